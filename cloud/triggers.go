@@ -1,6 +1,7 @@
 package cloud
 
 import (
+	"context"
 	"reflect"
 
 	"github.com/lfq7413/tomato/errs"
@@ -34,6 +35,7 @@ type TriggerRequest struct {
 	Master         bool
 	User           types.M
 	InstallationID string
+	Context        context.Context // 当前请求的 context ，请求取消或超时后 Done 被关闭
 }
 
 // FunctionRequest ...
@@ -44,6 +46,7 @@ type FunctionRequest struct {
 	InstallationID string
 	Headers        map[string]string
 	FunctionName   string
+	Context        context.Context // 当前请求的 context ，请求取消或超时后 Done 被关闭
 }
 
 // JobRequest ...
@@ -1,11 +1,15 @@
 package config
 
 import (
+	"encoding/base64"
+	"errors"
 	"time"
 
 	"log"
+	"net"
 
 	"regexp"
+	"strconv"
 
 	"strings"
 
@@ -16,7 +20,7 @@ import (
 type Config struct {
 	AppName              string // 应用名称，必填
 	ServerURL            string // 服务对外地址，必填
-	DatabaseType         string // 数据库类型，可选： MongoDB、PostgreSQL
+	DatabaseType         string // 数据库类型，可选： MongoDB、PostgreSQL、SQLite、Memory ； MongoDB 不支持事务， "transaction": true 的批量请求直接返回 OperationForbidden 错误，导入与迁移不使用事务执行
 	DatabaseURI          string // 数据库地址
 	DatabaseUserName     string // 数据库用戶名
 	DatabaseUserPassword string // 数据库密碼
@@ -54,7 +58,24 @@ type Config struct {
 	PushChannel                      string   // 推送通道
 	PushBatchSize                    int      // 批量推送的大小
 	ScheduledPush                    bool     // 是否有推送调度器
+	RequestTimeout                   int      // 请求超时时间，单位为毫秒，超时后中止正在执行的数据库操作，默认为 0 表示不限制
+	MaxQueryTime                     int      // 单次查询的最大执行时间，单位为毫秒，传递给 Mongo 的 maxTimeMS 与 Postgres 的 statement_timeout ，默认为 0 表示不限制
+	MaxLimit                         int      // 查询时 limit 的最大值，默认为 0 表示不限制
+	MaxIncludeDepth                  int      // 查询时 include 的最大层数，默认为 0 表示不限制
+	MaxSubQueries                    int      // 单个查询中 $inQuery 、 $notInQuery 、 $select 、 $dontSelect 展开的子查询的最大数量，默认为 0 表示不限制
+	BatchConcurrency                 int      // 批量请求中相邻的 GET 子请求并发执行的最大数量，为 1 时顺序执行，默认为 4
+	IdempotencyPaths                 []string // 支持通过 X-Parse-Request-Id 请求头保证幂等的 POST 接口，为 /v1/ 之后的路径前缀，多个路径使用 | 隔开，默认为 classes|functions ，请求 id 只在同一个用户、设备或 master 的请求之间生效，三者都没有时不保证幂等
+	IdempotencyTTL                   int      // 请求 id 与对应响应的保存时间，单位为秒，默认为 300
+	MigrationsDir                    string   // JSON 格式的迁移文件所在目录，启动时注册目录下所有 .json 文件，默认为空
+	AutoMigrate                      bool     // 启动时是否执行未执行的迁移，多个节点同时启动时只有一个节点执行，默认为 true
+	SoftDeleteRetentionDays          int      // 开启软删除的类中，已删除对象的默认保留天数，超过后由 purgeTrash 任务永久删除，默认为 30
+	ExpireSweepInterval              int      // 清理开启过期的类中已过期对象的间隔，单位为秒，为 0 时不清理，默认为 60
+	FieldEncryptionKeys              string   // 字段加密密钥，格式为 keyId:base64Key ，密钥长度为 16、24 或 32 字节，多个密钥使用 | 隔开，第一个用于加密，其余用于解密旧数据，如： k2:xxx|k1:yyy
+	RateLimits                       string   // 限流规则，格式为 path:methods:zone:max:window[:master] ，多个规则使用 | 隔开，如： login:POST:ip:5:60|functions/*:*:user:100:60
+	RateLimitAdapter                 string   // 限流计数的存储模块，可选： InMemory、Redis ，默认为 InMemory ，为 Redis 时使用 RedisAddress 在多个节点间共享计数
+	TrustedProxies                   string   // 可信的代理地址， ip 或 CIDR ，多个地址使用 | 隔开，直接连接的地址可信时才从 X-Forwarded-For 与 X-Real-Ip 中获取客户端 ip ，默认为空表示不信任任何代理
 	LiveQueryClasses                 string   // LiveQuery 支持的 classe ，多个 class 使用 | 隔开，如： classeA|classeB|classeC
+	LiveQueryChangeFeed              bool     // 是否通过捕获数据库变更发送 LiveQuery 通知，直接修改数据库的变更也会通知，仅支持 MongoDB 副本集与 PostgreSQL ，默认为 false
 	PublisherType                    string   // 发布者类型，可选：Redis ，默认使用自带的 EventEmitter
 	PublisherURL                     string   // 发布者地址， PublisherType=Redis 时必填
 	PublisherConfig                  string   // 发布者配置信息， PublisherType=Redis 时为 Redis 密码，选填
@@ -130,6 +151,7 @@ func parseConfig() {
 
 	// LiveQueryClasses 支持的类列表，格式： classeA|classeB|classeC
 	TConfig.LiveQueryClasses = beego.AppConfig.String("LiveQueryClasses")
+	TConfig.LiveQueryChangeFeed = beego.AppConfig.DefaultBool("LiveQueryChangeFeed", false)
 	TConfig.PublisherType = beego.AppConfig.String("PublisherType")
 	TConfig.PublisherURL = beego.AppConfig.String("PublisherURL")
 	TConfig.PublisherConfig = beego.AppConfig.String("PublisherConfig")
@@ -199,6 +221,29 @@ func parseConfig() {
 	TConfig.PushBatchSize = beego.AppConfig.DefaultInt("PushBatchSize", 0)
 	TConfig.ScheduledPush = beego.AppConfig.DefaultBool("ScheduledPush", false)
 
+	TConfig.RequestTimeout = beego.AppConfig.DefaultInt("RequestTimeout", 0)
+	TConfig.MaxQueryTime = beego.AppConfig.DefaultInt("MaxQueryTime", 0)
+	TConfig.MaxLimit = beego.AppConfig.DefaultInt("MaxLimit", 0)
+	TConfig.MaxIncludeDepth = beego.AppConfig.DefaultInt("MaxIncludeDepth", 0)
+	TConfig.MaxSubQueries = beego.AppConfig.DefaultInt("MaxSubQueries", 0)
+	TConfig.BatchConcurrency = beego.AppConfig.DefaultInt("BatchConcurrency", 4)
+	TConfig.IdempotencyPaths = []string{}
+	for _, path := range strings.Split(beego.AppConfig.DefaultString("IdempotencyPaths", "classes|functions"), "|") {
+		if path = strings.Trim(path, "/ "); path != "" {
+			TConfig.IdempotencyPaths = append(TConfig.IdempotencyPaths, path)
+		}
+	}
+	TConfig.IdempotencyTTL = beego.AppConfig.DefaultInt("IdempotencyTTL", 300)
+
+	TConfig.MigrationsDir = beego.AppConfig.String("MigrationsDir")
+	TConfig.AutoMigrate = beego.AppConfig.DefaultBool("AutoMigrate", true)
+	TConfig.SoftDeleteRetentionDays = beego.AppConfig.DefaultInt("SoftDeleteRetentionDays", 30)
+	TConfig.ExpireSweepInterval = beego.AppConfig.DefaultInt("ExpireSweepInterval", 60)
+	TConfig.FieldEncryptionKeys = beego.AppConfig.String("FieldEncryptionKeys")
+	TConfig.RateLimits = beego.AppConfig.String("RateLimits")
+	TConfig.RateLimitAdapter = beego.AppConfig.DefaultString("RateLimitAdapter", "InMemory")
+	TConfig.TrustedProxies = beego.AppConfig.String("TrustedProxies")
+
 	TConfig.FCMServerKey = beego.AppConfig.String("FCMServerKey")
 }
 
@@ -214,6 +259,8 @@ func Validate() {
 	validatePasswordPolicy()
 	validateCacheConfiguration()
 	validateAnalyticsConfiguration()
+	validateEncryptionConfiguration()
+	validateRateLimitConfiguration()
 }
 
 // validateApplicationConfiguration 校验应用相关参数
@@ -233,6 +280,27 @@ func validateApplicationConfiguration() {
 	if TConfig.ClientKey == "" && TConfig.JavaScriptKey == "" && TConfig.DotNetKey == "" && TConfig.RestAPIKey == "" {
 		log.Fatalln("ClientKey or JavaScriptKey or DotNetKey or RestAPIKey is required")
 	}
+	if TConfig.RequestTimeout < 0 {
+		log.Fatalln("RequestTimeout must be a value greater than or equal to 0")
+	}
+	if TConfig.MaxQueryTime < 0 {
+		log.Fatalln("MaxQueryTime must be a value greater than or equal to 0")
+	}
+	if TConfig.MaxLimit < 0 {
+		log.Fatalln("MaxLimit must be a value greater than or equal to 0")
+	}
+	if TConfig.MaxIncludeDepth < 0 {
+		log.Fatalln("MaxIncludeDepth must be a value greater than or equal to 0")
+	}
+	if TConfig.MaxSubQueries < 0 {
+		log.Fatalln("MaxSubQueries must be a value greater than or equal to 0")
+	}
+	if TConfig.BatchConcurrency < 1 {
+		log.Fatalln("BatchConcurrency must be a value greater than or equal to 1")
+	}
+	if TConfig.IdempotencyTTL < 1 {
+		log.Fatalln("IdempotencyTTL must be a value greater than 0")
+	}
 }
 
 // validateFileConfiguration 校验文件存储相关参数
@@ -303,6 +371,13 @@ func validateLiveQueryConfiguration() {
 	default:
 		log.Fatalln("Unsupported LiveQuery PublisherType")
 	}
+	if TConfig.LiveQueryChangeFeed {
+		switch TConfig.DatabaseType {
+		case "", "MongoDB", "PostgreSQL":
+		default:
+			log.Fatalln("LiveQueryChangeFeed is only supported by MongoDB and PostgreSQL")
+		}
+	}
 }
 
 // validateSessionConfiguration 校验 Session 有效期
@@ -364,6 +439,32 @@ func validateCacheConfiguration() {
 	}
 }
 
+// validateEncryptionConfiguration 校验字段加密密钥
+func validateEncryptionConfiguration() {
+	if _, err := ParseFieldEncryptionKeys(TConfig.FieldEncryptionKeys); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// validateRateLimitConfiguration 校验限流相关参数
+func validateRateLimitConfiguration() {
+	if _, err := ParseRateLimits(TConfig.RateLimits); err != nil {
+		log.Fatalln(err)
+	}
+	switch TConfig.RateLimitAdapter {
+	case "", "InMemory":
+	case "Redis":
+		if TConfig.RedisAddress == "" {
+			log.Fatalln("RedisAddress is required")
+		}
+	default:
+		log.Fatalln("Unsupported RateLimitAdapter")
+	}
+	if _, err := ParseTrustedProxies(TConfig.TrustedProxies); err != nil {
+		log.Fatalln(err)
+	}
+}
+
 // validateAnalyticsConfiguration 校验分析模块相关参数
 func validateAnalyticsConfiguration() {
 	adapter := TConfig.AnalyticsAdapter
@@ -485,3 +586,142 @@ func ParseFrameURL() string {
 func VerifyEmailURL() string {
 	return TConfig.ServerURL + `/apps/verify_email`
 }
+
+// EncryptionKey 字段加密密钥， ID 保存在密文中，用于轮换密钥后解密旧数据
+type EncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+// ParseFieldEncryptionKeys 解析 FieldEncryptionKeys 格式的密钥列表，第一个为当前用于加密的密钥
+func ParseFieldEncryptionKeys(spec string) ([]EncryptionKey, error) {
+	keys := []EncryptionKey{}
+	if spec == "" {
+		return keys, nil
+	}
+	ids := map[string]bool{}
+	for _, item := range strings.Split(spec, "|") {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("FieldEncryptionKeys should be in the format keyId:base64Key")
+		}
+		if ids[parts[0]] {
+			return nil, errors.New("Duplicate field encryption key id: " + parts[0])
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, errors.New("Field encryption key " + parts[0] + " is not valid base64")
+		}
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			return nil, errors.New("Field encryption key " + parts[0] + " should be 16, 24 or 32 bytes")
+		}
+		ids[parts[0]] = true
+		keys = append(keys, EncryptionKey{ID: parts[0], Key: key})
+	}
+	return keys, nil
+}
+
+// RateLimit 限流规则，在 Window 秒内同一个 Zone 最多允许 Max 个匹配的请求
+// Path 为 /v1/ 之后的路径， * 匹配任意字符
+// Methods 为空时匹配所有请求方法
+// Zone 可选 ip、user、installation ，无法获取用户或设备时按 ip 计数
+// IncludeMaster 为 true 时使用 MasterKey 的请求也会被限制
+type RateLimit struct {
+	Path          string
+	Methods       []string
+	Zone          string
+	Max           int
+	Window        int
+	IncludeMaster bool
+	pattern       *regexp.Regexp
+}
+
+// Match 判断请求是否匹配规则， path 为 /v1/ 之后的路径
+func (r RateLimit) Match(method, path string) bool {
+	if len(r.Methods) > 0 {
+		matched := false
+		for _, m := range r.Methods {
+			if m == method {
+				matched = true
+				break
+			}
+		}
+		if matched == false {
+			return false
+		}
+	}
+	return r.pattern.MatchString(strings.Trim(path, "/"))
+}
+
+// ParseRateLimits 解析 RateLimits 格式的限流规则
+func ParseRateLimits(spec string) ([]RateLimit, error) {
+	limits := []RateLimit{}
+	if spec == "" {
+		return limits, nil
+	}
+	for _, item := range strings.Split(spec, "|") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 5 && len(parts) != 6 {
+			return nil, errors.New("RateLimits should be in the format path:methods:zone:max:window[:master]")
+		}
+		limit := RateLimit{Path: strings.Trim(parts[0], "/ "), Methods: []string{}, Zone: parts[2]}
+		if limit.Path == "" {
+			return nil, errors.New("Rate limit path is required")
+		}
+		limit.pattern = regexp.MustCompile("^" + strings.Replace(regexp.QuoteMeta(limit.Path), `\*`, ".*", -1) + "$")
+		if parts[1] != "*" {
+			for _, m := range strings.Split(parts[1], ",") {
+				limit.Methods = append(limit.Methods, strings.ToUpper(strings.TrimSpace(m)))
+			}
+		}
+		switch limit.Zone {
+		case "ip", "user", "installation":
+		default:
+			return nil, errors.New("Rate limit zone should be ip, user or installation: " + limit.Zone)
+		}
+		var err error
+		if limit.Max, err = strconv.Atoi(parts[3]); err != nil || limit.Max < 1 {
+			return nil, errors.New("Rate limit max should be an integer greater than 0: " + parts[3])
+		}
+		if limit.Window, err = strconv.Atoi(parts[4]); err != nil || limit.Window < 1 {
+			return nil, errors.New("Rate limit window should be an integer greater than 0: " + parts[4])
+		}
+		if len(parts) == 6 {
+			if parts[5] != "master" {
+				return nil, errors.New("Rate limit option should be master: " + parts[5])
+			}
+			limit.IncludeMaster = true
+		}
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
+
+// ParseTrustedProxies 解析 TrustedProxies 格式的代理地址，单个 ip 转换为只包含该 ip 的网段
+func ParseTrustedProxies(spec string) ([]*net.IPNet, error) {
+	proxies := []*net.IPNet{}
+	if spec == "" {
+		return proxies, nil
+	}
+	for _, item := range strings.Split(spec, "|") {
+		item = strings.TrimSpace(item)
+		if strings.Contains(item, "/") == false {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, errors.New("Trusted proxy should be an ip or CIDR: " + item)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, errors.New("Trusted proxy should be an ip or CIDR: " + item)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
@@ -75,7 +75,7 @@ func (i *IAPValidationController) HandlePost() {
 }
 
 func (i *IAPValidationController) getFileForProductIdentifier(productIdentifier string) {
-	r, err := rest.Find(i.Auth, "_Product", types.M{"productIdentifier": productIdentifier}, types.M{}, i.Info.ClientSDK)
+	r, err := rest.FindContext(i.Context, i.Auth, "_Product", types.M{"productIdentifier": productIdentifier}, types.M{}, i.Info.ClientSDK)
 	if err != nil {
 		i.HandleError(err, 0)
 		return
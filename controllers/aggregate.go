@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/rest"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// AggregateController 处理 /aggregate 接口的请求
+type AggregateController struct {
+	ClassesController
+}
+
+// HandleAggregate 处理聚合请求，需要 Master 权限或者类的 find 权限
+// 参数 pipeline 为聚合管道，参数 distinct 为需要返回不同取值的字段，二者只能使用其一
+// 参数 where 为查询条件，仅处理符合条件的对象
+// @router /:className [get]
+func (a *AggregateController) HandleAggregate() {
+	if a.ClassName == "" {
+		a.ClassName = a.Ctx.Input.Param(":className")
+	}
+
+	allowConstraints := map[string]bool{
+		"pipeline": true,
+		"distinct": true,
+		"where":    true,
+	}
+	for k := range a.Query {
+		if allowConstraints[k] == false {
+			a.HandleError(errs.E(errs.InvalidQuery, "Invalid parameter for query: "+k), 0)
+			return
+		}
+	}
+	for k := range a.JSONBody {
+		if allowConstraints[k] == false {
+			a.HandleError(errs.E(errs.InvalidQuery, "Invalid parameter for query: "+k), 0)
+			return
+		}
+	}
+
+	options := types.M{}
+	if a.Query["pipeline"] != "" {
+		var pipeline types.S
+		err := json.Unmarshal([]byte(a.Query["pipeline"]), &pipeline)
+		if err != nil {
+			a.HandleError(errs.E(errs.InvalidJSON, "pipeline should be valid json"), 0)
+			return
+		}
+		options["pipeline"] = pipeline
+	} else if a.JSONBody != nil && a.JSONBody["pipeline"] != nil {
+		options["pipeline"] = a.JSONBody["pipeline"]
+	}
+
+	if a.Query["distinct"] != "" {
+		options["distinct"] = a.Query["distinct"]
+	} else if a.JSONBody != nil && a.JSONBody["distinct"] != nil {
+		if distinct, ok := a.JSONBody["distinct"].(string); ok {
+			options["distinct"] = distinct
+		}
+	}
+
+	if options["pipeline"] == nil && options["distinct"] == nil {
+		a.HandleError(errs.E(errs.InvalidQuery, "pipeline or distinct is required."), 0)
+		return
+	}
+	if options["pipeline"] != nil && options["distinct"] != nil {
+		a.HandleError(errs.E(errs.InvalidQuery, "pipeline and distinct cannot be used together."), 0)
+		return
+	}
+
+	where := types.M{}
+	if a.Query["where"] != "" {
+		err := json.Unmarshal([]byte(a.Query["where"]), &where)
+		if err != nil {
+			a.HandleError(errs.E(errs.InvalidJSON, "where should be valid json"), 0)
+			return
+		}
+	} else if a.JSONBody != nil && a.JSONBody["where"] != nil {
+		where = utils.M(a.JSONBody["where"])
+	}
+
+	response, err := rest.FindContext(a.Context, a.Auth, a.ClassName, where, options, a.Info.ClientSDK)
+	if err != nil {
+		a.HandleError(err, 0)
+		return
+	}
+	a.Data["json"] = types.M{"results": response["results"]}
+	a.ServeJSON()
+}
+
+// Get ...
+// @router / [get]
+func (a *AggregateController) Get() {
+	a.ClassesController.Get()
+}
+
+// Post ...
+// @router / [post]
+func (a *AggregateController) Post() {
+	a.ClassesController.Post()
+}
+
+// Put ...
+// @router / [put]
+func (a *AggregateController) Put() {
+	a.ClassesController.Put()
+}
+
+// Delete ...
+// @router / [delete]
+func (a *AggregateController) Delete() {
+	a.ClassesController.Delete()
+}
@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"strings"
+	"time"
 
 	"github.com/astaxie/beego"
 	"github.com/lfq7413/tomato/client"
@@ -19,6 +21,7 @@ import (
 // Auth 当前请求的用户权限
 // JSONBody 由 JSON 格式转换来的请求数据
 // RawBody 原始请求数据
+// Context 当前请求的 context ，客户端断开连接或者请求超时后取消
 type BaseController struct {
 	beego.Controller
 	Info     *RequestInfo
@@ -26,6 +29,10 @@ type BaseController struct {
 	Query    map[string]string
 	JSONBody types.M
 	RawBody  []byte
+	Context  context.Context
+	cancel   context.CancelFunc
+	// requestID 需要保存响应的请求 id
+	requestID string
 }
 
 // RequestInfo http 请求的权限信息
@@ -49,25 +56,37 @@ type RequestInfo struct {
 // 4. 校验请求权限
 // 5. 生成用户信息
 func (b *BaseController) Prepare() {
-	info := &RequestInfo{}
-	info.AppID = b.Ctx.Input.Header("X-Parse-Application-Id")
-	info.MasterKey = b.Ctx.Input.Header("X-Parse-Master-Key")
-	info.ClientKey = b.Ctx.Input.Header("X-Parse-Client-Key")
-	info.JavaScriptKey = b.Ctx.Input.Header("X-Parse-Javascript-Key")
-	info.DotNetKey = b.Ctx.Input.Header("X-Parse-Windows-Key")
-	info.RestAPIKey = b.Ctx.Input.Header("X-Parse-REST-API-Key")
-	info.SessionToken = b.Ctx.Input.Header("X-Parse-Session-Token")
-	info.InstallationID = b.Ctx.Input.Header("X-Parse-Installation-Id")
-	info.ClientVersion = b.Ctx.Input.Header("X-Parse-Client-Version")
-
-	basicAuth := httpAuth(b.Ctx.Input.Header("Authorization"))
-	if basicAuth != nil {
-		info.AppID = basicAuth["appId"]
-		if basicAuth["masterKey"] != "" {
-			info.MasterKey = basicAuth["masterKey"]
-		}
-		if basicAuth["javascriptKey"] != "" {
-			info.ClientKey = basicAuth["javascriptKey"]
+	b.Context, b.cancel = requestContext(b.Ctx.Request.Context())
+	// 权限校验通过后处理请求 id
+	defer b.enforceIdempotency()
+
+	// 批量请求中的子请求沿用批量请求的权限信息
+	parent, _ := b.Ctx.Request.Context().Value(batchParentKey{}).(*batchParent)
+
+	var info *RequestInfo
+	if parent != nil {
+		info = parent.info
+	} else {
+		info = &RequestInfo{}
+		info.AppID = b.Ctx.Input.Header("X-Parse-Application-Id")
+		info.MasterKey = b.Ctx.Input.Header("X-Parse-Master-Key")
+		info.ClientKey = b.Ctx.Input.Header("X-Parse-Client-Key")
+		info.JavaScriptKey = b.Ctx.Input.Header("X-Parse-Javascript-Key")
+		info.DotNetKey = b.Ctx.Input.Header("X-Parse-Windows-Key")
+		info.RestAPIKey = b.Ctx.Input.Header("X-Parse-REST-API-Key")
+		info.SessionToken = b.Ctx.Input.Header("X-Parse-Session-Token")
+		info.InstallationID = b.Ctx.Input.Header("X-Parse-Installation-Id")
+		info.ClientVersion = b.Ctx.Input.Header("X-Parse-Client-Version")
+
+		basicAuth := httpAuth(b.Ctx.Input.Header("Authorization"))
+		if basicAuth != nil {
+			info.AppID = basicAuth["appId"]
+			if basicAuth["masterKey"] != "" {
+				info.MasterKey = basicAuth["masterKey"]
+			}
+			if basicAuth["javascriptKey"] != "" {
+				info.ClientKey = basicAuth["javascriptKey"]
+			}
 		}
 	}
 
@@ -160,6 +179,10 @@ func (b *BaseController) Prepare() {
 	}
 
 	b.Info = info
+	if parent != nil {
+		b.Auth = parent.auth
+		return
+	}
 
 	// 校验请求权限
 	if info.AppID != config.TConfig.AppID {
@@ -251,6 +274,90 @@ func decodeBase64(str string) string {
 	return string(data)
 }
 
+// Finish 请求处理完成后释放 context ，请求 id 对应的响应没有保存时删除请求 id
+func (b *BaseController) Finish() {
+	if b.requestID != "" {
+		rest.ReleaseRequestID(context.Background(), b.requestID)
+		b.requestID = ""
+	}
+	if b.cancel != nil {
+		b.cancel()
+	}
+}
+
+// enforceIdempotency 请求带有 X-Parse-Request-Id 并且接口需要保证幂等时，记录请求 id
+// 请求 id 已经存在时，直接返回保存的响应
+func (b *BaseController) enforceIdempotency() {
+	if b.Auth == nil || b.Ctx.ResponseWriter.Started {
+		return
+	}
+	reqID := rest.IdempotencyKey(b.Auth, b.Ctx.Input.Header("X-Parse-Request-Id"))
+	if reqID == "" || idempotencyCovered(b.Ctx.Input.Method(), b.Ctx.Input.URL()) == false {
+		return
+	}
+	stored, err := rest.ReserveRequestID(b.Context, reqID)
+	if err != nil {
+		b.HandleError(err, 0)
+		return
+	}
+	if stored != nil {
+		if stored.Location != "" {
+			b.Ctx.Output.Header("Location", stored.Location)
+		}
+		if stored.Status != 0 {
+			b.Ctx.Output.SetStatus(stored.Status)
+		}
+		b.Data["json"] = stored.Response
+		b.ServeJSON()
+		return
+	}
+	b.requestID = reqID
+}
+
+// idempotencyCovered 判断请求是否需要保证幂等，仅处理 IdempotencyPaths 中的 POST 请求
+func idempotencyCovered(method, path string) bool {
+	if method != "POST" || strings.HasPrefix(path, "/v1/") == false {
+		return false
+	}
+	path = path[len("/v1/"):]
+	for _, prefix := range config.TConfig.IdempotencyPaths {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeJSON 返回 JSON 格式的数据，请求需要保证幂等时，执行成功则保存响应，执行失败则删除请求 id 以便重试
+func (b *BaseController) ServeJSON(encoding ...bool) {
+	if b.requestID != "" {
+		reqID := b.requestID
+		b.requestID = ""
+		status := b.Ctx.Output.Status
+		if status == 0 {
+			status = 200
+		}
+		// 请求超时或者客户端断开连接时也需要保存，不使用请求的 context
+		if status < 400 {
+			err := rest.SaveRequestResponse(context.Background(), reqID, status, b.Data["json"], b.Ctx.ResponseWriter.Header().Get("Location"))
+			if err != nil {
+				rest.ReleaseRequestID(context.Background(), reqID)
+			}
+		} else {
+			rest.ReleaseRequestID(context.Background(), reqID)
+		}
+	}
+	b.Controller.ServeJSON(encoding...)
+}
+
+// requestContext 根据 RequestTimeout 为请求设置超时时间
+func requestContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if config.TConfig.RequestTimeout > 0 {
+		return context.WithTimeout(parent, time.Duration(config.TConfig.RequestTimeout)*time.Millisecond)
+	}
+	return context.WithCancel(parent)
+}
+
 // HandleError 返回错误信息，不指定 status 参数时，默认为 0
 func (b *BaseController) HandleError(err error, status int) {
 	code := errs.GetErrorCode(err)
@@ -261,6 +368,10 @@ func (b *BaseController) HandleError(err error, status int) {
 			httpStatus = 500
 		case errs.ObjectNotFound:
 			httpStatus = 404
+		case errs.PreconditionFailed:
+			httpStatus = 412
+		case errs.RequestLimitExceeded:
+			httpStatus = 429
 		default:
 			httpStatus = 400
 		}
@@ -2,15 +2,21 @@ package controllers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/astaxie/beego"
+	"github.com/lfq7413/tomato/config"
 	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/ratelimit"
+	"github.com/lfq7413/tomato/rest"
 	"github.com/lfq7413/tomato/types"
 	"github.com/lfq7413/tomato/utils"
 )
@@ -64,15 +70,200 @@ func (b *BatchController) HandleBatch() {
 		headers["Authorization"] = b.Ctx.Input.Header("Authorization")
 	}
 
-	b.HandleRequest(requests, headers, b.Ctx.Input.Scheme())
+	if transaction, ok := b.JSONBody["transaction"].(bool); ok && transaction {
+		// 数据库不支持事务时直接拒绝，不执行其中的任何请求
+		if rest.TransactionsSupported() == false {
+			b.HandleError(rest.ErrTransactionsNotSupported, 0)
+			return
+		}
+		b.HandleTransaction(requests)
+		return
+	}
+
+	b.HandleRequest(requests, headers)
 }
 
-// HandleRequest ...
-func (b *BatchController) HandleRequest(requests types.S, headers map[string]string, scheme string) {
+// HandleTransaction 在同一个事务中执行所有请求，全部成功时提交，任意一个失败时全部回滚
+// 事务中仅支持对 classes users roles installations 的增删改查，数据库不支持事务时返回 rest.ErrTransactionsNotSupported
+func (b *BatchController) HandleTransaction(requests types.S) {
+	type batchRequest struct {
+		method    string
+		className string
+		objectID  string
+		body      types.M
+	}
+	batch := []batchRequest{}
+	for _, v := range requests {
+		request := utils.M(v)
+		if request == nil {
+			b.HandleError(errs.E(errs.InvalidJSON, "Invalid request"), 0)
+			return
+		}
+		method := utils.S(request["method"])
+		if method == "" {
+			b.HandleError(errs.E(errs.InvalidJSON, "Invalid method"), 0)
+			return
+		}
+		className, objectID, ok := parseBatchPath(utils.S(request["path"]))
+		if ok == false {
+			b.HandleError(errs.E(errs.InvalidJSON, "Invalid path"), 0)
+			return
+		}
+		r := batchRequest{method: method, className: className, objectID: objectID, body: utils.M(request["body"])}
+		switch {
+		case method == "POST" && objectID == "":
+		case (method == "PUT" || method == "DELETE" || method == "GET") && objectID != "":
+		default:
+			b.HandleError(errs.E(errs.InvalidJSON, "Unsupported request in transaction: "+method+" "+utils.S(request["path"])), 0)
+			return
+		}
+		if (method == "POST" || method == "PUT") && r.body == nil {
+			b.HandleError(errs.E(errs.InvalidJSON, "request body is empty"), 0)
+			return
+		}
+		batch = append(batch, r)
+	}
+	// 事务中的请求不经过路由，在执行前计数
+	for _, v := range requests {
+		path, _ := batchPath(utils.S(utils.M(v)["path"]))
+		if _, err := ratelimit.Check(b.Ctx, utils.S(utils.M(v)["method"]), path); err != nil {
+			b.HandleError(err, 0)
+			return
+		}
+	}
+
+	tx, err := rest.BeginTransactionContext(b.Context)
+	if err != nil {
+		b.HandleError(err, 0)
+		return
+	}
+	results := types.S{}
+	for _, r := range batch {
+		var result types.M
+		switch r.method {
+		case "POST":
+			result, err = tx.Create(b.Auth, r.className, r.body, b.Info.ClientSDK)
+			if err == nil {
+				result = utils.M(result["response"])
+			}
+		case "PUT":
+			result, err = tx.Update(b.Auth, r.className, r.objectID, r.body, b.Info.ClientSDK)
+			if err == nil {
+				result = utils.M(result["response"])
+			}
+		case "DELETE":
+			err = tx.Delete(b.Auth, r.className, r.objectID)
+			result = types.M{}
+		case "GET":
+			result, err = tx.Get(b.Auth, r.className, r.objectID, r.body, b.Info.ClientSDK)
+			if err == nil {
+				if utils.HasResults(result) == false {
+					err = errs.E(errs.ObjectNotFound, "Object not found.")
+				} else {
+					result = utils.M(utils.A(result["results"])[0])
+				}
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			b.HandleError(err, 0)
+			return
+		}
+		results = append(results, types.M{"success": result})
+	}
+	if err := tx.Commit(); err != nil {
+		b.HandleError(err, 0)
+		return
+	}
+	b.Data["json"] = results
+	b.ServeJSON()
+}
+
+// batchPath 将批量请求中的路径转换为路由中的路径
+// 支持完整地址、以 /v1/ 开头的路径，以及带有 ServerURL 中挂载路径的路径，如 /parse/v1/classes/post
+func batchPath(path string) (string, bool) {
+	for _, prefix := range []string{"http://", "https://"} {
+		if strings.HasPrefix(path, prefix) {
+			path = path[len(prefix):]
+			p := strings.Index(path, "/")
+			if p == -1 {
+				return "", false
+			}
+			path = path[p:]
+			break
+		}
+	}
+	if strings.HasPrefix(path, "/") == false {
+		return "", false
+	}
+	if mountPath := batchMountPath(); mountPath != "" && strings.HasPrefix(path, mountPath+"/v1/") {
+		path = path[len(mountPath):]
+	}
+	return path, true
+}
+
+// batchMountPath 返回 ServerURL 中 /v1 之前的挂载路径，如 http://example.com/parse/v1 对应 /parse
+func batchMountPath() string {
+	u, err := url.Parse(config.TConfig.ServerURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/v1")
+}
+
+// parseBatchPath 解析批量请求中的路径，返回对应的类名与对象 id
+func parseBatchPath(path string) (string, string, bool) {
+	path, ok := batchPath(path)
+	if ok == false {
+		return "", "", false
+	}
+	if p := strings.Index(path, "?"); p != -1 {
+		path = path[:p]
+	}
+	if strings.HasPrefix(path, "/v1/") == false {
+		return "", "", false
+	}
+	parts := strings.Split(strings.Trim(path[len("/v1/"):], "/"), "/")
+	var className string
+	switch parts[0] {
+	case "classes":
+		if len(parts) < 2 || parts[1] == "" {
+			return "", "", false
+		}
+		className = parts[1]
+		parts = parts[2:]
+	case "users":
+		className = "_User"
+		parts = parts[1:]
+	case "roles":
+		className = "_Role"
+		parts = parts[1:]
+	case "installations":
+		className = "_Installation"
+		parts = parts[1:]
+	default:
+		return "", "", false
+	}
+	switch len(parts) {
+	case 0:
+		return className, "", true
+	case 1:
+		return className, parts[0], true
+	}
+	return "", "", false
+}
+
+// HandleRequest 在当前进程中依次执行批量请求中的子请求，子请求沿用当前请求的权限信息
+// 相邻的在同一个类中创建对象的请求合并为一次批量创建，相邻的 GET 请求并发执行
+// 批量请求带有 X-Parse-Request-Id 时，需要保证幂等的子请求使用 请求id:序号 作为各自的请求 id
+func (b *BatchController) HandleRequest(requests types.S, headers map[string]string) {
 	methods := []string{}
 	paths := []string{}
 	bodys := []interface{}{}
+	createClassNames := []string{}
+	reqIDs := []string{}
 	results := types.S{}
+	parentReqID := b.Ctx.Input.Header("X-Parse-Request-Id")
 
 	for _, v := range requests {
 		request := utils.M(v)
@@ -88,43 +279,172 @@ func (b *BatchController) HandleRequest(requests types.S, headers map[string]str
 		}
 		methods = append(methods, method)
 
-		path := utils.S(request["path"])
-		if path == "" {
-			b.HandleError(errs.E(errs.InvalidJSON, "Invalid path"), 0)
-			return
-		}
-		if strings.HasPrefix(path, "/") {
-			path = scheme + "://127.0.0.1:" + beego.AppConfig.String("httpport") + path
-		} else if strings.HasPrefix(path, scheme+"://") {
-			path = path[len(scheme+"://"):]
-			p := strings.Index(path, "/")
-			if p == -1 {
-				b.HandleError(errs.E(errs.InvalidJSON, "Invalid path"), 0)
-				return
-			}
-			path = path[p:]
-			path = scheme + "://127.0.0.1:" + beego.AppConfig.String("httpport") + path
-		} else {
+		path, ok := batchPath(utils.S(request["path"]))
+		if ok == false {
 			b.HandleError(errs.E(errs.InvalidJSON, "Invalid path"), 0)
 			return
 		}
 		paths = append(paths, path)
 
 		bodys = append(bodys, request["body"])
+		createClassNames = append(createClassNames, batchCreateClassName(method, path, utils.M(request["body"])))
+		reqID := ""
+		if parentReqID != "" && idempotencyCovered(method, path) {
+			reqID = parentReqID + ":" + strconv.Itoa(len(reqIDs))
+		}
+		reqIDs = append(reqIDs, reqID)
 	}
-	for i := 0; i < len(requests); i++ {
-		r := request(methods[i], paths[i], headers, bodys[i])
-		results = append(results, r)
+	for i := 0; i < len(requests); {
+		end := i + 1
+		if createClassNames[i] != "" {
+			for end < len(requests) && createClassNames[end] == createClassNames[i] {
+				end++
+			}
+		} else if methods[i] == "GET" {
+			for end < len(requests) && methods[end] == "GET" {
+				end++
+			}
+		}
+		switch {
+		case end-i > 1 && createClassNames[i] != "":
+			results = append(results, b.createObjects(createClassNames[i], paths[i:end], bodys[i:end], reqIDs[i:end])...)
+		case end-i > 1:
+			// GET 请求之间互不影响，并发执行，结果按请求顺序返回
+			r := make([]types.M, end-i)
+			// 未经过配置校验时（如直接设置 TConfig ）取值可能小于 1 ，至少允许一个请求执行
+			concurrency := config.TConfig.BatchConcurrency
+			if concurrency < 1 {
+				concurrency = 1
+			}
+			sem := make(chan struct{}, concurrency)
+			var wg sync.WaitGroup
+			for j := i; j < end; j++ {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(j int) {
+					defer func() {
+						<-sem
+						wg.Done()
+					}()
+					r[j-i] = b.dispatch(methods[j], paths[j], headers, bodys[j])
+				}(j)
+			}
+			wg.Wait()
+			for _, v := range r {
+				results = append(results, v)
+			}
+		default:
+			h := headers
+			if reqIDs[i] != "" {
+				h = map[string]string{"X-Parse-Request-Id": reqIDs[i]}
+				for k, v := range headers {
+					h[k] = v
+				}
+			}
+			results = append(results, b.dispatch(methods[i], paths[i], h, bodys[i]))
+		}
+		i = end
 	}
 	b.Data["json"] = results
 	b.ServeJSON()
 }
 
-func request(method, path string, headers map[string]string, body interface{}) types.M {
+// createObjects 合并执行在 className 中创建对象的子请求，返回与子请求一一对应的结果
+// 合并的请求不经过路由，在创建前逐个计数与记录请求 id ，超过限制的请求不创建，请求 id 已经存在时返回保存的响应
+func (b *BatchController) createObjects(className string, paths []string, bodys []interface{}, reqIDs []string) types.S {
+	results := make(types.S, len(paths))
+	objects := []types.M{}
+	indexes := []int{}
+	keys := make([]string, len(reqIDs))
+	for j, reqID := range reqIDs {
+		keys[j] = rest.IdempotencyKey(b.Auth, reqID)
+	}
+	for j := range paths {
+		if _, err := ratelimit.Check(b.Ctx, "POST", paths[j]); err != nil {
+			results[j] = types.M{"error": errs.ErrorToMap(err)}
+			continue
+		}
+		if keys[j] != "" {
+			stored, err := rest.ReserveRequestID(b.Context, keys[j])
+			if err != nil {
+				results[j] = types.M{"error": errs.ErrorToMap(err)}
+				continue
+			}
+			if stored != nil {
+				results[j] = types.M{"success": stored.Response}
+				continue
+			}
+		}
+		objects = append(objects, utils.M(bodys[j]))
+		indexes = append(indexes, j)
+	}
+	if len(objects) == 0 {
+		return results
+	}
+
+	created, errList := rest.CreateObjectsContext(b.Context, b.Auth, className, objects, b.Info.ClientSDK)
+	for k, j := range indexes {
+		if errList[k] != nil {
+			results[j] = types.M{"error": errs.ErrorToMap(errList[k])}
+			if keys[j] != "" {
+				rest.ReleaseRequestID(context.Background(), keys[j])
+			}
+			continue
+		}
+		results[j] = types.M{"success": created[k]["response"]}
+		if keys[j] != "" {
+			// 与 ServeJSON 相同，不使用请求的 context
+			err := rest.SaveRequestResponse(context.Background(), keys[j], 201, created[k]["response"], utils.S(created[k]["location"]))
+			if err != nil {
+				rest.ReleaseRequestID(context.Background(), keys[j])
+			}
+		}
+	}
+	return results
+}
+
+// batchCreateClassName 请求为在 /classes 下的非系统类中创建对象时，返回类名，否则返回空
+func batchCreateClassName(method, path string, body types.M) string {
+	if method != "POST" || body == nil {
+		return ""
+	}
+	className, objectID, ok := parseBatchPath(path)
+	if ok == false || objectID != "" || strings.HasPrefix(className, "_") {
+		return ""
+	}
+	return className
+}
+
+// batchParentKey 子请求的 context 中保存父请求权限信息的 key
+type batchParentKey struct{}
+
+// batchParent 批量请求的权限信息，子请求中直接沿用，不再重新校验
+type batchParent struct {
+	info *RequestInfo
+	auth *rest.Auth
+}
+
+// batchResponseWriter 保存子请求的响应数据
+type batchResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
+
+func (w *batchResponseWriter) WriteHeader(statusCode int) {}
+
+// dispatch 通过路由在当前进程中执行子请求
+// 每个子请求使用权限信息的副本，避免并发执行时互相修改其中缓存的角色信息
+func (b *BatchController) dispatch(method, path string, headers map[string]string, body interface{}) types.M {
 	var requestBody io.Reader
-	if body == nil {
-		requestBody = nil
-	} else {
+	if body != nil {
 		jsonParams, err := json.Marshal(body)
 		if err != nil {
 			return types.M{"error": errs.ErrorMessageToMap(errs.InvalidJSON, "Invalid body")}
@@ -132,34 +452,35 @@ func request(method, path string, headers map[string]string, body interface{}) t
 		requestBody = bytes.NewBuffer(jsonParams)
 	}
 
-	request, err := http.NewRequest(method, path, requestBody)
+	info := *b.Info
+	auth := *b.Auth
+	ctx := context.WithValue(b.Context, batchParentKey{}, &batchParent{info: &info, auth: &auth})
+	request, err := http.NewRequestWithContext(ctx, method, path, requestBody)
 	if err != nil {
 		return types.M{"error": errs.ErrorMessageToMap(errs.InvalidJSON, "Invalid request")}
 	}
-
-	if method == "POST" || method == "PUT" || method == "PATCH" {
+	request.RequestURI = path
+	request.Host = b.Ctx.Request.Host
+	request.RemoteAddr = b.Ctx.Request.RemoteAddr
+	for _, header := range []string{"X-Forwarded-For", "X-Real-Ip"} {
+		if value := b.Ctx.Input.Header(header); value != "" {
+			request.Header.Set(header, value)
+		}
+	}
+	if requestBody != nil && method != "GET" {
 		request.Header.Set("Content-Type", "application/json")
 	}
 	for header, value := range headers {
 		request.Header.Set(header, value)
 	}
 
-	client := http.DefaultClient
-	response, err := client.Do(request)
-	if err != nil {
-		return types.M{"error": errs.ErrorMessageToMap(errs.InvalidJSON, "Invalid request")}
-	}
-	defer response.Body.Close()
-
-	responseBody, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return types.M{"error": errs.ErrorMessageToMap(errs.InvalidJSON, "Invalid request")}
-	}
+	w := &batchResponseWriter{header: http.Header{}}
+	beego.BeeApp.Handlers.ServeHTTP(w, request)
 
 	var result types.M
-	err = json.Unmarshal(responseBody, &result)
+	err = json.Unmarshal(w.body.Bytes(), &result)
 	if err != nil {
-		return types.M{"error": types.M{"error": string(responseBody)}}
+		return types.M{"error": types.M{"error": w.body.String()}}
 	}
 
 	if result["error"] != nil {
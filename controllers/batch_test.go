@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	beegocontext "github.com/astaxie/beego/context"
+	"github.com/lfq7413/tomato/config"
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/ratelimit"
+	"github.com/lfq7413/tomato/rest"
+	"github.com/lfq7413/tomato/storage/memory"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+func Test_createObjects(t *testing.T) {
+	var b *BatchController
+	var results, expect types.S
+	var paths []string
+	var bodys []interface{}
+	var reqIDs []string
+	config.TConfig.ServerURL = "http://127.0.0.1/v1"
+	config.TConfig.IdempotencyTTL = 300
+	newController := func() *BatchController {
+		b := &BatchController{}
+		b.Ctx = beegocontext.NewContext()
+		req := httptest.NewRequest("POST", "/v1/batch", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		b.Ctx.Reset(httptest.NewRecorder(), req)
+		b.Context = context.Background()
+		b.Auth = rest.Master()
+		b.Info = &RequestInfo{}
+		return b
+	}
+	/********************************************************/
+	// 合并创建的请求使用各自的请求 id ，重试时返回保存的响应
+	orm.InitOrm(memory.NewMemoryAdapter("tomato"))
+	ratelimit.InitRateLimit()
+	b = newController()
+	paths = []string{"/v1/classes/post", "/v1/classes/post"}
+	bodys = []interface{}{types.M{"title": "a"}, types.M{"title": "b"}}
+	reqIDs = []string{"r1:0", "r1:1"}
+	results = b.createObjects("post", paths, bodys, reqIDs)
+	if len(results) != 2 || utils.M(utils.M(results[0])["success"])["objectId"] == nil || utils.M(utils.M(results[1])["success"])["objectId"] == nil {
+		t.Error("expect:", "success", "result:", results)
+	}
+	expect = results
+	results = newController().createObjects("post", paths, bodys, reqIDs)
+	if reflect.DeepEqual(utils.M(utils.M(expect[0])["success"])["objectId"], utils.M(utils.M(results[0])["success"])["objectId"]) == false ||
+		reflect.DeepEqual(utils.M(utils.M(expect[1])["success"])["objectId"], utils.M(utils.M(results[1])["success"])["objectId"]) == false {
+		t.Error("expect:", expect, "result:", results)
+	}
+	response, _ := rest.Find(rest.Master(), "post", types.M{}, types.M{}, nil)
+	if len(utils.A(response["results"])) != 2 {
+		t.Error("expect:", 2, "result:", response["results"])
+	}
+	/********************************************************/
+	// 没有请求 id 的请求仍然创建
+	results = newController().createObjects("post", paths, bodys, []string{"", ""})
+	response, _ = rest.Find(rest.Master(), "post", types.M{}, types.M{}, nil)
+	if len(results) != 2 || len(utils.A(response["results"])) != 4 {
+		t.Error("expect:", 4, "result:", response["results"])
+	}
+	orm.TomatoDBController.DeleteEverything()
+	/********************************************************/
+	// 合并创建的请求逐个计数，超过限制的请求不创建
+	config.TConfig.RateLimits = "classes/post:POST:ip:2:60"
+	ratelimit.InitRateLimit()
+	b = newController()
+	paths = []string{"/v1/classes/post", "/v1/classes/post", "/v1/classes/post"}
+	bodys = []interface{}{types.M{"title": "a"}, types.M{"title": "b"}, types.M{"title": "c"}}
+	reqIDs = []string{"r2:0", "r2:1", "r2:2"}
+	results = b.createObjects("post", paths, bodys, reqIDs)
+	if len(results) != 3 || utils.M(results[0])["success"] == nil || utils.M(results[1])["success"] == nil {
+		t.Error("expect:", "success", "result:", results)
+	}
+	if reflect.DeepEqual(types.M{"error": errs.ErrorToMap(ratelimit.ErrRequestLimitExceeded)}, results[2]) == false {
+		t.Error("expect:", ratelimit.ErrRequestLimitExceeded, "result:", results[2])
+	}
+	response, _ = rest.Find(rest.Master(), "post", types.M{}, types.M{}, nil)
+	if len(utils.A(response["results"])) != 2 {
+		t.Error("expect:", 2, "result:", response["results"])
+	}
+	// 超过限制的请求没有记录请求 id ，之后可以使用相同的 id 重试
+	stored, err := rest.ReserveRequestID(context.Background(), rest.IdempotencyKey(b.Auth, "r2:2"))
+	if err != nil || stored != nil {
+		t.Error("expect:", nil, "result:", stored, err)
+	}
+	config.TConfig.RateLimits = ""
+	ratelimit.InitRateLimit()
+	orm.TomatoDBController.DeleteEverything()
+}
+
+func Test_HandleRequest(t *testing.T) {
+	/********************************************************/
+	// 并发数量小于 1 时仍然执行相邻的 GET 请求
+	concurrency := config.TConfig.BatchConcurrency
+	config.TConfig.BatchConcurrency = 0
+	defer func() { config.TConfig.BatchConcurrency = concurrency }()
+	b := &BatchController{}
+	b.Ctx = beegocontext.NewContext()
+	b.Ctx.Reset(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/batch", nil))
+	b.Data = map[interface{}]interface{}{}
+	b.Context = context.Background()
+	b.Auth = rest.Master()
+	b.Info = &RequestInfo{}
+	requests := types.S{
+		types.M{"method": "GET", "path": "/v1/classes/post/01"},
+		types.M{"method": "GET", "path": "/v1/classes/post/02"},
+	}
+	done := make(chan struct{})
+	go func() {
+		b.HandleRequest(requests, map[string]string{})
+		close(done)
+	}()
+	select {
+	case <-done:
+		if results := utils.A(b.Data["json"]); len(results) != 2 {
+			t.Error("expect:", 2, "result:", b.Data["json"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expect:", "done", "result:", "blocked")
+	}
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"strconv"
@@ -32,7 +33,7 @@ func (c *ClassesController) HandleCreate() {
 		return
 	}
 
-	result, err := rest.Create(c.Auth, c.ClassName, c.JSONBody, c.Info.ClientSDK)
+	result, err := rest.CreateContext(c.Context, c.Auth, c.ClassName, c.JSONBody, c.Info.ClientSDK)
 	if err != nil {
 		c.HandleError(err, 0)
 		return
@@ -91,7 +92,7 @@ func (c *ClassesController) HandleGet() {
 		options["include"] = c.JSONBody["include"]
 	}
 
-	response, err := rest.Get(c.Auth, c.ClassName, c.ObjectID, options, c.Info.ClientSDK)
+	response, err := rest.GetContext(c.Context, c.Auth, c.ClassName, c.ObjectID, options, c.Info.ClientSDK)
 	if err != nil {
 		c.HandleError(err, 0)
 		return
@@ -113,6 +114,15 @@ func (c *ClassesController) HandleGet() {
 		}
 	}
 
+	if etag := rest.ETag(result); etag != "" {
+		c.Ctx.Output.Header("ETag", etag)
+		// 对象未修改时，返回 304
+		if ifNoneMatch := c.Ctx.Input.Header("If-None-Match"); ifNoneMatch != "" && rest.MatchETag(ifNoneMatch, etag) {
+			c.Ctx.Output.SetStatus(304)
+			return
+		}
+	}
+
 	c.Data["json"] = result
 	c.ServeJSON()
 }
@@ -131,16 +141,32 @@ func (c *ClassesController) HandleUpdate() {
 		return
 	}
 
-	result, err := rest.Update(c.Auth, c.ClassName, c.ObjectID, c.JSONBody, c.Info.ClientSDK)
+	result, err := rest.UpdateContext(c.ifMatchContext(), c.Auth, c.ClassName, c.ObjectID, c.JSONBody, c.Info.ClientSDK)
 	if err != nil {
 		c.HandleError(err, 0)
 		return
 	}
 
+	if etag := rest.ETag(utils.M(result["response"])); etag != "" {
+		c.Ctx.Output.Header("ETag", etag)
+	}
 	c.Data["json"] = result["response"]
 	c.ServeJSON()
 }
 
+// ifMatchContext 返回带有 If-Match 的 context ，SDK 可以通过请求数据中的 _If-Match 字段指定
+func (c *ClassesController) ifMatchContext() context.Context {
+	ifMatch := c.Ctx.Input.Header("If-Match")
+	if c.JSONBody != nil && c.JSONBody["_If-Match"] != nil {
+		ifMatch = utils.S(c.JSONBody["_If-Match"])
+		delete(c.JSONBody, "_If-Match")
+	}
+	if ifMatch == "" {
+		return c.Context
+	}
+	return rest.WithIfMatch(c.Context, ifMatch)
+}
+
 // HandleFind 处理查找对象请求
 // @router /:className [get]
 func (c *ClassesController) HandleFind() {
@@ -157,6 +183,9 @@ func (c *ClassesController) HandleFind() {
 		"include":                 true,
 		"redirectClassNameForKey": true,
 		"where":                   true,
+		"cursor":                  true,
+		"explain":                 true,
+		"maxTimeMS":               true,
 	}
 	for k := range c.Query {
 		if allowConstraints[k] == false {
@@ -229,6 +258,31 @@ func (c *ClassesController) HandleFind() {
 		options["redirectClassNameForKey"] = c.JSONBody["redirectClassNameForKey"]
 	}
 
+	if c.Query["cursor"] == "true" {
+		options["cursor"] = true
+	} else if c.Query["cursor"] != "" {
+		options["cursor"] = c.Query["cursor"]
+	} else if c.JSONBody != nil && c.JSONBody["cursor"] != nil {
+		options["cursor"] = c.JSONBody["cursor"]
+	}
+
+	if c.Query["explain"] == "true" {
+		options["explain"] = true
+	} else if c.JSONBody != nil && c.JSONBody["explain"] == true {
+		options["explain"] = true
+	}
+
+	// 仅 Master 权限可以设置，在 rest 中校验
+	if c.Query["maxTimeMS"] != "" {
+		if i, err := strconv.Atoi(c.Query["maxTimeMS"]); err == nil {
+			options["maxTimeMS"] = i
+		} else {
+			options["maxTimeMS"] = c.Query["maxTimeMS"]
+		}
+	} else if c.JSONBody != nil && c.JSONBody["maxTimeMS"] != nil {
+		options["maxTimeMS"] = c.JSONBody["maxTimeMS"]
+	}
+
 	where := types.M{}
 	if c.Query["where"] != "" {
 		err := json.Unmarshal([]byte(c.Query["where"]), &where)
@@ -240,7 +294,7 @@ func (c *ClassesController) HandleFind() {
 		where = utils.M(c.JSONBody["where"])
 	}
 
-	response, err := rest.Find(c.Auth, c.ClassName, where, options, c.Info.ClientSDK)
+	response, err := rest.FindContext(c.Context, c.Auth, c.ClassName, where, options, c.Info.ClientSDK)
 	if err != nil {
 		c.HandleError(err, 0)
 		return
@@ -269,7 +323,7 @@ func (c *ClassesController) HandleDelete() {
 		c.ObjectID = c.Ctx.Input.Param(":objectId")
 	}
 
-	err := rest.Delete(c.Auth, c.ClassName, c.ObjectID)
+	err := rest.DeleteContext(c.ifMatchContext(), c.Auth, c.ClassName, c.ObjectID)
 	if err != nil {
 		c.HandleError(err, 0)
 		return
@@ -279,6 +333,62 @@ func (c *ClassesController) HandleDelete() {
 	c.ServeJSON()
 }
 
+// HandleHistory 处理获取对象修改历史请求，支持 skip 、 limit 参数
+// @router /:className/:objectId/history [get]
+func (c *ClassesController) HandleHistory() {
+	if c.ClassName == "" {
+		c.ClassName = c.Ctx.Input.Param(":className")
+	}
+	if c.ObjectID == "" {
+		c.ObjectID = c.Ctx.Input.Param(":objectId")
+	}
+
+	options := types.M{"limit": 100}
+	if c.Query["skip"] != "" {
+		if i, err := strconv.Atoi(c.Query["skip"]); err == nil {
+			options["skip"] = i
+		}
+	}
+	if c.Query["limit"] != "" {
+		if i, err := strconv.Atoi(c.Query["limit"]); err == nil {
+			options["limit"] = i
+		}
+	}
+
+	response, err := rest.HistoryContext(c.Context, c.Auth, c.ClassName, c.ObjectID, options, c.Info.ClientSDK)
+	if err != nil {
+		c.HandleError(err, 0)
+		return
+	}
+
+	c.Data["json"] = response
+	c.ServeJSON()
+}
+
+// HandleRevert 处理把对象恢复为历史版本的请求，请求体为 {"historyId":"..."}
+// @router /:className/:objectId/revert [post]
+func (c *ClassesController) HandleRevert() {
+	if c.ClassName == "" {
+		c.ClassName = c.Ctx.Input.Param(":className")
+	}
+	if c.ObjectID == "" {
+		c.ObjectID = c.Ctx.Input.Param(":objectId")
+	}
+	if c.JSONBody == nil || utils.S(c.JSONBody["historyId"]) == "" {
+		c.HandleError(errs.E(errs.InvalidJSON, "historyId is required"), 0)
+		return
+	}
+
+	result, err := rest.RevertContext(c.Context, c.Auth, c.ClassName, c.ObjectID, utils.S(c.JSONBody["historyId"]), c.Info.ClientSDK)
+	if err != nil {
+		c.HandleError(err, 0)
+		return
+	}
+
+	c.Data["json"] = result["response"]
+	c.ServeJSON()
+}
+
 // Get ...
 // @router / [get]
 func (c *ClassesController) Get() {
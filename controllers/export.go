@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/types"
+)
+
+// ExportController 处理 /export 接口的请求，导出的数据为 NDJSON 格式，每行一个 JSON 对象
+type ExportController struct {
+	ClassesController
+}
+
+// Prepare 访问 /export 接口需要 master key
+func (e *ExportController) Prepare() {
+	e.ClassesController.Prepare()
+	if e.Ctx.ResponseWriter.Started == false {
+		e.EnforceMasterKeyAccess()
+	}
+}
+
+// HandleExport 导出类的 schema 、所有对象以及 _Join 表，可通过 /import 接口导入
+// @router /:className [get]
+func (e *ExportController) HandleExport() {
+	className := e.Ctx.Input.Param(":className")
+	w := e.Ctx.ResponseWriter
+	err := orm.TomatoDBController.WithContext(e.Context).ExportClass(className, func(line types.M) error {
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		if w.Started == false {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(200)
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	})
+	if err == nil {
+		return
+	}
+	if w.Started == false {
+		e.HandleError(err, 0)
+		return
+	}
+	// 已经开始输出时无法修改状态码，在最后一行返回错误
+	data, _ := json.Marshal(errs.ErrorToMap(err))
+	w.Write(append(data, '\n'))
+}
+
+// Get ...
+// @router / [get]
+func (e *ExportController) Get() {
+	e.ClassesController.Get()
+}
+
+// Post ...
+// @router / [post]
+func (e *ExportController) Post() {
+	e.ClassesController.Post()
+}
+
+// Delete ...
+// @router / [delete]
+func (e *ExportController) Delete() {
+	e.ClassesController.Delete()
+}
+
+// Put ...
+// @router / [put]
+func (e *ExportController) Put() {
+	e.ClassesController.Put()
+}
@@ -52,7 +52,7 @@ func (f *FeaturesController) HandleGet() {
 			"addClass":                  true,
 			"removeClass":               true,
 			"clearAllDataFromClass":     true,
-			"exportClass":               false,
+			"exportClass":               true,
 			"editClassLevelPermissions": true,
 			"editPointerPermissions":    true,
 		},
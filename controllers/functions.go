@@ -49,6 +49,7 @@ func (f *FunctionsController) HandleCloudFunction() {
 		InstallationID: f.Info.InstallationID,
 		FunctionName:   functionName,
 		Headers:        headers,
+		Context:        f.Context,
 	}
 	if f.Auth != nil {
 		request.Master = f.Auth.IsMaster
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/types"
+)
+
+// maxImportLineSize 导入数据中单行的最大长度
+const maxImportLineSize = 16 * 1024 * 1024
+
+// ImportController 处理 /import 接口的请求，请求数据为 /export 接口导出的 NDJSON 格式数据
+// Content-Type 需要设置为 application/x-ndjson
+type ImportController struct {
+	ClassesController
+}
+
+// Prepare 访问 /import 接口需要 master key
+func (i *ImportController) Prepare() {
+	i.ClassesController.Prepare()
+	if i.Ctx.ResponseWriter.Started == false {
+		i.EnforceMasterKeyAccess()
+	}
+}
+
+// HandleImport 创建类并导入对象，对象保留原有的 objectId 、 createdAt 与 updatedAt ，类已经存在时返回错误
+// @router /:className [post]
+func (i *ImportController) HandleImport() {
+	className := i.Ctx.Input.Param(":className")
+	var body io.Reader = i.Ctx.Request.Body
+	if i.Ctx.Input.RequestBody != nil {
+		body = bytes.NewReader(i.Ctx.Input.RequestBody)
+	}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize)
+	next := func() (types.M, error) {
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var line types.M
+			err := json.Unmarshal(scanner.Bytes(), &line)
+			if err != nil {
+				return nil, errs.E(errs.InvalidJSON, "invalid JSON")
+			}
+			return line, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, errs.E(errs.InvalidJSON, err.Error())
+		}
+		return nil, io.EOF
+	}
+
+	count, err := orm.TomatoDBController.WithContext(i.Context).ImportClass(className, next)
+	if err != nil {
+		i.HandleError(err, 0)
+		return
+	}
+	i.Data["json"] = types.M{"count": count}
+	i.ServeJSON()
+}
+
+// Get ...
+// @router / [get]
+func (i *ImportController) Get() {
+	i.ClassesController.Get()
+}
+
+// Post ...
+// @router / [post]
+func (i *ImportController) Post() {
+	i.ClassesController.Post()
+}
+
+// Delete ...
+// @router / [delete]
+func (i *ImportController) Delete() {
+	i.ClassesController.Delete()
+}
+
+// Put ...
+// @router / [put]
+func (i *ImportController) Put() {
+	i.ClassesController.Put()
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/migration"
+	"github.com/lfq7413/tomato/types"
+)
+
+// MigrationsController 处理 /migrations 接口的请求
+type MigrationsController struct {
+	ClassesController
+}
+
+// Prepare 访问 /migrations 接口需要 master key
+func (m *MigrationsController) Prepare() {
+	m.ClassesController.Prepare()
+	if m.Ctx.ResponseWriter.Started == false {
+		m.EnforceMasterKeyAccess()
+	}
+}
+
+// HandleFind 列出所有迁移及其执行状态
+// @router / [get]
+func (m *MigrationsController) HandleFind() {
+	results, err := migration.Status()
+	if err != nil {
+		m.HandleError(err, 0)
+		return
+	}
+	m.Data["json"] = types.M{"results": results}
+	m.ServeJSON()
+}
+
+// HandleApply 执行未执行的迁移，可通过 version 指定执行到的版本
+// @router /apply [post]
+func (m *MigrationsController) HandleApply() {
+	var version int64
+	if m.JSONBody != nil && m.JSONBody["version"] != nil {
+		v, ok := m.JSONBody["version"].(float64)
+		if ok == false || v <= 0 {
+			m.HandleError(errs.E(errs.InvalidJSON, "version must be a number greater than 0"), 0)
+			return
+		}
+		version = int64(v)
+	}
+	results, err := migration.Up(version)
+	if err != nil {
+		m.HandleError(err, 0)
+		return
+	}
+	m.Data["json"] = types.M{"results": results}
+	m.ServeJSON()
+}
+
+// HandleRollback 回滚版本大于 version 的已执行的迁移， version 为 0 时回滚全部
+// @router /rollback [post]
+func (m *MigrationsController) HandleRollback() {
+	if m.JSONBody == nil || m.JSONBody["version"] == nil {
+		m.HandleError(errs.E(errs.MissingRequiredFieldError, "version is required"), 0)
+		return
+	}
+	v, ok := m.JSONBody["version"].(float64)
+	if ok == false || v < 0 {
+		m.HandleError(errs.E(errs.InvalidJSON, "version must be a number greater than or equal to 0"), 0)
+		return
+	}
+	results, err := migration.Down(int64(v))
+	if err != nil {
+		m.HandleError(err, 0)
+		return
+	}
+	m.Data["json"] = types.M{"results": results}
+	m.ServeJSON()
+}
+
+// Post ...
+// @router / [post]
+func (m *MigrationsController) Post() {
+	m.ClassesController.Post()
+}
+
+// Delete ...
+// @router / [delete]
+func (m *MigrationsController) Delete() {
+	m.ClassesController.Delete()
+}
+
+// Put ...
+// @router / [put]
+func (m *MigrationsController) Put() {
+	m.ClassesController.Put()
+}
@@ -32,8 +32,12 @@ func (s *SchemasController) HandleFind() {
 		s.ServeJSON()
 		return
 	}
+	results := types.S{}
+	for _, sch := range schemas {
+		results = append(results, orm.HideInternalFields(sch))
+	}
 	s.Data["json"] = types.M{
-		"results": schemas,
+		"results": results,
 	}
 	s.ServeJSON()
 }
@@ -48,7 +52,7 @@ func (s *SchemasController) HandleGet() {
 		s.HandleError(errs.E(errs.InvalidClassName, "Class "+className+" does not exist."), 0)
 		return
 	}
-	s.Data["json"] = sch
+	s.Data["json"] = orm.HideInternalFields(sch)
 	s.ServeJSON()
 }
 
@@ -80,14 +84,27 @@ func (s *SchemasController) HandleCreate() {
 		return
 	}
 
+	options, err := classOptions(data)
+	if err != nil {
+		s.HandleError(err, 0)
+		return
+	}
+
 	schema := orm.TomatoDBController.LoadSchema(types.M{"clearCache": true})
-	result, err := schema.AddClassIfNotExists(className, utils.M(data["fields"]), utils.M(data["classLevelPermissions"]))
+	result, err := schema.AddClassIfNotExists(className, utils.M(data["fields"]), utils.M(data["classLevelPermissions"]), utils.M(data["indexes"]))
 	if err != nil {
 		s.HandleError(err, 0)
 		return
 	}
+	if options != nil {
+		result["options"], err = schema.SetClassOptions(className, options)
+		if err != nil {
+			s.HandleError(err, 0)
+			return
+		}
+	}
 
-	s.Data["json"] = result
+	s.Data["json"] = orm.HideInternalFields(result)
 	s.ServeJSON()
 }
 
@@ -115,17 +132,42 @@ func (s *SchemasController) HandleUpdate() {
 		submittedFields = utils.M(data["fields"])
 	}
 
+	options, err := classOptions(data)
+	if err != nil {
+		s.HandleError(err, 0)
+		return
+	}
+
 	schema := orm.TomatoDBController.LoadSchema(types.M{"clearCache": true})
-	result, err := schema.UpdateClass(className, submittedFields, utils.M(data["classLevelPermissions"]))
+	result, err := schema.UpdateClass(className, submittedFields, utils.M(data["classLevelPermissions"]), utils.M(data["indexes"]))
 	if err != nil {
 		s.HandleError(err, 0)
 		return
 	}
+	if options != nil {
+		result["options"], err = schema.SetClassOptions(className, options)
+		if err != nil {
+			s.HandleError(err, 0)
+			return
+		}
+	}
 
-	s.Data["json"] = result
+	s.Data["json"] = orm.HideInternalFields(result)
 	s.ServeJSON()
 }
 
+// classOptions 获取请求中的类选项，不存在时返回 nil
+func classOptions(data types.M) (types.M, error) {
+	if data["options"] == nil {
+		return nil, nil
+	}
+	options := utils.M(data["options"])
+	if options == nil {
+		return nil, errs.E(errs.InvalidJSON, "options must be an object.")
+	}
+	return options, nil
+}
+
 // HandleDelete 处理删除指定类请求
 // @router /:className [delete]
 func (s *SchemasController) HandleDelete() {
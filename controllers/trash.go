@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/types"
+)
+
+// TrashController 处理 /trash 接口的请求，管理开启软删除的类中已删除的对象
+type TrashController struct {
+	ClassesController
+}
+
+// Prepare 访问 /trash 接口需要 master key
+func (t *TrashController) Prepare() {
+	t.ClassesController.Prepare()
+	if t.Ctx.ResponseWriter.Started == false {
+		t.EnforceMasterKeyAccess()
+	}
+}
+
+// HandleFind 查找已删除的对象，支持 where 、 skip 、 limit 、 count 参数，对象中的 deletedAt 为删除时间
+// @router /:className [get]
+func (t *TrashController) HandleFind() {
+	className := t.Ctx.Input.Param(":className")
+	options := types.M{"trash": true, "limit": 100}
+	if t.Query["skip"] != "" {
+		if i, err := strconv.Atoi(t.Query["skip"]); err == nil {
+			options["skip"] = i
+		}
+	}
+	if t.Query["limit"] != "" {
+		if i, err := strconv.Atoi(t.Query["limit"]); err == nil {
+			options["limit"] = i
+		}
+	}
+	where := types.M{}
+	if t.Query["where"] != "" {
+		err := json.Unmarshal([]byte(t.Query["where"]), &where)
+		if err != nil {
+			t.HandleError(errs.E(errs.InvalidJSON, "where should be valid json"), 0)
+			return
+		}
+	}
+
+	db := orm.TomatoDBController.WithContext(t.Context)
+	results, err := db.Find(className, where, options)
+	if err != nil {
+		t.HandleError(err, 0)
+		return
+	}
+	response := types.M{"results": results}
+	if t.Query["count"] != "" {
+		counts, err := db.Find(className, where, types.M{"trash": true, "count": true})
+		if err != nil {
+			t.HandleError(err, 0)
+			return
+		}
+		response["count"] = counts[0]
+	}
+	t.Data["json"] = response
+	t.ServeJSON()
+}
+
+// HandleRestore 恢复已删除的对象，返回恢复后的对象
+// @router /:className/:objectId/restore [post]
+func (t *TrashController) HandleRestore() {
+	className := t.Ctx.Input.Param(":className")
+	objectID := t.Ctx.Input.Param(":objectId")
+	object, err := orm.TomatoDBController.WithContext(t.Context).Restore(className, objectID)
+	if err != nil {
+		t.HandleError(err, 0)
+		return
+	}
+	t.Data["json"] = object
+	t.ServeJSON()
+}
+
+// HandlePurge 永久删除回收站中的指定对象
+// @router /:className/:objectId [delete]
+func (t *TrashController) HandlePurge() {
+	className := t.Ctx.Input.Param(":className")
+	objectID := t.Ctx.Input.Param(":objectId")
+	count, err := orm.TomatoDBController.WithContext(t.Context).PurgeTrash(className, types.M{"objectId": objectID})
+	if err != nil {
+		t.HandleError(err, 0)
+		return
+	}
+	if count == 0 {
+		t.HandleError(errs.E(errs.ObjectNotFound, "Object not found."), 0)
+		return
+	}
+	t.Data["json"] = types.M{}
+	t.ServeJSON()
+}
+
+// HandlePurgeAll 清空类的回收站，返回删除的对象数量
+// @router /:className [delete]
+func (t *TrashController) HandlePurgeAll() {
+	className := t.Ctx.Input.Param(":className")
+	count, err := orm.TomatoDBController.WithContext(t.Context).PurgeTrash(className, types.M{})
+	if err != nil {
+		t.HandleError(err, 0)
+		return
+	}
+	t.Data["json"] = types.M{"count": count}
+	t.ServeJSON()
+}
+
+// Get ...
+// @router / [get]
+func (t *TrashController) Get() {
+	t.ClassesController.Get()
+}
+
+// Post ...
+// @router / [post]
+func (t *TrashController) Post() {
+	t.ClassesController.Post()
+}
+
+// Delete ...
+// @router / [delete]
+func (t *TrashController) Delete() {
+	t.ClassesController.Delete()
+}
+
+// Put ...
+// @router / [put]
+func (t *TrashController) Put() {
+	t.ClassesController.Put()
+}
@@ -393,6 +393,18 @@ const TemporaryRejectionError = 159
 // Error code indicating an invalid event name.
 const InvalidEventName = 160
 
+// QueryLimitExceeded ...
+// Error code indicating that the query exceeded a limit configured on the server.
+const QueryLimitExceeded = 161
+
+// PreconditionFailed ...
+// Error code indicating that the object was modified since the version given in If-Match.
+const PreconditionFailed = 162
+
+// DuplicateRequest ...
+// Error code indicating that a request with the same request id is already processed or in progress.
+const DuplicateRequest = 163
+
 // UsernameMissing ...
 // Error code indicating that the username is missing or empty.
 const UsernameMissing = 200
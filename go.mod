@@ -8,6 +8,7 @@ require (
 	github.com/garyburd/redigo v1.6.2
 	github.com/influxdata/influxdb v1.8.5
 	github.com/lib/pq v1.10.0
+	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 	golang.org/x/net v0.0.0-20210415231046-e915ea6b2b7d
 	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
 )
@@ -0,0 +1,27 @@
+package job
+
+import (
+	"encoding/json"
+
+	"github.com/lfq7413/tomato/cloud"
+	"github.com/lfq7413/tomato/orm"
+)
+
+// PurgeTrashJobName 内置任务，永久删除开启软删除的类中超过保留天数的对象
+// 可通过 POST /jobs/purgeTrash 定期执行
+const PurgeTrashJobName = "purgeTrash"
+
+func init() {
+	cloud.Job(PurgeTrashJobName, purgeTrash)
+}
+
+// purgeTrash 执行结果为每个类中删除的对象数量，如 {"post":3}
+func purgeTrash(request cloud.JobRequest, response cloud.JobResponse) {
+	results, err := orm.TomatoDBController.PurgeExpiredTrash()
+	if err != nil {
+		response.Error(err.Error())
+		return
+	}
+	b, _ := json.Marshal(results)
+	response.Success(string(b))
+}
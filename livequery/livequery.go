@@ -1,17 +1,34 @@
 package livequery
 
 import (
+	"context"
 	"strings"
+	"time"
 
 	"github.com/lfq7413/tomato/config"
 	"github.com/lfq7413/tomato/livequery/pubsub"
 	"github.com/lfq7413/tomato/livequery/t"
+	"github.com/lfq7413/tomato/livequery/utils"
+	"github.com/lfq7413/tomato/storage"
 )
 
+// changeFeedName 监听数据库变更时使用的监听者名称
+const changeFeedName = "LiveQuery"
+
+// changeFeedRetryInterval 监听数据库变更出错后，重新监听的间隔
+const changeFeedRetryInterval = 5 * time.Second
+
 // LiveQuery 接收指定类的对象保存与对象删除的通知，发送对象数据到发布者，由发布者通知订阅者，订阅者实时接收数据
+// 使用数据库变更作为通知来源时，忽略 OnAfterSave 与 OnAfterDelete ，避免重复通知
 type LiveQuery struct {
 	classNames         map[string]bool
 	liveQueryPublisher *pubsub.CloudCodePublisher
+	changeFeed         bool
+}
+
+// ChangeWatcher 数据库变更的来源
+type ChangeWatcher interface {
+	WatchChanges(ctx context.Context, name string, classNames []string, handler func(*storage.ChangeEvent) error) error
 }
 
 // TLiveQuery ...
@@ -23,6 +40,7 @@ func init() {
 	pubURL := config.TConfig.PublisherURL
 	pubConfig := config.TConfig.PublisherConfig
 	TLiveQuery = NewLiveQuery(classNames, pubType, pubURL, pubConfig)
+	TLiveQuery.changeFeed = config.TConfig.LiveQueryChangeFeed
 }
 
 // NewLiveQuery 初始化 LiveQuery
@@ -44,7 +62,7 @@ func NewLiveQuery(classNames []string, pubType, pubURL, pubConfig string) *LiveQ
 
 // OnAfterSave 保存对象之后调用
 func (l *LiveQuery) OnAfterSave(className string, currentObject, originalObject map[string]interface{}) {
-	if l.HasLiveQuery(className) == false {
+	if l.changeFeed || l.HasLiveQuery(className) == false {
 		return
 	}
 	req := l.makePublisherRequest(currentObject, originalObject)
@@ -53,13 +71,63 @@ func (l *LiveQuery) OnAfterSave(className string, currentObject, originalObject
 
 // OnAfterDelete 删除对象之后调用
 func (l *LiveQuery) OnAfterDelete(className string, currentObject, originalObject map[string]interface{}) {
-	if l.HasLiveQuery(className) == false {
+	if l.changeFeed || l.HasLiveQuery(className) == false {
 		return
 	}
 	req := l.makePublisherRequest(currentObject, originalObject)
 	l.liveQueryPublisher.OnCloudCodeAfterDelete(req)
 }
 
+// RunChangeFeed 监听数据库变更并发送到发布者，出错后自动重新监听，从上次处理的位置继续，ctx 取消后返回
+// 调用之后不再处理 OnAfterSave 与 OnAfterDelete 的通知
+func (l *LiveQuery) RunChangeFeed(ctx context.Context, watcher ChangeWatcher) {
+	l.changeFeed = true
+	classNames := []string{}
+	for className := range l.classNames {
+		if className != "" {
+			classNames = append(classNames, className)
+		}
+	}
+	if len(classNames) == 0 {
+		return
+	}
+	for {
+		err := watcher.WatchChanges(ctx, changeFeedName, classNames, l.onChange)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			utils.TLog.Error("Watch changes failed:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(changeFeedRetryInterval):
+		}
+	}
+}
+
+// onChange 发送数据库中对象的变更，格式与 OnAfterSave OnAfterDelete 相同
+func (l *LiveQuery) onChange(event *storage.ChangeEvent) error {
+	if l.HasLiveQuery(event.ClassName) == false {
+		return nil
+	}
+	currentObject := t.M(event.Object)
+	currentObject["className"] = event.ClassName
+	var originalObject t.M
+	if event.Original != nil {
+		originalObject = t.M(event.Original)
+		originalObject["className"] = event.ClassName
+	}
+	req := l.makePublisherRequest(currentObject, originalObject)
+	if event.Type == storage.ChangeDelete {
+		l.liveQueryPublisher.OnCloudCodeAfterDelete(req)
+	} else {
+		l.liveQueryPublisher.OnCloudCodeAfterSave(req)
+	}
+	return nil
+}
+
 // HasLiveQuery 是否有对应的 className
 func (l *LiveQuery) HasLiveQuery(className string) bool {
 	return l.classNames[className]
@@ -0,0 +1,96 @@
+package livequery
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lfq7413/tomato/livequery/pubsub"
+	"github.com/lfq7413/tomato/livequery/server"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+)
+
+type testWatcher struct {
+	events []*storage.ChangeEvent
+	cancel context.CancelFunc
+}
+
+func (w *testWatcher) WatchChanges(ctx context.Context, name string, classNames []string, handler func(*storage.ChangeEvent) error) error {
+	for _, event := range w.events {
+		if err := handler(event); err != nil {
+			return err
+		}
+	}
+	w.cancel()
+	return nil
+}
+
+func Test_RunChangeFeed(t *testing.T) {
+	messages := make(chan []string, 10)
+	sub := pubsub.CreateSubscriber("", "", "")
+	sub.Subscribe(server.TomatoInfo["appId"] + "afterSave")
+	sub.Subscribe(server.TomatoInfo["appId"] + "afterDelete")
+	sub.On("message", func(args ...string) {
+		messages <- args
+	})
+	defer sub.Unsubscribe(server.TomatoInfo["appId"] + "afterSave")
+	defer sub.Unsubscribe(server.TomatoInfo["appId"] + "afterDelete")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher := &testWatcher{
+		events: []*storage.ChangeEvent{
+			{ClassName: "post", Type: storage.ChangeSave, Object: types.M{"objectId": "01", "title": "a"}},
+			{ClassName: "user", Type: storage.ChangeSave, Object: types.M{"objectId": "01"}},
+			{ClassName: "post", Type: storage.ChangeSave, Object: types.M{"objectId": "01", "title": "b"}, Original: types.M{"objectId": "01", "title": "a"}},
+			{ClassName: "post", Type: storage.ChangeDelete, Object: types.M{"objectId": "01", "title": "b"}},
+		},
+		cancel: cancel,
+	}
+	l := NewLiveQuery([]string{"post"}, "", "", "")
+	l.RunChangeFeed(ctx, watcher)
+
+	// EventEmitter 异步发送消息，不保证顺序
+	want := [][]string{
+		{server.TomatoInfo["appId"] + "afterSave", `{"currentParseObject":{"className":"post","objectId":"01","title":"a"},"originalParseObject":null}`},
+		{server.TomatoInfo["appId"] + "afterSave", `{"currentParseObject":{"className":"post","objectId":"01","title":"b"},"originalParseObject":{"className":"post","objectId":"01","title":"a"}}`},
+		{server.TomatoInfo["appId"] + "afterDelete", `{"currentParseObject":{"className":"post","objectId":"01","title":"b"},"originalParseObject":null}`},
+	}
+	got := [][]string{}
+	for range want {
+		select {
+		case message := <-messages:
+			got = append(got, message)
+		case <-time.After(time.Second):
+			t.Fatalf("LiveQuery.RunChangeFeed() = %v, want %v", got, want)
+		}
+	}
+	for _, w := range want {
+		found := false
+		for _, g := range got {
+			if g[0] == w[0] && equalJSON(g[1], w[1]) {
+				found = true
+			}
+		}
+		if found == false {
+			t.Errorf("LiveQuery.RunChangeFeed() = %v, want %v", got, w)
+		}
+	}
+
+	l.OnAfterSave("post", types.M{"objectId": "02", "className": "post"}, nil)
+	select {
+	case got := <-messages:
+		t.Errorf("LiveQuery.OnAfterSave() with change feed = %v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func equalJSON(a, b string) bool {
+	var x, y interface{}
+	if json.Unmarshal([]byte(a), &x) != nil || json.Unmarshal([]byte(b), &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
@@ -239,6 +239,14 @@ func matchesKeyConstraints(object t.M, key string, constraints interface{}) bool
 			if compareBox(compareTo, object[key]) == false {
 				return false
 			}
+		case "$geoWithin":
+			if compareGeoWithin(compareTo, object[key]) == false {
+				return false
+			}
+		case "$geoIntersects":
+			if compareGeoIntersects(compareTo, object[key]) == false {
+				return false
+			}
 		case "$options":
 		case "$maxDistance":
 		case "$select":
@@ -331,6 +339,109 @@ func compareBox(compareTo, point interface{}) bool {
 		geoPoint["longitude"] < northEast["longitude"]
 }
 
+// compareGeoWithin 校验一点是否在多边形内， $polygon 为 GeoPoint 数组或者 Polygon
+func compareGeoWithin(compareTo, point interface{}) bool {
+	geoWithin, ok := compareTo.(map[string]interface{})
+	if ok == false {
+		return false
+	}
+	var vertices [][2]float64
+	switch polygon := geoWithin["$polygon"].(type) {
+	case []interface{}:
+		for _, p := range polygon {
+			x, y, ok := geoPointOf(p)
+			if ok == false {
+				return false
+			}
+			vertices = append(vertices, [2]float64{x, y})
+		}
+	case map[string]interface{}:
+		vertices = polygonOf(polygon)
+	}
+	if len(vertices) < 3 {
+		return false
+	}
+	x, y, ok := geoPointOf(point)
+	if ok == false {
+		return false
+	}
+	return polygonContains(vertices, x, y)
+}
+
+// compareGeoIntersects 校验多边形 polygon 是否包含 $point
+func compareGeoIntersects(compareTo, polygon interface{}) bool {
+	geoIntersects, ok := compareTo.(map[string]interface{})
+	if ok == false {
+		return false
+	}
+	x, y, ok := geoPointOf(geoIntersects["$point"])
+	if ok == false {
+		return false
+	}
+	var vertices [][2]float64
+	if p, ok := polygon.(map[string]interface{}); ok {
+		vertices = polygonOf(p)
+	}
+	if len(vertices) < 3 {
+		return false
+	}
+	return polygonContains(vertices, x, y)
+}
+
+// geoPointOf 获取 GeoPoint 的经纬度
+func geoPointOf(point interface{}) (float64, float64, bool) {
+	if p, ok := point.(map[string]interface{}); ok {
+		if x, ok := p["longitude"].(float64); ok {
+			if y, ok := p["latitude"].(float64); ok {
+				return x, y, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// polygonOf 获取 Polygon 的顶点 (longitude, latitude) ， coordinates 中的顶点为 [latitude, longitude]
+func polygonOf(polygon map[string]interface{}) [][2]float64 {
+	if polygon["__type"] != "Polygon" {
+		return nil
+	}
+	coordinates, ok := polygon["coordinates"].([]interface{})
+	if ok == false {
+		return nil
+	}
+	vertices := [][2]float64{}
+	for _, c := range coordinates {
+		pair, ok := c.([]interface{})
+		if ok == false || len(pair) != 2 {
+			return nil
+		}
+		y, ok1 := pair[0].(float64)
+		x, ok2 := pair[1].(float64)
+		if ok1 == false || ok2 == false {
+			return nil
+		}
+		vertices = append(vertices, [2]float64{x, y})
+	}
+	return vertices
+}
+
+// polygonContains 点 (x,y) 是否在多边形内，在边上时也认为包含
+func polygonContains(vertices [][2]float64, x, y float64) bool {
+	inside := false
+	for i, j := 0, len(vertices)-1; i < len(vertices); j, i = i, i+1 {
+		xi, yi := vertices[i][0], vertices[i][1]
+		xj, yj := vertices[j][0], vertices[j][1]
+		cross := (xj-xi)*(y-yi) - (yj-yi)*(x-xi)
+		if cross == 0 && x >= math.Min(xi, xj) && x <= math.Max(xi, xj) && y >= math.Min(yi, yj) && y <= math.Max(yi, yj) {
+			return true
+		}
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
 // compareGeoPoint 比较两点是否相邻
 func compareGeoPoint(p1, p2, maxDistance interface{}) bool {
 	if v1, ok := p1.(map[string]interface{}); ok {
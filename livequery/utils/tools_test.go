@@ -639,6 +639,45 @@ func Test_matchesKeyConstraints(t *testing.T) {
 			},
 			expect: false,
 		},
+		{
+			object: tp.M{
+				"data": map[string]interface{}{"__type": "Bytes", "base64": "aGVsbG8="},
+			},
+			key:         "data",
+			constraints: map[string]interface{}{"__type": "Bytes", "base64": "aGVsbG8="},
+			expect:      true,
+		},
+		{
+			object: tp.M{
+				"data": map[string]interface{}{"__type": "Bytes", "base64": "aGVsbG8="},
+			},
+			key:         "data",
+			constraints: map[string]interface{}{"__type": "Bytes", "base64": "d29ybGQ="},
+			expect:      false,
+		},
+		{
+			object: tp.M{
+				"data": map[string]interface{}{"__type": "Bytes", "base64": "aGVsbG8="},
+			},
+			key: "data",
+			constraints: map[string]interface{}{
+				"$in": []interface{}{
+					map[string]interface{}{"__type": "Bytes", "base64": "d29ybGQ="},
+					map[string]interface{}{"__type": "Bytes", "base64": "aGVsbG8="},
+				},
+			},
+			expect: true,
+		},
+		{
+			object: tp.M{
+				"data": map[string]interface{}{"__type": "Bytes", "base64": "aGVsbG8="},
+			},
+			key: "data",
+			constraints: map[string]interface{}{
+				"$ne": map[string]interface{}{"__type": "Bytes", "base64": "aGVsbG8="},
+			},
+			expect: false,
+		},
 	}
 
 	for _, d := range data {
@@ -795,6 +834,131 @@ func Test_compareBox(t *testing.T) {
 	}
 }
 
+func Test_compareGeoWithin(t *testing.T) {
+	polygon := map[string]interface{}{
+		"__type":      "Polygon",
+		"coordinates": []interface{}{[]interface{}{0.0, 0.0}, []interface{}{0.0, 10.0}, []interface{}{10.0, 10.0}, []interface{}{10.0, 0.0}},
+	}
+	points := []interface{}{
+		map[string]interface{}{"__type": "GeoPoint", "longitude": 0.0, "latitude": 0.0},
+		map[string]interface{}{"__type": "GeoPoint", "longitude": 10.0, "latitude": 0.0},
+		map[string]interface{}{"__type": "GeoPoint", "longitude": 10.0, "latitude": 10.0},
+	}
+	data := []struct {
+		compareTo interface{}
+		point     interface{}
+		expect    bool
+	}{
+		{
+			compareTo: "hello",
+			point:     map[string]interface{}{"longitude": 5.0, "latitude": 5.0},
+			expect:    false,
+		},
+		{
+			compareTo: map[string]interface{}{"$polygon": "hello"},
+			point:     map[string]interface{}{"longitude": 5.0, "latitude": 5.0},
+			expect:    false,
+		},
+		{
+			compareTo: map[string]interface{}{"$polygon": []interface{}{1, 2, 3}},
+			point:     map[string]interface{}{"longitude": 5.0, "latitude": 5.0},
+			expect:    false,
+		},
+		{
+			compareTo: map[string]interface{}{"$polygon": points},
+			point:     map[string]interface{}{"longitude": 8.0, "latitude": 2.0},
+			expect:    true,
+		},
+		{
+			compareTo: map[string]interface{}{"$polygon": points},
+			point:     map[string]interface{}{"longitude": 2.0, "latitude": 8.0},
+			expect:    false,
+		},
+		{
+			compareTo: map[string]interface{}{"$polygon": polygon},
+			point:     map[string]interface{}{"longitude": 5.0, "latitude": 5.0},
+			expect:    true,
+		},
+		{
+			compareTo: map[string]interface{}{"$polygon": polygon},
+			point:     map[string]interface{}{"longitude": 10.0, "latitude": 5.0},
+			expect:    true,
+		},
+		{
+			compareTo: map[string]interface{}{"$polygon": polygon},
+			point:     map[string]interface{}{"longitude": 15.0, "latitude": 5.0},
+			expect:    false,
+		},
+		{
+			compareTo: map[string]interface{}{"$polygon": polygon},
+			point:     "hello",
+			expect:    false,
+		},
+	}
+
+	for _, d := range data {
+		result := compareGeoWithin(d.compareTo, d.point)
+		if reflect.DeepEqual(d.expect, result) == false {
+			t.Error("expect:", d.expect, "result:", result)
+		}
+	}
+}
+
+func Test_compareGeoIntersects(t *testing.T) {
+	polygon := map[string]interface{}{
+		"__type":      "Polygon",
+		"coordinates": []interface{}{[]interface{}{0.0, 0.0}, []interface{}{0.0, 10.0}, []interface{}{10.0, 10.0}, []interface{}{10.0, 0.0}, []interface{}{0.0, 0.0}},
+	}
+	data := []struct {
+		compareTo interface{}
+		polygon   interface{}
+		expect    bool
+	}{
+		{
+			compareTo: "hello",
+			polygon:   polygon,
+			expect:    false,
+		},
+		{
+			compareTo: map[string]interface{}{"$point": "hello"},
+			polygon:   polygon,
+			expect:    false,
+		},
+		{
+			compareTo: map[string]interface{}{"$point": map[string]interface{}{"__type": "GeoPoint", "longitude": 5.0, "latitude": 5.0}},
+			polygon:   "hello",
+			expect:    false,
+		},
+		{
+			compareTo: map[string]interface{}{"$point": map[string]interface{}{"__type": "GeoPoint", "longitude": 5.0, "latitude": 5.0}},
+			polygon:   map[string]interface{}{"__type": "GeoPoint", "longitude": 5.0, "latitude": 5.0},
+			expect:    false,
+		},
+		{
+			compareTo: map[string]interface{}{"$point": map[string]interface{}{"__type": "GeoPoint", "longitude": 5.0, "latitude": 5.0}},
+			polygon:   polygon,
+			expect:    true,
+		},
+		{
+			compareTo: map[string]interface{}{"$point": map[string]interface{}{"__type": "GeoPoint", "longitude": 0.0, "latitude": 0.0}},
+			polygon:   polygon,
+			expect:    true,
+		},
+		{
+			compareTo: map[string]interface{}{"$point": map[string]interface{}{"__type": "GeoPoint", "longitude": 5.0, "latitude": 15.0}},
+			polygon:   polygon,
+			expect:    false,
+		},
+	}
+
+	for _, d := range data {
+		result := compareGeoIntersects(d.compareTo, d.polygon)
+		if reflect.DeepEqual(d.expect, result) == false {
+			t.Error("expect:", d.expect, "result:", result)
+		}
+	}
+}
+
 func Test_compareGeoPoint(t *testing.T) {
 	data := []struct {
 		p1          interface{}
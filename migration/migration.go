@@ -0,0 +1,698 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// 已经执行的迁移保存在 _Migration 表中，每个版本一条记录
+// 表中 objectId 为 lock 的记录用作执行锁，多个节点同时启动时只有获得锁的节点执行迁移
+// 锁带有过期时间 lockedUntil ，持有锁的节点异常退出后，其他节点可以在锁过期后重新获取
+// 执行期间在后台定时延长锁的有效期，发现锁已被其他节点抢占时中止正在执行的迁移
+// 适配器支持事务时，迁移与记录的写入在同一个事务中执行
+// 不支持事务时（如 MongoDB），执行前先把记录的 status 设为 running ，完成后设为 applied ，
+// 执行过程中异常退出时记录保持 running 状态，之后不再自动执行迁移，需要检查数据后手动删除该记录
+
+const migrationCollection = "_Migration"
+
+const lockObjectID = "lock"
+
+// 迁移记录的状态
+const (
+	statusRunning = "running"
+	statusApplied = "applied"
+)
+
+// lockTimeout 锁的有效期，持有期间在后台每隔 lockRefreshInterval 延长有效期
+var lockTimeout = 10 * time.Minute
+
+// lockRefreshInterval 后台延长锁有效期的间隔，需要小于 lockTimeout
+var lockRefreshInterval = time.Minute
+
+// lockRetryInterval 等待锁时重试的间隔
+var lockRetryInterval = time.Second
+
+// migrationCLP _Migration 表仅允许 master 访问
+var migrationCLP = types.M{
+	"find":     types.M{},
+	"get":      types.M{},
+	"count":    types.M{},
+	"create":   types.M{},
+	"update":   types.M{},
+	"delete":   types.M{},
+	"addField": types.M{},
+}
+
+// Migration 版本迁移， Version 需要大于 0 ，迁移按 Version 从小到大执行
+// Down 为空时不支持回滚
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(db *orm.DBController) error
+	Down    func(db *orm.DBController) error
+}
+
+var migrations = map[int64]*Migration{}
+var mutex sync.Mutex
+
+// Register 注册迁移
+func Register(m *Migration) error {
+	if m == nil || m.Version <= 0 {
+		return errs.E(errs.IncorrectType, "migration version must be greater than 0")
+	}
+	if m.Up == nil {
+		return errs.E(errs.IncorrectType, "migration "+strconv.FormatInt(m.Version, 10)+" has no up function")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := migrations[m.Version]; ok {
+		return errs.E(errs.DuplicateValue, "migration "+strconv.FormatInt(m.Version, 10)+" already registered")
+	}
+	migrations[m.Version] = m
+	return nil
+}
+
+// RegisterJSON 注册 JSON 格式的迁移，格式如下：
+//
+//	{
+//		"version": 1,
+//		"name": "create post",
+//		"up": [
+//			{"op": "createClass", "className": "Post", "fields": {...}, "classLevelPermissions": {...}, "indexes": {...}, "options": {...}},
+//			{"op": "updateClass", "className": "Post", "fields": {"title": {"__op": "Delete"}}, "indexes": {...}},
+//			{"op": "deleteClass", "className": "Post"},
+//			{"op": "update", "className": "Post", "where": {...}, "update": {...}},
+//			{"op": "delete", "className": "Post", "where": {...}}
+//		],
+//		"down": [...]
+//	}
+//
+// createClass 、 updateClass 的参数与 /schemas 接口相同， update 、 delete 用于修改数据， where 为空时处理所有对象
+func RegisterJSON(data []byte) error {
+	var object types.M
+	err := json.Unmarshal(data, &object)
+	if err != nil {
+		return errs.E(errs.InvalidJSON, "invalid migration: "+err.Error())
+	}
+	m, err := parseMigration(object)
+	if err != nil {
+		return err
+	}
+	return Register(m)
+}
+
+// LoadDir 注册目录下所有 .json 文件中的迁移
+func LoadDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || strings.HasSuffix(file.Name(), ".json") == false {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		err = RegisterJSON(data)
+		if err != nil {
+			return errs.E(errs.GetErrorCode(err), file.Name()+": "+errs.GetErrorMessage(err))
+		}
+	}
+	return nil
+}
+
+// parseMigration 解析 JSON 格式的迁移
+func parseMigration(object types.M) (*Migration, error) {
+	version, ok := object["version"].(float64)
+	if ok == false || version != float64(int64(version)) {
+		return nil, errs.E(errs.InvalidJSON, "migration version must be an integer")
+	}
+	m := &Migration{
+		Version: int64(version),
+		Name:    utils.S(object["name"]),
+	}
+	up, err := parseSteps(object["up"])
+	if err != nil {
+		return nil, err
+	}
+	if up == nil {
+		return nil, errs.E(errs.InvalidJSON, "migration "+strconv.FormatInt(m.Version, 10)+" has no up steps")
+	}
+	m.Up = runSteps(up)
+	down, err := parseSteps(object["down"])
+	if err != nil {
+		return nil, err
+	}
+	if down != nil {
+		m.Down = runSteps(down)
+	}
+	return m, nil
+}
+
+// parseSteps 解析并校验迁移步骤
+func parseSteps(i interface{}) ([]types.M, error) {
+	if i == nil {
+		return nil, nil
+	}
+	list := utils.A(i)
+	if list == nil {
+		return nil, errs.E(errs.InvalidJSON, "migration steps must be an array")
+	}
+	steps := []types.M{}
+	for _, v := range list {
+		step := utils.M(v)
+		if step == nil {
+			return nil, errs.E(errs.InvalidJSON, "migration step must be an object")
+		}
+		if utils.S(step["className"]) == "" {
+			return nil, errs.E(errs.InvalidJSON, "migration step needs a className")
+		}
+		switch utils.S(step["op"]) {
+		case "createClass", "updateClass", "deleteClass", "update", "delete":
+		default:
+			return nil, errs.E(errs.InvalidJSON, "unknown migration op: "+utils.S(step["op"]))
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// runSteps 返回按顺序执行迁移步骤的函数
+func runSteps(steps []types.M) func(db *orm.DBController) error {
+	return func(db *orm.DBController) error {
+		for _, step := range steps {
+			err := runStep(db, step)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// runStep 执行单个迁移步骤
+func runStep(db *orm.DBController, step types.M) error {
+	className := utils.S(step["className"])
+	where := utils.M(step["where"])
+	if len(where) == 0 {
+		where = types.M{"objectId": types.M{"$exists": true}}
+	}
+	var err error
+	switch utils.S(step["op"]) {
+	case "createClass":
+		schema := db.LoadSchema(types.M{"clearCache": true})
+		_, err = schema.AddClassIfNotExists(className, utils.M(step["fields"]), utils.M(step["classLevelPermissions"]), utils.M(step["indexes"]))
+		if err == nil && utils.M(step["options"]) != nil {
+			_, err = schema.SetClassOptions(className, utils.M(step["options"]))
+		}
+	case "updateClass":
+		fields := utils.M(step["fields"])
+		if fields == nil {
+			fields = types.M{}
+		}
+		schema := db.LoadSchema(types.M{"clearCache": true})
+		_, err = schema.UpdateClass(className, fields, utils.M(step["classLevelPermissions"]), utils.M(step["indexes"]))
+		if err == nil && utils.M(step["options"]) != nil {
+			_, err = schema.SetClassOptions(className, utils.M(step["options"]))
+		}
+	case "deleteClass":
+		err = db.DeleteSchema(className)
+	case "update":
+		_, err = db.Update(className, where, utils.M(step["update"]), types.M{"many": true}, false)
+	case "delete":
+		err = db.Destroy(className, where, types.M{})
+		if errs.GetErrorCode(err) == errs.ObjectNotFound {
+			// 没有需要删除的对象
+			err = nil
+		}
+	}
+	return err
+}
+
+// Status 返回所有已注册以及已执行的迁移，按版本排序
+// 格式为 {"version": 1, "name": "create post", "applied": true, "appliedAt": "..."} ，执行过程中异常退出的迁移 running 为 true
+func Status() (types.S, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	mutex.Lock()
+	versions := []int64{}
+	results := map[int64]types.M{}
+	for version, m := range migrations {
+		versions = append(versions, version)
+		results[version] = types.M{"version": version, "name": m.Name, "applied": false}
+	}
+	mutex.Unlock()
+	for version, record := range applied {
+		result := results[version]
+		if result == nil {
+			versions = append(versions, version)
+			result = types.M{"version": version, "name": record["name"]}
+			results[version] = result
+		}
+		if utils.S(record["status"]) == statusRunning {
+			result["applied"] = false
+			result["running"] = true
+			continue
+		}
+		result["applied"] = true
+		result["appliedAt"] = record["appliedAt"]
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	list := types.S{}
+	for _, version := range versions {
+		list = append(list, results[version])
+	}
+	return list, nil
+}
+
+// Run 执行所有未执行的迁移，锁被其他节点持有时等待其释放，用于服务启动时
+func Run() error {
+	mutex.Lock()
+	count := len(migrations)
+	mutex.Unlock()
+	if count == 0 {
+		return nil
+	}
+	err := ensureClass()
+	if err != nil {
+		return err
+	}
+	l, err := acquireLock(true)
+	if err != nil {
+		return err
+	}
+	defer l.release()
+	_, err = up(l, 0)
+	return err
+}
+
+// Up 执行版本不大于 target 的所有未执行的迁移， target 为 0 时执行全部，返回执行的迁移
+// 其他节点正在执行迁移时返回错误
+func Up(target int64) (types.S, error) {
+	err := ensureClass()
+	if err != nil {
+		return nil, err
+	}
+	l, err := acquireLock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return up(l, target)
+}
+
+// Down 按版本从大到小回滚版本大于 target 的所有已执行的迁移，返回回滚的迁移
+// 其他节点正在执行迁移时返回错误
+func Down(target int64) (types.S, error) {
+	err := ensureClass()
+	if err != nil {
+		return nil, err
+	}
+	l, err := acquireLock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return down(l, target)
+}
+
+func up(l *lock, target int64) (types.S, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	err = checkInterrupted(applied)
+	if err != nil {
+		return nil, err
+	}
+	pending := []*Migration{}
+	mutex.Lock()
+	for version, m := range migrations {
+		if _, ok := applied[version]; ok {
+			continue
+		}
+		if target > 0 && version > target {
+			continue
+		}
+		pending = append(pending, m)
+	}
+	mutex.Unlock()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+
+	// 锁丢失后 l.ctx 被取消，正在执行的迁移中的数据库操作随之失败
+	db := orm.TomatoDBController.WithContext(l.ctx)
+	results := types.S{}
+	for _, m := range pending {
+		err = l.lost()
+		if err != nil {
+			return results, err
+		}
+		err = apply(db, m)
+		if lost := l.lost(); lost != nil {
+			return results, lost
+		}
+		if err != nil {
+			return results, err
+		}
+		results = append(results, types.M{"version": m.Version, "name": m.Name})
+	}
+	return results, nil
+}
+
+// apply 执行迁移并保存记录，数据库支持事务时在事务中执行，否则先保存 running 状态的记录再执行
+func apply(db *orm.DBController, m *Migration) error {
+	record := types.M{
+		"objectId":  utils.CreateObjectID(),
+		"version":   float64(m.Version),
+		"name":      m.Name,
+		"status":    statusApplied,
+		"appliedAt": types.M{"__type": "Date", "iso": utils.TimetoString(time.Now().UTC())},
+		// lockdown!
+		"ACL": types.M{},
+	}
+	if db.SupportsTransactions() {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		err = m.Up(tx)
+		if err == nil {
+			err = tx.Create(migrationCollection, record, types.M{})
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			return tx.Commit()
+		}
+		tx.Rollback()
+		if notSupportedInTransaction(err) == false {
+			return migrationError(m, "up", err)
+		}
+		// 迁移中包含事务不支持的操作，不使用事务重新执行
+	}
+
+	// 不支持事务时，先保存 running 状态的记录
+	record["status"] = statusRunning
+	err := db.Create(migrationCollection, record, types.M{})
+	if err != nil {
+		return err
+	}
+	query := types.M{"objectId": record["objectId"]}
+	err = m.Up(db)
+	if err != nil {
+		db.Destroy(migrationCollection, query, types.M{})
+		return migrationError(m, "up", err)
+	}
+	update := types.M{
+		"status":    statusApplied,
+		"appliedAt": types.M{"__type": "Date", "iso": utils.TimetoString(time.Now().UTC())},
+	}
+	_, err = db.Update(migrationCollection, query, update, types.M{}, false)
+	return err
+}
+
+func down(l *lock, target int64) (types.S, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	err = checkInterrupted(applied)
+	if err != nil {
+		return nil, err
+	}
+	versions := []int64{}
+	for version := range applied {
+		if version > target {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+	db := orm.TomatoDBController.WithContext(l.ctx)
+	results := types.S{}
+	for _, version := range versions {
+		mutex.Lock()
+		m := migrations[version]
+		mutex.Unlock()
+		if m == nil || m.Down == nil {
+			return results, errs.E(errs.OperationForbidden, "migration "+strconv.FormatInt(version, 10)+" can not be rolled back")
+		}
+		err = l.lost()
+		if err != nil {
+			return results, err
+		}
+		err = revert(db, m)
+		if lost := l.lost(); lost != nil {
+			return results, lost
+		}
+		if err != nil {
+			return results, err
+		}
+		results = append(results, types.M{"version": m.Version, "name": m.Name})
+	}
+	return results, nil
+}
+
+// revert 回滚迁移并删除记录，数据库不支持事务时先把记录设为 running 状态再执行
+func revert(db *orm.DBController, m *Migration) error {
+	query := types.M{"version": float64(m.Version)}
+	if db.SupportsTransactions() {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		err = m.Down(tx)
+		if err == nil {
+			err = tx.Destroy(migrationCollection, query, types.M{})
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			return tx.Commit()
+		}
+		tx.Rollback()
+		if notSupportedInTransaction(err) == false {
+			return migrationError(m, "down", err)
+		}
+		// 迁移中包含事务不支持的操作，不使用事务重新执行
+	}
+
+	// 不支持事务时，先把记录设为 running 状态
+	_, err := db.Update(migrationCollection, query, types.M{"status": statusRunning}, types.M{}, false)
+	if err != nil {
+		return err
+	}
+	err = m.Down(db)
+	if err != nil {
+		db.Update(migrationCollection, query, types.M{"status": statusApplied}, types.M{}, false)
+		return migrationError(m, "down", err)
+	}
+	return db.Destroy(migrationCollection, query, types.M{})
+}
+
+// notSupportedInTransaction 判断是否为事务中执行不支持的操作时返回的错误
+func notSupportedInTransaction(err error) bool {
+	return errs.GetErrorCode(err) == errs.GetErrorCode(storage.ErrNotSupportedInTransaction) &&
+		errs.GetErrorMessage(err) == errs.GetErrorMessage(storage.ErrNotSupportedInTransaction)
+}
+
+// checkInterrupted 存在 running 状态的记录时返回错误，说明之前的迁移在执行过程中异常退出
+func checkInterrupted(applied map[int64]types.M) error {
+	versions := []int64{}
+	for version, record := range applied {
+		if utils.S(record["status"]) == statusRunning {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return errs.E(errs.OperationForbidden, "migration "+strconv.FormatInt(versions[0], 10)+" was interrupted while running, check the data and delete its _Migration record before running migrations again")
+}
+
+// migrationError 在错误信息中加入迁移版本
+func migrationError(m *Migration, direction string, err error) error {
+	code := errs.GetErrorCode(err)
+	if code == 0 {
+		code = errs.OtherCause
+	}
+	return errs.E(code, "migration "+strconv.FormatInt(m.Version, 10)+" "+direction+" failed: "+errs.GetErrorMessage(err))
+}
+
+// appliedMigrations 查询已经执行以及正在执行的迁移，以版本为键
+func appliedMigrations() (map[int64]types.M, error) {
+	results, err := orm.TomatoDBController.Find(migrationCollection, types.M{"version": types.M{"$exists": true}}, types.M{})
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int64]types.M{}
+	for _, v := range results {
+		record := utils.M(v)
+		if record == nil {
+			continue
+		}
+		if version, ok := record["version"].(float64); ok {
+			applied[int64(version)] = record
+		}
+	}
+	return applied, nil
+}
+
+// ensureClass 创建仅允许 master 访问的 _Migration 表
+func ensureClass() error {
+	schema := orm.TomatoDBController.LoadSchema(types.M{"clearCache": true})
+	if schema.HasClass(migrationCollection) {
+		return nil
+	}
+	_, err := schema.AddClassIfNotExists(migrationCollection, types.M{}, migrationCLP, nil)
+	if err != nil && errs.GetErrorCode(err) == errs.InvalidClassName {
+		// 已经由其他节点创建
+		return nil
+	}
+	return err
+}
+
+// lock 迁移执行锁
+type lock struct {
+	owner  string
+	ctx    context.Context // 锁丢失后被取消
+	cancel context.CancelFunc
+	done   chan struct{}
+	mu     sync.Mutex
+	err    error // 后台延长有效期时发现的锁丢失的原因
+}
+
+// acquireLock 获取锁， wait 为 true 时一直等待到获取成功
+func acquireLock(wait bool) (*lock, error) {
+	hostname, _ := os.Hostname()
+	l := &lock{owner: hostname + ":" + utils.CreateObjectID()}
+	for {
+		ok, err := l.tryLock()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			l.keepAlive()
+			return l, nil
+		}
+		if wait == false {
+			return nil, errs.E(errs.OperationForbidden, "Migrations are being run by another server.")
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// tryLock 尝试获取锁，锁不存在时创建，锁已过期时抢占
+func (l *lock) tryLock() (bool, error) {
+	now := time.Now().UTC()
+	object := types.M{
+		"objectId":    lockObjectID,
+		"owner":       l.owner,
+		"lockedUntil": types.M{"__type": "Date", "iso": utils.TimetoString(now.Add(lockTimeout))},
+		// lockdown!
+		"ACL": types.M{},
+	}
+	err := orm.TomatoDBController.Create(migrationCollection, object, types.M{})
+	if err == nil {
+		return true, nil
+	}
+	if errs.GetErrorCode(err) != errs.DuplicateValue {
+		return false, err
+	}
+
+	query := types.M{
+		"objectId":    lockObjectID,
+		"lockedUntil": types.M{"$lt": types.M{"__type": "Date", "iso": utils.TimetoString(now)}},
+	}
+	update := types.M{
+		"owner":       l.owner,
+		"lockedUntil": object["lockedUntil"],
+	}
+	_, err = orm.TomatoDBController.Update(migrationCollection, query, update, types.M{}, false)
+	if err == nil {
+		return true, nil
+	}
+	if errs.GetErrorCode(err) == errs.ObjectNotFound {
+		return false, nil
+	}
+	return false, err
+}
+
+// keepAlive 在后台每隔 lockRefreshInterval 延长锁的有效期，直到 release
+// 锁已被其他节点抢占，或者连续失败直到超过有效期时，记录原因并取消 l.ctx
+func (l *lock) keepAlive() {
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(lockRefreshInterval)
+		defer ticker.Stop()
+		lockedUntil := time.Now().Add(lockTimeout)
+		for {
+			select {
+			case <-l.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := l.refresh()
+			if err == nil {
+				lockedUntil = time.Now().Add(lockTimeout)
+				continue
+			}
+			if errs.GetErrorCode(err) != errs.OperationForbidden && time.Now().Before(lockedUntil) {
+				// 暂时性的错误，下次继续尝试
+				continue
+			}
+			l.mu.Lock()
+			l.err = errs.E(errs.OperationForbidden, "Migration lock has expired.")
+			l.mu.Unlock()
+			l.cancel()
+			return
+		}
+	}()
+}
+
+// lost 锁已丢失时返回错误
+func (l *lock) lost() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// refresh 延长锁的有效期，锁已被其他节点抢占时返回错误
+func (l *lock) refresh() error {
+	query := types.M{"objectId": lockObjectID, "owner": l.owner}
+	update := types.M{"lockedUntil": types.M{"__type": "Date", "iso": utils.TimetoString(time.Now().UTC().Add(lockTimeout))}}
+	_, err := orm.TomatoDBController.Update(migrationCollection, query, update, types.M{}, false)
+	if errs.GetErrorCode(err) == errs.ObjectNotFound {
+		return errs.E(errs.OperationForbidden, "Migration lock has expired.")
+	}
+	return err
+}
+
+// release 停止延长有效期并释放锁
+func (l *lock) release() {
+	l.cancel()
+	<-l.done
+	orm.TomatoDBController.Destroy(migrationCollection, types.M{"objectId": lockObjectID, "owner": l.owner}, types.M{})
+}
@@ -0,0 +1,310 @@
+package migration
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/storage/memory"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+func Test_RegisterJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{
+			name:    "1",
+			data:    `{"version":1,"name":"a","up":[{"op":"createClass","className":"post"}],"down":[{"op":"deleteClass","className":"post"}]}`,
+			wantErr: nil,
+		},
+		{
+			name:    "2",
+			data:    `{"version":1,"up":[{"op":"deleteClass","className":"post"}]}`,
+			wantErr: errs.E(errs.DuplicateValue, "migration 1 already registered"),
+		},
+		{
+			name:    "3",
+			data:    `{"version":1.5,"up":[]}`,
+			wantErr: errs.E(errs.InvalidJSON, "migration version must be an integer"),
+		},
+		{
+			name:    "4",
+			data:    `{"version":2}`,
+			wantErr: errs.E(errs.InvalidJSON, "migration 2 has no up steps"),
+		},
+		{
+			name:    "5",
+			data:    `{"version":2,"up":[{"op":"dropIndex","className":"post"}]}`,
+			wantErr: errs.E(errs.InvalidJSON, "unknown migration op: dropIndex"),
+		},
+		{
+			name:    "6",
+			data:    `{"version":2,"up":[{"op":"update"}]}`,
+			wantErr: errs.E(errs.InvalidJSON, "migration step needs a className"),
+		},
+		{
+			name:    "7",
+			data:    `{"version":0,"up":[]}`,
+			wantErr: errs.E(errs.IncorrectType, "migration version must be greater than 0"),
+		},
+	}
+	migrations = map[int64]*Migration{}
+	defer func() { migrations = map[int64]*Migration{} }()
+	for _, tt := range tests {
+		err := RegisterJSON([]byte(tt.data))
+		if reflect.DeepEqual(err, tt.wantErr) == false {
+			t.Errorf("%q. RegisterJSON() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if m := migrations[1]; m == nil || m.Name != "a" || m.Down == nil {
+		t.Errorf("RegisterJSON() = %v", m)
+	}
+}
+
+func Test_Up_Down(t *testing.T) {
+	orm.InitOrm(memory.NewMemoryAdapter("tomato"))
+	migrations = map[int64]*Migration{}
+	defer func() { migrations = map[int64]*Migration{} }()
+	db := orm.TomatoDBController
+
+	RegisterJSON([]byte(`{
+		"version": 1,
+		"name": "create post",
+		"up": [{"op": "createClass", "className": "post", "fields": {"title": {"type": "String"}}}],
+		"down": [{"op": "delete", "className": "post"}, {"op": "deleteClass", "className": "post"}]
+	}`))
+	Register(&Migration{
+		Version: 2,
+		Name:    "add posts",
+		Up: func(db *orm.DBController) error {
+			return db.Create("post", types.M{"objectId": "01", "title": "a"}, types.M{})
+		},
+		Down: func(db *orm.DBController) error {
+			return db.Destroy("post", types.M{"objectId": "01"}, types.M{})
+		},
+	})
+	RegisterJSON([]byte(`{
+		"version": 3,
+		"name": "backfill status",
+		"up": [
+			{"op": "updateClass", "className": "post", "fields": {"status": {"type": "String"}}},
+			{"op": "update", "className": "post", "update": {"status": "draft"}}
+		]
+	}`))
+
+	/************************************************************/
+	results, err := Up(2)
+	expect := types.S{
+		types.M{"version": int64(1), "name": "create post"},
+		types.M{"version": int64(2), "name": "add posts"},
+	}
+	if err != nil || reflect.DeepEqual(results, expect) == false {
+		t.Error("expect:", expect, "result:", results, err)
+	}
+	status, _ := Status()
+	if len(status) != 3 || utils.M(status[1])["applied"] != true || utils.M(status[2])["applied"] != false {
+		t.Error("expect: 2 applied", "result:", status)
+	}
+
+	/************************************************************/
+	err = Run()
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	objects, _ := db.Find("post", types.M{}, types.M{})
+	if len(objects) != 1 || utils.M(objects[0])["status"] != "draft" {
+		t.Error("expect: status draft", "result:", objects)
+	}
+	locks, _ := db.Find(migrationCollection, types.M{"objectId": lockObjectID}, types.M{})
+	if len(locks) != 0 {
+		t.Error("expect: lock released", "result:", locks)
+	}
+
+	/************************************************************/
+	results, err = Down(0)
+	expect = types.S{}
+	if reflect.DeepEqual(err, errs.E(errs.OperationForbidden, "migration 3 can not be rolled back")) == false || reflect.DeepEqual(results, expect) == false {
+		t.Error("expect:", expect, "result:", results, err)
+	}
+
+	/************************************************************/
+	delete(migrations, 3)
+	db.Destroy(migrationCollection, types.M{"version": float64(3)}, types.M{})
+	results, err = Down(0)
+	expect = types.S{
+		types.M{"version": int64(2), "name": "add posts"},
+		types.M{"version": int64(1), "name": "create post"},
+	}
+	if err != nil || reflect.DeepEqual(results, expect) == false {
+		t.Error("expect:", expect, "result:", results, err)
+	}
+	if db.LoadSchema(types.M{"clearCache": true}).HasClass("post") {
+		t.Error("expect: post deleted")
+	}
+}
+
+func Test_apply(t *testing.T) {
+	orm.InitOrm(memory.NewMemoryAdapter("tomato"))
+	migrations = map[int64]*Migration{}
+	defer func() { migrations = map[int64]*Migration{} }()
+	db := orm.TomatoDBController
+	ensureClass()
+	db.LoadSchema(nil).AddClassIfNotExists("post", types.M{"title": types.M{"type": "String"}}, nil, nil)
+
+	/************************************************************/
+	// 迁移失败时，迁移中的写操作与记录一起回滚
+	Register(&Migration{
+		Version: 1,
+		Name:    "fail",
+		Up: func(db *orm.DBController) error {
+			err := db.Create("post", types.M{"objectId": "01", "title": "a"}, types.M{})
+			if err != nil {
+				return err
+			}
+			return errs.E(errs.OtherCause, "boom")
+		},
+	})
+	_, err := Up(0)
+	expectErr := errs.E(errs.OtherCause, "migration 1 up failed: boom")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	objects, _ := db.Find("post", types.M{}, types.M{})
+	records, _ := db.Find(migrationCollection, types.M{"version": float64(1)}, types.M{})
+	if len(objects) != 0 || len(records) != 0 {
+		t.Error("expect: rolled back", "result:", objects, records)
+	}
+
+	/************************************************************/
+	// 存在 running 状态的记录时，不再自动执行迁移
+	delete(migrations, 1)
+	Register(&Migration{
+		Version: 2,
+		Name:    "add posts",
+		Up: func(db *orm.DBController) error {
+			return db.Create("post", types.M{"objectId": "02", "title": "b"}, types.M{})
+		},
+	})
+	db.Create(migrationCollection, types.M{"objectId": "r1", "version": float64(2), "name": "add posts", "status": statusRunning, "ACL": types.M{}}, types.M{})
+	_, err = Up(0)
+	expectErr = errs.E(errs.OperationForbidden, "migration 2 was interrupted while running, check the data and delete its _Migration record before running migrations again")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	status, _ := Status()
+	if len(status) != 1 || utils.M(status[0])["applied"] != false || utils.M(status[0])["running"] != true {
+		t.Error("expect: running", "result:", status)
+	}
+	objects, _ = db.Find("post", types.M{}, types.M{})
+	if len(objects) != 0 {
+		t.Error("expect:", 0, "result:", objects)
+	}
+
+	/************************************************************/
+	db.Destroy(migrationCollection, types.M{"objectId": "r1"}, types.M{})
+	_, err = Up(0)
+	objects, _ = db.Find("post", types.M{}, types.M{})
+	records, _ = db.Find(migrationCollection, types.M{"version": float64(2)}, types.M{})
+	if err != nil || len(objects) != 1 || len(records) != 1 || utils.M(records[0])["status"] != statusApplied {
+		t.Error("expect: applied", "result:", err, objects, records)
+	}
+}
+
+func Test_acquireLock(t *testing.T) {
+	orm.InitOrm(memory.NewMemoryAdapter("tomato"))
+	ensureClass()
+
+	/************************************************************/
+	l, err := acquireLock(false)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	_, err = acquireLock(false)
+	expect := errs.E(errs.OperationForbidden, "Migrations are being run by another server.")
+	if reflect.DeepEqual(err, expect) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	l.release()
+	l, err = acquireLock(false)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+
+	/************************************************************/
+	// 锁过期后可以被抢占，原持有者无法继续延长有效期
+	expired := types.M{"__type": "Date", "iso": utils.TimetoString(time.Now().UTC().Add(-time.Minute))}
+	orm.TomatoDBController.Update(migrationCollection, types.M{"objectId": lockObjectID}, types.M{"lockedUntil": expired}, types.M{}, false)
+	other, err := acquireLock(false)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	err = l.refresh()
+	expect = errs.E(errs.OperationForbidden, "Migration lock has expired.")
+	if reflect.DeepEqual(err, expect) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	if other != nil {
+		other.release()
+	}
+	l.release()
+}
+
+func Test_keepAlive(t *testing.T) {
+	orm.InitOrm(memory.NewMemoryAdapter("tomato"))
+	migrations = map[int64]*Migration{}
+	timeout, interval := lockTimeout, lockRefreshInterval
+	lockTimeout, lockRefreshInterval = 200*time.Millisecond, 20*time.Millisecond
+	defer func() {
+		migrations = map[int64]*Migration{}
+		lockTimeout, lockRefreshInterval = timeout, interval
+	}()
+	ensureClass()
+	db := orm.TomatoDBController
+	db.LoadSchema(nil).AddClassIfNotExists("post", types.M{"title": types.M{"type": "String"}}, nil, nil)
+
+	/************************************************************/
+	// 执行时间超过有效期的迁移仍然持有锁
+	var err, other error
+	Register(&Migration{
+		Version: 1,
+		Name:    "slow",
+		Up: func(db *orm.DBController) error {
+			time.Sleep(3 * lockTimeout)
+			_, other = acquireLock(false)
+			return db.Create("post", types.M{"objectId": "01", "title": "a"}, types.M{})
+		},
+	})
+	_, err = Up(0)
+	expect := errs.E(errs.OperationForbidden, "Migrations are being run by another server.")
+	if err != nil || reflect.DeepEqual(expect, other) == false {
+		t.Error("expect:", expect, "result:", err, other)
+	}
+
+	/************************************************************/
+	// 锁被其他节点抢占后中止迁移
+	Register(&Migration{
+		Version: 2,
+		Name:    "lost",
+		Up: func(db *orm.DBController) error {
+			orm.TomatoDBController.Update(migrationCollection, types.M{"objectId": lockObjectID}, types.M{"owner": "other"}, types.M{}, false)
+			time.Sleep(5 * lockRefreshInterval)
+			return db.Create("post", types.M{"objectId": "02", "title": "b"}, types.M{})
+		},
+	})
+	_, err = Up(0)
+	expect = errs.E(errs.OperationForbidden, "Migration lock has expired.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	objects, _ := db.Find("post", types.M{}, types.M{})
+	records, _ := db.Find(migrationCollection, types.M{"version": float64(2)}, types.M{})
+	if len(objects) != 1 || len(records) != 0 {
+		t.Error("expect: aborted", "result:", objects, records)
+	}
+}
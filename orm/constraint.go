@@ -0,0 +1,482 @@
+package orm
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// fieldConstraintsOption 类的选项中保存字段约束的内部选项，接口中通过字段定义设置与返回
+// 格式为： {"fieldConstraints": {"title": {"required": true, "maxLength": 20}}}
+const fieldConstraintsOption = "fieldConstraints"
+
+// fieldConstraintKeys 字段定义中支持的约束
+// {"type": "String", "required": true, "defaultValue": "draft", "enum": ["draft", "published"]}
+var fieldConstraintKeys = []string{"required", "defaultValue", "min", "max", "minLength", "maxLength", "pattern", "enum"}
+
+// hasFieldConstraints 字段定义中是否包含约束，包括值为 null 的约束
+func hasFieldConstraints(field types.M) bool {
+	for _, key := range fieldConstraintKeys {
+		if _, ok := field[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitFieldConstraints 把字段定义拆分为类型与约束，值为 null 的约束会被忽略
+func splitFieldConstraints(field types.M) (types.M, types.M) {
+	fieldType := types.M{}
+	constraints := types.M{}
+	for k, v := range field {
+		fieldType[k] = v
+	}
+	for _, key := range fieldConstraintKeys {
+		if v, ok := field[key]; ok {
+			delete(fieldType, key)
+			if v != nil {
+				constraints[key] = v
+			}
+		}
+	}
+	return fieldType, constraints
+}
+
+// splitSchemaFields 拆分 fields 中所有字段的类型与约束，没有约束的字段不出现在返回的约束中
+func splitSchemaFields(fields types.M) (types.M, types.M) {
+	fieldTypes := types.M{}
+	constraints := types.M{}
+	for fieldName, v := range fields {
+		field := utils.M(v)
+		if field == nil {
+			fieldTypes[fieldName] = v
+			continue
+		}
+		fieldType, c := splitFieldConstraints(field)
+		fieldTypes[fieldName] = fieldType
+		if len(c) > 0 {
+			constraints[fieldName] = c
+		}
+	}
+	return fieldTypes, constraints
+}
+
+// fieldConstraintsOf 返回 schema 中保存的字段约束
+func fieldConstraintsOf(schema types.M) types.M {
+	return utils.M(utils.M(schema["options"])[fieldConstraintsOption])
+}
+
+// mergeFieldConstraints 把约束合并到 fields 的字段定义中，不存在的字段会被忽略
+func mergeFieldConstraints(fields, constraints types.M) {
+	for fieldName, c := range constraints {
+		field := utils.M(fields[fieldName])
+		if field == nil {
+			continue
+		}
+		merged := utils.CopyMapM(field)
+		for k, v := range utils.M(c) {
+			merged[k] = v
+		}
+		fields[fieldName] = merged
+	}
+}
+
+// setFieldConstraints 保存类的字段约束， constraints 为空时删除该选项
+func (s *Schema) setFieldConstraints(className string, schema, constraints types.M) error {
+	old := fieldConstraintsOf(schema)
+	if len(old) == 0 && len(constraints) == 0 || reflect.DeepEqual(old, constraints) {
+		return nil
+	}
+	options := types.M{}
+	for k, v := range utils.M(schema["options"]) {
+		options[k] = v
+	}
+	if len(constraints) == 0 {
+		delete(options, fieldConstraintsOption)
+	} else {
+		options[fieldConstraintsOption] = constraints
+	}
+	return s.dbAdapter.SetClassOptions(className, options)
+}
+
+// toNumber 把 JSON 中的数字转换为 float64
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// formatNumber 格式化错误信息中的数字
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// validateFieldConstraints 校验字段定义中的约束是否与字段类型 fieldType 匹配
+func validateFieldConstraints(fieldType string, t types.M) error {
+	_, constraints := splitFieldConstraints(t)
+	for _, key := range fieldConstraintKeys {
+		value, ok := constraints[key]
+		if ok == false {
+			continue
+		}
+		if fieldType == "Relation" {
+			return errs.E(errs.IncorrectType, "type Relation does not support "+key)
+		}
+		switch key {
+		case "required":
+			if _, ok := value.(bool); ok == false {
+				return errs.E(errs.InvalidJSON, "required must be a boolean")
+			}
+		case "defaultValue":
+			expected := types.M{"type": fieldType, "targetClass": t["targetClass"]}
+			tp, err := getType(value)
+			if err != nil || dbTypeMatchesObjectType(expected, tp) == false {
+				return errs.E(errs.IncorrectType, "defaultValue must be of type "+typeToString(expected))
+			}
+		case "min", "max":
+			if fieldType != "Number" {
+				return errs.E(errs.IncorrectType, key+" is only supported on Number fields")
+			}
+			if _, ok := toNumber(value); ok == false {
+				return errs.E(errs.InvalidJSON, key+" must be a number")
+			}
+		case "minLength", "maxLength":
+			if fieldType != "String" && fieldType != "Array" {
+				return errs.E(errs.IncorrectType, key+" is only supported on String and Array fields")
+			}
+			n, ok := toNumber(value)
+			if ok == false || n < 0 || n != float64(int(n)) {
+				return errs.E(errs.InvalidJSON, key+" must be an integer greater than or equal to 0")
+			}
+		case "pattern":
+			if fieldType != "String" {
+				return errs.E(errs.IncorrectType, "pattern is only supported on String fields")
+			}
+			pattern, ok := value.(string)
+			if ok == false {
+				return errs.E(errs.InvalidJSON, "pattern must be a string")
+			}
+			if _, err := regexp.Compile(pattern); err != nil {
+				return errs.E(errs.InvalidJSON, "pattern must be a valid regular expression")
+			}
+		case "enum":
+			if fieldType != "String" && fieldType != "Number" {
+				return errs.E(errs.IncorrectType, "enum is only supported on String and Number fields")
+			}
+			values := utils.A(value)
+			if len(values) == 0 {
+				return errs.E(errs.InvalidJSON, "enum must be a non-empty array")
+			}
+			for _, v := range values {
+				tp, err := getType(v)
+				if err != nil || utils.S(tp["type"]) != fieldType {
+					return errs.E(errs.IncorrectType, "enum values must be of type "+fieldType)
+				}
+			}
+		}
+	}
+
+	for _, keys := range [][2]string{{"min", "max"}, {"minLength", "maxLength"}} {
+		min, hasMin := toNumber(constraints[keys[0]])
+		max, hasMax := toNumber(constraints[keys[1]])
+		if hasMin && hasMax && min > max {
+			return errs.E(errs.InvalidJSON, keys[0]+" must be less than or equal to "+keys[1])
+		}
+	}
+
+	if value, ok := constraints["defaultValue"]; ok {
+		if err := checkFieldValue("defaultValue", value, constraints); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFieldValue 校验字段的值是否满足约束，不校验 null 与 __op 操作
+func checkFieldValue(fieldName string, value interface{}, constraints types.M) error {
+	if value == nil {
+		return nil
+	}
+	if op := utils.M(value); op != nil && op["__op"] != nil {
+		return nil
+	}
+
+	length := -1
+	switch v := value.(type) {
+	case string:
+		length = utf8.RuneCountInString(v)
+		if pattern, ok := constraints["pattern"].(string); ok {
+			if matched, err := regexp.MatchString(pattern, v); err != nil || matched == false {
+				return errs.E(errs.ValidationError, fieldName+" does not match the pattern.")
+			}
+		}
+	case []interface{}, types.S:
+		length = len(utils.A(v))
+	}
+	if length >= 0 {
+		if min, ok := toNumber(constraints["minLength"]); ok && float64(length) < min {
+			return errs.E(errs.ValidationError, "length of "+fieldName+" must be greater than or equal to "+formatNumber(min)+".")
+		}
+		if max, ok := toNumber(constraints["maxLength"]); ok && float64(length) > max {
+			return errs.E(errs.ValidationError, "length of "+fieldName+" must be less than or equal to "+formatNumber(max)+".")
+		}
+	}
+
+	if n, ok := toNumber(value); ok {
+		if min, ok := toNumber(constraints["min"]); ok && n < min {
+			return errs.E(errs.ValidationError, fieldName+" must be greater than or equal to "+formatNumber(min)+".")
+		}
+		if max, ok := toNumber(constraints["max"]); ok && n > max {
+			return errs.E(errs.ValidationError, fieldName+" must be less than or equal to "+formatNumber(max)+".")
+		}
+	}
+
+	if values := utils.A(constraints["enum"]); values != nil {
+		for _, v := range values {
+			if a, ok := toNumber(v); ok {
+				if b, ok := toNumber(value); ok && a == b {
+					return nil
+				}
+			} else if v == value {
+				return nil
+			}
+		}
+		return errs.E(errs.ValidationError, fieldName+" must be one of the enum values.")
+	}
+	return nil
+}
+
+// enforceFieldConstraints 校验对象是否满足字段约束，新建对象时为缺少的字段设置 defaultValue
+// query 中包含 objectId 时为更新对象，只校验更新的字段，必须的字段不能删除
+func (s *Schema) enforceFieldConstraints(className string, object, query types.M) error {
+	s.dataMutex.Lock()
+	fields := utils.M(s.data[className])
+	s.dataMutex.Unlock()
+
+	isUpdate := query != nil && query["objectId"] != nil
+	for fieldName, v := range fields {
+		field := utils.M(v)
+		if field == nil || hasFieldConstraints(field) == false {
+			continue
+		}
+		value, exists := object[fieldName]
+		if isUpdate == false && value == nil && field["defaultValue"] != nil {
+			value = utils.DeepCopy(field["defaultValue"])
+			object[fieldName] = value
+		}
+		if field["required"] == true {
+			missing := value == nil
+			if isUpdate {
+				op := utils.M(value)
+				missing = exists && (value == nil || op != nil && utils.S(op["__op"]) == "Delete")
+			}
+			if missing {
+				return errs.E(errs.ValidationError, fieldName+" is required.")
+			}
+		}
+		if op := utils.M(value); op != nil && op["__op"] != nil {
+			if err := checkFieldOp(fieldName, op, field, isUpdate); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := checkFieldValue(fieldName, value, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// opConstraintKeys __op 操作可能违反的约束
+var opConstraintKeys = map[string][]string{
+	"Increment": {"min", "max", "enum"},
+	"Add":       {"maxLength"},
+	"AddUnique": {"maxLength"},
+	"Remove":    {"minLength"},
+}
+
+// checkFieldOp 校验字段的 __op 操作是否满足约束
+// 新建对象时校验操作的结果，更新对象时无法得到操作之后的值，由 DBController.Update 根据当前值校验
+func checkFieldOp(fieldName string, op, constraints types.M, isUpdate bool) error {
+	if isUpdate {
+		return nil
+	}
+	name := utils.S(op["__op"])
+	switch name {
+	case "Increment", "Add", "AddUnique", "Remove":
+		return checkFieldValue(fieldName, applyFieldOp(nil, op), constraints)
+	}
+	return nil
+}
+
+// applyFieldOp 返回对 value 执行 __op 操作之后的值， value 为空时视为新建
+func applyFieldOp(value interface{}, op types.M) interface{} {
+	switch utils.S(op["__op"]) {
+	case "Increment":
+		n, _ := toNumber(value)
+		amount, _ := toNumber(op["amount"])
+		return n + amount
+	case "Add":
+		result := append(types.S{}, utils.A(value)...)
+		return append(result, utils.A(op["objects"])...)
+	case "AddUnique":
+		result := append(types.S{}, utils.A(value)...)
+		for _, o := range utils.A(op["objects"]) {
+			if containsValue(result, o) == false {
+				result = append(result, o)
+			}
+		}
+		return result
+	case "Remove":
+		result := types.S{}
+		for _, v := range utils.A(value) {
+			if containsValue(utils.A(op["objects"]), v) == false {
+				result = append(result, v)
+			}
+		}
+		return result
+	}
+	return value
+}
+
+// containsValue values 中是否存在与 value 相同的元素
+func containsValue(values types.S, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// opConstraint 更新中可能违反字段约束的 __op 操作
+type opConstraint struct {
+	op          types.M
+	constraints types.M
+}
+
+// constrainedOps 返回 update 中可能违反字段约束的 __op 操作
+func (s *Schema) constrainedOps(className string, update types.M) map[string]opConstraint {
+	s.dataMutex.Lock()
+	fields := utils.M(s.data[className])
+	s.dataMutex.Unlock()
+
+	ops := map[string]opConstraint{}
+	for fieldName, v := range update {
+		op := utils.M(v)
+		field := utils.M(fields[fieldName])
+		if op == nil || field == nil {
+			continue
+		}
+		for _, key := range opConstraintKeys[utils.S(op["__op"])] {
+			if _, ok := field[key]; ok {
+				ops[fieldName] = opConstraint{op: op, constraints: field}
+				break
+			}
+		}
+	}
+	return ops
+}
+
+// checkOpConstraints 读取 query 匹配的对象，校验执行 ops 中的操作之后的值是否满足约束
+// limit 大于 0 时最多读取 limit 个对象，返回读取的对象
+func (d *DBController) checkOpConstraints(className string, schema, query types.M, ops map[string]opConstraint, limit int) ([]types.M, error) {
+	options := types.M{}
+	if limit > 0 {
+		options["limit"] = limit
+	}
+	objects, err := d.adapter().Find(className, schema, query, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		for fieldName, c := range ops {
+			if err := checkFieldValue(fieldName, applyFieldOp(object[fieldName], c.op), c.constraints); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return objects, nil
+}
+
+// incrementConditions 返回 Increment 操作的查询条件，只更新操作之后仍然满足 min 、 max 、 enum 约束的对象
+// 如 max 为 5 ， amount 为 2 时，要求字段的值小于等于 3
+func incrementConditions(ops map[string]opConstraint) types.S {
+	conditions := types.S{}
+	for fieldName, c := range ops {
+		if utils.S(c.op["__op"]) != "Increment" {
+			continue
+		}
+		amount, _ := toNumber(c.op["amount"])
+		condition := types.M{}
+		if max, ok := toNumber(c.constraints["max"]); ok {
+			condition["$lte"] = max - amount
+		}
+		if min, ok := toNumber(c.constraints["min"]); ok {
+			condition["$gte"] = min - amount
+		}
+		if values := utils.A(c.constraints["enum"]); values != nil {
+			in := types.S{}
+			for _, v := range values {
+				if n, ok := toNumber(v); ok {
+					in = append(in, n-amount)
+				}
+			}
+			condition["$in"] = in
+		}
+		if len(condition) == 0 {
+			continue
+		}
+		// 字段不存在时从 0 开始增加
+		if checkFieldValue(fieldName, amount, c.constraints) == nil {
+			conditions = append(conditions, types.M{"$or": types.S{
+				types.M{fieldName: condition},
+				types.M{fieldName: types.M{"$exists": false}},
+			}})
+		} else {
+			conditions = append(conditions, types.M{fieldName: condition})
+		}
+	}
+	return conditions
+}
+
+// opConstraintRetries 更新单个对象时，对象在校验之后被修改的最大重试次数
+const opConstraintRetries = 3
+
+// updateWithConstraints 更新单个对象，根据对象的当前值校验 ops 中的操作
+// 校验之后对象可能被其他请求修改，因此 Increment 通过查询条件限制范围，其他操作要求 updatedAt 没有变化
+// 对象在校验之后被修改时重新读取并校验
+func (d *DBController) updateWithConstraints(className string, schema, query, update types.M, ops map[string]opConstraint) (types.M, error) {
+	for i := 0; ; i++ {
+		objects, err := d.checkOpConstraints(className, schema, query, ops, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(objects) == 0 {
+			return types.M{}, nil
+		}
+		conditions := incrementConditions(ops)
+		for _, c := range ops {
+			if utils.S(c.op["__op"]) == "Increment" {
+				continue
+			}
+			if updatedAt, ok := objects[0]["updatedAt"].(string); ok {
+				conditions = append(conditions, types.M{"updatedAt": types.M{"__type": "Date", "iso": updatedAt}})
+			}
+			break
+		}
+		result, err := d.adapter().FindOneAndUpdate(className, schema, types.M{"$and": append(types.S{query}, conditions...)}, update)
+		if err != nil || len(result) > 0 || i == opConstraintRetries {
+			return result, err
+		}
+	}
+}
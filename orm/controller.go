@@ -2,16 +2,21 @@
 package orm
 
 import (
+	"context"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lfq7413/tomato/cache"
 	"github.com/lfq7413/tomato/config"
 	"github.com/lfq7413/tomato/errs"
 	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/storage/memory"
 	"github.com/lfq7413/tomato/storage/mongo"
 	"github.com/lfq7413/tomato/storage/postgres"
+	"github.com/lfq7413/tomato/storage/sqlite"
 	"github.com/lfq7413/tomato/types"
 	"github.com/lfq7413/tomato/utils"
 )
@@ -30,7 +35,13 @@ func init() {
 	if config.TConfig.DatabaseType == "MongoDB" {
 		Adapter = mongo.NewMongoAdapter("tomato", storage.OpenMongoDB())
 	} else if config.TConfig.DatabaseType == "PostgreSQL" {
-		Adapter = postgres.NewPostgresAdapter("tomato", storage.OpenPostgreSQL())
+		adapter := postgres.NewPostgresAdapter("tomato", storage.OpenPostgreSQL())
+		adapter.SetDatabaseURI(config.TConfig.DatabaseURI)
+		Adapter = adapter
+	} else if config.TConfig.DatabaseType == "SQLite" {
+		Adapter = sqlite.NewSQLiteAdapter("tomato", storage.OpenSQLite())
+	} else if config.TConfig.DatabaseType == "Memory" {
+		Adapter = memory.NewMemoryAdapter("tomato")
 	} else {
 		// 默认连接 MongoDB
 		Adapter = mongo.NewMongoAdapter("tomato", storage.OpenMongoDB())
@@ -41,11 +52,97 @@ func init() {
 
 // DBController 数据库操作类
 type DBController struct {
+	tx      storage.Transaction // 不为空时，所有数据库操作都在该事务中执行
+	schema  *Schema
+	ctx     context.Context // 不为空时，所有数据库操作在 ctx 取消或超时后中止
+	bound   storage.Adapter // 绑定了 ctx 的适配器
+	expired bool            // 为 true 时查询与更新不排除已过期的对象
+}
+
+// adapter 返回当前使用的数据库适配器，处于事务中时返回事务对应的适配器
+func (d *DBController) adapter() storage.Adapter {
+	if d.bound != nil {
+		return d.bound
+	}
+	if d.tx != nil {
+		return d.tx
+	}
+	return Adapter
+}
+
+// WithContext 返回在 ctx 中执行数据库操作的 DBController ，处于事务中时共用同一个事务
+func (d *DBController) WithContext(ctx context.Context) *DBController {
+	adapter := Adapter
+	if d.tx != nil {
+		adapter = d.tx
+	}
+	return &DBController{
+		tx:      d.tx,
+		schema:  d.schema,
+		ctx:     ctx,
+		bound:   adapter.WithContext(ctx),
+		expired: d.expired,
+	}
+}
+
+// Context 返回执行数据库操作使用的 ctx ，未设置时返回 context.Background()
+func (d *DBController) Context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+// SupportsTransactions 数据库是否支持事务，不支持时 Begin 总是返回错误
+func (d *DBController) SupportsTransactions() bool {
+	supported, ok := d.adapter().(storage.TransactionSupport)
+	return ok == false || supported.SupportsTransactions()
+}
+
+// Begin 开始事务，返回的 DBController 中的所有数据库操作都在同一个事务中执行
+func (d *DBController) Begin() (*DBController, error) {
+	if d.tx != nil {
+		return nil, errs.E(errs.OperationForbidden, "Transaction already started.")
+	}
+	// 设置了 ctx 时，事务中的操作同样在 ctx 取消或超时后中止
+	adapter := Adapter
+	if d.bound != nil {
+		adapter = d.bound
+	}
+	tx, err := adapter.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &DBController{tx: tx, ctx: d.ctx, expired: d.expired}, nil
+}
+
+// Commit 提交事务
+func (d *DBController) Commit() error {
+	if d.tx == nil {
+		return errs.E(errs.OperationForbidden, "Transaction not started.")
+	}
+	err := d.tx.Commit()
+	if err != nil {
+		return err
+	}
+	// 事务中可能修改了表结构，提交之后清除全局缓存并重新加载
+	schemaCache.Clear()
+	schemaPromise = nil
+	return nil
+}
+
+// Rollback 回滚事务
+func (d *DBController) Rollback() error {
+	if d.tx == nil {
+		return errs.E(errs.OperationForbidden, "Transaction not started.")
+	}
+	// 事务中的表结构不会写入全局缓存，无需清除
+	return d.tx.Rollback()
 }
 
 // CollectionExists 检测表是否存在
 func (d *DBController) CollectionExists(className string) bool {
-	return Adapter.ClassExists(className)
+	return d.adapter().ClassExists(className)
 }
 
 // PurgeCollection 清除类
@@ -55,12 +152,15 @@ func (d *DBController) PurgeCollection(className string) error {
 	if err != nil {
 		return err
 	}
-	return Adapter.DeleteObjectsByQuery(className, sch, types.M{})
+	return d.adapter().DeleteObjectsByQuery(className, sch, types.M{})
 }
 
 // Find 从指定表中查询数据，查询到的数据放入 list 中
 // 如果查询的是 count ，结果也会放入 list，并且只有这一个元素
-// options 中的选项包括：skip、limit、sort、keys、count、acl
+// 如果设置了 pipeline ，返回聚合管道的输出，如果设置了 distinct ，返回该字段的不同取值
+// 如果设置了 explain ，不执行查询，返回转换后的查询条件以及数据库的执行计划
+// options 中的选项包括：skip、limit、sort、keys、count、acl、pipeline、distinct、explain
+// 查询条件中包含 $text 时，sort 与 keys 中可以使用 $score 表示全文检索的相关度
 func (d *DBController) Find(className string, query, options types.M) (types.S, error) {
 	if options == nil {
 		options = types.M{}
@@ -108,6 +208,43 @@ func (d *DBController) Find(className string, query, options types.M) (types.S,
 		classExists = false
 		parseFormatSchema["fields"] = types.M{}
 	}
+	encrypted := encryptedFields(parseFormatSchema)
+
+	// 校验聚合管道，管道最前面的 $match 合并到查询条件中
+	var pipeline types.S
+	if v, ok := options["pipeline"]; ok && classExists {
+		// 加密字段的值是密文，不能在管道中使用
+		fields := types.M{}
+		for fieldName, tp := range utils.M(parseFormatSchema["fields"]) {
+			if _, isEncrypted := encrypted[fieldName]; isEncrypted == false {
+				fields[fieldName] = tp
+			}
+		}
+		matches, stages, err := validatePipeline(className, v, fields, isMaster)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) > 0 {
+			if len(query) > 0 {
+				matches = append(types.S{query}, matches...)
+			}
+			if len(matches) == 1 {
+				query = utils.M(matches[0])
+			} else {
+				query = types.M{"$and": matches}
+			}
+		}
+		pipeline = stages
+	}
+	distinct, isDistinct := options["distinct"].(string)
+	if isDistinct && classExists {
+		fields := utils.M(parseFormatSchema["fields"])
+		tp, ok := fields[distinct]
+		_, isEncrypted := encrypted[distinct]
+		if ok == false || isEncrypted || aggregatableField(className, distinct, utils.M(tp), isMaster) == false {
+			return nil, errs.E(errs.InvalidKeyName, "Invalid field name: "+distinct)
+		}
+	}
 
 	if keys, ok := options["sort"].([]string); ok {
 		for i, key := range keys {
@@ -118,6 +255,15 @@ func (d *DBController) Find(className string, query, options types.M) (types.S,
 				key = key[1:]
 			}
 
+			// $score 表示按全文检索的相关度排序
+			if key == storage.TextScoreKey {
+				if storage.HasTextSearch(query) == false {
+					return nil, errs.E(errs.InvalidQuery, "$score can only be used with $text queries.")
+				}
+				keys[i] = prefix + key
+				continue
+			}
+
 			if key == "_created_at" {
 				key = "createdAt"
 			} else if key == "_updated_at" {
@@ -132,10 +278,21 @@ func (d *DBController) Find(className string, query, options types.M) (types.S,
 				return nil, errs.E(errs.InvalidKeyName, "Invalid field name: "+key)
 			}
 
+			if _, ok := encrypted[key]; ok {
+				return nil, errs.E(errs.InvalidKeyName, "Cannot sort by encrypted field "+key)
+			}
+
 			keys[i] = prefix + key
 		}
 		options["sort"] = keys
 	}
+	if keys, ok := options["keys"].([]string); ok {
+		for _, key := range keys {
+			if key == storage.TextScoreKey && storage.HasTextSearch(query) == false {
+				return nil, errs.E(errs.InvalidQuery, "$score can only be used with $text queries.")
+			}
+		}
+	}
 
 	// 校验当前用户是否能对表进行 find 或者 get 操作
 	if isMaster == false {
@@ -169,17 +326,46 @@ func (d *DBController) Find(className string, query, options types.M) (types.S,
 		query = addReadACL(query, aclGroup)
 	}
 
-	err = validateQuery(query)
+	err = validateQuery(query, encrypted)
 	if err != nil {
 		return nil, err
 	}
+	query, err = encryptQuery(className, encrypted, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// 开启软删除的类中排除已删除的对象， trash 为 true 时只查找已删除的对象
+	trash := options["trash"] == true
+	if SoftDeleteEnabled(parseFormatSchema) {
+		query = excludeDeleted(query, trash)
+	} else if trash {
+		return nil, errs.E(errs.OperationForbidden, "Soft delete is not enabled on class "+className+".")
+	}
+	// 开启过期的类中排除已过期的对象
+	if ttl := TTLOf(parseFormatSchema); ttl != nil && d.expired == false {
+		query = excludeExpired(query, ttl)
+	}
+
+	// 获取执行计划， query 为处理 relation 与 acl 之后的查询条件， native 为数据库返回的结果
+	if options["explain"] != nil {
+		explain := types.M{"query": query}
+		if classExists {
+			native, err := d.adapter().Explain(className, parseFormatSchema, query, options)
+			if err != nil {
+				return nil, err
+			}
+			explain["native"] = native
+		}
+		return types.S{explain}, nil
+	}
 
 	// 获取 count
 	if options["count"] != nil {
 		if classExists == false {
 			return types.S{0}, nil
 		}
-		count, err := Adapter.Count(className, parseFormatSchema, query)
+		count, err := d.adapter().Count(className, parseFormatSchema, query)
 		if err != nil {
 			return nil, err
 		}
@@ -190,8 +376,33 @@ func (d *DBController) Find(className string, query, options types.M) (types.S,
 		return types.S{}, nil
 	}
 
+	if isDistinct {
+		return d.adapter().Distinct(className, parseFormatSchema, query, distinct)
+	}
+
+	if pipeline != nil {
+		objects, err := d.adapter().Aggregate(className, parseFormatSchema, query, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		results := types.S{}
+		for _, object := range objects {
+			if storage.PipelineTransformsObjects(pipeline) == false {
+				object = untransformObjectACL(object)
+				object = filterSensitiveData(isMaster, aclGroup, className, object)
+				delete(object, softDeleteField)
+				err = decryptObject(className, encrypted, object)
+				if err != nil {
+					return nil, err
+				}
+			}
+			results = append(results, object)
+		}
+		return results, nil
+	}
+
 	// 执行查询操作
-	objects, err := Adapter.Find(className, parseFormatSchema, query, options)
+	objects, err := d.adapter().Find(className, parseFormatSchema, query, options)
 	if err != nil {
 		return nil, err
 	}
@@ -199,6 +410,14 @@ func (d *DBController) Find(className string, query, options types.M) (types.S,
 	for _, object := range objects {
 		object = untransformObjectACL(object)
 		result := filterSensitiveData(isMaster, aclGroup, className, object)
+		if deletedAt, ok := result[softDeleteField]; ok && trash {
+			result["deletedAt"] = deletedAt
+		}
+		delete(result, softDeleteField)
+		err = decryptObject(className, encrypted, result)
+		if err != nil {
+			return nil, err
+		}
 		results = append(results, result)
 	}
 	return results, nil
@@ -241,20 +460,30 @@ func (d *DBController) Destroy(className string, query types.M, options types.M)
 		query = addWriteACL(query, aclGroup)
 	}
 
-	err := validateQuery(query)
+	parseFormatSchema, err := schema.GetOneSchema(className, false, nil)
 	if err != nil {
 		return err
 	}
+	if len(parseFormatSchema) == 0 {
+		parseFormatSchema["fields"] = types.M{}
+	}
 
-	parseFormatSchema, err := schema.GetOneSchema(className, false, nil)
+	encrypted := encryptedFields(parseFormatSchema)
+	err = validateQuery(query, encrypted)
 	if err != nil {
 		return err
 	}
-	if len(parseFormatSchema) == 0 {
-		parseFormatSchema["fields"] = types.M{}
+	query, err = encryptQuery(className, encrypted, query)
+	if err != nil {
+		return err
 	}
 
-	err = Adapter.DeleteObjectsByQuery(className, parseFormatSchema, query)
+	// 开启软删除的类中只设置删除时间
+	if SoftDeleteEnabled(parseFormatSchema) {
+		return d.softDelete(className, parseFormatSchema, query)
+	}
+
+	err = d.adapter().DeleteObjectsByQuery(className, parseFormatSchema, query)
 	if err != nil {
 		// 排除 _Session，避免在修改密码时因为没有 Session 失败
 		if className == "_Session" && errs.GetErrorCode(err) == errs.ObjectNotFound {
@@ -266,6 +495,118 @@ func (d *DBController) Destroy(className string, query types.M, options types.M)
 	return nil
 }
 
+// softDelete 设置符合条件的对象的删除时间，没有符合条件的对象时返回 ObjectNotFound
+func (d *DBController) softDelete(className string, schema, query types.M) error {
+	query = excludeDeleted(query, false)
+	count, err := d.adapter().Count(className, schema, query)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errs.E(errs.ObjectNotFound, "Object not found.")
+	}
+	update := types.M{
+		softDeleteField: types.M{
+			"__type": "Date",
+			"iso":    utils.TimetoString(time.Now().UTC()),
+		},
+	}
+	return d.adapter().UpdateObjectsByQuery(className, schema, query, update)
+}
+
+// excludeDeleted 在查询条件中排除已删除的对象， deleted 为 true 时只保留已删除的对象
+func excludeDeleted(query types.M, deleted bool) types.M {
+	query = utils.CopyMap(query)
+	constraint := types.M{}
+	if c := utils.M(query[softDeleteField]); c != nil {
+		constraint = utils.CopyMap(c)
+	}
+	constraint["$exists"] = deleted
+	query[softDeleteField] = constraint
+	return query
+}
+
+// trashSchema 获取开启了软删除的类的 schema
+func (d *DBController) trashSchema(className string) (types.M, error) {
+	schema, err := d.LoadSchema(nil).GetOneSchema(className, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	if SoftDeleteEnabled(schema) == false {
+		return nil, errs.E(errs.OperationForbidden, "Soft delete is not enabled on class "+className+".")
+	}
+	return schema, nil
+}
+
+// Restore 恢复已删除的对象，返回恢复后的对象
+func (d *DBController) Restore(className, objectID string) (types.M, error) {
+	schema, err := d.trashSchema(className)
+	if err != nil {
+		return nil, err
+	}
+	query := excludeDeleted(types.M{"objectId": objectID}, true)
+	object, err := d.adapter().FindOneAndUpdate(className, schema, query, types.M{softDeleteField: types.M{"__op": "Delete"}})
+	if err != nil {
+		return nil, err
+	}
+	if len(object) == 0 {
+		return nil, errs.E(errs.ObjectNotFound, "Object not found.")
+	}
+	object = untransformObjectACL(object)
+	delete(object, softDeleteField)
+	return object, nil
+}
+
+// PurgeTrash 永久删除回收站中符合条件的对象，返回删除的数量
+func (d *DBController) PurgeTrash(className string, query types.M) (int, error) {
+	schema, err := d.trashSchema(className)
+	if err != nil {
+		return 0, err
+	}
+	query = excludeDeleted(query, true)
+	count, err := d.adapter().Count(className, schema, query)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+	err = d.adapter().DeleteObjectsByQuery(className, schema, query)
+	if err != nil && errs.GetErrorCode(err) != errs.ObjectNotFound {
+		return 0, err
+	}
+	return count, nil
+}
+
+// PurgeExpiredTrash 永久删除所有类中超过保留天数的已删除对象，返回每个类中删除的数量
+// 保留天数为类选项中的 retentionDays ，未设置时使用 SoftDeleteRetentionDays
+func (d *DBController) PurgeExpiredTrash() (types.M, error) {
+	schemas, err := d.LoadSchema(types.M{"clearCache": true}).GetAllClasses(nil)
+	if err != nil {
+		return nil, err
+	}
+	results := types.M{}
+	for _, schema := range schemas {
+		if SoftDeleteEnabled(schema) == false {
+			continue
+		}
+		className := utils.S(schema["className"])
+		days := config.TConfig.SoftDeleteRetentionDays
+		if v, ok := utils.M(utils.M(schema["options"])["softDelete"])["retentionDays"].(float64); ok {
+			days = int(v)
+		}
+		expiredAt := time.Now().UTC().AddDate(0, 0, -days)
+		query := types.M{
+			softDeleteField: types.M{
+				"$lt": types.M{"__type": "Date", "iso": utils.TimetoString(expiredAt)},
+			},
+		}
+		count, err := d.PurgeTrash(className, query)
+		if err != nil {
+			return results, err
+		}
+		results[className] = count
+	}
+	return results, nil
+}
+
 var specialKeysForUpdate = map[string]bool{
 	"_hashed_password":               true,
 	"_perishable_token":              true,
@@ -339,17 +680,28 @@ func (d *DBController) Update(className string, query, update, options types.M,
 		query = addWriteACL(query, aclGroup)
 	}
 
-	err := validateQuery(query)
+	sch, err := schema.GetOneSchema(className, false, nil)
 	if err != nil {
 		return nil, err
 	}
+	if len(sch) == 0 {
+		sch["fields"] = types.M{}
+	}
 
-	sch, err := schema.GetOneSchema(className, false, nil)
+	encrypted := encryptedFields(sch)
+	err = validateQuery(query, encrypted)
 	if err != nil {
 		return nil, err
 	}
-	if len(sch) == 0 {
-		sch["fields"] = types.M{}
+	query, err = encryptQuery(className, encrypted, query)
+	if err != nil {
+		return nil, err
+	}
+	if SoftDeleteEnabled(sch) && upsert == false {
+		query = excludeDeleted(query, false)
+	}
+	if ttl := TTLOf(sch); ttl != nil && upsert == false && d.expired == false {
+		query = excludeExpired(query, ttl)
 	}
 
 	for fieldName, v := range update {
@@ -370,24 +722,61 @@ func (d *DBController) Update(className string, query, update, options types.M,
 		}
 	}
 
+	// 可能违反字段约束的操作，需要根据对象的当前值校验
+	constrained := schema.constrainedOps(className, update)
+
 	update = transformObjectACL(update)
 	transformAuthData(className, update, sch)
+	err = encryptObject(className, encrypted, update)
+	if err != nil {
+		return nil, err
+	}
 	var result types.M
 	if many {
-		err := Adapter.UpdateObjectsByQuery(className, sch, query, update)
+		if len(constrained) > 0 {
+			_, err := d.checkOpConstraints(className, sch, query, constrained, 0)
+			if err != nil {
+				return nil, err
+			}
+			if conditions := incrementConditions(constrained); len(conditions) > 0 {
+				query = types.M{"$and": append(types.S{query}, conditions...)}
+			}
+		}
+		err := d.adapter().UpdateObjectsByQuery(className, sch, query, update)
 		if err != nil {
 			return nil, err
 		}
 		result = types.M{}
 	} else if upsert {
-		err := Adapter.UpsertOneObject(className, sch, query, update)
+		if len(constrained) > 0 {
+			objects, err := d.checkOpConstraints(className, sch, query, constrained, 1)
+			if err != nil {
+				return nil, err
+			}
+			// 对象不存在时新建对象，校验操作的结果
+			for fieldName, c := range constrained {
+				if len(objects) > 0 {
+					break
+				}
+				if err := checkFieldOp(fieldName, c.op, c.constraints, false); err != nil {
+					return nil, err
+				}
+			}
+		}
+		err := d.adapter().UpsertOneObject(className, sch, query, update)
 		if err != nil {
 			return nil, err
 		}
 		result = types.M{}
+	} else if len(constrained) > 0 {
+		var err error
+		result, err = d.updateWithConstraints(className, sch, query, update, constrained)
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		var err error
-		result, err = Adapter.FindOneAndUpdate(className, sch, query, update)
+		result, err = d.adapter().FindOneAndUpdate(className, sch, query, update)
 		if err != nil {
 			return nil, err
 		}
@@ -397,6 +786,10 @@ func (d *DBController) Update(className string, query, update, options types.M,
 	if many == false && upsert == false && len(result) == 0 {
 		return nil, errs.E(errs.ObjectNotFound, "Object not found.")
 	}
+	err = decryptObject(className, encrypted, result)
+	if err != nil {
+		return nil, err
+	}
 
 	err = d.handleRelationUpdates(className, utils.S(originalQuery["objectId"]), update, relationUpdates)
 	if err != nil {
@@ -445,23 +838,7 @@ func (d *DBController) Create(className string, object, options types.M) error {
 	if object == nil {
 		object = types.M{}
 	}
-	// 复制数据，不要修改原数据
-	object = utils.CopyMapM(object)
-
-	object = transformObjectACL(object)
-
-	if v, ok := object["createdAt"]; ok {
-		object["createdAt"] = types.M{
-			"__type": "Date",
-			"iso":    v,
-		}
-	}
-	if v, ok := object["updatedAt"]; ok {
-		object["updatedAt"] = types.M{
-			"__type": "Date",
-			"iso":    v,
-		}
-	}
+	object = prepareObjectForCreate(object)
 
 	isMaster := false
 	aclGroup := []string{}
@@ -502,9 +879,13 @@ func (d *DBController) Create(className string, object, options types.M) error {
 
 	transformAuthData(className, object, sch)
 	flattenUpdateOperatorsForCreate(object)
+	err = encryptObject(className, encryptedFields(sch), object)
+	if err != nil {
+		return err
+	}
 
 	// 无需调用 sanitizeDatabaseResult
-	err = Adapter.CreateObject(className, convertSchemaToAdapterSchema(sch), object)
+	err = d.adapter().CreateObject(className, convertSchemaToAdapterSchema(sch), object)
 	if err != nil {
 		return err
 	}
@@ -512,6 +893,117 @@ func (d *DBController) Create(className string, object, options types.M) error {
 	return d.handleRelationUpdates(className, "", object, relationUpdates)
 }
 
+// CreateObjects 批量创建对象，权限与类只检查一次，所有对象通过适配器的 CreateObjects 一次写入
+// 写入之前出错时不创建任何对象，返回 err ；写入之后处理 Relation 出错时对象已经创建，errList 中保存每个对象的错误
+// 适配器无法确定哪些对象已经写入时，errList 中每个对象的错误均为 storage.ErrBulkCreateIncomplete
+func (d *DBController) CreateObjects(className string, objects []types.M, options types.M) ([]error, error) {
+	if options == nil {
+		options = types.M{}
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	isMaster := false
+	aclGroup := []string{}
+	if acl, ok := options["acl"]; ok {
+		if v, ok := acl.([]string); ok {
+			aclGroup = v
+		}
+	} else {
+		isMaster = true
+	}
+
+	err := d.validateClassName(className)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := d.LoadSchema(nil)
+	if isMaster == false {
+		err := schema.validatePermission(className, aclGroup, "create")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = schema.EnforceClassExists(className)
+	if err != nil {
+		return nil, err
+	}
+
+	schema.reloadData(nil)
+
+	sch, err := schema.GetOneSchema(className, true, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := encryptedFields(sch)
+	created := []types.M{}
+	relationUpdates := [][]types.M{}
+	for _, object := range objects {
+		object = prepareObjectForCreate(object)
+		relationUpdates = append(relationUpdates, d.collectRelationUpdates(className, "", object))
+		transformAuthData(className, object, sch)
+		flattenUpdateOperatorsForCreate(object)
+		err = encryptObject(className, fields, object)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, object)
+	}
+
+	errList := make([]error, len(created))
+	err = d.adapter().CreateObjects(className, convertSchemaToAdapterSchema(sch), created)
+	if isBulkCreateIncomplete(err) {
+		// 部分对象可能已经写入，不能当作没有创建任何对象
+		for i := range errList {
+			errList[i] = err
+		}
+		return errList, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, object := range created {
+		errList[i] = d.handleRelationUpdates(className, "", object, relationUpdates[i])
+	}
+	return errList, nil
+}
+
+// isBulkCreateIncomplete 判断是否为无法确定哪些对象已经写入的批量创建错误
+func isBulkCreateIncomplete(err error) bool {
+	return err != nil && errs.GetErrorCode(err) == errs.GetErrorCode(storage.ErrBulkCreateIncomplete) &&
+		errs.GetErrorMessage(err) == errs.GetErrorMessage(storage.ErrBulkCreateIncomplete)
+}
+
+// prepareObjectForCreate 复制要创建的对象，并转换其中的 ACL 与时间字段
+func prepareObjectForCreate(object types.M) types.M {
+	if object == nil {
+		object = types.M{}
+	}
+	// 复制数据，不要修改原数据
+	object = utils.CopyMapM(object)
+
+	object = transformObjectACL(object)
+
+	if v, ok := object["createdAt"]; ok {
+		object["createdAt"] = types.M{
+			"__type": "Date",
+			"iso":    v,
+		}
+	}
+	if v, ok := object["updatedAt"]; ok {
+		object["updatedAt"] = types.M{
+			"__type": "Date",
+			"iso":    v,
+		}
+	}
+	return object
+}
+
 // validateClassName 校验表名是否合法
 func (d *DBController) validateClassName(className string) error {
 	if ClassNameIsValid(className) == false {
@@ -640,7 +1132,7 @@ func (d *DBController) addRelation(key, fromClassName, fromID, toID string) erro
 		"owningId":  fromID,
 	}
 	className := "_Join:" + key + ":" + fromClassName
-	return Adapter.UpsertOneObject(className, relationSchema, doc, doc)
+	return d.adapter().UpsertOneObject(className, relationSchema, doc, doc)
 }
 
 // removeRelation 把对象 id 从 _Join 表中删除，表名为 _Join:key:fromClassName
@@ -650,7 +1142,7 @@ func (d *DBController) removeRelation(key, fromClassName, fromID, toID string) e
 		"owningId":  fromID,
 	}
 	className := "_Join:" + key + ":" + fromClassName
-	err := Adapter.DeleteObjectsByQuery(className, relationSchema, doc)
+	err := d.adapter().DeleteObjectsByQuery(className, relationSchema, doc)
 	if err != nil {
 		if errs.GetErrorCode(err) == errs.ObjectNotFound {
 			return nil
@@ -697,6 +1189,14 @@ func (d *DBController) LoadSchema(options types.M) *Schema {
 	if options == nil {
 		options = types.M{"clearCache": false}
 	}
+	if d.tx != nil {
+		// 事务中使用独立的 Schema ，表结构的修改在同一个事务中执行
+		// 不使用全局缓存，避免未提交的表结构被其他请求读取
+		if c, ok := options["clearCache"].(bool); (ok && c) || d.schema == nil {
+			d.schema = Load(d.tx, cache.NewSchemaCache(-1, false), options)
+		}
+		return d.schema
+	}
 	if c, ok := options["clearCache"].(bool); ok && c {
 		schemaPromise = Load(Adapter, schemaCache, options)
 		return schemaPromise
@@ -711,7 +1211,8 @@ func (d *DBController) LoadSchema(options types.M) *Schema {
 func (d *DBController) DeleteEverything() {
 	schemaCache.Clear()
 	schemaPromise = nil
-	Adapter.DeleteAllClasses()
+	resetIdempotencyClass()
+	d.adapter().DeleteAllClasses()
 }
 
 // RedirectClassNameForKey 返回指定类的字段所对应的类型
@@ -823,7 +1324,7 @@ func (d *DBController) reduceRelationKeys(className string, query types.M) types
 // relatedIds 从 Join 表中查询 ids ，表名：_Join:key:className
 func (d *DBController) relatedIds(className, key, owningID string) types.S {
 	ids := types.S{}
-	results, err := Adapter.Find(joinTableName(className, key), relationSchema, types.M{"owningId": owningID}, types.M{})
+	results, err := d.adapter().Find(joinTableName(className, key), relationSchema, types.M{"owningId": owningID}, types.M{})
 	if err != nil {
 		return ids
 	}
@@ -1117,7 +1618,7 @@ func (d *DBController) owningIds(className, key string, relatedIds types.S) type
 			"$in": relatedIds,
 		},
 	}
-	results, err := Adapter.Find(joinTableName(className, key), relationSchema, query, types.M{})
+	results, err := d.adapter().Find(joinTableName(className, key), relationSchema, query, types.M{})
 	if err != nil {
 		return ids
 	}
@@ -1184,7 +1685,7 @@ func (d *DBController) DeleteSchema(className string) error {
 
 	exist := d.CollectionExists(className)
 	if exist {
-		count, err := Adapter.Count(className, types.M{"fields": types.M{}}, types.M{})
+		count, err := d.adapter().Count(className, types.M{"fields": types.M{}}, types.M{})
 		if err != nil {
 			return err
 		}
@@ -1193,7 +1694,7 @@ func (d *DBController) DeleteSchema(className string) error {
 		}
 	}
 
-	result, err := Adapter.DeleteClass(className)
+	result, err := d.adapter().DeleteClass(className)
 	if err != nil {
 		return err
 	}
@@ -1202,7 +1703,7 @@ func (d *DBController) DeleteSchema(className string) error {
 			for fieldName, v := range fields {
 				if fieldType := utils.M(v); fieldType != nil {
 					if utils.S(fieldType["type"]) == "Relation" {
-						_, err = Adapter.DeleteClass(joinTableName(className, fieldName))
+						_, err = d.adapter().DeleteClass(joinTableName(className, fieldName))
 						if err != nil {
 							return err
 						}
@@ -1310,10 +1811,314 @@ func (d *DBController) PerformInitialization() {
 
 	d.LoadSchema(nil).EnforceClassExists("_User")
 	d.LoadSchema(nil).EnforceClassExists("_Role")
-	Adapter.EnsureUniqueness("_User", requiredUserFields, []string{"username"})
-	Adapter.EnsureUniqueness("_User", requiredUserFields, []string{"email"})
-	Adapter.EnsureUniqueness("_Role", requiredRoleFields, []string{"name"})
-	Adapter.PerformInitialization(types.M{"VolatileClassesSchemas": volatileClassesSchemas()})
+	d.adapter().EnsureUniqueness("_User", requiredUserFields, []string{"username"})
+	d.adapter().EnsureUniqueness("_User", requiredUserFields, []string{"email"})
+	d.adapter().EnsureUniqueness("_Role", requiredRoleFields, []string{"name"})
+	d.adapter().PerformInitialization(types.M{"VolatileClassesSchemas": volatileClassesSchemas()})
+}
+
+// WatchChanges 监听数据库中 classNames 对象的变更，当前数据库不支持时返回错误
+// 变更中的对象转换为 API 格式，并删除 _User 中的敏感字段
+func (d *DBController) WatchChanges(ctx context.Context, name string, classNames []string, handler func(*storage.ChangeEvent) error) error {
+	feed, ok := d.adapter().(storage.ChangeFeed)
+	if ok == false {
+		return errs.E(errs.OperationForbidden, "Change feed is not supported by this database.")
+	}
+	return feed.WatchChanges(ctx, name, classNames, func(event *storage.ChangeEvent) error {
+		// 软删除与恢复分别转换为删除与新建，已删除对象的变更不通知
+		if event.Object[softDeleteField] != nil {
+			if event.Type == storage.ChangeDelete || event.Original[softDeleteField] != nil {
+				return nil
+			}
+			event.Type = storage.ChangeDelete
+			event.Original = nil
+		} else if event.Original[softDeleteField] != nil {
+			event.Original = nil
+		}
+		sch, err := d.LoadSchema(nil).GetOneSchema(event.ClassName, false, nil)
+		if err != nil {
+			return err
+		}
+		encrypted := encryptedFields(sch)
+		for _, object := range []types.M{event.Object, event.Original} {
+			if object == nil {
+				continue
+			}
+			err = decryptObject(event.ClassName, encrypted, object)
+			if err != nil {
+				return err
+			}
+			untransformObjectACL(object)
+			filterSensitiveData(false, []string{}, event.ClassName, object)
+			delete(object, softDeleteField)
+			if event.ClassName == "_User" {
+				delete(object, "password")
+			}
+		}
+		return handler(event)
+	})
+}
+
+// exportBatchSize 导出时每次读取的对象数量
+const exportBatchSize = 1000
+
+// importBatchSize 导入时每个事务中写入的对象数量
+const importBatchSize = 100
+
+// ExportClass 导出类的 schema 、所有对象以及 Relation 字段对应的 _Join 表，每一行调用一次 handler
+// 第一行为 {"className": "post", "schema": {...}} ，之后每个对象一行 {"className": "post", "object": {...}}
+// _Join 表中的数据为 {"className": "_Join:key:post", "object": {"owningId": "...", "relatedId": "..."}}
+// 对象按 objectId 分批读取，保留 ACL 与内部字段，用于通过 ImportClass 导入
+func (d *DBController) ExportClass(className string, handler func(line types.M) error) error {
+	schema := d.LoadSchema(types.M{"clearCache": true})
+	sch, err := schema.GetOneSchema(className, false, types.M{"clearCache": true})
+	if err != nil || len(sch) == 0 {
+		return errs.E(errs.InvalidClassName, "Class "+className+" does not exist.")
+	}
+	exportSchema := types.M{
+		"fields":                sch["fields"],
+		"classLevelPermissions": sch["classLevelPermissions"],
+		"indexes":               sch["indexes"],
+	}
+	// 字段约束包含在字段定义中
+	if options := utils.M(HideInternalFields(sch)["options"]); len(options) > 0 {
+		exportSchema["options"] = options
+	}
+	err = handler(types.M{"className": className, "schema": exportSchema})
+	if err != nil {
+		return err
+	}
+
+	relations := []string{}
+	for fieldName, v := range utils.M(sch["fields"]) {
+		if utils.S(utils.M(v)["type"]) == "Relation" {
+			relations = append(relations, fieldName)
+		}
+	}
+
+	last := ""
+	for {
+		query := types.M{}
+		if last != "" {
+			query["objectId"] = types.M{"$gt": last}
+		}
+		objects, err := d.adapter().Find(className, sch, query, types.M{"sort": []string{"objectId"}, "limit": exportBatchSize})
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			last = utils.S(object["objectId"])
+			// Relation 字段的数据保存在 _Join 表中
+			for _, fieldName := range relations {
+				delete(object, fieldName)
+			}
+			err = handler(types.M{"className": className, "object": untransformObjectACL(object)})
+			if err != nil {
+				return err
+			}
+		}
+		if len(objects) < exportBatchSize {
+			break
+		}
+	}
+
+	for _, fieldName := range relations {
+		err = d.exportJoinTable(joinTableName(className, fieldName), handler)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportJoinTable 按 owningId 、 relatedId 分批导出 _Join 表
+func (d *DBController) exportJoinTable(joinClassName string, handler func(line types.M) error) error {
+	owningID, relatedID := "", ""
+	for {
+		query := types.M{}
+		if owningID != "" {
+			query["$or"] = types.S{
+				types.M{"owningId": types.M{"$gt": owningID}},
+				types.M{"owningId": owningID, "relatedId": types.M{"$gt": relatedID}},
+			}
+		}
+		objects, err := d.adapter().Find(joinClassName, relationSchema, query, types.M{"sort": []string{"owningId", "relatedId"}, "limit": exportBatchSize})
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			owningID = utils.S(object["owningId"])
+			relatedID = utils.S(object["relatedId"])
+			err = handler(types.M{
+				"className": joinClassName,
+				"object":    types.M{"owningId": owningID, "relatedId": relatedID},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(objects) < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+// ImportClass 导入 ExportClass 导出的数据， next 依次返回每一行，读取完毕时返回 io.EOF
+// 第一行必须为 schema ，通过 AddClassIfNotExists 创建类，类已经存在时返回错误
+// 对象保留原有的 objectId 、 createdAt 、 updatedAt 与 ACL ，每 importBatchSize 个对象在一个事务中写入，适配器不支持事务时直接写入
+// 返回导入的对象数量，出错时已经提交的对象不会撤销
+func (d *DBController) ImportClass(className string, next func() (types.M, error)) (int, error) {
+	line, err := next()
+	if err == io.EOF {
+		return 0, errs.E(errs.InvalidJSON, "Import data is empty.")
+	}
+	if err != nil {
+		return 0, err
+	}
+	sch := utils.M(line["schema"])
+	if sch == nil || utils.S(line["className"]) != className {
+		return 0, errs.E(errs.InvalidJSON, "The first line must be the schema of class "+className+".")
+	}
+
+	// 默认字段由 AddClassIfNotExists 添加，跳过 _rperm 等内部字段
+	fields := types.M{}
+	for fieldName, v := range utils.M(sch["fields"]) {
+		if DefaultColumns["_Default"][fieldName] != nil || DefaultColumns[className][fieldName] != nil {
+			continue
+		}
+		if strings.HasPrefix(fieldName, "_") {
+			continue
+		}
+		fields[fieldName] = v
+	}
+	if className == "_User" {
+		delete(fields, "password")
+	}
+	// 以 _ 开头的索引由类的选项创建
+	indexes := types.M{}
+	for name, index := range utils.M(sch["indexes"]) {
+		if strings.HasPrefix(name, "_") == false {
+			indexes[name] = index
+		}
+	}
+	schema := d.LoadSchema(types.M{"clearCache": true})
+	_, err = schema.AddClassIfNotExists(className, fields, utils.M(sch["classLevelPermissions"]), indexes)
+	if err != nil {
+		return 0, err
+	}
+	if options := utils.M(sch["options"]); len(options) > 0 {
+		_, err = schema.SetClassOptions(className, options)
+		if err != nil {
+			return 0, err
+		}
+	}
+	schema = d.LoadSchema(types.M{"clearCache": true})
+	parseSchema, err := schema.GetOneSchema(className, false, types.M{"clearCache": true})
+	if err != nil {
+		return 0, err
+	}
+	adapterSchema := convertSchemaToAdapterSchema(parseSchema)
+	joinClassNames := map[string]bool{}
+	for fieldName, v := range utils.M(parseSchema["fields"]) {
+		if utils.S(utils.M(v)["type"]) == "Relation" {
+			joinClassNames[joinTableName(className, fieldName)] = true
+		}
+	}
+
+	count := 0
+	lines := []types.M{}
+	flush := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		// 数据库不支持事务时直接写入，出错时已经写入的数据不会撤销
+		tx := d
+		if d.SupportsTransactions() {
+			var err error
+			tx, err = d.Begin()
+			if err != nil {
+				return err
+			}
+		}
+		rollback := func() {
+			if tx != d {
+				tx.Rollback()
+			}
+		}
+		// 对象通过一次批量写入插入，关联关系逐条写入
+		objects := []types.M{}
+		for _, line := range lines {
+			if utils.S(line["className"]) == className {
+				objects = append(objects, importObject(className, utils.M(line["object"]), parseSchema))
+			}
+		}
+		err = tx.adapter().CreateObjects(className, adapterSchema, objects)
+		if err != nil {
+			rollback()
+			return err
+		}
+		for _, line := range lines {
+			name := utils.S(line["className"])
+			if name == className {
+				continue
+			}
+			object := utils.M(line["object"])
+			doc := types.M{"owningId": object["owningId"], "relatedId": object["relatedId"]}
+			err = tx.adapter().UpsertOneObject(name, relationSchema, doc, doc)
+			if err != nil {
+				rollback()
+				return err
+			}
+		}
+		if tx != d {
+			err = tx.Commit()
+		}
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if utils.S(line["className"]) == className {
+				count++
+			}
+		}
+		lines = lines[:0]
+		return nil
+	}
+
+	for {
+		line, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		name := utils.S(line["className"])
+		if utils.M(line["object"]) == nil || (name != className && joinClassNames[name] == false) {
+			return count, errs.E(errs.InvalidJSON, "Invalid import line for class "+className+".")
+		}
+		lines = append(lines, line)
+		if len(lines) >= importBatchSize {
+			err = flush()
+			if err != nil {
+				return count, err
+			}
+		}
+	}
+	err = flush()
+	return count, err
+}
+
+// importObject 把导出的对象转换为 CreateObject 需要的格式
+func importObject(className string, object, schema types.M) types.M {
+	object = transformObjectACL(utils.CopyMapM(object))
+	for _, key := range []string{"createdAt", "updatedAt"} {
+		if v, ok := object[key].(string); ok {
+			object[key] = types.M{"__type": "Date", "iso": v}
+		}
+	}
+	transformAuthData(className, object, schema)
+	return object
 }
 
 func addWriteACL(query types.M, acl []string) types.M {
@@ -1362,7 +2167,7 @@ var specialQuerykeys = map[string]bool{
 	"_password_changed_at":           true,
 }
 
-func validateQuery(query types.M) error {
+func validateQuery(query types.M, encrypted map[string]bool) error {
 	if query == nil {
 		return nil
 	}
@@ -1417,14 +2222,19 @@ func validateQuery(query types.M) error {
 				}
 			}
 			hasNears := false
+			hasText := false
 			if obj := utils.M(query[key]); obj != nil {
 				if _, ok := obj["$nearSphere"]; ok {
 					hasNears = true
 				} else if _, ok := obj["$near"]; ok {
 					hasNears = true
 				}
+				// $text 同样只能在查询条件中出现一次，保留在顶层
+				if _, ok := obj["$text"]; ok {
+					hasText = true
+				}
 			}
-			if noCollisions && !hasNears {
+			if noCollisions && !hasNears && !hasText {
 				for _, subquery := range orArr {
 					subquery[key] = query[key]
 				}
@@ -1433,7 +2243,7 @@ func validateQuery(query types.M) error {
 		}
 
 		for _, subQuery := range orArr {
-			err := validateQuery(subQuery)
+			err := validateQuery(subQuery, encrypted)
 			if err != nil {
 				return err
 			}
@@ -1447,7 +2257,7 @@ func validateQuery(query types.M) error {
 				if subQuery == nil {
 					return errs.E(errs.InvalidQuery, "Bad $and format - invalid sub query.")
 				}
-				err := validateQuery(subQuery)
+				err := validateQuery(subQuery, encrypted)
 				if err != nil {
 					return err
 				}
@@ -1469,6 +2279,20 @@ func validateQuery(query types.M) error {
 					}
 				}
 			}
+			// 检测 $text 的格式
+			if text, ok := condition["$text"]; ok {
+				if _, err := storage.ParseTextSearch(text); err != nil {
+					return err
+				}
+			}
+		}
+
+		// 加密字段仅支持等值查询
+		if searchable, ok := encrypted[key]; ok {
+			err := validateEncryptedCondition(key, query[key], searchable)
+			if err != nil {
+				return err
+			}
 		}
 
 		if specialQuerykeys[key] == true {
@@ -1483,6 +2307,356 @@ func validateQuery(query types.M) error {
 	return nil
 }
 
+// validatePipeline 校验并规范化聚合管道，规范化之后的格式参见 storage/aggregate.go
+// 位于管道最前面的 $match 使用普通的查询条件格式，单独返回以合并到查询条件中
+// fields 为类的字段定义，返回值依次为需要合并的查询条件、规范化之后的管道
+func validatePipeline(className string, pipeline interface{}, fields types.M, isMaster bool) (types.S, types.S, error) {
+	stages := utils.A(pipeline)
+	if stages == nil {
+		return nil, nil, errs.E(errs.InvalidQuery, "Bad pipeline format - use an array value.")
+	}
+
+	// 可以在管道中使用的字段
+	current := types.M{}
+	for fieldName, v := range fields {
+		if aggregatableField(className, fieldName, utils.M(v), isMaster) {
+			current[fieldName] = v
+		}
+	}
+
+	matches := types.S{}
+	result := types.S{}
+	transformed := false
+	for _, s := range stages {
+		stage := utils.M(s)
+		if len(stage) != 1 {
+			return nil, nil, errs.E(errs.InvalidQuery, "Bad pipeline format - each stage must contain exactly one operator.")
+		}
+		op, arg := storage.StageOperator(stage)
+		var normalized types.M
+		var err error
+		switch op {
+		case "$match":
+			match := utils.M(arg)
+			if match == nil {
+				return nil, nil, errs.E(errs.InvalidQuery, "Bad $match format - use an object value.")
+			}
+			if len(result) == 0 {
+				matches = append(matches, types.M(match))
+				continue
+			}
+			if transformed == false {
+				return nil, nil, errs.E(errs.InvalidQuery, "$match must be the first stage or follow $group or $project.")
+			}
+			normalized, err = validateAggregateMatch(match, current)
+		case "$group":
+			normalized, err = validateGroup(utils.M(arg), current)
+		case "$project":
+			normalized, err = validateProject(utils.M(arg), current)
+		case "$sort":
+			normalized, err = validateAggregateSort(arg, current)
+		case "$skip", "$limit":
+			n, ok := arg.(float64)
+			if i, isInt := arg.(int); isInt {
+				n, ok = float64(i), true
+			}
+			if ok == false || n < 0 || n != float64(int(n)) {
+				return nil, nil, errs.E(errs.InvalidQuery, op+" must be a non-negative integer.")
+			}
+			normalized = types.M{op: int(n)}
+		default:
+			return nil, nil, errs.E(errs.InvalidQuery, "Invalid aggregate stage: "+op)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if op == "$group" || op == "$project" {
+			transformed = true
+		}
+		current = storage.AggregateOutputFields(current, normalized)
+		result = append(result, normalized)
+	}
+	return matches, result, nil
+}
+
+// aggregatableField 字段能否在聚合管道与 distinct 中使用
+func aggregatableField(className, fieldName string, tp types.M, isMaster bool) bool {
+	if fieldNameIsValid(fieldName) == false || fieldName == "ACL" {
+		return false
+	}
+	switch utils.S(tp["type"]) {
+	case "Relation", "ACL":
+		return false
+	}
+	if className == "_User" {
+		switch fieldName {
+		case "password", "authData", "sessionToken":
+			return false
+		case "email":
+			return isMaster
+		}
+	}
+	return true
+}
+
+// aggregateFieldReference 校验字段引用，返回引用的字段名
+func aggregateFieldReference(v interface{}, fields types.M) (string, error) {
+	fieldName := storage.FieldReference(v)
+	if fieldName == "" {
+		return "", errs.E(errs.InvalidQuery, "Bad field reference, use $ followed by field name.")
+	}
+	if _, ok := fields[fieldName]; ok == false {
+		return "", errs.E(errs.InvalidKeyName, "Invalid field name: "+fieldName)
+	}
+	return fieldName, nil
+}
+
+// aggregateFieldType 返回字段类型，类型未知时返回空字符串
+func aggregateFieldType(fields types.M, fieldName string) string {
+	return utils.S(utils.M(fields[fieldName])["type"])
+}
+
+// validateAggregateAlias 校验输出字段名
+func validateAggregateAlias(alias string) error {
+	if fieldNameIsValid(alias) == false {
+		return errs.E(errs.InvalidKeyName, "Invalid field name: "+alias)
+	}
+	return nil
+}
+
+// validateGroup 校验 $group ，分组依据可以使用 objectId 或者 _id 表示， $count 转换为 {"$sum": 1}
+func validateGroup(group types.M, fields types.M) (types.M, error) {
+	if group == nil {
+		return nil, errs.E(errs.InvalidQuery, "Bad $group format - use an object value.")
+	}
+	key, hasObjectID := group["objectId"]
+	if id, ok := group["_id"]; ok {
+		if hasObjectID {
+			return nil, errs.E(errs.InvalidQuery, "$group cannot contain both objectId and _id.")
+		}
+		key, hasObjectID = id, true
+	}
+	if hasObjectID == false {
+		return nil, errs.E(errs.InvalidQuery, "$group must contain objectId.")
+	}
+
+	result := types.M{}
+	validateKeyField := func(v interface{}) (string, error) {
+		fieldName, err := aggregateFieldReference(v, fields)
+		if err != nil {
+			return "", err
+		}
+		switch aggregateFieldType(fields, fieldName) {
+		case "Object", "Array", "GeoPoint", "Polygon":
+			return "", errs.E(errs.InvalidQuery, "Cannot group by field "+fieldName+".")
+		}
+		return fieldName, nil
+	}
+	if key == nil {
+		result["objectId"] = nil
+	} else if compound := utils.M(key); compound != nil {
+		if len(compound) == 0 {
+			return nil, errs.E(errs.InvalidQuery, "Bad $group format - objectId cannot be an empty object.")
+		}
+		normalized := types.M{}
+		for alias, v := range compound {
+			if err := validateAggregateAlias(alias); err != nil {
+				return nil, err
+			}
+			fieldName, err := validateKeyField(v)
+			if err != nil {
+				return nil, err
+			}
+			normalized[alias] = "$" + fieldName
+		}
+		result["objectId"] = normalized
+	} else {
+		fieldName, err := validateKeyField(key)
+		if err != nil {
+			return nil, err
+		}
+		result["objectId"] = "$" + fieldName
+	}
+
+	for alias, v := range group {
+		if alias == "objectId" || alias == "_id" {
+			continue
+		}
+		if err := validateAggregateAlias(alias); err != nil {
+			return nil, err
+		}
+		accumulator := utils.M(v)
+		if len(accumulator) != 1 {
+			return nil, errs.E(errs.InvalidQuery, "Bad accumulator format for "+alias+".")
+		}
+		op, arg := storage.Accumulator(accumulator)
+		switch op {
+		case "$count":
+			result[alias] = types.M{"$sum": 1}
+			continue
+		case "$sum":
+			if n, ok := arg.(float64); ok {
+				result[alias] = types.M{"$sum": n}
+				continue
+			}
+			if n, ok := arg.(int); ok {
+				result[alias] = types.M{"$sum": n}
+				continue
+			}
+		case "$avg", "$min", "$max":
+		default:
+			return nil, errs.E(errs.InvalidQuery, "Invalid accumulator: "+op)
+		}
+		fieldName, err := aggregateFieldReference(arg, fields)
+		if err != nil {
+			return nil, err
+		}
+		tp := aggregateFieldType(fields, fieldName)
+		switch op {
+		case "$sum", "$avg":
+			if tp != "" && tp != "Number" {
+				return nil, errs.E(errs.InvalidQuery, op+" only supports Number fields.")
+			}
+		default:
+			switch tp {
+			case "", "Number", "String", "Date", "Pointer":
+			default:
+				return nil, errs.E(errs.InvalidQuery, op+" only supports Number, String, Date and Pointer fields.")
+			}
+		}
+		result[alias] = types.M{op: "$" + fieldName}
+	}
+	if result["objectId"] == nil && len(result) == 1 {
+		return nil, errs.E(errs.InvalidQuery, "$group must contain at least one accumulator when objectId is null.")
+	}
+	return types.M{"$group": result}, nil
+}
+
+// validateProject 校验 $project ，字段值为 1 或 true 时表示输出该字段， objectId 为 0 或 false 时表示不输出 objectId
+func validateProject(project types.M, fields types.M) (types.M, error) {
+	if len(project) == 0 {
+		return nil, errs.E(errs.InvalidQuery, "Bad $project format - use a non-empty object value.")
+	}
+	result := types.M{}
+	if _, ok := fields["objectId"]; ok {
+		result["objectId"] = "$objectId"
+	}
+	for alias, v := range project {
+		if err := validateAggregateAlias(alias); err != nil {
+			return nil, err
+		}
+		switch value := v.(type) {
+		case bool:
+			v = value
+		case float64:
+			v = value != 0
+		case int:
+			v = value != 0
+		}
+		if include, ok := v.(bool); ok {
+			if include == false {
+				if alias != "objectId" {
+					return nil, errs.E(errs.InvalidQuery, "$project only supports excluding objectId.")
+				}
+				delete(result, "objectId")
+				continue
+			}
+			v = "$" + alias
+		}
+		fieldName, err := aggregateFieldReference(v, fields)
+		if err != nil {
+			return nil, err
+		}
+		result[alias] = "$" + fieldName
+	}
+	if len(result) == 0 {
+		return nil, errs.E(errs.InvalidQuery, "$project must output at least one field.")
+	}
+	return types.M{"$project": result}, nil
+}
+
+// validateAggregateMatch 校验作用于 $group 或 $project 输出的 $match
+func validateAggregateMatch(match types.M, fields types.M) (types.M, error) {
+	isSimple := func(v interface{}) bool {
+		switch v.(type) {
+		case nil, string, bool, float64, int:
+			return true
+		}
+		return false
+	}
+	result := types.M{}
+	for fieldName, v := range match {
+		if _, ok := fields[fieldName]; ok == false {
+			return nil, errs.E(errs.InvalidKeyName, "Invalid field name: "+fieldName)
+		}
+		constraint := utils.M(v)
+		if constraint == nil {
+			if isSimple(v) == false {
+				return nil, errs.E(errs.InvalidQuery, "$match only supports simple values after $group or $project.")
+			}
+			result[fieldName] = types.M{"$eq": v}
+			continue
+		}
+		normalized := types.M{}
+		for op, value := range constraint {
+			switch op {
+			case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+				if isSimple(value) == false {
+					return nil, errs.E(errs.InvalidQuery, "$match only supports simple values after $group or $project.")
+				}
+			case "$in", "$nin":
+				array := utils.A(value)
+				if array == nil {
+					return nil, errs.E(errs.InvalidQuery, "Bad "+op+" value")
+				}
+				for _, item := range array {
+					if isSimple(item) == false {
+						return nil, errs.E(errs.InvalidQuery, "$match only supports simple values after $group or $project.")
+					}
+				}
+				value = types.S(array)
+			default:
+				return nil, errs.E(errs.InvalidQuery, "Invalid $match operator: "+op)
+			}
+			normalized[op] = value
+		}
+		result[fieldName] = normalized
+	}
+	return types.M{"$match": result}, nil
+}
+
+// validateAggregateSort 校验 $sort ，可以使用 "-score,name" 、 ["-score", "name"] 或者只包含一个字段的 {"score": -1}
+func validateAggregateSort(sort interface{}, fields types.M) (types.M, error) {
+	keys := []string{}
+	if s, ok := sort.(string); ok {
+		for _, key := range strings.Split(s, ",") {
+			keys = append(keys, strings.TrimSpace(key))
+		}
+	} else if array := utils.A(sort); array != nil {
+		for _, key := range array {
+			keys = append(keys, utils.S(key))
+		}
+	} else if object := utils.M(sort); len(object) == 1 {
+		for key, v := range object {
+			if order, ok := v.(float64); ok && order < 0 {
+				key = "-" + key
+			}
+			keys = append(keys, key)
+		}
+	} else {
+		return nil, errs.E(errs.InvalidQuery, "Bad $sort format - use an array to sort by multiple fields.")
+	}
+	if len(keys) == 0 {
+		return nil, errs.E(errs.InvalidQuery, "Bad $sort format - use a non-empty value.")
+	}
+	for _, key := range keys {
+		if _, ok := fields[strings.TrimPrefix(key, "-")]; ok == false {
+			return nil, errs.E(errs.InvalidKeyName, "Invalid field name: "+key)
+		}
+	}
+	return types.M{"$sort": keys}, nil
+}
+
 // transformObjectACL 转换对象中的 ACL 字段
 // {
 // 	"ACL":{
@@ -1701,4 +2875,5 @@ func InitOrm(a storage.Adapter) {
 	Adapter = a
 	schemaCache = cache.NewSchemaCache(5, false)
 	TomatoDBController = &DBController{}
+	resetIdempotencyClass()
 }
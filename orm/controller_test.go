@@ -1,12 +1,17 @@
 package orm
 
 import (
+	"io"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/lfq7413/tomato/cache"
+	"github.com/lfq7413/tomato/config"
 	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/storage/memory"
 	"github.com/lfq7413/tomato/types"
 	"github.com/lfq7413/tomato/utils"
 )
@@ -2744,6 +2749,131 @@ func Test_Create(t *testing.T) {
 	TomatoDBController.DeleteEverything()
 }
 
+func Test_CreateObjects(t *testing.T) {
+	initEnv()
+	var className string
+	var objects []types.M
+	var options types.M
+	var err error
+	var expectErr error
+	timeStr := utils.TimetoString(time.Now())
+	var results []types.M
+	var expects []types.M
+	/*************************************************/
+	className = "user"
+	objects = []types.M{
+		types.M{
+			"objectId":  "01",
+			"createdAt": timeStr,
+			"key":       "hello",
+		},
+		types.M{
+			"objectId":  "02",
+			"createdAt": timeStr,
+			"ACL": types.M{
+				"*": types.M{"read": true},
+			},
+		},
+	}
+	options = nil
+	_, err = TomatoDBController.CreateObjects(className, objects, options)
+	expectErr = nil
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	results, err = Adapter.Find(className, types.M{}, types.M{}, types.M{"sort": []string{"objectId"}})
+	expects = []types.M{
+		types.M{
+			"objectId":  "01",
+			"createdAt": timeStr,
+			"key":       "hello",
+		},
+		types.M{
+			"objectId":  "02",
+			"createdAt": timeStr,
+			"_rperm":    types.S{"*"},
+			"_wperm":    types.S{},
+		},
+	}
+	if reflect.DeepEqual(expects, results) == false {
+		t.Error("expect:", expects, "result:", results)
+	}
+	TomatoDBController.DeleteEverything()
+	/*************************************************/
+	className = "@user"
+	objects = []types.M{types.M{}}
+	options = nil
+	_, err = TomatoDBController.CreateObjects(className, objects, options)
+	expectErr = errs.E(errs.InvalidClassName, "invalid className: @user")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	TomatoDBController.DeleteEverything()
+	/*************************************************/
+	className = "user"
+	Adapter.CreateClass(className, types.M{
+		"fields": types.M{
+			"key": types.M{"type": "String"},
+		},
+		"classLevelPermissions": types.M{
+			"create": types.M{"role:1001": true},
+		},
+	})
+	objects = []types.M{types.M{"key": "hello"}, types.M{"key": "world"}}
+	options = types.M{
+		"acl": []string{"role:2001"},
+	}
+	_, err = TomatoDBController.CreateObjects(className, objects, options)
+	expectErr = errs.E(errs.OperationForbidden, "Permission denied for action create on class user.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	results, err = Adapter.Find(className, types.M{}, types.M{}, types.M{})
+	if len(results) != 0 {
+		t.Error("expect:", 0, "result:", len(results))
+	}
+	TomatoDBController.DeleteEverything()
+	/*************************************************/
+	className = "user"
+	objects = []types.M{
+		types.M{
+			"objectId":  "1001",
+			"createdAt": timeStr,
+			"key": types.M{
+				"__op": "AddRelation",
+				"objects": types.S{
+					types.M{
+						"__type":    "Pointer",
+						"className": "post",
+						"objectId":  "2001",
+					},
+				},
+			},
+		},
+		types.M{
+			"objectId":  "1002",
+			"createdAt": timeStr,
+		},
+	}
+	options = nil
+	_, err = TomatoDBController.CreateObjects(className, objects, options)
+	expectErr = nil
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	results, err = Adapter.Find("_Join:key:user", relationSchema, types.M{}, types.M{})
+	expects = []types.M{
+		types.M{
+			"owningId":  "1001",
+			"relatedId": "2001",
+		},
+	}
+	if len(results) != 1 || results[0]["owningId"] != "1001" || results[0]["relatedId"] != "2001" {
+		t.Error("expect:", expects, "result:", results)
+	}
+	TomatoDBController.DeleteEverything()
+}
+
 func Test_validateClassName(t *testing.T) {
 	initEnv()
 	var className string
@@ -4606,6 +4736,170 @@ func Test_DeleteSchema(t *testing.T) {
 	TomatoDBController.DeleteEverything()
 }
 
+func Test_ExportClass_ImportClass(t *testing.T) {
+	initEnv()
+	var err error
+	var expect interface{}
+	schema := TomatoDBController.LoadSchema(nil)
+	schema.AddClassIfNotExists("post", types.M{
+		"title": types.M{"type": "String"},
+		"likes": types.M{"type": "Relation", "targetClass": "_User"},
+	}, types.M{"find": types.M{"*": true}}, nil)
+	TomatoDBController.Create("post", types.M{
+		"objectId":  "01",
+		"title":     "a",
+		"createdAt": "2006-01-02T15:04:05.000Z",
+		"updatedAt": "2006-01-02T15:04:05.000Z",
+		"ACL":       types.M{"u1": types.M{"read": true}},
+	}, nil)
+	TomatoDBController.Create("post", types.M{"objectId": "02", "title": "b"}, nil)
+	TomatoDBController.addRelation("likes", "post", "01", "u1")
+	TomatoDBController.addRelation("likes", "post", "01", "u2")
+	/*************************************************/
+	lines := []types.M{}
+	err = TomatoDBController.ExportClass("post", func(line types.M) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil || len(lines) != 5 || lines[0]["schema"] == nil {
+		t.Error("expect:", 5, "result:", lines, err)
+	}
+	expect = types.M{"className": "_Join:likes:post", "object": types.M{"owningId": "01", "relatedId": "u2"}}
+	if reflect.DeepEqual(lines[4], expect) == false {
+		t.Error("expect:", expect, "result:", lines[4])
+	}
+	/*************************************************/
+	TomatoDBController.Destroy("post", types.M{"objectId": types.M{"$exists": true}}, nil)
+	Adapter.DeleteClass("post")
+	Adapter.DeleteClass("_Join:likes:post")
+	i := 0
+	count, err := TomatoDBController.ImportClass("post", func() (types.M, error) {
+		if i == len(lines) {
+			return nil, io.EOF
+		}
+		i++
+		return lines[i-1], nil
+	})
+	if err != nil || count != 2 {
+		t.Error("expect:", 2, "result:", count, err)
+	}
+	results, _ := TomatoDBController.Find("post", types.M{"objectId": "01"}, nil)
+	expect = types.S{types.M{
+		"objectId":  "01",
+		"title":     "a",
+		"createdAt": "2006-01-02T15:04:05.000Z",
+		"updatedAt": "2006-01-02T15:04:05.000Z",
+		"ACL":       types.M{"u1": types.M{"read": true}},
+		"likes":     types.M{"__type": "Relation", "className": "_User"},
+	}}
+	if reflect.DeepEqual(results, expect) == false {
+		t.Error("expect:", expect, "result:", results)
+	}
+	ids := TomatoDBController.relatedIds("post", "likes", "01")
+	if len(ids) != 2 {
+		t.Error("expect:", 2, "result:", ids)
+	}
+	/*************************************************/
+	i = 0
+	_, err = TomatoDBController.ImportClass("post", func() (types.M, error) {
+		i++
+		return lines[i-1], nil
+	})
+	expect = errs.E(errs.InvalidClassName, "Class post already exists.")
+	if reflect.DeepEqual(err, expect) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	TomatoDBController.DeleteEverything()
+}
+
+func Test_SoftDelete(t *testing.T) {
+	initEnv()
+	var err error
+	var expect interface{}
+	schema := TomatoDBController.LoadSchema(nil)
+	schema.AddClassIfNotExists("post", types.M{"title": types.M{"type": "String"}}, nil, nil)
+	_, err = schema.SetClassOptions("post", types.M{"softDelete": types.M{"retentionDays": float64(30)}})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	TomatoDBController.Create("post", types.M{"objectId": "01", "title": "a"}, nil)
+	TomatoDBController.Create("post", types.M{"objectId": "02", "title": "b"}, nil)
+	/*************************************************/
+	err = TomatoDBController.Destroy("post", types.M{"objectId": "01"}, nil)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	results, _ := TomatoDBController.Find("post", types.M{}, nil)
+	if len(results) != 1 || utils.M(results[0])["objectId"] != "02" || utils.M(results[0])["_deleted_at"] != nil {
+		t.Error("expect:", "02", "result:", results)
+	}
+	expect = errs.E(errs.ObjectNotFound, "Object not found.")
+	err = TomatoDBController.Destroy("post", types.M{"objectId": "01"}, nil)
+	if reflect.DeepEqual(err, expect) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	_, err = TomatoDBController.Update("post", types.M{"objectId": "01"}, types.M{"title": "c"}, nil, false)
+	if reflect.DeepEqual(err, expect) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	results, _ = TomatoDBController.Find("post", types.M{}, types.M{"trash": true})
+	if len(results) != 1 || utils.M(results[0])["objectId"] != "01" || utils.M(results[0])["deletedAt"] == nil {
+		t.Error("expect:", "01", "result:", results)
+	}
+	/*************************************************/
+	object, err := TomatoDBController.Restore("post", "01")
+	if err != nil || object["title"] != "a" {
+		t.Error("expect:", "a", "result:", object, err)
+	}
+	results, _ = TomatoDBController.Find("post", types.M{}, nil)
+	if len(results) != 2 {
+		t.Error("expect:", 2, "result:", results)
+	}
+	_, err = TomatoDBController.Restore("post", "01")
+	if reflect.DeepEqual(err, expect) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	TomatoDBController.Destroy("post", types.M{"objectId": "02"}, nil)
+	count, err := TomatoDBController.PurgeTrash("post", types.M{})
+	if err != nil || count != 1 {
+		t.Error("expect:", 1, "result:", count, err)
+	}
+	results, _ = TomatoDBController.Find("post", types.M{}, types.M{"trash": true})
+	if len(results) != 0 {
+		t.Error("expect:", 0, "result:", results)
+	}
+	/*************************************************/
+	TomatoDBController.Destroy("post", types.M{"objectId": "01"}, nil)
+	purged, err := TomatoDBController.PurgeExpiredTrash()
+	if err != nil || reflect.DeepEqual(purged, types.M{"post": 0}) == false {
+		t.Error("expect:", types.M{"post": 0}, "result:", purged, err)
+	}
+	schema = TomatoDBController.LoadSchema(nil)
+	_, err = schema.SetClassOptions("post", types.M{"softDelete": nil})
+	expect = errs.E(errs.ClassNotEmpty, "Class post has 1 deleted objects, purge them before disabling soft delete.")
+	if reflect.DeepEqual(err, expect) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	schema.SetClassOptions("post", types.M{"softDelete": types.M{"retentionDays": float64(0)}})
+	time.Sleep(10 * time.Millisecond)
+	purged, err = TomatoDBController.PurgeExpiredTrash()
+	if err != nil || reflect.DeepEqual(purged, types.M{"post": 1}) == false {
+		t.Error("expect:", types.M{"post": 1}, "result:", purged, err)
+	}
+	options, err := schema.SetClassOptions("post", types.M{"softDelete": nil})
+	if err != nil || len(options) != 0 {
+		t.Error("expect:", types.M{}, "result:", options, err)
+	}
+	_, err = TomatoDBController.Find("post", types.M{}, types.M{"trash": true})
+	expect = errs.E(errs.OperationForbidden, "Soft delete is not enabled on class post.")
+	if reflect.DeepEqual(err, expect) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	TomatoDBController.DeleteEverything()
+}
+
 func Test_addPointerPermissions(t *testing.T) {
 	initEnv()
 	var object types.M
@@ -5214,14 +5508,14 @@ func Test_validateQuery(t *testing.T) {
 	var expectQuery types.M
 	/*************************************************/
 	query = nil
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = nil
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
 	}
 	/*************************************************/
 	query = types.M{"ACL": "ACL"}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = errs.E(errs.InvalidQuery, "Cannot query on ACL.")
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
@@ -5233,7 +5527,7 @@ func Test_validateQuery(t *testing.T) {
 			"$options": "imxs",
 		},
 	}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = nil
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
@@ -5245,7 +5539,7 @@ func Test_validateQuery(t *testing.T) {
 			"$options": "abc",
 		},
 	}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = errs.E(errs.InvalidQuery, "Bad $options value for query: abc")
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
@@ -5260,7 +5554,7 @@ func Test_validateQuery(t *testing.T) {
 		"_account_lockout_expires_at":    "hello",
 		"_failed_login_count":            "hello",
 	}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = nil
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
@@ -5269,7 +5563,7 @@ func Test_validateQuery(t *testing.T) {
 	query = types.M{
 		"_other": "hello",
 	}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = errs.E(errs.InvalidKeyName, "Invalid key name: _other")
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
@@ -5278,7 +5572,7 @@ func Test_validateQuery(t *testing.T) {
 	query = types.M{
 		"$or": "hello",
 	}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = errs.E(errs.InvalidQuery, "Bad $or format - use an array value.")
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
@@ -5287,7 +5581,7 @@ func Test_validateQuery(t *testing.T) {
 	query = types.M{
 		"$or": types.S{"hello", "world"},
 	}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = errs.E(errs.InvalidQuery, "Bad $or format - invalid sub query.")
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
@@ -5299,7 +5593,7 @@ func Test_validateQuery(t *testing.T) {
 			types.M{"key": "value"},
 		},
 	}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = nil
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
@@ -5314,7 +5608,7 @@ func Test_validateQuery(t *testing.T) {
 			"$in": types.S{nil, "*", "role:1024"},
 		},
 	}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = nil
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
@@ -5352,7 +5646,7 @@ func Test_validateQuery(t *testing.T) {
 			},
 		},
 	}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = nil
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
@@ -5377,7 +5671,7 @@ func Test_validateQuery(t *testing.T) {
 	query = types.M{
 		"$and": "hello",
 	}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = errs.E(errs.InvalidQuery, "Bad $and format - use an array value.")
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
@@ -5386,7 +5680,7 @@ func Test_validateQuery(t *testing.T) {
 	query = types.M{
 		"$and": types.S{"hello", "world"},
 	}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
 	expect = errs.E(errs.InvalidQuery, "Bad $and format - invalid sub query.")
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
@@ -5398,11 +5692,237 @@ func Test_validateQuery(t *testing.T) {
 			types.M{"key": "value"},
 		},
 	}
-	err = validateQuery(query)
+	err = validateQuery(query, nil)
+	expect = nil
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	query = types.M{
+		"subject": types.M{
+			"$text": types.M{
+				"$search": types.M{
+					"$term":               "coffee",
+					"$language":           "english",
+					"$caseSensitive":      false,
+					"$diacriticSensitive": true,
+				},
+			},
+		},
+	}
+	err = validateQuery(query, nil)
 	expect = nil
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
 	}
+	/*************************************************/
+	query = types.M{
+		"subject": types.M{
+			"$text": types.M{
+				"$search": types.M{
+					"$language": "english",
+				},
+			},
+		},
+	}
+	err = validateQuery(query, nil)
+	expect = errs.E(errs.InvalidJSON, "bad $text: $term, should be string")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	query = types.M{
+		"subject": types.M{
+			"$text": types.M{
+				"$search": types.M{
+					"$term":          "coffee",
+					"$caseSensitive": "true",
+				},
+			},
+		},
+	}
+	err = validateQuery(query, nil)
+	expect = errs.E(errs.InvalidJSON, "bad $text: $caseSensitive, should be boolean")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	query = types.M{
+		"$or": types.S{
+			types.M{"key": "value"},
+			types.M{"key": "hello"},
+		},
+		"subject": types.M{
+			"$text": types.M{"$search": types.M{"$term": "coffee"}},
+		},
+	}
+	err = validateQuery(query, nil)
+	expect = nil
+	expectQuery = types.M{
+		"$or": types.S{
+			types.M{"key": "value"},
+			types.M{"key": "hello"},
+		},
+		"subject": types.M{
+			"$text": types.M{"$search": types.M{"$term": "coffee"}},
+		},
+	}
+	if reflect.DeepEqual(expect, err) == false || reflect.DeepEqual(expectQuery, query) == false {
+		t.Error("expect:", expect, expectQuery, "result:", err, query)
+	}
+	/*************************************************/
+	encrypted := map[string]bool{"ssn": true, "address": false}
+	query = types.M{
+		"ssn":     types.M{"$in": types.S{"1", "2"}},
+		"address": types.M{"$exists": true},
+	}
+	err = validateQuery(query, encrypted)
+	expect = nil
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	query = types.M{
+		"$or": types.S{
+			types.M{"ssn": types.M{"$regex": "^1"}},
+			types.M{"name": "joe"},
+		},
+	}
+	err = validateQuery(query, encrypted)
+	expect = errs.E(errs.InvalidQuery, "Invalid operator $regex on encrypted field ssn.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	query = types.M{"address": "street"}
+	err = validateQuery(query, encrypted)
+	expect = errs.E(errs.InvalidQuery, "Encrypted field address is not searchable.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+}
+
+func Test_validatePipeline(t *testing.T) {
+	var pipeline interface{}
+	var fields types.M
+	var matches types.S
+	var stages types.S
+	var err error
+	var expect error
+	var expectMatches types.S
+	var expectStages types.S
+	fields = types.M{
+		"objectId": types.M{"type": "String"},
+		"ACL":      types.M{"type": "ACL"},
+		"player":   types.M{"type": "Pointer", "targetClass": "_User"},
+		"points":   types.M{"type": "Number"},
+		"name":     types.M{"type": "String"},
+		"tags":     types.M{"type": "Array"},
+	}
+	/*************************************************/
+	pipeline = "hello"
+	_, _, err = validatePipeline("score", pipeline, fields, false)
+	expect = errs.E(errs.InvalidQuery, "Bad pipeline format - use an array value.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$match": types.M{"name": "joe"}},
+		types.M{"$group": types.M{"_id": "$player", "total": types.M{"$sum": "$points"}, "count": types.M{"$count": types.M{}}}},
+		types.M{"$match": types.M{"total": types.M{"$gt": 10.0}}},
+		types.M{"$sort": "-total,objectId"},
+		types.M{"$limit": 10.0},
+	}
+	matches, stages, err = validatePipeline("score", pipeline, fields, false)
+	expectMatches = types.S{types.M{"name": "joe"}}
+	expectStages = types.S{
+		types.M{"$group": types.M{"objectId": "$player", "total": types.M{"$sum": "$points"}, "count": types.M{"$sum": 1}}},
+		types.M{"$match": types.M{"total": types.M{"$gt": 10.0}}},
+		types.M{"$sort": []string{"-total", "objectId"}},
+		types.M{"$limit": 10},
+	}
+	if err != nil || reflect.DeepEqual(expectMatches, matches) == false || reflect.DeepEqual(expectStages, stages) == false {
+		t.Error("expect:", expectMatches, expectStages, "result:", matches, stages, err)
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$project": types.M{"objectId": 0.0, "score": "$points", "name": 1.0}},
+		types.M{"$sort": types.M{"score": -1.0}},
+	}
+	matches, stages, err = validatePipeline("score", pipeline, fields, false)
+	expectMatches = types.S{}
+	expectStages = types.S{
+		types.M{"$project": types.M{"score": "$points", "name": "$name"}},
+		types.M{"$sort": []string{"-score"}},
+	}
+	if err != nil || reflect.DeepEqual(expectMatches, matches) == false || reflect.DeepEqual(expectStages, stages) == false {
+		t.Error("expect:", expectMatches, expectStages, "result:", matches, stages, err)
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$sort": "points"},
+		types.M{"$match": types.M{"name": "joe"}},
+	}
+	_, _, err = validatePipeline("score", pipeline, fields, false)
+	expect = errs.E(errs.InvalidQuery, "$match must be the first stage or follow $group or $project.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$group": types.M{"objectId": "$tags", "count": types.M{"$sum": 1}}},
+	}
+	_, _, err = validatePipeline("score", pipeline, fields, false)
+	expect = errs.E(errs.InvalidQuery, "Cannot group by field tags.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$group": types.M{"objectId": nil, "total": types.M{"$sum": "$name"}}},
+	}
+	_, _, err = validatePipeline("score", pipeline, fields, false)
+	expect = errs.E(errs.InvalidQuery, "$sum only supports Number fields.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$group": types.M{"objectId": "$ACL", "count": types.M{"$sum": 1}}},
+	}
+	_, _, err = validatePipeline("score", pipeline, fields, false)
+	expect = errs.E(errs.InvalidKeyName, "Invalid field name: ACL")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$group": types.M{"objectId": "$email"}},
+	}
+	_, _, err = validatePipeline("_User", pipeline, types.M{"email": types.M{"type": "String"}}, false)
+	expect = errs.E(errs.InvalidKeyName, "Invalid field name: email")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$skip": -1.0},
+	}
+	_, _, err = validatePipeline("score", pipeline, fields, false)
+	expect = errs.E(errs.InvalidQuery, "$skip must be a non-negative integer.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$unwind": "$tags"},
+	}
+	_, _, err = validatePipeline("score", pipeline, fields, false)
+	expect = errs.E(errs.InvalidQuery, "Invalid aggregate stage: $unwind")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
 }
 
 func Test_transformObjectACL(t *testing.T) {
@@ -5883,3 +6403,264 @@ func initEnv() {
 	schemaCache = cache.NewSchemaCache(5, false)
 	TomatoDBController = &DBController{}
 }
+
+func Test_FieldEncryption(t *testing.T) {
+	initEnv()
+	var err error
+	var expect interface{}
+	config.TConfig.FieldEncryptionKeys = "k1:MDEyMzQ1Njc4OWFiY2RlZg=="
+	defer func() { config.TConfig.FieldEncryptionKeys = "" }()
+	schema := TomatoDBController.LoadSchema(nil)
+	schema.AddClassIfNotExists("post", types.M{"title": types.M{"type": "String"}}, nil, nil)
+	_, err = schema.SetClassOptions("post", types.M{"encryptedFields": types.M{"ssn": types.M{"searchable": true}, "address": types.M{}}})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	TomatoDBController.Create("post", types.M{"objectId": "01", "ssn": "123", "address": "a"}, nil)
+	TomatoDBController.Create("post", types.M{"objectId": "02", "ssn": "456", "address": "b"}, nil)
+	/*************************************************/
+	sch, _ := schema.GetOneSchema("post", false, nil)
+	objects, _ := Adapter.Find("post", sch, types.M{"objectId": "01"}, types.M{})
+	if len(objects) != 1 || strings.HasPrefix(utils.S(objects[0]["ssn"]), "enc:k1:") == false || strings.HasPrefix(utils.S(objects[0]["address"]), "enc:k1:") == false {
+		t.Error("expect:", "enc:k1:", "result:", objects)
+	}
+	results, _ := TomatoDBController.Find("post", types.M{"objectId": "01"}, types.M{})
+	if len(results) != 1 || utils.M(results[0])["ssn"] != "123" || utils.M(results[0])["address"] != "a" {
+		t.Error("expect:", "123", "result:", results)
+	}
+	/*************************************************/
+	config.TConfig.FieldEncryptionKeys = "k2:ZmVkY2JhOTg3NjU0MzIxMA==|k1:MDEyMzQ1Njc4OWFiY2RlZg=="
+	TomatoDBController.Update("post", types.M{"objectId": "02"}, types.M{"ssn": "789"}, nil, false)
+	objects, _ = Adapter.Find("post", sch, types.M{"objectId": "02"}, types.M{})
+	if len(objects) != 1 || strings.HasPrefix(utils.S(objects[0]["ssn"]), "enc:k2:") == false {
+		t.Error("expect:", "enc:k2:", "result:", objects)
+	}
+	results, _ = TomatoDBController.Find("post", types.M{"ssn": "123"}, types.M{})
+	if len(results) != 1 || utils.M(results[0])["objectId"] != "01" {
+		t.Error("expect:", "01", "result:", results)
+	}
+	results, _ = TomatoDBController.Find("post", types.M{"ssn": types.M{"$in": types.S{"123", "789"}}}, types.M{"sort": []string{"objectId"}})
+	if len(results) != 2 || utils.M(results[1])["ssn"] != "789" {
+		t.Error("expect:", "789", "result:", results)
+	}
+	/*************************************************/
+	_, err = TomatoDBController.Find("post", types.M{"address": "a"}, types.M{})
+	expect = errs.E(errs.InvalidQuery, "Encrypted field address is not searchable.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	_, err = TomatoDBController.Find("post", types.M{}, types.M{"sort": []string{"-ssn"}})
+	expect = errs.E(errs.InvalidKeyName, "Cannot sort by encrypted field ssn")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	err = TomatoDBController.Create("post", types.M{"objectId": "03", "ssn": 123}, nil)
+	expect = errs.E(errs.IncorrectType, "Encrypted field ssn must be a String.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	_, err = schema.SetClassOptions("post", types.M{"encryptedFields": types.M{"ssn": types.M{"searchable": true}}})
+	expect = errs.E(errs.ClassNotEmpty, "Field address of class post has encrypted values, clear them before disabling encryption.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	_, err = TomatoDBController.Find("post", types.M{}, types.M{"pipeline": types.S{types.M{"$group": types.M{"objectId": "$ssn"}}}})
+	expect = errs.E(errs.InvalidKeyName, "Invalid field name: ssn")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	_, err = TomatoDBController.Find("post", types.M{}, types.M{"pipeline": types.S{types.M{"$project": types.M{"address": 1}}}})
+	expect = errs.E(errs.InvalidKeyName, "Invalid field name: address")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	err = TomatoDBController.Destroy("post", types.M{"ssn": "123"}, nil)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	results, _ = TomatoDBController.Find("post", types.M{}, types.M{})
+	if len(results) != 1 || utils.M(results[0])["objectId"] != "02" {
+		t.Error("expect:", "02", "result:", results)
+	}
+	TomatoDBController.DeleteEverything()
+}
+
+func Test_ClassTTL(t *testing.T) {
+	initEnv()
+	var err error
+	var expect interface{}
+	schema := TomatoDBController.LoadSchema(nil)
+	schema.AddClassIfNotExists("otp", types.M{"code": types.M{"type": "String"}}, nil, nil)
+	_, err = schema.SetClassOptions("otp", types.M{"ttl": types.M{"field": "expiresAt", "retentionSeconds": float64(60)}})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	sch, _ := schema.GetOneSchema("otp", false, types.M{"clearCache": true})
+	if utils.S(utils.M(utils.M(sch["fields"])["expiresAt"])["type"]) != "Date" {
+		t.Error("expect:", "Date", "result:", sch["fields"])
+	}
+	index := utils.M(utils.M(sch["indexes"])[ttlIndexName])
+	if index == nil || storage.IndexExpireAfterSeconds(index) != 60 {
+		t.Error("expect:", 60, "result:", sch["indexes"])
+	}
+	date := func(d time.Duration) types.M {
+		return types.M{"__type": "Date", "iso": utils.TimetoString(time.Now().UTC().Add(d))}
+	}
+	TomatoDBController.Create("otp", types.M{"objectId": "01", "code": "a", "expiresAt": date(-2 * time.Minute)}, nil)
+	TomatoDBController.Create("otp", types.M{"objectId": "02", "code": "b", "expiresAt": date(-30 * time.Second)}, nil)
+	TomatoDBController.Create("otp", types.M{"objectId": "03", "code": "c"}, nil)
+	/*************************************************/
+	results, _ := TomatoDBController.Find("otp", types.M{}, types.M{"sort": []string{"objectId"}})
+	if len(results) != 2 || utils.M(results[0])["objectId"] != "02" || utils.M(results[1])["objectId"] != "03" {
+		t.Error("expect:", "02 03", "result:", results)
+	}
+	results, _ = TomatoDBController.Find("otp", types.M{"$or": types.S{types.M{"code": "a"}, types.M{"code": "b"}}}, types.M{})
+	if len(results) != 1 || utils.M(results[0])["objectId"] != "02" {
+		t.Error("expect:", "02", "result:", results)
+	}
+	_, err = TomatoDBController.Update("otp", types.M{"objectId": "01"}, types.M{"code": "d"}, nil, false)
+	expect = errs.E(errs.ObjectNotFound, "Object not found.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/*************************************************/
+	ids, _ := TomatoDBController.ExpiredObjectIDs("otp", 10)
+	if len(ids) != 0 {
+		t.Error("expect:", 0, "result:", ids)
+	}
+	purged, err := TomatoDBController.PurgeExpiredObjects()
+	expect = types.M{"otp": 1}
+	if err != nil || reflect.DeepEqual(expect, purged) == false {
+		t.Error("expect:", expect, "result:", purged, err)
+	}
+	results, _ = TomatoDBController.IncludeExpired().Find("otp", types.M{}, types.M{})
+	if len(results) != 2 {
+		t.Error("expect:", 2, "result:", results)
+	}
+	/*************************************************/
+	_, err = schema.SetClassOptions("otp", types.M{"ttl": types.M{"field": "code"}})
+	expect = errs.E(errs.IncorrectType, "TTL field code must be a Date.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	_, err = schema.SetClassOptions("otp", types.M{"ttl": nil})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	sch, _ = schema.GetOneSchema("otp", false, types.M{"clearCache": true})
+	if utils.M(sch["indexes"])[ttlIndexName] != nil {
+		t.Error("expect:", nil, "result:", sch["indexes"])
+	}
+	TomatoDBController.DeleteEverything()
+}
+
+func Test_TransactionSchemaCache(t *testing.T) {
+	var tx *DBController
+	var sch types.M
+	hasField := func(sch types.M, fieldName string) bool {
+		return utils.M(sch["fields"])[fieldName] != nil
+	}
+	/*************************************************/
+	InitOrm(memory.NewMemoryAdapter("tomato"))
+	TomatoDBController.LoadSchema(nil).AddClassIfNotExists("post", types.M{"a": types.M{"type": "String"}}, nil, nil)
+	sch, _ = TomatoDBController.LoadSchema(nil).GetOneSchema("post", false, nil)
+	if hasField(sch, "a") == false {
+		t.Error("expect:", "a", "result:", sch)
+	}
+	/*************************************************/
+	// 事务中修改的表结构在提交之前不会被其他请求读取，回滚之后不会残留在缓存中
+	tx, _ = TomatoDBController.Begin()
+	tx.LoadSchema(nil).UpdateClass("post", types.M{"b": types.M{"type": "String"}}, nil, nil)
+	sch, _ = tx.LoadSchema(nil).GetOneSchema("post", false, nil)
+	if hasField(sch, "b") == false {
+		t.Error("expect:", "b", "result:", sch)
+	}
+	sch, _ = TomatoDBController.LoadSchema(nil).GetOneSchema("post", false, nil)
+	if hasField(sch, "b") {
+		t.Error("expect:", nil, "result:", sch)
+	}
+	tx.Rollback()
+	sch, _ = TomatoDBController.LoadSchema(nil).GetOneSchema("post", false, nil)
+	if hasField(sch, "b") {
+		t.Error("expect:", nil, "result:", sch)
+	}
+	/*************************************************/
+	// 提交之后重新加载表结构
+	tx, _ = TomatoDBController.Begin()
+	tx.LoadSchema(nil).UpdateClass("post", types.M{"c": types.M{"type": "String"}}, nil, nil)
+	tx.Commit()
+	sch, _ = TomatoDBController.LoadSchema(nil).GetOneSchema("post", false, nil)
+	if hasField(sch, "c") == false {
+		t.Error("expect:", "c", "result:", sch)
+	}
+	TomatoDBController.DeleteEverything()
+}
+
+func Test_UpdateWithConstraints(t *testing.T) {
+	initEnv()
+	var result types.M
+	var err, expectErr error
+	schema := TomatoDBController.LoadSchema(nil)
+	schema.AddClassIfNotExists("post", types.M{
+		"rating": types.M{"type": "Number", "min": 1, "max": 5},
+		"tags":   types.M{"type": "Array", "maxLength": 2},
+		"views":  types.M{"type": "Number"},
+	}, nil, nil)
+	TomatoDBController.Create("post", types.M{"objectId": "01", "rating": 3, "tags": types.S{"a"}}, nil)
+	/*************************************************/
+	// 操作之后的值满足约束时可以更新
+	result, err = TomatoDBController.Update("post", types.M{"objectId": "01"}, types.M{"rating": types.M{"__op": "Increment", "amount": 2}}, nil, false)
+	if err != nil || result["rating"] != 5.0 {
+		t.Error("expect:", 5, "result:", result, err)
+	}
+	result, err = TomatoDBController.Update("post", types.M{"objectId": "01"}, types.M{"tags": types.M{"__op": "AddUnique", "objects": types.S{"a", "b"}}}, nil, false)
+	if err != nil || reflect.DeepEqual(types.S{"a", "b"}, result["tags"]) == false {
+		t.Error("expect:", types.S{"a", "b"}, "result:", result, err)
+	}
+	/*************************************************/
+	// 操作之后的值违反约束时返回错误，不更新对象
+	_, err = TomatoDBController.Update("post", types.M{"objectId": "01"}, types.M{"rating": types.M{"__op": "Increment", "amount": 1}}, nil, false)
+	expectErr = errs.E(errs.ValidationError, "rating must be less than or equal to 5.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	_, err = TomatoDBController.Update("post", types.M{"objectId": "01"}, types.M{"tags": types.M{"__op": "Add", "objects": types.S{"c"}}}, nil, false)
+	expectErr = errs.E(errs.ValidationError, "length of tags must be less than or equal to 2.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	results, _ := TomatoDBController.Find("post", types.M{"objectId": "01"}, types.M{})
+	if len(results) != 1 || utils.M(results[0])["rating"] != 5.0 || reflect.DeepEqual(types.S{"a", "b"}, utils.M(results[0])["tags"]) == false {
+		t.Error("expect:", "not updated", "result:", results)
+	}
+	/*************************************************/
+	// 没有约束的字段不读取当前值
+	result, err = TomatoDBController.Update("post", types.M{"objectId": "01"}, types.M{"views": types.M{"__op": "Increment", "amount": 1}}, nil, false)
+	if err != nil || result["views"] != 1.0 {
+		t.Error("expect:", 1, "result:", result, err)
+	}
+	/*************************************************/
+	// 批量更新时同样校验当前值
+	TomatoDBController.Create("post", types.M{"objectId": "02", "rating": 1}, nil)
+	_, err = TomatoDBController.Update("post", types.M{"objectId": "02"}, types.M{"rating": types.M{"__op": "Increment", "amount": -1}}, types.M{"many": true}, false)
+	expectErr = errs.E(errs.ValidationError, "rating must be greater than or equal to 1.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/*************************************************/
+	// Increment 的查询条件只匹配操作之后仍然满足约束的对象
+	query := types.M{"rating": types.M{"$gte": 1}}
+	if conditions := incrementConditions(map[string]opConstraint{"rating": {
+		op:          types.M{"__op": "Increment", "amount": 1},
+		constraints: types.M{"min": 1, "max": 5},
+	}}); len(conditions) > 0 {
+		query = types.M{"$and": append(types.S{query}, conditions...)}
+	}
+	results, _ = TomatoDBController.Find("post", query, types.M{})
+	if len(results) != 1 || utils.M(results[0])["objectId"] != "02" {
+		t.Error("expect:", "02", "result:", results)
+	}
+	TomatoDBController.DeleteEverything()
+}
@@ -0,0 +1,275 @@
+package orm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"sync"
+
+	"github.com/lfq7413/tomato/config"
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// encryptedPrefix 加密字段在数据库中保存为 enc:<keyId>:<base64(nonce+密文)>
+const encryptedPrefix = "enc:"
+
+// fieldCipher 使用 AES-GCM 加密字段， mac 用于为可查询字段生成确定性的 nonce
+type fieldCipher struct {
+	id   string
+	aead cipher.AEAD
+	mac  []byte
+}
+
+var cipherMutex sync.Mutex
+var cipherSpec string
+var ciphers []*fieldCipher
+
+// fieldCiphers 返回 FieldEncryptionKeys 中配置的密钥，第一个为当前用于加密的密钥，配置变化时重新解析
+func fieldCiphers() ([]*fieldCipher, error) {
+	cipherMutex.Lock()
+	defer cipherMutex.Unlock()
+	spec := config.TConfig.FieldEncryptionKeys
+	if ciphers != nil && spec == cipherSpec {
+		return ciphers, nil
+	}
+	keys, err := config.ParseFieldEncryptionKeys(spec)
+	if err != nil {
+		return nil, errs.E(errs.InternalServerError, err.Error())
+	}
+	result := []*fieldCipher{}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return nil, errs.E(errs.InternalServerError, err.Error())
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errs.E(errs.InternalServerError, err.Error())
+		}
+		h := hmac.New(sha256.New, key.Key)
+		h.Write([]byte("deterministic nonce"))
+		result = append(result, &fieldCipher{id: key.ID, aead: aead, mac: h.Sum(nil)})
+	}
+	cipherSpec = spec
+	ciphers = result
+	return ciphers, nil
+}
+
+// currentCipher 返回用于加密的密钥，未配置时返回错误
+func currentCipher() (*fieldCipher, error) {
+	ciphers, err := fieldCiphers()
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphers) == 0 {
+		return nil, errs.E(errs.InternalServerError, "Field encryption keys are not configured.")
+	}
+	return ciphers[0], nil
+}
+
+// encrypt 加密 plaintext ， aad 为 类名.字段名 ，防止密文被复制到其他字段
+// deterministic 为 true 时相同的明文得到相同的密文，用于等值查询
+func (c *fieldCipher) encrypt(aad, plaintext string, deterministic bool) string {
+	nonce := make([]byte, c.aead.NonceSize())
+	if deterministic {
+		h := hmac.New(sha256.New, c.mac)
+		h.Write([]byte(aad))
+		h.Write([]byte{0})
+		h.Write([]byte(plaintext))
+		copy(nonce, h.Sum(nil))
+	} else {
+		rand.Read(nonce)
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), []byte(aad))
+	return encryptedPrefix + c.id + ":" + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// decryptValue 解密 value ，根据密文中的 keyId 选择密钥
+func decryptValue(aad, value string) (string, error) {
+	ciphers, err := fieldCiphers()
+	if err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 2)
+	if len(parts) == 2 {
+		for _, c := range ciphers {
+			if c.id != parts[0] {
+				continue
+			}
+			sealed, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil || len(sealed) < c.aead.NonceSize() {
+				break
+			}
+			size := c.aead.NonceSize()
+			plaintext, err := c.aead.Open(nil, sealed[:size], sealed[size:], []byte(aad))
+			if err != nil {
+				break
+			}
+			return string(plaintext), nil
+		}
+	}
+	return "", errs.E(errs.InternalServerError, "Unable to decrypt field "+aad+".")
+}
+
+// encryptedFields 返回 schema 中声明的加密字段， value 表示该字段是否支持等值查询
+// 在类的选项中声明： {"encryptedFields": {"ssn": {"searchable": true}, "address": {}}}
+func encryptedFields(schema types.M) map[string]bool {
+	fields := utils.M(utils.M(schema["options"])["encryptedFields"])
+	if len(fields) == 0 {
+		return nil
+	}
+	result := map[string]bool{}
+	for fieldName, v := range fields {
+		result[fieldName] = utils.M(v)["searchable"] == true
+	}
+	return result
+}
+
+// EncryptedFieldNames 返回类中的加密字段， schema 为 GetOneSchema 返回的类定义
+func EncryptedFieldNames(schema types.M) []string {
+	names := []string{}
+	for fieldName := range encryptedFields(schema) {
+		names = append(names, fieldName)
+	}
+	return names
+}
+
+// encryptObject 加密 object 中的加密字段， object 为新建的对象或者更新的数据
+// 加密字段只能是字符串，或者使用 Delete 删除
+func encryptObject(className string, fields map[string]bool, object types.M) error {
+	for fieldName, searchable := range fields {
+		value, ok := object[fieldName]
+		if ok == false || value == nil {
+			continue
+		}
+		if op := utils.M(value); op != nil && utils.S(op["__op"]) == "Delete" {
+			continue
+		}
+		s, ok := value.(string)
+		if ok == false {
+			return errs.E(errs.IncorrectType, "Encrypted field "+fieldName+" must be a String.")
+		}
+		c, err := currentCipher()
+		if err != nil {
+			return err
+		}
+		object[fieldName] = c.encrypt(className+"."+fieldName, s, searchable)
+	}
+	return nil
+}
+
+// decryptObject 解密从数据库中读取的对象，开启加密之前保存的明文原样返回
+func decryptObject(className string, fields map[string]bool, object types.M) error {
+	for fieldName := range fields {
+		s, ok := object[fieldName].(string)
+		if ok == false || strings.HasPrefix(s, encryptedPrefix) == false {
+			continue
+		}
+		plaintext, err := decryptValue(className+"."+fieldName, s)
+		if err != nil {
+			return err
+		}
+		object[fieldName] = plaintext
+	}
+	return nil
+}
+
+// validateEncryptedCondition 校验加密字段上的查询条件
+// 可查询的字段支持等值查询以及 $eq $ne $in $nin ，所有加密字段都支持 $exists
+func validateEncryptedCondition(fieldName string, value interface{}, searchable bool) error {
+	condition := utils.M(value)
+	if condition == nil || condition["__type"] != nil {
+		if searchable {
+			return nil
+		}
+		return errs.E(errs.InvalidQuery, "Encrypted field "+fieldName+" is not searchable.")
+	}
+	for op := range condition {
+		switch op {
+		case "$exists":
+			continue
+		case "$eq", "$ne", "$in", "$nin":
+			if searchable {
+				continue
+			}
+			return errs.E(errs.InvalidQuery, "Encrypted field "+fieldName+" is not searchable.")
+		}
+		return errs.E(errs.InvalidQuery, "Invalid operator "+op+" on encrypted field "+fieldName+".")
+	}
+	return nil
+}
+
+// encryptQuery 把加密字段上的等值查询转换为对密文的查询，需要先通过 validateQuery 校验
+// 使用所有密钥分别加密查询值，以便匹配轮换密钥之前写入的数据，同时匹配开启加密之前的明文
+func encryptQuery(className string, fields map[string]bool, query types.M) (types.M, error) {
+	if len(fields) == 0 || query == nil {
+		return query, nil
+	}
+	ciphers, err := fieldCiphers()
+	if err != nil {
+		return nil, err
+	}
+	encryptValues := func(aad string, values ...interface{}) types.S {
+		result := types.S{}
+		for _, value := range values {
+			result = append(result, value)
+			if s, ok := value.(string); ok {
+				for _, c := range ciphers {
+					result = append(result, c.encrypt(aad, s, true))
+				}
+			}
+		}
+		return result
+	}
+
+	result := types.M{}
+	for key, value := range query {
+		if key == "$or" || key == "$and" {
+			subQueries := types.S{}
+			for _, v := range utils.A(value) {
+				subQuery, err := encryptQuery(className, fields, utils.M(v))
+				if err != nil {
+					return nil, err
+				}
+				subQueries = append(subQueries, subQuery)
+			}
+			result[key] = subQueries
+			continue
+		}
+		if _, ok := fields[key]; ok == false {
+			result[key] = value
+			continue
+		}
+		aad := className + "." + key
+		condition := utils.M(value)
+		if condition == nil || condition["__type"] != nil {
+			if _, ok := value.(string); ok {
+				result[key] = types.M{"$in": encryptValues(aad, value)}
+			} else {
+				result[key] = value
+			}
+			continue
+		}
+		encrypted := types.M{}
+		for op, v := range condition {
+			switch op {
+			case "$eq":
+				encrypted["$in"] = encryptValues(aad, v)
+			case "$ne":
+				encrypted["$nin"] = encryptValues(aad, v)
+			case "$in", "$nin":
+				encrypted[op] = encryptValues(aad, utils.A(v)...)
+			default:
+				encrypted[op] = v
+			}
+		}
+		result[key] = encrypted
+	}
+	return result, nil
+}
@@ -0,0 +1,210 @@
+package orm
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// ttlIndexName 开启过期的类中自动创建的过期字段索引， fast 模式下为 TTL 索引
+const ttlIndexName = "_ttl"
+
+// ExpireModeFast ExpireModeDestroy 过期对象的删除方式
+const (
+	ExpireModeFast    = "fast"    // 直接从数据库删除，不执行触发器， MongoDB 中由 TTL 索引删除
+	ExpireModeDestroy = "destroy" // 通过 rest.Destroy 逐个删除，执行 beforeDelete afterDelete 并发送 LiveQuery 通知
+)
+
+// ClassTTL 类的过期设置，对象在 Field 的时间之后 RetentionSeconds 秒过期，没有 Field 的对象不会过期
+// 在类的选项中声明： {"ttl": {"field": "expiresAt", "retentionSeconds": 0, "mode": "fast"}}
+type ClassTTL struct {
+	Field            string
+	RetentionSeconds int
+	Mode             string
+}
+
+// TTLOf 返回 schema 中的过期设置，未开启时返回 nil
+func TTLOf(schema types.M) *ClassTTL {
+	ttl := utils.M(utils.M(schema["options"])["ttl"])
+	if ttl == nil {
+		return nil
+	}
+	result := &ClassTTL{Field: utils.S(ttl["field"]), Mode: ExpireModeFast}
+	if v, ok := ttl["retentionSeconds"].(float64); ok {
+		result.RetentionSeconds = int(v)
+	}
+	if mode := utils.S(ttl["mode"]); mode != "" {
+		result.Mode = mode
+	}
+	return result
+}
+
+// expiredBefore 过期字段早于该时间的对象已过期
+func (t *ClassTTL) expiredBefore() types.M {
+	cutoff := time.Now().UTC().Add(-time.Duration(t.RetentionSeconds) * time.Second)
+	return types.M{"__type": "Date", "iso": utils.TimetoString(cutoff)}
+}
+
+// excludeExpired 在查询条件中排除已过期的对象
+func excludeExpired(query types.M, ttl *ClassTTL) types.M {
+	notExpired := types.S{
+		types.M{ttl.Field: types.M{"$exists": false}},
+		types.M{ttl.Field: types.M{"$gt": ttl.expiredBefore()}},
+	}
+	query = utils.CopyMap(query)
+	if _, ok := query["$or"]; ok == false {
+		query["$or"] = notExpired
+		return query
+	}
+	return types.M{"$and": types.S{query, types.M{"$or": notExpired}}}
+}
+
+// expiredQuery 查找已过期对象的查询条件
+func expiredQuery(ttl *ClassTTL) types.M {
+	return types.M{ttl.Field: types.M{"$lte": ttl.expiredBefore()}}
+}
+
+// IncludeExpired 返回查询与更新时不排除已过期对象的 DBController ，用于删除过期对象
+func (d *DBController) IncludeExpired() *DBController {
+	db := *d
+	db.expired = true
+	return &db
+}
+
+// PurgeExpiredObjects 直接删除 fast 模式的类中已过期的对象，返回每个类中删除的数量
+// 数据库支持 TTL 索引时由数据库删除，不做处理
+func (d *DBController) PurgeExpiredObjects() (types.M, error) {
+	results := types.M{}
+	if indexer, ok := d.adapter().(storage.TTLIndexer); ok && indexer.SupportsTTLIndex() {
+		return results, nil
+	}
+	schemas, err := d.LoadSchema(types.M{"clearCache": true}).GetAllClasses(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, schema := range schemas {
+		ttl := TTLOf(schema)
+		if ttl == nil || ttl.Mode != ExpireModeFast {
+			continue
+		}
+		className := utils.S(schema["className"])
+		query := expiredQuery(ttl)
+		count, err := d.adapter().Count(className, schema, query)
+		if err != nil {
+			return results, err
+		}
+		if count == 0 {
+			continue
+		}
+		err = d.adapter().DeleteObjectsByQuery(className, schema, query)
+		if err != nil && errs.GetErrorCode(err) != errs.ObjectNotFound {
+			return results, err
+		}
+		results[className] = count
+	}
+	return results, nil
+}
+
+// ExpiredObjectIDs 返回 destroy 模式的类中已过期对象的 objectId ，最多 limit 个
+// 开启软删除的类中不包含已删除的对象
+func (d *DBController) ExpiredObjectIDs(className string, limit int) ([]string, error) {
+	schema, err := d.LoadSchema(nil).GetOneSchema(className, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	ttl := TTLOf(schema)
+	if ttl == nil || ttl.Mode != ExpireModeDestroy {
+		return []string{}, nil
+	}
+	query := expiredQuery(ttl)
+	if SoftDeleteEnabled(schema) {
+		query = excludeDeleted(query, false)
+	}
+	objects, err := d.adapter().Find(className, schema, query, types.M{"keys": []string{"objectId"}, "limit": limit})
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, object := range objects {
+		ids = append(ids, utils.S(object["objectId"]))
+	}
+	return ids, nil
+}
+
+// prepareTTL 校验过期字段，字段不存在时添加为 Date 类型，并重新创建过期字段索引
+func (s *Schema) prepareTTL(className string, schema, options types.M) error {
+	oldTTL := TTLOf(schema)
+	newTTL := TTLOf(types.M{"options": options})
+	if reflect.DeepEqual(oldTTL, newTTL) {
+		return nil
+	}
+	if newTTL != nil {
+		if tp := utils.M(utils.M(schema["fields"])[newTTL.Field]); tp != nil {
+			if utils.S(tp["type"]) != "Date" {
+				return errs.E(errs.IncorrectType, "TTL field "+newTTL.Field+" must be a Date.")
+			}
+		} else {
+			err := s.dbAdapter.AddFieldIfNotExists(className, newTTL.Field, types.M{"type": "Date"})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if utils.M(schema["indexes"])[ttlIndexName] != nil {
+		err := s.dbAdapter.DropIndex(className, ttlIndexName)
+		if err != nil {
+			return err
+		}
+	}
+	if newTTL == nil {
+		return nil
+	}
+	index := types.M{"keys": types.S{newTTL.Field}}
+	if newTTL.Mode == ExpireModeFast {
+		// MongoDB 中 expireAfterSeconds 为 0 时无法创建 TTL 索引，查询时会排除这 1 秒内过期的对象
+		seconds := newTTL.RetentionSeconds
+		if seconds < 1 {
+			seconds = 1
+		}
+		index["expireAfterSeconds"] = seconds
+	}
+	return s.createIndexes(className, types.M{ttlIndexName: index})
+}
+
+// validateTTL 校验类选项中的 ttl
+func validateTTL(className string, v interface{}) error {
+	ttl := utils.M(v)
+	if ttl == nil {
+		return errs.E(errs.InvalidJSON, "ttl must be an object.")
+	}
+	if className[0] == '_' {
+		return errs.E(errs.InvalidClassName, "TTL is not supported on class "+className+".")
+	}
+	if _, ok := ttl["field"]; ok == false {
+		return errs.E(errs.InvalidJSON, "ttl must contain field.")
+	}
+	for k, value := range ttl {
+		switch k {
+		case "field":
+			if fieldNameIsValid(utils.S(value)) == false {
+				return errs.E(errs.InvalidKeyName, "Invalid TTL field: "+utils.S(value))
+			}
+		case "retentionSeconds":
+			seconds, ok := value.(float64)
+			if ok == false || seconds < 0 || seconds != float64(int(seconds)) {
+				return errs.E(errs.InvalidJSON, "retentionSeconds must be an integer greater than or equal to 0.")
+			}
+		case "mode":
+			if mode := utils.S(value); mode != ExpireModeFast && mode != ExpireModeDestroy {
+				return errs.E(errs.InvalidJSON, "mode must be fast or destroy.")
+			}
+		default:
+			return errs.E(errs.InvalidJSON, "Invalid ttl option: "+k)
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package orm
 
 import (
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -16,7 +17,7 @@ import (
 var clpValidKeys = []string{"find", "count", "get", "create", "update", "delete", "addField", "readUserFields", "writeUserFields"}
 
 // SystemClasses 系统表
-var SystemClasses = []string{"_User", "_Installation", "_Role", "_Session", "_Product", "_PushStatus", "_JobStatus"}
+var SystemClasses = []string{"_User", "_Installation", "_Role", "_Session", "_Product", "_PushStatus", "_JobStatus", "_Migration", "_History", "_Idempotency"}
 
 var volatileClasses = []string{"_JobStatus", "_PushStatus", "_Hooks", "_GlobalConfig"}
 
@@ -106,6 +107,31 @@ var DefaultColumns = map[string]types.M{
 		"objectId": types.M{"type": "String"},
 		"params":   types.M{"type": "Object"},
 	},
+	"_Migration": types.M{
+		"version":     types.M{"type": "Number"},
+		"name":        types.M{"type": "String"},
+		"appliedAt":   types.M{"type": "Date"},
+		"owner":       types.M{"type": "String"},
+		"lockedUntil": types.M{"type": "Date"},
+	},
+	"_History": types.M{
+		"targetClass":    types.M{"type": "String"},
+		"targetId":       types.M{"type": "String"},
+		"action":         types.M{"type": "String"},
+		"user":           types.M{"type": "Pointer", "targetClass": "_User"},
+		"master":         types.M{"type": "Boolean"},
+		"installationId": types.M{"type": "String"},
+		"update":         types.M{"type": "Object"},
+		"before":         types.M{"type": "Object"},
+		"after":          types.M{"type": "Object"},
+	},
+	"_Idempotency": types.M{
+		"reqId":    types.M{"type": "String"},
+		"expire":   types.M{"type": "Date"},
+		"status":   types.M{"type": "Number"},
+		"response": types.M{"type": "String"},
+		"location": types.M{"type": "String"},
+	},
 }
 
 // requiredColumns 类必须要有的字段
@@ -125,18 +151,28 @@ type Schema struct {
 	reloadDataPromise []types.M
 }
 
-// AddClassIfNotExists 添加类定义，包含默认的字段
-func (s *Schema) AddClassIfNotExists(className string, fields types.M, classLevelPermissions types.M) (types.M, error) {
+// AddClassIfNotExists 添加类定义，包含默认的字段， indexes 为需要同时创建的索引
+func (s *Schema) AddClassIfNotExists(className string, fields types.M, classLevelPermissions types.M, indexes types.M) (types.M, error) {
 	err := s.validateNewClass(className, fields, classLevelPermissions)
 	if err != nil {
 		return nil, err
 	}
+	// 字段约束保存在类的选项中
+	fields, constraints := splitSchemaFields(fields)
+	allFields := utils.M(injectDefaultSchema(types.M{"className": className, "fields": fields})["fields"])
+	createdIndexes, _, err := validateIndexes(allFields, nil, indexes)
+	if err != nil {
+		return nil, err
+	}
 
 	schema := types.M{
 		"className":             className,
 		"fields":                fields,
 		"classLevelPermissions": classLevelPermissions,
 	}
+	if len(createdIndexes) > 0 {
+		schema["indexes"] = createdIndexes
+	}
 	result, err := s.dbAdapter.CreateClass(className, convertSchemaToAdapterSchema(schema))
 	if err != nil {
 		if errs.GetErrorCode(err) == errs.DuplicateValue {
@@ -144,14 +180,23 @@ func (s *Schema) AddClassIfNotExists(className string, fields types.M, classLeve
 		}
 		return nil, err
 	}
+	if len(constraints) > 0 {
+		err = s.dbAdapter.SetClassOptions(className, types.M{fieldConstraintsOption: constraints})
+		if err != nil {
+			return nil, err
+		}
+	}
 	result = convertAdapterSchemaToParseSchema(result)
+	if fields := utils.M(result["fields"]); fields != nil {
+		mergeFieldConstraints(fields, constraints)
+	}
 	s.cache.Clear()
 
 	return result, nil
 }
 
-// UpdateClass 更新类
-func (s *Schema) UpdateClass(className string, submittedFields types.M, classLevelPermissions types.M) (types.M, error) {
+// UpdateClass 更新类， submittedIndexes 中为需要创建的索引，以及 {"__op":"Delete"} 表示需要删除的索引
+func (s *Schema) UpdateClass(className string, submittedFields types.M, classLevelPermissions types.M, submittedIndexes types.M) (types.M, error) {
 	schema, err := s.GetOneSchema(className, false, nil)
 	if err != nil {
 		return nil, err
@@ -177,8 +222,17 @@ func (s *Schema) UpdateClass(className string, submittedFields types.M, classLev
 		}
 		op := utils.S(field["__op"])
 		if existingFields[name] != nil && op != "Delete" {
-			// 字段已存在，不能更新
-			return nil, errs.E(errs.ClassNotEmpty, "Field "+name+" exists, cannot update.")
+			// 字段已存在，只能更新约束，类型必须与原有类型一致
+			if hasFieldConstraints(field) == false || dbTypeMatchesObjectType(utils.M(existingFields[name]), field) == false {
+				return nil, errs.E(errs.ClassNotEmpty, "Field "+name+" exists, cannot update.")
+			}
+			if DefaultColumns["_Default"][name] != nil || DefaultColumns[className][name] != nil {
+				return nil, errs.E(errs.ChangedImmutableFieldError, "field "+name+" cannot be changed")
+			}
+			err := fieldTypeIsInvalid(field)
+			if err != nil {
+				return nil, err
+			}
 		}
 		if existingFields[name] == nil && op == "Delete" {
 			// 字段不存在，不能删除
@@ -202,6 +256,22 @@ func (s *Schema) UpdateClass(className string, submittedFields types.M, classLev
 		return nil, err
 	}
 
+	// 校验索引，已有索引中的字段不能删除
+	existingIndexes := utils.M(schema["indexes"])
+	allFields := utils.M(injectDefaultSchema(types.M{"className": className, "fields": newSchema})["fields"])
+	createdIndexes, deletedIndexes, err := validateIndexes(allFields, existingIndexes, submittedIndexes)
+	if err != nil {
+		return nil, err
+	}
+
+	// 删除指定索引
+	for _, indexName := range deletedIndexes {
+		err = s.dbAdapter.DropIndex(className, indexName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// 删除指定字段，并统计需要插入的字段
 	deletedFields := []string{}
 	insertedFields := []string{}
@@ -213,7 +283,7 @@ func (s *Schema) UpdateClass(className string, submittedFields types.M, classLev
 		op := utils.S(field["__op"])
 		if op == "Delete" {
 			deletedFields = append(deletedFields, name)
-		} else {
+		} else if existingFields[name] == nil {
 			insertedFields = append(insertedFields, name)
 		}
 	}
@@ -225,33 +295,100 @@ func (s *Schema) UpdateClass(className string, submittedFields types.M, classLev
 		}
 	}
 
+	// 更新字段约束，提交的字段定义中的约束替换原有的约束
+	constraints := types.M{}
+	for name, c := range fieldConstraintsOf(schema) {
+		constraints[name] = c
+	}
+	for _, name := range deletedFields {
+		delete(constraints, name)
+	}
+	for name, v := range submittedFields {
+		field := utils.M(v)
+		if field == nil || utils.S(field["__op"]) == "Delete" {
+			continue
+		}
+		if _, c := splitFieldConstraints(field); len(c) > 0 {
+			constraints[name] = c
+		} else {
+			delete(constraints, name)
+		}
+	}
+	err = s.setFieldConstraints(className, schema, constraints)
+	if err != nil {
+		return nil, err
+	}
+
 	// 重新加载修改过的数据
 	s.reloadData(types.M{"clearCache": true})
 
 	// 校验并插入字段
 	for _, fieldName := range insertedFields {
-		fieldType := utils.M(submittedFields[fieldName])
+		fieldType, _ := splitFieldConstraints(utils.M(submittedFields[fieldName]))
 		err := s.enforceFieldExists(className, fieldName, fieldType)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	// 创建索引，需要在插入字段之后进行
+	if len(createdIndexes) > 0 {
+		err = s.createIndexes(className, createdIndexes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// 设置 CLP
 	err = s.setPermissions(className, classLevelPermissions, newSchema)
 	if err != nil {
 		return nil, err
 	}
 
+	indexes := types.M{}
+	for name, index := range existingIndexes {
+		indexes[name] = index
+	}
+	for _, name := range deletedIndexes {
+		delete(indexes, name)
+	}
+	for name, index := range createdIndexes {
+		indexes[name] = index
+	}
+
 	s.dataMutex.Lock()
 	defer s.dataMutex.Unlock()
 	s.permsMutex.Lock()
 	defer s.permsMutex.Unlock()
-	return types.M{
+	result := types.M{
 		"className":             className,
 		"fields":                utils.DeepCopy(s.data[className]),
 		"classLevelPermissions": utils.DeepCopy(s.perms[className]),
-	}, nil
+	}
+	if len(indexes) > 0 {
+		result["indexes"] = indexes
+	}
+	if options := utils.M(schema["options"]); len(options) > 0 {
+		result["options"] = options
+	}
+	return result, nil
+}
+
+// createIndexes 在指定类上创建索引
+func (s *Schema) createIndexes(className string, indexes types.M) error {
+	schema, err := s.GetOneSchema(className, false, types.M{"clearCache": true})
+	if err != nil {
+		return err
+	}
+	schema = convertSchemaToAdapterSchema(schema)
+	for name, index := range indexes {
+		err = s.dbAdapter.CreateIndex(className, schema, name, utils.M(index))
+		if err != nil {
+			return err
+		}
+	}
+	s.cache.Clear()
+	return nil
 }
 
 // deleteField 从类定义中删除指定的字段
@@ -355,7 +492,7 @@ func (s *Schema) validateObject(className string, object, query types.M) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return s.enforceFieldConstraints(className, object, query)
 }
 
 // testBaseCLP 校验用户是否有权限对表进行指定操作
@@ -447,7 +584,7 @@ func (s *Schema) EnforceClassExists(className string) error {
 	s.dataMutex.Unlock()
 
 	// 添加不存在的类定义
-	_, err := s.AddClassIfNotExists(className, nil, nil)
+	_, err := s.AddClassIfNotExists(className, nil, nil, nil)
 	if err != nil {
 
 	}
@@ -613,6 +750,319 @@ func (s *Schema) setPermissions(className string, perms types.M, newSchema types
 	return nil
 }
 
+// SetClassOptions 更新类的选项， options 中的值为 null 时删除该选项，返回更新后的全部选项
+// 当前支持的选项：
+// softDelete: {"retentionDays": 30} 开启软删除，删除对象时只设置 _deleted_at ，retentionDays 为已删除对象的保留天数
+// history: {} 开启修改历史，对象的每次修改记录在 _History 中
+// encryptedFields: {"ssn": {"searchable": true}} 使用 AES-GCM 加密字段，searchable 为 true 时使用确定性加密以支持等值查询
+// ttl: {"field": "expiresAt", "retentionSeconds": 0, "mode": "fast"} 对象过期后不再被查询到，并定期删除， mode 为 fast 或 destroy
+func (s *Schema) SetClassOptions(className string, options types.M) (types.M, error) {
+	schema, err := s.GetOneSchema(className, false, types.M{"clearCache": true})
+	if err != nil {
+		return nil, err
+	}
+	if len(schema) == 0 {
+		return nil, errs.E(errs.InvalidClassName, "Class "+className+" does not exist.")
+	}
+
+	if _, ok := options[fieldConstraintsOption]; ok {
+		// 字段约束通过字段定义设置
+		return nil, errs.E(errs.InvalidJSON, "Invalid class option: "+fieldConstraintsOption)
+	}
+	existingOptions := utils.M(schema["options"])
+	newOptions := types.M{}
+	for k, v := range existingOptions {
+		newOptions[k] = v
+	}
+	for k, v := range options {
+		if v == nil {
+			delete(newOptions, k)
+		} else {
+			newOptions[k] = v
+		}
+	}
+	err = validateClassOptions(className, newOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if SoftDeleteEnabled(schema) && SoftDeleteEnabled(types.M{"options": newOptions}) == false {
+		// 关闭软删除之前需要清空回收站，否则已删除的对象会重新出现
+		count, err := s.dbAdapter.Count(className, schema, types.M{softDeleteField: types.M{"$exists": true}})
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, errs.E(errs.ClassNotEmpty, "Class "+className+" has "+strconv.Itoa(count)+" deleted objects, purge them before disabling soft delete.")
+		}
+	}
+	if SoftDeleteEnabled(types.M{"options": newOptions}) {
+		err = s.dbAdapter.AddFieldIfNotExists(className, softDeleteField, types.M{"type": "Date"})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if HistoryEnabled(types.M{"options": newOptions}) {
+		err = s.ensureHistoryClass()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = s.prepareEncryptedFields(className, schema, newOptions)
+	if err != nil {
+		return nil, err
+	}
+	err = s.prepareTTL(className, schema, newOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.dbAdapter.SetClassOptions(className, newOptions)
+	if err != nil {
+		return nil, err
+	}
+	s.reloadData(types.M{"clearCache": true})
+	return newOptions, nil
+}
+
+// validateClassOptions 校验类的选项
+func validateClassOptions(className string, options types.M) error {
+	for key, v := range options {
+		switch key {
+		case "history":
+			history := utils.M(v)
+			if history == nil {
+				return errs.E(errs.InvalidJSON, "history must be an object.")
+			}
+			if className[0] == '_' {
+				return errs.E(errs.InvalidClassName, "History is not supported on class "+className+".")
+			}
+			for k := range history {
+				return errs.E(errs.InvalidJSON, "Invalid history option: "+k)
+			}
+		case "encryptedFields":
+			fields := utils.M(v)
+			if fields == nil {
+				return errs.E(errs.InvalidJSON, "encryptedFields must be an object.")
+			}
+			if className[0] == '_' && className != "_User" {
+				return errs.E(errs.InvalidClassName, "Field encryption is not supported on class "+className+".")
+			}
+			for fieldName, value := range fields {
+				field := utils.M(value)
+				if field == nil {
+					return errs.E(errs.InvalidJSON, "Encrypted field "+fieldName+" must be an object.")
+				}
+				if fieldNameIsValid(fieldName) == false || DefaultColumns["_Default"][fieldName] != nil || DefaultColumns[className][fieldName] != nil {
+					return errs.E(errs.InvalidKeyName, "Field "+fieldName+" cannot be encrypted.")
+				}
+				for k, option := range field {
+					if k != "searchable" {
+						return errs.E(errs.InvalidJSON, "Invalid encrypted field option: "+k)
+					}
+					if _, ok := option.(bool); ok == false {
+						return errs.E(errs.InvalidJSON, "searchable must be a boolean.")
+					}
+				}
+			}
+		case "ttl":
+			err := validateTTL(className, v)
+			if err != nil {
+				return err
+			}
+		case fieldConstraintsOption:
+			// 字段约束在更新字段时已经校验
+			continue
+		case "softDelete":
+			softDelete := utils.M(v)
+			if softDelete == nil {
+				return errs.E(errs.InvalidJSON, "softDelete must be an object.")
+			}
+			if className[0] == '_' {
+				return errs.E(errs.InvalidClassName, "Soft delete is not supported on class "+className+".")
+			}
+			for k, value := range softDelete {
+				if k != "retentionDays" {
+					return errs.E(errs.InvalidJSON, "Invalid softDelete option: "+k)
+				}
+				days, ok := value.(float64)
+				if ok == false || days < 0 || days != float64(int(days)) {
+					return errs.E(errs.InvalidJSON, "retentionDays must be an integer greater than or equal to 0.")
+				}
+			}
+		default:
+			return errs.E(errs.InvalidJSON, "Invalid class option: "+key)
+		}
+	}
+	return nil
+}
+
+// softDeleteField 开启软删除的类中保存删除时间的隐藏字段
+const softDeleteField = "_deleted_at"
+
+// SoftDeleteEnabled schema 对应的类是否开启了软删除
+func SoftDeleteEnabled(schema types.M) bool {
+	return utils.M(utils.M(schema["options"])["softDelete"]) != nil
+}
+
+// HistoryEnabled schema 对应的类是否开启了修改历史
+func HistoryEnabled(schema types.M) bool {
+	return utils.M(utils.M(schema["options"])["history"]) != nil
+}
+
+// historyCLP _History 表仅允许 master 访问，普通用户通过对象的 history 接口读取
+var historyCLP = types.M{
+	"find":     types.M{},
+	"get":      types.M{},
+	"count":    types.M{},
+	"create":   types.M{},
+	"update":   types.M{},
+	"delete":   types.M{},
+	"addField": types.M{},
+}
+
+// ensureHistoryClass 创建 _History 表，已经存在时不做处理
+func (s *Schema) ensureHistoryClass() error {
+	indexes := types.M{
+		"target": types.M{"keys": types.S{"targetClass", "targetId", "-createdAt"}},
+	}
+	_, err := s.AddClassIfNotExists("_History", types.M{}, historyCLP, indexes)
+	if err != nil && errs.GetErrorCode(err) == errs.InvalidClassName {
+		return nil
+	}
+	return err
+}
+
+// idempotencyCLP _Idempotency 表仅允许 master 访问
+var idempotencyCLP = types.M{
+	"find":     types.M{},
+	"get":      types.M{},
+	"count":    types.M{},
+	"create":   types.M{},
+	"update":   types.M{},
+	"delete":   types.M{},
+	"addField": types.M{},
+}
+
+// idempotencyClassReady _Idempotency 表与 reqId 的唯一索引已经确认存在，之后不再访问数据库，删除所有数据时重置
+var idempotencyClassReady bool
+var idempotencyClassMutex sync.Mutex
+
+// EnsureIdempotencyClass 创建保存请求 id 与响应的 _Idempotency 表
+// reqId 唯一，对象在 expire 之后过期，表已经存在时仍然确保唯一索引存在，成功之后不再重复检查
+func (s *Schema) EnsureIdempotencyClass() error {
+	idempotencyClassMutex.Lock()
+	defer idempotencyClassMutex.Unlock()
+	if idempotencyClassReady {
+		return nil
+	}
+	className := "_Idempotency"
+	if s.HasClass(className) == false {
+		schema, err := s.AddClassIfNotExists(className, types.M{}, idempotencyCLP, nil)
+		if err != nil {
+			if errs.GetErrorCode(err) != errs.InvalidClassName {
+				return err
+			}
+			// 已经由其他节点创建
+		} else {
+			options := types.M{"ttl": types.M{"field": "expire", "mode": ExpireModeFast}}
+			err = s.prepareTTL(className, schema, options)
+			if err != nil {
+				return err
+			}
+			err = s.dbAdapter.SetClassOptions(className, options)
+			if err != nil {
+				return err
+			}
+		}
+		s.reloadData(types.M{"clearCache": true})
+	}
+	// 创建表之后、添加索引之前中断时，表中没有唯一索引，所以表已经存在时也要确保索引存在
+	schema, err := s.GetOneSchema(className, true, nil)
+	if err != nil {
+		return err
+	}
+	err = s.dbAdapter.EnsureUniqueness(className, convertSchemaToAdapterSchema(schema), []string{"reqId"})
+	if err != nil {
+		return err
+	}
+	idempotencyClassReady = true
+	return nil
+}
+
+// resetIdempotencyClass 删除所有数据或者更换数据库之后，重新检查 _Idempotency 表
+func resetIdempotencyClass() {
+	idempotencyClassMutex.Lock()
+	idempotencyClassReady = false
+	idempotencyClassMutex.Unlock()
+}
+
+// prepareEncryptedFields 校验新增的加密字段并添加为 String 类型，需要配置 FieldEncryptionKeys
+// 已经保存了数据的字段不能取消加密，开启加密之前保存的明文不会自动加密
+func (s *Schema) prepareEncryptedFields(className string, schema, options types.M) error {
+	encrypted := encryptedFields(types.M{"options": options})
+	for fieldName := range encryptedFields(schema) {
+		if _, ok := encrypted[fieldName]; ok {
+			continue
+		}
+		count, err := s.dbAdapter.Count(className, schema, types.M{fieldName: types.M{"$exists": true}})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errs.E(errs.ClassNotEmpty, "Field "+fieldName+" of class "+className+" has encrypted values, clear them before disabling encryption.")
+		}
+	}
+	if len(encrypted) == 0 {
+		return nil
+	}
+	if _, err := currentCipher(); err != nil {
+		return err
+	}
+	fields := utils.M(schema["fields"])
+	for fieldName := range encrypted {
+		if tp := utils.M(fields[fieldName]); tp != nil {
+			if utils.S(tp["type"]) != "String" {
+				return errs.E(errs.IncorrectType, "Encrypted field "+fieldName+" must be a String.")
+			}
+			continue
+		}
+		err := s.dbAdapter.AddFieldIfNotExists(className, fieldName, types.M{"type": "String"})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HideInternalFields 返回删除了内部字段之后的 schema ，用于接口中返回
+func HideInternalFields(schema types.M) types.M {
+	fields := utils.M(schema["fields"])
+	options := utils.M(schema["options"])
+	_, hasDeleted := fields[softDeleteField]
+	_, hasConstraints := options[fieldConstraintsOption]
+	if hasDeleted == false && hasConstraints == false {
+		return schema
+	}
+	result := utils.CopyMap(schema)
+	if hasDeleted {
+		fields = utils.CopyMap(fields)
+		delete(fields, softDeleteField)
+		result["fields"] = fields
+	}
+	if hasConstraints {
+		// 字段约束已经合并到字段定义中
+		options = utils.CopyMap(options)
+		delete(options, fieldConstraintsOption)
+		if len(options) > 0 {
+			result["options"] = options
+		} else {
+			delete(result, "options")
+		}
+	}
+	return result
+}
+
 // HasClass Schema 中是否存在类定义
 func (s *Schema) HasClass(className string) bool {
 	s.reloadData(nil)
@@ -830,13 +1280,20 @@ func getObjectType(obj interface{}) (types.M, error) {
 				if object["latitude"] != nil && object["longitude"] != nil {
 					return types.M{"type": "GeoPoint"}, nil
 				}
+			case "Polygon":
+				if object["coordinates"] != nil {
+					if _, err := storage.ParsePolygon(object); err != nil {
+						return nil, err
+					}
+					return types.M{"type": "Polygon"}, nil
+				}
 			case "Bytes":
 				if object["base64"] != nil {
 					return types.M{"type": "Bytes"}, nil
 				}
 			}
-			// 当 __type 的值不在以上 6 种类型之中时，为无效类型
-			// 当 __type 的值在以上 6 种类型之中，但是不符合详细规则时，为无效的类型
+			// 当 __type 的值不在以上 7 种类型之中时，为无效类型
+			// 当 __type 的值在以上 7 种类型之中，但是不符合详细规则时，为无效的类型
 			return nil, errs.E(errs.IncorrectType, "This is not a valid "+t)
 		}
 		if object["$ne"] != nil {
@@ -934,9 +1391,11 @@ var validNonRelationOrPointerTypes = map[string]bool{
 	"Array":    true,
 	"GeoPoint": true,
 	"File":     true,
+	"Polygon":  true,
+	"Bytes":    true,
 }
 
-// fieldTypeIsInvalid 检测字段类型是否合法
+// fieldTypeIsInvalid 检测字段类型以及字段约束是否合法
 func fieldTypeIsInvalid(t types.M) error {
 	var invalidJSONError = errs.E(errs.InvalidJSON, "invalid JSON")
 	if t == nil {
@@ -961,14 +1420,14 @@ func fieldTypeIsInvalid(t types.M) error {
 		if ClassNameIsValid(targetClass) == false {
 			return errs.E(errs.InvalidClassName, InvalidClassNameMessage(targetClass))
 		}
-		return nil
+		return validateFieldConstraints(fieldType, t)
 	}
 
 	if validNonRelationOrPointerTypes[fieldType] == false {
 		return errs.E(errs.IncorrectType, "invalid field type: "+fieldType)
 	}
 
-	return nil
+	return validateFieldConstraints(fieldType, t)
 }
 
 // validateCLP 校验类级别权限
@@ -1063,6 +1522,153 @@ func verifyPermissionKey(key string) error {
 	return errs.E(errs.InvalidJSON, key+" is not a valid key for class level permissions")
 }
 
+var indexNameRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+var indexLanguageRegex = regexp.MustCompile(`^[a-z]+$`)
+
+// validateIndexes 校验索引，返回格式化之后需要创建的索引，以及需要删除的索引名称
+// fields 为类的全部字段，包含默认字段
+// submittedIndexes 格式如下：
+// {
+// 	"name_age": {"keys": ["name", "-age"], "sparse": true},
+// 	"expire": {"keys": ["expireAt"], "expireAfterSeconds": 3600},
+// 	"search": {"keys": ["title", "content"], "text": true, "language": "english"},
+// 	"old": {"__op": "Delete"}
+// }
+func validateIndexes(fields, existingIndexes, submittedIndexes types.M) (types.M, []string, error) {
+	if existingIndexes == nil {
+		existingIndexes = types.M{}
+	}
+	createdIndexes := types.M{}
+	deletedIndexes := []string{}
+	deleted := map[string]bool{}
+	for name, v := range submittedIndexes {
+		if indexNameRegex.MatchString(name) == false {
+			return nil, nil, errs.E(errs.InvalidQuery, "Invalid index name: "+name)
+		}
+		index := utils.M(v)
+		if index == nil {
+			return nil, nil, errs.E(errs.InvalidJSON, "Index "+name+" must be an object.")
+		}
+		if utils.S(index["__op"]) == "Delete" {
+			if existingIndexes[name] == nil {
+				return nil, nil, errs.E(errs.InvalidQuery, "Index "+name+" does not exist, cannot delete.")
+			}
+			deletedIndexes = append(deletedIndexes, name)
+			deleted[name] = true
+			continue
+		}
+		if existingIndexes[name] != nil {
+			return nil, nil, errs.E(errs.InvalidQuery, "Index "+name+" exists, cannot update.")
+		}
+		result, err := validateIndex(name, index, fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		createdIndexes[name] = result
+	}
+
+	// 保留的索引中的字段必须存在，每个类只能有一个全文索引
+	textIndexes := 0
+	for _, index := range createdIndexes {
+		if storage.IndexIsText(utils.M(index)) {
+			textIndexes++
+		}
+	}
+	for name, v := range existingIndexes {
+		index := utils.M(v)
+		if index == nil || deleted[name] {
+			continue
+		}
+		if storage.IndexIsText(index) {
+			textIndexes++
+		}
+		for _, field := range storage.IndexFields(index) {
+			if fields[field.Name] == nil {
+				return nil, nil, errs.E(errs.InvalidQuery, "Field "+field.Name+" is used by index "+name+", cannot delete.")
+			}
+		}
+	}
+	if textIndexes > 1 {
+		return nil, nil, errs.E(errs.InvalidQuery, "Only one text index is allowed per class.")
+	}
+
+	return createdIndexes, deletedIndexes, nil
+}
+
+// validateIndex 校验单个索引定义，返回格式化之后的索引定义
+func validateIndex(name string, index, fields types.M) (types.M, error) {
+	result := types.M{}
+	for option, v := range index {
+		switch option {
+		case "keys":
+		case "sparse", "text":
+			if _, ok := v.(bool); ok == false {
+				return nil, errs.E(errs.InvalidJSON, option+" of index "+name+" must be a boolean.")
+			}
+			result[option] = v
+		case "expireAfterSeconds":
+			seconds, ok := v.(float64)
+			if i, isInt := v.(int); isInt {
+				seconds, ok = float64(i), true
+			}
+			if ok == false || seconds < 1 || seconds != float64(int(seconds)) {
+				return nil, errs.E(errs.InvalidJSON, "expireAfterSeconds of index "+name+" must be a positive integer.")
+			}
+			result[option] = int(seconds)
+		case "language":
+			if indexLanguageRegex.MatchString(utils.S(v)) == false {
+				return nil, errs.E(errs.InvalidJSON, "Invalid language for index "+name+".")
+			}
+			result[option] = v
+		default:
+			return nil, errs.E(errs.InvalidJSON, "Invalid index option "+option+" for index "+name+".")
+		}
+	}
+
+	keys := utils.A(index["keys"])
+	if len(keys) == 0 {
+		return nil, errs.E(errs.InvalidJSON, "Index "+name+" must contain keys.")
+	}
+	text := storage.IndexIsText(result)
+	seen := map[string]bool{}
+	resultKeys := types.S{}
+	for _, k := range keys {
+		key := utils.S(k)
+		fieldName := strings.TrimPrefix(key, "-")
+		if fieldName == "" {
+			return nil, errs.E(errs.InvalidJSON, "Index "+name+" must contain keys.")
+		}
+		if seen[fieldName] {
+			return nil, errs.E(errs.InvalidQuery, "Field "+fieldName+" is duplicated in index "+name+".")
+		}
+		seen[fieldName] = true
+		fieldType := utils.M(fields[fieldName])
+		if fieldType == nil {
+			return nil, errs.E(errs.InvalidQuery, "Field "+fieldName+" does not exist, cannot add index.")
+		}
+		switch utils.S(fieldType["type"]) {
+		case "Relation", "ACL", "GeoPoint", "Polygon":
+			return nil, errs.E(errs.InvalidQuery, "Field "+fieldName+" cannot be indexed.")
+		}
+		if text && (utils.S(fieldType["type"]) != "String" || key != fieldName) {
+			return nil, errs.E(errs.InvalidQuery, "Text index "+name+" only supports String fields.")
+		}
+		resultKeys = append(resultKeys, key)
+	}
+	result["keys"] = resultKeys
+
+	if result["language"] != nil && text == false {
+		return nil, errs.E(errs.InvalidQuery, "language is only supported by text index.")
+	}
+	if _, ok := result["expireAfterSeconds"]; ok {
+		if text || len(resultKeys) != 1 || utils.S(utils.M(fields[strings.TrimPrefix(utils.S(resultKeys[0]), "-")])["type"]) != "Date" {
+			return nil, errs.E(errs.InvalidQuery, "TTL index "+name+" must contain exactly one Date field.")
+		}
+	}
+
+	return result, nil
+}
+
 // buildMergedSchemaObject 组装数据库类型的 existingFields 与 API 类型的 putRequest，
 // 返回值中不包含默认字段，返回的是 API 类型的数据
 func buildMergedSchemaObject(existingFields types.M, putRequest types.M) types.M {
@@ -1200,9 +1806,16 @@ func injectDefaultSchema(schema types.M) types.M {
 			newfields[k] = v
 		}
 	}
+	mergeFieldConstraints(newfields, fieldConstraintsOf(schema))
 	newSchema["fields"] = newfields
 	newSchema["className"] = schema["className"]
 	newSchema["classLevelPermissions"] = schema["classLevelPermissions"]
+	if indexes := utils.M(schema["indexes"]); len(indexes) > 0 {
+		newSchema["indexes"] = types.M(indexes)
+	}
+	if options := utils.M(schema["options"]); len(options) > 0 {
+		newSchema["options"] = types.M(options)
+	}
 
 	return newSchema
 }
@@ -30,7 +30,7 @@ func TestPostgres_AddClassIfNotExists(t *testing.T) {
 		"key": types.M{"type": "String"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.AddClassIfNotExists(className, fields, classLevelPermissions)
+	result, err = schama.AddClassIfNotExists(className, fields, classLevelPermissions, nil)
 	expect = types.M{
 		"className": className,
 		"fields": types.M{
@@ -66,7 +66,7 @@ func TestPostgres_AddClassIfNotExists(t *testing.T) {
 		"key": types.M{"type": "String"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.AddClassIfNotExists(className, fields, classLevelPermissions)
+	result, err = schama.AddClassIfNotExists(className, fields, classLevelPermissions, nil)
 	expect = errs.E(errs.InvalidClassName, "Class "+className+" already exists.")
 	if err == nil || reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", result, err)
@@ -88,7 +88,7 @@ func TestPostgres_UpdateClass(t *testing.T) {
 	className = "user"
 	submittedFields = nil
 	classLevelPermissions = nil
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = errs.E(errs.InvalidClassName, "Class "+className+" does not exist.")
 	if err == nil || reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", result, err)
@@ -107,7 +107,7 @@ func TestPostgres_UpdateClass(t *testing.T) {
 		"key": types.M{"type": "String"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = errs.E(errs.ClassNotEmpty, "Field key exists, cannot update.")
 	if err == nil || reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", result, err)
@@ -126,7 +126,7 @@ func TestPostgres_UpdateClass(t *testing.T) {
 		"key1": types.M{"__op": "Delete"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = errs.E(errs.ClassNotEmpty, "Field key1 does not exist, cannot delete.")
 	if err == nil || reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", result, err)
@@ -145,7 +145,7 @@ func TestPostgres_UpdateClass(t *testing.T) {
 		"key1": types.M{"type": "String"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = types.M{
 		"className": className,
 		"fields": types.M{
@@ -183,7 +183,7 @@ func TestPostgres_UpdateClass(t *testing.T) {
 		"key":  types.M{"__op": "Delete"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = types.M{
 		"className": className,
 		"fields": types.M{
@@ -222,7 +222,7 @@ func TestPostgres_UpdateClass(t *testing.T) {
 		"key2": types.M{"__op": "Delete"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = types.M{
 		"className": className,
 		"fields": types.M{
@@ -265,7 +265,7 @@ func TestPostgres_UpdateClass(t *testing.T) {
 		"update": types.M{"*": true},
 		"delete": types.M{"*": true},
 	}
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = types.M{
 		"className": className,
 		"fields": types.M{
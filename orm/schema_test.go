@@ -12,6 +12,7 @@ import (
 	"github.com/lfq7413/tomato/storage/mongo"
 	"github.com/lfq7413/tomato/test"
 	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
 )
 
 func Test_AddClassIfNotExists(t *testing.T) {
@@ -30,7 +31,7 @@ func Test_AddClassIfNotExists(t *testing.T) {
 		"key": types.M{"type": "String"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.AddClassIfNotExists(className, fields, classLevelPermissions)
+	result, err = schama.AddClassIfNotExists(className, fields, classLevelPermissions, nil)
 	expect = types.M{
 		"className": className,
 		"fields": types.M{
@@ -66,7 +67,7 @@ func Test_AddClassIfNotExists(t *testing.T) {
 		"key": types.M{"type": "String"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.AddClassIfNotExists(className, fields, classLevelPermissions)
+	result, err = schama.AddClassIfNotExists(className, fields, classLevelPermissions, nil)
 	expect = errs.E(errs.InvalidClassName, "Class "+className+" already exists.")
 	if err == nil || reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", result, err)
@@ -88,7 +89,7 @@ func Test_UpdateClass(t *testing.T) {
 	className = "user"
 	submittedFields = nil
 	classLevelPermissions = nil
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = errs.E(errs.InvalidClassName, "Class "+className+" does not exist.")
 	if err == nil || reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", result, err)
@@ -107,7 +108,7 @@ func Test_UpdateClass(t *testing.T) {
 		"key": types.M{"type": "String"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = errs.E(errs.ClassNotEmpty, "Field key exists, cannot update.")
 	if err == nil || reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", result, err)
@@ -126,7 +127,7 @@ func Test_UpdateClass(t *testing.T) {
 		"key1": types.M{"__op": "Delete"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = errs.E(errs.ClassNotEmpty, "Field key1 does not exist, cannot delete.")
 	if err == nil || reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", result, err)
@@ -145,7 +146,7 @@ func Test_UpdateClass(t *testing.T) {
 		"key1": types.M{"type": "String"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = types.M{
 		"className": className,
 		"fields": types.M{
@@ -183,7 +184,7 @@ func Test_UpdateClass(t *testing.T) {
 		"key":  types.M{"__op": "Delete"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = types.M{
 		"className": className,
 		"fields": types.M{
@@ -222,7 +223,7 @@ func Test_UpdateClass(t *testing.T) {
 		"key2": types.M{"__op": "Delete"},
 	}
 	classLevelPermissions = nil
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = types.M{
 		"className": className,
 		"fields": types.M{
@@ -265,7 +266,7 @@ func Test_UpdateClass(t *testing.T) {
 		"update": types.M{"*": true},
 		"delete": types.M{"*": true},
 	}
-	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions)
+	result, err = schama.UpdateClass(className, submittedFields, classLevelPermissions, nil)
 	expect = types.M{
 		"className": className,
 		"fields": types.M{
@@ -530,6 +531,226 @@ func Test_validateObject(t *testing.T) {
 	adapter.DeleteAllClasses()
 }
 
+func Test_validateObjectWithConstraints(t *testing.T) {
+	adapter := getAdapter()
+	schama := getSchema()
+	var object types.M
+	var err error
+	var expect error
+	schama.AddClassIfNotExists("post", types.M{
+		"title":  types.M{"type": "String", "required": true, "minLength": 2, "maxLength": 5},
+		"status": types.M{"type": "String", "defaultValue": "draft", "enum": types.S{"draft", "published"}},
+		"code":   types.M{"type": "String", "pattern": "^[A-Z]+$"},
+		"rating": types.M{"type": "Number", "min": 1, "max": 5},
+		"tags":   types.M{"type": "Array", "maxLength": 2},
+	}, nil, nil)
+	schama.reloadData(types.M{"clearCache": true})
+	/************************************************************/
+	object = types.M{"title": "hello"}
+	err = schama.validateObject("post", object, types.M{})
+	if err != nil || object["status"] != "draft" {
+		t.Error("expect:", "draft", "result:", object, err)
+	}
+	/************************************************************/
+	object = types.M{"title": "hello", "status": "published"}
+	err = schama.validateObject("post", object, types.M{})
+	if err != nil || object["status"] != "published" {
+		t.Error("expect:", "published", "result:", object, err)
+	}
+	/************************************************************/
+	object = types.M{"status": "draft"}
+	err = schama.validateObject("post", object, types.M{})
+	expect = errs.E(errs.ValidationError, "title is required.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"rating": 3}
+	err = schama.validateObject("post", object, types.M{"objectId": "01"})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	if _, ok := object["status"]; ok {
+		t.Error("expect:", "no status", "result:", object)
+	}
+	/************************************************************/
+	object = types.M{"title": types.M{"__op": "Delete"}}
+	err = schama.validateObject("post", object, types.M{"objectId": "01"})
+	expect = errs.E(errs.ValidationError, "title is required.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"title": "h"}
+	err = schama.validateObject("post", object, types.M{"objectId": "01"})
+	expect = errs.E(errs.ValidationError, "length of title must be greater than or equal to 2.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"title": "你好世界"}
+	err = schama.validateObject("post", object, types.M{"objectId": "01"})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"title": "hello", "status": "deleted"}
+	err = schama.validateObject("post", object, types.M{})
+	expect = errs.E(errs.ValidationError, "status must be one of the enum values.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"code": "abc"}
+	err = schama.validateObject("post", object, types.M{"objectId": "01"})
+	expect = errs.E(errs.ValidationError, "code does not match the pattern.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"rating": 6}
+	err = schama.validateObject("post", object, types.M{"objectId": "01"})
+	expect = errs.E(errs.ValidationError, "rating must be less than or equal to 5.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"rating": types.M{"__op": "Increment", "amount": 10}}
+	// 更新时的 __op 操作在 DBController.Update 中根据当前值校验
+	err = schama.validateObject("post", object, types.M{"objectId": "01"})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"title": "hello", "rating": types.M{"__op": "Increment", "amount": 10}}
+	err = schama.validateObject("post", object, types.M{})
+	expect = errs.E(errs.ValidationError, "rating must be less than or equal to 5.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"title": "hello", "rating": types.M{"__op": "Increment", "amount": 3}}
+	err = schama.validateObject("post", object, types.M{})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"tags": types.M{"__op": "Add", "objects": types.S{"a"}}}
+	// 更新时的 __op 操作在 DBController.Update 中根据当前值校验
+	err = schama.validateObject("post", object, types.M{"objectId": "01"})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"title": "hello", "tags": types.M{"__op": "Add", "objects": types.S{"a", "b", "c"}}}
+	err = schama.validateObject("post", object, types.M{})
+	expect = errs.E(errs.ValidationError, "length of tags must be less than or equal to 2.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"title": "hello", "tags": types.M{"__op": "AddUnique", "objects": types.S{"a", "a", "b"}}}
+	err = schama.validateObject("post", object, types.M{})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	/************************************************************/
+	object = types.M{"tags": types.S{"a", "b", "c"}}
+	err = schama.validateObject("post", object, types.M{"objectId": "01"})
+	expect = errs.E(errs.ValidationError, "length of tags must be less than or equal to 2.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	schama.data = nil
+	adapter.DeleteAllClasses()
+}
+
+func Test_FieldConstraints(t *testing.T) {
+	adapter := getAdapter()
+	schama := getSchema()
+	var result types.M
+	var err error
+	var expect interface{}
+	/************************************************************/
+	result, err = schama.AddClassIfNotExists("post", types.M{
+		"title": types.M{"type": "String", "required": true},
+		"body":  types.M{"type": "String"},
+	}, nil, nil)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	if title := utils.M(utils.M(result["fields"])["title"]); title["type"] != "String" || title["required"] != true {
+		t.Error("expect:", "required", "result:", result["fields"])
+	}
+	sch, _ := schama.GetOneSchema("post", false, types.M{"clearCache": true})
+	if title := utils.M(utils.M(sch["fields"])["title"]); title["type"] != "String" || title["required"] != true {
+		t.Error("expect:", "required", "result:", sch["fields"])
+	}
+	if _, ok := HideInternalFields(sch)["options"]; ok {
+		t.Error("expect:", "no options", "result:", HideInternalFields(sch))
+	}
+	/************************************************************/
+	_, err = schama.UpdateClass("post", types.M{"body": types.M{"type": "Number", "min": 1}}, nil, nil)
+	expect = errs.E(errs.ClassNotEmpty, "Field body exists, cannot update.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	_, err = schama.UpdateClass("post", types.M{"body": types.M{"type": "String", "maxLength": "1"}}, nil, nil)
+	expect = errs.E(errs.InvalidJSON, "maxLength must be an integer greater than or equal to 0")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	_, err = schama.UpdateClass("post", types.M{"createdAt": types.M{"type": "Date", "required": true}}, nil, nil)
+	expect = errs.E(errs.ChangedImmutableFieldError, "field createdAt cannot be changed")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	result, err = schama.UpdateClass("post", types.M{
+		"title": types.M{"type": "String", "required": nil},
+		"body":  types.M{"type": "String", "maxLength": float64(100)},
+		"views": types.M{"type": "Number", "defaultValue": float64(0)},
+	}, nil, nil)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	fields := utils.M(result["fields"])
+	if title := utils.M(fields["title"]); len(title) != 1 || title["type"] != "String" {
+		t.Error("expect:", "String", "result:", fields["title"])
+	}
+	if body := utils.M(fields["body"]); len(body) != 2 || body["maxLength"] != float64(100) {
+		t.Error("expect:", 100, "result:", fields["body"])
+	}
+	if views := utils.M(fields["views"]); len(views) != 2 || views["type"] != "Number" || views["defaultValue"] != float64(0) {
+		t.Error("expect:", 0, "result:", fields["views"])
+	}
+	/************************************************************/
+	_, err = schama.UpdateClass("post", types.M{"body": types.M{"__op": "Delete"}}, nil, nil)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	sch, _ = schama.GetOneSchema("post", false, types.M{"clearCache": true})
+	if constraints := fieldConstraintsOf(sch); len(constraints) != 1 || utils.M(constraints["views"])["defaultValue"] != float64(0) {
+		t.Error("expect:", "views", "result:", constraints)
+	}
+	/************************************************************/
+	_, err = schama.SetClassOptions("post", types.M{"fieldConstraints": types.M{}})
+	expect = errs.E(errs.InvalidJSON, "Invalid class option: fieldConstraints")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	_, err = schama.SetClassOptions("post", types.M{"history": types.M{}})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	sch, _ = schama.GetOneSchema("post", false, types.M{"clearCache": true})
+	if constraints := fieldConstraintsOf(sch); len(constraints) != 1 || utils.M(constraints["views"])["defaultValue"] != float64(0) {
+		t.Error("expect:", "views", "result:", constraints)
+	}
+	schama.data = nil
+	adapter.DeleteAllClasses()
+}
+
 func Test_testBaseCLP(t *testing.T) {
 	schama := getSchema()
 	var className string
@@ -778,6 +999,63 @@ func Test_EnforceClassExists(t *testing.T) {
 	adapter.DeleteAllClasses()
 }
 
+func Test_EnsureIdempotencyClass(t *testing.T) {
+	schama := getSchema()
+	adapter := schama.dbAdapter
+	var className string
+	var err, expect error
+	/************************************************************/
+	// 表已经存在但没有唯一索引时补上索引
+	className = "_Idempotency"
+	class := types.M{
+		"fields": types.M{
+			"reqId":  types.M{"type": "String"},
+			"expire": types.M{"type": "Date"},
+		},
+	}
+	adapter.CreateClass(className, class)
+	err = schama.EnsureIdempotencyClass()
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	err = adapter.CreateObject(className, class, types.M{"objectId": "01", "reqId": "abc"})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	err = adapter.CreateObject(className, class, types.M{"objectId": "02", "reqId": "abc"})
+	expect = errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	adapter.DeleteAllClasses()
+	resetIdempotencyClass()
+	/************************************************************/
+	schama.reloadData(types.M{"clearCache": true})
+	err = schama.EnsureIdempotencyClass()
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	err = adapter.CreateObject(className, class, types.M{"objectId": "01", "reqId": "abc"})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	err = adapter.CreateObject(className, class, types.M{"objectId": "02", "reqId": "abc"})
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	adapter.DeleteAllClasses()
+	/************************************************************/
+	// 检查成功之后不再访问数据库
+	err = schama.EnsureIdempotencyClass()
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	if exist := adapter.ClassExists(className); exist {
+		t.Error("expect:", false, "result:", exist)
+	}
+	resetIdempotencyClass()
+}
+
 func Test_validateNewClass(t *testing.T) {
 	adapter := getAdapter()
 	schama := getSchema()
@@ -1934,6 +2212,26 @@ func Test_getObjectType(t *testing.T) {
 		t.Error("expect:", expect, "result:", result, err)
 	}
 	/************************************************************/
+	object = types.M{
+		"__type":      "Polygon",
+		"coordinates": types.S{types.S{0.0, 0.0}, types.S{0.0, 10.0}, types.S{10.0, 10.0}},
+	}
+	result, err = getObjectType(object)
+	expect = types.M{"type": "Polygon"}
+	if err != nil || reflect.DeepEqual(expect, result) == false {
+		t.Error("expect:", expect, "result:", result, err)
+	}
+	/************************************************************/
+	object = types.M{
+		"__type":      "Polygon",
+		"coordinates": types.S{types.S{0.0, 0.0}, types.S{0.0, 10.0}, types.S{0.0, 0.0}},
+	}
+	result, err = getObjectType(object)
+	expect = errs.E(errs.InvalidJSON, "Polygon must have at least 3 different vertices")
+	if err == nil || reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", result, err)
+	}
+	/************************************************************/
 	object = types.M{
 		"__type": "Other",
 	}
@@ -2394,6 +2692,15 @@ func Test_fieldTypeIsInvalid(t *testing.T) {
 		t.Error("expect:", expect, "result:", err)
 	}
 	/************************************************************/
+	tp = types.M{
+		"type": "Bytes",
+	}
+	err = fieldTypeIsInvalid(tp)
+	expect = nil
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
 	tp = types.M{
 		"type": "Boolean",
 	}
@@ -2456,6 +2763,125 @@ func Test_fieldTypeIsInvalid(t *testing.T) {
 	if reflect.DeepEqual(expect, err) == false {
 		t.Error("expect:", expect, "result:", err)
 	}
+	/************************************************************/
+	tp = types.M{"type": "String", "required": true, "defaultValue": "draft", "enum": types.S{"draft", "published"}, "maxLength": 10, "pattern": "^[a-z]+$"}
+	err = fieldTypeIsInvalid(tp)
+	expect = nil
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "Number", "min": 0, "max": 100, "defaultValue": 50}
+	err = fieldTypeIsInvalid(tp)
+	expect = nil
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "Pointer", "targetClass": "_User", "required": true}
+	err = fieldTypeIsInvalid(tp)
+	expect = nil
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "Relation", "targetClass": "_User", "required": true}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.IncorrectType, "type Relation does not support required")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "String", "required": "yes"}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.InvalidJSON, "required must be a boolean")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "String", "defaultValue": 10}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.IncorrectType, "defaultValue must be of type String")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "Pointer", "targetClass": "_User", "defaultValue": types.M{"__type": "Pointer", "className": "post", "objectId": "01"}}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.IncorrectType, "defaultValue must be of type Pointer<_User>")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "String", "min": 1}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.IncorrectType, "min is only supported on Number fields")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "Number", "max": "10"}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.InvalidJSON, "max must be a number")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "Number", "minLength": 1}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.IncorrectType, "minLength is only supported on String and Array fields")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "Array", "maxLength": -1}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.InvalidJSON, "maxLength must be an integer greater than or equal to 0")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "String", "pattern": "[a-"}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.InvalidJSON, "pattern must be a valid regular expression")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "Boolean", "enum": types.S{true}}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.IncorrectType, "enum is only supported on String and Number fields")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "String", "enum": types.S{}}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.InvalidJSON, "enum must be a non-empty array")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "Number", "enum": types.S{1, "2"}}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.IncorrectType, "enum values must be of type Number")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "Number", "min": 10, "max": 1}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.InvalidJSON, "min must be less than or equal to max")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
+	/************************************************************/
+	tp = types.M{"type": "String", "maxLength": 3, "defaultValue": "draft"}
+	err = fieldTypeIsInvalid(tp)
+	expect = errs.E(errs.ValidationError, "length of defaultValue must be less than or equal to 3.")
+	if reflect.DeepEqual(expect, err) == false {
+		t.Error("expect:", expect, "result:", err)
+	}
 }
 
 func Test_validateCLP(t *testing.T) {
@@ -2745,6 +3171,283 @@ func Test_verifyPermissionKey(t *testing.T) {
 	}
 }
 
+func Test_validateClassOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		className string
+		options   types.M
+		wantErr   error
+	}{
+		{
+			name:      "1",
+			className: "post",
+			options:   types.M{"softDelete": types.M{"retentionDays": float64(7)}},
+			wantErr:   nil,
+		},
+		{
+			name:      "2",
+			className: "post",
+			options:   types.M{"softDelete": types.M{}},
+			wantErr:   nil,
+		},
+		{
+			name:      "3",
+			className: "post",
+			options:   types.M{"softDelete": true},
+			wantErr:   errs.E(errs.InvalidJSON, "softDelete must be an object."),
+		},
+		{
+			name:      "4",
+			className: "post",
+			options:   types.M{"softDelete": types.M{"retentionDays": float64(1.5)}},
+			wantErr:   errs.E(errs.InvalidJSON, "retentionDays must be an integer greater than or equal to 0."),
+		},
+		{
+			name:      "5",
+			className: "post",
+			options:   types.M{"softDelete": types.M{"days": float64(1)}},
+			wantErr:   errs.E(errs.InvalidJSON, "Invalid softDelete option: days"),
+		},
+		{
+			name:      "6",
+			className: "_User",
+			options:   types.M{"softDelete": types.M{}},
+			wantErr:   errs.E(errs.InvalidClassName, "Soft delete is not supported on class _User."),
+		},
+		{
+			name:      "7",
+			className: "post",
+			options:   types.M{"archive": true},
+			wantErr:   errs.E(errs.InvalidJSON, "Invalid class option: archive"),
+		},
+		{
+			name:      "8",
+			className: "post",
+			options:   types.M{"history": types.M{}, "softDelete": types.M{}},
+			wantErr:   nil,
+		},
+		{
+			name:      "9",
+			className: "post",
+			options:   types.M{"history": types.M{"fields": types.S{"title"}}},
+			wantErr:   errs.E(errs.InvalidJSON, "Invalid history option: fields"),
+		},
+		{
+			name:      "10",
+			className: "_Role",
+			options:   types.M{"history": types.M{}},
+			wantErr:   errs.E(errs.InvalidClassName, "History is not supported on class _Role."),
+		},
+		{
+			name:      "11",
+			className: "_User",
+			options:   types.M{"encryptedFields": types.M{"ssn": types.M{"searchable": true}, "address": types.M{}}},
+			wantErr:   nil,
+		},
+		{
+			name:      "12",
+			className: "_User",
+			options:   types.M{"encryptedFields": types.M{"email": types.M{}}},
+			wantErr:   errs.E(errs.InvalidKeyName, "Field email cannot be encrypted."),
+		},
+		{
+			name:      "13",
+			className: "post",
+			options:   types.M{"encryptedFields": types.M{"ssn": types.M{"searchable": "yes"}}},
+			wantErr:   errs.E(errs.InvalidJSON, "searchable must be a boolean."),
+		},
+		{
+			name:      "14",
+			className: "_Role",
+			options:   types.M{"encryptedFields": types.M{"ssn": types.M{}}},
+			wantErr:   errs.E(errs.InvalidClassName, "Field encryption is not supported on class _Role."),
+		},
+		{
+			name:      "15",
+			className: "otp",
+			options:   types.M{"ttl": types.M{"field": "expiresAt", "retentionSeconds": float64(60), "mode": "destroy"}},
+			wantErr:   nil,
+		},
+		{
+			name:      "16",
+			className: "otp",
+			options:   types.M{"ttl": types.M{"retentionSeconds": float64(60)}},
+			wantErr:   errs.E(errs.InvalidJSON, "ttl must contain field."),
+		},
+		{
+			name:      "17",
+			className: "otp",
+			options:   types.M{"ttl": types.M{"field": "expiresAt", "mode": "slow"}},
+			wantErr:   errs.E(errs.InvalidJSON, "mode must be fast or destroy."),
+		},
+		{
+			name:      "18",
+			className: "_Session",
+			options:   types.M{"ttl": types.M{"field": "expiresAt"}},
+			wantErr:   errs.E(errs.InvalidClassName, "TTL is not supported on class _Session."),
+		},
+	}
+	for _, tt := range tests {
+		err := validateClassOptions(tt.className, tt.options)
+		if reflect.DeepEqual(err, tt.wantErr) == false {
+			t.Errorf("%q. validateClassOptions() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func Test_validateIndexes(t *testing.T) {
+	var fields types.M
+	var existingIndexes types.M
+	var submittedIndexes types.M
+	var created types.M
+	var deleted []string
+	var err error
+	var expectCreated types.M
+	var expectDeleted []string
+	var expectErr error
+	fields = types.M{
+		"objectId":  types.M{"type": "String"},
+		"createdAt": types.M{"type": "Date"},
+		"name":      types.M{"type": "String"},
+		"age":       types.M{"type": "Number"},
+		"expireAt":  types.M{"type": "Date"},
+		"friends":   types.M{"type": "Relation", "targetClass": "_User"},
+	}
+	/************************************************************/
+	existingIndexes = nil
+	submittedIndexes = nil
+	created, deleted, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectCreated = types.M{}
+	expectDeleted = []string{}
+	if reflect.DeepEqual(expectCreated, created) == false || reflect.DeepEqual(expectDeleted, deleted) == false || err != nil {
+		t.Error("expect:", expectCreated, expectDeleted, "result:", created, deleted, err)
+	}
+	/************************************************************/
+	existingIndexes = types.M{
+		"old": types.M{"keys": types.S{"age"}},
+	}
+	submittedIndexes = types.M{
+		"name_age": types.M{"keys": types.S{"name", "-age"}, "sparse": true},
+		"expire":   types.M{"keys": types.S{"expireAt"}, "expireAfterSeconds": 3600.0},
+		"search":   types.M{"keys": types.S{"name"}, "text": true, "language": "english"},
+		"old":      types.M{"__op": "Delete"},
+	}
+	created, deleted, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectCreated = types.M{
+		"name_age": types.M{"keys": types.S{"name", "-age"}, "sparse": true},
+		"expire":   types.M{"keys": types.S{"expireAt"}, "expireAfterSeconds": 3600},
+		"search":   types.M{"keys": types.S{"name"}, "text": true, "language": "english"},
+	}
+	expectDeleted = []string{"old"}
+	if reflect.DeepEqual(expectCreated, created) == false || reflect.DeepEqual(expectDeleted, deleted) == false || err != nil {
+		t.Error("expect:", expectCreated, expectDeleted, "result:", created, deleted, err)
+	}
+	/************************************************************/
+	existingIndexes = nil
+	submittedIndexes = types.M{"1abc": types.M{"keys": types.S{"name"}}}
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidQuery, "Invalid index name: 1abc")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/************************************************************/
+	existingIndexes = nil
+	submittedIndexes = types.M{"old": types.M{"__op": "Delete"}}
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidQuery, "Index old does not exist, cannot delete.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/************************************************************/
+	existingIndexes = types.M{"old": types.M{"keys": types.S{"age"}}}
+	submittedIndexes = types.M{"old": types.M{"keys": types.S{"name"}}}
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidQuery, "Index old exists, cannot update.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/************************************************************/
+	existingIndexes = nil
+	submittedIndexes = types.M{"idx": types.M{"keys": types.S{}}}
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidJSON, "Index idx must contain keys.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/************************************************************/
+	existingIndexes = nil
+	submittedIndexes = types.M{"idx": types.M{"keys": types.S{"other"}}}
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidQuery, "Field other does not exist, cannot add index.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/************************************************************/
+	existingIndexes = nil
+	submittedIndexes = types.M{"idx": types.M{"keys": types.S{"friends"}}}
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidQuery, "Field friends cannot be indexed.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/************************************************************/
+	existingIndexes = nil
+	submittedIndexes = types.M{"idx": types.M{"keys": types.S{"name", "-name"}}}
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidQuery, "Field name is duplicated in index idx.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/************************************************************/
+	existingIndexes = nil
+	submittedIndexes = types.M{"idx": types.M{"keys": types.S{"name"}, "unique": true}}
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidJSON, "Invalid index option unique for index idx.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/************************************************************/
+	existingIndexes = nil
+	submittedIndexes = types.M{"idx": types.M{"keys": types.S{"age"}, "text": true}}
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidQuery, "Text index idx only supports String fields.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/************************************************************/
+	existingIndexes = types.M{"search": types.M{"keys": types.S{"name"}, "text": true}}
+	submittedIndexes = types.M{"idx": types.M{"keys": types.S{"objectId"}, "text": true}}
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidQuery, "Only one text index is allowed per class.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/************************************************************/
+	existingIndexes = nil
+	submittedIndexes = types.M{"idx": types.M{"keys": types.S{"age"}, "expireAfterSeconds": 60}}
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidQuery, "TTL index idx must contain exactly one Date field.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/************************************************************/
+	existingIndexes = nil
+	submittedIndexes = types.M{"idx": types.M{"keys": types.S{"expireAt"}, "expireAfterSeconds": 1.5}}
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidJSON, "expireAfterSeconds of index idx must be a positive integer.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	/************************************************************/
+	existingIndexes = types.M{"idx": types.M{"keys": types.S{"nickname"}}}
+	submittedIndexes = nil
+	_, _, err = validateIndexes(fields, existingIndexes, submittedIndexes)
+	expectErr = errs.E(errs.InvalidQuery, "Field nickname is used by index idx, cannot delete.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+}
+
 func Test_buildMergedSchemaObject(t *testing.T) {
 	var existingFields types.M
 	var putRequest types.M
@@ -69,12 +69,33 @@ func (q *pushQueue) enqueue(body, where types.M, auth *rest.Auth, status *pushSt
 	}
 	status.setRunning(count)
 
-	for skip := 0; skip < count; skip += limit {
+	// 按照游标分页，每个任务从上一页最后一个对象之后开始查询，推送过程中数据发生变化时不会重复或遗漏
+	cursor := ""
+	for {
+		// 第一页传入 true 开启游标分页
+		var pageCursor interface{} = true
+		if cursor != "" {
+			pageCursor = cursor
+		}
+		pageOptions := types.M{
+			"limit":  limit,
+			"order":  order,
+			"keys":   "objectId",
+			"cursor": pageCursor,
+		}
+		page, err := rest.Find(auth, "_Installation", where, pageOptions, nil)
+		if err != nil {
+			return err
+		}
+		if len(utils.A(page["results"])) == 0 {
+			break
+		}
+
 		query := types.M{
-			"where": where,
-			"limit": limit,
-			"skip":  skip,
-			"order": order,
+			"where":  where,
+			"limit":  limit,
+			"order":  order,
+			"cursor": pageCursor,
 		}
 		pushWorkItem := types.M{
 			"body":       body,
@@ -86,6 +107,11 @@ func (q *pushQueue) enqueue(body, where types.M, auth *rest.Auth, status *pushSt
 			return err
 		}
 		q.parsePublisher.Publish(q.channel, string(b))
+
+		cursor = utils.S(page["nextCursor"])
+		if cursor == "" {
+			break
+		}
 	}
 
 	return nil
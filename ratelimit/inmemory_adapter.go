@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval 清理已过期计数的间隔
+const sweepInterval = time.Minute
+
+type inMemoryAdapter struct {
+	mu        sync.Mutex
+	counters  map[string]*counter
+	lastSweep time.Time
+}
+
+type counter struct {
+	count  int
+	expire time.Time
+}
+
+func newInMemoryAdapter() *inMemoryAdapter {
+	return &inMemoryAdapter{
+		counters:  map[string]*counter{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *inMemoryAdapter) incr(key string, window time.Duration) (int, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		for k, c := range m.counters {
+			if now.Before(c.expire) == false {
+				delete(m.counters, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	c, ok := m.counters[key]
+	if ok == false || now.Before(c.expire) == false {
+		c = &counter{expire: now.Add(window)}
+		m.counters[key] = c
+	}
+	c.count++
+	return c.count, c.expire.Sub(now), nil
+}
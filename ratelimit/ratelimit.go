@@ -0,0 +1,189 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/context"
+	"github.com/lfq7413/tomato/config"
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/logger"
+	"github.com/lfq7413/tomato/rest"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// 按 RateLimits 中的规则对请求计数，在路由之前执行，超过限制时返回 429
+// 计数使用固定时间窗口，保存在内存或 Redis 中，使用 Redis 时多个节点共享计数
+// 批量请求中的子请求分别计数
+// 按 ip 计数时使用直接连接的地址，只有该地址属于 TrustedProxies 时才从转发的请求头中获取客户端 ip
+
+var limits []config.RateLimit
+
+var trustedProxies []*net.IPNet
+
+var adapter Adapter
+
+func init() {
+	limits, _ = config.ParseRateLimits(config.TConfig.RateLimits)
+	trustedProxies, _ = config.ParseTrustedProxies(config.TConfig.TrustedProxies)
+	if config.TConfig.RateLimitAdapter == "Redis" && len(limits) > 0 {
+		adapter = newRedisAdapter(config.TConfig.RedisAddress, config.TConfig.RedisPassword)
+	} else {
+		adapter = newInMemoryAdapter()
+	}
+}
+
+// InitRateLimit 按 config 中的参数重新加载限流规则，计数保存在内存中，仅用于测试
+func InitRateLimit() {
+	limits, _ = config.ParseRateLimits(config.TConfig.RateLimits)
+	trustedProxies, _ = config.ParseTrustedProxies(config.TConfig.TrustedProxies)
+	adapter = newInMemoryAdapter()
+}
+
+// Adapter 限流计数的存储模块
+type Adapter interface {
+	// incr 对 key 计数加一，返回窗口内的计数与窗口的剩余时间，窗口从第一次计数开始
+	incr(key string, window time.Duration) (int, time.Duration, error)
+}
+
+// requestInfo 限流时使用的请求信息
+type requestInfo struct {
+	ip             string
+	masterKey      string
+	sessionToken   string
+	installationID string
+}
+
+// ErrRequestLimitExceeded 请求数超过限制时返回的错误
+var ErrRequestLimitExceeded = errs.E(errs.RequestLimitExceeded, "Too many requests.")
+
+// Filter 对匹配规则的请求计数，超过限制时返回 429
+func Filter(ctx *context.Context) {
+	retryAfter, err := Check(ctx, ctx.Input.Method(), ctx.Input.URL())
+	if err != nil {
+		ctx.Output.Header("Retry-After", strconv.Itoa(retryAfter))
+		ctx.Output.SetStatus(429)
+		ctx.Output.JSON(errs.ErrorToMap(err), false, false)
+	}
+}
+
+// Check 对 ctx 中以 method 访问 url 的请求计数，超过限制时返回 ErrRequestLimitExceeded 与需要等待的秒数
+// 批量请求中不经过路由的子请求通过 Check 计数
+func Check(ctx *context.Context, method, url string) (int, error) {
+	if len(limits) == 0 || method == "OPTIONS" {
+		return 0, nil
+	}
+	path := strings.TrimPrefix(url, "/v1/")
+	info := getRequestInfo(ctx)
+	for i, limit := range limits {
+		if limit.Match(method, path) == false {
+			continue
+		}
+		if limit.IncludeMaster == false && info.masterKey != "" && info.masterKey == config.TConfig.MasterKey {
+			continue
+		}
+		key := strings.Join([]string{config.TConfig.AppID, "ratelimit", strconv.Itoa(i), zoneKey(limit.Zone, info)}, ":")
+		count, reset, err := adapter.incr(key, time.Duration(limit.Window)*time.Second)
+		if err != nil {
+			// 计数失败时不限制请求
+			logger.Error("Rate limit failed:", err)
+			continue
+		}
+		if count > limit.Max {
+			retryAfter := int(math.Ceil(reset.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			return retryAfter, ErrRequestLimitExceeded
+		}
+	}
+	return 0, nil
+}
+
+// getRequestInfo 从请求头中获取 key ，请求头中不存在 AppID 时从请求数据中获取
+func getRequestInfo(ctx *context.Context) *requestInfo {
+	info := &requestInfo{
+		ip:             clientIP(ctx),
+		masterKey:      ctx.Input.Header("X-Parse-Master-Key"),
+		sessionToken:   ctx.Input.Header("X-Parse-Session-Token"),
+		installationID: ctx.Input.Header("X-Parse-Installation-Id"),
+	}
+	if ctx.Input.Header("X-Parse-Application-Id") != "" || len(ctx.Input.RequestBody) == 0 {
+		return info
+	}
+	var body map[string]interface{}
+	if json.Unmarshal(ctx.Input.RequestBody, &body) != nil {
+		return info
+	}
+	info.masterKey = utils.S(body["_MasterKey"])
+	info.sessionToken = utils.S(body["_SessionToken"])
+	info.installationID = utils.S(body["_InstallationId"])
+	return info
+}
+
+// clientIP 返回请求的客户端 ip ，直接连接的地址不是可信的代理时直接返回该地址
+// 否则从右向左查找 X-Forwarded-For 中第一个不是可信代理的地址，没有 X-Forwarded-For 时使用 X-Real-Ip
+func clientIP(ctx *context.Context) string {
+	ip, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
+	if err != nil {
+		ip = ctx.Request.RemoteAddr
+	}
+	if isTrustedProxy(ip) == false {
+		return ip
+	}
+	forwarded := ctx.Input.Header("X-Forwarded-For")
+	if forwarded == "" {
+		if realIP := strings.TrimSpace(ctx.Input.Header("X-Real-Ip")); realIP != "" {
+			return realIP
+		}
+		return ip
+	}
+	ips := strings.Split(forwarded, ",")
+	for i := len(ips) - 1; i >= 0; i-- {
+		v := strings.TrimSpace(ips[i])
+		if v == "" {
+			continue
+		}
+		ip = v
+		if isTrustedProxy(ip) == false {
+			break
+		}
+	}
+	return ip
+}
+
+// isTrustedProxy 判断 ip 是否属于 TrustedProxies
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneKey 返回请求在 zone 中的计数 key ，无法获取用户或设备时按 ip 计数
+func zoneKey(zone string, info *requestInfo) string {
+	switch zone {
+	case "user":
+		if info.sessionToken != "" {
+			auth, err := rest.GetAuthForSessionToken(info.sessionToken, info.installationID)
+			if err == nil && auth.User != nil && utils.S(auth.User["objectId"]) != "" {
+				return "user:" + utils.S(auth.User["objectId"])
+			}
+		}
+	case "installation":
+		if info.installationID != "" {
+			return "installation:" + info.installationID
+		}
+	}
+	return "ip:" + info.ip
+}
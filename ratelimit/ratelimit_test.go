@@ -0,0 +1,162 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/context"
+	"github.com/lfq7413/tomato/config"
+)
+
+func Test_inMemoryAdapter(t *testing.T) {
+	m := newInMemoryAdapter()
+	var count int
+	var reset time.Duration
+	var expect interface{}
+	/*******************************************************************/
+	count, reset, _ = m.incr("k1", time.Minute)
+	expect = 1
+	if reflect.DeepEqual(expect, count) == false || reset <= 0 || reset > time.Minute {
+		t.Error("expect:", expect, "result:", count, reset)
+	}
+	count, _, _ = m.incr("k1", time.Minute)
+	expect = 2
+	if reflect.DeepEqual(expect, count) == false {
+		t.Error("expect:", expect, "result:", count)
+	}
+	count, _, _ = m.incr("k2", time.Minute)
+	expect = 1
+	if reflect.DeepEqual(expect, count) == false {
+		t.Error("expect:", expect, "result:", count)
+	}
+	/*******************************************************************/
+	m.incr("k3", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	count, _, _ = m.incr("k3", time.Millisecond)
+	expect = 1
+	if reflect.DeepEqual(expect, count) == false {
+		t.Error("expect:", expect, "result:", count)
+	}
+}
+
+func Test_Filter(t *testing.T) {
+	config.TConfig.AppID = "test"
+	config.TConfig.MasterKey = "master"
+	defer func() {
+		limits = nil
+		adapter = newInMemoryAdapter()
+	}()
+	rules, err := config.ParseRateLimits("login:POST:ip:2:60|functions/*:*:installation:1:60|classes/Post:GET:ip:1:60:master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits = rules
+	adapter = newInMemoryAdapter()
+
+	type request struct {
+		method     string
+		url        string
+		remoteAddr string
+		header     map[string]string
+	}
+	tests := []struct {
+		name   string
+		req    request
+		expect int
+	}{
+		{name: "login 1", req: request{method: "POST", url: "/v1/login"}, expect: 200},
+		{name: "login 2", req: request{method: "POST", url: "/v1/login/"}, expect: 200},
+		{name: "login 3", req: request{method: "POST", url: "/v1/login"}, expect: 429},
+		{name: "login spoofed ip", req: request{method: "POST", url: "/v1/login", header: map[string]string{"X-Forwarded-For": "10.0.0.2", "X-Real-Ip": "10.0.0.3"}}, expect: 429},
+		{name: "login other ip", req: request{method: "POST", url: "/v1/login", remoteAddr: "10.0.0.2:1234"}, expect: 200},
+		{name: "login GET", req: request{method: "GET", url: "/v1/login"}, expect: 200},
+		{name: "login master", req: request{method: "POST", url: "/v1/login", header: map[string]string{"X-Parse-Master-Key": "master"}}, expect: 200},
+		{name: "function 1", req: request{method: "POST", url: "/v1/functions/hello", header: map[string]string{"X-Parse-Installation-Id": "i1"}}, expect: 200},
+		{name: "function 2", req: request{method: "POST", url: "/v1/functions/bye", header: map[string]string{"X-Parse-Installation-Id": "i1"}}, expect: 429},
+		{name: "function other installation", req: request{method: "POST", url: "/v1/functions/hello", header: map[string]string{"X-Parse-Installation-Id": "i2"}}, expect: 200},
+		{name: "class 1", req: request{method: "GET", url: "/v1/classes/Post", header: map[string]string{"X-Parse-Master-Key": "master"}}, expect: 200},
+		{name: "class 2", req: request{method: "GET", url: "/v1/classes/Post", header: map[string]string{"X-Parse-Master-Key": "master"}}, expect: 429},
+		{name: "other class", req: request{method: "GET", url: "/v1/classes/Posts"}, expect: 200},
+		{name: "options", req: request{method: "OPTIONS", url: "/v1/login"}, expect: 200},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.req.method, tt.req.url, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if tt.req.remoteAddr != "" {
+			req.RemoteAddr = tt.req.remoteAddr
+		}
+		for k, v := range tt.req.header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		ctx := context.NewContext()
+		ctx.Reset(w, req)
+		Filter(ctx)
+		if w.Code != tt.expect {
+			t.Errorf("%q. Filter() = %v, want %v", tt.name, w.Code, tt.expect)
+		}
+		if tt.expect == 429 && strings.Contains(w.Body.String(), `"code":155`) == false {
+			t.Errorf("%q. Filter() body = %v", tt.name, w.Body.String())
+		}
+	}
+}
+
+func Test_clientIP(t *testing.T) {
+	defer func() {
+		trustedProxies = nil
+	}()
+	proxies, err := config.ParseTrustedProxies("10.0.0.1|192.168.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedProxies = proxies
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     map[string]string
+		expect     string
+	}{
+		{name: "direct", remoteAddr: "1.2.3.4:1234", expect: "1.2.3.4"},
+		{name: "untrusted forwarded", remoteAddr: "1.2.3.4:1234", header: map[string]string{"X-Forwarded-For": "5.6.7.8"}, expect: "1.2.3.4"},
+		{name: "untrusted real ip", remoteAddr: "1.2.3.4:1234", header: map[string]string{"X-Real-Ip": "5.6.7.8"}, expect: "1.2.3.4"},
+		{name: "trusted no header", remoteAddr: "10.0.0.1:1234", expect: "10.0.0.1"},
+		{name: "trusted forwarded", remoteAddr: "10.0.0.1:1234", header: map[string]string{"X-Forwarded-For": "5.6.7.8"}, expect: "5.6.7.8"},
+		{name: "trusted chain", remoteAddr: "192.168.1.1:1234", header: map[string]string{"X-Forwarded-For": "9.9.9.9, 5.6.7.8, 192.168.2.2"}, expect: "5.6.7.8"},
+		{name: "trusted real ip", remoteAddr: "10.0.0.1:1234", header: map[string]string{"X-Real-Ip": "5.6.7.8"}, expect: "5.6.7.8"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/v1/login", nil)
+		req.RemoteAddr = tt.remoteAddr
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		ctx := context.NewContext()
+		ctx.Reset(httptest.NewRecorder(), req)
+		if result := clientIP(ctx); result != tt.expect {
+			t.Errorf("%q. clientIP() = %v, want %v", tt.name, result, tt.expect)
+		}
+	}
+}
+
+func Test_zoneKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		zone   string
+		info   *requestInfo
+		expect string
+	}{
+		{name: "ip", zone: "ip", info: &requestInfo{ip: "1.2.3.4", installationID: "i1"}, expect: "ip:1.2.3.4"},
+		{name: "installation", zone: "installation", info: &requestInfo{ip: "1.2.3.4", installationID: "i1"}, expect: "installation:i1"},
+		{name: "no installation", zone: "installation", info: &requestInfo{ip: "1.2.3.4"}, expect: "ip:1.2.3.4"},
+		{name: "no user", zone: "user", info: &requestInfo{ip: "1.2.3.4"}, expect: "ip:1.2.3.4"},
+	}
+	for _, tt := range tests {
+		if result := zoneKey(tt.zone, tt.info); result != tt.expect {
+			t.Errorf("%q. zoneKey() = %v, want %v", tt.name, result, tt.expect)
+		}
+	}
+}
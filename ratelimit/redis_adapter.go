@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// incrScript 计数加一，第一次计数时设置窗口时长，返回计数与剩余时间
+var incrScript = redis.NewScript(1, `
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`)
+
+type redisAdapter struct {
+	address  string
+	password string
+	p        *redis.Pool
+}
+
+func newRedisAdapter(address, password string) *redisAdapter {
+	m := &redisAdapter{
+		address:  address,
+		password: password,
+	}
+	m.p = &redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 180 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("tcp", m.address)
+			if err != nil {
+				return nil, err
+			}
+			if m.password != "" {
+				if _, err := c.Do("AUTH", m.password); err != nil {
+					c.Close()
+					return nil, err
+				}
+			}
+			return c, nil
+		},
+	}
+	return m
+}
+
+func (m *redisAdapter) incr(key string, window time.Duration) (int, time.Duration, error) {
+	c := m.p.Get()
+	defer c.Close()
+	values, err := redis.Ints(incrScript.Do(c, key, int64(window/time.Millisecond)))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(values) != 2 {
+		return 0, 0, redis.ErrNil
+	}
+	ttl := time.Duration(values[1]) * time.Millisecond
+	if ttl < 0 {
+		ttl = window
+	}
+	return values[0], ttl, nil
+}
@@ -0,0 +1,117 @@
+package rest
+
+import (
+	"context"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/types"
+)
+
+// 以 Context 结尾的函数与对应的函数相同，区别在于数据库操作与云代码都在 ctx 中执行，
+// ctx 取消或超时后，正在执行的数据库操作会被中止，并返回相应的错误
+
+// FindContext 在 ctx 中查找数据
+func FindContext(ctx context.Context, auth *Auth, className string, where, options types.M, clientSDK map[string]string) (types.M, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	result, err := find(orm.TomatoDBController.WithContext(ctx), auth, className, where, options, clientSDK)
+	return result, contextError(ctx, err)
+}
+
+// GetContext 在 ctx 中获取指定对象
+func GetContext(ctx context.Context, auth *Auth, className, objectID string, options types.M, clientSDK map[string]string) (types.M, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	result, err := get(orm.TomatoDBController.WithContext(ctx), auth, className, objectID, options, clientSDK)
+	return result, contextError(ctx, err)
+}
+
+// CreateContext 在 ctx 中创建对象
+func CreateContext(ctx context.Context, auth *Auth, className string, object types.M, clientSDK map[string]string) (types.M, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	result, err := create(orm.TomatoDBController.WithContext(ctx), auth, className, object, clientSDK)
+	return result, contextError(ctx, err)
+}
+
+// CreateObjectsContext 在 ctx 中批量创建对象
+func CreateObjectsContext(ctx context.Context, auth *Auth, className string, objects []types.M, clientSDK map[string]string) ([]types.M, []error) {
+	if err := ctx.Err(); err != nil {
+		errList := make([]error, len(objects))
+		for i := range errList {
+			errList[i] = contextError(ctx, err)
+		}
+		return make([]types.M, len(objects)), errList
+	}
+	results, errList := createObjects(orm.TomatoDBController.WithContext(ctx), auth, className, objects, clientSDK)
+	for i, err := range errList {
+		errList[i] = contextError(ctx, err)
+	}
+	return results, errList
+}
+
+// UpdateContext 在 ctx 中更新对象
+func UpdateContext(ctx context.Context, auth *Auth, className, objectID string, object types.M, clientSDK map[string]string) (types.M, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	result, err := update(orm.TomatoDBController.WithContext(ctx), auth, className, objectID, object, clientSDK)
+	return result, contextError(ctx, err)
+}
+
+// DeleteContext 在 ctx 中删除对象
+func DeleteContext(ctx context.Context, auth *Auth, className, objectID string) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(ctx, err)
+	}
+	err := del(orm.TomatoDBController.WithContext(ctx), auth, className, objectID)
+	return contextError(ctx, err)
+}
+
+// HistoryContext 在 ctx 中获取对象的修改历史
+func HistoryContext(ctx context.Context, auth *Auth, className, objectID string, options types.M, clientSDK map[string]string) (types.M, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	result, err := history(orm.TomatoDBController.WithContext(ctx), auth, className, objectID, options, clientSDK)
+	return result, contextError(ctx, err)
+}
+
+// RevertContext 在 ctx 中把对象恢复为修改历史中的指定版本
+func RevertContext(ctx context.Context, auth *Auth, className, objectID, historyID string, clientSDK map[string]string) (types.M, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	result, err := revert(orm.TomatoDBController.WithContext(ctx), auth, className, objectID, historyID, clientSDK)
+	return result, contextError(ctx, err)
+}
+
+// BeginTransactionContext 在 ctx 中开始一个新的事务， ctx 取消或超时后事务中的操作全部失败
+func BeginTransactionContext(ctx context.Context) (*Transaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	if TransactionsSupported() == false {
+		return nil, ErrTransactionsNotSupported
+	}
+	db, err := orm.TomatoDBController.WithContext(ctx).Begin()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return newTransaction(db), nil
+}
+
+// contextError ctx 已经取消或超时时，把 err 转换为对应的错误，否则原样返回
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return errs.E(errs.Timeout, "Request timed out.")
+	}
+	return errs.E(errs.ClientDisconnected, "Request canceled.")
+}
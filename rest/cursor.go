@@ -0,0 +1,194 @@
+package rest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// 游标分页：
+// 在 cursor 中传入 true 或者游标时开启，未传入时查询的排序与返回结果保持不变
+// 需要设置 limit 且不能设置 skip ，查询结果按照 order 中的字段排序，并自动以 objectId 作为最后一个排序字段，保证顺序唯一
+// 返回结果数量等于 limit 时，在 nextCursor 中返回游标，游标中保存了最后一个对象的排序字段取值
+// 下次查询时在 cursor 中传入该游标，即可从上次结束的位置继续查询，查询条件与 order 需要保持不变
+// 空值视为比任何值都小，排序字段为 Object Array 等无法比较的类型时不返回游标
+
+// queryCursor 解码后的游标
+type queryCursor struct {
+	ClassName string        `json:"className"`
+	Order     []string      `json:"order"`
+	Values    []interface{} `json:"values"`
+}
+
+// cursorOrder 在排序字段后追加 objectId ，已经包含 objectId 时不做处理
+func cursorOrder(sort []string) []string {
+	order := []string{}
+	for _, key := range sort {
+		if key == "" {
+			continue
+		}
+		order = append(order, key)
+		if strings.TrimPrefix(key, "-") == "objectId" {
+			return order
+		}
+	}
+	return append(order, "objectId")
+}
+
+// encodeCursor 使用 object 中排序字段的取值生成游标，存在无法比较的取值时返回空字符串
+func encodeCursor(className string, order []string, object types.M) string {
+	values := []interface{}{}
+	for _, key := range order {
+		value := cursorValue(object, strings.TrimPrefix(key, "-"))
+		if validCursorValue(value) == false {
+			return ""
+		}
+		values = append(values, value)
+	}
+	b, err := json.Marshal(queryCursor{ClassName: className, Order: order, Values: values})
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// validCursorValue 判断取值能否保存在游标中，只允许基本类型以及 Date 、 Pointer 类型
+// 游标由客户端传入，其中的取值会直接放入查询条件，不能包含 $regex $inQuery 等查询操作符
+func validCursorValue(value interface{}) bool {
+	switch value.(type) {
+	case nil, string, bool, float64, int, int64:
+		return true
+	}
+	m := utils.M(value)
+	if m == nil {
+		return false
+	}
+	var keys []string
+	switch m["__type"] {
+	case "Date":
+		keys = []string{"__type", "iso"}
+	case "Pointer":
+		keys = []string{"__type", "className", "objectId"}
+	default:
+		return false
+	}
+	if len(m) != len(keys) {
+		return false
+	}
+	for _, key := range keys {
+		if _, ok := m[key].(string); ok == false {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeCursor 解码游标，并校验游标与当前查询的类名、排序字段是否一致
+func decodeCursor(cursor, className string, order []string) (*queryCursor, error) {
+	invalid := errs.E(errs.InvalidQuery, "Invalid cursor.")
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, invalid
+	}
+	result := &queryCursor{}
+	err = json.Unmarshal(b, result)
+	if err != nil {
+		return nil, invalid
+	}
+	if result.ClassName != className || len(result.Order) != len(order) || len(result.Values) != len(order) {
+		return nil, invalid
+	}
+	for i, key := range order {
+		if result.Order[i] != key || validCursorValue(result.Values[i]) == false {
+			return nil, invalid
+		}
+		if m := utils.M(result.Values[i]); m != nil {
+			result.Values[i] = types.M(m)
+		}
+	}
+	return result, nil
+}
+
+// cursorValue 获取对象中的排序字段取值， createdAt updatedAt 转换为 Date 类型，以便在查询条件中使用
+func cursorValue(object types.M, key string) interface{} {
+	var value interface{} = object
+	for _, k := range strings.Split(key, ".") {
+		m := utils.M(value)
+		if m == nil {
+			return nil
+		}
+		value = m[k]
+	}
+	if s, ok := value.(string); ok && (key == "createdAt" || key == "updatedAt") {
+		return types.M{"__type": "Date", "iso": s}
+	}
+	return value
+}
+
+// cursorWhere 生成查询游标之后对象的查询条件
+// 排序字段为 k1 k2 ... kn 时，条件为 k1 > v1 ，或者 k1 = v1 且 k2 > v2 ，以此类推，降序字段使用 <
+func cursorWhere(order []string, values []interface{}) types.M {
+	clauses := types.S{}
+	prefix := types.M{}
+	for i, key := range order {
+		desc := strings.HasPrefix(key, "-")
+		key = strings.TrimPrefix(key, "-")
+		value := values[i]
+		after := []interface{}{}
+		switch {
+		case value == nil && desc == false:
+			after = append(after, types.M{"$exists": true})
+		case value == nil && desc:
+			// 空值为最小值，降序时之后没有其他取值
+		case desc:
+			after = append(after, types.M{"$lt": value})
+			if key != "objectId" {
+				after = append(after, types.M{"$exists": false})
+			}
+		default:
+			after = append(after, types.M{"$gt": value})
+		}
+		for _, constraint := range after {
+			clause := utils.CopyMapM(prefix)
+			clause[key] = constraint
+			clauses = append(clauses, clause)
+		}
+		if value == nil {
+			prefix[key] = types.M{"$exists": false}
+		} else {
+			prefix[key] = value
+		}
+	}
+	if len(clauses) == 1 {
+		return clauses[0].(types.M)
+	}
+	return types.M{"$or": clauses}
+}
+
+// cursorLimit 当前查询使用游标分页时返回 limit ，否则返回 0
+// 未开启游标分页，设置了 skip ，或者使用聚合、按相关度排序时不使用游标分页
+func (q *Query) cursorLimit() int {
+	if q.useCursor == false {
+		return 0
+	}
+	if q.findOptions["pipeline"] != nil || q.findOptions["distinct"] != nil || q.findOptions["skip"] != nil {
+		return 0
+	}
+	sort, _ := q.findOptions["sort"].([]string)
+	for _, key := range sort {
+		if strings.TrimPrefix(key, "-") == storage.TextScoreKey {
+			return 0
+		}
+	}
+	if l, ok := q.findOptions["limit"].(float64); ok && l > 0 {
+		return int(l)
+	} else if l, ok := q.findOptions["limit"].(int); ok && l > 0 {
+		return l
+	}
+	return 0
+}
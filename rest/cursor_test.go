@@ -0,0 +1,127 @@
+package rest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/lfq7413/tomato/types"
+)
+
+func Test_cursorOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		sort []string
+		want []string
+	}{
+		{name: "1", sort: nil, want: []string{"objectId"}},
+		{name: "2", sort: []string{"-score", "name"}, want: []string{"-score", "name", "objectId"}},
+		{name: "3", sort: []string{"-objectId", "name"}, want: []string{"-objectId"}},
+		{name: "4", sort: []string{""}, want: []string{"objectId"}},
+	}
+	for _, tt := range tests {
+		if got := cursorOrder(tt.sort); reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%q. cursorOrder() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_cursorWhere(t *testing.T) {
+	tests := []struct {
+		name   string
+		order  []string
+		values []interface{}
+		want   types.M
+	}{
+		{
+			name:   "1",
+			order:  []string{"objectId"},
+			values: []interface{}{"01"},
+			want:   types.M{"objectId": types.M{"$gt": "01"}},
+		},
+		{
+			name:   "2",
+			order:  []string{"score", "objectId"},
+			values: []interface{}{10.0, "01"},
+			want: types.M{"$or": types.S{
+				types.M{"score": types.M{"$gt": 10.0}},
+				types.M{"score": 10.0, "objectId": types.M{"$gt": "01"}},
+			}},
+		},
+		{
+			name:   "3",
+			order:  []string{"-score", "objectId"},
+			values: []interface{}{10.0, "01"},
+			want: types.M{"$or": types.S{
+				types.M{"score": types.M{"$lt": 10.0}},
+				types.M{"score": types.M{"$exists": false}},
+				types.M{"score": 10.0, "objectId": types.M{"$gt": "01"}},
+			}},
+		},
+		{
+			name:   "4",
+			order:  []string{"score", "objectId"},
+			values: []interface{}{nil, "01"},
+			want: types.M{"$or": types.S{
+				types.M{"score": types.M{"$exists": true}},
+				types.M{"score": types.M{"$exists": false}, "objectId": types.M{"$gt": "01"}},
+			}},
+		},
+		{
+			name:   "5",
+			order:  []string{"-score", "-objectId"},
+			values: []interface{}{nil, "01"},
+			want:   types.M{"score": types.M{"$exists": false}, "objectId": types.M{"$lt": "01"}},
+		},
+	}
+	for _, tt := range tests {
+		if got := cursorWhere(tt.order, tt.values); reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%q. cursorWhere() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_encodeCursor(t *testing.T) {
+	order := []string{"-createdAt", "score", "objectId"}
+	object := types.M{"objectId": "01", "createdAt": "2017-01-01T00:00:00.000Z", "score": 10}
+	cursor := encodeCursor("post", order, object)
+	if cursor == "" {
+		t.Fatal("encodeCursor() should not be empty")
+	}
+	got, err := decodeCursor(cursor, "post", order)
+	want := []interface{}{
+		types.M{"__type": "Date", "iso": "2017-01-01T00:00:00.000Z"},
+		10.0,
+		"01",
+	}
+	if err != nil || reflect.DeepEqual(got.Values, want) == false {
+		t.Errorf("decodeCursor() = %v, %v, want %v", got, err, want)
+	}
+
+	if _, err := decodeCursor(cursor, "user", order); err == nil {
+		t.Error("decodeCursor() with other className should fail")
+	}
+	if _, err := decodeCursor(cursor, "post", []string{"score", "objectId"}); err == nil {
+		t.Error("decodeCursor() with other order should fail")
+	}
+	if _, err := decodeCursor("abc", "post", order); err == nil {
+		t.Error("decodeCursor() with invalid cursor should fail")
+	}
+	if cursor := encodeCursor("post", []string{"tags", "objectId"}, types.M{"objectId": "01", "tags": types.S{"a"}}); cursor != "" {
+		t.Errorf("encodeCursor() = %v, want empty", cursor)
+	}
+	// 游标中的查询操作符
+	for _, value := range []interface{}{
+		map[string]interface{}{"$regex": ".*"},
+		map[string]interface{}{"$inQuery": map[string]interface{}{"className": "user", "where": map[string]interface{}{}}},
+		map[string]interface{}{"__type": "Date", "iso": "2017-01-01T00:00:00.000Z", "$select": "a"},
+		map[string]interface{}{"__type": "Pointer", "className": "user", "objectId": map[string]interface{}{"$ne": "01"}},
+		[]interface{}{"a"},
+	} {
+		b, _ := json.Marshal(queryCursor{ClassName: "post", Order: []string{"score", "objectId"}, Values: []interface{}{value, "01"}})
+		if _, err := decodeCursor(base64.RawURLEncoding.EncodeToString(b), "post", []string{"score", "objectId"}); err == nil {
+			t.Errorf("decodeCursor() with value %v should fail", value)
+		}
+	}
+}
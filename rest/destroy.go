@@ -15,6 +15,7 @@ type Destroy struct {
 	className    string
 	query        types.M
 	originalData types.M
+	db           *orm.DBController // 为空时使用 orm.TomatoDBController
 }
 
 // NewDestroy 组装 Destroy
@@ -33,6 +34,14 @@ func NewDestroy(
 	return destroy
 }
 
+// database 返回执行数据库操作的 DBController
+func (d *Destroy) database() *orm.DBController {
+	if d.db != nil {
+		return d.db
+	}
+	return orm.TomatoDBController
+}
+
 // Execute 执行删除请求
 func (d *Destroy) Execute() error {
 	err := d.handleSession()
@@ -76,11 +85,15 @@ func (d *Destroy) runBeforeTrigger() error {
 		return nil
 	}
 	if livequery.TLiveQuery != nil {
-		livequery.TLiveQuery.OnAfterDelete(d.className, d.originalData, nil)
+		// 在事务中时，提交之后再通知
+		original := utils.CopyMap(d.originalData)
+		afterCommit(d.database(), func() {
+			livequery.TLiveQuery.OnAfterDelete(d.className, original, nil)
+		})
 	}
 
 	d.originalData["className"] = d.className
-	maybeRunTrigger(cloud.TypeBeforeDelete, d.auth, d.originalData, nil)
+	maybeRunTrigger(d.database().Context(), cloud.TypeBeforeDelete, d.auth, d.originalData, nil)
 
 	return nil
 }
@@ -105,11 +118,23 @@ func (d *Destroy) runDestroy() error {
 		}
 		options["acl"] = acl
 	}
-	return orm.TomatoDBController.Destroy(d.className, d.query, options)
+	err := d.database().Destroy(d.className, d.query, options)
+	if err != nil {
+		return err
+	}
+	if historyEnabled(d.database(), d.className) {
+		objectID := utils.S(d.query["objectId"])
+		err = recordHistory(d.database(), d.auth, d.className, objectID, HistoryDelete, nil, d.originalData, nil)
+		return historyError(d.database(), d.className, objectID, err)
+	}
+	return nil
 }
 
 // runAfterTrigger 执行删后回调
+// 在事务中时，提交之后再执行
 func (d *Destroy) runAfterTrigger() error {
-	maybeRunTrigger(cloud.TypeAfterDelete, d.auth, d.originalData, nil)
+	afterCommit(d.database(), func() {
+		maybeRunTrigger(d.database().Context(), cloud.TypeAfterDelete, d.auth, d.originalData, nil)
+	})
 	return nil
 }
@@ -0,0 +1,93 @@
+package rest
+
+import (
+	"context"
+	"strings"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// 对象的 ETag 由 updatedAt 生成，用于乐观并发控制
+// 更新与删除对象时可以通过 If-Match 指定 ETag ，对象已被修改时返回 PreconditionFailed
+
+type ifMatchKey struct{}
+
+// ETag 返回对象的 ETag ，对象中不存在 updatedAt 时返回空
+func ETag(object types.M) string {
+	updatedAt := utils.S(object["updatedAt"])
+	if updatedAt == "" {
+		return ""
+	}
+	return `"` + updatedAt + `"`
+}
+
+// MatchETag 判断 etag 是否与 If-Match 或 If-None-Match 中的任意一个值匹配， * 匹配任意 ETag ，值可以不带引号
+func MatchETag(header, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, v := range strings.Split(header, ",") {
+		v = trimETag(v)
+		if v == "*" || v == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// trimETag 去掉 ETag 的弱校验前缀 W/ 与引号
+func trimETag(v string) string {
+	return strings.Trim(strings.TrimPrefix(strings.TrimSpace(v), "W/"), `"`)
+}
+
+// WithIfMatch 返回设置了 If-Match 的 ctx ，在 ctx 中更新或删除对象时，仅当对象的 ETag 与其匹配时才执行
+func WithIfMatch(ctx context.Context, ifMatch string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, ifMatchKey{}, strings.TrimSpace(ifMatch))
+}
+
+// ifMatch 返回 db 的 ctx 中设置的 If-Match ，未设置时返回空
+func ifMatch(db *orm.DBController) string {
+	if db == nil {
+		return ""
+	}
+	v, _ := db.Context().Value(ifMatchKey{}).(string)
+	return v
+}
+
+// ifMatchCondition 把 If-Match 转换为 updatedAt 的查询条件，为空或者为 * 时返回 nil
+// SDK 通过 _If-Match 字段传入的值可以不带引号
+func ifMatchCondition(ifMatch string) (interface{}, error) {
+	if ifMatch == "" || ifMatch == "*" {
+		return nil, nil
+	}
+	dates := types.S{}
+	for _, v := range strings.Split(ifMatch, ",") {
+		v = trimETag(v)
+		if _, err := utils.StringtoTime(v); err != nil {
+			return nil, errs.E(errs.PreconditionFailed, "Invalid ETag: "+v)
+		}
+		dates = append(dates, types.M{"__type": "Date", "iso": v})
+	}
+	if len(dates) == 1 {
+		return dates[0], nil
+	}
+	return types.M{"$in": dates}, nil
+}
+
+// preconditionError 带有 If-Match 条件的操作未找到对象时，如果对象仍然存在，说明对象已被修改，返回 PreconditionFailed
+func preconditionError(db *orm.DBController, auth *Auth, className, objectID string, err error) error {
+	if errs.GetErrorCode(err) != errs.ObjectNotFound {
+		return err
+	}
+	response, findErr := find(db, auth, className, types.M{"objectId": objectID}, types.M{}, nil)
+	if findErr != nil || utils.HasResults(response) == false {
+		return err
+	}
+	return errs.E(errs.PreconditionFailed, "Object has been modified.")
+}
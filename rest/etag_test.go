@@ -0,0 +1,94 @@
+package rest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/types"
+)
+
+func Test_ETag(t *testing.T) {
+	tests := []struct {
+		name   string
+		object types.M
+		want   string
+	}{
+		{name: "1", object: types.M{}, want: ""},
+		{name: "2", object: types.M{"updatedAt": "2006-01-02T15:04:05.000Z"}, want: `"2006-01-02T15:04:05.000Z"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ETag(tt.object); got != tt.want {
+				t.Errorf("ETag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_MatchETag(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		want   bool
+	}{
+		{name: "1", header: `"a"`, etag: "", want: false},
+		{name: "2", header: `"a"`, etag: `"a"`, want: true},
+		{name: "3", header: `a`, etag: `"a"`, want: true},
+		{name: "4", header: `W/"a"`, etag: `"a"`, want: true},
+		{name: "5", header: `"b", "a"`, etag: `"a"`, want: true},
+		{name: "6", header: `*`, etag: `"a"`, want: true},
+		{name: "7", header: `"b"`, etag: `"a"`, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchETag(tt.header, tt.etag); got != tt.want {
+				t.Errorf("MatchETag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ifMatchCondition(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    interface{}
+		wantErr error
+	}{
+		{name: "1", ifMatch: "", want: nil},
+		{name: "2", ifMatch: "*", want: nil},
+		{
+			name:    "3",
+			ifMatch: `"2006-01-02T15:04:05.000Z"`,
+			want:    types.M{"__type": "Date", "iso": "2006-01-02T15:04:05.000Z"},
+		},
+		{
+			name:    "4",
+			ifMatch: `"2006-01-02T15:04:05.000Z", 2006-01-03T15:04:05.000Z`,
+			want: types.M{"$in": types.S{
+				types.M{"__type": "Date", "iso": "2006-01-02T15:04:05.000Z"},
+				types.M{"__type": "Date", "iso": "2006-01-03T15:04:05.000Z"},
+			}},
+		},
+		{name: "5", ifMatch: `"abc"`, wantErr: errs.E(errs.PreconditionFailed, "Invalid ETag: abc")},
+		{
+			name:    "6",
+			ifMatch: `W/"2006-01-02T15:04:05.000Z"`,
+			want:    types.M{"__type": "Date", "iso": "2006-01-02T15:04:05.000Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ifMatchCondition(tt.ifMatch)
+			if reflect.DeepEqual(err, tt.wantErr) == false {
+				t.Errorf("ifMatchCondition() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if reflect.DeepEqual(got, tt.want) == false {
+				t.Errorf("ifMatchCondition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
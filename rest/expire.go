@@ -0,0 +1,89 @@
+package rest
+
+import (
+	"context"
+	"time"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/logger"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// expireBatchSize destroy 模式下每次获取的过期对象数量
+const expireBatchSize = 100
+
+// SweepExpiredObjects 删除所有开启过期的类中已过期的对象，返回每个类中删除的数量
+// fast 模式的类直接删除， destroy 模式的类通过 Destroy 逐个删除，会执行删除触发器并发送 LiveQuery 通知
+// destroy 模式下删除失败的对象会记录日志，并在下次执行时重试
+func SweepExpiredObjects(ctx context.Context) (types.M, error) {
+	db := orm.TomatoDBController.WithContext(ctx)
+	results, err := db.PurgeExpiredObjects()
+	if err != nil {
+		return results, contextError(ctx, err)
+	}
+	schemas, err := db.LoadSchema(nil).GetAllClasses(nil)
+	if err != nil {
+		return results, contextError(ctx, err)
+	}
+	for _, schema := range schemas {
+		ttl := orm.TTLOf(schema)
+		if ttl == nil || ttl.Mode != orm.ExpireModeDestroy {
+			continue
+		}
+		className := utils.S(schema["className"])
+		count, err := destroyExpiredObjects(db.IncludeExpired(), className)
+		if count > 0 {
+			results[className] = count
+		}
+		if err != nil {
+			return results, contextError(ctx, err)
+		}
+	}
+	return results, nil
+}
+
+// destroyExpiredObjects 通过 Destroy 删除类中已过期的对象，有对象删除失败时停止处理该类
+func destroyExpiredObjects(db *orm.DBController, className string) (int, error) {
+	count := 0
+	for {
+		ids, err := db.ExpiredObjectIDs(className, expireBatchSize)
+		if err != nil {
+			return count, err
+		}
+		failed := false
+		for _, id := range ids {
+			if err := db.Context().Err(); err != nil {
+				return count, err
+			}
+			err := del(db, Master(), className, id)
+			if err != nil {
+				// 已经被删除的对象不做处理
+				if errs.GetErrorCode(err) != errs.ObjectNotFound {
+					logger.Error("Destroy expired object", className, id, "failed:", err)
+					failed = true
+				}
+				continue
+			}
+			count++
+		}
+		if failed || len(ids) < expireBatchSize {
+			return count, nil
+		}
+	}
+}
+
+// RunExpirySweeper 每隔 interval 执行一次 SweepExpiredObjects ，直到 ctx 取消
+func RunExpirySweeper(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if _, err := SweepExpiredObjects(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("Sweep expired objects failed:", err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package rest
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/logger"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// historyClassName 保存对象修改历史的表
+const historyClassName = "_History"
+
+// HistoryCreate HistoryUpdate HistoryDelete 修改历史中的操作类型
+const (
+	HistoryCreate = "create"
+	HistoryUpdate = "update"
+	HistoryDelete = "delete"
+)
+
+// historyEnabled 类是否开启了修改历史
+func historyEnabled(db *orm.DBController, className string) bool {
+	if db == nil {
+		db = orm.TomatoDBController
+	}
+	schema, err := db.LoadSchema(nil).GetOneSchema(className, false, nil)
+	if err != nil {
+		return false
+	}
+	return orm.HistoryEnabled(schema)
+}
+
+// historyError 处理记录修改历史时出现的错误
+// 处于事务中时返回错误，修改与历史一起回滚；否则修改已经写入，只记录日志，仍然返回修改成功
+func historyError(db *orm.DBController, className, objectID string, err error) error {
+	if err == nil || inTransaction(db) {
+		return err
+	}
+	logger.Error("Record history of", className, objectID, "failed:", err)
+	return nil
+}
+
+// recordHistory 在 _History 中记录一次修改， before 与 after 分别为修改前后的对象，新建时 before 为空，删除时 after 为空
+// 加密字段不记录到修改历史中，避免以明文保存
+func recordHistory(db *orm.DBController, auth *Auth, className, objectID, action string, update, before, after types.M) error {
+	if db == nil {
+		db = orm.TomatoDBController
+	}
+	schema, err := db.LoadSchema(nil).GetOneSchema(className, false, nil)
+	if err != nil {
+		return err
+	}
+	encrypted := orm.EncryptedFieldNames(schema)
+	now := utils.TimetoString(time.Now().UTC())
+	record := types.M{
+		"objectId":    utils.CreateObjectID(),
+		"createdAt":   now,
+		"updatedAt":   now,
+		"targetClass": className,
+		"targetId":    objectID,
+		"action":      action,
+		"master":      auth.IsMaster,
+	}
+	if auth.User != nil {
+		record["user"] = types.M{
+			"__type":    "Pointer",
+			"className": "_User",
+			"objectId":  auth.User["objectId"],
+		}
+	}
+	if auth.InstallationID != "" {
+		record["installationId"] = auth.InstallationID
+	}
+	for key, object := range map[string]types.M{"update": update, "before": before, "after": after} {
+		if object == nil {
+			continue
+		}
+		object = utils.CopyMap(object)
+		delete(object, "className")
+		for _, fieldName := range encrypted {
+			delete(object, fieldName)
+		}
+		record[key] = object
+	}
+	return db.Create(historyClassName, record, nil)
+}
+
+// currentObject 使用 master 权限获取对象当前的数据，用于记录修改后的对象
+func currentObject(db *orm.DBController, className, objectID string) (types.M, error) {
+	results, err := db.Find(className, types.M{"objectId": objectID}, types.M{})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, errs.E(errs.ObjectNotFound, "Object not found.")
+	}
+	return utils.M(results[0]), nil
+}
+
+// History 获取对象的修改历史，按时间倒序排列，需要有该对象的读权限
+// options 中支持 skip 、 limit
+// 返回格式如下：
+//
+//	{
+//		"results":[
+//			{"objectId":"...", "action":"update", "user":{...}, "master":false, "update":{...}, "before":{...}, "after":{...}, "createdAt":"..."},
+//		]
+//	}
+func History(auth *Auth, className, objectID string, options types.M, clientSDK map[string]string) (types.M, error) {
+	return history(nil, auth, className, objectID, options, clientSDK)
+}
+
+func history(db *orm.DBController, auth *Auth, className, objectID string, options types.M, clientSDK map[string]string) (types.M, error) {
+	if auth.IsMaster == false {
+		// 通过查询对象校验读权限，已删除的对象只有 master 可以查看历史
+		response, err := get(db, auth, className, objectID, types.M{}, clientSDK)
+		if err != nil {
+			return nil, err
+		}
+		if utils.HasResults(response) == false {
+			return nil, errs.E(errs.ObjectNotFound, "Object not found.")
+		}
+	}
+	if db == nil {
+		db = orm.TomatoDBController
+	}
+	if historyEnabled(db, className) == false {
+		return nil, errs.E(errs.OperationForbidden, "History is not enabled on class "+className+".")
+	}
+
+	findOptions := types.M{"sort": []string{"-createdAt"}}
+	for _, key := range []string{"skip", "limit"} {
+		if v, ok := options[key]; ok {
+			findOptions[key] = v
+		}
+	}
+	where := types.M{"targetClass": className, "targetId": objectID}
+	results, err := db.Find(historyClassName, where, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range results {
+		record := utils.M(v)
+		delete(record, "targetClass")
+		delete(record, "targetId")
+		delete(record, "updatedAt")
+	}
+	return types.M{"results": results}, nil
+}
+
+// Revert 把对象恢复为修改历史中 historyID 对应的版本，通过 Update 执行，需要有该对象的写权限
+// 恢复到删除记录时使用删除前的对象，已删除的对象无法恢复
+func Revert(auth *Auth, className, objectID, historyID string, clientSDK map[string]string) (types.M, error) {
+	return revert(nil, auth, className, objectID, historyID, clientSDK)
+}
+
+func revert(db *orm.DBController, auth *Auth, className, objectID, historyID string, clientSDK map[string]string) (types.M, error) {
+	database := db
+	if database == nil {
+		database = orm.TomatoDBController
+	}
+	where := types.M{"objectId": historyID, "targetClass": className, "targetId": objectID}
+	records, err := database.Find(historyClassName, where, types.M{})
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errs.E(errs.ObjectNotFound, "History record not found.")
+	}
+	record := utils.M(records[0])
+	version := utils.M(record["after"])
+	if version == nil {
+		version = utils.M(record["before"])
+	}
+
+	response, err := get(db, auth, className, objectID, types.M{}, clientSDK)
+	if err != nil {
+		return nil, err
+	}
+	if utils.HasResults(response) == false {
+		return nil, errs.E(errs.ObjectNotFound, "Object not found.")
+	}
+	current := utils.M(utils.A(response["results"])[0])
+
+	return update(db, auth, className, objectID, revertData(current, version), clientSDK)
+}
+
+// revertData 生成把 current 修改为 version 的更新数据
+func revertData(current, version types.M) types.M {
+	skip := func(key string, value interface{}) bool {
+		switch key {
+		case "objectId", "createdAt", "updatedAt", "className":
+			return true
+		}
+		return utils.S(utils.M(value)["__type"]) == "Relation"
+	}
+	data := types.M{}
+	for key, value := range version {
+		if skip(key, value) || reflect.DeepEqual(current[key], value) {
+			continue
+		}
+		data[key] = value
+	}
+	for key, value := range current {
+		if _, ok := version[key]; ok || skip(key, value) {
+			continue
+		}
+		if key == "ACL" {
+			// 没有 ACL 的对象为公共读写
+			data[key] = types.M{"*": types.M{"read": true, "write": true}}
+			continue
+		}
+		data[key] = types.M{"__op": "Delete"}
+	}
+	return data
+}
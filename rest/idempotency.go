@@ -0,0 +1,141 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/lfq7413/tomato/config"
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// 客户端通过 X-Parse-Request-Id 为请求指定唯一的 id ，重试时使用相同的 id
+// 请求执行成功后，响应与请求 id 一起保存在 _Idempotency 表中，相同 id 的请求直接返回保存的响应，不再重复执行
+// 记录在 IdempotencyTTL 秒后过期，由过期对象的清理任务删除
+
+// idempotencyClassName 保存请求 id 与响应的表
+const idempotencyClassName = "_Idempotency"
+
+// IdempotentResponse 请求 id 对应的已保存的响应
+type IdempotentResponse struct {
+	Status   int
+	Response interface{}
+	Location string
+}
+
+// IdempotencyKey 返回请求 id 在 _Idempotency 中保存时使用的 key ，相同的请求 id 只对同一个用户、设备或 master 的请求生效
+// 无法确定请求方时返回空，不保证幂等，避免其他请求方通过相同的请求 id 获取保存的响应
+func IdempotencyKey(auth *Auth, reqID string) string {
+	if reqID == "" || auth == nil {
+		return ""
+	}
+	switch {
+	case auth.User != nil && utils.S(auth.User["objectId"]) != "":
+		return "user:" + utils.S(auth.User["objectId"]) + ":" + reqID
+	case auth.InstallationID != "":
+		return "installation:" + auth.InstallationID + ":" + reqID
+	case auth.IsMaster:
+		return "master:" + reqID
+	}
+	return ""
+}
+
+// ReserveRequestID 记录请求 id ，返回 nil 时表示需要执行请求， reqID 为 IdempotencyKey 返回的 key
+// 请求 id 已经存在时返回保存的响应，相同 id 的请求仍在执行中时返回 DuplicateRequest
+func ReserveRequestID(ctx context.Context, reqID string) (*IdempotentResponse, error) {
+	db := orm.TomatoDBController.WithContext(ctx)
+	err := db.LoadSchema(nil).EnsureIdempotencyClass()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	for i := 0; i < 2; i++ {
+		response, err := storedResponse(db, reqID)
+		if err != nil || response != nil {
+			return response, contextError(ctx, err)
+		}
+		now := time.Now().UTC()
+		record := types.M{
+			"objectId":  utils.CreateObjectID(),
+			"createdAt": utils.TimetoString(now),
+			"updatedAt": utils.TimetoString(now),
+			"reqId":     reqID,
+			"expire":    types.M{"__type": "Date", "iso": utils.TimetoString(now.Add(time.Duration(config.TConfig.IdempotencyTTL) * time.Second))},
+			"ACL":       types.M{},
+		}
+		err = db.Create(idempotencyClassName, record, types.M{})
+		if err == nil {
+			return nil, nil
+		}
+		if errs.GetErrorCode(err) != errs.DuplicateValue {
+			return nil, contextError(ctx, err)
+		}
+		// 请求 id 已经存在，但记录已过期还未被清理时，删除后重试
+		query := types.M{
+			"reqId":  reqID,
+			"expire": types.M{"$lte": types.M{"__type": "Date", "iso": utils.TimetoString(now)}},
+		}
+		err = db.IncludeExpired().Destroy(idempotencyClassName, query, types.M{})
+		if err != nil && errs.GetErrorCode(err) != errs.ObjectNotFound {
+			return nil, contextError(ctx, err)
+		}
+	}
+	return nil, errs.E(errs.DuplicateRequest, "Duplicate request")
+}
+
+// storedResponse 返回请求 id 对应的已保存的响应，不存在时返回 nil ，还没有响应时返回 DuplicateRequest
+func storedResponse(db *orm.DBController, reqID string) (*IdempotentResponse, error) {
+	results, err := db.Find(idempotencyClassName, types.M{"reqId": reqID}, types.M{})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+	record := utils.M(results[0])
+	if utils.S(record["response"]) == "" {
+		return nil, errs.E(errs.DuplicateRequest, "Duplicate request")
+	}
+	response := &IdempotentResponse{Location: utils.S(record["location"])}
+	switch status := record["status"].(type) {
+	case float64:
+		response.Status = int(status)
+	case int:
+		response.Status = status
+	case int64:
+		response.Status = int(status)
+	}
+	err = json.Unmarshal([]byte(utils.S(record["response"])), &response.Response)
+	if err != nil {
+		return nil, errs.E(errs.InternalServerError, "Invalid stored response")
+	}
+	return response, nil
+}
+
+// SaveRequestResponse 保存请求 id 对应的响应
+func SaveRequestResponse(ctx context.Context, reqID string, status int, response interface{}, location string) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	update := types.M{
+		"status":   status,
+		"response": string(data),
+	}
+	if location != "" {
+		update["location"] = location
+	}
+	_, err = orm.TomatoDBController.WithContext(ctx).Update(idempotencyClassName, types.M{"reqId": reqID}, update, types.M{}, false)
+	return contextError(ctx, err)
+}
+
+// ReleaseRequestID 删除请求 id ，请求执行失败后可以使用相同的 id 重试
+func ReleaseRequestID(ctx context.Context, reqID string) error {
+	err := orm.TomatoDBController.WithContext(ctx).Destroy(idempotencyClassName, types.M{"reqId": reqID}, types.M{})
+	if err != nil && errs.GetErrorCode(err) != errs.ObjectNotFound {
+		return contextError(ctx, err)
+	}
+	return nil
+}
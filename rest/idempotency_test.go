@@ -0,0 +1,92 @@
+package rest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/lfq7413/tomato/config"
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/types"
+)
+
+func Test_ReserveRequestID(t *testing.T) {
+	var stored, expect *IdempotentResponse
+	var err, expectErr error
+	ctx := context.Background()
+	/********************************************************/
+	initEnv()
+	config.TConfig.IdempotencyTTL = 300
+	stored, err = ReserveRequestID(ctx, "abc")
+	if err != nil || stored != nil {
+		t.Error("expect:", nil, "result:", stored, err)
+	}
+	stored, err = ReserveRequestID(ctx, "abc")
+	expectErr = errs.E(errs.DuplicateRequest, "Duplicate request")
+	if reflect.DeepEqual(expectErr, err) == false || stored != nil {
+		t.Error("expect:", expectErr, "result:", stored, err)
+	}
+	if errs.GetErrorCode(err) != 163 {
+		t.Error("expect:", 163, "result:", errs.GetErrorCode(err))
+	}
+	err = SaveRequestResponse(ctx, "abc", 201, types.M{"objectId": "1001"}, "http://127.0.0.1/v1/classes/post/1001")
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	stored, err = ReserveRequestID(ctx, "abc")
+	expect = &IdempotentResponse{
+		Status:   201,
+		Response: map[string]interface{}{"objectId": "1001"},
+		Location: "http://127.0.0.1/v1/classes/post/1001",
+	}
+	if err != nil || reflect.DeepEqual(expect, stored) == false {
+		t.Error("expect:", expect, "result:", stored, err)
+	}
+	orm.TomatoDBController.DeleteEverything()
+	/********************************************************/
+	initEnv()
+	config.TConfig.IdempotencyTTL = 300
+	ReserveRequestID(ctx, "abc")
+	err = ReleaseRequestID(ctx, "abc")
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	stored, err = ReserveRequestID(ctx, "abc")
+	if err != nil || stored != nil {
+		t.Error("expect:", nil, "result:", stored, err)
+	}
+	orm.TomatoDBController.DeleteEverything()
+	/********************************************************/
+	initEnv()
+	config.TConfig.IdempotencyTTL = -1
+	ReserveRequestID(ctx, "abc")
+	SaveRequestResponse(ctx, "abc", 200, types.M{"result": "ok"}, "")
+	config.TConfig.IdempotencyTTL = 300
+	stored, err = ReserveRequestID(ctx, "abc")
+	if err != nil || stored != nil {
+		t.Error("expect:", nil, "result:", stored, err)
+	}
+	orm.TomatoDBController.DeleteEverything()
+}
+
+func Test_IdempotencyKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   *Auth
+		reqID  string
+		expect string
+	}{
+		{name: "empty", auth: Master(), reqID: "", expect: ""},
+		{name: "master", auth: Master(), reqID: "abc", expect: "master:abc"},
+		{name: "user", auth: &Auth{User: types.M{"objectId": "u1"}, InstallationID: "i1"}, reqID: "abc", expect: "user:u1:abc"},
+		{name: "other user", auth: &Auth{User: types.M{"objectId": "u2"}}, reqID: "abc", expect: "user:u2:abc"},
+		{name: "installation", auth: &Auth{InstallationID: "i1"}, reqID: "abc", expect: "installation:i1:abc"},
+		{name: "anonymous", auth: Nobody(), reqID: "abc", expect: ""},
+	}
+	for _, tt := range tests {
+		if result := IdempotencyKey(tt.auth, tt.reqID); result != tt.expect {
+			t.Errorf("%q. IdempotencyKey() = %v, want %v", tt.name, result, tt.expect)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/lfq7413/tomato/errs"
 	"github.com/lfq7413/tomato/files"
 	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/storage"
 	"github.com/lfq7413/tomato/types"
 	"github.com/lfq7413/tomato/utils"
 )
@@ -27,6 +29,11 @@ type Query struct {
 	redirectKey       string
 	redirectClassName string
 	clientSDK         map[string]string
+	cursor            string
+	useCursor         bool              // 为 true 时使用游标分页，需要在 cursor 中传入游标或者 true
+	db                *orm.DBController // 为空时使用 orm.TomatoDBController
+	maxTimeMS         int               // 单次查询的最大执行时间，单位为毫秒，为 0 时不限制
+	subQueries        *int              // 已展开的子查询数量，与子查询共用
 }
 
 var alwaysSelectedKeys = []string{"objectId", "createdAt", "updatedAt"}
@@ -58,6 +65,7 @@ func NewQuery(
 		redirectKey:       "",
 		redirectClassName: "",
 		clientSDK:         clientSDK,
+		maxTimeMS:         config.TConfig.MaxQueryTime,
 	}
 
 	if auth.IsMaster == false {
@@ -167,16 +175,127 @@ func NewQuery(
 					query.include = append(query.include, strings.Split(set, "."))
 				} // query.include = [["name"],["name","friend"],["user"],["user","seeeion"]]
 			}
+		case "pipeline":
+			query.findOptions["pipeline"] = v
+		case "distinct":
+			query.findOptions["distinct"] = v
 		case "redirectClassNameForKey":
 			if s, ok := v.(string); ok {
 				query.redirectKey = s
 				query.redirectClassName = ""
 			}
+		case "cursor":
+			// 传入 true 时从第一页开始使用游标分页
+			if s, ok := v.(string); ok && s != "" {
+				query.cursor = s
+				query.useCursor = true
+			} else if b, ok := v.(bool); ok && b {
+				query.useCursor = true
+			}
+		case "explain":
+			if b, ok := v.(bool); ok && b {
+				if auth.IsMaster == false {
+					return nil, errs.E(errs.OperationForbidden, "unauthorized: master key is required")
+				}
+				query.findOptions["explain"] = true
+			}
+		case "maxTimeMS":
+			// Master 权限可以覆盖 MaxQueryTime ，为 0 时不限制
+			if auth.IsMaster == false {
+				return nil, errs.E(errs.OperationForbidden, "unauthorized: master key is required")
+			}
+			maxTimeMS, ok := intOption(v)
+			if ok == false || maxTimeMS < 0 {
+				return nil, errs.E(errs.InvalidQuery, "maxTimeMS should be a number greater than or equal to 0.")
+			}
+			query.maxTimeMS = maxTimeMS
 		default:
 			return nil, errs.E(errs.InvalidJSON, "bad option: "+k)
 		}
 	}
 
+	if auth.IsMaster == false {
+		if err := query.checkLimits(); err != nil {
+			return nil, err
+		}
+	}
+
+	if query.findOptions["explain"] != nil && (query.findOptions["pipeline"] != nil || query.findOptions["distinct"] != nil) {
+		return nil, errs.E(errs.InvalidQuery, "explain cannot be used with pipeline or distinct.")
+	}
+
+	if query.useCursor {
+		if query.cursorLimit() == 0 {
+			return nil, errs.E(errs.InvalidQuery, "cursor should be used with limit, and cannot be used with skip.")
+		}
+		sort, _ := query.findOptions["sort"].([]string)
+		if query.cursor != "" {
+			if _, err := decodeCursor(query.cursor, className, cursorOrder(sort)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return query, nil
+}
+
+// checkLimits 检查 limit 与 include 层数是否超过服务器设置的最大值
+func (q *Query) checkLimits() error {
+	if maxLimit := config.TConfig.MaxLimit; maxLimit > 0 {
+		if limit, ok := intOption(q.findOptions["limit"]); ok && limit > maxLimit {
+			return errs.E(errs.QueryLimitExceeded, fmt.Sprintf("limit exceeds the maximum of %d.", maxLimit))
+		}
+	}
+	if maxDepth := config.TConfig.MaxIncludeDepth; maxDepth > 0 {
+		for _, path := range q.include {
+			if len(path) > maxDepth {
+				return errs.E(errs.QueryLimitExceeded, fmt.Sprintf("include depth exceeds the maximum of %d: %s", maxDepth, strings.Join(path, ".")))
+			}
+		}
+	}
+	return nil
+}
+
+// intOption 转换数字类型的查询选项
+func intOption(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int(n), true
+	case int:
+		return n, true
+	}
+	return 0, false
+}
+
+// database 返回执行数据库操作的 DBController ，设置了 maxTimeMS 时，数据库中的单次查询限制执行时间
+func (q *Query) database() *orm.DBController {
+	db := q.db
+	if db == nil {
+		db = orm.TomatoDBController
+	}
+	if q.maxTimeMS > 0 {
+		return db.WithContext(storage.WithQueryTimeout(db.Context(), q.maxTimeMS))
+	}
+	return db
+}
+
+// newSubQuery 创建展开 $select $dontSelect $inQuery $notInQuery 时使用的子查询，
+// 子查询与当前查询共用计数，非 Master 权限时数量超过 MaxSubQueries 返回错误
+func (q *Query) newSubQuery(className string, where, options types.M) (*Query, error) {
+	if q.subQueries == nil {
+		q.subQueries = new(int)
+	}
+	*q.subQueries++
+	if maxSubQueries := config.TConfig.MaxSubQueries; q.auth.IsMaster == false && maxSubQueries > 0 && *q.subQueries > maxSubQueries {
+		return nil, errs.E(errs.QueryLimitExceeded, fmt.Sprintf("number of sub-queries exceeds the maximum of %d.", maxSubQueries))
+	}
+	query, err := NewQuery(q.auth, className, where, options, q.clientSDK)
+	if err != nil {
+		return nil, err
+	}
+	query.db = q.db
+	query.subQueries = q.subQueries
+	query.maxTimeMS = q.maxTimeMS
 	return query, nil
 }
 
@@ -191,6 +310,10 @@ func (q *Query) Execute(executeOptions ...types.M) (types.M, error) {
 	if err != nil {
 		return nil, err
 	}
+	// 获取执行计划时不返回查询结果
+	if q.findOptions["explain"] != nil {
+		return q.response, nil
+	}
 	err = q.runCount()
 	if err != nil {
 		return nil, err
@@ -258,7 +381,7 @@ func (q *Query) redirectClassNameForKey() error {
 		return nil
 	}
 
-	newClassName := orm.TomatoDBController.RedirectClassNameForKey(q.className, q.redirectKey)
+	newClassName := q.database().RedirectClassNameForKey(q.className, q.redirectKey)
 	q.className = newClassName
 	q.redirectClassName = newClassName
 
@@ -281,7 +404,7 @@ func (q *Query) validateClientClassCreation() error {
 		}
 	}
 	// 允许操作已存在的表
-	schema := orm.TomatoDBController.LoadSchema(nil)
+	schema := q.database().LoadSchema(nil)
 	hasClass := schema.HasClass(q.className)
 	if hasClass {
 		return nil
@@ -344,7 +467,7 @@ func (q *Query) replaceSelect() error {
 	delete(queryValue, "className")
 	additionalOptions := queryValue
 
-	query, err := NewQuery(q.auth, className, where, additionalOptions, q.clientSDK)
+	query, err := q.newSubQuery(className, where, additionalOptions)
 	if err != nil {
 		return err
 	}
@@ -401,7 +524,7 @@ func (q *Query) replaceDontSelect() error {
 	delete(queryValue, "className")
 	additionalOptions := queryValue
 
-	query, err := NewQuery(q.auth, className, where, additionalOptions, q.clientSDK)
+	query, err := q.newSubQuery(className, where, additionalOptions)
 	if err != nil {
 		return err
 	}
@@ -474,7 +597,7 @@ func (q *Query) replaceInQuery() error {
 	delete(inQueryValue, "className")
 	additionalOptions := inQueryValue
 
-	query, err := NewQuery(q.auth, className, where, additionalOptions, q.clientSDK)
+	query, err := q.newSubQuery(className, where, additionalOptions)
 	if err != nil {
 		return err
 	}
@@ -522,7 +645,7 @@ func (q *Query) replaceNotInQuery() error {
 	delete(notInQueryValue, "className")
 	additionalOptions := notInQueryValue
 
-	query, err := NewQuery(q.auth, className, where, additionalOptions, q.clientSDK)
+	query, err := q.newSubQuery(className, where, additionalOptions)
 	if err != nil {
 		return err
 	}
@@ -591,10 +714,72 @@ func (q *Query) runFind(executeOptions ...types.M) error {
 	if v, ok := options["op"].(string); ok && v != "" {
 		findOptions["op"] = v
 	}
-	response, err := orm.TomatoDBController.Find(q.className, q.Where, findOptions)
+
+	// 使用游标分页时，以 objectId 作为最后一个排序字段，并从游标的位置开始查询
+	where := q.Where
+	limit := q.cursorLimit()
+	var order, cursorKeys []string
+	if limit > 0 {
+		sort, _ := findOptions["sort"].([]string)
+		order = cursorOrder(sort)
+		findOptions["sort"] = order
+		findOptions[storage.SortNullsFirst] = true
+		if q.cursor != "" {
+			cursor, err := decodeCursor(q.cursor, q.className, order)
+			if err != nil {
+				return err
+			}
+			if len(where) == 0 {
+				where = cursorWhere(order, cursor.Values)
+			} else {
+				where = types.M{"$and": types.S{where, cursorWhere(order, cursor.Values)}}
+			}
+		}
+		// 生成游标需要排序字段的取值，返回结果前再删除
+		if keys, ok := findOptions["keys"].([]string); ok {
+			selected := map[string]bool{}
+			for _, k := range keys {
+				selected[k] = true
+			}
+			for _, key := range order {
+				key = strings.Split(strings.TrimPrefix(key, "-"), ".")[0]
+				if selected[key] == false {
+					selected[key] = true
+					keys = append(keys, key)
+					cursorKeys = append(cursorKeys, key)
+				}
+			}
+			findOptions["keys"] = keys
+		}
+	}
+
+	response, err := q.database().Find(q.className, where, findOptions)
 	if err != nil {
 		return err
 	}
+	// where 为展开 $inQuery $select 等条件之后的查询条件
+	if q.findOptions["explain"] != nil {
+		explain := types.M{"where": where}
+		if len(response) > 0 {
+			for k, v := range utils.M(response[0]) {
+				explain[k] = v
+			}
+		}
+		q.response["explain"] = explain
+		return nil
+	}
+	if limit > 0 && len(response) == limit {
+		if cursor := encodeCursor(q.className, order, utils.M(response[len(response)-1])); cursor != "" {
+			q.response["nextCursor"] = cursor
+		}
+	}
+	for _, v := range response {
+		if r := utils.M(v); r != nil {
+			for _, key := range cursorKeys {
+				delete(r, key)
+			}
+		}
+	}
 	// 从 _User 表中删除敏感字段
 	if q.className == "_User" {
 		for _, v := range response {
@@ -629,7 +814,7 @@ func (q *Query) runCount() error {
 	delete(q.findOptions, "skip")
 	delete(q.findOptions, "limit")
 	// 当需要取 count 时，数据库返回结果的第一个即为 count
-	result, err := orm.TomatoDBController.Find(q.className, q.Where, q.findOptions)
+	result, err := q.database().Find(q.className, q.Where, q.findOptions)
 	if err != nil {
 		return err
 	}
@@ -670,7 +855,7 @@ func (q *Query) runAfterFindTrigger() error {
 	if hasAfterFindHook == false {
 		return nil
 	}
-	results, err := maybeRunAfterFindTrigger(cloud.TypeAfterFind, q.className, results, q.auth)
+	results, err := maybeRunAfterFindTrigger(q.database().Context(), cloud.TypeAfterFind, q.className, results, q.auth)
 	if err != nil {
 		return err
 	}
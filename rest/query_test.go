@@ -1155,6 +1155,25 @@ func Test_replaceInQuery(t *testing.T) {
 		t.Error("expect:", expectErr, "result:", err)
 	}
 	/**********************************************************/
+	config.TConfig.MaxSubQueries = 1
+	where = types.M{
+		"post": types.M{
+			"$inQuery": types.M{
+				"where":     types.M{},
+				"className": "Post",
+			},
+		},
+	}
+	q, _ = NewQuery(nil, "user", where, nil, nil)
+	q.subQueries = new(int)
+	*q.subQueries = 1
+	err = q.replaceInQuery()
+	expectErr = errs.E(errs.QueryLimitExceeded, "number of sub-queries exceeds the maximum of 1.")
+	if err == nil || reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	config.TConfig.MaxSubQueries = 0
+	/**********************************************************/
 	initEnv()
 	where = types.M{
 		"post": types.M{
@@ -2850,6 +2869,99 @@ func Test_NewQuery(t *testing.T) {
 	if err != nil || reflect.DeepEqual(expect, result) == false {
 		t.Error("expect:", expect, "result:", result, err)
 	}
+	/**********************************************************/
+	auth = Nobody()
+	className = "user"
+	where = nil
+	options = types.M{"maxTimeMS": 1000}
+	clientSDK = nil
+	result, err = NewQuery(auth, className, where, options, clientSDK)
+	expectErr = errs.E(errs.OperationForbidden, "unauthorized: master key is required")
+	if err == nil || reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", result, err)
+	}
+	/**********************************************************/
+	auth = Master()
+	className = "user"
+	where = nil
+	options = types.M{"maxTimeMS": -1}
+	clientSDK = nil
+	result, err = NewQuery(auth, className, where, options, clientSDK)
+	expectErr = errs.E(errs.InvalidQuery, "maxTimeMS should be a number greater than or equal to 0.")
+	if err == nil || reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", result, err)
+	}
+	/**********************************************************/
+	config.TConfig.MaxQueryTime = 500
+	auth = Nobody()
+	className = "user"
+	where = nil
+	options = nil
+	clientSDK = nil
+	result, err = NewQuery(auth, className, where, options, clientSDK)
+	if err != nil || result.maxTimeMS != 500 {
+		t.Error("expect:", 500, "result:", result, err)
+	}
+	auth = Master()
+	options = types.M{"maxTimeMS": float64(0)}
+	result, err = NewQuery(auth, className, where, options, clientSDK)
+	if err != nil || result.maxTimeMS != 0 {
+		t.Error("expect:", 0, "result:", result, err)
+	}
+	config.TConfig.MaxQueryTime = 0
+	/**********************************************************/
+	config.TConfig.MaxLimit = 100
+	auth = Nobody()
+	className = "user"
+	where = nil
+	options = types.M{"limit": float64(101)}
+	clientSDK = nil
+	result, err = NewQuery(auth, className, where, options, clientSDK)
+	expectErr = errs.E(errs.QueryLimitExceeded, "limit exceeds the maximum of 100.")
+	if err == nil || reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", result, err)
+	}
+	options = types.M{"limit": 100}
+	result, err = NewQuery(auth, className, where, options, clientSDK)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	auth = Master()
+	options = types.M{"limit": 1000}
+	result, err = NewQuery(auth, className, where, options, clientSDK)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	config.TConfig.MaxLimit = 0
+	/**********************************************************/
+	config.TConfig.MaxIncludeDepth = 2
+	auth = Nobody()
+	className = "user"
+	where = nil
+	options = types.M{"include": "post.author"}
+	clientSDK = nil
+	result, err = NewQuery(auth, className, where, options, clientSDK)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	options = types.M{"keys": "post.author.name"}
+	result, err = NewQuery(auth, className, where, options, clientSDK)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	options = types.M{"include": "post.author.friend"}
+	result, err = NewQuery(auth, className, where, options, clientSDK)
+	expectErr = errs.E(errs.QueryLimitExceeded, "include depth exceeds the maximum of 2: post.author.friend")
+	if err == nil || reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", result, err)
+	}
+	auth = Master()
+	options = types.M{"include": "post.author.friend"}
+	result, err = NewQuery(auth, className, where, options, clientSDK)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	config.TConfig.MaxIncludeDepth = 0
 }
 
 func Test_includePath(t *testing.T) {
@@ -1,9 +1,11 @@
 package rest
 
 import (
+	"context"
 	"github.com/lfq7413/tomato/cloud"
 	"github.com/lfq7413/tomato/errs"
 	"github.com/lfq7413/tomato/livequery"
+	"github.com/lfq7413/tomato/orm"
 	"github.com/lfq7413/tomato/types"
 	"github.com/lfq7413/tomato/utils"
 )
@@ -14,15 +16,27 @@ import (
 // 	"results":[
 // 		{...},
 // 	],
-// 	"count":10
+// 	"count":10,
+// 	"nextCursor":"..."
 // }
+// 结果数量等于 limit 时返回 nextCursor ，在 options 的 cursor 中传入即可查询下一页
+// options 中设置 explain 为 true 时（需要 Master 权限），不返回查询结果，仅在 explain 中返回转换后的查询条件与执行计划
 func Find(auth *Auth, className string, where, options types.M, clientSDK map[string]string) (types.M, error) {
+	return find(nil, auth, className, where, options, clientSDK)
+}
+
+// find 在指定的 DBController 上查找数据， db 为空时使用 orm.TomatoDBController
+func find(db *orm.DBController, auth *Auth, className string, where, options types.M, clientSDK map[string]string) (types.M, error) {
 
 	err := enforceRoleSecurity("find", className, auth)
 	if err != nil {
 		return nil, err
 	}
-	w, o, err := maybeRunQueryTrigger(cloud.TypeBeforeFind, className, where, options, auth)
+	ctx := context.Background()
+	if db != nil {
+		ctx = db.Context()
+	}
+	w, o, err := maybeRunQueryTrigger(ctx, cloud.TypeBeforeFind, className, where, options, auth)
 	if err != nil {
 		return nil, err
 	}
@@ -36,12 +50,17 @@ func Find(auth *Auth, className string, where, options types.M, clientSDK map[st
 	if err != nil {
 		return nil, err
 	}
+	query.db = db
 
 	return query.Execute()
 }
 
 // Get ...
 func Get(auth *Auth, className, objectID string, options types.M, clientSDK map[string]string) (types.M, error) {
+	return get(nil, auth, className, objectID, options, clientSDK)
+}
+
+func get(db *orm.DBController, auth *Auth, className, objectID string, options types.M, clientSDK map[string]string) (types.M, error) {
 
 	err := enforceRoleSecurity("get", className, auth)
 	if err != nil {
@@ -51,12 +70,17 @@ func Get(auth *Auth, className, objectID string, options types.M, clientSDK map[
 	if err != nil {
 		return nil, err
 	}
+	query.db = db
 
 	return query.Execute()
 }
 
 // Delete 删除指定对象
 func Delete(auth *Auth, className, objectID string) error {
+	return del(nil, auth, className, objectID)
+}
+
+func del(db *orm.DBController, auth *Auth, className, objectID string) error {
 
 	if className == "_User" && auth.CouldUpdateUserID(objectID) == false {
 		return errs.E(errs.SessionMissing, "insufficient auth to delete user")
@@ -67,12 +91,21 @@ func Delete(auth *Auth, className, objectID string) error {
 		return err
 	}
 
+	query := types.M{"objectId": objectID}
+	precondition, err := ifMatchCondition(ifMatch(db))
+	if err != nil {
+		return err
+	}
+	if precondition != nil {
+		query["updatedAt"] = precondition
+	}
+
 	var inflatedObject types.M
 	// 如果存在删前回调、或者删后回调、或者要删除的属于 _Session 类，则需要获取到要删除的对象数据
 	hasTriggers := checkTriggers(className, []string{cloud.TypeBeforeDelete, cloud.TypeAfterDelete})
 	hasLiveQuery := checkLiveQuery(className)
-	if hasTriggers || hasLiveQuery || className == "_Session" {
-		response, err := Find(auth, className, types.M{"objectId": objectID}, types.M{}, nil)
+	if hasTriggers || hasLiveQuery || className == "_Session" || historyEnabled(db, className) {
+		response, err := find(db, auth, className, types.M{"objectId": objectID}, types.M{}, nil)
 		if err != nil || utils.HasResults(response) == false {
 			return errs.E(errs.ObjectNotFound, "Object not found for delete.")
 		}
@@ -83,11 +116,19 @@ func Delete(auth *Auth, className, objectID string) error {
 			return errs.E(errs.ObjectNotFound, "Object not found for delete.")
 		}
 		inflatedObject["className"] = className
+		if precondition != nil && MatchETag(ifMatch(db), ETag(inflatedObject)) == false {
+			return errs.E(errs.PreconditionFailed, "Object has been modified.")
+		}
 	}
 
-	destroy := NewDestroy(auth, className, types.M{"objectId": objectID}, inflatedObject)
+	destroy := NewDestroy(auth, className, query, inflatedObject)
+	destroy.db = db
 
-	return destroy.Execute()
+	err = destroy.Execute()
+	if err != nil && precondition != nil {
+		return preconditionError(db, auth, className, objectID, err)
+	}
+	return err
 }
 
 // Create 创建对象
@@ -98,6 +139,10 @@ func Delete(auth *Auth, className, objectID string) error {
 // 	"location":"http://..."
 // }
 func Create(auth *Auth, className string, object types.M, clientSDK map[string]string) (types.M, error) {
+	return create(nil, auth, className, object, clientSDK)
+}
+
+func create(db *orm.DBController, auth *Auth, className string, object types.M, clientSDK map[string]string) (types.M, error) {
 
 	err := enforceRoleSecurity("create", className, auth)
 	if err != nil {
@@ -107,27 +152,138 @@ func Create(auth *Auth, className string, object types.M, clientSDK map[string]s
 	if err != nil {
 		return nil, err
 	}
+	write.db = db
 
 	return write.Execute()
 }
 
+// bulkCreateExcludedClasses 创建时包含额外处理的类，批量创建时仍然逐个创建
+var bulkCreateExcludedClasses = map[string]bool{
+	"_User":         true,
+	"_Installation": true,
+	"_Session":      true,
+}
+
+// CreateObjects 批量创建对象，返回的结果与错误均与 objects 一一对应，格式与 Create 相同
+// 每个对象依次执行 beforeSave 回调与校验，通过的对象一次写入数据库，再依次执行 afterSave 回调与 LiveQuery 通知
+// 批量写入失败时没有创建任何对象，改为逐个写入，以便确定出错的对象；写入之后出错的对象直接返回错误
+// 无法确定哪些对象已经写入时，每个对象都返回错误，不再逐个写入
+func CreateObjects(auth *Auth, className string, objects []types.M, clientSDK map[string]string) ([]types.M, []error) {
+	return createObjects(nil, auth, className, objects, clientSDK)
+}
+
+func createObjects(db *orm.DBController, auth *Auth, className string, objects []types.M, clientSDK map[string]string) ([]types.M, []error) {
+	results := make([]types.M, len(objects))
+	errList := make([]error, len(objects))
+
+	err := enforceRoleSecurity("create", className, auth)
+	if err != nil {
+		for i := range errList {
+			errList[i] = err
+		}
+		return results, errList
+	}
+
+	writes := map[int]*Write{}
+	pending := []int{}
+	for i, object := range objects {
+		write, err := NewWrite(auth, className, nil, object, nil, clientSDK)
+		if err != nil {
+			errList[i] = err
+			continue
+		}
+		write.db = db
+		if bulkCreateExcludedClasses[className] {
+			results[i], errList[i] = write.Execute()
+			continue
+		}
+		err = write.beforeDatabaseOperation()
+		if err == nil && write.response == nil {
+			err = write.validateDatabaseOperation()
+		}
+		if err != nil {
+			errList[i] = err
+			continue
+		}
+		writes[i] = write
+		if write.response == nil {
+			write.prepareCreate()
+			pending = append(pending, i)
+		}
+	}
+
+	if len(pending) > 0 {
+		data := []types.M{}
+		for _, i := range pending {
+			data = append(data, writes[i].data)
+		}
+		first := writes[pending[0]]
+		// 批量写入失败时没有创建任何对象，逐个重新创建以得到每个对象的错误
+		// 写入之后出现的错误只属于对应的对象，不能重新创建，否则会产生重复的对象
+		// 无法确定哪些对象已经写入时同样返回每个对象的错误
+		createErrs, err := first.database().CreateObjects(className, data, first.RunOptions)
+		for n, i := range pending {
+			write := writes[i]
+			if err != nil {
+				err := write.runDatabaseOperation()
+				if err != nil {
+					errList[i] = err
+					delete(writes, i)
+				}
+				continue
+			}
+			if createErrs[n] != nil {
+				errList[i] = createErrs[n]
+				delete(writes, i)
+				continue
+			}
+			write.setCreateResponse()
+			// 不在事务中时记录历史失败只写日志，对象仍然返回创建成功
+			if err := write.recordHistory(); err != nil {
+				errList[i] = err
+				delete(writes, i)
+			}
+		}
+	}
+
+	for i := range objects {
+		if write, ok := writes[i]; ok {
+			results[i], errList[i] = write.afterDatabaseOperation()
+		}
+	}
+	return results, errList
+}
+
 // Update 更新对象
 // 返回更新后的字段，一般只有 updatedAt
 func Update(auth *Auth, className, objectID string, object types.M, clientSDK map[string]string) (types.M, error) {
+	return update(nil, auth, className, objectID, object, clientSDK)
+}
+
+func update(db *orm.DBController, auth *Auth, className, objectID string, object types.M, clientSDK map[string]string) (types.M, error) {
 
 	err := enforceRoleSecurity("update", className, auth)
 	if err != nil {
 		return nil, err
 	}
 
+	query := types.M{"objectId": objectID}
+	precondition, err := ifMatchCondition(ifMatch(db))
+	if err != nil {
+		return nil, err
+	}
+	if precondition != nil {
+		query["updatedAt"] = precondition
+	}
+
 	var originalRestObject types.M
 
 	// 如果存在删前回调、或者删后回调，则需要获取到要删除的对象数据
 	var response types.M
 	hasTriggers := checkTriggers(className, []string{cloud.TypeBeforeSave, cloud.TypeAfterSave})
 	hasLiveQuery := checkLiveQuery(className)
-	if hasTriggers || hasLiveQuery {
-		response, err = Find(auth, className, types.M{"objectId": objectID}, types.M{}, clientSDK)
+	if hasTriggers || hasLiveQuery || historyEnabled(db, className) {
+		response, err = find(db, auth, className, types.M{"objectId": objectID}, types.M{}, clientSDK)
 		if err != nil || utils.HasResults(response) == false {
 			return nil, errs.E(errs.ObjectNotFound, "Object not found for update.")
 		}
@@ -137,14 +293,22 @@ func Update(auth *Auth, className, objectID string, object types.M, clientSDK ma
 		if originalRestObject == nil {
 			return nil, errs.E(errs.ObjectNotFound, "Object not found for update.")
 		}
+		if precondition != nil && MatchETag(ifMatch(db), ETag(originalRestObject)) == false {
+			return nil, errs.E(errs.PreconditionFailed, "Object has been modified.")
+		}
 	}
 
-	write, err := NewWrite(auth, className, types.M{"objectId": objectID}, object, originalRestObject, clientSDK)
+	write, err := NewWrite(auth, className, query, object, originalRestObject, clientSDK)
 	if err != nil {
 		return nil, err
 	}
+	write.db = db
 
-	return write.Execute()
+	result, err := write.Execute()
+	if err != nil && precondition != nil {
+		return nil, preconditionError(db, auth, className, objectID, err)
+	}
+	return result, err
 }
 
 // enforceRoleSecurity 对指定的类与操作进行安全校验
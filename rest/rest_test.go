@@ -1,13 +1,18 @@
 package rest
 
 import (
+	"context"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/lfq7413/tomato/cloud"
 	"github.com/lfq7413/tomato/config"
 	"github.com/lfq7413/tomato/errs"
 	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/storage/memory"
 	"github.com/lfq7413/tomato/types"
 	"github.com/lfq7413/tomato/utils"
 )
@@ -138,6 +143,69 @@ func Test_Find(t *testing.T) {
 	orm.TomatoDBController.DeleteEverything()
 }
 
+func Test_FindCursor(t *testing.T) {
+	var schema types.M
+	var className string
+	var result, expect types.M
+	var err error
+	/********************************************************/
+	initEnv()
+	className = "user"
+	schema = types.M{
+		"fields": types.M{
+			"key":   types.M{"type": "String"},
+			"score": types.M{"type": "Number"},
+		},
+	}
+	orm.Adapter.CreateClass(className, schema)
+	for _, id := range []string{"03", "01", "02"} {
+		orm.Adapter.CreateObject(className, schema, types.M{"objectId": id, "key": "k" + id, "score": 1})
+	}
+	// 未开启游标分页时，排序、返回的字段与结果保持不变
+	result, err = Find(Master(), className, types.M{}, types.M{"limit": 2, "order": "score", "keys": "key"}, nil)
+	expect = types.M{
+		"results": types.S{
+			types.M{"objectId": "03", "key": "k03"},
+			types.M{"objectId": "01", "key": "k01"},
+		},
+	}
+	if err != nil || reflect.DeepEqual(expect, result) == false {
+		t.Error("expect:", expect, "result:", result)
+	}
+	/********************************************************/
+	// 开启游标分页时，以 objectId 作为最后一个排序字段，并返回 nextCursor
+	result, err = Find(Master(), className, types.M{}, types.M{"limit": 2, "order": "score", "keys": "key", "cursor": true}, nil)
+	if err != nil || result["nextCursor"] == nil {
+		t.Error("expect:", "nextCursor", "result:", result, err)
+	}
+	cursor := result["nextCursor"]
+	delete(result, "nextCursor")
+	expect = types.M{
+		"results": types.S{
+			types.M{"objectId": "01", "key": "k01"},
+			types.M{"objectId": "02", "key": "k02"},
+		},
+	}
+	if reflect.DeepEqual(expect, result) == false {
+		t.Error("expect:", expect, "result:", result)
+	}
+	result, err = Find(Master(), className, types.M{}, types.M{"limit": 2, "order": "score", "keys": "key", "cursor": cursor}, nil)
+	expect = types.M{
+		"results": types.S{
+			types.M{"objectId": "03", "key": "k03"},
+		},
+	}
+	if err != nil || reflect.DeepEqual(expect, result) == false {
+		t.Error("expect:", expect, "result:", result)
+	}
+	/********************************************************/
+	_, err = Find(Master(), className, types.M{}, types.M{"cursor": true}, nil)
+	if errs.GetErrorCode(err) != errs.InvalidQuery {
+		t.Error("expect:", errs.InvalidQuery, "result:", err)
+	}
+	orm.TomatoDBController.DeleteEverything()
+}
+
 func Test_Get(t *testing.T) {
 	var object, schema types.M
 	var className string
@@ -324,6 +392,160 @@ func Test_Create(t *testing.T) {
 	orm.TomatoDBController.DeleteEverything()
 }
 
+func Test_CreateObjects(t *testing.T) {
+	var auth *Auth
+	var className string
+	var objects []types.M
+	var results []types.M
+	var errList []error
+	var expect error
+	/********************************************************/
+	initEnv()
+	auth = Master()
+	className = "user"
+	objects = []types.M{
+		types.M{"name": "joe"},
+		types.M{"name": "jack"},
+	}
+	config.TConfig.ServerURL = "http://127.0.0.1/v1"
+	results, errList = CreateObjects(auth, className, objects, nil)
+	for i := range objects {
+		if errList[i] != nil || results[i] == nil || utils.M(results[i]["response"])["objectId"] == nil {
+			t.Error("expect:", nil, "result:", results[i], errList[i])
+		}
+	}
+	response, _ := Find(auth, className, types.M{}, types.M{}, nil)
+	if len(utils.A(response["results"])) != 2 {
+		t.Error("expect:", 2, "result:", response["results"])
+	}
+	orm.TomatoDBController.DeleteEverything()
+	/********************************************************/
+	initEnv()
+	saved := []string{}
+	cloud.BeforeSave("user", func(request cloud.TriggerRequest, response cloud.Response) {
+		if utils.S(request.Object["name"]) == "" {
+			response.Error(1, "need a name")
+			return
+		}
+		response.Success(nil)
+	})
+	cloud.AfterSave("user", func(request cloud.TriggerRequest, response cloud.Response) {
+		saved = append(saved, utils.S(request.Object["name"]))
+		response.Success(nil)
+	})
+	auth = Master()
+	className = "user"
+	objects = []types.M{
+		types.M{"name": "joe"},
+		types.M{"key": "hello"},
+		types.M{"name": "jack"},
+	}
+	results, errList = CreateObjects(auth, className, objects, nil)
+	if errList[0] != nil || errList[2] != nil || results[0] == nil || results[2] == nil {
+		t.Error("expect:", nil, "result:", errList)
+	}
+	expect = errs.E(1, "need a name")
+	if reflect.DeepEqual(expect, errList[1]) == false || results[1] != nil {
+		t.Error("expect:", expect, "result:", errList[1])
+	}
+	if reflect.DeepEqual([]string{"joe", "jack"}, saved) == false {
+		t.Error("expect:", []string{"joe", "jack"}, "result:", saved)
+	}
+	cloud.UnregisterAll()
+	orm.TomatoDBController.DeleteEverything()
+	/********************************************************/
+	initEnv()
+	auth = Master()
+	className = "user"
+	orm.Adapter.CreateClass(className, types.M{"fields": types.M{"name": types.M{"type": "String"}}})
+	orm.Adapter.CreateObject(className, types.M{}, types.M{"objectId": "01", "name": "tom"})
+	orm.Adapter.EnsureUniqueness(className, types.M{"fields": types.M{"name": types.M{"type": "String"}}}, []string{"name"})
+	objects = []types.M{
+		types.M{"name": "joe"},
+		types.M{"name": "tom"},
+	}
+	results, errList = CreateObjects(auth, className, objects, nil)
+	if errList[0] != nil || results[0] == nil {
+		t.Error("expect:", nil, "result:", errList[0])
+	}
+	expect = errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
+	if reflect.DeepEqual(expect, errList[1]) == false {
+		t.Error("expect:", expect, "result:", errList[1])
+	}
+	orm.TomatoDBController.DeleteEverything()
+	/********************************************************/
+	// 写入之后出错时不再逐个重新创建
+	orm.InitOrm(&relationFailAdapter{memory.NewMemoryAdapter("tomato")})
+	auth = Master()
+	className = "user"
+	objects = []types.M{
+		types.M{"name": "joe"},
+		types.M{
+			"name": "jack",
+			"friends": types.M{
+				"__op":    "AddRelation",
+				"objects": types.S{types.M{"__type": "Pointer", "className": "user", "objectId": "01"}},
+			},
+		},
+	}
+	results, errList = CreateObjects(auth, className, objects, nil)
+	if errList[0] != nil || results[0] == nil {
+		t.Error("expect:", nil, "result:", errList[0])
+	}
+	expect = errs.E(errs.InternalServerError, "add relation failed")
+	if reflect.DeepEqual(expect, errList[1]) == false || results[1] != nil {
+		t.Error("expect:", expect, "result:", errList[1])
+	}
+	response, _ = Find(auth, className, types.M{}, types.M{}, nil)
+	if len(utils.A(response["results"])) != 2 {
+		t.Error("expect:", 2, "result:", response["results"])
+	}
+	orm.TomatoDBController.DeleteEverything()
+	/********************************************************/
+	// 无法确定哪些对象已经写入时，每个对象都返回错误，不再逐个重新创建
+	orm.InitOrm(&incompleteCreateAdapter{memory.NewMemoryAdapter("tomato")})
+	auth = Master()
+	className = "user"
+	objects = []types.M{
+		types.M{"name": "joe"},
+		types.M{"name": "jack"},
+	}
+	results, errList = CreateObjects(auth, className, objects, nil)
+	for i := range objects {
+		if reflect.DeepEqual(storage.ErrBulkCreateIncomplete, errList[i]) == false || results[i] != nil {
+			t.Error("expect:", storage.ErrBulkCreateIncomplete, "result:", results[i], errList[i])
+		}
+	}
+	response, _ = Find(auth, className, types.M{}, types.M{}, nil)
+	if len(utils.A(response["results"])) != 1 {
+		t.Error("expect:", 1, "result:", response["results"])
+	}
+	orm.TomatoDBController.DeleteEverything()
+	initEnv()
+}
+
+// incompleteCreateAdapter 批量创建时只写入第一个对象，并且无法撤销的适配器
+type incompleteCreateAdapter struct {
+	*memory.MemoryAdapter
+}
+
+func (i *incompleteCreateAdapter) CreateObjects(className string, schema types.M, objects []types.M) error {
+	i.MemoryAdapter.CreateObject(className, schema, objects[0])
+	return storage.ErrBulkCreateIncomplete
+}
+
+// relationFailAdapter 写入 _Join 表时出错的适配器
+type relationFailAdapter struct {
+	*memory.MemoryAdapter
+}
+
+func (r *relationFailAdapter) UpsertOneObject(className string, schema, query, update types.M) error {
+	if strings.HasPrefix(className, "_Join:") {
+		return errs.E(errs.InternalServerError, "add relation failed")
+	}
+	return r.MemoryAdapter.UpsertOneObject(className, schema, query, update)
+}
+
 func Test_Update(t *testing.T) {
 	var auth *Auth
 	var className, objectID string
@@ -454,3 +676,276 @@ func Test_Update(t *testing.T) {
 	}
 	orm.TomatoDBController.DeleteEverything()
 }
+
+func Test_IfMatch(t *testing.T) {
+	var auth *Auth
+	var className, objectID, etag, oldETag string
+	var result types.M
+	var err, expectErr error
+	/********************************************************/
+	initEnv()
+	config.TConfig.ServerURL = "http://127.0.0.1/v1"
+	auth = Master()
+	className = "user"
+	result, _ = Create(auth, className, types.M{"name": "joe"}, nil)
+	objectID = utils.S(utils.M(result["response"])["objectId"])
+	result, _ = Get(auth, className, objectID, types.M{}, nil)
+	oldETag = ETag(utils.M(utils.A(result["results"])[0]))
+	time.Sleep(2 * time.Millisecond)
+	result, err = UpdateContext(WithIfMatch(context.Background(), oldETag), auth, className, objectID, types.M{"name": "jack"}, nil)
+	if err != nil || result == nil {
+		t.Error("expect:", nil, "result:", result, err)
+	}
+	etag = ETag(utils.M(result["response"]))
+	if etag == "" || etag == oldETag {
+		t.Error("expect:", "new etag", "result:", etag)
+	}
+	_, err = UpdateContext(WithIfMatch(context.Background(), oldETag), auth, className, objectID, types.M{"name": "tom"}, nil)
+	expectErr = errs.E(errs.PreconditionFailed, "Object has been modified.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	_, err = UpdateContext(WithIfMatch(context.Background(), "abc"), auth, className, objectID, types.M{"name": "tom"}, nil)
+	expectErr = errs.E(errs.PreconditionFailed, "Invalid ETag: abc")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	_, err = UpdateContext(WithIfMatch(context.Background(), oldETag), auth, className, "02", types.M{"name": "tom"}, nil)
+	if errs.GetErrorCode(err) != errs.ObjectNotFound {
+		t.Error("expect:", errs.ObjectNotFound, "result:", err)
+	}
+	err = DeleteContext(WithIfMatch(context.Background(), oldETag), auth, className, objectID)
+	expectErr = errs.E(errs.PreconditionFailed, "Object has been modified.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	err = DeleteContext(WithIfMatch(context.Background(), strings.Trim(etag, `"`)), auth, className, objectID)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	result, _ = Find(auth, className, types.M{}, types.M{}, nil)
+	if utils.HasResults(result) {
+		t.Error("expect:", "empty", "result:", result)
+	}
+	orm.TomatoDBController.DeleteEverything()
+	/********************************************************/
+	initEnv()
+	config.TConfig.ServerURL = "http://127.0.0.1/v1"
+	auth = Master()
+	className = "user"
+	result, _ = Create(auth, className, types.M{"name": "joe"}, nil)
+	objectID = utils.S(utils.M(result["response"])["objectId"])
+	cloud.BeforeSave(className, func(request cloud.TriggerRequest, response cloud.Response) {
+		t.Error("expect:", "no beforeSave", "result:", request.Object)
+		response.Success(nil)
+	})
+	_, err = UpdateContext(WithIfMatch(context.Background(), `"2006-01-02T15:04:05.000Z"`), auth, className, objectID, types.M{"name": "jack"}, nil)
+	expectErr = errs.E(errs.PreconditionFailed, "Object has been modified.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	cloud.UnregisterAll()
+	orm.TomatoDBController.DeleteEverything()
+}
+
+func Test_History(t *testing.T) {
+	var result types.M
+	var err, expectErr error
+	/********************************************************/
+	initEnv()
+	config.TConfig.ServerURL = "http://127.0.0.1/v1"
+	schema := orm.TomatoDBController.LoadSchema(nil)
+	schema.AddClassIfNotExists("post", types.M{"title": types.M{"type": "String"}}, nil, nil)
+	_, err = schema.SetClassOptions("post", types.M{"history": types.M{}})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	result, err = Create(Master(), "post", types.M{"title": "a"}, nil)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	objectID := utils.S(utils.M(result["response"])["objectId"])
+	time.Sleep(5 * time.Millisecond)
+	_, err = Update(Master(), "post", objectID, types.M{"title": "b"}, nil)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	result, err = History(Master(), "post", objectID, types.M{}, nil)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	results := utils.A(result["results"])
+	if len(results) != 2 {
+		t.Fatal("expect:", 2, "result:", len(results))
+	}
+	update := utils.M(results[0])
+	if update["action"] != HistoryUpdate || update["master"] != true ||
+		len(utils.M(update["update"])) != 1 || utils.M(update["update"])["title"] != "b" ||
+		utils.M(update["before"])["title"] != "a" || utils.M(update["after"])["title"] != "b" {
+		t.Error("expect:", HistoryUpdate, "result:", update)
+	}
+	create := utils.M(results[1])
+	if create["action"] != HistoryCreate || create["before"] != nil || utils.M(create["after"])["title"] != "a" {
+		t.Error("expect:", HistoryCreate, "result:", create)
+	}
+	/********************************************************/
+	_, err = Revert(Master(), "post", objectID, utils.S(create["objectId"]), nil)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	result, _ = Get(Master(), "post", objectID, types.M{}, nil)
+	if utils.M(utils.A(result["results"])[0])["title"] != "a" {
+		t.Error("expect:", "a", "result:", result)
+	}
+	/********************************************************/
+	time.Sleep(5 * time.Millisecond)
+	err = Delete(Master(), "post", objectID)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	result, _ = History(Master(), "post", objectID, types.M{"limit": 1}, nil)
+	results = utils.A(result["results"])
+	if len(results) != 1 || utils.M(results[0])["action"] != HistoryDelete ||
+		utils.M(utils.M(results[0])["before"])["title"] != "a" || utils.M(results[0])["after"] != nil {
+		t.Error("expect:", HistoryDelete, "result:", results)
+	}
+	/********************************************************/
+	_, err = History(Nobody(), "post", objectID, types.M{}, nil)
+	expectErr = errs.E(errs.ObjectNotFound, "Object not found.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	orm.TomatoDBController.DeleteEverything()
+	/********************************************************/
+	initEnv()
+	orm.TomatoDBController.Create("user", types.M{"objectId": "01", "name": "joe"}, nil)
+	_, err = History(Master(), "user", "01", types.M{}, nil)
+	expectErr = errs.E(errs.OperationForbidden, "History is not enabled on class user.")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	orm.TomatoDBController.DeleteEverything()
+	/********************************************************/
+	// 加密字段不记录到修改历史中
+	initEnv()
+	config.TConfig.FieldEncryptionKeys = "k1:MDEyMzQ1Njc4OWFiY2RlZg=="
+	schema = orm.TomatoDBController.LoadSchema(nil)
+	schema.AddClassIfNotExists("post", types.M{"title": types.M{"type": "String"}}, nil, nil)
+	_, err = schema.SetClassOptions("post", types.M{"history": types.M{}, "encryptedFields": types.M{"ssn": types.M{}}})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	result, _ = Create(Master(), "post", types.M{"title": "a", "ssn": "123"}, nil)
+	objectID = utils.S(utils.M(result["response"])["objectId"])
+	Update(Master(), "post", objectID, types.M{"title": "b", "ssn": "456"}, nil)
+	records, _ := orm.TomatoDBController.Find("_History", types.M{}, types.M{})
+	if len(records) != 2 {
+		t.Error("expect:", 2, "result:", records)
+	}
+	for _, v := range records {
+		for _, key := range []string{"update", "before", "after"} {
+			if object := utils.M(utils.M(v)[key]); object != nil && object["ssn"] != nil {
+				t.Error("expect:", "no ssn", "result:", v)
+			}
+		}
+	}
+	config.TConfig.FieldEncryptionKeys = ""
+	orm.TomatoDBController.DeleteEverything()
+	/********************************************************/
+	// 记录历史失败时，不在事务中的修改仍然返回成功
+	orm.InitOrm(&historyFailAdapter{memory.NewMemoryAdapter("tomato")})
+	schema = orm.TomatoDBController.LoadSchema(nil)
+	schema.AddClassIfNotExists("post", types.M{"title": types.M{"type": "String"}}, nil, nil)
+	schema.SetClassOptions("post", types.M{"history": types.M{}})
+	result, err = Create(Master(), "post", types.M{"title": "a"}, nil)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	objectID = utils.S(utils.M(result["response"])["objectId"])
+	_, err = Update(Master(), "post", objectID, types.M{"title": "b"}, nil)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	created, errList := CreateObjects(Master(), "post", []types.M{types.M{"title": "c"}, types.M{"title": "d"}}, nil)
+	if errList[0] != nil || errList[1] != nil || created[0] == nil || created[1] == nil {
+		t.Error("expect:", nil, "result:", created, errList)
+	}
+	err = Delete(Master(), "post", objectID)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	/********************************************************/
+	// 事务中记录历史失败时返回错误，修改随事务回滚
+	tx, _ := BeginTransaction()
+	_, err = tx.Create(Master(), "post", types.M{"title": "e"}, nil)
+	expectErr = errs.E(errs.InternalServerError, "record history failed")
+	if reflect.DeepEqual(expectErr, err) == false {
+		t.Error("expect:", expectErr, "result:", err)
+	}
+	tx.Rollback()
+	result, _ = Find(Master(), "post", types.M{}, types.M{}, nil)
+	if len(utils.A(result["results"])) != 2 {
+		t.Error("expect:", 2, "result:", result["results"])
+	}
+	orm.TomatoDBController.DeleteEverything()
+	initEnv()
+}
+
+// historyFailAdapter 写入 _History 时出错的适配器
+type historyFailAdapter struct {
+	*memory.MemoryAdapter
+}
+
+func (h *historyFailAdapter) CreateObject(className string, schema, object types.M) error {
+	if className == "_History" {
+		return errs.E(errs.InternalServerError, "record history failed")
+	}
+	return h.MemoryAdapter.CreateObject(className, schema, object)
+}
+
+func (h *historyFailAdapter) Begin() (storage.Transaction, error) {
+	tx, err := h.MemoryAdapter.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &historyFailAdapter{tx.(*memory.MemoryAdapter)}, nil
+}
+
+func (h *historyFailAdapter) WithContext(ctx context.Context) storage.Adapter {
+	return &historyFailAdapter{h.MemoryAdapter.WithContext(ctx).(*memory.MemoryAdapter)}
+}
+
+func Test_SweepExpiredObjects(t *testing.T) {
+	var result types.M
+	var err error
+	/********************************************************/
+	initEnv()
+	schema := orm.TomatoDBController.LoadSchema(nil)
+	schema.AddClassIfNotExists("otp", types.M{"code": types.M{"type": "String"}}, nil, nil)
+	_, err = schema.SetClassOptions("otp", types.M{"ttl": types.M{"field": "expiresAt", "mode": "destroy"}})
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	expiresAt := types.M{"__type": "Date", "iso": utils.TimetoString(time.Now().UTC().Add(-time.Minute))}
+	orm.TomatoDBController.Create("otp", types.M{"objectId": "01", "code": "a", "expiresAt": expiresAt}, nil)
+	orm.TomatoDBController.Create("otp", types.M{"objectId": "02", "code": "b"}, nil)
+	deleted := []string{}
+	cloud.AfterDelete("otp", func(request cloud.TriggerRequest, response cloud.Response) {
+		deleted = append(deleted, utils.S(request.Object["objectId"]))
+	})
+	result, err = SweepExpiredObjects(context.Background())
+	expect := types.M{"otp": 1}
+	if err != nil || reflect.DeepEqual(expect, result) == false {
+		t.Error("expect:", expect, "result:", result, err)
+	}
+	if reflect.DeepEqual([]string{"01"}, deleted) == false {
+		t.Error("expect:", "01", "result:", deleted)
+	}
+	results, _ := orm.TomatoDBController.IncludeExpired().Find("otp", types.M{}, types.M{})
+	if len(results) != 1 || utils.M(results[0])["objectId"] != "02" {
+		t.Error("expect:", "02", "result:", results)
+	}
+	cloud.RemoveTrigger(cloud.TypeAfterDelete, "otp")
+	orm.TomatoDBController.DeleteEverything()
+}
@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/types"
+)
+
+// Transaction 在同一个数据库事务中执行多个请求
+// 所有操作在 Commit 之后生效，调用 Rollback 时全部撤销
+// afterSave 、 afterDelete 回调与 LiveQuery 通知在 Commit 成功之后执行， Rollback 时丢弃
+type Transaction struct {
+	db    *orm.DBController
+	mu    sync.Mutex
+	after []func()
+}
+
+type transactionKey struct{}
+
+// ErrTransactionsNotSupported 数据库不支持事务时，开始事务返回的错误
+var ErrTransactionsNotSupported = errs.E(errs.OperationForbidden, "Transactions are not supported by the current database.")
+
+// TransactionsSupported 当前数据库是否支持事务，不支持时（如 MongoDB）开始事务总是返回 ErrTransactionsNotSupported
+func TransactionsSupported() bool {
+	return orm.TomatoDBController.SupportsTransactions()
+}
+
+// BeginTransaction 开始一个新的事务
+func BeginTransaction() (*Transaction, error) {
+	if TransactionsSupported() == false {
+		return nil, ErrTransactionsNotSupported
+	}
+	db, err := orm.TomatoDBController.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return newTransaction(db), nil
+}
+
+// newTransaction 使用已经开始事务的 db 创建 Transaction ，事务中的操作通过 db 的 ctx 找到所在的 Transaction
+func newTransaction(db *orm.DBController) *Transaction {
+	t := &Transaction{}
+	t.db = db.WithContext(context.WithValue(db.Context(), transactionKey{}, t))
+	return t
+}
+
+// afterCommit 在 db 所在的事务提交之后执行 fn ，不在事务中时立即执行
+func afterCommit(db *orm.DBController, fn func()) {
+	t, _ := db.Context().Value(transactionKey{}).(*Transaction)
+	if t == nil {
+		fn()
+		return
+	}
+	t.mu.Lock()
+	t.after = append(t.after, fn)
+	t.mu.Unlock()
+}
+
+// inTransaction db 是否处于 Transaction 中
+func inTransaction(db *orm.DBController) bool {
+	if db == nil {
+		return false
+	}
+	t, _ := db.Context().Value(transactionKey{}).(*Transaction)
+	return t != nil
+}
+
+// Find 在事务中查找数据
+func (t *Transaction) Find(auth *Auth, className string, where, options types.M, clientSDK map[string]string) (types.M, error) {
+	result, err := find(t.db, auth, className, where, options, clientSDK)
+	return result, contextError(t.db.Context(), err)
+}
+
+// Get 在事务中获取指定对象
+func (t *Transaction) Get(auth *Auth, className, objectID string, options types.M, clientSDK map[string]string) (types.M, error) {
+	result, err := get(t.db, auth, className, objectID, options, clientSDK)
+	return result, contextError(t.db.Context(), err)
+}
+
+// Create 在事务中创建对象
+func (t *Transaction) Create(auth *Auth, className string, object types.M, clientSDK map[string]string) (types.M, error) {
+	result, err := create(t.db, auth, className, object, clientSDK)
+	return result, contextError(t.db.Context(), err)
+}
+
+// Update 在事务中更新对象
+func (t *Transaction) Update(auth *Auth, className, objectID string, object types.M, clientSDK map[string]string) (types.M, error) {
+	result, err := update(t.db, auth, className, objectID, object, clientSDK)
+	return result, contextError(t.db.Context(), err)
+}
+
+// Delete 在事务中删除对象
+func (t *Transaction) Delete(auth *Auth, className, objectID string) error {
+	return contextError(t.db.Context(), del(t.db, auth, className, objectID))
+}
+
+// Commit 提交事务，成功后按顺序执行事务中的回调与通知
+func (t *Transaction) Commit() error {
+	err := t.db.Commit()
+	t.mu.Lock()
+	after := t.after
+	t.after = nil
+	t.mu.Unlock()
+	if err != nil {
+		return contextError(t.db.Context(), err)
+	}
+	for _, fn := range after {
+		fn()
+	}
+	return nil
+}
+
+// Rollback 回滚事务，丢弃事务中的回调与通知
+func (t *Transaction) Rollback() error {
+	t.mu.Lock()
+	t.after = nil
+	t.mu.Unlock()
+	return t.db.Rollback()
+}
@@ -0,0 +1,98 @@
+package rest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/lfq7413/tomato/cloud"
+	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/storage/memory"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+func Test_Transaction(t *testing.T) {
+	var tx *Transaction
+	var result types.M
+	var err error
+	var calls, expect []string
+	register := func() {
+		calls = []string{}
+		cloud.AfterSave("post", func(request cloud.TriggerRequest, response cloud.Response) {
+			calls = append(calls, "afterSave:"+utils.S(request.Object["title"]))
+		})
+		cloud.AfterDelete("post", func(request cloud.TriggerRequest, response cloud.Response) {
+			calls = append(calls, "afterDelete:"+utils.S(request.Object["title"]))
+		})
+	}
+	/********************************************************/
+	// 事务中的回调在提交之后执行
+	orm.InitOrm(memory.NewMemoryAdapter("tomato"))
+	register()
+	tx, _ = BeginTransaction()
+	result, err = tx.Create(Master(), "post", types.M{"title": "a"}, nil)
+	if err != nil {
+		t.Error("expect:", nil, "result:", err)
+	}
+	objectID := utils.S(utils.M(result["response"])["objectId"])
+	tx.Update(Master(), "post", objectID, types.M{"title": "b"}, nil)
+	expect = []string{}
+	if reflect.DeepEqual(expect, calls) == false {
+		t.Error("expect:", expect, "result:", calls)
+	}
+	err = tx.Commit()
+	expect = []string{"afterSave:a", "afterSave:b"}
+	if err != nil || reflect.DeepEqual(expect, calls) == false {
+		t.Error("expect:", expect, "result:", calls, err)
+	}
+	/********************************************************/
+	// 回滚时丢弃事务中的回调
+	calls = []string{}
+	tx, _ = BeginTransaction()
+	tx.Create(Master(), "post", types.M{"title": "c"}, nil)
+	tx.Delete(Master(), "post", objectID)
+	tx.Rollback()
+	expect = []string{}
+	if reflect.DeepEqual(expect, calls) == false {
+		t.Error("expect:", expect, "result:", calls)
+	}
+	result, _ = Find(Master(), "post", types.M{}, types.M{}, nil)
+	if len(utils.A(result["results"])) != 1 {
+		t.Error("expect:", 1, "result:", result)
+	}
+	/********************************************************/
+	// 不在事务中时立即执行
+	calls = []string{}
+	Delete(Master(), "post", objectID)
+	expect = []string{"afterDelete:b"}
+	if reflect.DeepEqual(expect, calls) == false {
+		t.Error("expect:", expect, "result:", calls)
+	}
+	cloud.UnregisterAll()
+	orm.TomatoDBController.DeleteEverything()
+	/********************************************************/
+	// 数据库不支持事务时直接返回错误
+	orm.InitOrm(noTransactionAdapter{memory.NewMemoryAdapter("tomato")})
+	if TransactionsSupported() {
+		t.Error("expect:", false, "result:", true)
+	}
+	tx, err = BeginTransaction()
+	if tx != nil || reflect.DeepEqual(ErrTransactionsNotSupported, err) == false {
+		t.Error("expect:", ErrTransactionsNotSupported, "result:", tx, err)
+	}
+	tx, err = BeginTransactionContext(context.Background())
+	if tx != nil || reflect.DeepEqual(ErrTransactionsNotSupported, err) == false {
+		t.Error("expect:", ErrTransactionsNotSupported, "result:", tx, err)
+	}
+	orm.InitOrm(memory.NewMemoryAdapter("tomato"))
+}
+
+// noTransactionAdapter 不支持事务的适配器
+type noTransactionAdapter struct {
+	*memory.MemoryAdapter
+}
+
+func (a noTransactionAdapter) SupportsTransactions() bool {
+	return false
+}
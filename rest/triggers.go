@@ -1,16 +1,19 @@
 package rest
 
 import (
+	"context"
+
 	"github.com/lfq7413/tomato/cloud"
 	"github.com/lfq7413/tomato/types"
 	"github.com/lfq7413/tomato/utils"
 )
 
-func getRequest(triggerType string, auth *Auth, parseObject, originalParseObject types.M) cloud.TriggerRequest {
+func getRequest(ctx context.Context, triggerType string, auth *Auth, parseObject, originalParseObject types.M) cloud.TriggerRequest {
 	request := cloud.TriggerRequest{
 		TriggerName: triggerType,
 		Object:      parseObject,
 		Master:      false,
+		Context:     ctx,
 	}
 
 	if originalParseObject != nil {
@@ -38,12 +41,13 @@ func getResponse(request cloud.TriggerRequest) *cloud.TriggerResponse {
 	return response
 }
 
-func getRequestQuery(triggerType string, auth *Auth, query types.M, count bool) cloud.TriggerRequest {
+func getRequestQuery(ctx context.Context, triggerType string, auth *Auth, query types.M, count bool) cloud.TriggerRequest {
 	request := cloud.TriggerRequest{
 		TriggerName: triggerType,
 		Query:       query,
 		Count:       count,
 		Master:      false,
+		Context:     ctx,
 	}
 
 	if auth == nil {
@@ -60,7 +64,7 @@ func getRequestQuery(triggerType string, auth *Auth, query types.M, count bool)
 	return request
 }
 
-func maybeRunTrigger(triggerType string, auth *Auth, parseObject, originalParseObject types.M) (types.M, error) {
+func maybeRunTrigger(ctx context.Context, triggerType string, auth *Auth, parseObject, originalParseObject types.M) (types.M, error) {
 	if parseObject == nil {
 		return types.M{}, nil
 	}
@@ -69,13 +73,13 @@ func maybeRunTrigger(triggerType string, auth *Auth, parseObject, originalParseO
 	if trigger == nil {
 		return types.M{}, nil
 	}
-	request := getRequest(triggerType, auth, parseObject, originalParseObject)
+	request := getRequest(ctx, triggerType, auth, parseObject, originalParseObject)
 	response := getResponse(request)
 	trigger(request, response)
 	return response.Response, response.Err
 }
 
-func maybeRunQueryTrigger(triggerType, className string, restWhere, restOptions types.M, auth *Auth) (types.M, types.M, error) {
+func maybeRunQueryTrigger(ctx context.Context, triggerType, className string, restWhere, restOptions types.M, auth *Auth) (types.M, types.M, error) {
 	trigger := cloud.GetTrigger(triggerType, className)
 	if trigger == nil {
 		return restWhere, restOptions, nil
@@ -102,7 +106,7 @@ func maybeRunQueryTrigger(triggerType, className string, restWhere, restOptions
 		count = true
 	}
 
-	request := getRequestQuery(triggerType, auth, query, count)
+	request := getRequestQuery(ctx, triggerType, auth, query, count)
 	response := getResponse(request)
 	trigger(request, response)
 
@@ -131,12 +135,12 @@ func maybeRunQueryTrigger(triggerType, className string, restWhere, restOptions
 	return restWhere, restOptions, nil
 }
 
-func maybeRunAfterFindTrigger(triggerType, className string, objects types.S, auth *Auth) (types.S, error) {
+func maybeRunAfterFindTrigger(ctx context.Context, triggerType, className string, objects types.S, auth *Auth) (types.S, error) {
 	trigger := cloud.GetTrigger(triggerType, className)
 	if trigger == nil {
 		return objects, nil
 	}
-	request := getRequest(triggerType, auth, nil, nil)
+	request := getRequest(ctx, triggerType, auth, nil, nil)
 	response := getResponse(request)
 	request.Objects = objects
 	trigger(request, response)
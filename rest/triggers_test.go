@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -25,12 +26,12 @@ func Test_maybeRunTrigger(t *testing.T) {
 			response.Error(1, "need a username")
 		}
 	})
-	_, err = maybeRunTrigger(cloud.TypeBeforeSave, Master(), types.M{"className": "user"}, nil)
+	_, err = maybeRunTrigger(context.Background(), cloud.TypeBeforeSave, Master(), types.M{"className": "user"}, nil)
 	expectErr = errs.E(1, "need a username")
 	if reflect.DeepEqual(expectErr, err) == false {
 		t.Error("expect:", expectErr, "result:", err)
 	}
-	result, err = maybeRunTrigger(cloud.TypeBeforeSave, Master(), types.M{"className": "user", "username": "joe"}, nil)
+	result, err = maybeRunTrigger(context.Background(), cloud.TypeBeforeSave, Master(), types.M{"className": "user", "username": "joe"}, nil)
 	expect = types.M{
 		"object": types.M{
 			"className": "user",
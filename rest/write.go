@@ -34,6 +34,7 @@ type Write struct {
 	updatedAt                  string
 	responseShouldHaveUsername bool
 	clientSDK                  map[string]string
+	db                         *orm.DBController // 为空时使用 orm.TomatoDBController
 }
 
 // NewWrite 可用于 create 和 update ， create 时 	query 为 nil
@@ -82,53 +83,75 @@ func NewWrite(
 	return write, nil
 }
 
+// database 返回执行数据库操作的 DBController
+func (w *Write) database() *orm.DBController {
+	if w.db != nil {
+		return w.db
+	}
+	return orm.TomatoDBController
+}
+
 // Execute 执行写入操作，并返回结果
 func (w *Write) Execute() (types.M, error) {
-	err := w.getUserAndRoleACL()
+	err := w.beforeDatabaseOperation()
 	if err != nil {
 		return nil, err
 	}
-	err = w.validateClientClassCreation()
+	err = w.runDatabaseOperation()
 	if err != nil {
 		return nil, err
 	}
+	return w.afterDatabaseOperation()
+}
+
+// beforeDatabaseOperation 执行写入数据库之前的步骤，包括权限检查、 beforeSave 回调与数据校验
+func (w *Write) beforeDatabaseOperation() error {
+	err := w.getUserAndRoleACL()
+	if err != nil {
+		return err
+	}
+	err = w.validateClientClassCreation()
+	if err != nil {
+		return err
+	}
 	err = w.handleInstallation()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	err = w.handleSession()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	err = w.validateAuthData()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	err = w.runBeforeTrigger()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	err = w.validateSchema()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	err = w.setRequiredFieldsIfNeeded()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	err = w.transformUser()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	err = w.expandFilesForExistingObjects()
 	if err != nil {
-		return nil, err
-	}
-	err = w.runDatabaseOperation()
-	if err != nil {
-		return nil, err
+		return err
 	}
-	err = w.createSessionTokenIfNeeded()
+	return nil
+}
+
+// afterDatabaseOperation 执行写入数据库之后的步骤，包括 afterSave 回调与 LiveQuery 通知，并返回结果
+func (w *Write) afterDatabaseOperation() (types.M, error) {
+	err := w.createSessionTokenIfNeeded()
 	if err != nil {
 		return nil, err
 	}
@@ -174,7 +197,7 @@ func (w *Write) validateClientClassCreation() error {
 		}
 	}
 	// 允许操作已存在的表
-	schema := orm.TomatoDBController.LoadSchema(nil)
+	schema := w.database().LoadSchema(nil)
 	hasClass := schema.HasClass(w.className)
 	if hasClass {
 		return nil
@@ -185,7 +208,7 @@ func (w *Write) validateClientClassCreation() error {
 
 // validateSchema 校验数据与权限是否允许进行当前操作
 func (w *Write) validateSchema() error {
-	return orm.TomatoDBController.ValidateObject(w.className, w.data, w.query, w.RunOptions)
+	return w.database().ValidateObject(w.className, w.data, w.query, w.RunOptions)
 }
 
 // handleInstallation 处理 _Installation 表的操作
@@ -245,7 +268,7 @@ func (w *Write) handleInstallation() error {
 	}
 
 	// 查找跟提交的 objectId installationId deviceToken 相同的记录
-	results, err := orm.TomatoDBController.Find("_Installation", types.M{"$or": orQueries}, types.M{})
+	results, err := w.database().Find("_Installation", types.M{"$or": orQueries}, types.M{})
 	if err != nil {
 		return err
 	}
@@ -332,7 +355,7 @@ func (w *Write) handleInstallation() error {
 			if w.data["appIdentifier"] != nil {
 				delQuery["appIdentifier"] = w.data["appIdentifier"]
 			}
-			err := orm.TomatoDBController.Destroy("_Installation", delQuery, types.M{})
+			err := w.database().Destroy("_Installation", delQuery, types.M{})
 			if err != nil {
 				if errs.GetErrorCode(err) == errs.ObjectNotFound {
 
@@ -355,7 +378,7 @@ func (w *Write) handleInstallation() error {
 			delQuery := types.M{
 				"objectId": idMatch["objectId"],
 			}
-			err := orm.TomatoDBController.Destroy("_Installation", delQuery, nil)
+			err := w.database().Destroy("_Installation", delQuery, nil)
 			if err != nil {
 				if errs.GetErrorCode(err) == errs.ObjectNotFound {
 
@@ -389,7 +412,7 @@ func (w *Write) handleInstallation() error {
 					if w.data["appIdentifier"] != nil {
 						delQuery["appIdentifier"] = w.data["appIdentifier"]
 					}
-					err := orm.TomatoDBController.Destroy("_Installation", delQuery, nil)
+					err := w.database().Destroy("_Installation", delQuery, nil)
 					if err != nil {
 						if errs.GetErrorCode(err) == errs.ObjectNotFound {
 
@@ -404,9 +427,14 @@ func (w *Write) handleInstallation() error {
 	}
 	// objID 不为空时，转换当前请求为 update 请求
 	if objID != "" {
-		w.query = types.M{
+		query := types.M{
 			"objectId": objID,
 		}
+		// 保留 If-Match 对应的 updatedAt 条件
+		if w.query != nil && w.query["updatedAt"] != nil && utils.S(w.query["objectId"]) == objID {
+			query["updatedAt"] = w.query["updatedAt"]
+		}
+		w.query = query
 		delete(w.data, "objectId")
 		delete(w.data, "createdAt")
 	}
@@ -464,6 +492,7 @@ func (w *Write) handleSession() error {
 		if err != nil {
 			return err
 		}
+		write.db = w.db
 		results, err := write.Execute()
 		if err != nil {
 			return err
@@ -591,7 +620,7 @@ func (w *Write) handleAuthData(authData types.M) error {
 			w.response["response"] = userResult
 
 			// 更新数据库中的 authData 字段
-			_, err = orm.TomatoDBController.Update(w.className, types.M{"objectId": w.data["objectId"]}, types.M{"authData": mutatedAuthData}, types.M{}, false)
+			_, err = w.database().Update(w.className, types.M{"objectId": w.data["objectId"]}, types.M{"authData": mutatedAuthData}, types.M{}, false)
 			return err
 		} else if w.query != nil && w.query["objectId"] != nil {
 			// 存在一个用户，并且当前为 update 请求，校验 objectId 是否一致
@@ -647,7 +676,7 @@ func (w *Write) findUsersWithAuthData(authData types.M) (types.S, error) {
 			"$or": query,
 		}
 		var err error
-		findPromise, err = orm.TomatoDBController.Find(w.className, where, types.M{})
+		findPromise, err = w.database().Find(w.className, where, types.M{})
 		if err != nil {
 			return nil, err
 		}
@@ -682,7 +711,7 @@ func (w *Write) runBeforeTrigger() error {
 		updatedObject[k] = v
 	}
 
-	response, err := maybeRunTrigger(cloud.TypeBeforeSave, w.auth, updatedObject, originalObject)
+	response, err := maybeRunTrigger(w.database().Context(), cloud.TypeBeforeSave, w.auth, updatedObject, originalObject)
 	if err != nil {
 		return err
 	}
@@ -751,6 +780,7 @@ func (w *Write) transformUser() error {
 		if err != nil {
 			return err
 		}
+		query.db = w.db
 		response, err := query.Execute()
 		if err != nil {
 			return err
@@ -820,7 +850,7 @@ func (w *Write) validateUserName() error {
 	option := types.M{
 		"limit": 1,
 	}
-	results, err := orm.TomatoDBController.Find(w.className, where, option)
+	results, err := w.database().Find(w.className, where, option)
 	if err != nil {
 		return err
 	}
@@ -855,7 +885,7 @@ func (w *Write) validateEmail() error {
 	option := types.M{
 		"limit": 1,
 	}
-	results, err := orm.TomatoDBController.Find(w.className, where, option)
+	results, err := w.database().Find(w.className, where, option)
 	if err != nil {
 		return err
 	}
@@ -902,7 +932,7 @@ func (w *Write) validatePasswordRequirements() error {
 		} else {
 			// username 不存在时，从数据库中取出再去检测
 			query := types.M{"objectId": w.objectID()}
-			results, err := orm.TomatoDBController.Find("_User", query, types.M{})
+			results, err := w.database().Find("_User", query, types.M{})
 			if err != nil {
 				return err
 			}
@@ -929,7 +959,7 @@ func (w *Write) validatePasswordHistory() error {
 	options := types.M{
 		"keys": []string{"_password_history", "_hashed_password"},
 	}
-	results, err := orm.TomatoDBController.Find("_User", query, options)
+	results, err := w.database().Find("_User", query, options)
 	if err != nil {
 		return err
 	}
@@ -973,25 +1003,9 @@ func (w *Write) runDatabaseOperation() error {
 		return nil
 	}
 
-	if w.className == "_Role" {
-		cache.Role.Clear()
-	}
-
-	if w.className == "_User" && w.query != nil &&
-		w.auth.CouldUpdateUserID(utils.S(w.query["objectId"])) == false {
-		// 不能更新该用户，Master 可以更新任意用户，普通用户仅可更新自身
-		return errs.E(errs.SessionMissing, "cannot modify user "+utils.S(w.query["objectId"]))
-	}
-
-	if w.className == "_Product" && w.data["download"] != nil {
-		if download := utils.M(w.data["download"]); download != nil {
-			w.data["downloadName"] = download["name"]
-		}
-	}
-
-	// TODO 确保不要出现用户无法访问自身数据的情况
-	if acl := utils.M(w.data["ACL"]); acl != nil && acl["*unresolved"] != nil {
-		return errs.E(errs.InvalidACL, "Invalid ACL.")
+	err := w.validateDatabaseOperation()
+	if err != nil {
+		return err
 	}
 
 	if w.query != nil {
@@ -1019,7 +1033,7 @@ func (w *Write) runDatabaseOperation() error {
 			options := types.M{
 				"keys": []string{"_password_history", "_hashed_password"},
 			}
-			results, err := orm.TomatoDBController.Find("_User", query, options)
+			results, err := w.database().Find("_User", query, options)
 			if err != nil {
 				return err
 			}
@@ -1040,7 +1054,7 @@ func (w *Write) runDatabaseOperation() error {
 			w.data["_password_history"] = oldPasswords
 		}
 		// 执行更新
-		response, err := orm.TomatoDBController.Update(w.className, w.query, w.data, w.RunOptions, false)
+		response, err := w.database().Update(w.className, w.query, w.data, w.RunOptions, false)
 		if err != nil {
 			return err
 		}
@@ -1053,32 +1067,10 @@ func (w *Write) runDatabaseOperation() error {
 			"response": response,
 		}
 	} else {
-		// 给新用户设置默认 ACL ，密码过期时间戳
-		// TODO 为了用户信息安全性，应该禁止其他用户读取
-		if w.className == "_User" {
-			readwrite := types.M{
-				"read":  true,
-				"write": true,
-			}
-			onlyread := types.M{
-				"read": true,
-			}
-			acl := utils.M(w.data["ACL"])
-			if acl == nil {
-				acl = types.M{}
-				acl["*"] = onlyread
-			}
-			objectID := utils.S(w.data["objectId"])
-			acl[objectID] = readwrite
-			w.data["ACL"] = acl
-
-			if config.TConfig.PasswordPolicy && config.TConfig.MaxPasswordAge > 0 {
-				w.data["_password_changed_at"] = utils.TimetoString(time.Now().UTC())
-			}
-		}
+		w.prepareCreate()
 
 		// 创建对象
-		err := orm.TomatoDBController.Create(w.className, w.data, w.RunOptions)
+		err := w.database().Create(w.className, w.data, w.RunOptions)
 		if err != nil {
 			if w.className != "_User" {
 				return err
@@ -1092,7 +1084,7 @@ func (w *Write) runDatabaseOperation() error {
 					"username": w.data["username"],
 					"objectId": types.M{"$ne": w.objectID()},
 				}
-				results, err := orm.TomatoDBController.Find(w.className, where, types.M{"limit": 1})
+				results, err := w.database().Find(w.className, where, types.M{"limit": 1})
 				if err != nil {
 					return err
 				}
@@ -1106,7 +1098,7 @@ func (w *Write) runDatabaseOperation() error {
 					"email":    w.data["email"],
 					"objectId": types.M{"$ne": w.objectID()},
 				}
-				results, err := orm.TomatoDBController.Find(w.className, where, types.M{"limit": 1})
+				results, err := w.database().Find(w.className, where, types.M{"limit": 1})
 				if err != nil {
 					return err
 				}
@@ -1117,23 +1109,103 @@ func (w *Write) runDatabaseOperation() error {
 
 			return errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
 		}
-		response := types.M{
-			"objectId":  w.data["objectId"],
-			"createdAt": w.data["createdAt"],
+		w.setCreateResponse()
+	}
+
+	return w.recordHistory()
+}
+
+// validateDatabaseOperation 写入数据库之前的检查
+func (w *Write) validateDatabaseOperation() error {
+	if w.className == "_Role" {
+		cache.Role.Clear()
+	}
+
+	if w.className == "_User" && w.query != nil &&
+		w.auth.CouldUpdateUserID(utils.S(w.query["objectId"])) == false {
+		// 不能更新该用户，Master 可以更新任意用户，普通用户仅可更新自身
+		return errs.E(errs.SessionMissing, "cannot modify user "+utils.S(w.query["objectId"]))
+	}
+
+	if w.className == "_Product" && w.data["download"] != nil {
+		if download := utils.M(w.data["download"]); download != nil {
+			w.data["downloadName"] = download["name"]
 		}
-		if w.responseShouldHaveUsername {
-			response["username"] = w.data["username"]
+	}
+
+	// TODO 确保不要出现用户无法访问自身数据的情况
+	if acl := utils.M(w.data["ACL"]); acl != nil && acl["*unresolved"] != nil {
+		return errs.E(errs.InvalidACL, "Invalid ACL.")
+	}
+	return nil
+}
+
+// prepareCreate 创建对象之前，给新用户设置默认 ACL 与密码修改时间
+func (w *Write) prepareCreate() {
+	// 给新用户设置默认 ACL ，密码过期时间戳
+	// TODO 为了用户信息安全性，应该禁止其他用户读取
+	if w.className == "_User" {
+		readwrite := types.M{
+			"read":  true,
+			"write": true,
 		}
-		// 如果回调函数修改过数据，则将其复制到返回结果中
-		w.updateResponseWithData(response, w.data)
-		w.response = types.M{
-			"status":   201,
-			"response": response,
-			"location": w.location(),
+		onlyread := types.M{
+			"read": true,
+		}
+		acl := utils.M(w.data["ACL"])
+		if acl == nil {
+			acl = types.M{}
+			acl["*"] = onlyread
+		}
+		objectID := utils.S(w.data["objectId"])
+		acl[objectID] = readwrite
+		w.data["ACL"] = acl
+
+		if config.TConfig.PasswordPolicy && config.TConfig.MaxPasswordAge > 0 {
+			w.data["_password_changed_at"] = utils.TimetoString(time.Now().UTC())
 		}
 	}
+}
 
-	return nil
+// setCreateResponse 创建对象之后设置返回结果
+func (w *Write) setCreateResponse() {
+	response := types.M{
+		"objectId":  w.data["objectId"],
+		"createdAt": w.data["createdAt"],
+	}
+	if w.responseShouldHaveUsername {
+		response["username"] = w.data["username"]
+	}
+	// 如果回调函数修改过数据，则将其复制到返回结果中
+	w.updateResponseWithData(response, w.data)
+	w.response = types.M{
+		"status":   201,
+		"response": response,
+		"location": w.location(),
+	}
+}
+
+// recordHistory 开启修改历史的类中，记录本次新建或更新的数据以及修改前后的对象
+// 不在事务中时，记录失败不影响已经写入的数据，参考 historyError
+func (w *Write) recordHistory() error {
+	if historyEnabled(w.database(), w.className) == false {
+		return nil
+	}
+	objectID := utils.S(w.objectID())
+	after, err := currentObject(w.database(), w.className, objectID)
+	if err != nil {
+		return historyError(w.database(), w.className, objectID, err)
+	}
+	update := w.sanitizedData()
+	delete(update, "objectId")
+	delete(update, "createdAt")
+	delete(update, "updatedAt")
+	if w.query == nil {
+		err = recordHistory(w.database(), w.auth, w.className, objectID, HistoryCreate, update, nil, after)
+	} else {
+		err = recordHistory(w.database(), w.auth, w.className, objectID, HistoryUpdate, update, w.originalData, after)
+	}
+	return historyError(w.database(), w.className, objectID, err)
 }
 
 // createSessionTokenIfNeeded 创建 Token
@@ -1187,6 +1259,7 @@ func (w *Write) createSessionToken() error {
 	if err != nil {
 		return err
 	}
+	create.db = w.db
 	_, err = create.Execute()
 
 	return err
@@ -1205,7 +1278,7 @@ func (w *Write) handleFollowup() error {
 			"user": user,
 		}
 		delete(w.storage, "clearSessions")
-		err := orm.TomatoDBController.Destroy("_Session", sessionQuery, types.M{})
+		err := w.database().Destroy("_Session", sessionQuery, types.M{})
 		if err != nil {
 			return err
 		}
@@ -1259,15 +1332,18 @@ func (w *Write) runAfterTrigger() error {
 		updatedObject[k] = v
 	}
 
-	if hasLiveQuery {
-		// 尝试通知 LiveQueryServer
-		livequery.TLiveQuery.OnAfterSave(w.className, updatedObject, originalObject)
-	}
+	// 在事务中时，提交之后再通知与回调
+	afterCommit(w.database(), func() {
+		if hasLiveQuery {
+			// 尝试通知 LiveQueryServer
+			livequery.TLiveQuery.OnAfterSave(w.className, updatedObject, originalObject)
+		}
 
-	if hasAfterSaveHook {
-		// TODO 不等待回调返回
-		maybeRunTrigger(cloud.TypeAfterSave, w.auth, updatedObject, originalObject)
-	}
+		if hasAfterSaveHook {
+			// TODO 不等待回调返回
+			maybeRunTrigger(w.database().Context(), cloud.TypeAfterSave, w.auth, updatedObject, originalObject)
+		}
+	})
 
 	return nil
 }
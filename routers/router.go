@@ -92,6 +92,11 @@ func init() {
 				&controllers.PurgeController{},
 			),
 		),
+		beego.NSNamespace("/aggregate",
+			beego.NSInclude(
+				&controllers.AggregateController{},
+			),
+		),
 		beego.NSNamespace("/config",
 			beego.NSInclude(
 				&controllers.GlobalConfigController{},
@@ -112,6 +117,26 @@ func init() {
 				&controllers.FeaturesController{},
 			),
 		),
+		beego.NSNamespace("/export",
+			beego.NSInclude(
+				&controllers.ExportController{},
+			),
+		),
+		beego.NSNamespace("/import",
+			beego.NSInclude(
+				&controllers.ImportController{},
+			),
+		),
+		beego.NSNamespace("/migrations",
+			beego.NSInclude(
+				&controllers.MigrationsController{},
+			),
+		),
+		beego.NSNamespace("/trash",
+			beego.NSInclude(
+				&controllers.TrashController{},
+			),
+		),
 		beego.NSNamespace("/hooks",
 			beego.NSInclude(
 				&controllers.HooksController{},
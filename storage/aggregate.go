@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// 聚合管道由 orm 校验并规范化之后传入 Adapter.Aggregate ，格式如下：
+// [
+// 	{"$group": {"objectId": "$owner", "total": {"$sum": "$score"}, "count": {"$sum": 1}}},
+// 	{"$match": {"total": {"$gt": 10}}},
+// 	{"$project": {"objectId": "$objectId", "score": "$total"}},
+// 	{"$sort": ["-score", "objectId"]},
+// 	{"$skip": 10},
+// 	{"$limit": 10}
+// ]
+// 每个阶段只包含一个操作符，字段名均为 Parse 格式，以 $ 开头的字符串表示引用上一阶段的字段
+// 位于管道最前面的 $match 已合并到查询条件中，其余 $match 作用于 $group 或 $project 的输出，
+// 只包含 $eq $ne $gt $gte $lt $lte $in $nin 以及相等比较，比较的值均为简单类型
+// $group 中的 objectId 为分组依据，可以为 null 、字段引用，或者由字段引用组成的对象
+// $group 中的其他字段为累加器，支持 $sum $avg $min $max ，$sum 的参数可以是数字
+// $project 中的每个字段都对应一个字段引用，不包含在其中的字段不会输出
+// $sort 为字段数组，字段前加 - 表示降序
+
+// StageOperator 返回聚合阶段的操作符与参数
+func StageOperator(stage types.M) (string, interface{}) {
+	for op, v := range stage {
+		return op, v
+	}
+	return "", nil
+}
+
+// FieldReference 解析字段引用，v 不是字段引用时返回空字符串
+func FieldReference(v interface{}) string {
+	s, ok := v.(string)
+	if ok == false || strings.HasPrefix(s, "$") == false {
+		return ""
+	}
+	return s[1:]
+}
+
+// Accumulator 返回累加器的操作符与参数
+func Accumulator(v interface{}) (string, interface{}) {
+	return StageOperator(utils.M(v))
+}
+
+// GroupKeyFields 返回分组依据中各个字段对应的引用，分组依据为 null 或单个字段时返回 nil
+func GroupKeyFields(key interface{}) map[string]string {
+	object := utils.M(key)
+	if object == nil {
+		return nil
+	}
+	fields := map[string]string{}
+	for alias, v := range object {
+		fields[alias] = FieldReference(v)
+	}
+	return fields
+}
+
+// AggregateOutputFields 返回对象经过聚合阶段 stage 之后的字段类型， fields 为输入对象的字段类型
+// 类型未知的字段对应的值为 nil ，由对象组成的分组依据，其类型为 {"type": "Object", "fields": {...}}
+func AggregateOutputFields(fields types.M, stage types.M) types.M {
+	op, v := StageOperator(stage)
+	switch op {
+	case "$group":
+		group := utils.M(v)
+		result := types.M{}
+		if keyFields := GroupKeyFields(group["objectId"]); keyFields != nil {
+			subFields := types.M{}
+			for alias, field := range keyFields {
+				subFields[alias] = fields[field]
+			}
+			result["objectId"] = types.M{"type": "Object", "fields": subFields}
+		} else if field := FieldReference(group["objectId"]); field != "" {
+			result["objectId"] = fields[field]
+		}
+		for alias, accumulator := range group {
+			if alias == "objectId" {
+				continue
+			}
+			switch op, arg := Accumulator(accumulator); op {
+			case "$sum", "$avg":
+				result[alias] = types.M{"type": "Number"}
+			default:
+				result[alias] = fields[FieldReference(arg)]
+			}
+		}
+		return result
+	case "$project":
+		result := types.M{}
+		for alias, ref := range utils.M(v) {
+			result[alias] = fields[FieldReference(ref)]
+		}
+		return result
+	}
+	return fields
+}
+
+// PipelineTransformsObjects 管道中是否包含改变对象结构的 $group 或 $project
+func PipelineTransformsObjects(pipeline types.S) bool {
+	for _, v := range pipeline {
+		switch op, _ := StageOperator(utils.M(v)); op {
+		case "$group", "$project":
+			return true
+		}
+	}
+	return false
+}
+
+// SortKeys 解析 $sort 阶段中的字段
+func SortKeys(v interface{}) []string {
+	if keys, ok := v.([]string); ok {
+		return keys
+	}
+	keys := []string{}
+	for _, key := range utils.A(v) {
+		if s := utils.S(key); s != "" {
+			keys = append(keys, s)
+		}
+	}
+	return keys
+}
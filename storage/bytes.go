@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"encoding/base64"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// Bytes 类型的格式如下， base64 为标准 Base64 编码的二进制数据：
+// {
+// 	"__type": "Bytes",
+// 	"base64": "aGVsbG8="
+// }
+// 数据库中保存为二进制数据，查询时转换为 Bytes 类型返回
+
+// IsBytes 是否为 Bytes 类型
+func IsBytes(value interface{}) bool {
+	object := utils.M(value)
+	return object != nil && utils.S(object["__type"]) == "Bytes"
+}
+
+// ParseBytes 解析 Bytes 类型，返回解码后的数据
+func ParseBytes(value interface{}) ([]byte, error) {
+	if IsBytes(value) == false {
+		return nil, errs.E(errs.InvalidJSON, "bad Bytes value")
+	}
+	data, err := base64.StdEncoding.DecodeString(utils.S(utils.M(value)["base64"]))
+	if err != nil {
+		return nil, errs.E(errs.InvalidJSON, "invalid base64")
+	}
+	return data, nil
+}
+
+// BytesToJSON 把二进制数据转换为 Bytes 类型
+func BytesToJSON(data []byte) types.M {
+	return types.M{
+		"__type": "Bytes",
+		"base64": base64.StdEncoding.EncodeToString(data),
+	}
+}
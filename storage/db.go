@@ -50,3 +50,17 @@ func OpenPostgreSQL() *sql.DB {
 	}
 	return db
 }
+
+// OpenSQLite 打开 SQLite ，DatabaseURI 为数据库文件路径
+// 驱动由 storage/sqlite 注册，名称与 sqlite.DriverName 一致
+func OpenSQLite() *sql.DB {
+	db, err := sql.Open("sqlite3_tomato", config.TConfig.DatabaseURI)
+	if err != nil {
+		panic(err)
+	}
+	if config.TConfig.DatabaseURI == ":memory:" {
+		// 内存数据库在每个连接中都是独立的，只能使用一个连接
+		db.SetMaxOpenConns(1)
+	}
+	return db
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"math"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// Polygon 类型的格式如下，顶点为 [latitude, longitude] ，至少包含 3 个不同的顶点：
+// {
+// 	"__type": "Polygon",
+// 	"coordinates": [[0, 0], [0, 10], [10, 10], [10, 0]]
+// }
+// 保存时首尾顶点不同会自动添加第一个顶点使多边形闭合，查询时返回闭合后的顶点
+// 查询条件中支持：
+// {"area": {"$geoIntersects": {"$point": {"__type": "GeoPoint", "latitude": 5, "longitude": 5}}}} 查找包含该点的多边形
+// {"location": {"$geoWithin": {"$polygon": {"__type": "Polygon", "coordinates": [...]}}}} 查找多边形内的 GeoPoint
+
+// Vertex 多边形的顶点， [longitude, latitude]
+type Vertex [2]float64
+
+// ParsePolygon 解析 Polygon 类型，返回闭合的顶点数组
+func ParsePolygon(value interface{}) ([]Vertex, error) {
+	polygon := utils.M(value)
+	if polygon == nil || utils.S(polygon["__type"]) != "Polygon" {
+		return nil, errs.E(errs.InvalidJSON, "bad Polygon value")
+	}
+	coordinates := utils.A(polygon["coordinates"])
+	if len(coordinates) < 3 {
+		return nil, errs.E(errs.InvalidJSON, "Polygon must have at least 3 coordinates")
+	}
+	vertices := []Vertex{}
+	for _, c := range coordinates {
+		pair := utils.A(c)
+		if len(pair) != 2 {
+			return nil, errs.E(errs.InvalidJSON, "bad Polygon coordinates")
+		}
+		latitude, ok1 := geoFloat(pair[0])
+		longitude, ok2 := geoFloat(pair[1])
+		if ok1 == false || ok2 == false || latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+			return nil, errs.E(errs.InvalidJSON, "bad Polygon coordinates")
+		}
+		vertices = append(vertices, Vertex{longitude, latitude})
+	}
+	if vertices[0] != vertices[len(vertices)-1] {
+		vertices = append(vertices, vertices[0])
+	}
+	unique := map[Vertex]bool{}
+	for _, v := range vertices {
+		unique[v] = true
+	}
+	if len(unique) < 3 {
+		return nil, errs.E(errs.InvalidJSON, "Polygon must have at least 3 different vertices")
+	}
+	return vertices, nil
+}
+
+// PolygonToJSON 把顶点数组转换为 Polygon 类型
+func PolygonToJSON(vertices []Vertex) types.M {
+	coordinates := types.S{}
+	for _, v := range vertices {
+		coordinates = append(coordinates, types.S{v[1], v[0]})
+	}
+	return types.M{
+		"__type":      "Polygon",
+		"coordinates": coordinates,
+	}
+}
+
+// PolygonContains 点是否在多边形内，在边上时也认为包含
+func PolygonContains(vertices []Vertex, longitude, latitude float64) bool {
+	inside := false
+	for i, j := 0, len(vertices)-1; i < len(vertices); j, i = i, i+1 {
+		xi, yi := vertices[i][0], vertices[i][1]
+		xj, yj := vertices[j][0], vertices[j][1]
+		// 点在边上
+		cross := (xj-xi)*(latitude-yi) - (yj-yi)*(longitude-xi)
+		if cross == 0 && longitude >= math.Min(xi, xj) && longitude <= math.Max(xi, xj) && latitude >= math.Min(yi, yj) && latitude <= math.Max(yi, yj) {
+			return true
+		}
+		if (yi > latitude) != (yj > latitude) && longitude < (xj-xi)*(latitude-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// GeoPointOf 获取 GeoPoint 的经纬度
+func GeoPointOf(value interface{}) (float64, float64, bool) {
+	point := utils.M(value)
+	if point == nil || utils.S(point["__type"]) != "GeoPoint" {
+		return 0, 0, false
+	}
+	longitude, ok1 := geoFloat(point["longitude"])
+	latitude, ok2 := geoFloat(point["latitude"])
+	return longitude, latitude, ok1 && ok2
+}
+
+func geoFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
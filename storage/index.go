@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// 索引定义保存在 _SCHEMA 中，格式如下：
+// {
+// 	"keys": ["name", "-age"],
+// 	"sparse": true,
+// 	"expireAfterSeconds": 3600,
+// 	"text": true,
+// 	"language": "english"
+// }
+// keys 为必填项，字段前加 - 表示降序，其他选项均为可选
+// sparse 表示只为存在索引字段的对象建立索引
+// expireAfterSeconds 表示 TTL 索引，对象在该字段的时间之后指定秒数过期
+// text 表示全文索引， language 为全文索引的默认语言
+
+// IndexField 索引中的字段
+type IndexField struct {
+	Name       string
+	Descending bool
+}
+
+// IndexFields 解析索引定义中的字段
+func IndexFields(index types.M) []IndexField {
+	fields := []IndexField{}
+	for _, v := range utils.A(index["keys"]) {
+		key := utils.S(v)
+		if key == "" {
+			continue
+		}
+		if strings.HasPrefix(key, "-") {
+			fields = append(fields, IndexField{Name: key[1:], Descending: true})
+		} else {
+			fields = append(fields, IndexField{Name: key})
+		}
+	}
+	return fields
+}
+
+// IndexIsText 是否为全文索引
+func IndexIsText(index types.M) bool {
+	text, ok := index["text"].(bool)
+	return ok && text
+}
+
+// IndexIsSparse 是否为稀疏索引
+func IndexIsSparse(index types.M) bool {
+	sparse, ok := index["sparse"].(bool)
+	return ok && sparse
+}
+
+// IndexExpireAfterSeconds 返回 TTL 索引的过期时间，不是 TTL 索引时返回 -1
+func IndexExpireAfterSeconds(index types.M) int {
+	switch v := index["expireAfterSeconds"].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return -1
+}
+
+// IndexLanguage 返回全文索引的默认语言
+func IndexLanguage(index types.M) string {
+	if language := utils.S(index["language"]); language != "" {
+		return language
+	}
+	return "english"
+}
@@ -1,26 +1,96 @@
 package storage
 
-import "github.com/lfq7413/tomato/types"
+import (
+	"context"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/types"
+)
 
 // Adapter 数据库操作适配器接口
 type Adapter interface {
 	ClassExists(name string) bool
 	SetClassLevelPermissions(className string, CLPs types.M) error
+	SetClassOptions(className string, options types.M) error
 	CreateClass(className string, schema types.M) (types.M, error)
 	AddFieldIfNotExists(className, fieldName string, fieldType types.M) error
 	DeleteClass(className string) (types.M, error)
 	DeleteAllClasses() error
 	DeleteFields(className string, schema types.M, fieldNames []string) error
 	CreateObject(className string, schema, object types.M) error
+	CreateObjects(className string, schema types.M, objects []types.M) error
 	GetAllClasses() ([]types.M, error)
 	GetClass(className string) (types.M, error)
 	DeleteObjectsByQuery(className string, schema, query types.M) error
 	Find(className string, schema, query, options types.M) ([]types.M, error)
+	Explain(className string, schema, query, options types.M) (types.M, error)
 	Count(className string, schema, query types.M) (int, error)
 	UpdateObjectsByQuery(className string, schema, query, update types.M) error
 	FindOneAndUpdate(className string, schema, query, update types.M) (types.M, error)
 	UpsertOneObject(className string, schema, query, update types.M) error
 	EnsureUniqueness(className string, schema types.M, fieldNames []string) error
+	CreateIndex(className string, schema types.M, indexName string, index types.M) error
+	DropIndex(className string, indexName string) error
+	GetIndexes(className string) (types.M, error)
+	Aggregate(className string, schema, query types.M, pipeline types.S) ([]types.M, error)
+	Distinct(className string, schema, query types.M, fieldName string) (types.S, error)
 	PerformInitialization(options types.M) error
 	HandleShutdown()
+	Begin() (Transaction, error)
+	WithContext(ctx context.Context) Adapter
+}
+
+// TransactionSupport 不支持事务的适配器实现该接口并返回 false ，此时 Begin 总是返回错误
+// 调用方可以在执行任何操作之前判断是否支持事务，未实现该接口的适配器视为支持事务
+type TransactionSupport interface {
+	SupportsTransactions() bool
+}
+
+// ErrNotSupportedInTransaction 事务中执行不支持的操作时返回的错误
+var ErrNotSupportedInTransaction = errs.E(errs.OperationForbidden, "This operation is not supported in a transaction.")
+
+// ErrBulkCreateIncomplete CreateObjects 出错后无法确定哪些对象已经写入时返回的错误
+// CreateObjects 出错时要么没有写入任何对象，要么返回该错误
+var ErrBulkCreateIncomplete = errs.E(errs.InternalServerError, "Bulk create failed and some objects may have been created.")
+
+// Transaction 数据库事务，事务中的操作在 Commit 之后生效， Rollback 时全部撤销
+type Transaction interface {
+	Adapter
+	Commit() error
+	Rollback() error
+}
+
+// SortNullsFirst 查询选项，为 true 时排序中的空值视为比任何值都小，游标分页依赖该顺序
+const SortNullsFirst = "sortNullsFirst"
+
+// ChangeSave ChangeDelete 对象变更的类型
+const (
+	ChangeSave   = "save"
+	ChangeDelete = "delete"
+)
+
+// ChangeEvent 数据库中对象的一次变更，对象格式与 Find 的返回结果相同
+// Object 为变更后的对象，删除时为被删除的对象
+// Original 为变更前的对象，新建对象或者无法获取时为空
+// Position 为该变更在变更流中的位置
+type ChangeEvent struct {
+	ClassName string
+	Type      string
+	Object    types.M
+	Original  types.M
+	Position  string
+}
+
+// TTLIndexer 支持通过 TTL 索引自动删除过期对象的适配器，当前 MongoDB 支持
+type TTLIndexer interface {
+	// SupportsTTLIndex 带有 expireAfterSeconds 的索引是否由数据库自动删除过期对象
+	SupportsTTLIndex() bool
+}
+
+// ChangeFeed 支持捕获数据库变更的适配器，当前 MongoDB 与 PostgreSQL 支持
+type ChangeFeed interface {
+	// WatchChanges 监听 classNames 中对象的变更，每个变更调用一次 handler ，直接修改数据库的变更同样可以捕获
+	// name 为监听者名称，handler 处理成功后保存该监听者的位置，再次监听时从保存的位置继续，首次监听时从当前位置开始
+	// ctx 取消后返回 nil ，出现错误时返回错误，可以再次调用以恢复监听
+	WatchChanges(ctx context.Context, name string, classNames []string, handler func(*ChangeEvent) error) error
 }
@@ -0,0 +1,761 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// MemoryAdapter 内存数据库适配器，数据仅保存在当前进程中，主要用于测试
+// 所有操作都由读写锁保护，可以在多个 goroutine 中同时使用
+type MemoryAdapter struct {
+	collectionPrefix string
+	*memoryData
+	journal *journal        // 不为空时表示处于事务中
+	ctx     context.Context // 不为空时，在 ctx 取消或超时后不再执行新的操作
+}
+
+// memoryData 保存的数据，事务与创建事务的适配器共用同一份数据
+type memoryData struct {
+	mu          sync.RWMutex
+	schemas     map[string]types.M
+	collections map[string][]types.M
+	indexes     map[string][][]string
+}
+
+// NewMemoryAdapter ...
+func NewMemoryAdapter(collectionPrefix string) *MemoryAdapter {
+	return &MemoryAdapter{
+		collectionPrefix: collectionPrefix,
+		memoryData: &memoryData{
+			schemas:     map[string]types.M{},
+			collections: map[string][]types.M{},
+			indexes:     map[string][][]string{},
+		},
+	}
+}
+
+// ClassExists 检测类是否存在
+func (m *MemoryAdapter) ClassExists(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.collections[name]
+	return ok
+}
+
+// SetClassLevelPermissions 设置类级别权限
+func (m *MemoryAdapter) SetClassLevelPermissions(className string, CLPs types.M) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if schema, ok := m.schemas[className]; ok {
+		m.journal.recordSchema(m.memoryData, className)
+		schema["classLevelPermissions"] = utils.CopyMapM(CLPs)
+	}
+	return nil
+}
+
+// SetClassOptions 设置类的选项
+func (m *MemoryAdapter) SetClassOptions(className string, options types.M) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if schema, ok := m.schemas[className]; ok {
+		m.journal.recordSchema(m.memoryData, className)
+		schema["options"] = utils.CopyMapM(options)
+	}
+	return nil
+}
+
+// CreateClass 创建类
+func (m *MemoryAdapter) CreateClass(className string, schema types.M) (types.M, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.schemas[className]; ok {
+		return nil, errs.E(errs.DuplicateValue, "Class "+className+" already exists.")
+	}
+	if schema == nil {
+		schema = types.M{}
+	}
+
+	fields := types.M{}
+	if f := utils.M(schema["fields"]); f != nil {
+		fields = utils.CopyMap(f)
+	}
+	var clps types.M
+	if c := utils.M(schema["classLevelPermissions"]); c != nil {
+		clps = utils.CopyMap(c)
+	}
+	m.journal.recordSchema(m.memoryData, className)
+	m.schemas[className] = types.M{
+		"className":             className,
+		"fields":                fields,
+		"classLevelPermissions": clps,
+	}
+	if indexes := utils.M(schema["indexes"]); len(indexes) > 0 {
+		m.schemas[className]["indexes"] = utils.CopyMapM(indexes)
+	}
+	if _, ok := m.collections[className]; ok == false {
+		m.collections[className] = []types.M{}
+	}
+
+	return toParseSchema(m.schemas[className]), nil
+}
+
+// AddFieldIfNotExists 添加字段定义，类不存在时创建类
+func (m *MemoryAdapter) AddFieldIfNotExists(className, fieldName string, fieldType types.M) error {
+	m.mu.Lock()
+	schema, ok := m.schemas[className]
+	m.mu.Unlock()
+	if ok == false {
+		_, err := m.CreateClass(className, types.M{
+			"fields": types.M{fieldName: fieldType},
+		})
+		if err != nil && errs.GetErrorCode(err) != errs.DuplicateValue {
+			return err
+		}
+		if err == nil {
+			return nil
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	schema = m.schemas[className]
+	fields := utils.M(schema["fields"])
+	if _, ok := fields[fieldName]; ok == false {
+		m.journal.recordSchema(m.memoryData, className)
+		fields[fieldName] = utils.CopyMapM(fieldType)
+	}
+	return nil
+}
+
+// DeleteClass 删除类以及其中的数据
+func (m *MemoryAdapter) DeleteClass(className string) (types.M, error) {
+	if m.journal != nil {
+		return nil, storage.ErrNotSupportedInTransaction
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	schema := m.schemas[className]
+	delete(m.schemas, className)
+	delete(m.collections, className)
+	delete(m.indexes, className)
+	if schema == nil {
+		return types.M{}, nil
+	}
+	return toParseSchema(schema), nil
+}
+
+// DeleteAllClasses 删除所有类，仅用于测试
+func (m *MemoryAdapter) DeleteAllClasses() error {
+	if m.journal != nil {
+		return storage.ErrNotSupportedInTransaction
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.schemas = map[string]types.M{}
+	m.collections = map[string][]types.M{}
+	m.indexes = map[string][][]string{}
+	return nil
+}
+
+// DeleteFields 删除字段定义以及对象中对应的数据
+func (m *MemoryAdapter) DeleteFields(className string, schema types.M, fieldNames []string) error {
+	if m.journal != nil {
+		return storage.ErrNotSupportedInTransaction
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.schemas[className]; s != nil {
+		fields := utils.M(s["fields"])
+		for _, fieldName := range fieldNames {
+			delete(fields, fieldName)
+		}
+	}
+	for _, object := range m.collections[className] {
+		for _, fieldName := range fieldNames {
+			delete(object, fieldName)
+		}
+	}
+	return nil
+}
+
+// CreateObject 创建对象
+func (m *MemoryAdapter) CreateObject(className string, schema, object types.M) error {
+	if err := m.contextErr(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o := types.M{}
+	err := applyUpdate(o, object)
+	if err != nil {
+		return err
+	}
+	objects := append(m.collections[className], o)
+	if m.hasDuplicates(className, objects) {
+		return errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
+	}
+	m.journal.recordCollection(m.memoryData, className)
+	m.journal.recordObject(className, o, nil)
+	m.collections[className] = objects
+	return nil
+}
+
+// CreateObjects 批量创建对象，任意一个对象出错时不创建任何对象
+func (m *MemoryAdapter) CreateObjects(className string, schema types.M, objects []types.M) error {
+	if err := m.contextErr(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	created := []types.M{}
+	for _, object := range objects {
+		o := types.M{}
+		err := applyUpdate(o, object)
+		if err != nil {
+			return err
+		}
+		created = append(created, o)
+	}
+	if len(created) == 0 {
+		return nil
+	}
+	all := append(append([]types.M{}, m.collections[className]...), created...)
+	if m.hasDuplicates(className, all) {
+		return errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
+	}
+	m.journal.recordCollection(m.memoryData, className)
+	for _, o := range created {
+		m.journal.recordObject(className, o, nil)
+	}
+	m.collections[className] = all
+	return nil
+}
+
+// GetAllClasses 获取所有类的定义
+func (m *MemoryAdapter) GetAllClasses() ([]types.M, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := []string{}
+	for name := range m.schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	schemas := []types.M{}
+	for _, name := range names {
+		schemas = append(schemas, toParseSchema(m.schemas[name]))
+	}
+	return schemas, nil
+}
+
+// GetClass 获取类的定义，类不存在时返回空对象
+func (m *MemoryAdapter) GetClass(className string) (types.M, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	schema := m.schemas[className]
+	if schema == nil {
+		return types.M{}, nil
+	}
+	return toParseSchema(schema), nil
+}
+
+// DeleteObjectsByQuery 删除符合条件的所有对象
+func (m *MemoryAdapter) DeleteObjectsByQuery(className string, schema, query types.M) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	objects := []types.M{}
+	for _, object := range m.collections[className] {
+		ok, err := matchesQuery(object, query)
+		if err != nil {
+			return err
+		}
+		if ok == false {
+			objects = append(objects, object)
+		} else {
+			m.journal.recordObject(className, object, object)
+		}
+	}
+	if len(objects) == len(m.collections[className]) {
+		return errs.E(errs.ObjectNotFound, "Object not found.")
+	}
+	m.collections[className] = objects
+	return nil
+}
+
+// Find 查找对象，支持 sort skip limit keys 选项
+func (m *MemoryAdapter) Find(className string, schema, query, options types.M) ([]types.M, error) {
+	if options == nil {
+		options = types.M{}
+	}
+	m.mu.RLock()
+	objects, _, err := m.findMatches(className, query)
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// 全文检索的相关度临时保存在对象的 $score 字段中，用于排序以及返回
+	if storage.HasTextSearch(query) {
+		for _, object := range objects {
+			object[storage.TextScoreKey] = textScoreOf(object, query)
+		}
+	}
+
+	if key, point := nearSphereOf(query); point != nil {
+		sortByDistance(objects, key, point)
+	} else if keys, ok := options["sort"].([]string); ok && len(keys) > 0 {
+		sortKeys := []string{}
+		for _, key := range keys {
+			if strings.TrimPrefix(key, "-") == storage.TextScoreKey {
+				if storage.HasTextSearch(query) == false {
+					return nil, errs.E(errs.InvalidQuery, "$score can only be used with $text queries.")
+				}
+				// 按相关度排序时总是由高到低
+				key = "-" + storage.TextScoreKey
+			}
+			sortKeys = append(sortKeys, key)
+		}
+		sortObjects(objects, sortKeys)
+	}
+
+	if skip, ok := toFloat(options["skip"]); ok {
+		if int(skip) >= len(objects) {
+			objects = []types.M{}
+		} else if skip > 0 {
+			objects = objects[int(skip):]
+		}
+	}
+	if limit, ok := toFloat(options["limit"]); ok && int(limit) < len(objects) {
+		objects = objects[:int(limit)]
+	}
+
+	var keys []string
+	selectScore := false
+	if k, ok := options["keys"].([]string); ok {
+		for _, key := range k {
+			if key == storage.TextScoreKey {
+				if storage.HasTextSearch(query) == false {
+					return nil, errs.E(errs.InvalidQuery, "$score can only be used with $text queries.")
+				}
+				selectScore = true
+			}
+			if key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	results := []types.M{}
+	for _, object := range objects {
+		result := memoryObjectToParseObject(object, schema, keys)
+		if score, ok := result[storage.TextScoreKey]; ok {
+			delete(result, storage.TextScoreKey)
+			if selectScore {
+				result[storage.TextScoreField] = score
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Explain 返回查询条件以及执行计划，内存数据库总是遍历集合中的所有对象
+func (m *MemoryAdapter) Explain(className string, schema, query, options types.M) (types.M, error) {
+	m.mu.RLock()
+	examined := len(m.collections[className])
+	m.mu.RUnlock()
+	results, err := m.Find(className, schema, query, options)
+	if err != nil {
+		return nil, err
+	}
+	return types.M{
+		"query": query,
+		"plan": types.M{
+			"stage":    "COLLSCAN",
+			"examined": examined,
+			"returned": len(results),
+		},
+	}, nil
+}
+
+// Count 统计符合条件的对象数量
+func (m *MemoryAdapter) Count(className string, schema, query types.M) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	objects, _, err := m.findMatches(className, query)
+	if err != nil {
+		return 0, err
+	}
+	return len(objects), nil
+}
+
+// UpdateObjectsByQuery 更新符合条件的所有对象
+func (m *MemoryAdapter) UpdateObjectsByQuery(className string, schema, query, update types.M) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, indexes, err := m.findMatches(className, query)
+	if err != nil {
+		return err
+	}
+	_, err = m.updateObjects(className, indexes, update)
+	return err
+}
+
+// FindOneAndUpdate 更新符合条件的第一个对象，并返回更新后的对象
+func (m *MemoryAdapter) FindOneAndUpdate(className string, schema, query, update types.M) (types.M, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, indexes, err := m.findMatches(className, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes) == 0 {
+		return types.M{}, nil
+	}
+	updated, err := m.updateObjects(className, indexes[:1], update)
+	if err != nil {
+		return nil, err
+	}
+	return memoryObjectToParseObject(updated[0], schema, nil), nil
+}
+
+// UpsertOneObject 更新符合条件的第一个对象，不存在时使用查询条件与更新数据创建新对象
+func (m *MemoryAdapter) UpsertOneObject(className string, schema, query, update types.M) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, indexes, err := m.findMatches(className, query)
+	if err != nil {
+		return err
+	}
+	if len(indexes) > 0 {
+		_, err = m.updateObjects(className, indexes[:1], update)
+		return err
+	}
+
+	object := types.M{}
+	for k, v := range query {
+		if strings.HasPrefix(k, "$") {
+			continue
+		}
+		if c := utils.M(v); c != nil && isOperatorMap(c) {
+			continue
+		}
+		object[k] = utils.DeepCopy(v)
+	}
+	err = applyUpdate(object, update)
+	if err != nil {
+		return err
+	}
+	objects := append(m.collections[className], object)
+	if m.hasDuplicates(className, objects) {
+		return errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
+	}
+	m.journal.recordCollection(m.memoryData, className)
+	m.journal.recordObject(className, object, nil)
+	m.collections[className] = objects
+	return nil
+}
+
+// EnsureUniqueness 为指定字段添加唯一索引，已有数据存在重复时返回错误
+func (m *MemoryAdapter) EnsureUniqueness(className string, schema types.M, fieldNames []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.collections[className]; ok == false {
+		m.collections[className] = []types.M{}
+	}
+	for _, index := range m.indexes[className] {
+		if strings.Join(index, ",") == strings.Join(fieldNames, ",") {
+			return nil
+		}
+	}
+	if uniqueViolation(m.collections[className], fieldNames) {
+		return errs.E(errs.DuplicateValue, "Tried to ensure field uniqueness for a class that already has duplicates.")
+	}
+	m.indexes[className] = append(m.indexes[className], append([]string{}, fieldNames...))
+	return nil
+}
+
+// CreateIndex 保存索引定义，内存数据库中不建立实际的索引
+func (m *MemoryAdapter) CreateIndex(className string, schema types.M, indexName string, index types.M) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.schemas[className]
+	if ok == false {
+		return errs.E(errs.InvalidClassName, "Class "+className+" does not exist.")
+	}
+	indexes := utils.M(stored["indexes"])
+	if _, ok := indexes[indexName]; ok {
+		return errs.E(errs.DuplicateValue, "Index "+indexName+" already exists.")
+	}
+	m.journal.recordSchema(m.memoryData, className)
+	if indexes == nil {
+		indexes = types.M{}
+		stored["indexes"] = indexes
+	}
+	indexes[indexName] = utils.CopyMapM(index)
+	return nil
+}
+
+// DropIndex 删除索引定义
+func (m *MemoryAdapter) DropIndex(className string, indexName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.schemas[className]
+	if ok == false {
+		return errs.E(errs.InvalidClassName, "Class "+className+" does not exist.")
+	}
+	if indexes := utils.M(stored["indexes"]); indexes != nil {
+		m.journal.recordSchema(m.memoryData, className)
+		delete(indexes, indexName)
+	}
+	return nil
+}
+
+// GetIndexes 获取索引定义
+func (m *MemoryAdapter) GetIndexes(className string) (types.M, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	indexes := utils.M(m.schemas[className]["indexes"])
+	if indexes == nil {
+		return types.M{}, nil
+	}
+	return utils.CopyMapM(indexes), nil
+}
+
+// PerformInitialization 创建易变类对应的数据集合
+func (m *MemoryAdapter) PerformInitialization(options types.M) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if options == nil {
+		return nil
+	}
+	if volatileClassesSchemas, ok := options["VolatileClassesSchemas"].([]types.M); ok {
+		for _, schema := range volatileClassesSchemas {
+			className := utils.S(schema["className"])
+			if _, ok := m.collections[className]; ok == false {
+				m.collections[className] = []types.M{}
+			}
+		}
+	}
+	return nil
+}
+
+// HandleShutdown 内存数据库无需关闭
+func (m *MemoryAdapter) HandleShutdown() {}
+
+// Begin 开始事务，事务中的写操作直接作用于数据，并记录被修改数据的原始值，回滚时进行恢复
+// 事务之间没有隔离，其他请求可以读取到事务中尚未提交的数据
+func (m *MemoryAdapter) Begin() (storage.Transaction, error) {
+	if m.journal != nil {
+		return nil, errs.E(errs.OperationForbidden, "Transaction already started.")
+	}
+	return &MemoryAdapter{
+		collectionPrefix: m.collectionPrefix,
+		memoryData:       m.memoryData,
+		journal:          newJournal(),
+		ctx:              m.ctx,
+	}, nil
+}
+
+// WithContext 返回在 ctx 中执行操作的适配器，与当前适配器共用数据与事务
+func (m *MemoryAdapter) WithContext(ctx context.Context) storage.Adapter {
+	adapter := *m
+	adapter.ctx = ctx
+	return &adapter
+}
+
+// contextErr 返回 ctx 被取消或超时的原因
+func (m *MemoryAdapter) contextErr() error {
+	if m.ctx == nil {
+		return nil
+	}
+	return m.ctx.Err()
+}
+
+// Commit 提交事务
+func (m *MemoryAdapter) Commit() error {
+	if m.journal == nil {
+		return errs.E(errs.OperationForbidden, "Transaction not started.")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.journal = nil
+	return nil
+}
+
+// Rollback 回滚事务，恢复事务中修改过的类定义与对象
+func (m *MemoryAdapter) Rollback() error {
+	if m.journal == nil {
+		return errs.E(errs.OperationForbidden, "Transaction not started.")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.journal.restore(m.memoryData)
+	m.journal = nil
+	return nil
+}
+
+// findMatches 查找符合条件的对象，返回对象副本及其在集合中的位置，调用前需要加锁
+func (m *MemoryAdapter) findMatches(className string, query types.M) ([]types.M, []int, error) {
+	if err := m.contextErr(); err != nil {
+		return nil, nil, err
+	}
+	objects := []types.M{}
+	indexes := []int{}
+	for i, object := range m.collections[className] {
+		ok, err := matchesQuery(object, query)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			objects = append(objects, utils.CopyMapM(object))
+			indexes = append(indexes, i)
+		}
+	}
+	return objects, indexes, nil
+}
+
+// updateObjects 更新集合中指定位置的对象，违反唯一索引时不做任何修改，调用前需要加锁
+func (m *MemoryAdapter) updateObjects(className string, indexes []int, update types.M) ([]types.M, error) {
+	collection := m.collections[className]
+	objects := make([]types.M, len(collection))
+	copy(objects, collection)
+	updated := []types.M{}
+	for _, i := range indexes {
+		object := utils.CopyMapM(collection[i])
+		err := applyUpdate(object, update)
+		if err != nil {
+			return nil, err
+		}
+		objects[i] = object
+		updated = append(updated, object)
+	}
+	if m.hasDuplicates(className, objects) {
+		return nil, errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
+	}
+	for _, i := range indexes {
+		m.journal.recordObject(className, collection[i], collection[i])
+	}
+	m.collections[className] = objects
+	return updated, nil
+}
+
+// hasDuplicates 检测对象集合是否违反 objectId 以及唯一索引的约束
+func (m *MemoryAdapter) hasDuplicates(className string, objects []types.M) bool {
+	if uniqueViolation(objects, []string{"objectId"}) {
+		return true
+	}
+	for _, index := range m.indexes[className] {
+		if uniqueViolation(objects, index) {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueViolation 检测对象集合中 fieldNames 对应的值是否有重复，缺少任意字段的对象不参与比较
+func uniqueViolation(objects []types.M, fieldNames []string) bool {
+	seen := map[string]bool{}
+	for _, object := range objects {
+		values := []interface{}{}
+		for _, fieldName := range fieldNames {
+			v, ok := getValue(object, fieldName)
+			if ok == false || v == nil {
+				values = nil
+				break
+			}
+			values = append(values, normalize(v))
+		}
+		if values == nil {
+			continue
+		}
+		key := marshal(values)
+		if seen[key] {
+			return true
+		}
+		seen[key] = true
+	}
+	return false
+}
+
+// memoryObjectToParseObject 把保存的对象转换为 Parse 格式，keys 不为空时只返回指定的字段
+func memoryObjectToParseObject(object, schema types.M, keys []string) types.M {
+	result := types.M{}
+	if len(keys) > 0 {
+		for _, key := range keys {
+			if v, ok := object[key]; ok {
+				result[key] = utils.DeepCopy(v)
+			}
+		}
+	} else {
+		result = utils.CopyMapM(object)
+	}
+
+	if fields := utils.M(schema["fields"]); fields != nil {
+		for fieldName, v := range fields {
+			if tp := utils.M(v); tp != nil && utils.S(tp["type"]) == "Relation" {
+				result[fieldName] = types.M{
+					"__type":    "Relation",
+					"className": tp["targetClass"],
+				}
+			}
+		}
+	}
+	return result
+}
+
+var defaultCLPS = types.M{
+	"find":     types.M{"*": true},
+	"get":      types.M{"*": true},
+	"create":   types.M{"*": true},
+	"update":   types.M{"*": true},
+	"delete":   types.M{"*": true},
+	"addField": types.M{"*": true},
+}
+
+func toParseSchema(schema types.M) types.M {
+	if schema == nil {
+		return nil
+	}
+
+	var fields types.M
+	if fields = utils.M(schema["fields"]); fields == nil {
+		fields = types.M{}
+	}
+	fields = utils.CopyMap(fields)
+
+	if utils.S(schema["className"]) == "_User" {
+		delete(fields, "_hashed_password")
+	}
+
+	delete(fields, "_wperm")
+	delete(fields, "_rperm")
+
+	var clps types.M
+	clps = utils.CopyMap(defaultCLPS)
+	if classLevelPermissions := utils.M(schema["classLevelPermissions"]); classLevelPermissions != nil {
+		// 不存在的 action 默认为公共权限
+		for k, v := range classLevelPermissions {
+			clps[k] = utils.DeepCopy(v)
+		}
+	}
+
+	result := types.M{
+		"className":             schema["className"],
+		"fields":                fields,
+		"classLevelPermissions": clps,
+	}
+	if indexes := utils.M(schema["indexes"]); len(indexes) > 0 {
+		result["indexes"] = utils.CopyMapM(indexes)
+	}
+	if options := utils.M(schema["options"]); len(options) > 0 {
+		result["options"] = utils.CopyMapM(options)
+	}
+	return result
+}
@@ -0,0 +1,692 @@
+package memory
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+func Test_matchesQuery(t *testing.T) {
+	object := types.M{
+		"objectId":  "01",
+		"createdAt": "2006-01-02T15:04:05.000Z",
+		"name":      "joe",
+		"age":       10.0,
+		"tags":      types.S{"a", "b"},
+		"post":      types.M{"__type": "Pointer", "className": "Post", "objectId": "p1"},
+		"authData":  types.M{"facebook": types.M{"id": "f1"}},
+	}
+	tests := []struct {
+		name    string
+		query   types.M
+		want    bool
+		wantErr bool
+	}{
+		{name: "1", query: types.M{}, want: true},
+		{name: "2", query: types.M{"age": 10}, want: true},
+		{name: "3", query: types.M{"age": types.M{"$gt": 5, "$lte": 10}}, want: true},
+		{name: "4", query: types.M{"age": types.M{"$gt": "5"}}, want: false},
+		{name: "5", query: types.M{"createdAt": types.M{"$lt": types.M{"__type": "Date", "iso": "2007-01-02T15:04:05.000Z"}}}, want: true},
+		{name: "6", query: types.M{"post": types.M{"__type": "Pointer", "className": "Post", "objectId": "p1"}}, want: true},
+		{name: "7", query: types.M{"post": types.M{"$in": types.S{types.M{"__type": "Pointer", "className": "Post", "objectId": "p2"}}}}, want: false},
+		{name: "8", query: types.M{"missing": nil}, want: true},
+		{name: "9", query: types.M{"$and": types.S{types.M{"name": "joe"}, types.M{"tags": "b"}}}, want: true},
+		{name: "10", query: types.M{"$nor": types.S{types.M{"name": "joe"}}}, want: false},
+		{name: "11", query: types.M{"_auth_data_facebook.id": "f1"}, want: true},
+		{name: "12", query: types.M{"authData.facebook.id": "f2"}, want: false},
+		{name: "13", query: types.M{"name": types.M{"$regex": `\Qj.\E`}}, want: false},
+		{name: "14", query: types.M{"name": types.M{"$regex": "("}}, wantErr: true},
+		{name: "15", query: types.M{"name": types.M{"$unknown": 1}}, wantErr: true},
+		{name: "16", query: types.M{"tags": types.M{"$all": types.S{"a", "c"}}}, want: false},
+		{name: "17", query: types.M{"tags": types.M{"$nin": types.S{"c"}}}, want: true},
+	}
+	for _, tt := range tests {
+		got, err := matchesQuery(object, tt.query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. matchesQuery() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q. matchesQuery() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_applyUpdate(t *testing.T) {
+	object := types.M{
+		"count": 1.0,
+		"tags":  types.S{"a", "b"},
+		"info":  types.M{"city": "bj", "zip": 1.0},
+	}
+	update := types.M{
+		"count":              types.M{"__op": "Increment", "amount": 2},
+		"tags":               types.M{"__op": "AddUnique", "objects": types.S{"b", "c"}},
+		"info.zip":           types.M{"__op": "Delete"},
+		"info.street":        "st",
+		"_auth_data_twitter": types.M{"id": "t1"},
+		"updatedAt":          types.M{"__type": "Date", "iso": "2006-01-02T15:04:05.000Z"},
+		"removed":            nil,
+	}
+	want := types.M{
+		"count":     3.0,
+		"tags":      types.S{"a", "b", "c"},
+		"info":      types.M{"city": "bj", "street": "st"},
+		"authData":  types.M{"twitter": types.M{"id": "t1"}},
+		"updatedAt": "2006-01-02T15:04:05.000Z",
+	}
+	if err := applyUpdate(object, update); err != nil || reflect.DeepEqual(object, want) == false {
+		t.Errorf("applyUpdate() = %v, want %v, error %v", object, want, err)
+	}
+
+	err := applyUpdate(object, types.M{"tags": types.M{"__op": "Batch"}})
+	if reflect.DeepEqual(err, errs.E(errs.CommandUnavailable, "The Batch operator is not supported yet.")) == false {
+		t.Errorf("applyUpdate() error = %v", err)
+	}
+
+	err = applyUpdate(object, types.M{"data": types.M{"__type": "Bytes", "base64": "hello"}})
+	if reflect.DeepEqual(err, errs.E(errs.InvalidJSON, "invalid base64")) == false {
+		t.Errorf("applyUpdate() error = %v", err)
+	}
+}
+
+func TestMemoryAdapter_CreateClass(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{
+		"fields": types.M{
+			"name":   types.M{"type": "String"},
+			"_rperm": types.M{"type": "Array"},
+		},
+		"classLevelPermissions": types.M{"find": types.M{"role:admin": true}},
+	}
+	result, err := m.CreateClass("post", schema)
+	want := types.M{
+		"className": "post",
+		"fields": types.M{
+			"name": types.M{"type": "String"},
+		},
+		"classLevelPermissions": types.M{
+			"find":     types.M{"role:admin": true},
+			"get":      types.M{"*": true},
+			"create":   types.M{"*": true},
+			"update":   types.M{"*": true},
+			"delete":   types.M{"*": true},
+			"addField": types.M{"*": true},
+		},
+	}
+	if err != nil || reflect.DeepEqual(result, want) == false {
+		t.Errorf("MemoryAdapter.CreateClass() = %v, want %v, error %v", result, want, err)
+	}
+	if m.ClassExists("post") == false {
+		t.Error("MemoryAdapter.ClassExists() = false")
+	}
+	if got, _ := m.GetClass("post"); reflect.DeepEqual(got, want) == false {
+		t.Errorf("MemoryAdapter.GetClass() = %v, want %v", got, want)
+	}
+
+	_, err = m.CreateClass("post", schema)
+	if reflect.DeepEqual(err, errs.E(errs.DuplicateValue, "Class post already exists.")) == false {
+		t.Errorf("MemoryAdapter.CreateClass() error = %v", err)
+	}
+}
+
+func TestMemoryAdapter_SetClassOptions(t *testing.T) {
+	m := NewMemoryAdapter("")
+	m.CreateClass("post", nil)
+	options := types.M{"softDelete": types.M{"retentionDays": 7}}
+	if err := m.SetClassOptions("post", options); err != nil {
+		t.Errorf("MemoryAdapter.SetClassOptions() error = %v", err)
+	}
+	got, _ := m.GetClass("post")
+	if reflect.DeepEqual(got["options"], options) == false {
+		t.Errorf("MemoryAdapter.SetClassOptions() = %v, want %v", got["options"], options)
+	}
+	if err := m.SetClassOptions("user", options); err != nil {
+		t.Errorf("MemoryAdapter.SetClassOptions() error = %v", err)
+	}
+	if got, _ := m.GetClass("user"); len(got) != 0 {
+		t.Errorf("MemoryAdapter.SetClassOptions() = %v", got)
+	}
+}
+
+func TestMemoryAdapter_AddFieldIfNotExists(t *testing.T) {
+	m := NewMemoryAdapter("")
+	if err := m.AddFieldIfNotExists("post", "name", types.M{"type": "String"}); err != nil {
+		t.Errorf("MemoryAdapter.AddFieldIfNotExists() error = %v", err)
+	}
+	if err := m.AddFieldIfNotExists("post", "age", types.M{"type": "Number"}); err != nil {
+		t.Errorf("MemoryAdapter.AddFieldIfNotExists() error = %v", err)
+	}
+	if err := m.AddFieldIfNotExists("post", "age", types.M{"type": "String"}); err != nil {
+		t.Errorf("MemoryAdapter.AddFieldIfNotExists() error = %v", err)
+	}
+	got, _ := m.GetClass("post")
+	want := types.M{
+		"name": types.M{"type": "String"},
+		"age":  types.M{"type": "Number"},
+	}
+	if reflect.DeepEqual(got["fields"], want) == false {
+		t.Errorf("MemoryAdapter.AddFieldIfNotExists() = %v, want %v", got["fields"], want)
+	}
+}
+
+func TestMemoryAdapter_DeleteFields(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{"fields": types.M{"name": types.M{"type": "String"}, "age": types.M{"type": "Number"}}}
+	m.CreateClass("post", schema)
+	m.CreateObject("post", schema, types.M{"objectId": "01", "name": "joe", "age": 10})
+	if err := m.DeleteFields("post", schema, []string{"age"}); err != nil {
+		t.Errorf("MemoryAdapter.DeleteFields() error = %v", err)
+	}
+	results, _ := m.Find("post", schema, types.M{}, types.M{})
+	if reflect.DeepEqual(results, []types.M{{"objectId": "01", "name": "joe"}}) == false {
+		t.Errorf("MemoryAdapter.DeleteFields() = %v", results)
+	}
+	got, _ := m.GetClass("post")
+	if reflect.DeepEqual(got["fields"], types.M{"name": types.M{"type": "String"}}) == false {
+		t.Errorf("MemoryAdapter.DeleteFields() = %v", got["fields"])
+	}
+}
+
+func TestMemoryAdapter_DeleteClass(t *testing.T) {
+	m := NewMemoryAdapter("")
+	m.CreateClass("post", nil)
+	m.CreateClass("user", nil)
+	m.DeleteClass("post")
+	if m.ClassExists("post") || m.ClassExists("user") == false {
+		t.Error("MemoryAdapter.DeleteClass() failed")
+	}
+	m.DeleteAllClasses()
+	if classes, _ := m.GetAllClasses(); len(classes) != 0 || m.ClassExists("user") {
+		t.Errorf("MemoryAdapter.DeleteAllClasses() = %v", classes)
+	}
+}
+
+func TestMemoryAdapter_CreateObject(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{
+		"fields": types.M{
+			"name":        types.M{"type": "String"},
+			"RelationKey": types.M{"type": "Relation", "targetClass": "Post"},
+		},
+	}
+	m.CreateClass("user", schema)
+	object := types.M{
+		"objectId":           "01",
+		"createdAt":          types.M{"__type": "Date", "iso": "2006-01-02T15:04:05.000Z"},
+		"name":               "joe",
+		"_auth_data_twitter": types.M{"id": "t1"},
+	}
+	if err := m.CreateObject("user", schema, object); err != nil {
+		t.Errorf("MemoryAdapter.CreateObject() error = %v", err)
+	}
+	object["name"] = "jack"
+	results, err := m.Find("user", schema, types.M{}, types.M{})
+	want := []types.M{
+		{
+			"objectId":    "01",
+			"createdAt":   "2006-01-02T15:04:05.000Z",
+			"name":        "joe",
+			"authData":    types.M{"twitter": types.M{"id": "t1"}},
+			"RelationKey": types.M{"__type": "Relation", "className": "Post"},
+		},
+	}
+	if err != nil || reflect.DeepEqual(results, want) == false {
+		t.Errorf("MemoryAdapter.CreateObject() = %v, want %v, error %v", results, want, err)
+	}
+
+	err = m.CreateObject("user", schema, types.M{"objectId": "01"})
+	if reflect.DeepEqual(err, errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")) == false {
+		t.Errorf("MemoryAdapter.CreateObject() error = %v", err)
+	}
+}
+
+func TestMemoryAdapter_CreateObjects(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{
+		"fields": types.M{
+			"name": types.M{"type": "String"},
+		},
+	}
+	m.CreateClass("user", schema)
+	err := m.CreateObjects("user", schema, []types.M{{"objectId": "01", "name": "joe"}, {"objectId": "02", "name": "jack"}})
+	if err != nil {
+		t.Errorf("MemoryAdapter.CreateObjects() error = %v", err)
+	}
+	results, err := m.Find("user", schema, types.M{}, types.M{"sort": []string{"objectId"}})
+	want := []types.M{
+		{"objectId": "01", "name": "joe"},
+		{"objectId": "02", "name": "jack"},
+	}
+	if err != nil || reflect.DeepEqual(results, want) == false {
+		t.Errorf("MemoryAdapter.CreateObjects() = %v, want %v, error %v", results, want, err)
+	}
+
+	// 任意一个对象重复时，所有对象都不创建
+	err = m.CreateObjects("user", schema, []types.M{{"objectId": "03", "name": "tom"}, {"objectId": "01"}})
+	if reflect.DeepEqual(err, errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")) == false {
+		t.Errorf("MemoryAdapter.CreateObjects() error = %v", err)
+	}
+	count, _ := m.Count("user", schema, types.M{})
+	if count != 2 {
+		t.Errorf("MemoryAdapter.Count() = %v, want %v", count, 2)
+	}
+}
+
+func TestMemoryAdapter_Find(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{}
+	m.CreateClass("user", schema)
+	area := types.M{"__type": "Polygon", "coordinates": types.S{types.S{0.0, 0.0}, types.S{0.0, 10.0}, types.S{10.0, 10.0}, types.S{10.0, 0.0}}}
+	m.CreateObject("user", schema, types.M{"objectId": "01", "name": "joe", "age": 10, "tags": types.S{"a", "b"}, "info": types.M{"city": "bj"}, "location": types.M{"longitude": 0.0, "latitude": 0.0}, "area": area, "data": types.M{"__type": "Bytes", "base64": "aGVsbG8="}, "_rperm": types.S{"*"}})
+	m.CreateObject("user", schema, types.M{"objectId": "02", "name": "jack", "age": 20, "tags": types.S{"b", "c"}, "info": types.M{"city": "sh"}, "location": types.M{"longitude": 1.0, "latitude": 1.0}, "_rperm": types.S{"02"}})
+	m.CreateObject("user", schema, types.M{"objectId": "03", "name": "Tom", "age": 30, "location": types.M{"longitude": 50.0, "latitude": 50.0}})
+
+	ids := func(results []types.M) []string {
+		list := []string{}
+		for _, r := range results {
+			list = append(list, r["objectId"].(string))
+		}
+		return list
+	}
+
+	tests := []struct {
+		name    string
+		query   types.M
+		options types.M
+		want    []string
+	}{
+		{name: "1", query: types.M{}, options: types.M{"sort": []string{"age"}}, want: []string{"01", "02", "03"}},
+		{name: "2", query: types.M{"name": "joe"}, options: types.M{}, want: []string{"01"}},
+		{name: "3", query: types.M{"age": types.M{"$gte": 20}}, options: types.M{"sort": []string{"-age"}}, want: []string{"03", "02"}},
+		{name: "4", query: types.M{"$or": types.S{types.M{"name": "joe"}, types.M{"age": 30}}}, options: types.M{"sort": []string{"age"}}, want: []string{"01", "03"}},
+		{name: "5", query: types.M{"name": types.M{"$in": types.S{"joe", "jack"}}}, options: types.M{"sort": []string{"age"}}, want: []string{"01", "02"}},
+		{name: "6", query: types.M{"name": types.M{"$nin": types.S{"joe", "jack"}}}, options: types.M{}, want: []string{"03"}},
+		{name: "7", query: types.M{"tags": types.M{"$all": types.S{"b", "c"}}}, options: types.M{}, want: []string{"02"}},
+		{name: "8", query: types.M{"tags": "a"}, options: types.M{}, want: []string{"01"}},
+		{name: "9", query: types.M{"tags": types.M{"$exists": false}}, options: types.M{}, want: []string{"03"}},
+		{name: "10", query: types.M{"name": types.M{"$regex": "^j", "$options": "i"}}, options: types.M{"sort": []string{"age"}}, want: []string{"01", "02"}},
+		{name: "11", query: types.M{"name": types.M{"$regex": "^t", "$options": "i"}}, options: types.M{}, want: []string{"03"}},
+		{name: "12", query: types.M{"info.city": "sh"}, options: types.M{}, want: []string{"02"}},
+		{name: "13", query: types.M{"_rperm": types.M{"$in": types.S{nil, "*"}}}, options: types.M{"sort": []string{"age"}}, want: []string{"01", "03"}},
+		{name: "14", query: types.M{}, options: types.M{"sort": []string{"age"}, "skip": 1, "limit": 1}, want: []string{"02"}},
+		{name: "15", query: types.M{}, options: types.M{"sort": []string{"age"}, "skip": 1}, want: []string{"02", "03"}},
+		{name: "16", query: types.M{"location": types.M{"$nearSphere": types.M{"longitude": 1.1, "latitude": 1.1}, "$maxDistance": 0.1}}, options: types.M{}, want: []string{"02", "01"}},
+		{name: "17", query: types.M{"location": types.M{"$within": types.M{"$box": types.S{types.M{"longitude": -1.0, "latitude": -1.0}, types.M{"longitude": 0.5, "latitude": 0.5}}}}}, options: types.M{}, want: []string{"01"}},
+		{name: "18", query: types.M{"location": types.M{"$geoWithin": types.M{"$polygon": types.S{
+			types.M{"__type": "GeoPoint", "longitude": 40.0, "latitude": 40.0},
+			types.M{"__type": "GeoPoint", "longitude": 60.0, "latitude": 40.0},
+			types.M{"__type": "GeoPoint", "longitude": 60.0, "latitude": 60.0},
+			types.M{"__type": "GeoPoint", "longitude": 40.0, "latitude": 60.0},
+		}}}}, options: types.M{}, want: []string{"03"}},
+		{name: "19", query: types.M{"name": types.M{"$ne": "joe"}, "age": types.M{"$lt": 30}}, options: types.M{}, want: []string{"02"}},
+		{name: "20", query: types.M{}, options: types.M{"sort": []string{"-tags", "age"}}, want: []string{"02", "01", "03"}},
+		{name: "21", query: types.M{"location": types.M{"$geoWithin": types.M{"$polygon": types.M{
+			"__type": "Polygon", "coordinates": types.S{types.S{0.5, 0.5}, types.S{0.5, 2.0}, types.S{2.0, 2.0}, types.S{2.0, 0.5}},
+		}}}}, options: types.M{}, want: []string{"02"}},
+		{name: "22", query: types.M{"area": types.M{"$geoIntersects": types.M{"$point": types.M{"__type": "GeoPoint", "longitude": 5.0, "latitude": 5.0}}}}, options: types.M{}, want: []string{"01"}},
+		{name: "23", query: types.M{"area": types.M{"$geoIntersects": types.M{"$point": types.M{"__type": "GeoPoint", "longitude": 15.0, "latitude": 5.0}}}}, options: types.M{}, want: []string{}},
+		{name: "24", query: types.M{"data": types.M{"__type": "Bytes", "base64": "aGVsbG8="}}, options: types.M{}, want: []string{"01"}},
+		{name: "25", query: types.M{"data": types.M{"$in": types.S{types.M{"__type": "Bytes", "base64": "aGVsbG8="}}}}, options: types.M{}, want: []string{"01"}},
+		{name: "26", query: types.M{"data": types.M{"__type": "Bytes", "base64": "d29ybGQ="}}, options: types.M{}, want: []string{}},
+	}
+	for _, tt := range tests {
+		results, err := m.Find("user", schema, tt.query, tt.options)
+		if err != nil {
+			t.Errorf("%q. MemoryAdapter.Find() error = %v", tt.name, err)
+			continue
+		}
+		if got := ids(results); reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%q. MemoryAdapter.Find() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	results, err := m.Find("user", schema, types.M{"objectId": "01"}, types.M{"keys": []string{"objectId", "name"}})
+	if err != nil || reflect.DeepEqual(results, []types.M{{"objectId": "01", "name": "joe"}}) == false {
+		t.Errorf("MemoryAdapter.Find() keys = %v, %v", results, err)
+	}
+
+	results, err = m.Find("user", schema, types.M{"objectId": "01"}, types.M{"keys": []string{"area"}})
+	expect := types.M{"__type": "Polygon", "coordinates": types.S{types.S{0.0, 0.0}, types.S{0.0, 10.0}, types.S{10.0, 10.0}, types.S{10.0, 0.0}, types.S{0.0, 0.0}}}
+	if err != nil || len(results) != 1 || reflect.DeepEqual(results[0]["area"], expect) == false {
+		t.Errorf("MemoryAdapter.Find() polygon = %v, %v", results, err)
+	}
+
+	results, _ = m.Find("user", schema, types.M{"objectId": "01"}, types.M{"keys": []string{"objectId", "name"}})
+	results[0]["name"] = "changed"
+	results, _ = m.Find("user", schema, types.M{"objectId": "01"}, types.M{})
+	if results[0]["name"] != "joe" {
+		t.Errorf("MemoryAdapter.Find() returned shared object = %v", results)
+	}
+
+	results, err = m.Find("other", schema, types.M{}, types.M{})
+	if err != nil || len(results) != 0 {
+		t.Errorf("MemoryAdapter.Find() not exists = %v, %v", results, err)
+	}
+}
+
+func TestMemoryAdapter_FindText(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{
+		"fields": types.M{
+			"objectId": types.M{"type": "String"},
+			"subject":  types.M{"type": "String"},
+		},
+	}
+	m.CreateClass("post", schema)
+	m.CreateObject("post", schema, types.M{"objectId": "01", "subject": "coffee and cake"})
+	m.CreateObject("post", schema, types.M{"objectId": "02", "subject": "Café Coffee"})
+	m.CreateObject("post", schema, types.M{"objectId": "03", "subject": "green tea"})
+
+	text := func(search types.M) types.M {
+		return types.M{"subject": types.M{"$text": types.M{"$search": search}}}
+	}
+	results, err := m.Find("post", schema, text(types.M{"$term": "coffee"}), types.M{"sort": []string{storage.TextScoreKey}, "keys": []string{"objectId", storage.TextScoreKey}})
+	expect := []types.M{{"objectId": "02", "score": 0.5}, {"objectId": "01", "score": 1.0 / 3}}
+	if err != nil || reflect.DeepEqual(results, expect) == false {
+		t.Errorf("MemoryAdapter.Find() = %v, %v", results, err)
+	}
+	results, err = m.Find("post", schema, text(types.M{"$term": "cafe"}), types.M{})
+	expect = []types.M{{"objectId": "02", "subject": "Café Coffee"}}
+	if err != nil || reflect.DeepEqual(results, expect) == false {
+		t.Errorf("MemoryAdapter.Find() = %v, %v", results, err)
+	}
+	results, err = m.Find("post", schema, text(types.M{"$term": "cafe", "$diacriticSensitive": true}), types.M{})
+	if err != nil || len(results) != 0 {
+		t.Errorf("MemoryAdapter.Find() = %v, %v", results, err)
+	}
+	_, err = m.Find("post", schema, text(types.M{"$term": 1}), types.M{})
+	if reflect.DeepEqual(err, errs.E(errs.InvalidJSON, "bad $text: $term, should be string")) == false {
+		t.Errorf("MemoryAdapter.Find() error = %v", err)
+	}
+	_, err = m.Find("post", schema, types.M{}, types.M{"keys": []string{storage.TextScoreKey}})
+	if reflect.DeepEqual(err, errs.E(errs.InvalidQuery, "$score can only be used with $text queries.")) == false {
+		t.Errorf("MemoryAdapter.Find() error = %v", err)
+	}
+}
+
+func TestMemoryAdapter_Count(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{}
+	m.CreateObject("user", schema, types.M{"objectId": "01", "age": 10})
+	m.CreateObject("user", schema, types.M{"objectId": "02", "age": 20})
+
+	if got, err := m.Count("user", schema, types.M{"age": types.M{"$gt": 10}}); err != nil || got != 1 {
+		t.Errorf("MemoryAdapter.Count() = %v, %v", got, err)
+	}
+	if got, err := m.Count("user", schema, types.M{}); err != nil || got != 2 {
+		t.Errorf("MemoryAdapter.Count() = %v, %v", got, err)
+	}
+	if got, err := m.Count("other", schema, types.M{}); err != nil || got != 0 {
+		t.Errorf("MemoryAdapter.Count() = %v, %v", got, err)
+	}
+}
+
+func TestMemoryAdapter_DeleteObjectsByQuery(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{}
+	notFound := errs.E(errs.ObjectNotFound, "Object not found.")
+
+	if err := m.DeleteObjectsByQuery("user", schema, types.M{}); reflect.DeepEqual(err, notFound) == false {
+		t.Errorf("MemoryAdapter.DeleteObjectsByQuery() error = %v", err)
+	}
+	m.CreateObject("user", schema, types.M{"objectId": "01", "age": 10})
+	m.CreateObject("user", schema, types.M{"objectId": "02", "age": 20})
+	if err := m.DeleteObjectsByQuery("user", schema, types.M{"age": 30}); reflect.DeepEqual(err, notFound) == false {
+		t.Errorf("MemoryAdapter.DeleteObjectsByQuery() error = %v", err)
+	}
+	if err := m.DeleteObjectsByQuery("user", schema, types.M{"age": 10}); err != nil {
+		t.Errorf("MemoryAdapter.DeleteObjectsByQuery() error = %v", err)
+	}
+	if got, _ := m.Count("user", schema, types.M{}); got != 1 {
+		t.Errorf("MemoryAdapter.DeleteObjectsByQuery() count = %v", got)
+	}
+}
+
+func TestMemoryAdapter_FindOneAndUpdate(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{}
+	m.CreateObject("user", schema, types.M{"objectId": "01", "age": 10, "tags": types.S{"a"}})
+	m.CreateObject("user", schema, types.M{"objectId": "02", "age": 20})
+
+	result, err := m.FindOneAndUpdate("user", schema, types.M{"objectId": "01"}, types.M{
+		"age":  types.M{"__op": "Increment", "amount": 5},
+		"tags": types.M{"__op": "Add", "objects": types.S{"b"}},
+	})
+	want := types.M{"objectId": "01", "age": 15.0, "tags": types.S{"a", "b"}}
+	if err != nil || reflect.DeepEqual(result, want) == false {
+		t.Errorf("MemoryAdapter.FindOneAndUpdate() = %v, want %v, error %v", result, want, err)
+	}
+
+	result, err = m.FindOneAndUpdate("user", schema, types.M{"objectId": "03"}, types.M{"age": 1})
+	if err != nil || len(result) != 0 {
+		t.Errorf("MemoryAdapter.FindOneAndUpdate() = %v, error %v", result, err)
+	}
+
+	m.UpdateObjectsByQuery("user", schema, types.M{}, types.M{"name": "joe"})
+	if got, _ := m.Count("user", schema, types.M{"name": "joe"}); got != 2 {
+		t.Errorf("MemoryAdapter.UpdateObjectsByQuery() count = %v", got)
+	}
+
+	m.EnsureUniqueness("user", schema, []string{"age"})
+	_, err = m.FindOneAndUpdate("user", schema, types.M{"objectId": "02"}, types.M{"age": 15})
+	if errs.GetErrorCode(err) != errs.DuplicateValue {
+		t.Errorf("MemoryAdapter.FindOneAndUpdate() error = %v", err)
+	}
+	if got, _ := m.Count("user", schema, types.M{"age": 20}); got != 1 {
+		t.Errorf("MemoryAdapter.FindOneAndUpdate() changed object after error, count = %v", got)
+	}
+}
+
+func TestMemoryAdapter_UpsertOneObject(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{}
+	doc := types.M{"relatedId": "01", "owningId": "02"}
+	m.UpsertOneObject("_Join:users:post", schema, doc, doc)
+	m.UpsertOneObject("_Join:users:post", schema, doc, doc)
+	results, err := m.Find("_Join:users:post", schema, types.M{}, types.M{})
+	if err != nil || reflect.DeepEqual(results, []types.M{doc}) == false {
+		t.Errorf("MemoryAdapter.UpsertOneObject() = %v, error %v", results, err)
+	}
+
+	m.UpsertOneObject("_Join:users:post", schema, types.M{"relatedId": "01", "owningId": types.M{"$ne": "02"}}, types.M{"owningId": "03"})
+	if got, _ := m.Count("_Join:users:post", schema, types.M{}); got != 2 {
+		t.Errorf("MemoryAdapter.UpsertOneObject() count = %v", got)
+	}
+}
+
+func TestMemoryAdapter_EnsureUniqueness(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{}
+	m.CreateObject("user", schema, types.M{"objectId": "01", "name": "joe"})
+	m.CreateObject("user", schema, types.M{"objectId": "02", "name": "joe"})
+	m.CreateObject("user", schema, types.M{"objectId": "03"})
+
+	err := m.EnsureUniqueness("user", schema, []string{"name"})
+	if reflect.DeepEqual(err, errs.E(errs.DuplicateValue, "Tried to ensure field uniqueness for a class that already has duplicates.")) == false {
+		t.Errorf("MemoryAdapter.EnsureUniqueness() error = %v", err)
+	}
+
+	m.DeleteObjectsByQuery("user", schema, types.M{"objectId": "02"})
+	if err := m.EnsureUniqueness("user", schema, []string{"name"}); err != nil {
+		t.Errorf("MemoryAdapter.EnsureUniqueness() error = %v", err)
+	}
+	if err := m.CreateObject("user", schema, types.M{"objectId": "04"}); err != nil {
+		t.Errorf("MemoryAdapter.CreateObject() error = %v", err)
+	}
+	err = m.CreateObject("user", schema, types.M{"objectId": "05", "name": "joe"})
+	if errs.GetErrorCode(err) != errs.DuplicateValue {
+		t.Errorf("MemoryAdapter.CreateObject() error = %v", err)
+	}
+}
+
+func TestMemoryAdapter_PerformInitialization(t *testing.T) {
+	m := NewMemoryAdapter("")
+	err := m.PerformInitialization(types.M{"VolatileClassesSchemas": []types.M{{"className": "_Hooks"}}})
+	if err != nil || m.ClassExists("_Hooks") == false {
+		t.Errorf("MemoryAdapter.PerformInitialization() error = %v", err)
+	}
+}
+
+func TestMemoryAdapter_concurrent(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.CreateObject("user", schema, types.M{"objectId": strconv.Itoa(i), "count": 0})
+			m.FindOneAndUpdate("user", schema, types.M{"objectId": "0"}, types.M{"count": types.M{"__op": "Increment", "amount": 1}})
+			m.Find("user", schema, types.M{}, types.M{"sort": []string{"objectId"}})
+		}(i)
+	}
+	wg.Wait()
+	if got, _ := m.Count("user", schema, types.M{}); got != 50 {
+		t.Errorf("MemoryAdapter concurrent count = %v", got)
+	}
+}
+
+func TestMemoryAdapter_Transaction(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{}
+	m.CreateClass("user", types.M{"fields": types.M{"age": types.M{"type": "Number"}}})
+	m.CreateObject("user", schema, types.M{"objectId": "01", "age": 10})
+	m.CreateObject("user", schema, types.M{"objectId": "02", "age": 20})
+
+	tx, err := m.Begin()
+	if err != nil {
+		t.Fatalf("MemoryAdapter.Begin() error = %v", err)
+	}
+	tx.CreateObject("user", schema, types.M{"objectId": "03", "age": 30})
+	tx.CreateObject("post", schema, types.M{"objectId": "01"})
+	tx.UpdateObjectsByQuery("user", schema, types.M{"objectId": "01"}, types.M{"age": 11})
+	tx.DeleteObjectsByQuery("user", schema, types.M{"objectId": "02"})
+	tx.AddFieldIfNotExists("user", "name", types.M{"type": "String"})
+	if _, err := tx.DeleteClass("user"); reflect.DeepEqual(err, storage.ErrNotSupportedInTransaction) == false {
+		t.Errorf("MemoryAdapter.DeleteClass() in transaction error = %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Errorf("MemoryAdapter.Rollback() error = %v", err)
+	}
+
+	results, _ := m.Find("user", schema, types.M{}, types.M{"sort": []string{"objectId"}})
+	want := []types.M{
+		types.M{"objectId": "01", "age": 10},
+		types.M{"objectId": "02", "age": 20},
+	}
+	if reflect.DeepEqual(results, want) == false {
+		t.Errorf("MemoryAdapter.Rollback() = %v, want %v", results, want)
+	}
+	if m.ClassExists("post") {
+		t.Errorf("MemoryAdapter.Rollback() post is Exists")
+	}
+	if s, _ := m.GetClass("user"); utils.M(s["fields"])["name"] != nil {
+		t.Errorf("MemoryAdapter.Rollback() fields = %v", s["fields"])
+	}
+
+	tx, _ = m.Begin()
+	tx.CreateObject("user", schema, types.M{"objectId": "03", "age": 30})
+	if err := tx.Commit(); err != nil {
+		t.Errorf("MemoryAdapter.Commit() error = %v", err)
+	}
+	if got, _ := m.Count("user", schema, types.M{}); got != 3 {
+		t.Errorf("MemoryAdapter.Commit() count = %v", got)
+	}
+}
+
+func TestMemoryAdapter_CreateIndex(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{
+		"fields": types.M{"name": types.M{"type": "String"}, "age": types.M{"type": "Number"}},
+		"indexes": types.M{
+			"name_age": types.M{"keys": types.S{"name", "-age"}},
+		},
+	}
+	result, _ := m.CreateClass("user", schema)
+	if reflect.DeepEqual(result["indexes"], schema["indexes"]) == false {
+		t.Errorf("MemoryAdapter.CreateClass() indexes = %v", result["indexes"])
+	}
+
+	index := types.M{"keys": types.S{"age"}, "sparse": true}
+	if err := m.CreateIndex("user", schema, "age", index); err != nil {
+		t.Errorf("MemoryAdapter.CreateIndex() error = %v", err)
+	}
+	if err := m.CreateIndex("user", schema, "age", index); errs.GetErrorCode(err) != errs.DuplicateValue {
+		t.Errorf("MemoryAdapter.CreateIndex() error = %v", err)
+	}
+	if err := m.CreateIndex("other", schema, "age", index); errs.GetErrorCode(err) != errs.InvalidClassName {
+		t.Errorf("MemoryAdapter.CreateIndex() error = %v", err)
+	}
+	got, _ := m.GetIndexes("user")
+	want := types.M{
+		"name_age": types.M{"keys": types.S{"name", "-age"}},
+		"age":      types.M{"keys": types.S{"age"}, "sparse": true},
+	}
+	if reflect.DeepEqual(got, want) == false {
+		t.Errorf("MemoryAdapter.GetIndexes() = %v, want %v", got, want)
+	}
+
+	if err := m.DropIndex("user", "name_age"); err != nil {
+		t.Errorf("MemoryAdapter.DropIndex() error = %v", err)
+	}
+	got, _ = m.GetIndexes("user")
+	delete(want, "name_age")
+	if reflect.DeepEqual(got, want) == false {
+		t.Errorf("MemoryAdapter.DropIndex() = %v, want %v", got, want)
+	}
+}
+
+func TestMemoryAdapter_Explain(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{
+		"fields": types.M{
+			"name": types.M{"type": "String"},
+		},
+	}
+	m.CreateClass("user", schema)
+	m.CreateObject("user", schema, types.M{"objectId": "01", "name": "joe"})
+	m.CreateObject("user", schema, types.M{"objectId": "02", "name": "jack"})
+
+	result, err := m.Explain("user", schema, types.M{"name": "joe"}, types.M{})
+	expect := types.M{
+		"query": types.M{"name": "joe"},
+		"plan": types.M{
+			"stage":    "COLLSCAN",
+			"examined": 2,
+			"returned": 1,
+		},
+	}
+	if err != nil || reflect.DeepEqual(result, expect) == false {
+		t.Errorf("MemoryAdapter.Explain() = %v, %v, want %v", result, err, expect)
+	}
+}
+
+func TestMemoryAdapter_WithContext(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{}
+	m.CreateClass("user", types.M{"fields": types.M{"age": types.M{"type": "Number"}}})
+	m.CreateObject("user", schema, types.M{"objectId": "01", "age": 10})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := m.WithContext(ctx)
+	if got, err := c.Count("user", schema, types.M{}); err != nil || got != 1 {
+		t.Errorf("MemoryAdapter.WithContext() Count = %v, error = %v", got, err)
+	}
+	cancel()
+	if _, err := c.Find("user", schema, types.M{}, types.M{}); err != context.Canceled {
+		t.Errorf("MemoryAdapter.WithContext() Find error = %v", err)
+	}
+	if err := c.CreateObject("user", schema, types.M{"objectId": "02", "age": 20}); err != context.Canceled {
+		t.Errorf("MemoryAdapter.WithContext() CreateObject error = %v", err)
+	}
+	if got, err := m.Count("user", schema, types.M{}); err != nil || got != 1 {
+		t.Errorf("MemoryAdapter.Count() = %v, error = %v", got, err)
+	}
+}
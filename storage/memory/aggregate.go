@@ -0,0 +1,247 @@
+package memory
+
+import (
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// Aggregate 对符合条件的对象执行聚合管道
+func (m *MemoryAdapter) Aggregate(className string, schema, query types.M, pipeline types.S) ([]types.M, error) {
+	m.mu.RLock()
+	objects, _, err := m.findMatches(className, query)
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := utils.M(schema["fields"])
+	if fields == nil {
+		fields = types.M{}
+	}
+	for _, v := range pipeline {
+		stage := utils.M(v)
+		op, arg := storage.StageOperator(stage)
+		switch op {
+		case "$group":
+			objects = groupObjects(objects, utils.M(arg))
+		case "$project":
+			objects = projectObjects(objects, utils.M(arg))
+		case "$match":
+			matches := []types.M{}
+			for _, object := range objects {
+				ok, err := matchesQuery(object, utils.M(arg))
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					matches = append(matches, object)
+				}
+			}
+			objects = matches
+		case "$sort":
+			sortObjects(objects, storage.SortKeys(arg))
+		case "$skip":
+			if skip, ok := toFloat(arg); ok {
+				if int(skip) >= len(objects) {
+					objects = []types.M{}
+				} else if skip > 0 {
+					objects = objects[int(skip):]
+				}
+			}
+		case "$limit":
+			if limit, ok := toFloat(arg); ok && int(limit) < len(objects) {
+				objects = objects[:int(limit)]
+			}
+		default:
+			return nil, errs.E(errs.InvalidQuery, "Invalid aggregate stage: "+op)
+		}
+		fields = storage.AggregateOutputFields(fields, stage)
+	}
+
+	results := []types.M{}
+	transformed := storage.PipelineTransformsObjects(pipeline)
+	for _, object := range objects {
+		if transformed {
+			results = append(results, aggregateObjectToParseObject(object, fields))
+		} else {
+			results = append(results, memoryObjectToParseObject(object, schema, nil))
+		}
+	}
+	return results, nil
+}
+
+// Distinct 返回符合条件的对象中 fieldName 字段的不同取值，数组字段取其中的元素
+func (m *MemoryAdapter) Distinct(className string, schema, query types.M, fieldName string) (types.S, error) {
+	m.mu.RLock()
+	objects, _, err := m.findMatches(className, query)
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var tp interface{}
+	if fields := utils.M(schema["fields"]); fields != nil {
+		tp = fields[fieldName]
+	}
+	seen := map[string]bool{}
+	results := types.S{}
+	add := func(v interface{}) {
+		if v == nil {
+			return
+		}
+		key := marshal(normalize(v))
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		results = append(results, aggregateValueToParseValue(fieldName, v, tp))
+	}
+	for _, object := range objects {
+		v, _ := getValue(object, fieldName)
+		if array := utils.A(v); array != nil {
+			for _, item := range array {
+				add(item)
+			}
+		} else {
+			add(v)
+		}
+	}
+	return results, nil
+}
+
+// groupObjects 按照分组依据对对象分组，并计算各分组的累加器
+func groupObjects(objects []types.M, group types.M) []types.M {
+	keys := []string{}
+	groups := map[string][]types.M{}
+	groupKeys := map[string]interface{}{}
+	keyFields := storage.GroupKeyFields(group["objectId"])
+	keyField := storage.FieldReference(group["objectId"])
+	for _, object := range objects {
+		var groupKey interface{}
+		var hash string
+		if keyFields != nil {
+			compound := types.M{}
+			normalized := map[string]interface{}{}
+			for alias, field := range keyFields {
+				v, _ := getValue(object, field)
+				compound[alias] = v
+				normalized[alias] = normalize(v)
+			}
+			groupKey = compound
+			hash = marshal(normalized)
+		} else if keyField != "" {
+			groupKey, _ = getValue(object, keyField)
+			hash = marshal(normalize(groupKey))
+		}
+		if _, ok := groups[hash]; ok == false {
+			keys = append(keys, hash)
+			groupKeys[hash] = groupKey
+		}
+		groups[hash] = append(groups[hash], object)
+	}
+
+	results := []types.M{}
+	for _, hash := range keys {
+		result := types.M{"objectId": utils.DeepCopy(groupKeys[hash])}
+		for alias, accumulator := range group {
+			if alias == "objectId" {
+				continue
+			}
+			op, arg := storage.Accumulator(accumulator)
+			result[alias] = accumulate(groups[hash], op, arg)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// accumulate 计算一个分组中的累加器
+func accumulate(objects []types.M, op string, arg interface{}) interface{} {
+	if n, ok := toFloat(arg); ok && op == "$sum" {
+		return n * float64(len(objects))
+	}
+	field := storage.FieldReference(arg)
+	var sum float64
+	var count int
+	var result interface{}
+	for _, object := range objects {
+		v, _ := getValue(object, field)
+		if v == nil {
+			continue
+		}
+		switch op {
+		case "$sum", "$avg":
+			if n, ok := toFloat(v); ok {
+				sum += n
+				count++
+			}
+		case "$min":
+			if result == nil || compareValues(v, result) < 0 {
+				result = v
+			}
+		case "$max":
+			if result == nil || compareValues(v, result) > 0 {
+				result = v
+			}
+		}
+	}
+	switch op {
+	case "$sum":
+		return sum
+	case "$avg":
+		if count == 0 {
+			return nil
+		}
+		return sum / float64(count)
+	}
+	return utils.DeepCopy(result)
+}
+
+// projectObjects 只保留 project 中指定的字段
+func projectObjects(objects []types.M, project types.M) []types.M {
+	results := []types.M{}
+	for _, object := range objects {
+		result := types.M{}
+		for alias, ref := range project {
+			if v, ok := getValue(object, storage.FieldReference(ref)); ok {
+				result[alias] = utils.DeepCopy(v)
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// aggregateObjectToParseObject 按照输出字段的类型转换聚合结果
+func aggregateObjectToParseObject(object, fields types.M) types.M {
+	for fieldName, v := range object {
+		if v == nil {
+			delete(object, fieldName)
+			continue
+		}
+		object[fieldName] = aggregateValueToParseValue(fieldName, v, fields[fieldName])
+	}
+	return object
+}
+
+// aggregateValueToParseValue 把 Date 类型的值转换为 Parse 格式， createdAt updatedAt 保持字符串格式
+func aggregateValueToParseValue(fieldName string, value interface{}, tp interface{}) interface{} {
+	fieldType := utils.M(tp)
+	if fieldType == nil {
+		return value
+	}
+	switch utils.S(fieldType["type"]) {
+	case "Date":
+		if s, ok := value.(string); ok && fieldName != "createdAt" && fieldName != "updatedAt" {
+			return types.M{"__type": "Date", "iso": s}
+		}
+	case "Object":
+		subFields := utils.M(fieldType["fields"])
+		if object := utils.M(value); object != nil && subFields != nil {
+			return aggregateObjectToParseObject(types.M(object), subFields)
+		}
+	}
+	return value
+}
@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lfq7413/tomato/types"
+)
+
+func TestMemoryAdapter_Aggregate(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{
+		"fields": types.M{
+			"objectId":  types.M{"type": "String"},
+			"createdAt": types.M{"type": "Date"},
+			"player":    types.M{"type": "Pointer", "targetClass": "_User"},
+			"level":     types.M{"type": "String"},
+			"points":    types.M{"type": "Number"},
+			"playedAt":  types.M{"type": "Date"},
+		},
+	}
+	player := func(id string) types.M {
+		return types.M{"__type": "Pointer", "className": "_User", "objectId": id}
+	}
+	date := func(iso string) types.M {
+		return types.M{"__type": "Date", "iso": iso}
+	}
+	m.CreateClass("score", schema)
+	m.CreateObject("score", schema, types.M{"objectId": "01", "createdAt": "2017-01-01T00:00:00.000Z", "player": player("u1"), "level": "a", "points": 10, "playedAt": date("2017-01-01T00:00:00.000Z")})
+	m.CreateObject("score", schema, types.M{"objectId": "02", "createdAt": "2017-01-02T00:00:00.000Z", "player": player("u1"), "level": "b", "points": 20, "playedAt": date("2017-01-02T00:00:00.000Z")})
+	m.CreateObject("score", schema, types.M{"objectId": "03", "createdAt": "2017-01-03T00:00:00.000Z", "player": player("u2"), "level": "a", "points": 30, "playedAt": date("2017-01-03T00:00:00.000Z")})
+
+	tests := []struct {
+		name     string
+		query    types.M
+		pipeline types.S
+		want     []types.M
+	}{
+		{
+			name:  "1",
+			query: types.M{},
+			pipeline: types.S{
+				types.M{"$group": types.M{
+					"objectId": "$player",
+					"total":    types.M{"$sum": "$points"},
+					"count":    types.M{"$sum": 1},
+					"average":  types.M{"$avg": "$points"},
+					"first":    types.M{"$min": "$createdAt"},
+					"last":     types.M{"$max": "$playedAt"},
+				}},
+				types.M{"$sort": []string{"-count"}},
+			},
+			want: []types.M{
+				{"objectId": player("u1"), "total": 30.0, "count": 2.0, "average": 15.0, "first": date("2017-01-01T00:00:00.000Z"), "last": date("2017-01-02T00:00:00.000Z")},
+				{"objectId": player("u2"), "total": 30.0, "count": 1.0, "average": 30.0, "first": date("2017-01-03T00:00:00.000Z"), "last": date("2017-01-03T00:00:00.000Z")},
+			},
+		},
+		{
+			name:  "2",
+			query: types.M{"level": "a"},
+			pipeline: types.S{
+				types.M{"$group": types.M{"objectId": nil, "total": types.M{"$sum": "$points"}}},
+			},
+			want: []types.M{{"total": 40.0}},
+		},
+		{
+			name:  "3",
+			query: types.M{},
+			pipeline: types.S{
+				types.M{"$group": types.M{"objectId": types.M{"player": "$player", "level": "$level"}, "count": types.M{"$sum": 1}}},
+				types.M{"$match": types.M{"count": types.M{"$gte": 1}}},
+				types.M{"$sort": []string{"count"}},
+				types.M{"$limit": 1},
+			},
+			want: []types.M{{"objectId": types.M{"player": player("u1"), "level": "a"}, "count": 1.0}},
+		},
+		{
+			name:  "4",
+			query: types.M{},
+			pipeline: types.S{
+				types.M{"$project": types.M{"objectId": "$objectId", "score": "$points", "createdAt": "$createdAt"}},
+				types.M{"$sort": []string{"-score"}},
+				types.M{"$skip": 1},
+				types.M{"$limit": 1},
+			},
+			want: []types.M{{"objectId": "02", "score": 20, "createdAt": "2017-01-02T00:00:00.000Z"}},
+		},
+		{
+			name:     "5",
+			query:    types.M{"points": types.M{"$gt": 10}},
+			pipeline: types.S{types.M{"$sort": []string{"points"}}, types.M{"$limit": 1}},
+			want:     []types.M{{"objectId": "02", "createdAt": "2017-01-02T00:00:00.000Z", "player": player("u1"), "level": "b", "points": 20, "playedAt": date("2017-01-02T00:00:00.000Z")}},
+		},
+		{
+			name:     "6",
+			query:    types.M{"level": "c"},
+			pipeline: types.S{types.M{"$group": types.M{"objectId": nil, "count": types.M{"$sum": 1}}}},
+			want:     []types.M{},
+		},
+	}
+	for _, tt := range tests {
+		got, err := m.Aggregate("score", schema, tt.query, tt.pipeline)
+		if err != nil {
+			t.Errorf("%q. MemoryAdapter.Aggregate() error = %v", tt.name, err)
+			continue
+		}
+		if reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%q. MemoryAdapter.Aggregate() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryAdapter_Distinct(t *testing.T) {
+	m := NewMemoryAdapter("")
+	schema := types.M{
+		"fields": types.M{
+			"name": types.M{"type": "String"},
+			"tags": types.M{"type": "Array"},
+		},
+	}
+	m.CreateClass("user", schema)
+	m.CreateObject("user", schema, types.M{"objectId": "01", "name": "joe", "tags": types.S{"a", "b"}})
+	m.CreateObject("user", schema, types.M{"objectId": "02", "name": "jack", "tags": types.S{"b", "c"}})
+	m.CreateObject("user", schema, types.M{"objectId": "03", "name": "joe"})
+
+	got, err := m.Distinct("user", schema, types.M{}, "name")
+	if err != nil || reflect.DeepEqual(got, types.S{"joe", "jack"}) == false {
+		t.Errorf("MemoryAdapter.Distinct() = %v, %v", got, err)
+	}
+	got, err = m.Distinct("user", schema, types.M{}, "tags")
+	if err != nil || reflect.DeepEqual(got, types.S{"a", "b", "c"}) == false {
+		t.Errorf("MemoryAdapter.Distinct() = %v, %v", got, err)
+	}
+	got, err = m.Distinct("user", schema, types.M{"name": "jack"}, "tags")
+	if err != nil || reflect.DeepEqual(got, types.S{"b", "c"}) == false {
+		t.Errorf("MemoryAdapter.Distinct() = %v, %v", got, err)
+	}
+}
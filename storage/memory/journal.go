@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// journal 记录事务中被修改的类定义与对象的原始值，只记录第一次修改前的值
+// 方法可以在 nil 上调用，此时不做任何记录
+type journal struct {
+	schemas     map[string]types.M
+	collections map[string]bool
+	objects     map[string]map[string]types.M
+}
+
+func newJournal() *journal {
+	return &journal{
+		schemas:     map[string]types.M{},
+		collections: map[string]bool{},
+		objects:     map[string]map[string]types.M{},
+	}
+}
+
+// recordSchema 记录类定义的原始值，类不存在时记录为 nil
+func (j *journal) recordSchema(data *memoryData, className string) {
+	if j == nil {
+		return
+	}
+	j.recordCollection(data, className)
+	if _, ok := j.schemas[className]; ok {
+		return
+	}
+	j.schemas[className] = utils.CopyMapM(data.schemas[className])
+}
+
+// recordCollection 记录数据集合在事务开始前是否存在
+func (j *journal) recordCollection(data *memoryData, className string) {
+	if j == nil {
+		return
+	}
+	if _, ok := j.collections[className]; ok {
+		return
+	}
+	_, ok := data.collections[className]
+	j.collections[className] = ok
+}
+
+// recordObject 记录对象的原始值， original 为 nil 表示对象是在事务中创建的
+func (j *journal) recordObject(className string, object, original types.M) {
+	if j == nil {
+		return
+	}
+	objects := j.objects[className]
+	if objects == nil {
+		objects = map[string]types.M{}
+		j.objects[className] = objects
+	}
+	key := objectKey(object)
+	if _, ok := objects[key]; ok {
+		return
+	}
+	objects[key] = utils.CopyMapM(original)
+}
+
+// restore 把数据恢复到事务开始前的状态，调用前需要加锁
+func (j *journal) restore(data *memoryData) {
+	for className, originals := range j.objects {
+		objects := []types.M{}
+		for _, object := range data.collections[className] {
+			if _, ok := originals[objectKey(object)]; ok == false {
+				objects = append(objects, object)
+			}
+		}
+		for _, original := range originals {
+			if original != nil {
+				objects = append(objects, original)
+			}
+		}
+		data.collections[className] = objects
+	}
+	for className, schema := range j.schemas {
+		if schema == nil {
+			delete(data.schemas, className)
+		} else {
+			data.schemas[className] = schema
+		}
+	}
+	for className, existed := range j.collections {
+		if existed == false {
+			delete(data.collections, className)
+		}
+	}
+}
+
+// objectKey 对象的唯一标识，没有 objectId 的对象（如 _Join 表中的数据）使用对象本身作为标识
+func objectKey(object types.M) string {
+	if objectID, ok := object["objectId"].(string); ok && objectID != "" {
+		return objectID
+	}
+	return marshal(object)
+}
@@ -0,0 +1,671 @@
+package memory
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// matchesQuery 检测对象是否符合查询条件，查询条件的语义与 MongoDB 保持一致
+func matchesQuery(object, query types.M) (bool, error) {
+	for key, constraint := range query {
+		ok, err := matchesKeyConstraint(object, key, constraint)
+		if err != nil || ok == false {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchesKeyConstraint 检测对象中的字段是否符合指定的条件
+func matchesKeyConstraint(object types.M, key string, constraint interface{}) (bool, error) {
+	switch key {
+	case "$or", "$and", "$nor":
+		subQueries := utils.A(constraint)
+		if subQueries == nil {
+			return false, errs.E(errs.InvalidQuery, "bad "+key+" format - use an array value")
+		}
+		for _, q := range subQueries {
+			ok, err := matchesQuery(object, utils.M(q))
+			if err != nil {
+				return false, err
+			}
+			if key == "$or" && ok {
+				return true, nil
+			}
+			if key == "$and" && ok == false {
+				return false, nil
+			}
+			if key == "$nor" && ok {
+				return false, nil
+			}
+		}
+		return key != "$or", nil
+	}
+
+	value, exists := getValue(object, key)
+
+	c := utils.M(constraint)
+	if c == nil || isOperatorMap(c) == false {
+		return matchesEqual(value, constraint), nil
+	}
+
+	for op, compareTo := range c {
+		switch op {
+		case "$eq":
+			if matchesEqual(value, compareTo) == false {
+				return false, nil
+			}
+		case "$ne":
+			if matchesEqual(value, compareTo) {
+				return false, nil
+			}
+		case "$lt", "$lte", "$gt", "$gte":
+			if matchesCompare(value, compareTo, op) == false {
+				return false, nil
+			}
+		case "$in", "$nin":
+			array := utils.A(compareTo)
+			if array == nil {
+				return false, errs.E(errs.InvalidJSON, "bad "+op+" value")
+			}
+			in := false
+			for _, v := range array {
+				if matchesEqual(value, v) {
+					in = true
+					break
+				}
+			}
+			if in != (op == "$in") {
+				return false, nil
+			}
+		case "$all":
+			array := utils.A(compareTo)
+			if array == nil {
+				return false, errs.E(errs.InvalidJSON, "bad $all value")
+			}
+			if utils.A(value) == nil {
+				return false, nil
+			}
+			for _, v := range array {
+				if matchesEqual(value, v) == false {
+					return false, nil
+				}
+			}
+		case "$exists":
+			b, ok := compareTo.(bool)
+			if ok == false {
+				return false, errs.E(errs.InvalidJSON, "bad $exists value")
+			}
+			if (exists && value != nil) != b {
+				return false, nil
+			}
+		case "$regex":
+			re, err := compileRegex(compareTo, c["$options"])
+			if err != nil {
+				return false, err
+			}
+			s, ok := value.(string)
+			if ok == false || re.MatchString(s) == false {
+				return false, nil
+			}
+		case "$text":
+			search, err := storage.ParseTextSearch(compareTo)
+			if err != nil {
+				return false, err
+			}
+			s, ok := value.(string)
+			if ok == false || storage.TextScore(s, search) == 0 {
+				return false, nil
+			}
+		case "$nearSphere":
+			point := utils.M(compareTo)
+			if point == nil {
+				return false, errs.E(errs.InvalidJSON, "bad $nearSphere value")
+			}
+			distance, ok := distanceOf(value, point)
+			if ok == false {
+				return false, nil
+			}
+			if maxDistance, ok := maxDistanceOf(c); ok && distance > maxDistance {
+				return false, nil
+			}
+		case "$within":
+			box := utils.A(utils.M(compareTo)["$box"])
+			if len(box) != 2 || utils.M(box[0]) == nil || utils.M(box[1]) == nil {
+				return false, errs.E(errs.InvalidJSON, "bad $within value")
+			}
+			if pointInBox(value, utils.M(box[0]), utils.M(box[1])) == false {
+				return false, nil
+			}
+		case "$geoWithin":
+			var vertices []storage.Vertex
+			if p := utils.M(utils.M(compareTo)["$polygon"]); p != nil {
+				var err error
+				if vertices, err = storage.ParsePolygon(p); err != nil {
+					return false, err
+				}
+			} else {
+				polygon := utils.A(utils.M(compareTo)["$polygon"])
+				if len(polygon) < 3 {
+					return false, errs.E(errs.InvalidJSON, "bad $geoWithin value")
+				}
+				if vertices = geoPointsToVertices(polygon); vertices == nil {
+					return false, nil
+				}
+			}
+			lng, lat, ok := geoPoint(value)
+			if ok == false || storage.PolygonContains(vertices, lng, lat) == false {
+				return false, nil
+			}
+		case "$geoIntersects":
+			lng, lat, ok := storage.GeoPointOf(utils.M(compareTo)["$point"])
+			if ok == false {
+				return false, errs.E(errs.InvalidJSON, "bad $geoIntersects value; $point should be GeoPoint")
+			}
+			vertices, err := storage.ParsePolygon(value)
+			if err != nil || storage.PolygonContains(vertices, lng, lat) == false {
+				return false, nil
+			}
+		case "$options", "$maxDistance", "$maxDistanceInRadians", "$maxDistanceInMiles", "$maxDistanceInKilometers":
+		default:
+			return false, errs.E(errs.OperationForbidden, "Memory adapter doesn't support this query type yet "+op)
+		}
+	}
+
+	return true, nil
+}
+
+// isOperatorMap 判断查询条件是否为 {"$op": value} 格式
+func isOperatorMap(m types.M) bool {
+	if _, ok := m["__type"]; ok {
+		return false
+	}
+	for k := range m {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// getValue 获取对象中 key 对应的值，支持以 . 分隔的子字段
+func getValue(object types.M, key string) (interface{}, bool) {
+	components := strings.Split(key, ".")
+	if strings.HasPrefix(components[0], "_auth_data_") {
+		components = append([]string{"authData", components[0][len("_auth_data_"):]}, components[1:]...)
+	}
+	var current interface{} = map[string]interface{}(object)
+	for _, k := range components {
+		m := utils.M(current)
+		if m == nil {
+			return nil, false
+		}
+		v, ok := m[k]
+		if ok == false {
+			return nil, false
+		}
+		current = v
+	}
+	return current, true
+}
+
+// matchesEqual 检测 value 与 compareTo 是否相等，value 为数组时，只要有一个元素相等即可
+func matchesEqual(value, compareTo interface{}) bool {
+	if compareTo == nil {
+		return value == nil
+	}
+	if array := utils.A(value); array != nil && utils.A(compareTo) == nil {
+		for _, v := range array {
+			if equal(v, compareTo) {
+				return true
+			}
+		}
+		return false
+	}
+	return equal(value, compareTo)
+}
+
+// matchesCompare 比较大小，只在同类型的值之间进行
+func matchesCompare(value, compareTo interface{}, op string) bool {
+	if array := utils.A(value); array != nil {
+		for _, v := range array {
+			if matchesCompare(v, compareTo, op) {
+				return true
+			}
+		}
+		return false
+	}
+	v, c := normalize(value), normalize(compareTo)
+	if typeRank(v) != typeRank(c) || v == nil {
+		return false
+	}
+	result := compareValues(v, c)
+	switch op {
+	case "$lt":
+		return result < 0
+	case "$lte":
+		return result <= 0
+	case "$gt":
+		return result > 0
+	case "$gte":
+		return result >= 0
+	}
+	return false
+}
+
+// equal 比较两个值是否相等，Date 、 Pointer 、 File 类型按照其标识进行比较
+func equal(a, b interface{}) bool {
+	a, b = normalize(a), normalize(b)
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	return marshal(a) == marshal(b)
+}
+
+// normalize 把 Parse 格式的值转换为可以直接比较的值
+func normalize(v interface{}) interface{} {
+	m := utils.M(v)
+	if m == nil {
+		return v
+	}
+	switch utils.S(m["__type"]) {
+	case "Date":
+		return utils.S(m["iso"])
+	case "Pointer":
+		return utils.S(m["objectId"])
+	case "File":
+		return utils.S(m["name"])
+	}
+	return v
+}
+
+// typeRank 排序时不同类型之间的顺序
+func typeRank(v interface{}) int {
+	if v == nil {
+		return 0
+	}
+	if _, ok := toFloat(v); ok {
+		return 1
+	}
+	switch v.(type) {
+	case string:
+		return 2
+	case bool:
+		return 5
+	}
+	if utils.A(v) != nil {
+		return 4
+	}
+	return 3
+}
+
+// compareValues 比较两个值的大小，返回 -1 0 1
+func compareValues(a, b interface{}) int {
+	a, b = normalize(a), normalize(b)
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	switch ra {
+	case 1:
+		fa, _ := toFloat(a)
+		fb, _ := toFloat(b)
+		if fa < fb {
+			return -1
+		} else if fa > fb {
+			return 1
+		}
+		return 0
+	case 2:
+		return strings.Compare(a.(string), b.(string))
+	case 5:
+		if a.(bool) == b.(bool) {
+			return 0
+		} else if b.(bool) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(marshal(a), marshal(b))
+}
+
+// sortObjects 按照 keys 对对象进行排序，以 - 开头的 key 为降序
+func sortObjects(objects []types.M, keys []string) {
+	sort.SliceStable(objects, func(i, j int) bool {
+		for _, key := range keys {
+			desc := strings.HasPrefix(key, "-")
+			if desc {
+				key = key[1:]
+			}
+			vi, _ := getValue(objects[i], key)
+			vj, _ := getValue(objects[j], key)
+			result := compareValues(vi, vj)
+			if result == 0 {
+				continue
+			}
+			if desc {
+				return result > 0
+			}
+			return result < 0
+		}
+		return false
+	})
+}
+
+// sortByDistance 按照与 point 的距离由近及远排序
+func sortByDistance(objects []types.M, key string, point types.M) {
+	sort.SliceStable(objects, func(i, j int) bool {
+		vi, _ := getValue(objects[i], key)
+		vj, _ := getValue(objects[j], key)
+		di, _ := distanceOf(vi, point)
+		dj, _ := distanceOf(vj, point)
+		return di < dj
+	})
+}
+
+// nearSphereOf 查找查询条件中第一层的 $nearSphere ，用于按距离排序
+func nearSphereOf(query types.M) (string, types.M) {
+	keys := []string{}
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if c := utils.M(query[k]); c != nil {
+			if point := utils.M(c["$nearSphere"]); point != nil {
+				return k, point
+			}
+		}
+	}
+	return "", nil
+}
+
+// textScoreOf 计算对象与查询条件中所有 $text 的相关度之和，包括 $and $or 中的子查询
+func textScoreOf(object, query types.M) float64 {
+	var score float64
+	for key, v := range query {
+		if key == "$and" || key == "$or" {
+			for _, subQuery := range utils.A(v) {
+				score += textScoreOf(object, utils.M(subQuery))
+			}
+			continue
+		}
+		constraint := utils.M(v)
+		if constraint == nil || constraint["$text"] == nil {
+			continue
+		}
+		search, err := storage.ParseTextSearch(constraint["$text"])
+		if err != nil {
+			continue
+		}
+		if s, ok := object[key].(string); ok {
+			score += storage.TextScore(s, search)
+		}
+	}
+	return score
+}
+
+// compileRegex 编译正则表达式，支持 i m s x 选项以及 \Q...\E 格式的字面量
+func compileRegex(pattern, options interface{}) (*regexp.Regexp, error) {
+	p, ok := pattern.(string)
+	if ok == false {
+		return nil, errs.E(errs.InvalidJSON, "bad $regex value")
+	}
+	flags := ""
+	if o, ok := options.(string); ok {
+		for _, f := range o {
+			switch f {
+			case 'i', 'm', 's':
+				flags = flags + string(f)
+			case 'x':
+				p = removeWhiteSpace(p)
+			}
+		}
+	}
+	p = literalizeRegexPart(p)
+	if flags != "" {
+		p = "(?" + flags + ")" + p
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, errs.E(errs.InvalidQuery, "bad $regex: "+err.Error())
+	}
+	return re, nil
+}
+
+// removeWhiteSpace 删除 x 选项下正则表达式中的空白与注释
+func removeWhiteSpace(s string) string {
+	lines := []string{}
+	for _, line := range strings.Split(s, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		lines = append(lines, strings.Join(strings.Fields(line), ""))
+	}
+	return strings.Join(lines, "")
+}
+
+// literalizeRegexPart 把 \Q...\E 之间的内容转换为字面量
+func literalizeRegexPart(s string) string {
+	for {
+		start := strings.Index(s, `\Q`)
+		if start == -1 {
+			return s
+		}
+		end := strings.Index(s[start+2:], `\E`)
+		if end == -1 {
+			return s[:start] + regexp.QuoteMeta(s[start+2:])
+		}
+		end = end + start + 2
+		s = s[:start] + regexp.QuoteMeta(s[start+2:end]) + s[end+2:]
+	}
+}
+
+// geoPoint 获取 GeoPoint 的经纬度
+func geoPoint(v interface{}) (float64, float64, bool) {
+	m := utils.M(v)
+	if m == nil {
+		return 0, 0, false
+	}
+	longitude, ok1 := toFloat(m["longitude"])
+	latitude, ok2 := toFloat(m["latitude"])
+	return longitude, latitude, ok1 && ok2
+}
+
+// distanceOf 计算两点间的球面距离，单位为弧度
+func distanceOf(value interface{}, point types.M) (float64, bool) {
+	lng1, lat1, ok := geoPoint(value)
+	if ok == false {
+		return math.MaxFloat64, false
+	}
+	lng2, lat2, ok := geoPoint(point)
+	if ok == false {
+		return math.MaxFloat64, false
+	}
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLng := (lng2 - lng1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * math.Asin(math.Min(1, math.Sqrt(a))), true
+}
+
+// maxDistanceOf 获取以弧度为单位的最大距离
+func maxDistanceOf(constraint types.M) (float64, bool) {
+	if d, ok := toFloat(constraint["$maxDistance"]); ok {
+		return d, true
+	}
+	if d, ok := toFloat(constraint["$maxDistanceInRadians"]); ok {
+		return d, true
+	}
+	if d, ok := toFloat(constraint["$maxDistanceInMiles"]); ok {
+		return d / 3958.8, true
+	}
+	if d, ok := toFloat(constraint["$maxDistanceInKilometers"]); ok {
+		return d / 6371.0, true
+	}
+	return 0, false
+}
+
+// pointInBox 点是否在矩形范围内， box1 为左下角， box2 为右上角
+func pointInBox(value interface{}, box1, box2 types.M) bool {
+	lng, lat, ok := geoPoint(value)
+	if ok == false {
+		return false
+	}
+	left, bottom, _ := geoPoint(box1)
+	right, top, _ := geoPoint(box2)
+	return lng >= left && lng <= right && lat >= bottom && lat <= top
+}
+
+// geoPointsToVertices 把 GeoPoint 数组转换为多边形的顶点，包含无效的 GeoPoint 时返回 nil
+func geoPointsToVertices(polygon types.S) []storage.Vertex {
+	vertices := []storage.Vertex{}
+	for _, p := range polygon {
+		x, y, ok := geoPoint(p)
+		if ok == false {
+			return nil
+		}
+		vertices = append(vertices, storage.Vertex{x, y})
+	}
+	return vertices
+}
+
+// applyUpdate 把更新操作应用到对象上，支持 Delete Increment Add AddUnique Remove 操作
+func applyUpdate(object, update types.M) error {
+	for key, value := range update {
+		components := strings.Split(key, ".")
+		if strings.HasPrefix(components[0], "_auth_data_") {
+			components = append([]string{"authData", components[0][len("_auth_data_"):]}, components[1:]...)
+		}
+		parent := object
+		for _, k := range components[:len(components)-1] {
+			if utils.M(parent[k]) == nil {
+				parent[k] = types.M{}
+			}
+			parent = utils.M(parent[k])
+		}
+		err := applyOperation(parent, components[len(components)-1], value)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range []string{"createdAt", "updatedAt"} {
+		if m := utils.M(object[key]); m != nil && utils.S(m["__type"]) == "Date" {
+			object[key] = utils.S(m["iso"])
+		}
+	}
+	return nil
+}
+
+// applyOperation 对 object[key] 执行更新操作
+func applyOperation(object map[string]interface{}, key string, value interface{}) error {
+	op := utils.M(value)
+	if op == nil || op["__op"] == nil {
+		if value == nil {
+			delete(object, key)
+		} else if op != nil && utils.S(op["__type"]) == "Polygon" {
+			// 保存闭合后的多边形
+			vertices, err := storage.ParsePolygon(op)
+			if err != nil {
+				return err
+			}
+			object[key] = storage.PolygonToJSON(vertices)
+		} else if storage.IsBytes(op) {
+			data, err := storage.ParseBytes(op)
+			if err != nil {
+				return err
+			}
+			object[key] = storage.BytesToJSON(data)
+		} else {
+			object[key] = utils.DeepCopy(value)
+		}
+		return nil
+	}
+
+	switch utils.S(op["__op"]) {
+	case "Delete":
+		delete(object, key)
+	case "Increment":
+		amount, ok := toFloat(op["amount"])
+		if ok == false {
+			return errs.E(errs.InvalidJSON, "incrementing must provide a number")
+		}
+		current, _ := toFloat(object[key])
+		object[key] = current + amount
+	case "Add", "AddUnique", "Remove":
+		objects := utils.A(op["objects"])
+		if objects == nil {
+			return errs.E(errs.InvalidJSON, "objects to add must be an array")
+		}
+		array := utils.A(object[key])
+		if array == nil {
+			array = types.S{}
+		}
+		result := types.S{}
+		switch utils.S(op["__op"]) {
+		case "Add":
+			result = append(append(result, array...), utils.CopySlice(objects)...)
+		case "AddUnique":
+			result = append(result, array...)
+			for _, o := range objects {
+				if indexOf(result, o) == -1 {
+					result = append(result, utils.DeepCopy(o))
+				}
+			}
+		case "Remove":
+			for _, v := range array {
+				if indexOf(objects, v) == -1 {
+					result = append(result, v)
+				}
+			}
+		}
+		object[key] = result
+	default:
+		return errs.E(errs.CommandUnavailable, "The "+utils.S(op["__op"])+" operator is not supported yet.")
+	}
+	return nil
+}
+
+// indexOf 查找 value 在数组中的位置
+func indexOf(array []interface{}, value interface{}) int {
+	for i, v := range array {
+		if equal(v, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// toFloat 把数字转换为 float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func marshal(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,249 @@
+package mongo
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Aggregate 对符合条件的对象执行聚合管道
+func (m *MongoAdapter) Aggregate(className string, schema, query types.M, pipeline types.S) ([]types.M, error) {
+	if err := m.contextErr(); err != nil {
+		return nil, err
+	}
+	schema = convertParseSchemaToMongoSchema(schema)
+	mongoWhere, err := m.transform.transformWhere(className, query, schema)
+	if err != nil {
+		return nil, err
+	}
+	mongoPipeline, fields, err := m.transform.transformPipeline(className, pipeline, schema)
+	if err != nil {
+		return nil, err
+	}
+	if len(mongoWhere) > 0 {
+		mongoPipeline = append(types.S{types.M{"$match": mongoWhere}}, mongoPipeline...)
+	}
+
+	coll := m.adaptiveCollection(className)
+	results, err := coll.aggregate(mongoPipeline)
+	if err != nil {
+		return nil, err
+	}
+	transformed := storage.PipelineTransformsObjects(pipeline)
+	objects := []types.M{}
+	for _, result := range results {
+		if transformed {
+			object, err := m.transform.aggregateObjectToParseObject(result, fields)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, object)
+			continue
+		}
+		r, err := m.transform.mongoObjectToParseObject(className, result, schema)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, utils.M(r))
+	}
+	return objects, nil
+}
+
+// Distinct 返回符合条件的对象中 fieldName 字段的不同取值，数组字段取其中的元素
+func (m *MongoAdapter) Distinct(className string, schema, query types.M, fieldName string) (types.S, error) {
+	if err := m.contextErr(); err != nil {
+		return nil, err
+	}
+	schema = convertParseSchemaToMongoSchema(schema)
+	mongoWhere, err := m.transform.transformWhere(className, query, schema)
+	if err != nil {
+		return nil, err
+	}
+	options := types.M{}
+	if maxTimeMS := m.queryMaxTimeMS(); maxTimeMS != 0 {
+		options["maxTimeMS"] = maxTimeMS
+	}
+	coll := m.adaptiveCollection(className)
+	values, err := coll.distinct(mongoWhere, m.transform.transformKey(className, fieldName, schema), options)
+	if err != nil {
+		return nil, err
+	}
+
+	var tp interface{}
+	if fields := utils.M(schema["fields"]); fields != nil {
+		tp = fields[fieldName]
+	}
+	results := types.S{}
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		r, err := m.transform.aggregateValueToParseValue(fieldName, value, tp)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// transformPipeline 把聚合管道转换为数据库格式，同时返回输出对象的字段类型
+// 在 $group 与 $project 之前，字段名按照 transformKey 进行转换，之后的字段名即为输出对象的字段名
+// 输出对象中的 objectId 保存在 _id 中
+func (t *Transform) transformPipeline(className string, pipeline types.S, schema types.M) (types.S, types.M, error) {
+	fields := utils.M(schema["fields"])
+	if fields == nil {
+		fields = types.M{}
+	}
+	raw := true
+	transformKey := func(fieldName string) string {
+		if raw {
+			return t.transformKey(className, fieldName, schema)
+		}
+		if fieldName == "objectId" {
+			return "_id"
+		}
+		return fieldName
+	}
+	reference := func(v interface{}) interface{} {
+		return "$" + transformKey(storage.FieldReference(v))
+	}
+
+	mongoPipeline := types.S{}
+	for _, v := range pipeline {
+		stage := utils.M(v)
+		op, arg := storage.StageOperator(stage)
+		switch op {
+		case "$group":
+			group := utils.M(arg)
+			mongoGroup := types.M{"_id": nil}
+			if keyFields := storage.GroupKeyFields(group["objectId"]); keyFields != nil {
+				key := types.M{}
+				for alias, fieldName := range keyFields {
+					key[alias] = "$" + transformKey(fieldName)
+				}
+				mongoGroup["_id"] = key
+			} else if storage.FieldReference(group["objectId"]) != "" {
+				mongoGroup["_id"] = reference(group["objectId"])
+			}
+			for alias, accumulator := range group {
+				if alias == "objectId" {
+					continue
+				}
+				accumulatorOp, accumulatorArg := storage.Accumulator(accumulator)
+				switch accumulatorOp {
+				case "$sum", "$avg", "$min", "$max":
+				default:
+					return nil, nil, errs.E(errs.InvalidQuery, "Invalid accumulator: "+accumulatorOp)
+				}
+				if storage.FieldReference(accumulatorArg) != "" {
+					accumulatorArg = reference(accumulatorArg)
+				}
+				mongoGroup[alias] = types.M{accumulatorOp: accumulatorArg}
+			}
+			mongoPipeline = append(mongoPipeline, types.M{"$group": mongoGroup})
+		case "$project":
+			mongoProject := types.M{"_id": 0}
+			for alias, ref := range utils.M(arg) {
+				if alias == "objectId" {
+					mongoProject["_id"] = reference(ref)
+				} else {
+					mongoProject[alias] = reference(ref)
+				}
+			}
+			mongoPipeline = append(mongoPipeline, types.M{"$project": mongoProject})
+		case "$match":
+			mongoMatch := types.M{}
+			for fieldName, constraint := range utils.M(arg) {
+				mongoMatch[transformKey(fieldName)] = constraint
+			}
+			mongoPipeline = append(mongoPipeline, types.M{"$match": mongoMatch})
+		case "$sort":
+			mongoSort := bson.D{}
+			for _, key := range storage.SortKeys(arg) {
+				order := 1
+				if strings.HasPrefix(key, "-") {
+					order = -1
+					key = key[1:]
+				}
+				mongoSort = append(mongoSort, bson.DocElem{Name: transformKey(key), Value: order})
+			}
+			mongoPipeline = append(mongoPipeline, types.M{"$sort": mongoSort})
+		case "$skip", "$limit":
+			mongoPipeline = append(mongoPipeline, types.M{op: arg})
+		default:
+			return nil, nil, errs.E(errs.InvalidQuery, "Invalid aggregate stage: "+op)
+		}
+		if op == "$group" || op == "$project" {
+			raw = false
+		}
+		fields = storage.AggregateOutputFields(fields, stage)
+	}
+	return mongoPipeline, fields, nil
+}
+
+// aggregateObjectToParseObject 按照输出字段的类型转换聚合结果， _id 转换为 objectId
+func (t *Transform) aggregateObjectToParseObject(mongoObject, fields types.M) (types.M, error) {
+	object := types.M{}
+	for key, value := range mongoObject {
+		if key == "_id" {
+			key = "objectId"
+		}
+		if value == nil {
+			continue
+		}
+		r, err := t.aggregateValueToParseValue(key, value, fields[key])
+		if err != nil {
+			return nil, err
+		}
+		object[key] = r
+	}
+	return object, nil
+}
+
+// aggregateValueToParseValue 按照字段类型转换数据库中的值
+// Pointer 类型保存为 className$objectId 格式， Date 类型中 createdAt updatedAt 转换为字符串
+func (t *Transform) aggregateValueToParseValue(fieldName string, value interface{}, tp interface{}) (interface{}, error) {
+	if n, ok := value.(int64); ok {
+		return float64(n), nil
+	}
+	fieldType := utils.M(tp)
+	switch utils.S(fieldType["type"]) {
+	case "Pointer":
+		if s, ok := value.(string); ok {
+			if parts := strings.SplitN(s, "$", 2); len(parts) == 2 {
+				return types.M{
+					"__type":    "Pointer",
+					"className": parts[0],
+					"objectId":  parts[1],
+				}, nil
+			}
+		}
+	case "Date":
+		if date, ok := value.(time.Time); ok && (fieldName == "createdAt" || fieldName == "updatedAt") {
+			return utils.TimetoString(date), nil
+		}
+	case "Object":
+		subFields := utils.M(fieldType["fields"])
+		if object := utils.M(value); object != nil && subFields != nil {
+			result := types.M{}
+			for key, v := range object {
+				if v == nil {
+					continue
+				}
+				r, err := t.aggregateValueToParseValue(key, v, subFields[key])
+				if err != nil {
+					return nil, err
+				}
+				result[key] = r
+			}
+			return result, nil
+		}
+	}
+	return t.nestedMongoObjectToNestedParseObject(value)
+}
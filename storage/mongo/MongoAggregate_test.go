@@ -0,0 +1,129 @@
+package mongo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_transformPipeline(t *testing.T) {
+	tf := NewTransform()
+	var pipeline types.S
+	var schema types.M
+	var result types.S
+	var fields types.M
+	var err error
+	var expect types.S
+	var expectFields types.M
+	schema = types.M{
+		"fields": types.M{
+			"objectId":  types.M{"type": "String"},
+			"createdAt": types.M{"type": "Date"},
+			"player":    types.M{"type": "Pointer", "targetClass": "_User"},
+			"points":    types.M{"type": "Number"},
+		},
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$group": types.M{"objectId": "$player", "total": types.M{"$sum": "$points"}, "count": types.M{"$sum": 1}}},
+		types.M{"$match": types.M{"total": types.M{"$gt": 10}}},
+		types.M{"$sort": []string{"-total", "objectId"}},
+		types.M{"$limit": 10},
+	}
+	result, fields, err = tf.transformPipeline("score", pipeline, schema)
+	expect = types.S{
+		types.M{"$group": types.M{"_id": "$_p_player", "total": types.M{"$sum": "$points"}, "count": types.M{"$sum": 1}}},
+		types.M{"$match": types.M{"total": types.M{"$gt": 10}}},
+		types.M{"$sort": bson.D{{Name: "total", Value: -1}, {Name: "_id", Value: 1}}},
+		types.M{"$limit": 10},
+	}
+	expectFields = types.M{
+		"objectId": types.M{"type": "Pointer", "targetClass": "_User"},
+		"total":    types.M{"type": "Number"},
+		"count":    types.M{"type": "Number"},
+	}
+	if err != nil || reflect.DeepEqual(expect, result) == false || reflect.DeepEqual(expectFields, fields) == false {
+		t.Error("expect:", expect, expectFields, "get result:", result, fields, err)
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$group": types.M{"objectId": types.M{"p": "$player", "d": "$createdAt"}, "max": types.M{"$max": "$points"}}},
+	}
+	result, fields, err = tf.transformPipeline("score", pipeline, schema)
+	expect = types.S{
+		types.M{"$group": types.M{"_id": types.M{"p": "$_p_player", "d": "$_created_at"}, "max": types.M{"$max": "$points"}}},
+	}
+	expectFields = types.M{
+		"objectId": types.M{"type": "Object", "fields": types.M{
+			"p": types.M{"type": "Pointer", "targetClass": "_User"},
+			"d": types.M{"type": "Date"},
+		}},
+		"max": types.M{"type": "Number"},
+	}
+	if err != nil || reflect.DeepEqual(expect, result) == false || reflect.DeepEqual(expectFields, fields) == false {
+		t.Error("expect:", expect, expectFields, "get result:", result, fields, err)
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$sort": []string{"createdAt"}},
+		types.M{"$skip": 1},
+		types.M{"$project": types.M{"score": "$points", "date": "$createdAt"}},
+	}
+	result, fields, err = tf.transformPipeline("score", pipeline, schema)
+	expect = types.S{
+		types.M{"$sort": bson.D{{Name: "_created_at", Value: 1}}},
+		types.M{"$skip": 1},
+		types.M{"$project": types.M{"_id": 0, "score": "$points", "date": "$_created_at"}},
+	}
+	expectFields = types.M{
+		"score": types.M{"type": "Number"},
+		"date":  types.M{"type": "Date"},
+	}
+	if err != nil || reflect.DeepEqual(expect, result) == false || reflect.DeepEqual(expectFields, fields) == false {
+		t.Error("expect:", expect, expectFields, "get result:", result, fields, err)
+	}
+	/*************************************************/
+	pipeline = types.S{
+		types.M{"$unwind": "$tags"},
+	}
+	_, _, err = tf.transformPipeline("score", pipeline, schema)
+	if reflect.DeepEqual(errs.E(errs.InvalidQuery, "Invalid aggregate stage: $unwind"), err) == false {
+		t.Error("expect:", "Invalid aggregate stage", "get result:", err)
+	}
+}
+
+func Test_aggregateObjectToParseObject(t *testing.T) {
+	tf := NewTransform()
+	date := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	fields := types.M{
+		"objectId": types.M{"type": "Object", "fields": types.M{
+			"p": types.M{"type": "Pointer", "targetClass": "_User"},
+			"d": types.M{"type": "Date"},
+		}},
+		"count":     types.M{"type": "Number"},
+		"createdAt": types.M{"type": "Date"},
+		"empty":     types.M{"type": "Number"},
+	}
+	object := types.M{
+		"_id":       types.M{"p": "_User$u1", "d": date},
+		"count":     int64(2),
+		"createdAt": date,
+		"empty":     nil,
+	}
+	result, err := tf.aggregateObjectToParseObject(object, fields)
+	expect := types.M{
+		"objectId": types.M{
+			"p": types.M{"__type": "Pointer", "className": "_User", "objectId": "u1"},
+			"d": types.M{"__type": "Date", "iso": "2017-01-01T00:00:00.000Z"},
+		},
+		"count":     2.0,
+		"createdAt": "2017-01-01T00:00:00.000Z",
+	}
+	if err != nil || reflect.DeepEqual(expect, result) == false {
+		t.Error("expect:", expect, "get result:", result, err)
+	}
+}
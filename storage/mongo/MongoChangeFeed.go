@@ -0,0 +1,237 @@
+package mongo
+
+import (
+	"context"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/lfq7413/tomato/dependencies/lru"
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// mongoChangeFeedCollectionName 保存监听位置的表，每个监听者的每个类对应一条记录
+const mongoChangeFeedCollectionName = "_ChangeFeed"
+
+// mongoChangeFeedAwaitMS 每次获取变更时最长等待的时间，同时也是响应 ctx 取消的最长时间
+const mongoChangeFeedAwaitMS = 1000
+
+// mongoChangeFeedCacheSize 缓存最近收到的对象的数量
+const mongoChangeFeedCacheSize = 10000
+
+// change stream 的位置已经失效时的错误码，此时只能从当前位置重新开始监听
+var mongoChangeStreamLostCodes = map[int]bool{
+	260: true, // InvalidResumeToken
+	280: true, // ChangeStreamFatalError
+	286: true, // ChangeStreamHistoryLost
+}
+
+var errChangeStreamInvalidated = errs.E(errs.InternalServerError, "Change stream invalidated.")
+
+// mongoChangeEvent change stream 返回的变更
+type mongoChangeEvent struct {
+	ID            bson.Raw `bson:"_id"`
+	OperationType string   `bson:"operationType"`
+	FullDocument  types.M  `bson:"fullDocument"`
+	DocumentKey   types.M  `bson:"documentKey"`
+}
+
+// mongoChangeCursor change stream 游标的返回结果
+type mongoChangeCursor struct {
+	Cursor struct {
+		ID         int64      `bson:"id"`
+		FirstBatch []bson.Raw `bson:"firstBatch"`
+		NextBatch  []bson.Raw `bson:"nextBatch"`
+	} `bson:"cursor"`
+}
+
+// WatchChanges 使用 change stream 监听 classNames 对应的表，需要 MongoDB 3.6 及以上的副本集
+// 每个类使用单独的 change stream ，位置为 resume token ，保存在 _ChangeFeed 表中
+// change stream 中没有修改前的对象， Original 取自最近一次收到的同一对象，没有收到过时为空
+// 删除时 Object 同样取自最近一次收到的对象，没有收到过时仅包含 objectId
+func (m *MongoAdapter) WatchChanges(ctx context.Context, name string, classNames []string, handler func(*storage.ChangeEvent) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 所有类的变更依次交给 handler 处理
+	var mu sync.Mutex
+	objects := lru.New(mongoChangeFeedCacheSize)
+	handle := func(event *storage.ChangeEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		key := event.ClassName + ":" + utils.S(event.Object["objectId"])
+		if v, ok := objects.Get(key); ok {
+			if event.Type == storage.ChangeDelete {
+				event.Object = utils.CopyMapM(v.(types.M))
+			} else {
+				event.Original = utils.CopyMapM(v.(types.M))
+			}
+		}
+		if event.Type == storage.ChangeSave {
+			objects.Add(key, utils.CopyMapM(event.Object))
+		}
+		return handler(event)
+	}
+
+	errc := make(chan error, len(classNames))
+	for _, className := range classNames {
+		go func(className string) {
+			errc <- m.watchClass(ctx, name, className, handle)
+		}(className)
+	}
+	var err error
+	for range classNames {
+		if e := <-errc; e != nil && err == nil {
+			err = e
+			cancel()
+		}
+	}
+	return err
+}
+
+// watchClass 监听单个类的变更，使用独立的连接，避免等待变更时阻塞其他操作
+func (m *MongoAdapter) watchClass(ctx context.Context, name, className string, handler func(*storage.ChangeEvent) error) error {
+	session := m.db.Session.Copy()
+	defer session.Close()
+	db := m.db.With(session)
+	positions := db.C(m.collectionPrefix + mongoChangeFeedCollectionName)
+	positionID := name + ":" + className
+	collectionName := m.collectionPrefix + className
+
+	stage := bson.M{"fullDocument": "updateLookup"}
+	var saved struct {
+		Position bson.Raw `bson:"position"`
+	}
+	err := positions.FindId(positionID).One(&saved)
+	if err == nil && saved.Position.Kind != 0 {
+		stage["resumeAfter"] = saved.Position
+	} else if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+
+	var result mongoChangeCursor
+	err = db.Run(bson.D{
+		{Name: "aggregate", Value: collectionName},
+		{Name: "pipeline", Value: []bson.M{{"$changeStream": stage}}},
+		{Name: "cursor", Value: bson.M{}},
+	}, &result)
+	if err != nil {
+		return m.changeStreamError(positions, positionID, err)
+	}
+	cursorID := result.Cursor.ID
+	defer func() {
+		if cursorID != 0 {
+			db.Run(bson.D{{Name: "killCursors", Value: collectionName}, {Name: "cursors", Value: []int64{cursorID}}}, nil)
+		}
+	}()
+
+	batch := result.Cursor.FirstBatch
+	for {
+		if len(batch) > 0 {
+			position, err := m.handleChanges(className, batch, handler)
+			if position.Kind != 0 {
+				_, e := positions.UpsertId(positionID, bson.M{"$set": bson.M{"position": position, "updatedAt": time.Now()}})
+				if err == nil {
+					err = e
+				}
+			}
+			if err == errChangeStreamInvalidated {
+				// 表被删除或者重命名后 change stream 失效，需要从当前位置重新开始监听
+				positions.RemoveId(positionID)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if cursorID == 0 {
+			return errChangeStreamInvalidated
+		}
+		result = mongoChangeCursor{}
+		err = db.Run(bson.D{
+			{Name: "getMore", Value: cursorID},
+			{Name: "collection", Value: collectionName},
+			{Name: "maxTimeMS", Value: mongoChangeFeedAwaitMS},
+		}, &result)
+		if err != nil {
+			return m.changeStreamError(positions, positionID, err)
+		}
+		cursorID = result.Cursor.ID
+		batch = result.Cursor.NextBatch
+	}
+}
+
+// handleChanges 依次处理一批变更，返回最后一个处理成功的变更的位置
+func (m *MongoAdapter) handleChanges(className string, batch []bson.Raw, handler func(*storage.ChangeEvent) error) (bson.Raw, error) {
+	var position bson.Raw
+	schema, err := m.GetClass(className)
+	if err != nil {
+		return position, err
+	}
+	schema = convertParseSchemaToMongoSchema(schema)
+	for _, raw := range batch {
+		var change mongoChangeEvent
+		err := raw.Unmarshal(&change)
+		if err != nil {
+			return position, err
+		}
+		if change.OperationType == "invalidate" {
+			return position, errChangeStreamInvalidated
+		}
+		event, err := m.changeEvent(className, schema, &change)
+		if err != nil {
+			return position, err
+		}
+		if event != nil {
+			err = handler(event)
+			if err != nil {
+				return position, err
+			}
+		}
+		position = change.ID
+	}
+	return position, nil
+}
+
+// changeEvent 把 change stream 返回的变更转换为 ChangeEvent ，不需要处理的变更返回 nil
+func (m *MongoAdapter) changeEvent(className string, schema types.M, change *mongoChangeEvent) (*storage.ChangeEvent, error) {
+	event := &storage.ChangeEvent{
+		ClassName: className,
+		Position:  base64.RawURLEncoding.EncodeToString(change.ID.Data),
+	}
+	switch change.OperationType {
+	case "insert", "update", "replace":
+		if change.FullDocument == nil {
+			// 更新之后对象已经被删除
+			return nil, nil
+		}
+		object, err := m.transform.mongoObjectToParseObject(className, change.FullDocument, schema)
+		if err != nil {
+			return nil, err
+		}
+		event.Type = storage.ChangeSave
+		event.Object = utils.M(object)
+	case "delete":
+		event.Type = storage.ChangeDelete
+		event.Object = types.M{"objectId": change.DocumentKey["_id"]}
+	default:
+		return nil, nil
+	}
+	return event, nil
+}
+
+// changeStreamError 位置已经失效时删除保存的位置，以便再次监听时从当前位置开始
+func (m *MongoAdapter) changeStreamError(positions *mgo.Collection, positionID string, err error) error {
+	if e, ok := err.(*mgo.QueryError); ok && mongoChangeStreamLostCodes[e.Code] {
+		positions.RemoveId(positionID)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package mongo
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_changeEvent(t *testing.T) {
+	m := NewMongoAdapter("tomato", nil)
+	schema := convertParseSchemaToMongoSchema(types.M{
+		"fields": types.M{
+			"title": types.M{"type": "String"},
+			"owner": types.M{"type": "Pointer", "targetClass": "_User"},
+		},
+	})
+	tests := []struct {
+		name   string
+		change bson.M
+		want   *storage.ChangeEvent
+	}{
+		{
+			name: "1",
+			change: bson.M{
+				"_id":           bson.M{"_data": "01"},
+				"operationType": "insert",
+				"fullDocument":  bson.M{"_id": "01", "title": "a", "_p_owner": "_User$u1", "_rperm": []string{"*"}},
+				"documentKey":   bson.M{"_id": "01"},
+			},
+			want: &storage.ChangeEvent{
+				ClassName: "post",
+				Type:      storage.ChangeSave,
+				Object: types.M{
+					"objectId": "01",
+					"title":    "a",
+					"owner":    types.M{"__type": "Pointer", "className": "_User", "objectId": "u1"},
+					"_rperm":   []interface{}{"*"},
+				},
+			},
+		},
+		{
+			name: "2",
+			change: bson.M{
+				"_id":           bson.M{"_data": "02"},
+				"operationType": "delete",
+				"documentKey":   bson.M{"_id": "01"},
+			},
+			want: &storage.ChangeEvent{
+				ClassName: "post",
+				Type:      storage.ChangeDelete,
+				Object:    types.M{"objectId": "01"},
+			},
+		},
+		{
+			name: "3",
+			change: bson.M{
+				"_id":           bson.M{"_data": "03"},
+				"operationType": "update",
+				"documentKey":   bson.M{"_id": "01"},
+			},
+			want: nil,
+		},
+		{
+			name: "4",
+			change: bson.M{
+				"_id":           bson.M{"_data": "04"},
+				"operationType": "drop",
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		data, _ := bson.Marshal(tt.change)
+		var change mongoChangeEvent
+		err := bson.Unmarshal(data, &change)
+		if err != nil {
+			t.Errorf("%q. bson.Unmarshal() error = %v", tt.name, err)
+			continue
+		}
+		got, err := m.changeEvent("post", schema, &change)
+		if err != nil {
+			t.Errorf("%q. MongoAdapter.changeEvent() error = %v", tt.name, err)
+			continue
+		}
+		if got != nil {
+			if got.Position == "" {
+				t.Errorf("%q. MongoAdapter.changeEvent() Position is empty", tt.name)
+			}
+			got.Position = ""
+		}
+		if reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%q. MongoAdapter.changeEvent() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
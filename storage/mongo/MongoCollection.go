@@ -67,6 +67,23 @@ func (m *MongoCollection) find(query interface{}, options types.M) ([]types.M, e
 
 // rawFind 执行原始查找操作，查找选项包括 sort、skip、limit、keys、maxTimeMS
 func (m *MongoCollection) rawFind(query interface{}, options types.M) ([]types.M, error) {
+	var result []types.M
+	err := m.findQuery(query, options).All(&result)
+	return result, err
+}
+
+// explain 返回查找操作的执行计划，查找选项与 rawFind 相同
+func (m *MongoCollection) explain(query interface{}, options types.M) (types.M, error) {
+	result := types.M{}
+	err := m.findQuery(query, options).Explain(&result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// findQuery 按照查找选项组装查询
+func (m *MongoCollection) findQuery(query interface{}, options types.M) *mgo.Query {
 	if options == nil {
 		options = types.M{}
 	}
@@ -100,13 +117,11 @@ func (m *MongoCollection) rawFind(query interface{}, options types.M) ([]types.M
 			q = q.SetMaxTime(time.Duration(limit) * time.Millisecond)
 		}
 	}
-	var result []types.M
-	err := q.All(&result)
-	return result, err
+	return q
 }
 
 // count 执行 count 操作，查找选项包括 sort、skip、limit、maxTimeMS
-func (m *MongoCollection) count(query interface{}, options types.M) int {
+func (m *MongoCollection) count(query interface{}, options types.M) (int, error) {
 	if options == nil {
 		options = types.M{}
 	}
@@ -137,11 +152,41 @@ func (m *MongoCollection) count(query interface{}, options types.M) int {
 			q = q.SetMaxTime(time.Duration(limit) * time.Millisecond)
 		}
 	}
-	n, err := q.Count()
+	return q.Count()
+}
+
+// aggregate 执行聚合管道
+func (m *MongoCollection) aggregate(pipeline interface{}) ([]types.M, error) {
+	var result []types.M
+	err := m.collection.Pipe(pipeline).AllowDiskUse().All(&result)
 	if err != nil {
-		return 0
+		return nil, err
+	}
+	if result == nil {
+		return []types.M{}, nil
 	}
-	return n
+	return result, nil
+}
+
+// distinct 查找 key 对应的不同取值，查找选项包括 maxTimeMS
+func (m *MongoCollection) distinct(query interface{}, key string, options types.M) ([]interface{}, error) {
+	if options == nil {
+		options = types.M{}
+	}
+	q := m.collection.Find(query)
+	if options["maxTimeMS"] != nil {
+		if limit, ok := options["maxTimeMS"].(float64); ok {
+			q = q.SetMaxTime(time.Duration(limit) * time.Millisecond)
+		} else if limit, ok := options["maxTimeMS"].(int); ok {
+			q = q.SetMaxTime(time.Duration(limit) * time.Millisecond)
+		}
+	}
+	var result []interface{}
+	err := q.Distinct(key, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 // findOneAndUpdate 查找并更新一个对象，返回更新后的对象
@@ -173,6 +218,31 @@ func (m *MongoCollection) insertOne(docs interface{}) error {
 	return nil
 }
 
+// insertMany 按顺序批量插入对象，出现错误时停止插入，并返回已经插入的对象数量
+// 无法确定已经插入的数量时（如网络错误）返回 -1
+func (m *MongoCollection) insertMany(docs []interface{}) (int, error) {
+	bulk := m.collection.Bulk()
+	bulk.Insert(docs...)
+	_, err := bulk.Run()
+	if err == nil {
+		return len(docs), nil
+	}
+	inserted := -1
+	if e, ok := err.(*mgo.BulkError); ok {
+		for _, c := range e.Cases() {
+			if c.Index >= 0 {
+				inserted = c.Index
+				break
+			}
+		}
+	}
+	// 键值重复错误单独处理
+	if strings.Index(err.Error(), "duplicate key error") > -1 {
+		return inserted, errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
+	}
+	return inserted, err
+}
+
 // upsertOne 更新一个对象，如果要更新的对象不存在，则插入该对象
 func (m *MongoCollection) upsertOne(selector interface{}, update interface{}) error {
 	_, err := m.collection.Upsert(selector, update)
@@ -220,3 +290,17 @@ func (m *MongoCollection) ensureSparseUniqueIndexInBackground(indexRequest []str
 	}
 	return m.collection.EnsureIndex(index)
 }
+
+// ensureIndex 创建索引
+func (m *MongoCollection) ensureIndex(index mgo.Index) error {
+	return m.collection.EnsureIndex(index)
+}
+
+// dropIndex 删除指定名称的索引，索引不存在时忽略
+func (m *MongoCollection) dropIndex(name string) error {
+	err := m.collection.DropIndexName(name)
+	if err != nil && strings.Contains(err.Error(), "index not found") {
+		return nil
+	}
+	return err
+}
@@ -510,21 +510,21 @@ func Test_count(t *testing.T) {
 	docs = types.M{"_id": "003", "name": "joe", "age": 31}
 	mc.insertOne(docs)
 	selector = types.M{"name": "joe"}
-	count = mc.count(selector, nil)
+	count, _ = mc.count(selector, nil)
 	expect = 2
 	if count != expect {
 		t.Error("expect:", expect, "get result:", count)
 	}
 	/********************************************************/
 	selector = types.M{"name": "jack"}
-	count = mc.count(selector, nil)
+	count, _ = mc.count(selector, nil)
 	expect = 1
 	if count != expect {
 		t.Error("expect:", expect, "get result:", count)
 	}
 	/********************************************************/
 	selector = types.M{"name": "tom"}
-	count = mc.count(selector, nil)
+	count, _ = mc.count(selector, nil)
 	expect = 0
 	if count != expect {
 		t.Error("expect:", expect, "get result:", count)
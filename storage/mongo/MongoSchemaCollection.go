@@ -199,6 +199,10 @@ func mongoFieldToParseSchemaField(t string) types.M {
 		return types.M{
 			"type": "GeoPoint",
 		}
+	case "polygon":
+		return types.M{
+			"type": "Polygon",
+		}
 	case "file":
 		return types.M{
 			"type": "File",
@@ -291,11 +295,20 @@ func mongoSchemaToParseSchema(schema types.M) types.M {
 		}
 	}
 
-	return types.M{
+	result := types.M{
 		"className":             schema["_id"],
 		"fields":                mongoSchemaFieldsToParseSchemaFields(schema),
 		"classLevelPermissions": clps,
 	}
+	if metadata := utils.M(schema["_metadata"]); metadata != nil {
+		if indexes := utils.M(metadata["indexes"]); len(indexes) > 0 {
+			result["indexes"] = types.M(indexes)
+		}
+		if options := utils.M(metadata["options"]); len(options) > 0 {
+			result["options"] = types.M(options)
+		}
+	}
+	return result
 }
 
 // parseFieldTypeToMongoFieldType 返回数据库中存储的字段类型
@@ -327,8 +340,12 @@ func parseFieldTypeToMongoFieldType(t types.M) string {
 		return "array"
 	case "GeoPoint":
 		return "geopoint"
+	case "Polygon":
+		return "polygon"
 	case "File":
 		return "file"
+	case "Bytes":
+		return "bytes"
 	default:
 		return ""
 	}
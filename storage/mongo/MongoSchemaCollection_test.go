@@ -1124,6 +1124,41 @@ func Test_mongoSchemaToParseSchema(t *testing.T) {
 	if reflect.DeepEqual(expect, result) == false {
 		t.Error("expect:", expect, "result:", result)
 	}
+	/*****************************************************/
+	schema = types.M{
+		"_id":  "user",
+		"name": "string",
+		"_metadata": types.M{
+			"indexes": types.M{
+				"name": types.M{"keys": types.S{"name"}},
+			},
+		},
+	}
+	result = mongoSchemaToParseSchema(schema)
+	expect = types.M{
+		"className": "user",
+		"fields": types.M{
+			"name":      types.M{"type": "String"},
+			"ACL":       types.M{"type": "ACL"},
+			"createdAt": types.M{"type": "Date"},
+			"updatedAt": types.M{"type": "Date"},
+			"objectId":  types.M{"type": "String"},
+		},
+		"classLevelPermissions": types.M{
+			"find":     types.M{"*": true},
+			"get":      types.M{"*": true},
+			"create":   types.M{"*": true},
+			"update":   types.M{"*": true},
+			"delete":   types.M{"*": true},
+			"addField": types.M{"*": true},
+		},
+		"indexes": types.M{
+			"name": types.M{"keys": types.S{"name"}},
+		},
+	}
+	if reflect.DeepEqual(expect, result) == false {
+		t.Error("expect:", expect, "result:", result)
+	}
 }
 
 func Test_parseFieldTypeToMongoFieldType(t *testing.T) {
@@ -1255,6 +1290,15 @@ func Test_parseFieldTypeToMongoFieldType(t *testing.T) {
 		t.Error("expect:", expect, "result:", result)
 	}
 	/*****************************************************/
+	fieldType = types.M{
+		"type": "Bytes",
+	}
+	result = parseFieldTypeToMongoFieldType(fieldType)
+	expect = "bytes"
+	if result != expect {
+		t.Error("expect:", expect, "result:", result)
+	}
+	/*****************************************************/
 	fieldType = types.M{
 		"type": "Other",
 	}
@@ -1,10 +1,13 @@
 package mongo
 
 import (
+	"context"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
 	"github.com/lfq7413/tomato/types"
 	"github.com/lfq7413/tomato/utils"
 
@@ -13,13 +16,17 @@ import (
 
 const mongoSchemaCollectionName = "_SCHEMA"
 
+// 查询超过 maxTimeMS 时的错误码 MaxTimeMSExpired
+const mongoMaxTimeMSExpiredError = 50
+
 // MongoAdapter mongo 数据库适配器
 type MongoAdapter struct {
 	collectionPrefix string
 	collectionList   []string
 	db               *mgo.Database
 	transform        *Transform
-	maxTimeMS        int // 单次查询最大时间，以毫秒为单位
+	maxTimeMS        int             // 单次查询最大时间，以毫秒为单位
+	ctx              context.Context // 不为空时，在 ctx 取消或超时后不再执行新的操作
 }
 
 // NewMongoAdapter ...
@@ -43,6 +50,44 @@ func (m *MongoAdapter) adaptiveCollection(name string) *MongoCollection {
 	return newMongoCollection(rawCollection)
 }
 
+// WithContext 返回在 ctx 中执行操作的适配器，处于事务中时共用同一个事务
+// mgo 不支持 context ，每个操作开始前检查 ctx 是否已经取消或超时，
+// ctx 设置了截止时间时，查询的 maxTimeMS 不超过剩余时间，由数据库中止超时的查询
+func (m *MongoAdapter) WithContext(ctx context.Context) storage.Adapter {
+	adapter := *m
+	adapter.ctx = ctx
+	return &adapter
+}
+
+// contextErr 返回 ctx 被取消或超时的原因
+func (m *MongoAdapter) contextErr() error {
+	if m.ctx == nil {
+		return nil
+	}
+	return m.ctx.Err()
+}
+
+// queryMaxTimeMS 返回单次查询的最大时间，取 maxTimeMS 、 ctx 中设置的查询时间与 ctx 剩余时间中最小的一个，为 0 时表示不限制
+func (m *MongoAdapter) queryMaxTimeMS() int {
+	maxTimeMS := m.maxTimeMS
+	if m.ctx == nil {
+		return maxTimeMS
+	}
+	if timeout := storage.QueryTimeout(m.ctx); timeout > 0 && (maxTimeMS == 0 || timeout < maxTimeMS) {
+		maxTimeMS = timeout
+	}
+	if deadline, ok := m.ctx.Deadline(); ok {
+		remaining := int(time.Until(deadline) / time.Millisecond)
+		if remaining < 1 {
+			remaining = 1
+		}
+		if maxTimeMS == 0 || remaining < maxTimeMS {
+			maxTimeMS = remaining
+		}
+	}
+	return maxTimeMS
+}
+
 // schemaCollection 组装 _SCHEMA 表操作对象
 func (m *MongoAdapter) schemaCollection() *MongoSchemaCollection {
 	collection := m.adaptiveCollection(mongoSchemaCollectionName)
@@ -74,11 +119,24 @@ func (m *MongoAdapter) ClassExists(name string) bool {
 // SetClassLevelPermissions 设置类级别权限
 func (m *MongoAdapter) SetClassLevelPermissions(className string, CLPs types.M) error {
 	schemaCollection := m.schemaCollection()
+	// 只更新 class_permissions ，保留 _metadata 中的索引定义
+	update := types.M{
+		"$set": types.M{
+			"_metadata.class_permissions": CLPs,
+		},
+	}
+	return schemaCollection.updateSchema(className, update)
+}
+
+// SetClassOptions 设置类的选项，保存在 _metadata.options 中
+func (m *MongoAdapter) SetClassOptions(className string, options types.M) error {
+	schemaCollection := m.schemaCollection()
+	if options == nil {
+		options = types.M{}
+	}
 	update := types.M{
 		"$set": types.M{
-			"_metadata": types.M{
-				"class_permissions": CLPs,
-			},
+			"_metadata.options": options,
 		},
 	}
 	return schemaCollection.updateSchema(className, update)
@@ -93,6 +151,15 @@ func (m *MongoAdapter) CreateClass(className string, schema types.M) (types.M, e
 	}
 	mongoObject := mongoSchemaFromFieldsAndClassNameAndCLP(utils.M(schema["fields"]), className, utils.M(schema["classLevelPermissions"]))
 	mongoObject["_id"] = className
+	indexes := utils.M(schema["indexes"])
+	if len(indexes) > 0 {
+		metadata := utils.M(mongoObject["_metadata"])
+		if metadata == nil {
+			metadata = types.M{}
+			mongoObject["_metadata"] = metadata
+		}
+		metadata["indexes"] = indexes
+	}
 
 	schemaCollection := m.schemaCollection()
 	// 处理 insertOne 失败的情况，数据库插入失败，检测是否是因为键值重复造成的错误
@@ -104,6 +171,13 @@ func (m *MongoAdapter) CreateClass(className string, schema types.M) (types.M, e
 		return nil, err
 	}
 
+	for indexName, v := range indexes {
+		err = m.adaptiveCollection(className).ensureIndex(m.mongoIndex(className, schema, indexName, utils.M(v)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return mongoSchemaToParseSchema(mongoObject), nil
 }
 
@@ -192,6 +266,9 @@ func (m *MongoAdapter) DeleteFields(className string, schema types.M, fieldNames
 
 // CreateObject 创建对象
 func (m *MongoAdapter) CreateObject(className string, schema, object types.M) error {
+	if err := m.contextErr(); err != nil {
+		return err
+	}
 	schema = convertParseSchemaToMongoSchema(schema)
 	mongoObject, err := m.transform.parseObjectToMongoObjectForCreate(className, object, schema)
 	if err != nil {
@@ -201,6 +278,44 @@ func (m *MongoAdapter) CreateObject(className string, schema, object types.M) er
 	return coll.insertOne(mongoObject)
 }
 
+// CreateObjects 批量创建对象，使用一次 insertMany 插入所有对象
+// 出错时删除已经插入的对象，无法确定已经插入的对象或者删除失败时返回 storage.ErrBulkCreateIncomplete
+func (m *MongoAdapter) CreateObjects(className string, schema types.M, objects []types.M) error {
+	if err := m.contextErr(); err != nil {
+		return err
+	}
+	schema = convertParseSchemaToMongoSchema(schema)
+	docs := []interface{}{}
+	for _, object := range objects {
+		mongoObject, err := m.transform.parseObjectToMongoObjectForCreate(className, object, schema)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, mongoObject)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	coll := m.adaptiveCollection(className)
+	inserted, err := coll.insertMany(docs)
+	if err == nil {
+		return nil
+	}
+	if inserted < 0 {
+		return storage.ErrBulkCreateIncomplete
+	}
+	ids := []interface{}{}
+	for _, doc := range docs[:inserted] {
+		ids = append(ids, doc.(types.M)["_id"])
+	}
+	if len(ids) > 0 {
+		if _, e := coll.collection.RemoveAll(types.M{"_id": types.M{"$in": ids}}); e != nil {
+			return storage.ErrBulkCreateIncomplete
+		}
+	}
+	return err
+}
+
 // GetClass ...
 func (m *MongoAdapter) GetClass(className string) (types.M, error) {
 	return m.schemaCollection().findSchema(className)
@@ -228,6 +343,9 @@ func (m *MongoAdapter) getCollectionNames() []string {
 
 // DeleteObjectsByQuery 删除符合条件的所有对象
 func (m *MongoAdapter) DeleteObjectsByQuery(className string, schema, query types.M) error {
+	if err := m.contextErr(); err != nil {
+		return err
+	}
 	schema = convertParseSchemaToMongoSchema(schema)
 	collection := m.adaptiveCollection(className)
 
@@ -249,6 +367,9 @@ func (m *MongoAdapter) DeleteObjectsByQuery(className string, schema, query type
 
 // UpdateObjectsByQuery ...
 func (m *MongoAdapter) UpdateObjectsByQuery(className string, schema, query, update types.M) error {
+	if err := m.contextErr(); err != nil {
+		return err
+	}
 	schema = convertParseSchemaToMongoSchema(schema)
 	mongoUpdate, err := m.transform.transformUpdate(className, update, schema)
 	if err != nil {
@@ -264,6 +385,9 @@ func (m *MongoAdapter) UpdateObjectsByQuery(className string, schema, query, upd
 
 // FindOneAndUpdate ...
 func (m *MongoAdapter) FindOneAndUpdate(className string, schema, query, update types.M) (types.M, error) {
+	if err := m.contextErr(); err != nil {
+		return nil, err
+	}
 	schema = convertParseSchemaToMongoSchema(schema)
 	mongoUpdate, err := m.transform.transformUpdate(className, update, schema)
 	if err != nil {
@@ -284,6 +408,9 @@ func (m *MongoAdapter) FindOneAndUpdate(className string, schema, query, update
 
 // UpsertOneObject ...
 func (m *MongoAdapter) UpsertOneObject(className string, schema, query, update types.M) error {
+	if err := m.contextErr(); err != nil {
+		return err
+	}
 	schema = convertParseSchemaToMongoSchema(schema)
 	mongoUpdate, err := m.transform.transformUpdate(className, update, schema)
 	if err != nil {
@@ -299,14 +426,45 @@ func (m *MongoAdapter) UpsertOneObject(className string, schema, query, update t
 
 // Find ...
 func (m *MongoAdapter) Find(className string, schema, query, options types.M) ([]types.M, error) {
+	if err := m.contextErr(); err != nil {
+		return nil, err
+	}
 	if options == nil {
 		options = types.M{}
 	}
+	m.createTextIndexesIfNeeded(className, schema, query)
 	schema = convertParseSchemaToMongoSchema(schema)
 	mongoWhere, err := m.transform.transformWhere(className, query, schema)
 	if err != nil {
 		return nil, err
 	}
+	sortByScore, selectScore := m.transformFindOptions(className, schema, options)
+
+	coll := m.adaptiveCollection(className)
+	results, err := coll.find(mongoWhere, options)
+	if err != nil {
+		return nil, queryTimeoutError(err)
+	}
+	objects := []types.M{}
+	for _, result := range results {
+		if sortByScore && selectScore == false {
+			// 未要求返回相关度时，删除仅用于排序的相关度
+			if fields := utils.M(schema["fields"]); fields == nil || fields[storage.TextScoreField] == nil {
+				delete(result, storage.TextScoreField)
+			}
+		}
+		r, err := m.transform.mongoObjectToParseObject(className, result, schema)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, utils.M(r))
+	}
+	return objects, nil
+}
+
+// transformFindOptions 把查找选项中的 sort keys 转换为数据库格式
+// 按相关度排序时，需要在返回的字段中包含相关度
+func (m *MongoAdapter) transformFindOptions(className string, schema, options types.M) (sortByScore, selectScore bool) {
 	if _, ok := options["sort"]; ok {
 		if keys, ok := options["sort"].([]string); ok {
 			mongoSort := []string{}
@@ -319,6 +477,12 @@ func (m *MongoAdapter) Find(className string, schema, query, options types.M) ([
 					key = key[1:]
 				}
 
+				if key == storage.TextScoreKey {
+					sortByScore = true
+					mongoSort = append(mongoSort, "$textScore:"+storage.TextScoreField)
+					continue
+				}
+
 				mongoKey = prefix + m.transform.transformKey(className, key, schema)
 				mongoSort = append(mongoSort, mongoKey)
 			}
@@ -331,6 +495,10 @@ func (m *MongoAdapter) Find(className string, schema, query, options types.M) ([
 		if keys, ok := options["keys"].([]string); ok {
 			mongoKeys := types.M{}
 			for _, key := range keys {
+				if key == storage.TextScoreKey {
+					selectScore = true
+					continue
+				}
 				mongoKey := m.transform.transformKey(className, key, schema)
 				mongoKeys[mongoKey] = 1
 			}
@@ -339,38 +507,91 @@ func (m *MongoAdapter) Find(className string, schema, query, options types.M) ([
 			delete(options, "keys")
 		}
 	}
-	if m.maxTimeMS != 0 {
-		options["maxTimeMS"] = m.maxTimeMS
+	if sortByScore || selectScore {
+		mongoKeys := utils.M(options["keys"])
+		if mongoKeys == nil {
+			mongoKeys = types.M{}
+		}
+		mongoKeys[storage.TextScoreField] = types.M{"$meta": "textScore"}
+		options["keys"] = mongoKeys
+	}
+	if maxTimeMS := m.queryMaxTimeMS(); maxTimeMS != 0 {
+		options["maxTimeMS"] = maxTimeMS
+	}
+	return sortByScore, selectScore
+}
+
+// Explain 返回转换后的查询条件、查找选项以及数据库的执行计划
+func (m *MongoAdapter) Explain(className string, schema, query, options types.M) (types.M, error) {
+	if err := m.contextErr(); err != nil {
+		return nil, err
+	}
+	if options == nil {
+		options = types.M{}
+	}
+	m.createTextIndexesIfNeeded(className, schema, query)
+	schema = convertParseSchemaToMongoSchema(schema)
+	mongoWhere, err := m.transform.transformWhere(className, query, schema)
+	if err != nil {
+		return nil, err
 	}
+	m.transformFindOptions(className, schema, options)
 
 	coll := m.adaptiveCollection(className)
-	results, err := coll.find(mongoWhere, options)
+	plan, err := coll.explain(mongoWhere, options)
 	if err != nil {
 		return nil, err
 	}
-	objects := []types.M{}
-	for _, result := range results {
-		r, err := m.transform.mongoObjectToParseObject(className, result, schema)
-		if err != nil {
-			return nil, err
+	explain := types.M{
+		"filter": mongoWhere,
+		"plan":   plan,
+	}
+	for _, key := range []string{"sort", "keys", "skip", "limit"} {
+		if options[key] != nil {
+			explain[key] = options[key]
 		}
-		objects = append(objects, utils.M(r))
 	}
-	return objects, nil
+	return explain, nil
+}
+
+// createTextIndexesIfNeeded 查询条件中包含 $text 且类中还没有全文索引时，为该字段创建全文索引
+// MongoDB 中每个集合只能有一个全文索引， $text 在全文索引包含的所有字段中检索
+func (m *MongoAdapter) createTextIndexesIfNeeded(className string, schema, query types.M) {
+	for fieldName, v := range query {
+		if constraint := utils.M(v); constraint == nil || constraint["$text"] == nil {
+			continue
+		}
+		for _, index := range utils.M(schema["indexes"]) {
+			if storage.IndexIsText(utils.M(index)) {
+				return
+			}
+		}
+		index := types.M{
+			"keys": types.S{fieldName},
+			"text": true,
+		}
+		// 创建失败时（例如集合中已有未记录在 _SCHEMA 中的全文索引）继续执行查询，由数据库返回错误
+		m.CreateIndex(className, schema, fieldName+"_text", index)
+		return
+	}
 }
 
 // rawFind 仅用于测试
 func (m *MongoAdapter) rawFind(className string, query types.M) ([]types.M, error) {
 	coll := m.adaptiveCollection(className)
 	options := types.M{}
-	if m.maxTimeMS != 0 {
-		options["maxTimeMS"] = m.maxTimeMS
+	if maxTimeMS := m.queryMaxTimeMS(); maxTimeMS != 0 {
+		options["maxTimeMS"] = maxTimeMS
 	}
 	return coll.find(query, options)
 }
 
 // Count ...
 func (m *MongoAdapter) Count(className string, schema, query types.M) (int, error) {
+	if err := m.contextErr(); err != nil {
+		return 0, err
+	}
+	m.createTextIndexesIfNeeded(className, schema, query)
 	schema = convertParseSchemaToMongoSchema(schema)
 	coll := m.adaptiveCollection(className)
 	mongoWhere, err := m.transform.transformWhere(className, query, schema)
@@ -378,13 +599,24 @@ func (m *MongoAdapter) Count(className string, schema, query types.M) (int, erro
 		return 0, err
 	}
 	options := types.M{}
-	if m.maxTimeMS != 0 {
-		options["maxTimeMS"] = m.maxTimeMS
+	if maxTimeMS := m.queryMaxTimeMS(); maxTimeMS != 0 {
+		options["maxTimeMS"] = maxTimeMS
+	}
+	c, err := coll.count(mongoWhere, options)
+	if err != nil {
+		return 0, queryTimeoutError(err)
 	}
-	c := coll.count(mongoWhere, options)
 	return c, nil
 }
 
+// queryTimeoutError 查询超过 maxTimeMS 时转换为 storage.ErrQueryTimeout ，其他错误原样返回
+func queryTimeoutError(err error) error {
+	if e, ok := err.(*mgo.QueryError); ok && e.Code == mongoMaxTimeMSExpiredError {
+		return storage.ErrQueryTimeout
+	}
+	return err
+}
+
 // EnsureUniqueness 创建索引
 func (m *MongoAdapter) EnsureUniqueness(className string, schema types.M, fieldNames []string) error {
 	schema = convertParseSchemaToMongoSchema(schema)
@@ -401,6 +633,80 @@ func (m *MongoAdapter) EnsureUniqueness(className string, schema types.M, fieldN
 	return err
 }
 
+// CreateIndex 创建索引，并把索引定义保存到 _SCHEMA 的 _metadata.indexes 中
+func (m *MongoAdapter) CreateIndex(className string, schema types.M, indexName string, index types.M) error {
+	schema = convertParseSchemaToMongoSchema(schema)
+	err := m.adaptiveCollection(className).ensureIndex(m.mongoIndex(className, schema, indexName, index))
+	if err != nil {
+		return err
+	}
+	update := types.M{
+		"$set": types.M{
+			"_metadata.indexes." + indexName: index,
+		},
+	}
+	return m.schemaCollection().updateSchema(className, update)
+}
+
+// DropIndex 删除索引，并从 _SCHEMA 中删除索引定义
+func (m *MongoAdapter) DropIndex(className string, indexName string) error {
+	err := m.adaptiveCollection(className).dropIndex(indexName)
+	if err != nil {
+		return err
+	}
+	update := types.M{
+		"$unset": types.M{
+			"_metadata.indexes." + indexName: "",
+		},
+	}
+	return m.schemaCollection().updateSchema(className, update)
+}
+
+// GetIndexes 获取 _SCHEMA 中保存的索引定义
+func (m *MongoAdapter) GetIndexes(className string) (types.M, error) {
+	schema, err := m.schemaCollection().findSchema(className)
+	if err != nil {
+		return nil, err
+	}
+	if indexes := utils.M(schema["indexes"]); indexes != nil {
+		return indexes, nil
+	}
+	return types.M{}, nil
+}
+
+// mongoIndex 把索引定义转换为 mgo 中的索引
+func (m *MongoAdapter) mongoIndex(className string, schema types.M, indexName string, index types.M) mgo.Index {
+	keys := []string{}
+	text := storage.IndexIsText(index)
+	for _, field := range storage.IndexFields(index) {
+		key := m.transform.transformKey(className, field.Name, schema)
+		if text {
+			key = "$text:" + key
+		} else if field.Descending {
+			key = "-" + key
+		}
+		keys = append(keys, key)
+	}
+	mongoIndex := mgo.Index{
+		Key:        keys,
+		Name:       indexName,
+		Background: true,
+		Sparse:     storage.IndexIsSparse(index),
+	}
+	if text {
+		mongoIndex.DefaultLanguage = storage.IndexLanguage(index)
+	}
+	if seconds := storage.IndexExpireAfterSeconds(index); seconds > 0 {
+		mongoIndex.ExpireAfter = time.Duration(seconds) * time.Second
+	}
+	return mongoIndex
+}
+
+// SupportsTTLIndex MongoDB 自动删除 TTL 索引中过期的对象
+func (m *MongoAdapter) SupportsTTLIndex() bool {
+	return true
+}
+
 // PerformInitialization 性能优化初始化
 func (m *MongoAdapter) PerformInitialization(options types.M) error {
 	return nil
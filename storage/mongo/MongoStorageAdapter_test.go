@@ -1,6 +1,7 @@
 package mongo
 
 import (
+	"context"
 	"reflect"
 	"testing"
 	"time"
@@ -8,6 +9,7 @@ import (
 	"gopkg.in/mgo.v2"
 
 	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
 	"github.com/lfq7413/tomato/types"
 	"github.com/lfq7413/tomato/utils"
 )
@@ -1624,3 +1626,55 @@ func Test_mongoSchemaFromFieldsAndClassNameAndCLP(t *testing.T) {
 func getAdapter() *MongoAdapter {
 	return NewMongoAdapter("tomato", openDB())
 }
+
+func Test_queryMaxTimeMS(t *testing.T) {
+	var adapter *MongoAdapter
+	var result int
+	var expect int
+	/*****************************************************/
+	adapter = &MongoAdapter{}
+	result = adapter.queryMaxTimeMS()
+	expect = 0
+	if result != expect {
+		t.Error("expect:", expect, "result:", result)
+	}
+	/*****************************************************/
+	adapter = &MongoAdapter{ctx: storage.WithQueryTimeout(context.Background(), 500)}
+	result = adapter.queryMaxTimeMS()
+	expect = 500
+	if result != expect {
+		t.Error("expect:", expect, "result:", result)
+	}
+	/*****************************************************/
+	adapter = &MongoAdapter{maxTimeMS: 200, ctx: storage.WithQueryTimeout(context.Background(), 500)}
+	result = adapter.queryMaxTimeMS()
+	expect = 200
+	if result != expect {
+		t.Error("expect:", expect, "result:", result)
+	}
+	/*****************************************************/
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	adapter = &MongoAdapter{ctx: storage.WithQueryTimeout(ctx, 500)}
+	result = adapter.queryMaxTimeMS()
+	if result <= 0 || result > 100 {
+		t.Error("expect:", "(0, 100]", "result:", result)
+	}
+}
+
+func Test_queryTimeoutError(t *testing.T) {
+	var err error
+	var result error
+	/*****************************************************/
+	err = &mgo.QueryError{Code: 50, Message: "operation exceeded time limit"}
+	result = queryTimeoutError(err)
+	if reflect.DeepEqual(storage.ErrQueryTimeout, result) == false {
+		t.Error("expect:", storage.ErrQueryTimeout, "result:", result)
+	}
+	/*****************************************************/
+	err = &mgo.QueryError{Code: 2, Message: "bad query"}
+	result = queryTimeoutError(err)
+	if reflect.DeepEqual(err, result) == false {
+		t.Error("expect:", err, "result:", result)
+	}
+}
@@ -0,0 +1,16 @@
+package mongo
+
+import (
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+)
+
+// Begin mgo.v2 不支持 MongoDB 的 session 与多文档事务，无法保证事务的原子性与隔离性，直接返回错误
+func (m *MongoAdapter) Begin() (storage.Transaction, error) {
+	return nil, errs.E(errs.OperationForbidden, "transactions are not supported by the MongoDB adapter")
+}
+
+// SupportsTransactions MongoDB 适配器不支持事务
+func (m *MongoAdapter) SupportsTransactions() bool {
+	return false
+}
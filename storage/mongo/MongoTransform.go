@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
 	"github.com/lfq7413/tomato/types"
 	"github.com/lfq7413/tomato/utils"
 )
@@ -334,6 +335,10 @@ func (t *Transform) transformQueryKeyValue(className, key string, value interfac
 		return "", nil, err
 	}
 	if cValue != cannotTransform() {
+		// MongoDB 中 $text 只能作用于整个集合的全文索引，需要放在查询条件的顶层
+		if text := utils.M(cValue)["$text"]; text != nil {
+			return "$text", text, nil
+		}
 		return key, cValue, nil
 	}
 
@@ -539,6 +544,24 @@ func (t *Transform) transformConstraint(constraint interface{}, inArray bool) (i
 			nearSphere["$maxDistance"] = distance
 			answer["$nearSphere"] = nearSphere
 
+		// 转换 全文检索 操作符，在 transformQueryKeyValue 中移动到查询条件的顶层
+		case "$text":
+			search, err := storage.ParseTextSearch(object[key])
+			if err != nil {
+				return nil, err
+			}
+			text := types.M{"$search": search.Term}
+			if search.Language != "" {
+				text["$language"] = search.Language
+			}
+			if search.CaseSensitive {
+				text["$caseSensitive"] = true
+			}
+			if search.DiacriticSensitive {
+				text["$diacriticSensitive"] = true
+			}
+			answer[key] = text
+
 		case "$select", "$dontSelect":
 			// 暂时不支持该参数
 			return nil, errs.E(errs.CommandUnavailable, "the "+key+" constraint is not supported yet")
@@ -571,6 +594,21 @@ func (t *Transform) transformConstraint(constraint interface{}, inArray bool) (i
 			if geoWithin == nil {
 				return nil, errs.E(errs.InvalidJSON, "bad $geoWithin value")
 			}
+			// $polygon 为 Polygon 类型时，使用其顶点
+			if p := utils.M(geoWithin["$polygon"]); p != nil {
+				vertices, err := storage.ParsePolygon(p)
+				if err != nil {
+					return nil, err
+				}
+				points := types.S{}
+				for _, v := range vertices {
+					points = append(points, types.S{v[0], v[1]})
+				}
+				answer["$geoWithin"] = types.M{
+					"$polygon": points,
+				}
+				break
+			}
 			polygon := utils.A(geoWithin["$polygon"])
 			if polygon == nil {
 				return nil, errs.E(errs.InvalidJSON, "bad $geoWithin value")
@@ -592,6 +630,21 @@ func (t *Transform) transformConstraint(constraint interface{}, inArray bool) (i
 				"$polygon": points,
 			}
 
+		case "$geoIntersects":
+			// 查找包含该点的多边形
+			// {"$geoIntersects": {"$point": {"__type": "GeoPoint", "longitude": 10, "latitude": 20}}}
+			// ==> {"$geoIntersects": {"$geometry": {"type": "Point", "coordinates": [10, 20]}}}
+			longitude, latitude, ok := storage.GeoPointOf(utils.M(object[key])["$point"])
+			if ok == false {
+				return nil, errs.E(errs.InvalidJSON, "bad $geoIntersects value; $point should be GeoPoint")
+			}
+			answer["$geoIntersects"] = types.M{
+				"$geometry": types.M{
+					"type":        "Point",
+					"coordinates": types.S{longitude, latitude},
+				},
+			}
+
 		default:
 			b, _ := regexp.MatchString(`^\$+`, key)
 			if b {
@@ -685,6 +738,17 @@ func (t *Transform) transformTopLevelAtom(atom interface{}) (interface{}, error)
 			return g.jsonToDatabase(object)
 		}
 
+		// Polygon 类型
+		// {
+		// 	"__type": "Polygon",
+		// 	"coordinates": [[40.0, -30.0], [40.0, -20.0], [50.0, -20.0]]
+		// }
+		// ==> {"type": "Polygon", "coordinates": [[[-30.0, 40.0], [-20.0, 40.0], [-20.0, 50.0], [-30.0, 40.0]]]}
+		p := polygonCoder{}
+		if p.isValidJSON(object) {
+			return p.jsonToDatabase(object)
+		}
+
 		// File 类型
 		// {
 		// 	"__type": "File",
@@ -1314,6 +1378,17 @@ func (t *Transform) mongoObjectToParseObject(className string, mongoObject inter
 			case "_session_token":
 				restObject["sessionToken"] = value
 
+			// 软删除的时间，在 DB Controller 中决定是否返回
+			case "_deleted_at":
+				if t, ok := value.(time.Time); ok {
+					restObject[key] = types.M{
+						"__type": "Date",
+						"iso":    utils.TimetoString(t),
+					}
+				} else {
+					restObject[key] = value
+				}
+
 			// 时间类型转换为 ISO8601 标准的字符串
 			case "updatedAt", "_updated_at":
 				if t, ok := value.(time.Time); ok {
@@ -1439,6 +1514,16 @@ func (t *Transform) mongoObjectToParseObject(className string, mongoObject inter
 						restObject[key] = g.databaseToJSON(value)
 						break
 					}
+					// polygon 类型
+					// {
+					// 	"__type":      "Polygon",
+					// 	"coordinates": [[40, 30], [40, 20], [50, 20], [40, 30]]
+					// }
+					p := polygonCoder{}
+					if expectedType != nil && utils.S(expectedType["type"]) == "Polygon" && p.isValidDatabaseObject(value) {
+						restObject[key] = p.databaseToJSON(value)
+						break
+					}
 					// bytesCoder 类型
 					// {
 					// 	"__type": "Bytes",
@@ -1660,6 +1745,60 @@ func (g geoPointCoder) isValidJSON(value types.M) bool {
 	return value != nil && utils.S(value["__type"]) == "GeoPoint" && value["longitude"] != nil && value["latitude"] != nil
 }
 
+// polygonCoder Polygon 类型处理，在数据库中保存为 GeoJSON ，顶点为 [longitude, latitude]
+type polygonCoder struct{}
+
+func (p polygonCoder) databaseToJSON(object interface{}) types.M {
+	// 在 isValidDatabaseObject 中校验格式， [longitude, latitude] 转换为 [latitude, longitude]
+	coordinates := types.S{}
+	rings := utils.A(utils.M(object)["coordinates"])
+	for _, point := range utils.A(rings[0]) {
+		pair := utils.A(point)
+		coordinates = append(coordinates, types.S{pair[1], pair[0]})
+	}
+	return types.M{
+		"__type":      "Polygon",
+		"coordinates": coordinates,
+	}
+}
+
+func (p polygonCoder) isValidDatabaseObject(object interface{}) bool {
+	polygon := utils.M(object)
+	if polygon == nil || utils.S(polygon["type"]) != "Polygon" {
+		return false
+	}
+	rings := utils.A(polygon["coordinates"])
+	if len(rings) == 0 || utils.A(rings[0]) == nil {
+		return false
+	}
+	g := geoPointCoder{}
+	for _, point := range utils.A(rings[0]) {
+		if g.isValidDatabaseObject(point) == false {
+			return false
+		}
+	}
+	return true
+}
+
+func (p polygonCoder) jsonToDatabase(json types.M) (interface{}, error) {
+	vertices, err := storage.ParsePolygon(json)
+	if err != nil {
+		return nil, err
+	}
+	ring := types.S{}
+	for _, v := range vertices {
+		ring = append(ring, types.S{v[0], v[1]})
+	}
+	return types.M{
+		"type":        "Polygon",
+		"coordinates": types.S{ring},
+	}, nil
+}
+
+func (p polygonCoder) isValidJSON(value types.M) bool {
+	return value != nil && utils.S(value["__type"]) == "Polygon"
+}
+
 // fileCoder File 类型处理
 type fileCoder struct{}
 
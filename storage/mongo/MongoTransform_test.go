@@ -674,6 +674,16 @@ func Test_transformQueryKeyValue(t *testing.T) {
 	if err != nil || resultKey != expectKey || reflect.DeepEqual(resultValue, expectValue) == false {
 		t.Error("expect:", expectKey, expectValue, "get result:", resultKey, resultValue, err)
 	}
+	/*************************************************/
+	key = "subject"
+	value = types.M{"$text": types.M{"$search": types.M{"$term": "coffee", "$language": "es"}}}
+	schema = types.M{}
+	resultKey, resultValue, err = tf.transformQueryKeyValue("", key, value, schema)
+	expectKey = "$text"
+	expectValue = types.M{"$search": "coffee", "$language": "es"}
+	if err != nil || resultKey != expectKey || reflect.DeepEqual(resultValue, expectValue) == false {
+		t.Error("expect:", expectKey, expectValue, "get result:", resultKey, resultValue, err)
+	}
 }
 
 func Test_transformConstraint(t *testing.T) {
@@ -1134,6 +1144,72 @@ func Test_transformConstraint(t *testing.T) {
 		t.Error("expect:", expect, "get result:", result, err)
 	}
 	/*************************************************/
+	constraint = types.M{
+		"$geoWithin": types.M{
+			"$polygon": types.M{
+				"__type":      "Polygon",
+				"coordinates": types.S{types.S{20, 20}, types.S{30, 30}, types.S{30, 20}},
+			},
+		},
+	}
+	inArray = false
+	result, err = tf.transformConstraint(constraint, inArray)
+	expect = types.M{
+		"$geoWithin": types.M{
+			"$polygon": types.S{
+				types.S{20.0, 20.0},
+				types.S{30.0, 30.0},
+				types.S{20.0, 30.0},
+				types.S{20.0, 20.0},
+			},
+		},
+	}
+	if err != nil || reflect.DeepEqual(result, expect) == false {
+		t.Error("expect:", expect, "get result:", result, err)
+	}
+	/*************************************************/
+	constraint = types.M{
+		"$geoWithin": types.M{
+			"$polygon": types.M{
+				"__type":      "Polygon",
+				"coordinates": types.S{types.S{20, 20}, types.S{30, 30}},
+			},
+		},
+	}
+	inArray = false
+	result, err = tf.transformConstraint(constraint, inArray)
+	expect = errs.E(errs.InvalidJSON, "Polygon must have at least 3 coordinates")
+	if reflect.DeepEqual(err, expect) == false || result != nil {
+		t.Error("expect:", expect, "get result:", err)
+	}
+	/*************************************************/
+	constraint = types.M{
+		"$geoIntersects": types.M{
+			"$point": types.M{"__type": "GeoPoint", "longitude": 20, "latitude": 30},
+		},
+	}
+	inArray = false
+	result, err = tf.transformConstraint(constraint, inArray)
+	expect = types.M{
+		"$geoIntersects": types.M{
+			"$geometry": types.M{
+				"type":        "Point",
+				"coordinates": types.S{20.0, 30.0},
+			},
+		},
+	}
+	if err != nil || reflect.DeepEqual(result, expect) == false {
+		t.Error("expect:", expect, "get result:", result, err)
+	}
+	/*************************************************/
+	constraint = types.M{"$geoIntersects": types.M{"$point": "hello"}}
+	inArray = false
+	result, err = tf.transformConstraint(constraint, inArray)
+	expect = errs.E(errs.InvalidJSON, "bad $geoIntersects value; $point should be GeoPoint")
+	if reflect.DeepEqual(err, expect) == false || result != nil {
+		t.Error("expect:", expect, "get result:", err)
+	}
+	/*************************************************/
 	constraint = types.M{"$other": "hello"}
 	inArray = true
 	result, err = tf.transformConstraint(constraint, inArray)
@@ -1157,6 +1233,35 @@ func Test_transformConstraint(t *testing.T) {
 	if err != nil || reflect.DeepEqual(result, expect) == false {
 		t.Error("expect:", expect, "get result:", result)
 	}
+	/*************************************************/
+	constraint = types.M{
+		"$text": types.M{
+			"$search": types.M{
+				"$term":               "coffee",
+				"$caseSensitive":      true,
+				"$diacriticSensitive": false,
+			},
+		},
+	}
+	inArray = false
+	result, err = tf.transformConstraint(constraint, inArray)
+	expect = types.M{
+		"$text": types.M{
+			"$search":        "coffee",
+			"$caseSensitive": true,
+		},
+	}
+	if err != nil || reflect.DeepEqual(result, expect) == false {
+		t.Error("expect:", expect, "get result:", result)
+	}
+	/*************************************************/
+	constraint = types.M{"$text": types.M{"$search": "coffee"}}
+	inArray = false
+	result, err = tf.transformConstraint(constraint, inArray)
+	expect = errs.E(errs.InvalidJSON, "bad $text: $search, should be object")
+	if reflect.DeepEqual(err, expect) == false || result != nil {
+		t.Error("expect:", expect, "get result:", err)
+	}
 }
 
 func Test_transformTopLevelAtom(t *testing.T) {
@@ -2513,6 +2618,7 @@ func Test_mongoObjectToParseObject(t *testing.T) {
 		"_updated_at":                    tmpTime,
 		"_created_at":                    tmpTime,
 		"expiresAt":                      tmpTime,
+		"_deleted_at":                    tmpTime,
 	}
 	schema = types.M{}
 	result, err = tf.mongoObjectToParseObject("", mongoObject, schema)
@@ -2535,6 +2641,10 @@ func Test_mongoObjectToParseObject(t *testing.T) {
 			"__type": "Date",
 			"iso":    tmpTimeStr,
 		},
+		"_deleted_at": types.M{
+			"__type": "Date",
+			"iso":    tmpTimeStr,
+		},
 	}
 	if err != nil || reflect.DeepEqual(expect, result) == false {
 		t.Error("expect:", expect, "get result:", result)
@@ -2731,6 +2841,30 @@ func Test_mongoObjectToParseObject(t *testing.T) {
 		t.Error("expect:", expect, "get result:", result)
 	}
 	/*************************************************/
+	mongoObject = types.M{
+		"area": types.M{
+			"type":        "Polygon",
+			"coordinates": types.S{types.S{types.S{10.0, 20.0}, types.S{30.0, 20.0}, types.S{30.0, 40.0}, types.S{10.0, 20.0}}},
+		},
+	}
+	schema = types.M{
+		"fields": types.M{
+			"area": types.M{
+				"type": "Polygon",
+			},
+		},
+	}
+	result, err = tf.mongoObjectToParseObject("", mongoObject, schema)
+	expect = types.M{
+		"area": types.M{
+			"__type":      "Polygon",
+			"coordinates": types.S{types.S{20.0, 10.0}, types.S{20.0, 30.0}, types.S{40.0, 30.0}, types.S{20.0, 10.0}},
+		},
+	}
+	if err != nil || reflect.DeepEqual(expect, result) == false {
+		t.Error("expect:", expect, "get result:", result)
+	}
+	/*************************************************/
 	mongoObject = types.M{
 		"data": "aGVsbG8=",
 	}
@@ -3362,6 +3496,83 @@ func Test_geoPointCoder(t *testing.T) {
 	}
 }
 
+func Test_polygonCoder(t *testing.T) {
+	pc := polygonCoder{}
+	var databaseObject interface{}
+	var jsonObject types.M
+	var ok bool
+	var expect interface{}
+	var err error
+	/*************************************************/
+	databaseObject = types.M{
+		"type":        "Polygon",
+		"coordinates": types.S{types.S{types.S{10, 20}, types.S{30, 20}, types.S{30, 40}, types.S{10, 20}}},
+	}
+	ok = pc.isValidDatabaseObject(databaseObject)
+	if !ok {
+		t.Error("expect:", "true", "get:", ok)
+	}
+	jsonObject = pc.databaseToJSON(databaseObject)
+	expect = types.M{
+		"__type":      "Polygon",
+		"coordinates": types.S{types.S{20, 10}, types.S{20, 30}, types.S{40, 30}, types.S{20, 10}},
+	}
+	if reflect.DeepEqual(jsonObject, expect) == false {
+		t.Error("expect:", expect, "get jsonObject:", jsonObject)
+	}
+	/*************************************************/
+	databaseObject = types.M{"type": "Point", "coordinates": types.S{10, 20}}
+	ok = pc.isValidDatabaseObject(databaseObject)
+	if ok {
+		t.Error("expect:", "false", "get:", ok)
+	}
+	/*************************************************/
+	databaseObject = types.M{"type": "Polygon", "coordinates": types.S{types.S{"a", "b"}}}
+	ok = pc.isValidDatabaseObject(databaseObject)
+	if ok {
+		t.Error("expect:", "false", "get:", ok)
+	}
+	/*************************************************/
+	jsonObject = types.M{
+		"__type":      "Polygon",
+		"coordinates": types.S{types.S{20, 10}, types.S{20, 30}, types.S{40, 30}},
+	}
+	databaseObject, err = pc.jsonToDatabase(jsonObject)
+	expect = types.M{
+		"type":        "Polygon",
+		"coordinates": types.S{types.S{types.S{10.0, 20.0}, types.S{30.0, 20.0}, types.S{30.0, 40.0}, types.S{10.0, 20.0}}},
+	}
+	if err != nil || reflect.DeepEqual(databaseObject, expect) == false {
+		t.Error("expect:", expect, "get result:", databaseObject, err)
+	}
+	/*************************************************/
+	jsonObject = types.M{
+		"__type":      "Polygon",
+		"coordinates": types.S{types.S{20, 10}, types.S{20, 10}, types.S{40, 30}},
+	}
+	databaseObject, err = pc.jsonToDatabase(jsonObject)
+	expect = errs.E(errs.InvalidJSON, "Polygon must have at least 3 different vertices")
+	if reflect.DeepEqual(err, expect) == false {
+		t.Error("expect:", expect, "get result:", err)
+	}
+	/*************************************************/
+	jsonObject = types.M{
+		"__type":      "Polygon",
+		"coordinates": types.S{types.S{20, 10}, types.S{100, 10}, types.S{40, 30}},
+	}
+	databaseObject, err = pc.jsonToDatabase(jsonObject)
+	expect = errs.E(errs.InvalidJSON, "bad Polygon coordinates")
+	if reflect.DeepEqual(err, expect) == false {
+		t.Error("expect:", expect, "get result:", err)
+	}
+	/*************************************************/
+	jsonObject = types.M{"__type": "Polygon"}
+	ok = pc.isValidJSON(jsonObject)
+	if !ok {
+		t.Error("expect:", "true", "get:", ok)
+	}
+}
+
 func Test_fileCoder(t *testing.T) {
 	fc := fileCoder{}
 	var databaseObject interface{}
@@ -0,0 +1,262 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+	"github.com/lib/pq"
+)
+
+// 数据库变更通过触发器捕获：
+// 触发器把变更前后的行写入 _ChangeFeed 表，并通过 NOTIFY 通知监听者，监听者按 id 顺序读取变更
+// 每个监听者已经处理的位置保存在 _ChangeFeedPosition 表中，所有监听者都已处理的变更会被删除
+// 读取变更时使用 jsonb_populate_record 把保存的行还原为对应表的行，需要 PostgreSQL 10 及以上
+
+const postgresChangeFeedChannel = "tomato_change_feed"
+
+// postgresChangeFeedBatchSize 每次读取的变更数量
+const postgresChangeFeedBatchSize = 100
+
+// postgresChangeFeedInterval 未收到通知时，定时读取变更的间隔，同时用于为新建的类添加触发器
+const postgresChangeFeedInterval = 10 * time.Second
+
+// postgresChangeFeedPollInterval 未设置数据库地址、无法使用 LISTEN 时，定时读取变更的间隔
+const postgresChangeFeedPollInterval = time.Second
+
+var postgresChangeFeedSetup = []string{
+	`CREATE TABLE IF NOT EXISTS "_ChangeFeed" ( "id" bigserial PRIMARY KEY, "className" text NOT NULL, "type" text NOT NULL, "object" jsonb, "original" jsonb, "createdAt" timestamp with time zone DEFAULT now() )`,
+	`CREATE TABLE IF NOT EXISTS "_ChangeFeedPosition" ( "name" text PRIMARY KEY, "position" bigint NOT NULL, "updatedAt" timestamp with time zone DEFAULT now() )`,
+	`CREATE OR REPLACE FUNCTION tomato_change_feed() RETURNS trigger AS $$
+DECLARE
+	change_id bigint;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		INSERT INTO "_ChangeFeed" ("className", "type", "object") VALUES (TG_TABLE_NAME, 'delete', to_jsonb(OLD)) RETURNING "id" INTO change_id;
+	ELSIF TG_OP = 'UPDATE' THEN
+		INSERT INTO "_ChangeFeed" ("className", "type", "object", "original") VALUES (TG_TABLE_NAME, 'save', to_jsonb(NEW), to_jsonb(OLD)) RETURNING "id" INTO change_id;
+	ELSE
+		INSERT INTO "_ChangeFeed" ("className", "type", "object") VALUES (TG_TABLE_NAME, 'save', to_jsonb(NEW)) RETURNING "id" INTO change_id;
+	END IF;
+	PERFORM pg_notify('` + postgresChangeFeedChannel + `', change_id::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql`,
+}
+
+// WatchChanges 通过触发器与 LISTEN/NOTIFY 监听 classNames 对应的表
+// 监听开始时为已经存在的表添加触发器，之后创建的表在定时检查时添加
+// 未设置数据库地址时不使用 LISTEN ，改为定时读取变更
+// 变更按 id 顺序读取，并发事务的提交顺序与 id 顺序不一致时，较晚提交的变更可能在其后的变更之后才能读取到
+func (p *PostgresAdapter) WatchChanges(ctx context.Context, name string, classNames []string, handler func(*storage.ChangeEvent) error) error {
+	for _, qs := range postgresChangeFeedSetup {
+		_, err := p.db.Exec(qs)
+		if err != nil {
+			if e, ok := err.(*pq.Error); ok && (e.Code == postgresDuplicateRelationError || e.Code == postgresUniqueIndexViolationError) {
+				// 已经由其他监听者创建，忽略错误
+				continue
+			}
+			return err
+		}
+	}
+
+	position, err := p.changePosition(name)
+	if err != nil {
+		return err
+	}
+
+	var notify <-chan *pq.Notification
+	interval := postgresChangeFeedPollInterval
+	if p.uri != "" {
+		listener := pq.NewListener(p.uri, time.Second, time.Minute, nil)
+		defer listener.Close()
+		err = listener.Listen(postgresChangeFeedChannel)
+		if err != nil {
+			return err
+		}
+		notify = listener.Notify
+		interval = postgresChangeFeedInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	triggers := map[string]bool{}
+	for {
+		err = p.ensureChangeTriggers(classNames, triggers)
+		if err != nil {
+			return err
+		}
+		position, err = p.readChanges(ctx, name, classNames, position, handler)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// changePosition 获取监听者保存的位置，首次监听时从当前位置开始
+func (p *PostgresAdapter) changePosition(name string) (int64, error) {
+	var position int64
+	err := p.db.QueryRow(`SELECT "position" FROM "_ChangeFeedPosition" WHERE "name" = $1`, name).Scan(&position)
+	if err == nil {
+		return position, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+	err = p.db.QueryRow(`SELECT COALESCE(MAX("id"), 0) FROM "_ChangeFeed"`).Scan(&position)
+	if err != nil {
+		return 0, err
+	}
+	return position, p.saveChangePosition(name, position)
+}
+
+// saveChangePosition 保存监听者的位置，并删除所有监听者都已经处理的变更
+func (p *PostgresAdapter) saveChangePosition(name string, position int64) error {
+	qs := `INSERT INTO "_ChangeFeedPosition" ("name", "position", "updatedAt") VALUES ($1, $2, now())
+	ON CONFLICT ("name") DO UPDATE SET "position" = EXCLUDED."position", "updatedAt" = now()`
+	_, err := p.db.Exec(qs, name, position)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.Exec(`DELETE FROM "_ChangeFeed" WHERE "id" <= (SELECT MIN("position") FROM "_ChangeFeedPosition")`)
+	return err
+}
+
+// ensureChangeTriggers 为尚未添加触发器的表添加触发器，表不存在时跳过
+func (p *PostgresAdapter) ensureChangeTriggers(classNames []string, triggers map[string]bool) error {
+	for _, className := range classNames {
+		if triggers[className] {
+			continue
+		}
+		var exists bool
+		err := p.db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, `"`+className+`"`).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists == false {
+			continue
+		}
+		tx, err := p.db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DROP TRIGGER IF EXISTS "tomato_change_feed" ON "` + className + `"`)
+		if err == nil {
+			_, err = tx.Exec(`CREATE TRIGGER "tomato_change_feed" AFTER INSERT OR UPDATE OR DELETE ON "` + className + `" FOR EACH ROW EXECUTE PROCEDURE tomato_change_feed()`)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		triggers[className] = true
+	}
+	return nil
+}
+
+// readChanges 读取并处理 position 之后的所有变更，返回最后一个处理成功的变更的位置
+func (p *PostgresAdapter) readChanges(ctx context.Context, name string, classNames []string, position int64, handler func(*storage.ChangeEvent) error) (int64, error) {
+	for ctx.Err() == nil {
+		rows, err := p.db.Query(`SELECT "id", "className", "type" FROM "_ChangeFeed" WHERE "id" > $1 AND "className" = ANY($2) ORDER BY "id" LIMIT $3`,
+			position, pq.Array(classNames), postgresChangeFeedBatchSize)
+		if err != nil {
+			return position, err
+		}
+		changes := []*storage.ChangeEvent{}
+		ids := []int64{}
+		for rows.Next() {
+			var id int64
+			event := &storage.ChangeEvent{}
+			err = rows.Scan(&id, &event.ClassName, &event.Type)
+			if err != nil {
+				rows.Close()
+				return position, err
+			}
+			event.Position = strconv.FormatInt(id, 10)
+			changes = append(changes, event)
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if len(changes) == 0 {
+			return position, nil
+		}
+
+		last := position
+		schemas := map[string]types.M{}
+		for i, event := range changes {
+			err = p.loadChange(ids[i], event, schemas)
+			if err == nil && event.Object != nil {
+				err = handler(event)
+			}
+			if err != nil {
+				break
+			}
+			last = ids[i]
+		}
+		if last != position {
+			e := p.saveChangePosition(name, last)
+			if err == nil {
+				err = e
+			}
+			position = last
+		}
+		if err != nil {
+			return position, err
+		}
+	}
+	return position, nil
+}
+
+// loadChange 把保存的行还原为对象，表已经被删除时 Object 为空
+func (p *PostgresAdapter) loadChange(id int64, event *storage.ChangeEvent, schemas map[string]types.M) error {
+	fields, ok := schemas[event.ClassName]
+	if ok == false {
+		schema, err := p.GetClass(event.ClassName)
+		if err != nil {
+			return err
+		}
+		fields = utils.M(schema["fields"])
+		if fields == nil {
+			fields = types.M{}
+		}
+		schemas[event.ClassName] = fields
+	}
+
+	for _, column := range []string{"object", "original"} {
+		qs := `SELECT r.* FROM "_ChangeFeed", jsonb_populate_record(NULL::"` + event.ClassName + `", "_ChangeFeed"."` + column + `") AS r WHERE "_ChangeFeed"."id" = $1 AND "_ChangeFeed"."` + column + `" IS NOT NULL`
+		rows, err := p.db.Query(qs, id)
+		if err != nil {
+			if e, ok := err.(*pq.Error); ok && (e.Code == postgresRelationDoesNotExistError || e.Code == postgresUndefinedObjectError) {
+				return nil
+			}
+			return err
+		}
+		objects, err := scanObjects(rows, fields)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		if len(objects) == 0 {
+			continue
+		}
+		if column == "object" {
+			event.Object = objects[0]
+		} else {
+			event.Original = objects[0]
+		}
+	}
+	return nil
+}
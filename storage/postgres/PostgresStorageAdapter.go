@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"regexp"
 
 	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
 	"github.com/lfq7413/tomato/types"
 	"github.com/lfq7413/tomato/utils"
 	"github.com/lib/pq"
@@ -23,14 +25,73 @@ const postgresRelationDoesNotExistError = "42P01"
 const postgresDuplicateRelationError = "42P07"
 const postgresDuplicateColumnError = "42701"
 const postgresDuplicateObjectError = "42710"
+const postgresUndefinedObjectError = "42704"
 const postgresUniqueIndexViolationError = "23505"
 const postgresTransactionAbortedError = "25P02"
+const postgresQueryCanceledError = "57014"
 
 // PostgresAdapter postgres 数据库适配器
 type PostgresAdapter struct {
 	collectionPrefix string
 	collectionList   []string
 	db               *sql.DB
+	tx               *sql.Tx // 不为空时，所有操作都在该事务中执行
+	savepoints       int
+	ctx              context.Context // 不为空时，所有操作在 ctx 取消或超时后中止
+	uri              string          // 数据库地址，用于 LISTEN 监听数据库变更
+}
+
+// executor *sql.DB 与 *sql.Tx 的公共操作
+type executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// contextExecutor 使用 ctx 执行 SQL 的 executor
+type contextExecutor struct {
+	ctx  context.Context
+	conn interface {
+		ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+		QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+		QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	}
+}
+
+func (c *contextExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+func (c *contextExecutor) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(c.ctx, query, args...)
+}
+
+func (c *contextExecutor) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
+}
+
+// transaction 适配器内部使用的事务，适配器已经处于事务中时，使用保存点实现嵌套
+type transaction struct {
+	*sql.Tx
+	savepoint string
+}
+
+// Commit 提交事务，嵌套时释放保存点
+func (t *transaction) Commit() error {
+	if t.savepoint != "" {
+		_, err := t.Exec(`RELEASE SAVEPOINT ` + t.savepoint)
+		return err
+	}
+	return t.Tx.Commit()
+}
+
+// Rollback 回滚事务，嵌套时回滚到保存点
+func (t *transaction) Rollback() error {
+	if t.savepoint != "" {
+		_, err := t.Exec(`ROLLBACK TO SAVEPOINT ` + t.savepoint)
+		return err
+	}
+	return t.Tx.Rollback()
 }
 
 // NewPostgresAdapter ...
@@ -42,9 +103,85 @@ func NewPostgresAdapter(collectionPrefix string, db *sql.DB) *PostgresAdapter {
 	}
 }
 
+// SetDatabaseURI 设置数据库地址，监听数据库变更时使用该地址建立 LISTEN 连接
+func (p *PostgresAdapter) SetDatabaseURI(uri string) {
+	p.uri = uri
+}
+
+// conn 返回执行 SQL 的对象，处于事务中时返回该事务
+func (p *PostgresAdapter) conn() executor {
+	if p.ctx != nil {
+		if p.tx != nil {
+			return &contextExecutor{ctx: p.ctx, conn: p.tx}
+		}
+		return &contextExecutor{ctx: p.ctx, conn: p.db}
+	}
+	if p.tx != nil {
+		return p.tx
+	}
+	return p.db
+}
+
+// WithContext 返回在 ctx 中执行所有操作的适配器，处于事务中时共用同一个事务
+func (p *PostgresAdapter) WithContext(ctx context.Context) storage.Adapter {
+	adapter := *p
+	adapter.ctx = ctx
+	return &adapter
+}
+
+// beginTx 开始数据库事务，设置了 ctx 时，ctx 取消后事务自动回滚
+func (p *PostgresAdapter) beginTx() (*sql.Tx, error) {
+	if p.ctx != nil {
+		return p.db.BeginTx(p.ctx, nil)
+	}
+	return p.db.Begin()
+}
+
+// begin 开始适配器内部的事务
+func (p *PostgresAdapter) begin() (*transaction, error) {
+	if p.tx == nil {
+		tx, err := p.beginTx()
+		if err != nil {
+			return nil, err
+		}
+		return &transaction{Tx: tx}, nil
+	}
+	p.savepoints++
+	savepoint := fmt.Sprintf("tomato_savepoint_%d", p.savepoints)
+	_, err := p.tx.Exec(`SAVEPOINT ` + savepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{Tx: p.tx, savepoint: savepoint}, nil
+}
+
+// withQueryTimeout 执行只读查询 fn ， ctx 中设置了单次查询最大执行时间时，
+// 在事务中使用 SET LOCAL statement_timeout 限制执行时间，执行完成后回滚事务以撤销该设置
+func (p *PostgresAdapter) withQueryTimeout(fn func(conn executor) error) error {
+	timeout := storage.QueryTimeout(p.ctx)
+	if timeout <= 0 {
+		return fn(p.conn())
+	}
+	tx, err := p.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	conn := &contextExecutor{ctx: p.ctx, conn: tx.Tx}
+	_, err = conn.Exec(fmt.Sprintf(`SET LOCAL statement_timeout = %d`, timeout))
+	if err != nil {
+		return err
+	}
+	err = fn(conn)
+	if e, ok := err.(*pq.Error); ok && e.Code == postgresQueryCanceledError && p.ctx.Err() == nil {
+		return storage.ErrQueryTimeout
+	}
+	return err
+}
+
 // ensureSchemaCollectionExists 确保 _SCHEMA 表存在，不存在则创建表
 func (p *PostgresAdapter) ensureSchemaCollectionExists() error {
-	_, err := p.db.Exec(`CREATE TABLE IF NOT EXISTS "_SCHEMA" ( "className" varChar(120), "schema" jsonb, "isParseClass" bool, PRIMARY KEY ("className") )`)
+	_, err := p.conn().Exec(`CREATE TABLE IF NOT EXISTS "_SCHEMA" ( "className" varChar(120), "schema" jsonb, "isParseClass" bool, PRIMARY KEY ("className") )`)
 	if err != nil {
 		if e, ok := err.(*pq.Error); ok {
 			if e.Code == postgresDuplicateRelationError || e.Code == postgresUniqueIndexViolationError || e.Code == postgresDuplicateObjectError {
@@ -61,7 +198,7 @@ func (p *PostgresAdapter) ensureSchemaCollectionExists() error {
 // ClassExists 检测数据库中是否存在指定类
 func (p *PostgresAdapter) ClassExists(name string) bool {
 	var result bool
-	err := p.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM   information_schema.tables WHERE table_name = $1)`, name).Scan(&result)
+	err := p.conn().QueryRow(`SELECT EXISTS (SELECT 1 FROM   information_schema.tables WHERE table_name = $1)`, name).Scan(&result)
 	if err != nil {
 		return false
 	}
@@ -83,7 +220,30 @@ func (p *PostgresAdapter) SetClassLevelPermissions(className string, CLPs types.
 	}
 
 	qs := `UPDATE "_SCHEMA" SET "schema" = json_object_set_key("schema", $1::text, $2::jsonb) WHERE "className"=$3 `
-	_, err = p.db.Exec(qs, "classLevelPermissions", string(b), className)
+	_, err = p.conn().Exec(qs, "classLevelPermissions", string(b), className)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetClassOptions 设置类的选项
+func (p *PostgresAdapter) SetClassOptions(className string, options types.M) error {
+	err := p.ensureSchemaCollectionExists()
+	if err != nil {
+		return err
+	}
+	if options == nil {
+		options = types.M{}
+	}
+	b, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+
+	qs := `UPDATE "_SCHEMA" SET "schema" = json_object_set_key("schema", $1::text, $2::jsonb) WHERE "className"=$3 `
+	_, err = p.conn().Exec(qs, "options", string(b), className)
 	if err != nil {
 		return err
 	}
@@ -102,18 +262,28 @@ func (p *PostgresAdapter) CreateClass(className string, schema types.M) (types.M
 		return nil, err
 	}
 
-	tx, err := p.db.Begin()
+	tx, err := p.begin()
 	if err != nil {
 		return nil, err
 	}
 
 	err = p.createTable(className, schema, tx)
 	if err != nil {
+		tx.Rollback()
 		return nil, err
 	}
 
+	for indexName, v := range utils.M(schema["indexes"]) {
+		_, err = tx.Exec(buildCreateIndexQuery(className, indexName, utils.M(v)))
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
 	_, err = tx.Exec(`INSERT INTO "_SCHEMA" ("className", "schema", "isParseClass") VALUES ($1, $2, $3)`, className, string(b), true)
 	if err != nil {
+		tx.Rollback()
 		if e, ok := err.(*pq.Error); ok {
 			if e.Code == postgresUniqueIndexViolationError {
 				return nil, errs.E(errs.DuplicateValue, "Class "+className+" already exists.")
@@ -131,7 +301,7 @@ func (p *PostgresAdapter) CreateClass(className string, schema types.M) (types.M
 }
 
 // createTable 仅创建表，不加入 schema 中
-func (p *PostgresAdapter) createTable(className string, schema types.M, tx *sql.Tx) error {
+func (p *PostgresAdapter) createTable(className string, schema types.M, tx *transaction) error {
 	if schema == nil {
 		schema = types.M{}
 	}
@@ -196,7 +366,7 @@ func (p *PostgresAdapter) createTable(className string, schema types.M, tx *sql.
 	if tx != nil {
 		_, err = tx.Exec(qs)
 	} else {
-		_, err = p.db.Exec(qs)
+		_, err = p.conn().Exec(qs)
 	}
 	if err != nil {
 		if e, ok := err.(*pq.Error); ok {
@@ -219,7 +389,7 @@ func (p *PostgresAdapter) createTable(className string, schema types.M, tx *sql.
 		if tx != nil {
 			_, err = tx.Exec(qs)
 		} else {
-			_, err = p.db.Exec(qs)
+			_, err = p.conn().Exec(qs)
 		}
 		if err != nil {
 			return err
@@ -235,7 +405,7 @@ func (p *PostgresAdapter) AddFieldIfNotExists(className, fieldName string, field
 		fieldType = types.M{}
 	}
 
-	tx, err := p.db.Begin()
+	tx, err := p.begin()
 	if err != nil {
 		return err
 	}
@@ -248,6 +418,8 @@ func (p *PostgresAdapter) AddFieldIfNotExists(className, fieldName string, field
 		qs := fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s`, className, fieldName, tp)
 		_, err = tx.Exec(qs)
 		if err != nil {
+			// 发生错误之后 tx 异常中止，需要先回滚再重新获取
+			tx.Rollback()
 			if e, ok := err.(*pq.Error); ok {
 				if e.Code == postgresRelationDoesNotExistError {
 					// TODO 添加默认字段
@@ -263,8 +435,7 @@ func (p *PostgresAdapter) AddFieldIfNotExists(className, fieldName string, field
 			} else {
 				return err
 			}
-			// 发生错误之后 tx 异常中止，需要重新获取
-			tx, err = p.db.Begin()
+			tx, err = p.begin()
 			if err != nil {
 				return err
 			}
@@ -279,13 +450,14 @@ func (p *PostgresAdapter) AddFieldIfNotExists(className, fieldName string, field
 	}
 
 	qs := `SELECT "schema" FROM "_SCHEMA" WHERE "className" = $1 and ("schema"::json->'fields'->$2) is not null`
-	rows, err := p.db.Query(qs, className, fieldName)
+	rows, err := p.conn().Query(qs, className, fieldName)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-	if rows.Next() {
-		return nil
+	exists := rows.Next()
+	rows.Close()
+	if exists {
+		return tx.Commit()
 	}
 
 	path := fmt.Sprintf(`{fields,%s}`, fieldName)
@@ -301,7 +473,7 @@ func (p *PostgresAdapter) AddFieldIfNotExists(className, fieldName string, field
 
 // DeleteClass 删除指定表
 func (p *PostgresAdapter) DeleteClass(className string) (types.M, error) {
-	tx, err := p.db.Begin()
+	tx, err := p.begin()
 
 	if err != nil {
 		return nil, err
@@ -329,7 +501,7 @@ func (p *PostgresAdapter) DeleteClass(className string) (types.M, error) {
 // DeleteAllClasses 删除所有表，仅用于测试
 func (p *PostgresAdapter) DeleteAllClasses() error {
 	qs := `SELECT "className","schema" FROM "_SCHEMA"`
-	rows, err := p.db.Query(qs)
+	rows, err := p.conn().Query(qs)
 	if err != nil {
 		if e, ok := err.(*pq.Error); ok && e.Code == postgresRelationDoesNotExistError {
 			// _SCHEMA 不存在，则不删除
@@ -367,7 +539,7 @@ func (p *PostgresAdapter) DeleteAllClasses() error {
 	classes = append(classes, classNames...)
 	classes = append(classes, joins...)
 
-	tx, err := p.db.Begin()
+	tx, err := p.begin()
 	if err != nil {
 		return err
 	}
@@ -417,7 +589,7 @@ func (p *PostgresAdapter) DeleteFields(className string, schema types.M, fieldNa
 		return err
 	}
 
-	tx, err := p.db.Begin()
+	tx, err := p.begin()
 	if err != nil {
 		return err
 	}
@@ -442,21 +614,119 @@ func (p *PostgresAdapter) DeleteFields(className string, schema types.M, fieldNa
 
 // CreateObject 创建对象
 func (p *PostgresAdapter) CreateObject(className string, schema, object types.M) error {
-	columnsArray := []string{}
-	valuesArray := types.S{}
-	geoPoints := types.M{}
+	row, err := toPostgresInsertRow(className, schema, object)
+	if err != nil || row == nil {
+		return err
+	}
+	qs := fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES %s`, className, row.columnsPattern(), row.valuesPattern(0))
+	_, err = p.conn().Exec(qs, row.values...)
+	return createError(err)
+}
+
+// postgresMaxParameters 单条 SQL 中参数数量的上限
+const postgresMaxParameters = 65535
+
+// CreateObjects 批量创建对象，相邻的列相同的对象合并为一条多行 INSERT ，所有对象在同一个事务中插入
+func (p *PostgresAdapter) CreateObjects(className string, schema types.M, objects []types.M) error {
+	rows := []*postgresInsertRow{}
+	for _, object := range objects {
+		row, err := toPostgresInsertRow(className, schema, object)
+		if err != nil {
+			return err
+		}
+		if row != nil {
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	tx, err := p.begin()
+	if err != nil {
+		return err
+	}
+	var conn executor = tx.Tx
+	if p.ctx != nil {
+		conn = &contextExecutor{ctx: p.ctx, conn: tx.Tx}
+	}
+	for start := 0; start < len(rows); {
+		columnsPattern := rows[start].columnsPattern()
+		valuesPatterns := []string{}
+		values := types.S{}
+		end := start
+		for end < len(rows) && rows[end].columnsPattern() == columnsPattern && len(values)+len(rows[end].values) <= postgresMaxParameters {
+			valuesPatterns = append(valuesPatterns, rows[end].valuesPattern(len(values)))
+			values = append(values, rows[end].values...)
+			end++
+		}
+		qs := fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES %s`, className, columnsPattern, strings.Join(valuesPatterns, ","))
+		_, err = conn.Exec(qs, values...)
+		if err != nil {
+			tx.Rollback()
+			return createError(err)
+		}
+		start = end
+	}
+	return tx.Commit()
+}
+
+// createError 转换插入对象时的错误
+func createError(err error) error {
+	if e, ok := err.(*pq.Error); ok {
+		if e.Code == postgresUniqueIndexViolationError {
+			return errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
+		}
+	}
+	return err
+}
+
+// postgresInsertRow 要插入的一行数据，列按名称排序
+// casts 为列对应的类型转换，为 point 时表示 GeoPoint ，使用 POINT($n, $n+1) 并占用两个参数
+type postgresInsertRow struct {
+	columns []string
+	casts   []string
+	values  types.S
+}
+
+// columnsPattern 返回 INSERT 语句中的列名
+func (r *postgresInsertRow) columnsPattern() string {
+	columns := []string{}
+	for _, column := range r.columns {
+		columns = append(columns, fmt.Sprintf(`"%s"`, column))
+	}
+	return strings.Join(columns, ",")
+}
+
+// valuesPattern 返回 INSERT 语句中的一行值，参数从 $offset+1 开始编号
+func (r *postgresInsertRow) valuesPattern(offset int) string {
+	patterns := []string{}
+	for _, cast := range r.casts {
+		if cast == "point" {
+			patterns = append(patterns, fmt.Sprintf(`POINT($%d, $%d)`, offset+1, offset+2))
+			offset += 2
+		} else {
+			offset++
+			patterns = append(patterns, fmt.Sprintf(`$%d%s`, offset, cast))
+		}
+	}
+	return "(" + strings.Join(patterns, ",") + ")"
+}
+
+// toPostgresInsertRow 把对象转换为要插入的一行数据，对象为空时返回 nil
+func toPostgresInsertRow(className string, schema, object types.M) (*postgresInsertRow, error) {
 	if schema == nil {
 		schema = types.M{}
 	}
 	if len(object) == 0 {
-		return nil
+		return nil, nil
 	}
 	schema = toPostgresSchema(schema)
 	object = handleDotFields(object)
 
 	err := validateKeys(object)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// 预处理 authData 字段，避免在遍历 map 并向其添加元素时造成的不稳定性
@@ -475,26 +745,36 @@ func (p *PostgresAdapter) CreateObject(className string, schema, object types.M)
 		}
 	}
 
+	fields := utils.M(schema["fields"])
+	if fields == nil {
+		fields = types.M{}
+	}
+	fieldNames := []string{}
 	for fieldName := range object {
-		columnsArray = append(columnsArray, fieldName)
+		fieldNames = append(fieldNames, fieldName)
+	}
+	sort.Strings(fieldNames)
 
-		fields := utils.M(schema["fields"])
-		if fields == nil {
-			fields = types.M{}
-		}
+	row := &postgresInsertRow{columns: []string{}, casts: []string{}, values: types.S{}}
+	add := func(fieldName, cast string, values ...interface{}) {
+		row.columns = append(row.columns, fieldName)
+		row.casts = append(row.casts, cast)
+		row.values = append(row.values, values...)
+	}
+	for _, fieldName := range fieldNames {
 		if fields[fieldName] == nil && className == "_User" {
 			if fieldName == "_email_verify_token" ||
 				fieldName == "_failed_login_count" ||
 				fieldName == "_perishable_token" {
-				valuesArray = append(valuesArray, object[fieldName])
+				add(fieldName, "", object[fieldName])
 			}
 
 			if fieldName == "_password_history" {
 				b, err := json.Marshal(object[fieldName])
 				if err != nil {
-					return err
+					return nil, err
 				}
-				valuesArray = append(valuesArray, b)
+				add(fieldName, "", b)
 			}
 
 			if fieldName == "_email_verify_token_expires_at" ||
@@ -502,9 +782,9 @@ func (p *PostgresAdapter) CreateObject(className string, schema, object types.M)
 				fieldName == "_perishable_token_expires_at" ||
 				fieldName == "_password_changed_at" {
 				if v := utils.M(object[fieldName]); v != nil && utils.S(v["iso"]) != "" {
-					valuesArray = append(valuesArray, v["iso"])
+					add(fieldName, "", v["iso"])
 				} else {
-					valuesArray = append(valuesArray, nil)
+					add(fieldName, "", nil)
 				}
 			}
 
@@ -518,20 +798,20 @@ func (p *PostgresAdapter) CreateObject(className string, schema, object types.M)
 		switch utils.S(tp["type"]) {
 		case "Date":
 			if v := utils.M(object[fieldName]); v != nil && utils.S(v["iso"]) != "" {
-				valuesArray = append(valuesArray, v["iso"])
+				add(fieldName, "", v["iso"])
 			} else {
-				valuesArray = append(valuesArray, nil)
+				add(fieldName, "", nil)
 			}
 		case "Pointer":
 			if v := utils.M(object[fieldName]); v != nil && utils.S(v["objectId"]) != "" {
-				valuesArray = append(valuesArray, v["objectId"])
+				add(fieldName, "", v["objectId"])
 			} else {
-				valuesArray = append(valuesArray, "")
+				add(fieldName, "", "")
 			}
 		case "Array":
 			b, err := json.Marshal(object[fieldName])
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if fieldName == "_rperm" || fieldName == "_wperm" {
 				// '[' => '{'
@@ -542,89 +822,51 @@ func (p *PostgresAdapter) CreateObject(className string, schema, object types.M)
 				if len(b) > 0 && b[len(b)-1] == 93 {
 					b[len(b)-1] = 125
 				}
+				add(fieldName, "::text[]", b)
+			} else {
+				add(fieldName, "::jsonb", b)
 			}
-			valuesArray = append(valuesArray, b)
 		case "Object":
 			b, err := json.Marshal(object[fieldName])
 			if err != nil {
-				return err
+				return nil, err
 			}
-			valuesArray = append(valuesArray, b)
+			add(fieldName, "", b)
 		case "String", "Number", "Boolean":
-			valuesArray = append(valuesArray, object[fieldName])
+			add(fieldName, "", object[fieldName])
 		case "File":
 			if v := utils.M(object[fieldName]); v != nil && utils.S(v["name"]) != "" {
-				valuesArray = append(valuesArray, v["name"])
+				add(fieldName, "", v["name"])
 			} else {
-				valuesArray = append(valuesArray, "")
+				add(fieldName, "", "")
 			}
 		case "GeoPoint":
-			geoPoints[fieldName] = object[fieldName]
-			columnsArray = columnsArray[:len(columnsArray)-1]
-		default:
-			return errs.E(errs.OtherCause, "Type "+utils.S(tp["type"])+" not supported yet")
-		}
-
-	}
-
-	for key := range geoPoints {
-		columnsArray = append(columnsArray, key)
-	}
-
-	initialValues := []string{}
-	for index := range valuesArray {
-		termination := ""
-		fieldName := columnsArray[index]
-		if fieldName == "_rperm" || fieldName == "_wperm" {
-			termination = "::text[]"
-		} else {
-			fields := utils.M(schema["fields"])
-			if fields == nil {
-				fields = types.M{}
+			value := utils.M(object[fieldName])
+			if value == nil {
+				value = types.M{}
 			}
-			tp := utils.M(fields[fieldName])
-			if tp == nil {
-				tp = types.M{}
+			add(fieldName, "point", value["longitude"], value["latitude"])
+		case "Polygon":
+			vertices, err := storage.ParsePolygon(object[fieldName])
+			if err != nil {
+				return nil, err
 			}
-			if utils.S(tp["type"]) == "Array" {
-				termination = "::jsonb"
+			add(fieldName, "::polygon", polygonToPostgres(vertices))
+		case "Bytes":
+			data, err := storage.ParseBytes(object[fieldName])
+			if err != nil {
+				return nil, err
 			}
+			add(fieldName, "", data)
+		default:
+			return nil, errs.E(errs.OtherCause, "Type "+utils.S(tp["type"])+" not supported yet")
 		}
-		initialValues = append(initialValues, fmt.Sprintf(`$%d%s`, index+1, termination))
 	}
-
-	geoPointsInjects := []string{}
-	for _, v := range geoPoints {
-		value := utils.M(v)
-		if value == nil {
-			value = types.M{}
-		}
-		valuesArray = append(valuesArray, value["longitude"], value["latitude"])
-		l := len(valuesArray)
-		geoPointsInjects = append(geoPointsInjects, fmt.Sprintf(`POINT($%d, $%d)`, l-1, l))
+	if len(row.columns) == 0 {
+		return nil, nil
 	}
 
-	columnsPatternArray := []string{}
-	for _, key := range columnsArray {
-		columnsPatternArray = append(columnsPatternArray, fmt.Sprintf(`"%s"`, key))
-	}
-	columnsPattern := strings.Join(columnsPatternArray, ",")
-
-	initialValues = append(initialValues, geoPointsInjects...)
-	valuesPattern := strings.Join(initialValues, ",")
-
-	qs := fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES (%s)`, className, columnsPattern, valuesPattern)
-	_, err = p.db.Exec(qs, valuesArray...)
-	if err != nil {
-		if e, ok := err.(*pq.Error); ok {
-			if e.Code == postgresUniqueIndexViolationError {
-				return errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
-			}
-		}
-		return err
-	}
-
-	return nil
+	return row, nil
 }
 
 // GetAllClasses ...
@@ -634,7 +876,7 @@ func (p *PostgresAdapter) GetAllClasses() ([]types.M, error) {
 		return nil, err
 	}
 	qs := `SELECT "className","schema" FROM "_SCHEMA"`
-	rows, err := p.db.Query(qs)
+	rows, err := p.conn().Query(qs)
 	if err != nil {
 		return nil, err
 	}
@@ -668,7 +910,7 @@ func (p *PostgresAdapter) GetClass(className string) (types.M, error) {
 		return nil, err
 	}
 	qs := `SELECT "schema" FROM "_SCHEMA" WHERE "className"=$1`
-	rows, err := p.db.Query(qs, className)
+	rows, err := p.conn().Query(qs, className)
 	if err != nil {
 		return nil, err
 	}
@@ -704,7 +946,7 @@ func (p *PostgresAdapter) DeleteObjectsByQuery(className string, schema, query t
 	}
 
 	qs := fmt.Sprintf(`WITH deleted AS (DELETE FROM "%s" WHERE %s RETURNING *) SELECT count(*) FROM deleted`, className, where.pattern)
-	row := p.db.QueryRow(qs, where.values...)
+	row := p.conn().QueryRow(qs, where.values...)
 	var count int
 	err = row.Scan(&count)
 	if err != nil {
@@ -729,6 +971,107 @@ func (p *PostgresAdapter) Find(className string, schema, query, options types.M)
 	if schema == nil {
 		schema = types.M{}
 	}
+	qs, values, err := buildFindQuery(className, schema, query, options)
+	if err != nil {
+		return nil, err
+	}
+	fields := utils.M(schema["fields"])
+	if fields == nil {
+		fields = types.M{}
+	}
+	results := []types.M{}
+	err = p.withQueryTimeout(func(conn executor) error {
+		rows, err := conn.Query(qs, values...)
+		if err != nil {
+			if e, ok := err.(*pq.Error); ok {
+				// 表不存在返回空
+				if e.Code == postgresRelationDoesNotExistError {
+					return nil
+				}
+			}
+			return err
+		}
+		defer rows.Close()
+		results, err = scanObjects(rows, fields)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// scanObjects 读取查询结果中的所有行，并转换为对象
+func scanObjects(rows *sql.Rows, fields types.M) ([]types.M, error) {
+	results := []types.M{}
+	var resultColumns []string
+	var err error
+	for rows.Next() {
+		if resultColumns == nil {
+			resultColumns, err = rows.Columns()
+			if err != nil {
+				return nil, err
+			}
+		}
+		resultValues := []*interface{}{}
+		values := types.S{}
+		for i := 0; i < len(resultColumns); i++ {
+			var v interface{}
+			resultValues = append(resultValues, &v)
+			values = append(values, &v)
+		}
+		err = rows.Scan(values...)
+		if err != nil {
+			return nil, err
+		}
+		object := types.M{}
+		for i, field := range resultColumns {
+			object[field] = *resultValues[i]
+		}
+
+		object, err = postgresObjectToParseObject(object, fields)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, object)
+	}
+	// 查询超时等错误在读取行时才会返回
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Explain 返回查找对象的 SQL 语句、参数以及 EXPLAIN ANALYZE 得到的执行计划
+func (p *PostgresAdapter) Explain(className string, schema, query, options types.M) (types.M, error) {
+	if schema == nil {
+		schema = types.M{}
+	}
+	qs, values, err := buildFindQuery(className, schema, query, options)
+	if err != nil {
+		return nil, err
+	}
+	var plan []byte
+	err = p.conn().QueryRow(`EXPLAIN (ANALYZE, FORMAT JSON) `+qs, values...).Scan(&plan)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = json.Unmarshal(plan, &result)
+	if err != nil {
+		return nil, err
+	}
+	return types.M{
+		"sql":    qs,
+		"values": values,
+		"plan":   result,
+	}, nil
+}
+
+// buildFindQuery 组装查找对象的 SQL 语句及参数
+func buildFindQuery(className string, schema, query, options types.M) (string, types.S, error) {
 	if options == nil {
 		options = types.M{}
 	}
@@ -745,7 +1088,7 @@ func (p *PostgresAdapter) Find(className string, schema, query, options types.M)
 	values := types.S{}
 	where, err := buildWhereClause(schema, query, 1)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	values = append(values, where.values...)
 
@@ -766,16 +1109,34 @@ func (p *PostgresAdapter) Find(className string, schema, query, options types.M)
 
 	var sortPattern string
 	if _, ok := options["sort"]; ok {
+		nullsFirst := options[storage.SortNullsFirst] == true
 		if keys, ok := options["sort"].([]string); ok {
 			postgresSort := []string{}
 			for _, key := range keys {
 				var postgresKey string
+				if strings.TrimPrefix(key, "-") == storage.TextScoreKey {
+					// 按相关度排序时总是由高到低
+					score, err := where.textScore()
+					if err != nil {
+						return "", nil, err
+					}
+					postgresSort = append(postgresSort, score+" DESC")
+					continue
+				}
 				if strings.HasPrefix(key, "-") {
 					key = key[1:]
 					postgresKey = fmt.Sprintf(`"%s" DESC`, key)
 				} else {
 					postgresKey = fmt.Sprintf(`"%s" ASC`, key)
 				}
+				// 游标分页时与其他数据库保持一致，空值视为最小值
+				if nullsFirst {
+					if strings.HasSuffix(postgresKey, "DESC") {
+						postgresKey += " NULLS LAST"
+					} else {
+						postgresKey += " NULLS FIRST"
+					}
+				}
 				postgresSort = append(postgresSort, postgresKey)
 			}
 			sorting := strings.Join(postgresSort, ",")
@@ -793,7 +1154,13 @@ func (p *PostgresAdapter) Find(className string, schema, query, options types.M)
 		if keys, ok := options["keys"].([]string); ok {
 			postgresKeys := []string{}
 			for _, key := range keys {
-				if key != "" {
+				if key == storage.TextScoreKey {
+					score, err := where.textScore()
+					if err != nil {
+						return "", nil, err
+					}
+					postgresKeys = append(postgresKeys, fmt.Sprintf(`%s AS "%s"`, score, storage.TextScoreField))
+				} else if key != "" {
 					postgresKeys = append(postgresKeys, fmt.Sprintf(`"%s"`, key))
 				}
 			}
@@ -804,57 +1171,7 @@ func (p *PostgresAdapter) Find(className string, schema, query, options types.M)
 	}
 
 	qs := fmt.Sprintf(`SELECT %s FROM "%s" %s %s %s %s`, columns, className, wherePattern, sortPattern, limitPattern, skipPattern)
-	rows, err := p.db.Query(qs, values...)
-	if err != nil {
-		if e, ok := err.(*pq.Error); ok {
-			// 表不存在返回空
-			if e.Code == postgresRelationDoesNotExistError {
-				return []types.M{}, nil
-			}
-		}
-		return nil, err
-	}
-	defer rows.Close()
-
-	fields := utils.M(schema["fields"])
-	if fields == nil {
-		fields = types.M{}
-	}
-
-	results := []types.M{}
-	var resultColumns []string
-	for rows.Next() {
-		if resultColumns == nil {
-			resultColumns, err = rows.Columns()
-			if err != nil {
-				return nil, err
-			}
-		}
-		resultValues := []*interface{}{}
-		values := types.S{}
-		for i := 0; i < len(resultColumns); i++ {
-			var v interface{}
-			resultValues = append(resultValues, &v)
-			values = append(values, &v)
-		}
-		err = rows.Scan(values...)
-		if err != nil {
-			return nil, err
-		}
-		object := types.M{}
-		for i, field := range resultColumns {
-			object[field] = *resultValues[i]
-		}
-
-		object, err = postgresObjectToParseObject(object, fields)
-		if err != nil {
-			return nil, err
-		}
-
-		results = append(results, object)
-	}
-
-	return results, nil
+	return qs, values, nil
 }
 
 // Count ...
@@ -870,22 +1187,25 @@ func (p *PostgresAdapter) Count(className string, schema, query types.M) (int, e
 	}
 
 	qs := fmt.Sprintf(`SELECT count(*) FROM "%s" %s`, className, wherePattern)
-	rows, err := p.db.Query(qs, where.values...)
-	if err != nil {
-		if e, ok := err.(*pq.Error); ok {
-			if e.Code == postgresRelationDoesNotExistError {
-				return 0, nil
-			}
-		}
-		return 0, err
-	}
-	defer rows.Close()
 	var count int
-	if rows.Next() {
-		err = rows.Scan(&count)
+	err = p.withQueryTimeout(func(conn executor) error {
+		rows, err := conn.Query(qs, where.values...)
 		if err != nil {
-			return 0, nil
+			if e, ok := err.(*pq.Error); ok {
+				if e.Code == postgresRelationDoesNotExistError {
+					return nil
+				}
+			}
+			return err
 		}
+		defer rows.Close()
+		if rows.Next() {
+			rows.Scan(&count)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	return count, nil
@@ -1043,6 +1363,24 @@ func (p *PostgresAdapter) FindOneAndUpdate(className string, schema, query, upda
 				values = append(values, object["longitude"], object["latitude"])
 				index = index + 2
 				continue
+			case "Polygon":
+				vertices, err := storage.ParsePolygon(object)
+				if err != nil {
+					return nil, err
+				}
+				updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = $%d::polygon`, fieldName, index))
+				values = append(values, polygonToPostgres(vertices))
+				index = index + 1
+				continue
+			case "Bytes":
+				data, err := storage.ParseBytes(object)
+				if err != nil {
+					return nil, err
+				}
+				updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = $%d`, fieldName, index))
+				values = append(values, data)
+				index = index + 1
+				continue
 			case "Relation":
 				continue
 			}
@@ -1150,7 +1488,7 @@ func (p *PostgresAdapter) FindOneAndUpdate(className string, schema, query, upda
 
 	// TODO 需要添加限制，只更新一条，UpdateObjectsByQuery 时更新多条
 	qs := fmt.Sprintf(`UPDATE "%s" SET %s WHERE %s RETURNING *`, className, strings.Join(updatePatterns, ","), where.pattern)
-	rows, err := p.db.Query(qs, values...)
+	rows, err := p.conn().Query(qs, values...)
 	if err != nil {
 		if e, ok := err.(*pq.Error); ok {
 			// 表不存在返回空
@@ -1226,7 +1564,7 @@ func (p *PostgresAdapter) EnsureUniqueness(className string, schema types.M, fie
 	}
 
 	qs := fmt.Sprintf(`ALTER TABLE "%s" ADD CONSTRAINT "%s" UNIQUE (%s)`, className, constraintName, strings.Join(constraintPatterns, ","))
-	_, err := p.db.Exec(qs)
+	_, err := p.conn().Exec(qs)
 	if err != nil {
 		if e, ok := err.(*pq.Error); ok {
 			if e.Code == postgresDuplicateRelationError && strings.Contains(e.Message, constraintName) {
@@ -1241,6 +1579,129 @@ func (p *PostgresAdapter) EnsureUniqueness(className string, schema types.M, fie
 	return nil
 }
 
+// CreateIndex 创建索引，并把索引定义保存到 _SCHEMA 中
+// Postgres 不支持 TTL 索引，对于 TTL 索引仅创建普通索引
+func (p *PostgresAdapter) CreateIndex(className string, schema types.M, indexName string, index types.M) error {
+	tx, err := p.begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(buildCreateIndexQuery(className, indexName, index))
+	if err != nil {
+		tx.Rollback()
+		if e, ok := err.(*pq.Error); ok && e.Code == postgresDuplicateRelationError {
+			return errs.E(errs.DuplicateValue, "Index "+indexName+" already exists.")
+		}
+		return err
+	}
+	err = setIndexDefinition(tx, className, indexName, index)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DropIndex 删除索引，并从 _SCHEMA 中删除索引定义
+func (p *PostgresAdapter) DropIndex(className string, indexName string) error {
+	tx, err := p.begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`DROP INDEX IF EXISTS "%s"`, postgresIndexName(className, indexName)))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = setIndexDefinition(tx, className, indexName, nil)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetIndexes 获取 _SCHEMA 中保存的索引定义
+func (p *PostgresAdapter) GetIndexes(className string) (types.M, error) {
+	schema, err := p.GetClass(className)
+	if err != nil {
+		return nil, err
+	}
+	if indexes := utils.M(schema["indexes"]); indexes != nil {
+		return indexes, nil
+	}
+	return types.M{}, nil
+}
+
+// setIndexDefinition 更新 _SCHEMA 中的索引定义， index 为空时删除该索引
+func setIndexDefinition(tx *transaction, className, indexName string, index types.M) error {
+	var v []byte
+	err := tx.QueryRow(`SELECT "schema" FROM "_SCHEMA" WHERE "className"=$1 FOR UPDATE`, className).Scan(&v)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errs.E(errs.InvalidClassName, "Class "+className+" does not exist.")
+		}
+		return err
+	}
+	var schema types.M
+	err = json.Unmarshal(v, &schema)
+	if err != nil {
+		return err
+	}
+	indexes := utils.M(schema["indexes"])
+	if indexes == nil {
+		indexes = types.M{}
+	}
+	if index == nil {
+		delete(indexes, indexName)
+	} else {
+		indexes[indexName] = index
+	}
+	b, err := json.Marshal(indexes)
+	if err != nil {
+		return err
+	}
+	qs := `UPDATE "_SCHEMA" SET "schema" = json_object_set_key("schema", $1::text, $2::jsonb) WHERE "className"=$3 `
+	_, err = tx.Exec(qs, "indexes", string(b), className)
+	return err
+}
+
+// postgresIndexName 索引名在数据库中需要唯一，使用类名作为前缀
+func postgresIndexName(className, indexName string) string {
+	return className + "_" + indexName
+}
+
+// buildCreateIndexQuery 组装创建索引的语句
+// 全文索引使用 GIN 索引，稀疏索引使用部分索引实现
+func buildCreateIndexQuery(className, indexName string, index types.M) string {
+	fields := storage.IndexFields(index)
+	name := postgresIndexName(className, indexName)
+	if storage.IndexIsText(index) {
+		language := postgresTextLanguage(storage.IndexLanguage(index))
+		columns := []string{}
+		for _, field := range fields {
+			columns = append(columns, fmt.Sprintf(`coalesce("%s", '')`, field.Name))
+		}
+		return fmt.Sprintf(`CREATE INDEX "%s" ON "%s" USING GIN (to_tsvector('%s', %s))`, name, className, language, strings.Join(columns, ` || ' ' || `))
+	}
+
+	columns := []string{}
+	conditions := []string{}
+	for _, field := range fields {
+		if field.Descending {
+			columns = append(columns, fmt.Sprintf(`"%s" DESC`, field.Name))
+		} else {
+			columns = append(columns, fmt.Sprintf(`"%s" ASC`, field.Name))
+		}
+		conditions = append(conditions, fmt.Sprintf(`"%s" IS NOT NULL`, field.Name))
+	}
+	qs := fmt.Sprintf(`CREATE INDEX "%s" ON "%s" (%s)`, name, className, strings.Join(columns, ", "))
+	if storage.IndexIsSparse(index) {
+		qs += " WHERE " + strings.Join(conditions, " OR ")
+	}
+	return qs
+}
+
 // PerformInitialization ...
 func (p *PostgresAdapter) PerformInitialization(options types.M) error {
 	if options == nil {
@@ -1266,7 +1727,7 @@ func (p *PostgresAdapter) PerformInitialization(options types.M) error {
 		}
 	}
 
-	tx, err := p.db.Begin()
+	tx, err := p.begin()
 	if err != nil {
 		return err
 	}
@@ -1306,9 +1767,47 @@ func (p *PostgresAdapter) PerformInitialization(options types.M) error {
 
 // HandleShutdown 关闭数据库
 func (p *PostgresAdapter) HandleShutdown() {
+	if p.tx != nil {
+		p.tx.Rollback()
+		return
+	}
 	p.db.Close()
 }
 
+// Begin 开始事务，返回的适配器中的所有操作都在该事务中执行
+func (p *PostgresAdapter) Begin() (storage.Transaction, error) {
+	if p.tx != nil {
+		return nil, errs.E(errs.OperationForbidden, "Transaction already started.")
+	}
+	tx, err := p.beginTx()
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresAdapter{
+		collectionPrefix: p.collectionPrefix,
+		collectionList:   []string{},
+		db:               p.db,
+		tx:               tx,
+		ctx:              p.ctx,
+	}, nil
+}
+
+// Commit 提交事务
+func (p *PostgresAdapter) Commit() error {
+	if p.tx == nil {
+		return errs.E(errs.OperationForbidden, "Transaction not started.")
+	}
+	return p.tx.Commit()
+}
+
+// Rollback 回滚事务
+func (p *PostgresAdapter) Rollback() error {
+	if p.tx == nil {
+		return errs.E(errs.OperationForbidden, "Transaction not started.")
+	}
+	return p.tx.Rollback()
+}
+
 func postgresObjectToParseObject(object, fields types.M) (types.M, error) {
 	if len(object) == 0 {
 		return object, nil
@@ -1371,6 +1870,25 @@ func postgresObjectToParseObject(object, fields types.M) (types.M, error) {
 				"longitude": longitude,
 				"latitude":  latitude,
 			}
+		} else if objectType == "Polygon" && object[fieldName] != nil {
+			// object[fieldName] = ((10,20),(30,20),(30,40)) (longitude, latitude)
+			resString := ""
+			if v, ok := object[fieldName].([]byte); ok {
+				resString = string(v)
+			} else if v, ok := object[fieldName].(string); ok {
+				resString = v
+			}
+			vertices, err := parsePostgresPolygon(resString)
+			if err != nil {
+				return nil, err
+			}
+			object[fieldName] = storage.PolygonToJSON(vertices)
+		} else if objectType == "Bytes" && object[fieldName] != nil {
+			if v, ok := object[fieldName].([]byte); ok {
+				object[fieldName] = storage.BytesToJSON(v)
+			} else if v, ok := object[fieldName].(string); ok {
+				object[fieldName] = storage.BytesToJSON([]byte(v))
+			}
 		} else if objectType == "File" && object[fieldName] != nil {
 			if v, ok := object[fieldName].([]byte); ok {
 				object[fieldName] = types.M{
@@ -1556,6 +2074,10 @@ func parseTypeToPostgresType(t types.M) (string, error) {
 		return "double precision", nil
 	case "GeoPoint":
 		return "point", nil
+	case "Polygon":
+		return "polygon", nil
+	case "Bytes":
+		return "bytea", nil
 	case "Array":
 		if contents := utils.M(t["contents"]); contents != nil {
 			if utils.S(contents["type"]) == "String" {
@@ -1576,10 +2098,46 @@ func toPostgresValue(value interface{}) interface{} {
 		if utils.S(v["__type"]) == "File" {
 			return v["name"]
 		}
+		if utils.S(v["__type"]) == "Bytes" {
+			if data, err := storage.ParseBytes(v); err == nil {
+				return data
+			}
+		}
 	}
 	return value
 }
 
+// polygonToPostgres 转换为 polygon 类型的值 ((longitude, latitude), ...)
+func polygonToPostgres(vertices []storage.Vertex) string {
+	points := []string{}
+	for _, v := range vertices {
+		points = append(points, fmt.Sprintf("(%v, %v)", v[0], v[1]))
+	}
+	return fmt.Sprintf("(%s)", strings.Join(points, ", "))
+}
+
+// parsePostgresPolygon 解析数据库中 polygon 类型的值 ((10,20),(30,20),(30,40))
+func parsePostgresPolygon(s string) ([]storage.Vertex, error) {
+	s = strings.NewReplacer("(", "", ")", "", " ", "").Replace(s)
+	numbers := strings.Split(s, ",")
+	if len(numbers)%2 != 0 {
+		return nil, errs.E(errs.InternalServerError, "bad polygon value")
+	}
+	vertices := []storage.Vertex{}
+	for i := 0; i+1 < len(numbers); i += 2 {
+		longitude, err := strconv.ParseFloat(numbers[i], 64)
+		if err != nil {
+			return nil, err
+		}
+		latitude, err := strconv.ParseFloat(numbers[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		vertices = append(vertices, storage.Vertex{longitude, latitude})
+	}
+	return vertices, nil
+}
+
 func transformValue(value interface{}) interface{} {
 	if v := utils.M(value); v != nil {
 		if utils.S(v["__type"]) == "Pointer" {
@@ -1640,11 +2198,18 @@ func toParseSchema(schema types.M) types.M {
 		}
 	}
 
-	return types.M{
+	result := types.M{
 		"className":             schema["className"],
 		"fields":                fields,
 		"classLevelPermissions": clps,
 	}
+	if indexes := utils.M(schema["indexes"]); len(indexes) > 0 {
+		result["indexes"] = types.M(indexes)
+	}
+	if options := utils.M(schema["options"]); len(options) > 0 {
+		result["options"] = types.M(options)
+	}
+	return result
 }
 
 func toPostgresSchema(schema types.M) types.M {
@@ -1748,12 +2313,14 @@ type whereClause struct {
 	pattern string
 	values  types.S
 	sorts   []string
+	scores  []string // 全文检索的相关度表达式
 }
 
 func buildWhereClause(schema, query types.M, index int) (*whereClause, error) {
 	patterns := []string{}
 	values := types.S{}
 	sorts := []string{}
+	var scores []string
 
 	schema = toPostgresSchema(schema)
 	if schema == nil {
@@ -1828,6 +2395,7 @@ func buildWhereClause(schema, query types.M, index int) (*whereClause, error) {
 							clauseValues = append(clauseValues, clause.values...)
 							index = index + len(clause.values)
 						}
+						scores = append(scores, clause.scores...)
 					}
 				}
 			}
@@ -1855,7 +2423,7 @@ func buildWhereClause(schema, query types.M, index int) (*whereClause, error) {
 						patterns = append(patterns, fmt.Sprintf(`"%s" IS NOT NULL`, fieldName))
 					} else {
 						patterns = append(patterns, fmt.Sprintf(`("%s" <> $%d OR "%s" IS NULL)`, fieldName, index, fieldName))
-						values = append(values, value["$ne"])
+						values = append(values, toPostgresValue(value["$ne"]))
 						index = index + 1
 					}
 				}
@@ -1866,7 +2434,7 @@ func buildWhereClause(schema, query types.M, index int) (*whereClause, error) {
 					patterns = append(patterns, fmt.Sprintf(`"%s" IS NULL`, fieldName))
 				} else {
 					patterns = append(patterns, fmt.Sprintf(`"%s" = $%d`, fieldName, index))
-					values = append(values, v)
+					values = append(values, toPostgresValue(v))
 					index = index + 1
 				}
 			}
@@ -1922,7 +2490,7 @@ func buildWhereClause(schema, query types.M, index int) (*whereClause, error) {
 						} else {
 							inPatterns := []string{}
 							for listIndex, listElem := range baseArray {
-								values = append(values, listElem)
+								values = append(values, toPostgresValue(listElem))
 								inPatterns = append(inPatterns, fmt.Sprintf("$%d", index+listIndex))
 							}
 							patterns = append(patterns, fmt.Sprintf(`"%s" %s IN (%s)`, fieldName, not, strings.Join(inPatterns, ",")))
@@ -1986,7 +2554,15 @@ func buildWhereClause(schema, query types.M, index int) (*whereClause, error) {
 			}
 
 			if geoWithin := utils.M(value["$geoWithin"]); geoWithin != nil {
-				if polygon := utils.A(geoWithin["$polygon"]); polygon != nil {
+				if polygon := utils.M(geoWithin["$polygon"]); polygon != nil {
+					vertices, err := storage.ParsePolygon(polygon)
+					if err != nil {
+						return nil, err
+					}
+					patterns = append(patterns, fmt.Sprintf(`"%s"::point <@ $%d::polygon`, fieldName, index))
+					values = append(values, polygonToPostgres(vertices))
+					index = index + 1
+				} else if polygon := utils.A(geoWithin["$polygon"]); polygon != nil {
 					points := []string{}
 					for _, p := range polygon {
 						if point := utils.M(p); point != nil && utils.S(point["__type"]) == "GeoPoint" {
@@ -2006,6 +2582,16 @@ func buildWhereClause(schema, query types.M, index int) (*whereClause, error) {
 				}
 			}
 
+			if geoIntersects := utils.M(value["$geoIntersects"]); geoIntersects != nil {
+				longitude, latitude, ok := storage.GeoPointOf(geoIntersects["$point"])
+				if ok == false {
+					return nil, errs.E(errs.InvalidJSON, "bad $geoIntersects value; $point should be GeoPoint")
+				}
+				patterns = append(patterns, fmt.Sprintf(`"%s"::polygon @> $%d::point`, fieldName, index))
+				values = append(values, fmt.Sprintf("(%v, %v)", longitude, latitude))
+				index = index + 1
+			}
+
 			if regex := utils.S(value["$regex"]); regex != "" {
 				operator := "~"
 				opts := utils.S(value["$options"])
@@ -2023,6 +2609,24 @@ func buildWhereClause(schema, query types.M, index int) (*whereClause, error) {
 				patterns = append(patterns, fmt.Sprintf(`"%s" %s '%s'`, fieldName, operator, regex))
 			}
 
+			if text, ok := value["$text"]; ok {
+				search, err := storage.ParseTextSearch(text)
+				if err != nil {
+					return nil, err
+				}
+				if search.CaseSensitive {
+					return nil, errs.E(errs.InvalidJSON, "bad $text: $caseSensitive not supported, please use $regex or create a separate lower case column.")
+				}
+				// 与全文索引使用相同的表达式，以便查询时能够使用索引
+				language := postgresTextLanguage(search.Language)
+				document := fmt.Sprintf(`to_tsvector('%s', coalesce("%s", ''))`, language, fieldName)
+				tsquery := fmt.Sprintf(`plainto_tsquery('%s', $%d)`, language, index)
+				patterns = append(patterns, fmt.Sprintf(`%s @@ %s`, document, tsquery))
+				scores = append(scores, fmt.Sprintf(`ts_rank(%s, %s)`, document, tsquery))
+				values = append(values, search.Term)
+				index = index + 1
+			}
+
 			if utils.S(value["__type"]) == "Pointer" {
 				if isArrayField {
 					patterns = append(patterns, fmt.Sprintf(`array_contains("%s", $%d)`, fieldName, index))
@@ -2042,6 +2646,16 @@ func buildWhereClause(schema, query types.M, index int) (*whereClause, error) {
 				index = index + 1
 			}
 
+			if utils.S(value["__type"]) == "Bytes" {
+				data, err := storage.ParseBytes(value)
+				if err != nil {
+					return nil, err
+				}
+				patterns = append(patterns, fmt.Sprintf(`"%s" = $%d`, fieldName, index))
+				values = append(values, data)
+				index = index + 1
+			}
+
 			for cmp, pgComparator := range parseToPosgresComparator {
 				if v, ok := value[cmp]; ok {
 					patterns = append(patterns, fmt.Sprintf(`"%s" %s $%d`, fieldName, pgComparator, index))
@@ -2063,7 +2677,48 @@ func buildWhereClause(schema, query types.M, index int) (*whereClause, error) {
 	for i, v := range values {
 		values[i] = transformValue(v)
 	}
-	return &whereClause{strings.Join(patterns, " AND "), values, sorts}, nil
+	return &whereClause{strings.Join(patterns, " AND "), values, sorts, scores}, nil
+}
+
+// textScore 返回全文检索的相关度表达式，包含多个 $text 时相关度为各项之和
+func (w *whereClause) textScore() (string, error) {
+	if len(w.scores) == 0 {
+		return "", errs.E(errs.InvalidQuery, "$score can only be used with $text queries.")
+	}
+	return strings.Join(w.scores, " + "), nil
+}
+
+// postgresTextLanguage 把全文检索的语言转换为 Postgres 的文本检索配置，未指定时使用 english
+// 支持 MongoDB 中使用的语言代码， none 表示不进行词干提取
+func postgresTextLanguage(language string) string {
+	switch language {
+	case "":
+		return "english"
+	case "none":
+		return "simple"
+	}
+	if name, ok := textLanguageCodes[language]; ok {
+		return name
+	}
+	return language
+}
+
+var textLanguageCodes = map[string]string{
+	"da": "danish",
+	"de": "german",
+	"en": "english",
+	"es": "spanish",
+	"fi": "finnish",
+	"fr": "french",
+	"hu": "hungarian",
+	"it": "italian",
+	"nb": "norwegian",
+	"nl": "dutch",
+	"pt": "portuguese",
+	"ro": "romanian",
+	"ru": "russian",
+	"sv": "swedish",
+	"tr": "turkish",
 }
 
 func removeWhiteSpace(s string) string {
@@ -0,0 +1,448 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+	"github.com/lib/pq"
+)
+
+// Aggregate 对符合条件的对象执行聚合管道，管道被转换为嵌套的子查询
+func (p *PostgresAdapter) Aggregate(className string, schema, query types.M, pipeline types.S) ([]types.M, error) {
+	if schema == nil {
+		schema = types.M{}
+	}
+	qs, values, fields, err := buildAggregateQuery(className, schema, query, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := p.conn().Query(qs, values...)
+	if err != nil {
+		if e, ok := err.(*pq.Error); ok {
+			if e.Code == postgresRelationDoesNotExistError {
+				return []types.M{}, nil
+			}
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	transformed := storage.PipelineTransformsObjects(pipeline)
+	results := []types.M{}
+	for rows.Next() {
+		object, err := scanObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		if transformed {
+			object, err = aggregateObjectToParseObject(object, fields)
+		} else {
+			object, err = postgresObjectToParseObject(object, fields)
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, object)
+	}
+	return results, rows.Err()
+}
+
+// Distinct 返回符合条件的对象中 fieldName 字段的不同取值，数组字段取其中的元素
+func (p *PostgresAdapter) Distinct(className string, schema, query types.M, fieldName string) (types.S, error) {
+	if schema == nil {
+		schema = types.M{}
+	}
+	qs, values, err := buildDistinctQuery(className, schema, query, fieldName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := p.conn().Query(qs, values...)
+	if err != nil {
+		if e, ok := err.(*pq.Error); ok {
+			if e.Code == postgresRelationDoesNotExistError {
+				return types.S{}, nil
+			}
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tp types.M
+	if fields := utils.M(schema["fields"]); fields != nil {
+		tp = utils.M(fields[fieldName])
+	}
+	results := types.S{}
+	for rows.Next() {
+		var v interface{}
+		err = rows.Scan(&v)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		if utils.S(tp["type"]) == "Array" {
+			b, ok := v.([]byte)
+			if ok == false {
+				continue
+			}
+			var item interface{}
+			err = json.Unmarshal(b, &item)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, item)
+			continue
+		}
+		object, err := postgresObjectToParseObject(types.M{fieldName: v}, types.M{fieldName: tp})
+		if err != nil {
+			return nil, err
+		}
+		if value, ok := object[fieldName]; ok {
+			results = append(results, value)
+		}
+	}
+	return results, rows.Err()
+}
+
+// scanObject 把当前行转换为以列名为 key 的对象
+func scanObject(rows *sql.Rows) (types.M, error) {
+	resultColumns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	resultValues := make([]interface{}, len(resultColumns))
+	values := make(types.S, len(resultColumns))
+	for i := range resultValues {
+		values[i] = &resultValues[i]
+	}
+	err = rows.Scan(values...)
+	if err != nil {
+		return nil, err
+	}
+	object := types.M{}
+	for i, field := range resultColumns {
+		object[field] = resultValues[i]
+	}
+	return object, nil
+}
+
+// aggregateLevel 聚合查询中的一层子查询，相邻的 $sort $skip $limit 合并到同一层中
+type aggregateLevel struct {
+	query string   // 不包含排序与分页的查询语句
+	sort  []string // 排序字段，字段前加 - 表示降序
+	skip  string
+	limit string
+}
+
+func (l *aggregateLevel) String() string {
+	qs := l.query
+	if len(l.sort) > 0 {
+		sorts := []string{}
+		for _, key := range l.sort {
+			if strings.HasPrefix(key, "-") {
+				sorts = append(sorts, fmt.Sprintf(`"%s" DESC`, key[1:]))
+			} else {
+				sorts = append(sorts, fmt.Sprintf(`"%s" ASC`, key))
+			}
+		}
+		qs = qs + " ORDER BY " + strings.Join(sorts, ",")
+	}
+	if l.limit != "" {
+		qs = qs + " LIMIT " + l.limit
+	}
+	if l.skip != "" {
+		qs = qs + " OFFSET " + l.skip
+	}
+	return qs
+}
+
+// wrap 以当前层为子查询生成新的一层，子查询的排序在外层继续生效
+func (l *aggregateLevel) wrap() *aggregateLevel {
+	return &aggregateLevel{
+		query: fmt.Sprintf(`SELECT * FROM (%s) AS "_a"`, l.String()),
+		sort:  l.sort,
+	}
+}
+
+// buildAggregateQuery 把聚合管道转换为查询语句，同时返回输出对象的字段类型
+func buildAggregateQuery(className string, schema, query types.M, pipeline types.S) (string, types.S, types.M, error) {
+	where, err := buildWhereClause(schema, query, 1)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	values := types.S{}
+	values = append(values, where.values...)
+	wherePattern := ""
+	if where.pattern != "" {
+		wherePattern = " WHERE " + where.pattern
+	}
+
+	fields := utils.M(schema["fields"])
+	if fields == nil {
+		fields = types.M{}
+	}
+	level := &aggregateLevel{query: fmt.Sprintf(`SELECT * FROM "%s"%s`, className, wherePattern)}
+	for _, v := range pipeline {
+		stage := utils.M(v)
+		op, arg := storage.StageOperator(stage)
+		switch op {
+		case "$group":
+			group := utils.M(arg)
+			columns := []string{}
+			groupBy := []string{}
+			if keyFields := storage.GroupKeyFields(group["objectId"]); keyFields != nil {
+				pairs := []string{}
+				for _, alias := range sortedKeys(keyFields) {
+					pairs = append(pairs, fmt.Sprintf(`'%s', "%s"`, alias, keyFields[alias]))
+					groupBy = append(groupBy, fmt.Sprintf(`"%s"`, keyFields[alias]))
+				}
+				columns = append(columns, fmt.Sprintf(`jsonb_build_object(%s) AS "objectId"`, strings.Join(pairs, ", ")))
+			} else if field := storage.FieldReference(group["objectId"]); field != "" {
+				columns = append(columns, fmt.Sprintf(`"%s" AS "objectId"`, field))
+				groupBy = append(groupBy, fmt.Sprintf(`"%s"`, field))
+			}
+			for _, alias := range sortedKeys(group) {
+				if alias == "objectId" {
+					continue
+				}
+				column, err := aggregateAccumulator(storage.Accumulator(group[alias]))
+				if err != nil {
+					return "", nil, nil, err
+				}
+				columns = append(columns, fmt.Sprintf(`%s AS "%s"`, column, alias))
+			}
+			qs := fmt.Sprintf(`SELECT %s FROM (%s) AS "_a"`, strings.Join(columns, ", "), level.String())
+			if len(groupBy) > 0 {
+				qs = qs + " GROUP BY " + strings.Join(groupBy, ", ")
+			} else {
+				// 没有分组依据时，空表不输出结果
+				qs = qs + " HAVING COUNT(*) > 0"
+			}
+			level = &aggregateLevel{query: qs}
+		case "$project":
+			project := utils.M(arg)
+			columns := []string{}
+			renamed := map[string]string{}
+			for _, alias := range sortedKeys(project) {
+				field := storage.FieldReference(project[alias])
+				columns = append(columns, fmt.Sprintf(`"%s" AS "%s"`, field, alias))
+				renamed[field] = alias
+			}
+			sorts := []string{}
+			for _, key := range level.sort {
+				prefix := ""
+				if strings.HasPrefix(key, "-") {
+					prefix, key = "-", key[1:]
+				}
+				alias, ok := renamed[key]
+				if ok == false {
+					// 排序字段不再输出时，不再保证顺序
+					sorts = nil
+					break
+				}
+				sorts = append(sorts, prefix+alias)
+			}
+			level = &aggregateLevel{
+				query: fmt.Sprintf(`SELECT %s FROM (%s) AS "_a"`, strings.Join(columns, ", "), level.String()),
+				sort:  sorts,
+			}
+		case "$match":
+			pattern, matchValues := buildAggregateMatch(utils.M(arg), len(values)+1)
+			values = append(values, matchValues...)
+			level = level.wrap()
+			if pattern != "" {
+				level.query = level.query + " WHERE " + pattern
+			}
+		case "$sort":
+			if level.skip != "" || level.limit != "" {
+				level = level.wrap()
+			}
+			level.sort = storage.SortKeys(arg)
+		case "$skip":
+			if level.skip != "" || level.limit != "" {
+				level = level.wrap()
+			}
+			level.skip = fmt.Sprintf("$%d", len(values)+1)
+			values = append(values, arg)
+		case "$limit":
+			if level.limit != "" {
+				level = level.wrap()
+			}
+			level.limit = fmt.Sprintf("$%d", len(values)+1)
+			values = append(values, arg)
+		default:
+			return "", nil, nil, errs.E(errs.InvalidQuery, "Invalid aggregate stage: "+op)
+		}
+		fields = storage.AggregateOutputFields(fields, stage)
+	}
+	return level.String(), values, fields, nil
+}
+
+// aggregateAccumulator 转换累加器为聚合函数
+func aggregateAccumulator(op string, arg interface{}) (string, error) {
+	if op == "$sum" {
+		switch n := arg.(type) {
+		case float64:
+			return fmt.Sprintf(`CAST(COUNT(*) AS double precision) * %s`, strconv.FormatFloat(n, 'f', -1, 64)), nil
+		case int:
+			return fmt.Sprintf(`CAST(COUNT(*) AS double precision) * %d`, n), nil
+		}
+	}
+	field := storage.FieldReference(arg)
+	if field == "" {
+		return "", errs.E(errs.InvalidQuery, "Invalid argument for "+op)
+	}
+	switch op {
+	case "$sum":
+		return fmt.Sprintf(`SUM("%s")`, field), nil
+	case "$avg":
+		return fmt.Sprintf(`AVG("%s")`, field), nil
+	case "$min":
+		return fmt.Sprintf(`MIN("%s")`, field), nil
+	case "$max":
+		return fmt.Sprintf(`MAX("%s")`, field), nil
+	}
+	return "", errs.E(errs.InvalidQuery, "Invalid accumulator: "+op)
+}
+
+// buildAggregateMatch 转换作用于聚合输出的 $match 条件
+func buildAggregateMatch(match types.M, index int) (string, types.S) {
+	patterns := []string{}
+	values := types.S{}
+	placeholder := func(v interface{}) string {
+		values = append(values, v)
+		return fmt.Sprintf("$%d", index+len(values)-1)
+	}
+	for _, field := range sortedKeys(match) {
+		constraint := utils.M(match[field])
+		if constraint == nil {
+			constraint = types.M{"$eq": match[field]}
+		}
+		for _, op := range sortedKeys(constraint) {
+			v := constraint[op]
+			switch op {
+			case "$eq":
+				if v == nil {
+					patterns = append(patterns, fmt.Sprintf(`"%s" IS NULL`, field))
+				} else {
+					patterns = append(patterns, fmt.Sprintf(`"%s" = %s`, field, placeholder(v)))
+				}
+			case "$ne":
+				if v == nil {
+					patterns = append(patterns, fmt.Sprintf(`"%s" IS NOT NULL`, field))
+				} else {
+					patterns = append(patterns, fmt.Sprintf(`("%s" <> %s OR "%s" IS NULL)`, field, placeholder(v), field))
+				}
+			case "$gt", "$gte", "$lt", "$lte":
+				patterns = append(patterns, fmt.Sprintf(`"%s" %s %s`, field, parseToPosgresComparator[op], placeholder(v)))
+			case "$in", "$nin":
+				inPatterns := []string{}
+				for _, item := range utils.A(v) {
+					inPatterns = append(inPatterns, placeholder(item))
+				}
+				if op == "$in" {
+					if len(inPatterns) == 0 {
+						patterns = append(patterns, "false")
+					} else {
+						patterns = append(patterns, fmt.Sprintf(`"%s" IN (%s)`, field, strings.Join(inPatterns, ", ")))
+					}
+				} else if len(inPatterns) > 0 {
+					patterns = append(patterns, fmt.Sprintf(`("%s" NOT IN (%s) OR "%s" IS NULL)`, field, strings.Join(inPatterns, ", "), field))
+				}
+			}
+		}
+	}
+	return strings.Join(patterns, " AND "), values
+}
+
+// buildDistinctQuery 组装 distinct 查询语句，数组字段展开其中的元素
+func buildDistinctQuery(className string, schema, query types.M, fieldName string) (string, types.S, error) {
+	where, err := buildWhereClause(schema, query, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	wherePattern := ""
+	if where.pattern != "" {
+		wherePattern = " WHERE " + where.pattern
+	}
+	column := fmt.Sprintf(`"%s"`, fieldName)
+	if fields := utils.M(schema["fields"]); fields != nil {
+		if tp := utils.M(fields[fieldName]); tp != nil && utils.S(tp["type"]) == "Array" {
+			column = fmt.Sprintf(`jsonb_array_elements("%s")`, fieldName)
+		}
+	}
+	qs := fmt.Sprintf(`SELECT DISTINCT %s AS "%s" FROM "%s"%s`, column, fieldName, className, wherePattern)
+	return qs, where.values, nil
+}
+
+// aggregateObjectToParseObject 按照输出字段的类型转换聚合结果
+func aggregateObjectToParseObject(object, fields types.M) (types.M, error) {
+	object, err := postgresObjectToParseObject(object, fields)
+	if err != nil {
+		return nil, err
+	}
+	// 由对象组成的分组依据中的各个字段以 json 格式返回，需要单独转换
+	for fieldName, v := range fields {
+		tp := utils.M(v)
+		subFields := utils.M(tp["fields"])
+		value := utils.M(object[fieldName])
+		if utils.S(tp["type"]) != "Object" || subFields == nil || value == nil {
+			continue
+		}
+		for subFieldName, subValue := range value {
+			subType := utils.M(subFields[subFieldName])
+			s, ok := subValue.(string)
+			if subType == nil || ok == false {
+				continue
+			}
+			switch utils.S(subType["type"]) {
+			case "Pointer":
+				value[subFieldName] = types.M{
+					"__type":    "Pointer",
+					"className": subType["targetClass"],
+					"objectId":  s,
+				}
+			case "Date":
+				t, err := time.Parse(time.RFC3339Nano, s)
+				if err != nil {
+					return nil, err
+				}
+				value[subFieldName] = types.M{
+					"__type": "Date",
+					"iso":    utils.TimetoString(t),
+				}
+			}
+		}
+	}
+	return object, nil
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch v := m.(type) {
+	case map[string]string:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case types.M:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]interface{}:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lfq7413/tomato/types"
+)
+
+func Test_buildAggregateQuery(t *testing.T) {
+	schema := types.M{
+		"fields": types.M{
+			"objectId": types.M{"type": "String"},
+			"player":   types.M{"type": "Pointer", "targetClass": "_User"},
+			"level":    types.M{"type": "String"},
+			"points":   types.M{"type": "Number"},
+		},
+	}
+	tests := []struct {
+		name       string
+		pipeline   types.S
+		wantQuery  string
+		wantValues types.S
+		wantFields types.M
+	}{
+		{
+			name: "1",
+			pipeline: types.S{
+				types.M{"$group": types.M{"objectId": "$player", "total": types.M{"$sum": "$points"}, "count": types.M{"$sum": 1}}},
+				types.M{"$sort": []string{"-total"}},
+				types.M{"$limit": 10},
+			},
+			wantQuery:  `SELECT "player" AS "objectId", CAST(COUNT(*) AS double precision) * 1 AS "count", SUM("points") AS "total" FROM (SELECT * FROM "score" WHERE "level" = $1) AS "_a" GROUP BY "player" ORDER BY "total" DESC LIMIT $2`,
+			wantValues: types.S{"a", 10},
+			wantFields: types.M{
+				"objectId": types.M{"type": "Pointer", "targetClass": "_User"},
+				"total":    types.M{"type": "Number"},
+				"count":    types.M{"type": "Number"},
+			},
+		},
+		{
+			name: "2",
+			pipeline: types.S{
+				types.M{"$group": types.M{"objectId": nil, "average": types.M{"$avg": "$points"}}},
+			},
+			wantQuery:  `SELECT AVG("points") AS "average" FROM (SELECT * FROM "score" WHERE "level" = $1) AS "_a" HAVING COUNT(*) > 0`,
+			wantValues: types.S{"a"},
+			wantFields: types.M{"average": types.M{"type": "Number"}},
+		},
+		{
+			name: "3",
+			pipeline: types.S{
+				types.M{"$group": types.M{"objectId": types.M{"player": "$player", "level": "$level"}, "max": types.M{"$max": "$points"}}},
+				types.M{"$match": types.M{"max": types.M{"$gt": 10, "$ne": nil}}},
+			},
+			wantQuery:  `SELECT * FROM (SELECT jsonb_build_object('level', "level", 'player', "player") AS "objectId", MAX("points") AS "max" FROM (SELECT * FROM "score" WHERE "level" = $1) AS "_a" GROUP BY "level", "player") AS "_a" WHERE "max" > $2 AND "max" IS NOT NULL`,
+			wantValues: types.S{"a", 10},
+			wantFields: types.M{
+				"objectId": types.M{"type": "Object", "fields": types.M{
+					"player": types.M{"type": "Pointer", "targetClass": "_User"},
+					"level":  types.M{"type": "String"},
+				}},
+				"max": types.M{"type": "Number"},
+			},
+		},
+		{
+			name: "4",
+			pipeline: types.S{
+				types.M{"$sort": []string{"points"}},
+				types.M{"$limit": 5},
+				types.M{"$project": types.M{"objectId": "$objectId", "score": "$points"}},
+				types.M{"$skip": 1},
+			},
+			wantQuery:  `SELECT "objectId" AS "objectId", "points" AS "score" FROM (SELECT * FROM "score" WHERE "level" = $1 ORDER BY "points" ASC LIMIT $2) AS "_a" ORDER BY "score" ASC OFFSET $3`,
+			wantValues: types.S{"a", 5, 1},
+			wantFields: types.M{
+				"objectId": types.M{"type": "String"},
+				"score":    types.M{"type": "Number"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		query, values, fields, err := buildAggregateQuery("score", schema, types.M{"level": "a"}, tt.pipeline)
+		if err != nil {
+			t.Errorf("%q. buildAggregateQuery() error = %v", tt.name, err)
+			continue
+		}
+		if query != tt.wantQuery {
+			t.Errorf("%q. buildAggregateQuery() query = %v, want %v", tt.name, query, tt.wantQuery)
+		}
+		if reflect.DeepEqual(values, tt.wantValues) == false {
+			t.Errorf("%q. buildAggregateQuery() values = %v, want %v", tt.name, values, tt.wantValues)
+		}
+		if reflect.DeepEqual(fields, tt.wantFields) == false {
+			t.Errorf("%q. buildAggregateQuery() fields = %v, want %v", tt.name, fields, tt.wantFields)
+		}
+	}
+
+	_, _, _, err := buildAggregateQuery("score", schema, types.M{}, types.S{types.M{"$unwind": "$tags"}})
+	if err == nil {
+		t.Error("buildAggregateQuery() expect error")
+	}
+}
+
+func Test_buildDistinctQuery(t *testing.T) {
+	schema := types.M{
+		"fields": types.M{
+			"level": types.M{"type": "String"},
+			"tags":  types.M{"type": "Array"},
+		},
+	}
+	query, values, _ := buildDistinctQuery("score", schema, types.M{"level": "a"}, "tags")
+	if query != `SELECT DISTINCT jsonb_array_elements("tags") AS "tags" FROM "score" WHERE "level" = $1` || reflect.DeepEqual(values, types.S{"a"}) == false {
+		t.Errorf("buildDistinctQuery() = %v, %v", query, values)
+	}
+	query, values, _ = buildDistinctQuery("score", schema, types.M{}, "level")
+	if query != `SELECT DISTINCT "level" AS "level" FROM "score"` || len(values) != 0 {
+		t.Errorf("buildDistinctQuery() = %v, %v", query, values)
+	}
+}
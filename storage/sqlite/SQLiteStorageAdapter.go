@@ -0,0 +1,2524 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+	"github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchemaCollectionName = "_SCHEMA"
+
+// SQLiteAdapter sqlite 数据库适配器，适用于单机部署与测试环境
+type SQLiteAdapter struct {
+	collectionPrefix string
+	collectionList   []string
+	db               *sql.DB
+	tx               *sql.Tx // 不为空时，所有操作都在该事务中执行
+	savepoints       int
+	ctx              context.Context // 不为空时，所有操作在 ctx 取消或超时后中止
+}
+
+// executor *sql.DB 与 *sql.Tx 的公共操作
+type executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// contextExecutor 使用 ctx 执行 SQL 的 executor
+type contextExecutor struct {
+	ctx  context.Context
+	conn interface {
+		ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+		QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+		QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	}
+}
+
+func (c *contextExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+func (c *contextExecutor) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(c.ctx, query, args...)
+}
+
+func (c *contextExecutor) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
+}
+
+// transaction 适配器内部使用的事务，适配器已经处于事务中时，使用保存点实现嵌套
+type transaction struct {
+	*sql.Tx
+	savepoint string
+}
+
+// Commit 提交事务，嵌套时释放保存点
+func (t *transaction) Commit() error {
+	if t.savepoint != "" {
+		_, err := t.Exec(`RELEASE SAVEPOINT ` + t.savepoint)
+		return err
+	}
+	return t.Tx.Commit()
+}
+
+// Rollback 回滚事务，嵌套时回滚到保存点
+func (t *transaction) Rollback() error {
+	if t.savepoint != "" {
+		_, err := t.Exec(`ROLLBACK TO SAVEPOINT ` + t.savepoint)
+		return err
+	}
+	return t.Tx.Rollback()
+}
+
+// NewSQLiteAdapter ...
+func NewSQLiteAdapter(collectionPrefix string, db *sql.DB) *SQLiteAdapter {
+	return &SQLiteAdapter{
+		collectionPrefix: collectionPrefix,
+		collectionList:   []string{},
+		db:               db,
+	}
+}
+
+// conn 返回执行 SQL 的对象，处于事务中时返回该事务
+func (s *SQLiteAdapter) conn() executor {
+	if s.ctx != nil {
+		if s.tx != nil {
+			return &contextExecutor{ctx: s.ctx, conn: s.tx}
+		}
+		return &contextExecutor{ctx: s.ctx, conn: s.db}
+	}
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// WithContext 返回在 ctx 中执行所有操作的适配器，处于事务中时共用同一个事务
+func (s *SQLiteAdapter) WithContext(ctx context.Context) storage.Adapter {
+	adapter := *s
+	adapter.ctx = ctx
+	return &adapter
+}
+
+// beginTx 开始数据库事务，设置了 ctx 时，ctx 取消后事务自动回滚
+func (s *SQLiteAdapter) beginTx() (*sql.Tx, error) {
+	if s.ctx != nil {
+		return s.db.BeginTx(s.ctx, nil)
+	}
+	return s.db.Begin()
+}
+
+// begin 开始适配器内部的事务
+func (s *SQLiteAdapter) begin() (*transaction, error) {
+	if s.tx == nil {
+		tx, err := s.beginTx()
+		if err != nil {
+			return nil, err
+		}
+		return &transaction{Tx: tx}, nil
+	}
+	s.savepoints++
+	savepoint := fmt.Sprintf("tomato_savepoint_%d", s.savepoints)
+	_, err := s.tx.Exec(`SAVEPOINT ` + savepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{Tx: s.tx, savepoint: savepoint}, nil
+}
+
+// ensureSchemaCollectionExists 确保 _SCHEMA 表存在，不存在则创建表
+func (s *SQLiteAdapter) ensureSchemaCollectionExists(ex executor) error {
+	if ex == nil {
+		ex = s.conn()
+	}
+	_, err := ex.Exec(`CREATE TABLE IF NOT EXISTS "_SCHEMA" ( "className" varChar(120), "schema" text, "isParseClass" boolean, PRIMARY KEY ("className") )`)
+	return err
+}
+
+// ClassExists 检测数据库中是否存在指定类
+func (s *SQLiteAdapter) ClassExists(name string) bool {
+	var result bool
+	err := s.conn().QueryRow(`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?1)`, name).Scan(&result)
+	if err != nil {
+		return false
+	}
+	return result
+}
+
+// SetClassLevelPermissions 设置类级别权限
+func (s *SQLiteAdapter) SetClassLevelPermissions(className string, CLPs types.M) error {
+	err := s.ensureSchemaCollectionExists(nil)
+	if err != nil {
+		return err
+	}
+	if CLPs == nil {
+		CLPs = types.M{}
+	}
+	b, err := json.Marshal(CLPs)
+	if err != nil {
+		return err
+	}
+
+	qs := `UPDATE "_SCHEMA" SET "schema" = json_object_set_key("schema", ?1, ?2) WHERE "className" = ?3`
+	_, err = s.conn().Exec(qs, "classLevelPermissions", string(b), className)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetClassOptions 设置类的选项
+func (s *SQLiteAdapter) SetClassOptions(className string, options types.M) error {
+	err := s.ensureSchemaCollectionExists(nil)
+	if err != nil {
+		return err
+	}
+	if options == nil {
+		options = types.M{}
+	}
+	b, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+
+	qs := `UPDATE "_SCHEMA" SET "schema" = json_object_set_key("schema", ?1, ?2) WHERE "className" = ?3`
+	_, err = s.conn().Exec(qs, "options", string(b), className)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateClass 创建类
+func (s *SQLiteAdapter) CreateClass(className string, schema types.M) (types.M, error) {
+	if schema == nil {
+		schema = types.M{}
+	}
+	schema["className"] = className
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ensureSchemaCollectionExists(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.createTable(className, schema, tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for indexName, v := range utils.M(schema["indexes"]) {
+		if qs := buildCreateIndexQuery(className, indexName, utils.M(v)); qs != "" {
+			_, err = tx.Exec(qs)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO "_SCHEMA" ("className", "schema", "isParseClass") VALUES (?1, ?2, ?3)`, className, string(b), true)
+	if err != nil {
+		tx.Rollback()
+		if isUniqueViolation(err) {
+			return nil, errs.E(errs.DuplicateValue, "Class "+className+" already exists.")
+		}
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return toParseSchema(schema), nil
+}
+
+// createTable 仅创建表，不加入 schema 中
+func (s *SQLiteAdapter) createTable(className string, schema types.M, tx *transaction) error {
+	var ex = s.conn()
+	if tx != nil {
+		ex = tx
+	}
+	if schema == nil {
+		schema = types.M{}
+	}
+	patternsArray := []string{}
+	fields := utils.M(schema["fields"])
+	if fields == nil {
+		fields = types.M{}
+	}
+
+	if className == "_User" {
+		fields["_email_verify_token_expires_at"] = types.M{"type": "Date"}
+		fields["_email_verify_token"] = types.M{"type": "String"}
+		fields["_account_lockout_expires_at"] = types.M{"type": "Date"}
+		fields["_failed_login_count"] = types.M{"type": "Number"}
+		fields["_perishable_token"] = types.M{"type": "String"}
+		fields["_perishable_token_expires_at"] = types.M{"type": "Date"}
+		fields["_password_changed_at"] = types.M{"type": "Date"}
+		fields["_password_history"] = types.M{"type": "Array"}
+	}
+
+	relations := []string{}
+
+	// 保证建表语句中字段顺序稳定
+	fieldNames := []string{}
+	for fieldName := range fields {
+		fieldNames = append(fieldNames, fieldName)
+	}
+	sort.Strings(fieldNames)
+
+	for _, fieldName := range fieldNames {
+		parseType := utils.M(fields[fieldName])
+		if parseType == nil {
+			parseType = types.M{}
+		}
+
+		if utils.S(parseType["type"]) == "Relation" {
+			relations = append(relations, fieldName)
+			continue
+		}
+
+		if fieldName == "_rperm" || fieldName == "_wperm" {
+			parseType["contents"] = types.M{"type": "String"}
+		}
+
+		sqliteType, err := parseTypeToSQLiteType(parseType)
+		if err != nil {
+			return err
+		}
+
+		if fieldName == "objectId" {
+			patternsArray = append(patternsArray, fmt.Sprintf(`"%s" %s PRIMARY KEY`, fieldName, sqliteType))
+		} else {
+			patternsArray = append(patternsArray, fmt.Sprintf(`"%s" %s`, fieldName, sqliteType))
+		}
+	}
+
+	if len(patternsArray) == 0 {
+		// SQLite 中的表至少需要包含一列
+		patternsArray = append(patternsArray, `"objectId" varChar(120) PRIMARY KEY`)
+	}
+
+	err := s.ensureSchemaCollectionExists(ex)
+	if err != nil {
+		return err
+	}
+
+	qs := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (%s)`, className, strings.Join(patternsArray, ","))
+	_, err = ex.Exec(qs)
+	if err != nil {
+		return err
+	}
+
+	// 创建 relation 表
+	for _, fieldName := range relations {
+		name := fmt.Sprintf(`_Join:%s:%s`, fieldName, className)
+		qs = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" ("relatedId" varChar(120), "owningId" varChar(120), PRIMARY KEY("relatedId", "owningId") )`, name)
+		_, err = ex.Exec(qs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddFieldIfNotExists 添加字段定义
+func (s *SQLiteAdapter) AddFieldIfNotExists(className, fieldName string, fieldType types.M) error {
+	if fieldType == nil {
+		fieldType = types.M{}
+	}
+
+	err := s.ensureSchemaCollectionExists(nil)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+
+	if utils.S(fieldType["type"]) != "Relation" {
+		tp, err := parseTypeToSQLiteType(fieldType)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		qs := fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s`, className, fieldName, tp)
+		_, err = tx.Exec(qs)
+		if err != nil {
+			if isNoSuchTable(err) {
+				// 表不存在时，直接创建包含该字段的类
+				tx.Rollback()
+				_, err = s.CreateClass(className, types.M{"fields": types.M{fieldName: fieldType}})
+				return err
+			} else if isDuplicateColumn(err) {
+				// Column 已经存在，由其他请求创建
+			} else {
+				tx.Rollback()
+				return err
+			}
+		}
+	} else {
+		name := fmt.Sprintf(`_Join:%s:%s`, fieldName, className)
+		qs := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" ("relatedId" varChar(120), "owningId" varChar(120), PRIMARY KEY("relatedId", "owningId") )`, name)
+		_, err := tx.Exec(qs)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	schema, err := loadSchema(tx, className)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if schema == nil {
+		// 类不存在时不更新 _SCHEMA
+		return tx.Commit()
+	}
+	fields := utils.M(schema["fields"])
+	if fields == nil {
+		fields = types.M{}
+	}
+	if fields[fieldName] != nil {
+		return tx.Commit()
+	}
+	fields[fieldName] = fieldType
+	schema["fields"] = fields
+
+	b, err := json.Marshal(schema)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`UPDATE "_SCHEMA" SET "schema" = ?1 WHERE "className" = ?2`, string(b), className)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteClass 删除指定表
+func (s *SQLiteAdapter) DeleteClass(className string) (types.M, error) {
+	err := s.ensureSchemaCollectionExists(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	qs := fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, className)
+	_, err = tx.Exec(qs)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	qs = `DELETE FROM "_SCHEMA" WHERE "className" = ?1`
+	_, err = tx.Exec(qs, className)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return types.M{}, nil
+}
+
+// DeleteAllClasses 删除所有表，仅用于测试
+func (s *SQLiteAdapter) DeleteAllClasses() error {
+	qs := `SELECT "className","schema" FROM "_SCHEMA"`
+	rows, err := s.conn().Query(qs)
+	if err != nil {
+		if isNoSuchTable(err) {
+			// _SCHEMA 不存在，则不删除
+			return nil
+		}
+		return err
+	}
+
+	classNames := []string{}
+	schemas := []types.M{}
+
+	for rows.Next() {
+		var clsName string
+		var sch types.M
+		var v string
+		err := rows.Scan(&clsName, &v)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		err = json.Unmarshal([]byte(v), &sch)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		classNames = append(classNames, clsName)
+		schemas = append(schemas, sch)
+	}
+	rows.Close()
+
+	joins := []string{}
+	for _, sch := range schemas {
+		joins = append(joins, joinTablesForSchema(sch)...)
+	}
+
+	classes := []string{"_SCHEMA", "_PushStatus", "_JobStatus", "_Hooks", "_GlobalConfig"}
+	classes = append(classes, classNames...)
+	classes = append(classes, joins...)
+
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range classes {
+		qs = fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, name)
+		_, err = tx.Exec(qs)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteFields 删除字段
+// SQLite 不支持 DROP COLUMN ，需要重建数据表
+func (s *SQLiteAdapter) DeleteFields(className string, schema types.M, fieldNames []string) error {
+	if schema == nil {
+		schema = types.M{}
+	}
+
+	fields := utils.M(schema["fields"])
+	if fields == nil {
+		fields = types.M{}
+	}
+	fldNames := []string{}
+	for _, fieldName := range fieldNames {
+		field := utils.M(fields[fieldName])
+		if field != nil && utils.S(field["type"]) == "Relation" {
+			// 不处理 Relation 类型字段
+		} else {
+			fldNames = append(fldNames, fieldName)
+		}
+		delete(fields, fieldName)
+	}
+	schema["fields"] = fields
+
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+
+	err = s.ensureSchemaCollectionExists(nil)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+
+	qs := `UPDATE "_SCHEMA" SET "schema" = ?1 WHERE "className" = ?2`
+	_, err = tx.Exec(qs, string(b), className)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if len(fldNames) > 0 {
+		err = dropColumns(tx, className, fldNames)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// dropColumns 通过重建数据表的方式删除列，并恢复未涉及删除列的索引
+func dropColumns(tx *transaction, className string, fieldNames []string) error {
+	dropped := map[string]bool{}
+	for _, fieldName := range fieldNames {
+		dropped[fieldName] = true
+	}
+
+	rows, err := tx.Query(fmt.Sprintf(`PRAGMA table_info("%s")`, className))
+	if err != nil {
+		return err
+	}
+	columns := []string{}
+	patterns := []string{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, tp string
+		var defaultValue interface{}
+		err = rows.Scan(&cid, &name, &tp, &notNull, &defaultValue, &pk)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if dropped[name] {
+			continue
+		}
+		columns = append(columns, fmt.Sprintf(`"%s"`, name))
+		if pk > 0 {
+			patterns = append(patterns, fmt.Sprintf(`"%s" %s PRIMARY KEY`, name, tp))
+		} else {
+			patterns = append(patterns, fmt.Sprintf(`"%s" %s`, name, tp))
+		}
+	}
+	rows.Close()
+	if len(columns) == 0 {
+		// 表不存在
+		return nil
+	}
+
+	rows, err = tx.Query(`SELECT "sql" FROM sqlite_master WHERE type = 'index' AND tbl_name = ?1 AND "sql" IS NOT NULL`, className)
+	if err != nil {
+		return err
+	}
+	indexes := []string{}
+	for rows.Next() {
+		var qs string
+		err = rows.Scan(&qs)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		keep := true
+		for _, fieldName := range fieldNames {
+			if strings.Contains(qs, `"`+fieldName+`"`) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			indexes = append(indexes, qs)
+		}
+	}
+	rows.Close()
+
+	tmpName := "_tmp_" + className
+	qs := fmt.Sprintf(`CREATE TABLE "%s" (%s)`, tmpName, strings.Join(patterns, ","))
+	if _, err = tx.Exec(qs); err != nil {
+		return err
+	}
+	qs = fmt.Sprintf(`INSERT INTO "%s" (%s) SELECT %s FROM "%s"`, tmpName, strings.Join(columns, ","), strings.Join(columns, ","), className)
+	if _, err = tx.Exec(qs); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf(`DROP TABLE "%s"`, className)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "%s"`, tmpName, className)); err != nil {
+		return err
+	}
+	for _, qs := range indexes {
+		if _, err = tx.Exec(qs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateObject 创建对象
+func (s *SQLiteAdapter) CreateObject(className string, schema, object types.M) error {
+	columns, values, err := toSQLiteInsertRow(className, schema, object)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	_, err = s.conn().Exec(insertQuery(className, columns), values...)
+	return createError(err)
+}
+
+// CreateObjects 批量创建对象，所有对象在同一个事务中插入
+func (s *SQLiteAdapter) CreateObjects(className string, schema types.M, objects []types.M) error {
+	type row struct {
+		columns []string
+		values  types.S
+	}
+	rows := []row{}
+	for _, object := range objects {
+		columns, values, err := toSQLiteInsertRow(className, schema, object)
+		if err != nil {
+			return err
+		}
+		if len(columns) > 0 {
+			rows = append(rows, row{columns: columns, values: values})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		_, err = tx.Exec(insertQuery(className, r.columns), r.values...)
+		if err != nil {
+			tx.Rollback()
+			return createError(err)
+		}
+	}
+	return tx.Commit()
+}
+
+// insertQuery 返回插入一行数据的 SQL
+func insertQuery(className string, columns []string) string {
+	columnsPatternArray := []string{}
+	initialValues := []string{}
+	for index, key := range columns {
+		columnsPatternArray = append(columnsPatternArray, fmt.Sprintf(`"%s"`, key))
+		initialValues = append(initialValues, fmt.Sprintf(`?%d`, index+1))
+	}
+	return fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES (%s)`, className, strings.Join(columnsPatternArray, ","), strings.Join(initialValues, ","))
+}
+
+// createError 转换插入对象时的错误
+func createError(err error) error {
+	if err != nil && isUniqueViolation(err) {
+		return errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
+	}
+	return err
+}
+
+// toSQLiteInsertRow 把对象转换为要插入的列与对应的值，对象为空时返回空的列
+func toSQLiteInsertRow(className string, schema, object types.M) ([]string, types.S, error) {
+	columnsArray := []string{}
+	valuesArray := types.S{}
+	if schema == nil {
+		schema = types.M{}
+	}
+	if len(object) == 0 {
+		return nil, nil, nil
+	}
+	schema = toSQLiteSchema(schema)
+	object = handleDotFields(object)
+
+	err := validateKeys(object)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 预处理 authData 字段，避免在遍历 map 并向其添加元素时造成的不稳定性
+	for fieldName := range object {
+		re := regexp.MustCompile(`^_auth_data_([a-zA-Z0-9_]+)$`)
+		authDataMatch := re.FindStringSubmatch(fieldName)
+		if authDataMatch != nil && len(authDataMatch) == 2 {
+			provider := authDataMatch[1]
+			authData := utils.M(object["authData"])
+			if authData == nil {
+				authData = types.M{}
+			}
+			authData[provider] = object[fieldName]
+			delete(object, fieldName)
+			object["authData"] = authData
+		}
+	}
+
+	fields := utils.M(schema["fields"])
+	if fields == nil {
+		fields = types.M{}
+	}
+
+	for fieldName := range object {
+		if fields[fieldName] == nil && className == "_User" {
+			if fieldName == "_email_verify_token" ||
+				fieldName == "_failed_login_count" ||
+				fieldName == "_perishable_token" {
+				columnsArray = append(columnsArray, fieldName)
+				valuesArray = append(valuesArray, object[fieldName])
+			}
+
+			if fieldName == "_password_history" {
+				b, err := json.Marshal(object[fieldName])
+				if err != nil {
+					return nil, nil, err
+				}
+				columnsArray = append(columnsArray, fieldName)
+				valuesArray = append(valuesArray, string(b))
+			}
+
+			if fieldName == "_email_verify_token_expires_at" ||
+				fieldName == "_account_lockout_expires_at" ||
+				fieldName == "_perishable_token_expires_at" ||
+				fieldName == "_password_changed_at" {
+				columnsArray = append(columnsArray, fieldName)
+				if v := utils.M(object[fieldName]); v != nil && utils.S(v["iso"]) != "" {
+					valuesArray = append(valuesArray, v["iso"])
+				} else {
+					valuesArray = append(valuesArray, nil)
+				}
+			}
+
+			continue
+		}
+
+		columnsArray = append(columnsArray, fieldName)
+		tp := utils.M(fields[fieldName])
+		if tp == nil {
+			tp = types.M{}
+		}
+		switch utils.S(tp["type"]) {
+		case "Date":
+			if v := utils.M(object[fieldName]); v != nil && utils.S(v["iso"]) != "" {
+				valuesArray = append(valuesArray, v["iso"])
+			} else if v, ok := object[fieldName].(string); ok {
+				valuesArray = append(valuesArray, v)
+			} else {
+				valuesArray = append(valuesArray, nil)
+			}
+		case "Pointer":
+			if v := utils.M(object[fieldName]); v != nil && utils.S(v["objectId"]) != "" {
+				valuesArray = append(valuesArray, v["objectId"])
+			} else {
+				valuesArray = append(valuesArray, "")
+			}
+		case "Array", "Object":
+			b, err := json.Marshal(object[fieldName])
+			if err != nil {
+				return nil, nil, err
+			}
+			valuesArray = append(valuesArray, string(b))
+		case "String", "Number", "Boolean":
+			valuesArray = append(valuesArray, object[fieldName])
+		case "File":
+			if v := utils.M(object[fieldName]); v != nil && utils.S(v["name"]) != "" {
+				valuesArray = append(valuesArray, v["name"])
+			} else {
+				valuesArray = append(valuesArray, "")
+			}
+		case "GeoPoint":
+			valuesArray = append(valuesArray, toSQLitePoint(object[fieldName]))
+		case "Polygon":
+			vertices, err := storage.ParsePolygon(object[fieldName])
+			if err != nil {
+				return nil, nil, err
+			}
+			valuesArray = append(valuesArray, toSQLitePolygon(vertices))
+		case "Bytes":
+			data, err := storage.ParseBytes(object[fieldName])
+			if err != nil {
+				return nil, nil, err
+			}
+			valuesArray = append(valuesArray, data)
+		default:
+			return nil, nil, errs.E(errs.OtherCause, "Type "+utils.S(tp["type"])+" not supported yet")
+		}
+	}
+
+	return columnsArray, valuesArray, nil
+}
+
+// GetAllClasses ...
+func (s *SQLiteAdapter) GetAllClasses() ([]types.M, error) {
+	err := s.ensureSchemaCollectionExists(nil)
+	if err != nil {
+		return nil, err
+	}
+	qs := `SELECT "className","schema" FROM "_SCHEMA"`
+	rows, err := s.conn().Query(qs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schemas := []types.M{}
+
+	for rows.Next() {
+		var clsName string
+		var sch types.M
+		var v string
+		err := rows.Scan(&clsName, &v)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(v), &sch)
+		if err != nil {
+			return nil, err
+		}
+		sch["className"] = clsName
+		schemas = append(schemas, toParseSchema(sch))
+	}
+
+	return schemas, nil
+}
+
+// GetClass ...
+func (s *SQLiteAdapter) GetClass(className string) (types.M, error) {
+	err := s.ensureSchemaCollectionExists(nil)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := loadSchema(s.conn(), className)
+	if err != nil {
+		return nil, err
+	}
+	if schema == nil {
+		return types.M{}, nil
+	}
+
+	return toParseSchema(schema), nil
+}
+
+// loadSchema 从 _SCHEMA 中读取原始的 schema ，不存在时返回 nil
+func loadSchema(ex executor, className string) (types.M, error) {
+	var v string
+	err := ex.QueryRow(`SELECT "schema" FROM "_SCHEMA" WHERE "className" = ?1`, className).Scan(&v)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var schema types.M
+	err = json.Unmarshal([]byte(v), &schema)
+	if err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// DeleteObjectsByQuery 删除符合条件的所有对象
+func (s *SQLiteAdapter) DeleteObjectsByQuery(className string, schema, query types.M) error {
+	where, err := buildWhereClause(schema, query, 1)
+	if err != nil {
+		return err
+	}
+
+	if len(query) == 0 || where.pattern == "" {
+		where.pattern = "1"
+	}
+
+	qs := fmt.Sprintf(`DELETE FROM "%s" WHERE %s`, className, where.pattern)
+	result, err := s.conn().Exec(qs, where.values...)
+	if err != nil {
+		// 表不存在返回空
+		if isNoSuchTable(err) {
+			return errs.E(errs.ObjectNotFound, "Object not found.")
+		}
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errs.E(errs.ObjectNotFound, "Object not found.")
+	}
+
+	return nil
+}
+
+// Find ...
+func (s *SQLiteAdapter) Find(className string, schema, query, options types.M) ([]types.M, error) {
+	if schema == nil {
+		schema = types.M{}
+	}
+	qs, values, err := buildFindQuery(className, schema, query, options)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().Query(qs, values...)
+	if err != nil {
+		// 表不存在返回空
+		if isNoSuchTable(err) {
+			return []types.M{}, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	fields := utils.M(schema["fields"])
+	if fields == nil {
+		fields = types.M{}
+	}
+
+	results := []types.M{}
+	for rows.Next() {
+		object, err := scanObject(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		object, err = sqliteObjectToParseObject(object, fields)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, object)
+	}
+
+	return results, rows.Err()
+}
+
+// Explain 返回查找对象的 SQL 语句、参数以及 EXPLAIN QUERY PLAN 得到的执行计划
+func (s *SQLiteAdapter) Explain(className string, schema, query, options types.M) (types.M, error) {
+	if schema == nil {
+		schema = types.M{}
+	}
+	qs, values, err := buildFindQuery(className, schema, query, options)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().Query(`EXPLAIN QUERY PLAN `+qs, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plan := types.S{}
+	for rows.Next() {
+		step, err := scanObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		if detail, ok := step["detail"].([]byte); ok {
+			step["detail"] = string(detail)
+		}
+		plan = append(plan, step)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return types.M{
+		"sql":    qs,
+		"values": values,
+		"plan":   plan,
+	}, nil
+}
+
+// buildFindQuery 组装查找对象的 SQL 语句及参数
+func buildFindQuery(className string, schema, query, options types.M) (string, types.S, error) {
+	if options == nil {
+		options = types.M{}
+	}
+
+	var hasLimit bool
+	var hasSkip bool
+	if _, ok := options["limit"]; ok {
+		hasLimit = true
+	}
+	if _, ok := options["skip"]; ok {
+		hasSkip = true
+	}
+
+	values := types.S{}
+	where, err := buildWhereClause(schema, query, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	values = append(values, where.values...)
+
+	var wherePattern string
+	var limitPattern string
+	var skipPattern string
+	if where.pattern != "" {
+		wherePattern = `WHERE ` + where.pattern
+	}
+	if hasLimit {
+		limitPattern = fmt.Sprintf(`LIMIT ?%d`, len(values)+1)
+		values = append(values, options["limit"])
+	} else if hasSkip {
+		// SQLite 中 OFFSET 必须与 LIMIT 同时使用
+		limitPattern = `LIMIT -1`
+	}
+	if hasSkip {
+		skipPattern = fmt.Sprintf(`OFFSET ?%d`, len(values)+1)
+		values = append(values, options["skip"])
+	}
+
+	var sortPattern string
+	if keys, ok := options["sort"].([]string); ok {
+		sqliteSort := []string{}
+		for _, key := range keys {
+			var sqliteKey string
+			if strings.TrimPrefix(key, "-") == storage.TextScoreKey {
+				// 按相关度排序时总是由高到低
+				score, err := where.textScore()
+				if err != nil {
+					return "", nil, err
+				}
+				sqliteSort = append(sqliteSort, score+" DESC")
+				continue
+			}
+			if strings.HasPrefix(key, "-") {
+				key = key[1:]
+				sqliteKey = fmt.Sprintf(`"%s" DESC`, key)
+			} else {
+				sqliteKey = fmt.Sprintf(`"%s" ASC`, key)
+			}
+			sqliteSort = append(sqliteSort, sqliteKey)
+		}
+		if len(sqliteSort) > 0 {
+			sortPattern = fmt.Sprintf(`ORDER BY %s`, strings.Join(sqliteSort, ","))
+		}
+	}
+	if len(where.sorts) > 0 {
+		sortPattern = fmt.Sprintf(`ORDER BY %s`, strings.Join(where.sorts, ","))
+	}
+
+	columns := "*"
+	if keys, ok := options["keys"].([]string); ok {
+		sqliteKeys := []string{}
+		for _, key := range keys {
+			if key == storage.TextScoreKey {
+				score, err := where.textScore()
+				if err != nil {
+					return "", nil, err
+				}
+				sqliteKeys = append(sqliteKeys, fmt.Sprintf(`%s AS "%s"`, score, storage.TextScoreField))
+			} else if key != "" {
+				sqliteKeys = append(sqliteKeys, fmt.Sprintf(`"%s"`, key))
+			}
+		}
+		if len(sqliteKeys) > 0 {
+			columns = strings.Join(sqliteKeys, ",")
+		}
+	}
+
+	qs := fmt.Sprintf(`SELECT %s FROM "%s" %s %s %s %s`, columns, className, wherePattern, sortPattern, limitPattern, skipPattern)
+	return qs, values, nil
+}
+
+// scanObject 把当前行转换为以列名为 key 的对象
+func scanObject(rows *sql.Rows) (types.M, error) {
+	resultColumns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	resultValues := make([]interface{}, len(resultColumns))
+	values := make(types.S, len(resultColumns))
+	for i := range resultValues {
+		values[i] = &resultValues[i]
+	}
+	err = rows.Scan(values...)
+	if err != nil {
+		return nil, err
+	}
+	object := types.M{}
+	for i, field := range resultColumns {
+		object[field] = resultValues[i]
+	}
+	return object, nil
+}
+
+// Count ...
+func (s *SQLiteAdapter) Count(className string, schema, query types.M) (int, error) {
+	where, err := buildWhereClause(schema, query, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	wherePattern := ""
+	if len(where.pattern) > 0 {
+		wherePattern = `WHERE ` + where.pattern
+	}
+
+	qs := fmt.Sprintf(`SELECT count(*) FROM "%s" %s`, className, wherePattern)
+	var count int
+	err = s.conn().QueryRow(qs, where.values...).Scan(&count)
+	if err != nil {
+		if isNoSuchTable(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// UpdateObjectsByQuery ...
+func (s *SQLiteAdapter) UpdateObjectsByQuery(className string, schema, query, update types.M) error {
+	_, err := s.FindOneAndUpdate(className, schema, query, update)
+	return err
+}
+
+// FindOneAndUpdate ...
+// SQLite 不支持 UPDATE ... RETURNING ，先在事务中查出符合条件的行，更新后再读取
+func (s *SQLiteAdapter) FindOneAndUpdate(className string, schema, query, update types.M) (types.M, error) {
+	updatePatterns := []string{}
+	values := types.S{}
+	index := 1
+
+	if schema == nil {
+		schema = types.M{}
+	}
+	schema = toSQLiteSchema(schema)
+
+	fields := utils.M(schema["fields"])
+	if fields == nil {
+		fields = types.M{}
+	}
+
+	originalUpdate := utils.CopyMapM(update)
+	update = handleDotFields(update)
+
+	for fieldName, v := range update {
+		re := regexp.MustCompile(`^_auth_data_([a-zA-Z0-9_]+)$`)
+		authDataMatch := re.FindStringSubmatch(fieldName)
+		if authDataMatch != nil && len(authDataMatch) == 2 {
+			provider := authDataMatch[1]
+			delete(update, fieldName)
+			authData := utils.M(update["authData"])
+			if authData == nil {
+				authData = types.M{}
+			}
+			authData[provider] = v
+			update["authData"] = authData
+		}
+	}
+
+	for fieldName, fieldValue := range update {
+		if fieldValue == nil {
+			updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = NULL`, fieldName))
+			continue
+		}
+
+		if fieldName == "authData" {
+			lastKey := fmt.Sprintf(`"%s"`, fieldName)
+			authData := utils.M(fieldValue)
+			if authData == nil {
+				continue
+			}
+			for key, value := range authData {
+				lastKey = fmt.Sprintf(`json_object_set_key(%s, ?%d, ?%d)`, lastKey, index, index+1)
+				index = index + 2
+				if value != nil {
+					if v := utils.M(value); v != nil && utils.S(v["__op"]) == "Delete" {
+						value = nil
+					} else {
+						b, err := json.Marshal(v)
+						if err != nil {
+							return nil, err
+						}
+						value = string(b)
+					}
+				}
+				values = append(values, key, value)
+			}
+			updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = %s`, fieldName, lastKey))
+			continue
+		}
+
+		if fieldName == "updatedAt" {
+			updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+			values = append(values, fieldValue)
+			index = index + 1
+			continue
+		}
+
+		switch fieldValue.(type) {
+		case string, bool, float64, int:
+			updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+			values = append(values, fieldValue)
+			index = index + 1
+			continue
+		case time.Time:
+			updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+			values = append(values, utils.TimetoString(fieldValue.(time.Time)))
+			index = index + 1
+			continue
+		}
+
+		if object := utils.M(fieldValue); object != nil {
+			switch utils.S(object["__op"]) {
+			case "Increment":
+				updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = COALESCE("%s", 0) + ?%d`, fieldName, fieldName, index))
+				values = append(values, object["amount"])
+				index = index + 1
+				continue
+			case "Add", "AddUnique", "Remove":
+				function := map[string]string{"Add": "array_add", "AddUnique": "array_add_unique", "Remove": "array_remove"}[utils.S(object["__op"])]
+				updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = %s("%s", ?%d)`, fieldName, function, fieldName, index))
+				b, err := json.Marshal(object["objects"])
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, string(b))
+				index = index + 1
+				continue
+			case "Delete":
+				updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = NULL`, fieldName))
+				continue
+			}
+
+			switch utils.S(object["__type"]) {
+			case "Pointer":
+				updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+				values = append(values, object["objectId"])
+				index = index + 1
+				continue
+			case "Date", "File":
+				updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+				values = append(values, toSQLiteValue(object))
+				index = index + 1
+				continue
+			case "GeoPoint":
+				updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+				values = append(values, toSQLitePoint(object))
+				index = index + 1
+				continue
+			case "Polygon":
+				vertices, err := storage.ParsePolygon(object)
+				if err != nil {
+					return nil, err
+				}
+				updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+				values = append(values, toSQLitePolygon(vertices))
+				index = index + 1
+				continue
+			case "Bytes":
+				data, err := storage.ParseBytes(object)
+				if err != nil {
+					return nil, err
+				}
+				updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+				values = append(values, data)
+				index = index + 1
+				continue
+			case "Relation":
+				continue
+			}
+
+			if tp := utils.M(fields[fieldName]); tp != nil && utils.S(tp["type"]) == "Object" {
+				expression := fmt.Sprintf(`"%s"`, fieldName)
+
+				// 删除子字段
+				for k, v := range originalUpdate {
+					if o := utils.M(v); o != nil && utils.S(o["__op"]) == "Delete" {
+						if keys := strings.Split(k, "."); len(keys) == 2 && keys[0] == fieldName {
+							expression = fmt.Sprintf(`json_object_del_key(%s, ?%d)`, expression, index)
+							values = append(values, keys[1])
+							index = index + 1
+						}
+					}
+				}
+
+				// 累加子字段
+				for k, v := range originalUpdate {
+					if o := utils.M(v); o != nil && utils.S(o["__op"]) == "Increment" {
+						if keys := strings.Split(k, "."); len(keys) == 2 && keys[0] == fieldName {
+							increment := utils.M(object[keys[1]])
+							delete(object, keys[1])
+							if increment == nil {
+								continue
+							}
+							switch increment["amount"].(type) {
+							case float64, int:
+							default:
+								continue
+							}
+							expression = fmt.Sprintf(`json_object_increment(%s, ?%d, ?%d)`, expression, index, index+1)
+							values = append(values, keys[1], increment["amount"])
+							index = index + 2
+						}
+					}
+				}
+
+				b, err := json.Marshal(object)
+				if err != nil {
+					return nil, err
+				}
+				updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = json_object_merge(%s, ?%d)`, fieldName, expression, index))
+				values = append(values, string(b))
+				index = index + 1
+				continue
+			}
+		}
+
+		if array := utils.A(fieldValue); array != nil {
+			if tp := utils.M(fields[fieldName]); tp != nil && utils.S(tp["type"]) == "Array" {
+				b, err := json.Marshal(fieldValue)
+				if err != nil {
+					return nil, err
+				}
+				updatePatterns = append(updatePatterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+				values = append(values, string(b))
+				index = index + 1
+				continue
+			}
+		}
+
+		b, _ := json.Marshal(fieldValue)
+		return nil, errs.E(errs.OperationForbidden, "SQLite doesn't support update "+string(b)+" yet")
+	}
+
+	where, err := buildWhereClause(schema, query, 1)
+	if err != nil {
+		return nil, err
+	}
+	wherePattern := ""
+	if where.pattern != "" {
+		wherePattern = `WHERE ` + where.pattern
+	}
+
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+
+	rowIDs := types.S{}
+	rows, err := tx.Query(fmt.Sprintf(`SELECT rowid FROM "%s" %s`, className, wherePattern), where.values...)
+	if err != nil {
+		tx.Rollback()
+		// 表不存在返回空
+		if isNoSuchTable(err) {
+			return nil, errs.E(errs.ObjectNotFound, "Object not found.")
+		}
+		return nil, err
+	}
+	for rows.Next() {
+		var rowID int64
+		err = rows.Scan(&rowID)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, err
+		}
+		rowIDs = append(rowIDs, rowID)
+	}
+	rows.Close()
+
+	if len(rowIDs) == 0 {
+		tx.Rollback()
+		return types.M{}, nil
+	}
+
+	rowIDPatterns := []string{}
+	for i := range rowIDs {
+		rowIDPatterns = append(rowIDPatterns, fmt.Sprintf("?%d", index+i))
+	}
+	values = append(values, rowIDs...)
+
+	// TODO 需要添加限制，只更新一条，UpdateObjectsByQuery 时更新多条
+	if len(updatePatterns) > 0 {
+		qs := fmt.Sprintf(`UPDATE "%s" SET %s WHERE rowid IN (%s)`, className, strings.Join(updatePatterns, ","), strings.Join(rowIDPatterns, ","))
+		_, err = tx.Exec(qs, values...)
+		if err != nil {
+			tx.Rollback()
+			if isUniqueViolation(err) {
+				return nil, errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
+			}
+			return nil, err
+		}
+	}
+
+	rows, err = tx.Query(fmt.Sprintf(`SELECT * FROM "%s" WHERE rowid = ?1`, className), rowIDs[0])
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	object := types.M{}
+	if rows.Next() {
+		object, err = scanObject(rows)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	rows.Close()
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return sqliteObjectToParseObject(object, fields)
+}
+
+// UpsertOneObject 仅用于 config 和 hooks
+func (s *SQLiteAdapter) UpsertOneObject(className string, schema, query, update types.M) error {
+	object, err := s.FindOneAndUpdate(className, schema, query, update)
+	if err != nil {
+		return err
+	}
+	if len(object) == 0 {
+		createValue := types.M{}
+		for k, v := range query {
+			createValue[k] = v
+		}
+		for k, v := range update {
+			createValue[k] = v
+		}
+
+		err = s.CreateObject(className, schema, createValue)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureUniqueness 创建唯一索引
+func (s *SQLiteAdapter) EnsureUniqueness(className string, schema types.M, fieldNames []string) error {
+	sort.Sort(sort.StringSlice(fieldNames))
+	// SQLite 中索引名称在整个数据库中唯一，所以需要加上类名
+	indexName := className + `_unique_` + strings.Join(fieldNames, "_")
+	indexPatterns := []string{}
+	for _, fieldName := range fieldNames {
+		indexPatterns = append(indexPatterns, `"`+fieldName+`"`)
+	}
+
+	qs := fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS "%s" ON "%s" (%s)`, indexName, className, strings.Join(indexPatterns, ","))
+	_, err := s.conn().Exec(qs)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
+		}
+		return err
+	}
+	return nil
+}
+
+// CreateIndex 创建索引，并把索引定义保存到 _SCHEMA 中
+// SQLite 不支持 TTL 索引，对于 TTL 索引仅创建普通索引
+func (s *SQLiteAdapter) CreateIndex(className string, schema types.M, indexName string, index types.M) error {
+	err := s.ensureSchemaCollectionExists(nil)
+	if err != nil {
+		return err
+	}
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	if qs := buildCreateIndexQuery(className, indexName, index); qs != "" {
+		_, err = tx.Exec(qs)
+		if err != nil {
+			tx.Rollback()
+			if strings.Contains(err.Error(), "already exists") {
+				return errs.E(errs.DuplicateValue, "Index "+indexName+" already exists.")
+			}
+			return err
+		}
+	}
+	err = setIndexDefinition(tx, className, indexName, index)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DropIndex 删除索引，并从 _SCHEMA 中删除索引定义
+func (s *SQLiteAdapter) DropIndex(className string, indexName string) error {
+	err := s.ensureSchemaCollectionExists(nil)
+	if err != nil {
+		return err
+	}
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`DROP INDEX IF EXISTS "%s"`, sqliteIndexName(className, indexName)))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = setIndexDefinition(tx, className, indexName, nil)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetIndexes 获取 _SCHEMA 中保存的索引定义
+func (s *SQLiteAdapter) GetIndexes(className string) (types.M, error) {
+	schema, err := s.GetClass(className)
+	if err != nil {
+		return nil, err
+	}
+	if indexes := utils.M(schema["indexes"]); indexes != nil {
+		return indexes, nil
+	}
+	return types.M{}, nil
+}
+
+// setIndexDefinition 更新 _SCHEMA 中的索引定义， index 为空时删除该索引
+func setIndexDefinition(tx *transaction, className, indexName string, index types.M) error {
+	schema, err := loadSchema(tx, className)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return errs.E(errs.InvalidClassName, "Class "+className+" does not exist.")
+	}
+	indexes := utils.M(schema["indexes"])
+	if indexes == nil {
+		indexes = types.M{}
+	}
+	if index == nil {
+		delete(indexes, indexName)
+	} else {
+		indexes[indexName] = index
+	}
+	b, err := json.Marshal(indexes)
+	if err != nil {
+		return err
+	}
+	qs := `UPDATE "_SCHEMA" SET "schema" = json_object_set_key("schema", ?1, ?2) WHERE "className" = ?3`
+	_, err = tx.Exec(qs, "indexes", string(b), className)
+	return err
+}
+
+// sqliteIndexName SQLite 中索引名称在整个数据库中唯一，所以需要加上类名
+func sqliteIndexName(className, indexName string) string {
+	return className + "_" + indexName
+}
+
+// buildCreateIndexQuery 组装创建索引的语句，稀疏索引使用部分索引实现
+// SQLite 的普通表不支持全文索引，全文索引仅保存定义，返回空语句
+func buildCreateIndexQuery(className, indexName string, index types.M) string {
+	if storage.IndexIsText(index) {
+		return ""
+	}
+	columns := []string{}
+	conditions := []string{}
+	for _, field := range storage.IndexFields(index) {
+		if field.Descending {
+			columns = append(columns, fmt.Sprintf(`"%s" DESC`, field.Name))
+		} else {
+			columns = append(columns, fmt.Sprintf(`"%s" ASC`, field.Name))
+		}
+		conditions = append(conditions, fmt.Sprintf(`"%s" IS NOT NULL`, field.Name))
+	}
+	qs := fmt.Sprintf(`CREATE INDEX "%s" ON "%s" (%s)`, sqliteIndexName(className, indexName), className, strings.Join(columns, ", "))
+	if storage.IndexIsSparse(index) {
+		qs += " WHERE " + strings.Join(conditions, " OR ")
+	}
+	return qs
+}
+
+// PerformInitialization ...
+func (s *SQLiteAdapter) PerformInitialization(options types.M) error {
+	if options == nil {
+		options = types.M{}
+	}
+
+	if volatileClassesSchemas, ok := options["VolatileClassesSchemas"].([]types.M); ok {
+		for _, schema := range volatileClassesSchemas {
+			err := s.createTable(utils.S(schema["className"]), schema, nil)
+			if err != nil {
+				if e, ok := err.(*errs.TomatoError); ok {
+					if e.Code != errs.InvalidClassName {
+						return err
+					}
+				} else {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// HandleShutdown 关闭数据库
+func (s *SQLiteAdapter) HandleShutdown() {
+	if s.tx != nil {
+		s.tx.Rollback()
+		return
+	}
+	s.db.Close()
+}
+
+// Begin 开始事务，返回的适配器中的所有操作都在该事务中执行
+func (s *SQLiteAdapter) Begin() (storage.Transaction, error) {
+	if s.tx != nil {
+		return nil, errs.E(errs.OperationForbidden, "Transaction already started.")
+	}
+	tx, err := s.beginTx()
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteAdapter{
+		collectionPrefix: s.collectionPrefix,
+		collectionList:   []string{},
+		db:               s.db,
+		tx:               tx,
+		ctx:              s.ctx,
+	}, nil
+}
+
+// Commit 提交事务
+func (s *SQLiteAdapter) Commit() error {
+	if s.tx == nil {
+		return errs.E(errs.OperationForbidden, "Transaction not started.")
+	}
+	return s.tx.Commit()
+}
+
+// Rollback 回滚事务
+func (s *SQLiteAdapter) Rollback() error {
+	if s.tx == nil {
+		return errs.E(errs.OperationForbidden, "Transaction not started.")
+	}
+	return s.tx.Rollback()
+}
+
+func isNoSuchTable(err error) bool {
+	if e, ok := err.(sqlite3.Error); ok {
+		return e.Code == sqlite3.ErrError && strings.HasPrefix(e.Error(), "no such table")
+	}
+	return false
+}
+
+func isDuplicateColumn(err error) bool {
+	if e, ok := err.(sqlite3.Error); ok {
+		return e.Code == sqlite3.ErrError && strings.HasPrefix(e.Error(), "duplicate column name")
+	}
+	return false
+}
+
+func isUniqueViolation(err error) bool {
+	if e, ok := err.(sqlite3.Error); ok {
+		return e.ExtendedCode == sqlite3.ErrConstraintUnique || e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
+
+func sqliteObjectToParseObject(object, fields types.M) (types.M, error) {
+	if len(object) == 0 {
+		return object, nil
+	}
+	for fieldName, v := range fields {
+		tp := utils.M(v)
+		if tp == nil {
+			continue
+		}
+		objectType := utils.S(tp["type"])
+
+		if objectType == "Relation" {
+			object[fieldName] = types.M{
+				"__type":    "Relation",
+				"className": tp["targetClass"],
+			}
+			continue
+		}
+		if object[fieldName] == nil {
+			continue
+		}
+		value := toText(object[fieldName])
+
+		switch objectType {
+		case "Pointer":
+			if value != "" {
+				object[fieldName] = types.M{
+					"objectId":  value,
+					"__type":    "Pointer",
+					"className": tp["targetClass"],
+				}
+			} else {
+				object[fieldName] = nil
+			}
+		case "GeoPoint":
+			longitude, latitude, ok := parsePoint(value)
+			if ok {
+				object[fieldName] = types.M{
+					"__type":    "GeoPoint",
+					"longitude": longitude,
+					"latitude":  latitude,
+				}
+			} else {
+				object[fieldName] = nil
+			}
+		case "Polygon":
+			object[fieldName] = storage.PolygonToJSON(parsePolygon(value))
+		case "Bytes":
+			object[fieldName] = storage.BytesToJSON([]byte(value))
+		case "File":
+			object[fieldName] = types.M{
+				"__type": "File",
+				"name":   value,
+			}
+		case "String":
+			object[fieldName] = value
+		case "Date":
+			if fieldName != "createdAt" && fieldName != "updatedAt" {
+				object[fieldName] = valueToDate(value)
+			}
+		case "Number":
+			switch n := object[fieldName].(type) {
+			case int64:
+				object[fieldName] = float64(n)
+			case string:
+				f, err := strconv.ParseFloat(n, 64)
+				if err != nil {
+					return nil, err
+				}
+				object[fieldName] = f
+			}
+		case "Boolean":
+			switch b := object[fieldName].(type) {
+			case int64:
+				object[fieldName] = b > 0
+			}
+		case "Object":
+			var r types.M
+			err := json.Unmarshal([]byte(value), &r)
+			if err != nil {
+				return nil, err
+			}
+			object[fieldName] = r
+		case "Array":
+			var r types.S
+			err := json.Unmarshal([]byte(value), &r)
+			if err != nil {
+				return nil, err
+			}
+			object[fieldName] = r
+		}
+	}
+
+	for _, fieldName := range []string{"_rperm", "_wperm", "_password_history"} {
+		if v, ok := object[fieldName].(string); ok {
+			var r types.S
+			err := json.Unmarshal([]byte(v), &r)
+			if err != nil {
+				return nil, err
+			}
+			object[fieldName] = r
+		}
+	}
+
+	for _, fieldName := range []string{"createdAt", "updatedAt"} {
+		if object[fieldName] != nil {
+			object[fieldName] = toText(object[fieldName])
+		}
+	}
+
+	for _, fieldName := range []string{
+		"expiresAt",
+		"_email_verify_token_expires_at",
+		"_account_lockout_expires_at",
+		"_perishable_token_expires_at",
+		"_password_changed_at",
+	} {
+		if v, ok := object[fieldName].(string); ok {
+			object[fieldName] = valueToDate(v)
+		}
+	}
+
+	if v, ok := object["_failed_login_count"].(int64); ok {
+		object["_failed_login_count"] = float64(v)
+	}
+
+	for fieldName := range object {
+		if object[fieldName] == nil {
+			delete(object, fieldName)
+		} else if v, ok := object[fieldName].(types.M); ok && v == nil {
+			delete(object, fieldName)
+		}
+	}
+	return object, nil
+}
+
+var parseToSQLiteComparator = map[string]string{
+	"$gt":  ">",
+	"$lt":  "<",
+	"$gte": ">=",
+	"$lte": "<=",
+}
+
+func parseTypeToSQLiteType(t types.M) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	tp := utils.S(t["type"])
+	switch tp {
+	case "String":
+		return "text", nil
+	case "Date":
+		// 以 ISO8601 格式的字符串保存，可直接比较大小
+		return "text", nil
+	case "Object":
+		return "text", nil
+	case "File":
+		return "text", nil
+	case "Boolean":
+		return "boolean", nil
+	case "Pointer":
+		return "varChar(120)", nil
+	case "Number":
+		return "double precision", nil
+	case "GeoPoint":
+		return "text", nil
+	case "Polygon":
+		// 以 [[longitude,latitude],...] 格式的 JSON 保存
+		return "text", nil
+	case "Bytes":
+		return "blob", nil
+	case "Array":
+		return "text", nil
+	default:
+		return "", errs.E(errs.IncorrectType, "no type for "+tp+" yet")
+	}
+}
+
+func toSQLiteValue(value interface{}) interface{} {
+	if v := utils.M(value); v != nil {
+		if utils.S(v["__type"]) == "Date" {
+			return v["iso"]
+		}
+		if utils.S(v["__type"]) == "File" {
+			return v["name"]
+		}
+		if utils.S(v["__type"]) == "Bytes" {
+			if data, err := storage.ParseBytes(v); err == nil {
+				return data
+			}
+		}
+	}
+	return value
+}
+
+// toSQLitePoint 转换 GeoPoint 为 (longitude,latitude) 格式
+func toSQLitePoint(value interface{}) interface{} {
+	point := utils.M(value)
+	if point == nil {
+		return nil
+	}
+	return fmt.Sprintf("(%v,%v)", point["longitude"], point["latitude"])
+}
+
+// toSQLitePolygon 转换 Polygon 为 [[longitude,latitude],...] 格式
+func toSQLitePolygon(vertices []storage.Vertex) string {
+	points := types.S{}
+	for _, v := range vertices {
+		points = append(points, types.S{v[0], v[1]})
+	}
+	j, _ := json.Marshal(points)
+	return string(j)
+}
+
+func transformValue(value interface{}) interface{} {
+	if v := utils.M(value); v != nil {
+		if utils.S(v["__type"]) == "Pointer" {
+			return v["objectId"]
+		}
+	}
+	return value
+}
+
+var defaultCLPS = types.M{
+	"find":     types.M{"*": true},
+	"get":      types.M{"*": true},
+	"create":   types.M{"*": true},
+	"update":   types.M{"*": true},
+	"delete":   types.M{"*": true},
+	"addField": types.M{"*": true},
+}
+
+func toParseSchema(schema types.M) types.M {
+	if schema == nil {
+		return nil
+	}
+
+	var fields types.M
+	if fields = utils.M(schema["fields"]); fields == nil {
+		fields = types.M{}
+	}
+
+	if utils.S(schema["className"]) == "_User" {
+		delete(fields, "_hashed_password")
+	}
+
+	delete(fields, "_wperm")
+	delete(fields, "_rperm")
+
+	var clps types.M
+	clps = utils.CopyMap(defaultCLPS)
+	if classLevelPermissions := utils.M(schema["classLevelPermissions"]); classLevelPermissions != nil {
+		// 不存在的 action 默认为公共权限
+		for k, v := range classLevelPermissions {
+			clps[k] = v
+		}
+	}
+
+	result := types.M{
+		"className":             schema["className"],
+		"fields":                fields,
+		"classLevelPermissions": clps,
+	}
+	if indexes := utils.M(schema["indexes"]); len(indexes) > 0 {
+		result["indexes"] = types.M(indexes)
+	}
+	if options := utils.M(schema["options"]); len(options) > 0 {
+		result["options"] = types.M(options)
+	}
+	return result
+}
+
+func toSQLiteSchema(schema types.M) types.M {
+	if schema == nil {
+		return nil
+	}
+
+	var fields types.M
+	if fields = utils.M(schema["fields"]); fields == nil {
+		fields = types.M{}
+	}
+
+	fields["_wperm"] = types.M{
+		"type":     "Array",
+		"contents": types.M{"type": "String"},
+	}
+	fields["_rperm"] = types.M{
+		"type":     "Array",
+		"contents": types.M{"type": "String"},
+	}
+
+	if utils.S(schema["className"]) == "_User" {
+		fields["_hashed_password"] = types.M{"type": "String"}
+		fields["_password_history"] = types.M{"type": "Array"}
+	}
+
+	schema["fields"] = fields
+
+	return schema
+}
+
+func handleDotFields(object types.M) types.M {
+	for fieldName := range object {
+		if strings.Index(fieldName, ".") == -1 {
+			continue
+		}
+		components := strings.Split(fieldName, ".")
+
+		value := object[fieldName]
+		if v := utils.M(value); v != nil {
+			if utils.S(v["__op"]) == "Delete" {
+				value = nil
+			}
+		}
+
+		currentObj := object
+		for i, next := range components {
+			if i == (len(components) - 1) {
+				if value != nil {
+					currentObj[next] = value
+				}
+				break
+			}
+			obj := currentObj[next]
+			if obj == nil {
+				obj = types.M{}
+				currentObj[next] = obj
+			}
+			currentObj = utils.M(currentObj[next])
+		}
+
+		delete(object, fieldName)
+	}
+	return object
+}
+
+func validateKeys(object interface{}) error {
+	if obj := utils.M(object); obj != nil {
+		for key, value := range obj {
+			err := validateKeys(value)
+			if err != nil {
+				return err
+			}
+
+			if strings.Contains(key, "$") || strings.Contains(key, ".") {
+				return errs.E(errs.InvalidNestedKey, "Nested keys should not contain the '$' or '.' characters")
+			}
+		}
+	}
+	return nil
+}
+
+func joinTablesForSchema(schema types.M) []string {
+	list := []string{}
+	if schema != nil {
+		if fields := utils.M(schema["fields"]); fields != nil {
+			className := utils.S(schema["className"])
+			for field, v := range fields {
+				if tp := utils.M(v); tp != nil {
+					if utils.S(tp["type"]) == "Relation" {
+						list = append(list, "_Join:"+field+":"+className)
+					}
+				}
+			}
+		}
+	}
+	return list
+}
+
+type whereClause struct {
+	pattern string
+	values  types.S
+	sorts   []string
+	scores  []string // 全文检索的相关度表达式
+}
+
+// buildWhereClause 组装查询语句，参数使用 ?NNN 的形式从 index 开始编号
+func buildWhereClause(schema, query types.M, index int) (*whereClause, error) {
+	patterns := []string{}
+	values := types.S{}
+	sorts := []string{}
+	var scores []string
+
+	if schema == nil {
+		schema = types.M{}
+	}
+	schema = toSQLiteSchema(schema)
+	fields := utils.M(schema["fields"])
+	if fields == nil {
+		fields = types.M{}
+	}
+	for fieldName, fieldValue := range query {
+		isArrayField := false
+		if tp := utils.M(fields[fieldName]); tp != nil {
+			if utils.S(tp["type"]) == "Array" {
+				isArrayField = true
+			}
+		}
+		initialPatternsLength := len(patterns)
+
+		if fields[fieldName] == nil {
+			if v := utils.M(fieldValue); v != nil {
+				if b, ok := v["$exists"].(bool); ok && b == false {
+					continue
+				}
+			}
+		}
+
+		if strings.Contains(fieldName, ".") {
+			components := strings.Split(fieldName, ".")
+			b, err := json.Marshal(fieldValue)
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, fmt.Sprintf(`json_path_value("%s", ?%d) = ?%d`, components[0], index, index+1))
+			values = append(values, strings.Join(components[1:], "."), string(b))
+			index = index + 2
+		} else if isPrimitive(fieldValue) {
+			if isArrayField {
+				j, _ := json.Marshal(types.S{fieldValue})
+				patterns = append(patterns, fmt.Sprintf(`array_contains("%s", ?%d)`, fieldName, index))
+				values = append(values, string(j))
+			} else {
+				patterns = append(patterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+				values = append(values, fieldValue)
+			}
+			index = index + 1
+		} else if fieldName == "$or" || fieldName == "$and" {
+			clauses := []string{}
+			clauseValues := types.S{}
+			if array := utils.A(fieldValue); array != nil {
+				for _, v := range array {
+					if subQuery := utils.M(v); subQuery != nil {
+						clause, err := buildWhereClause(schema, subQuery, index)
+						if err != nil {
+							return nil, err
+						}
+						if len(clause.pattern) > 0 {
+							clauses = append(clauses, clause.pattern)
+							clauseValues = append(clauseValues, clause.values...)
+							index = index + len(clause.values)
+						}
+						scores = append(scores, clause.scores...)
+					}
+				}
+			}
+			var orOrAnd string
+			if fieldName == "$or" {
+				orOrAnd = " OR "
+			} else {
+				orOrAnd = " AND "
+			}
+			if len(clauses) > 0 {
+				patterns = append(patterns, fmt.Sprintf(`(%s)`, strings.Join(clauses, orOrAnd)))
+				values = append(values, clauseValues...)
+			} else {
+				patterns = append(patterns, "1")
+			}
+		}
+
+		if value := utils.M(fieldValue); value != nil {
+
+			if v, ok := value["$ne"]; ok {
+				if isArrayField {
+					j, _ := json.Marshal(types.S{v})
+					patterns = append(patterns, fmt.Sprintf(`NOT array_contains("%s", ?%d)`, fieldName, index))
+					values = append(values, string(j))
+					index = index + 1
+				} else {
+					if v == nil {
+						patterns = append(patterns, fmt.Sprintf(`"%s" IS NOT NULL`, fieldName))
+					} else {
+						patterns = append(patterns, fmt.Sprintf(`("%s" <> ?%d OR "%s" IS NULL)`, fieldName, index, fieldName))
+						values = append(values, toSQLiteValue(v))
+						index = index + 1
+					}
+				}
+			}
+
+			if v, ok := value["$eq"]; ok {
+				if v == nil {
+					patterns = append(patterns, fmt.Sprintf(`"%s" IS NULL`, fieldName))
+				} else if isArrayField {
+					j, _ := json.Marshal(types.S{v})
+					patterns = append(patterns, fmt.Sprintf(`array_contains("%s", ?%d)`, fieldName, index))
+					values = append(values, string(j))
+					index = index + 1
+				} else {
+					patterns = append(patterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+					values = append(values, toSQLiteValue(v))
+					index = index + 1
+				}
+			}
+
+			inArray := utils.A(value["$in"])
+			ninArray := utils.A(value["$nin"])
+			createConstraint := func(baseArray types.S, notIn bool) {
+				not := ""
+				if notIn {
+					not = "NOT "
+				}
+				elements := types.S{}
+				allowNull := false
+				for _, listElem := range baseArray {
+					if listElem == nil {
+						allowNull = true
+					} else {
+						elements = append(elements, listElem)
+					}
+				}
+				if len(elements) == 0 {
+					if allowNull {
+						patterns = append(patterns, fmt.Sprintf(`"%s" IS %sNULL`, fieldName, not))
+					} else if notIn == false {
+						// $in 为空数组时不匹配任何对象
+						patterns = append(patterns, "0")
+					} else {
+						patterns = append(patterns, "1")
+					}
+					return
+				}
+				var pattern string
+				if isArrayField {
+					j, _ := json.Marshal(elements)
+					pattern = fmt.Sprintf(`%sarray_contains("%s", ?%d)`, not, fieldName, index)
+					values = append(values, string(j))
+					index = index + 1
+				} else {
+					inPatterns := []string{}
+					for listIndex, listElem := range elements {
+						values = append(values, toSQLiteValue(listElem))
+						inPatterns = append(inPatterns, fmt.Sprintf("?%d", index+listIndex))
+					}
+					pattern = fmt.Sprintf(`"%s" %sIN (%s)`, fieldName, not, strings.Join(inPatterns, ","))
+					index = index + len(inPatterns)
+				}
+				if allowNull && notIn == false {
+					pattern = fmt.Sprintf(`("%s" IS NULL OR %s)`, fieldName, pattern)
+				} else if allowNull && notIn {
+					pattern = fmt.Sprintf(`("%s" IS NOT NULL AND %s)`, fieldName, pattern)
+				} else if notIn {
+					pattern = fmt.Sprintf(`("%s" IS NULL OR %s)`, fieldName, pattern)
+				}
+				patterns = append(patterns, pattern)
+			}
+			if inArray != nil {
+				createConstraint(inArray, false)
+			}
+			if ninArray != nil {
+				createConstraint(ninArray, true)
+			}
+
+			allArray := utils.A(value["$all"])
+			if allArray != nil && isArrayField {
+				patterns = append(patterns, fmt.Sprintf(`array_contains_all("%s", ?%d)`, fieldName, index))
+				j, _ := json.Marshal(allArray)
+				values = append(values, string(j))
+				index = index + 1
+			}
+
+			if b, ok := value["$exists"].(bool); ok {
+				if b {
+					patterns = append(patterns, fmt.Sprintf(`"%s" IS NOT NULL`, fieldName))
+				} else {
+					patterns = append(patterns, fmt.Sprintf(`"%s" IS NULL`, fieldName))
+				}
+			}
+
+			if point := utils.M(value["$nearSphere"]); point != nil {
+				patterns = append(patterns, fmt.Sprintf(`"%s" IS NOT NULL`, fieldName))
+				if distance, ok := value["$maxDistance"].(float64); ok {
+					distanceInM := distance * 6371 * 1000
+					patterns = append(patterns, fmt.Sprintf(`distance_sphere("%s", ?%d, ?%d) <= ?%d`, fieldName, index, index+1, index+2))
+					values = append(values, point["longitude"], point["latitude"], distanceInM)
+					sorts = append(sorts, fmt.Sprintf(`distance_sphere("%s", ?%d, ?%d) ASC`, fieldName, index, index+1))
+					index = index + 3
+				} else {
+					sorts = append(sorts, fmt.Sprintf(`distance_sphere("%s", ?%d, ?%d) ASC`, fieldName, index, index+1))
+					values = append(values, point["longitude"], point["latitude"])
+					index = index + 2
+				}
+			}
+
+			if within := utils.M(value["$within"]); within != nil {
+				if box := utils.A(within["$box"]); len(box) == 2 {
+					box1 := utils.M(box[0])
+					box2 := utils.M(box[1])
+					if box1 != nil && box2 != nil {
+						patterns = append(patterns, fmt.Sprintf(`point_in_box("%s", ?%d, ?%d, ?%d, ?%d)`, fieldName, index, index+1, index+2, index+3))
+						values = append(values, box1["longitude"], box1["latitude"], box2["longitude"], box2["latitude"])
+						index = index + 4
+					}
+				}
+			}
+
+			if geoWithin := utils.M(value["$geoWithin"]); geoWithin != nil {
+				if polygon := utils.M(geoWithin["$polygon"]); polygon != nil {
+					vertices, err := storage.ParsePolygon(polygon)
+					if err != nil {
+						return nil, err
+					}
+					patterns = append(patterns, fmt.Sprintf(`point_in_polygon("%s", ?%d)`, fieldName, index))
+					values = append(values, toSQLitePolygon(vertices))
+					index = index + 1
+				} else if polygon := utils.A(geoWithin["$polygon"]); polygon != nil {
+					points := types.S{}
+					for _, p := range polygon {
+						if point := utils.M(p); point != nil && utils.S(point["__type"]) == "GeoPoint" {
+							points = append(points, types.S{point["longitude"], point["latitude"]})
+						} else {
+							return nil, errs.E(errs.InvalidJSON, "bad $geoWithin value")
+						}
+					}
+
+					if len(points) > 0 {
+						j, _ := json.Marshal(points)
+						patterns = append(patterns, fmt.Sprintf(`point_in_polygon("%s", ?%d)`, fieldName, index))
+						values = append(values, string(j))
+						index = index + 1
+					}
+				} else {
+					return nil, errs.E(errs.InvalidJSON, "bad $geoWithin value")
+				}
+			}
+
+			if geoIntersects := utils.M(value["$geoIntersects"]); geoIntersects != nil {
+				if _, _, ok := storage.GeoPointOf(geoIntersects["$point"]); ok == false {
+					return nil, errs.E(errs.InvalidJSON, "bad $geoIntersects value; $point should be GeoPoint")
+				}
+				patterns = append(patterns, fmt.Sprintf(`point_in_polygon(?%d, "%s")`, index, fieldName))
+				values = append(values, toSQLitePoint(geoIntersects["$point"]))
+				index = index + 1
+			}
+
+			if regex, ok := value["$regex"].(string); ok {
+				opts := utils.S(value["$options"])
+				if strings.Contains(opts, "x") {
+					regex = removeWhiteSpace(regex)
+				}
+				regex = literalizeRegexPart(regex)
+				if strings.Contains(opts, "i") {
+					regex = "(?i)" + regex
+				}
+				if strings.Contains(opts, "m") {
+					regex = "(?m)" + regex
+				}
+				if _, err := regexp.Compile(regex); err != nil {
+					return nil, errs.E(errs.InvalidQuery, "Invalid regex: "+err.Error())
+				}
+
+				patterns = append(patterns, fmt.Sprintf(`"%s" REGEXP ?%d`, fieldName, index))
+				values = append(values, regex)
+				index = index + 1
+			}
+
+			// SQLite 的普通表不支持全文索引，使用自定义函数计算相关度
+			if text, ok := value["$text"]; ok {
+				search, err := storage.ParseTextSearch(text)
+				if err != nil {
+					return nil, err
+				}
+				score := fmt.Sprintf(`text_score("%s", ?%d, %d, %d)`, fieldName, index, boolToInt(search.CaseSensitive), boolToInt(search.DiacriticSensitive))
+				patterns = append(patterns, score+" > 0")
+				scores = append(scores, score)
+				values = append(values, search.Term)
+				index = index + 1
+			}
+
+			if utils.S(value["__type"]) == "Pointer" {
+				if isArrayField {
+					patterns = append(patterns, fmt.Sprintf(`array_contains("%s", ?%d)`, fieldName, index))
+					j, _ := json.Marshal(types.S{value})
+					values = append(values, string(j))
+					index = index + 1
+				} else {
+					patterns = append(patterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+					values = append(values, value["objectId"])
+					index = index + 1
+				}
+			}
+
+			if utils.S(value["__type"]) == "Date" {
+				patterns = append(patterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+				values = append(values, value["iso"])
+				index = index + 1
+			}
+
+			if utils.S(value["__type"]) == "Bytes" {
+				data, err := storage.ParseBytes(value)
+				if err != nil {
+					return nil, err
+				}
+				patterns = append(patterns, fmt.Sprintf(`"%s" = ?%d`, fieldName, index))
+				values = append(values, data)
+				index = index + 1
+			}
+
+			// 保证参数顺序稳定
+			comparators := []string{}
+			for cmp := range parseToSQLiteComparator {
+				comparators = append(comparators, cmp)
+			}
+			sort.Strings(comparators)
+			for _, cmp := range comparators {
+				if v, ok := value[cmp]; ok {
+					patterns = append(patterns, fmt.Sprintf(`"%s" %s ?%d`, fieldName, parseToSQLiteComparator[cmp], index))
+					values = append(values, toSQLiteValue(v))
+					index = index + 1
+				}
+			}
+		}
+
+		if fieldValue == nil {
+			patterns = append(patterns, fmt.Sprintf(`"%s" IS NULL`, fieldName))
+		}
+
+		if initialPatternsLength == len(patterns) {
+			s, _ := json.Marshal(fieldValue)
+			return nil, errs.E(errs.OperationForbidden, "SQLite doesn't support this query type yet "+string(s))
+		}
+	}
+	for i, v := range values {
+		values[i] = transformValue(v)
+	}
+	return &whereClause{strings.Join(patterns, " AND "), values, sorts, scores}, nil
+}
+
+// textScore 返回全文检索的相关度表达式，包含多个 $text 时相关度为各项之和
+func (w *whereClause) textScore() (string, error) {
+	if len(w.scores) == 0 {
+		return "", errs.E(errs.InvalidQuery, "$score can only be used with $text queries.")
+	}
+	return strings.Join(w.scores, " + "), nil
+}
+
+// boolToInt SQLite 中没有布尔类型，使用 1 与 0 表示
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isPrimitive(value interface{}) bool {
+	switch value.(type) {
+	case string, bool, float64, int, int64:
+		return true
+	}
+	return false
+}
+
+func removeWhiteSpace(s string) string {
+	if strings.HasSuffix(s, "\n") == false {
+		s = s + "\n"
+	}
+
+	re := regexp.MustCompile(`(?im)^#.*\n`)
+	s = re.ReplaceAllString(s, "")
+	re = regexp.MustCompile(`(?im)([^\\])#.*\n`)
+	s = re.ReplaceAllString(s, "$1")
+	re = regexp.MustCompile(`(?im)([^\\])\s+`)
+	s = re.ReplaceAllString(s, "$1")
+	re = regexp.MustCompile(`^\s+`)
+	s = re.ReplaceAllString(s, "")
+	s = strings.TrimSpace(s)
+
+	return s
+}
+
+// literalizeRegexPart 把 \Q...\E 之间的内容转换为字面量
+func literalizeRegexPart(s string) string {
+	result := ""
+	for {
+		start := strings.Index(s, `\Q`)
+		if start == -1 {
+			return result + s
+		}
+		result = result + s[:start]
+		s = s[start+2:]
+		end := strings.Index(s, `\E`)
+		if end == -1 {
+			return result + regexp.QuoteMeta(s)
+		}
+		result = result + regexp.QuoteMeta(s[:end])
+		s = s[end+2:]
+	}
+}
+
+func valueToDate(v string) types.M {
+	if v == "" {
+		return nil
+	}
+	return types.M{
+		"__type": "Date",
+		"iso":    v,
+	}
+}
@@ -0,0 +1,1192 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+func Test_parseTypeToSQLiteType(t *testing.T) {
+	tests := []struct {
+		name    string
+		t       types.M
+		want    string
+		wantErr error
+	}{
+		{name: "1", t: nil, want: "", wantErr: nil},
+		{name: "2", t: types.M{"type": "String"}, want: "text", wantErr: nil},
+		{name: "3", t: types.M{"type": "Date"}, want: "text", wantErr: nil},
+		{name: "4", t: types.M{"type": "Boolean"}, want: "boolean", wantErr: nil},
+		{name: "5", t: types.M{"type": "Number"}, want: "double precision", wantErr: nil},
+		{name: "6", t: types.M{"type": "Array", "contents": types.M{"type": "String"}}, want: "text", wantErr: nil},
+		{name: "7", t: types.M{"type": "Other"}, want: "", wantErr: errs.E(errs.IncorrectType, "no type for Other yet")},
+		{name: "8", t: types.M{"type": "Polygon"}, want: "text", wantErr: nil},
+		{name: "9", t: types.M{"type": "Bytes"}, want: "blob", wantErr: nil},
+	}
+	for _, tt := range tests {
+		got, err := parseTypeToSQLiteType(tt.t)
+		if reflect.DeepEqual(err, tt.wantErr) == false {
+			t.Errorf("%q. parseTypeToSQLiteType() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q. parseTypeToSQLiteType() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_buildWhereClause(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  types.M
+		query   types.M
+		index   int
+		want    *whereClause
+		wantErr error
+	}{
+		{
+			name:   "1",
+			schema: types.M{"fields": types.M{"name": types.M{"type": "String"}}},
+			query:  types.M{"name": "joe"},
+			index:  1,
+			want:   &whereClause{pattern: `"name" = ?1`, values: types.S{"joe"}, sorts: []string{}},
+		},
+		{
+			name:   "2",
+			schema: types.M{"fields": types.M{"tags": types.M{"type": "Array"}}},
+			query:  types.M{"tags": "a"},
+			index:  2,
+			want:   &whereClause{pattern: `array_contains("tags", ?2)`, values: types.S{`["a"]`}, sorts: []string{}},
+		},
+		{
+			name:   "3",
+			schema: types.M{"fields": types.M{"age": types.M{"type": "Number"}}},
+			query:  types.M{"age": types.M{"$gt": 10.0, "$lte": 20.0}},
+			index:  1,
+			want:   &whereClause{pattern: `"age" > ?1 AND "age" <= ?2`, values: types.S{10.0, 20.0}, sorts: []string{}},
+		},
+		{
+			name:   "4",
+			schema: types.M{"fields": types.M{"name": types.M{"type": "String"}}},
+			query:  types.M{"name": types.M{"$in": types.S{"a", "b"}}},
+			index:  1,
+			want:   &whereClause{pattern: `"name" IN (?1,?2)`, values: types.S{"a", "b"}, sorts: []string{}},
+		},
+		{
+			name:   "5",
+			schema: types.M{"fields": types.M{}},
+			query:  types.M{"_rperm": types.M{"$in": types.S{nil, "*", "123"}}},
+			index:  1,
+			want:   &whereClause{pattern: `("_rperm" IS NULL OR array_contains("_rperm", ?1))`, values: types.S{`["*","123"]`}, sorts: []string{}},
+		},
+		{
+			name:   "6",
+			schema: types.M{"fields": types.M{"post": types.M{"type": "Pointer", "targetClass": "Post"}}},
+			query:  types.M{"post": types.M{"__type": "Pointer", "className": "Post", "objectId": "1024"}},
+			index:  1,
+			want:   &whereClause{pattern: `"post" = ?1`, values: types.S{"1024"}, sorts: []string{}},
+		},
+		{
+			name:   "7",
+			schema: types.M{"fields": types.M{"name": types.M{"type": "String"}}},
+			query:  types.M{"name": types.M{"$regex": `^\Qa.b\E`, "$options": "i"}},
+			index:  1,
+			want:   &whereClause{pattern: `"name" REGEXP ?1`, values: types.S{`(?i)^a\.b`}, sorts: []string{}},
+		},
+		{
+			name:   "8",
+			schema: types.M{"fields": types.M{"location": types.M{"type": "GeoPoint"}}},
+			query:  types.M{"location": types.M{"$nearSphere": types.M{"__type": "GeoPoint", "longitude": 10.0, "latitude": 20.0}, "$maxDistance": 1.0}},
+			index:  1,
+			want: &whereClause{
+				pattern: `"location" IS NOT NULL AND distance_sphere("location", ?1, ?2) <= ?3`,
+				values:  types.S{10.0, 20.0, 6371000.0},
+				sorts:   []string{`distance_sphere("location", ?1, ?2) ASC`},
+			},
+		},
+		{
+			name:    "9",
+			schema:  types.M{"fields": types.M{"name": types.M{"type": "String"}}},
+			query:   types.M{"name": types.M{"$other": 1}},
+			index:   1,
+			want:    nil,
+			wantErr: errs.E(errs.OperationForbidden, `SQLite doesn't support this query type yet {"$other":1}`),
+		},
+		{
+			name:   "10",
+			schema: types.M{"fields": types.M{"subject": types.M{"type": "String"}}},
+			query:  types.M{"subject": types.M{"$text": types.M{"$search": types.M{"$term": "coffee", "$caseSensitive": true}}}},
+			index:  1,
+			want: &whereClause{
+				pattern: `text_score("subject", ?1, 1, 0) > 0`,
+				values:  types.S{"coffee"},
+				sorts:   []string{},
+				scores:  []string{`text_score("subject", ?1, 1, 0)`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := buildWhereClause(tt.schema, tt.query, tt.index)
+		if reflect.DeepEqual(err, tt.wantErr) == false {
+			t.Errorf("%q. buildWhereClause() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. buildWhereClause() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_literalizeRegexPart(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{name: "1", s: `abc`, want: `abc`},
+		{name: "2", s: `\Qa.b\E`, want: `a\.b`},
+		{name: "3", s: `^\Qa+\E.*\Q(c)`, want: `^a\+.*\(c\)`},
+	}
+	for _, tt := range tests {
+		if got := literalizeRegexPart(tt.s); got != tt.want {
+			t.Errorf("%q. literalizeRegexPart() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_functions(t *testing.T) {
+	if got := arrayAdd(`["a"]`, `["a","b"]`); got != `["a","a","b"]` {
+		t.Errorf("arrayAdd() = %v", got)
+	}
+	if got := arrayAddUnique(`["a"]`, `["a","b"]`); got != `["a","b"]` {
+		t.Errorf("arrayAddUnique() = %v", got)
+	}
+	if got := arrayRemove(`["a","b",{"k":1}]`, `["a",{"k":1}]`); got != `["b"]` {
+		t.Errorf("arrayRemove() = %v", got)
+	}
+	if got := arrayContains([]byte(nil), `["a"]`); got != false {
+		t.Errorf("arrayContains() = %v", got)
+	}
+	if got := arrayContainsAll(`["a","b","c"]`, `["a","c"]`); got != true {
+		t.Errorf("arrayContainsAll() = %v", got)
+	}
+	if got := jsonPathValue(`{"a":{"b":1}}`, "a.b"); got != `1` {
+		t.Errorf("jsonPathValue() = %v", got)
+	}
+	if got := jsonObjectIncrement(`{"a":1}`, "a", 2); got != `{"a":3}` {
+		t.Errorf("jsonObjectIncrement() = %v", got)
+	}
+	if got := jsonObjectMerge(`{"a":1,"b":{"c":1}}`, `{"b":{"d":2}}`); got != `{"a":1,"b":{"d":2}}` {
+		t.Errorf("jsonObjectMerge() = %v", got)
+	}
+	if got := pointInPolygon("(5,5)", `[[0,0],[10,0],[10,10],[0,10]]`); got != true {
+		t.Errorf("pointInPolygon() = %v", got)
+	}
+	if got := pointInBox("(15,5)", int64(0), int64(0), 10.0, 10.0); got != false {
+		t.Errorf("pointInBox() = %v", got)
+	}
+}
+
+func openDB() *sql.DB {
+	dir, err := ioutil.TempDir("", "tomato-sqlite")
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := sql.Open(DriverName, filepath.Join(dir, "test.db"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
+
+func closeDB(db *sql.DB) {
+	var seq int
+	var name, file string
+	db.QueryRow(`PRAGMA database_list`).Scan(&seq, &name, &file)
+	db.Close()
+	if file != "" {
+		os.RemoveAll(filepath.Dir(file))
+	}
+}
+
+func TestSQLiteAdapter_ensureSchemaCollectionExists(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	if err := s.ensureSchemaCollectionExists(nil); err != nil {
+		t.Errorf("SQLiteAdapter.ensureSchemaCollectionExists() error = %v", err)
+	}
+	if err := s.ensureSchemaCollectionExists(nil); err != nil {
+		t.Errorf("SQLiteAdapter.ensureSchemaCollectionExists() error = %v", err)
+	}
+	if s.ClassExists("_SCHEMA") == false {
+		t.Errorf("SQLiteAdapter.ensureSchemaCollectionExists() _SCHEMA is not Exists")
+	}
+}
+
+func TestSQLiteAdapter_ClassExists(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	tests := []struct {
+		name       string
+		className  string
+		want       bool
+		initialize func(string)
+		clean      func(string)
+	}{
+		{
+			name:       "1",
+			className:  "post",
+			want:       false,
+			initialize: func(name string) {},
+			clean:      func(name string) {},
+		},
+		{
+			name:      "2",
+			className: "post",
+			want:      true,
+			initialize: func(name string) {
+				db.Exec(`CREATE TABLE IF NOT EXISTS "` + name + `" ( "title" varChar(120) )`)
+			},
+			clean: func(name string) {
+				db.Exec(`DROP TABLE "` + name + `"`)
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt.initialize(tt.className)
+		if got := s.ClassExists(tt.className); got != tt.want {
+			t.Errorf("%q. SQLiteAdapter.ClassExists() = %v, want %v", tt.name, got, tt.want)
+		}
+		tt.clean(tt.className)
+	}
+}
+
+func TestSQLiteAdapter_CreateClass(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	clean := func(name string) {
+		db.Exec(`DROP TABLE "` + name + `"`)
+		db.Exec(`DROP TABLE "_SCHEMA"`)
+	}
+	defaultCLP := types.M{
+		"find":     types.M{"*": true},
+		"get":      types.M{"*": true},
+		"create":   types.M{"*": true},
+		"update":   types.M{"*": true},
+		"delete":   types.M{"*": true},
+		"addField": types.M{"*": true},
+	}
+	tests := []struct {
+		name       string
+		className  string
+		schema     types.M
+		want       types.M
+		wantErr    error
+		initialize func()
+	}{
+		{
+			name:       "1",
+			className:  "post",
+			schema:     types.M{"className": "post"},
+			want:       types.M{"className": "post", "fields": types.M{}, "classLevelPermissions": defaultCLP},
+			initialize: func() {},
+		},
+		{
+			name:      "2",
+			className: "post",
+			schema: types.M{
+				"className": "post",
+				"fields": types.M{
+					"title":    types.M{"type": "String"},
+					"objectId": types.M{"type": "String"},
+					"user":     types.M{"type": "Relation", "targetClass": "_User"},
+				},
+			},
+			want: types.M{
+				"className": "post",
+				"fields": types.M{
+					"title":    types.M{"type": "String"},
+					"objectId": types.M{"type": "String"},
+					"user":     types.M{"type": "Relation", "targetClass": "_User"},
+				},
+				"classLevelPermissions": defaultCLP,
+			},
+			initialize: func() {},
+		},
+		{
+			name:      "3",
+			className: "post",
+			schema:    types.M{"className": "post"},
+			want:      nil,
+			wantErr:   errs.E(errs.DuplicateValue, "Class post already exists."),
+			initialize: func() {
+				s.CreateClass("post", types.M{"className": "post"})
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt.initialize()
+		got, err := s.CreateClass(tt.className, tt.schema)
+		if reflect.DeepEqual(err, tt.wantErr) == false {
+			t.Errorf("%q. SQLiteAdapter.CreateClass() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. SQLiteAdapter.CreateClass() = %v, want %v", tt.name, got, tt.want)
+		}
+		clean(tt.className)
+	}
+}
+
+func TestSQLiteAdapter_SetClassLevelPermissions(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	s.CreateClass("post", nil)
+	clp := types.M{"find": types.M{"role:admin": true}}
+	if err := s.SetClassLevelPermissions("post", clp); err != nil {
+		t.Errorf("SQLiteAdapter.SetClassLevelPermissions() error = %v", err)
+	}
+	got, _ := s.GetClass("post")
+	want := types.M{
+		"find":     map[string]interface{}{"role:admin": true},
+		"get":      types.M{"*": true},
+		"create":   types.M{"*": true},
+		"update":   types.M{"*": true},
+		"delete":   types.M{"*": true},
+		"addField": types.M{"*": true},
+	}
+	if reflect.DeepEqual(got["classLevelPermissions"], want) == false {
+		t.Errorf("SQLiteAdapter.SetClassLevelPermissions() = %v, want %v", got["classLevelPermissions"], want)
+	}
+}
+
+func TestSQLiteAdapter_SetClassOptions(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	s.CreateClass("post", nil)
+	options := types.M{"softDelete": types.M{"retentionDays": 7}}
+	if err := s.SetClassOptions("post", options); err != nil {
+		t.Errorf("SQLiteAdapter.SetClassOptions() error = %v", err)
+	}
+	got, _ := s.GetClass("post")
+	want := types.M{"softDelete": map[string]interface{}{"retentionDays": float64(7)}}
+	if reflect.DeepEqual(got["options"], want) == false {
+		t.Errorf("SQLiteAdapter.SetClassOptions() = %v, want %v", got["options"], want)
+	}
+}
+
+func TestSQLiteAdapter_AddFieldIfNotExists(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	clean := func() {
+		s.DeleteAllClasses()
+	}
+	tests := []struct {
+		name       string
+		fieldName  string
+		fieldType  types.M
+		initialize func()
+		want       types.M
+	}{
+		{
+			name:       "1",
+			fieldName:  "title",
+			fieldType:  types.M{"type": "String"},
+			initialize: func() {},
+			want: types.M{
+				"title": map[string]interface{}{"type": "String"},
+			},
+		},
+		{
+			name:      "2",
+			fieldName: "title",
+			fieldType: types.M{"type": "String"},
+			initialize: func() {
+				s.CreateClass("post", types.M{"fields": types.M{"name": types.M{"type": "String"}}})
+			},
+			want: types.M{
+				"name":  map[string]interface{}{"type": "String"},
+				"title": map[string]interface{}{"type": "String"},
+			},
+		},
+		{
+			name:      "3",
+			fieldName: "title",
+			fieldType: types.M{"type": "String"},
+			initialize: func() {
+				s.CreateClass("post", types.M{"fields": types.M{"title": types.M{"type": "String"}}})
+			},
+			want: types.M{
+				"title": map[string]interface{}{"type": "String"},
+			},
+		},
+		{
+			name:      "4",
+			fieldName: "users",
+			fieldType: types.M{"type": "Relation", "targetClass": "_User"},
+			initialize: func() {
+				s.CreateClass("post", nil)
+			},
+			want: types.M{
+				"users": map[string]interface{}{"type": "Relation", "targetClass": "_User"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt.initialize()
+		if err := s.AddFieldIfNotExists("post", tt.fieldName, tt.fieldType); err != nil {
+			t.Errorf("%q. SQLiteAdapter.AddFieldIfNotExists() error = %v", tt.name, err)
+		}
+		got, _ := s.GetClass("post")
+		fields := types.M{}
+		for k, v := range utils.M(got["fields"]) {
+			if typ, ok := v.(types.M); ok {
+				v = map[string]interface{}(typ)
+			}
+			fields[k] = v
+		}
+		if reflect.DeepEqual(fields, tt.want) == false {
+			t.Errorf("%q. SQLiteAdapter.AddFieldIfNotExists() = %v, want %v", tt.name, fields, tt.want)
+		}
+		clean()
+	}
+}
+
+func TestSQLiteAdapter_DeleteClass(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	s.CreateClass("post", types.M{"fields": types.M{"title": types.M{"type": "String"}}})
+	got, err := s.DeleteClass("post")
+	if err != nil || reflect.DeepEqual(got, types.M{}) == false {
+		t.Errorf("SQLiteAdapter.DeleteClass() = %v, %v", got, err)
+	}
+	if s.ClassExists("post") {
+		t.Errorf("SQLiteAdapter.DeleteClass() post still exists")
+	}
+	schema, _ := s.GetClass("post")
+	if len(schema) != 0 {
+		t.Errorf("SQLiteAdapter.DeleteClass() schema = %v", schema)
+	}
+}
+
+func TestSQLiteAdapter_DeleteAllClasses(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	if err := s.DeleteAllClasses(); err != nil {
+		t.Errorf("SQLiteAdapter.DeleteAllClasses() error = %v", err)
+	}
+	s.CreateClass("post", types.M{"fields": types.M{"users": types.M{"type": "Relation", "targetClass": "_User"}}})
+	s.CreateClass("user", nil)
+	if err := s.DeleteAllClasses(); err != nil {
+		t.Errorf("SQLiteAdapter.DeleteAllClasses() error = %v", err)
+	}
+	for _, name := range []string{"post", "user", "_Join:users:post", "_SCHEMA"} {
+		if s.ClassExists(name) {
+			t.Errorf("SQLiteAdapter.DeleteAllClasses() %s still exists", name)
+		}
+	}
+}
+
+func TestSQLiteAdapter_DeleteFields(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{
+		"fields": types.M{
+			"objectId": types.M{"type": "String"},
+			"title":    types.M{"type": "String"},
+			"content":  types.M{"type": "String"},
+			"users":    types.M{"type": "Relation", "targetClass": "_User"},
+		},
+	}
+	s.CreateClass("post", schema)
+	s.EnsureUniqueness("post", schema, []string{"title"})
+	s.EnsureUniqueness("post", schema, []string{"content"})
+	s.CreateObject("post", schema, types.M{"objectId": "01", "title": "hello", "content": "world"})
+
+	sch, _ := s.GetClass("post")
+	err := s.DeleteFields("post", sch, []string{"content", "users"})
+	if err != nil {
+		t.Errorf("SQLiteAdapter.DeleteFields() error = %v", err)
+	}
+	sch, _ = s.GetClass("post")
+	if _, ok := utils.M(sch["fields"])["content"]; ok {
+		t.Errorf("SQLiteAdapter.DeleteFields() content still in schema")
+	}
+	results, _ := s.Find("post", sch, types.M{}, types.M{})
+	want := []types.M{{"objectId": "01", "title": "hello"}}
+	if reflect.DeepEqual(results, want) == false {
+		t.Errorf("SQLiteAdapter.DeleteFields() = %v, want %v", results, want)
+	}
+	// 唯一索引需要保留
+	err = s.CreateObject("post", sch, types.M{"objectId": "02", "title": "hello"})
+	if reflect.DeepEqual(err, errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")) == false {
+		t.Errorf("SQLiteAdapter.DeleteFields() unique index lost, error = %v", err)
+	}
+}
+
+func TestSQLiteAdapter_GetAllClasses(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	got, err := s.GetAllClasses()
+	if err != nil || len(got) != 0 {
+		t.Errorf("SQLiteAdapter.GetAllClasses() = %v, %v", got, err)
+	}
+	s.CreateClass("post", nil)
+	s.CreateClass("user", nil)
+	got, err = s.GetAllClasses()
+	if err != nil || len(got) != 2 {
+		t.Errorf("SQLiteAdapter.GetAllClasses() = %v, %v", got, err)
+	}
+}
+
+var typeClassSchema = types.M{
+	"className": "TypeClass",
+	"fields": types.M{
+		"objectId":       types.M{"type": "String"},
+		"createdAt":      types.M{"type": "Date"},
+		"updatedAt":      types.M{"type": "Date"},
+		"StringKey":      types.M{"type": "String"},
+		"DateKey":        types.M{"type": "Date"},
+		"ObjectKey":      types.M{"type": "Object"},
+		"FileKey":        types.M{"type": "File"},
+		"BooleanKey":     types.M{"type": "Boolean"},
+		"PointerKey":     types.M{"type": "Pointer", "targetClass": "Post"},
+		"NumberKey":      types.M{"type": "Number"},
+		"GeoPointKey":    types.M{"type": "GeoPoint"},
+		"ArrayKey":       types.M{"type": "Array"},
+		"StringArrayKey": types.M{"type": "Array", "contents": types.M{"type": "String"}},
+		"RelationKey":    types.M{"type": "Relation", "targetClass": "Post"},
+		"_rperm":         types.M{"type": "Array"},
+		"_wperm":         types.M{"type": "Array"},
+	},
+}
+
+func TestSQLiteAdapter_CreateObject(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	s.CreateClass("TypeClass", typeClassSchema)
+	object := types.M{
+		"objectId":       "1001",
+		"createdAt":      "2006-01-02T15:04:05.000Z",
+		"updatedAt":      "2006-01-02T15:04:05.000Z",
+		"StringKey":      "hello",
+		"DateKey":        types.M{"__type": "Date", "iso": "2006-01-02T15:04:05.000Z"},
+		"ObjectKey":      types.M{"key": "value"},
+		"FileKey":        types.M{"__type": "File", "name": "a.png"},
+		"BooleanKey":     true,
+		"PointerKey":     types.M{"__type": "Pointer", "className": "Post", "objectId": "2001"},
+		"NumberKey":      10,
+		"GeoPointKey":    types.M{"__type": "GeoPoint", "longitude": 20.0, "latitude": 30.5},
+		"ArrayKey":       types.S{1, "a"},
+		"StringArrayKey": types.S{"a", "b"},
+		"_rperm":         types.S{"*"},
+		"_wperm":         types.S{"1001"},
+	}
+	err := s.CreateObject("TypeClass", typeClassSchema, object)
+	if err != nil {
+		t.Errorf("SQLiteAdapter.CreateObject() error = %v", err)
+	}
+	results, err := s.Find("TypeClass", typeClassSchema, types.M{}, types.M{})
+	want := []types.M{
+		{
+			"objectId":       "1001",
+			"createdAt":      "2006-01-02T15:04:05.000Z",
+			"updatedAt":      "2006-01-02T15:04:05.000Z",
+			"StringKey":      "hello",
+			"DateKey":        types.M{"__type": "Date", "iso": "2006-01-02T15:04:05.000Z"},
+			"ObjectKey":      types.M{"key": "value"},
+			"FileKey":        types.M{"__type": "File", "name": "a.png"},
+			"BooleanKey":     true,
+			"PointerKey":     types.M{"__type": "Pointer", "className": "Post", "objectId": "2001"},
+			"NumberKey":      10.0,
+			"GeoPointKey":    types.M{"__type": "GeoPoint", "longitude": 20.0, "latitude": 30.5},
+			"ArrayKey":       types.S{1.0, "a"},
+			"StringArrayKey": types.S{"a", "b"},
+			"RelationKey":    types.M{"__type": "Relation", "className": "Post"},
+			"_rperm":         types.S{"*"},
+			"_wperm":         types.S{"1001"},
+		},
+	}
+	if err != nil || reflect.DeepEqual(results, want) == false {
+		t.Errorf("SQLiteAdapter.CreateObject() = %v, want %v, error %v", results, want, err)
+	}
+
+	err = s.CreateObject("TypeClass", typeClassSchema, types.M{"objectId": "1001"})
+	if reflect.DeepEqual(err, errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")) == false {
+		t.Errorf("SQLiteAdapter.CreateObject() error = %v", err)
+	}
+}
+
+func TestSQLiteAdapter_CreateObjects(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{
+		"fields": types.M{
+			"objectId": types.M{"type": "String"},
+			"name":     types.M{"type": "String"},
+			"age":      types.M{"type": "Number"},
+		},
+	}
+	s.CreateClass("user", schema)
+	objects := []types.M{
+		{"objectId": "01", "name": "joe", "age": 10},
+		{"objectId": "02", "name": "jack"},
+	}
+	err := s.CreateObjects("user", schema, objects)
+	if err != nil {
+		t.Errorf("SQLiteAdapter.CreateObjects() error = %v", err)
+	}
+	results, err := s.Find("user", schema, types.M{}, types.M{"sort": []string{"objectId"}})
+	want := []types.M{
+		{"objectId": "01", "name": "joe", "age": 10.0},
+		{"objectId": "02", "name": "jack"},
+	}
+	if err != nil || reflect.DeepEqual(results, want) == false {
+		t.Errorf("SQLiteAdapter.CreateObjects() = %v, want %v, error %v", results, want, err)
+	}
+
+	// 任意一个对象插入失败时，所有对象都不插入
+	err = s.CreateObjects("user", schema, []types.M{{"objectId": "03", "name": "tom"}, {"objectId": "01"}})
+	if reflect.DeepEqual(err, errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")) == false {
+		t.Errorf("SQLiteAdapter.CreateObjects() error = %v", err)
+	}
+	count, err := s.Count("user", schema, types.M{})
+	if err != nil || count != 2 {
+		t.Errorf("SQLiteAdapter.Count() = %v, want %v, error %v", count, 2, err)
+	}
+}
+
+func TestSQLiteAdapter_Find(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{
+		"fields": types.M{
+			"objectId": types.M{"type": "String"},
+			"name":     types.M{"type": "String"},
+			"age":      types.M{"type": "Number"},
+			"tags":     types.M{"type": "Array"},
+			"info":     types.M{"type": "Object"},
+			"location": types.M{"type": "GeoPoint"},
+			"area":     types.M{"type": "Polygon"},
+			"data":     types.M{"type": "Bytes"},
+			"_rperm":   types.M{"type": "Array"},
+		},
+	}
+	area := types.M{"__type": "Polygon", "coordinates": types.S{types.S{0.0, 0.0}, types.S{0.0, 10.0}, types.S{10.0, 10.0}, types.S{10.0, 0.0}}}
+	s.CreateClass("user", schema)
+	s.CreateObject("user", schema, types.M{"objectId": "01", "name": "joe", "age": 10, "tags": types.S{"a", "b"}, "info": types.M{"city": "bj"}, "location": types.M{"longitude": 0.0, "latitude": 0.0}, "area": area, "data": types.M{"__type": "Bytes", "base64": "aGVsbG8="}, "_rperm": types.S{"*"}})
+	s.CreateObject("user", schema, types.M{"objectId": "02", "name": "jack", "age": 20, "tags": types.S{"b", "c"}, "info": types.M{"city": "sh"}, "location": types.M{"longitude": 1.0, "latitude": 1.0}, "_rperm": types.S{"02"}})
+	s.CreateObject("user", schema, types.M{"objectId": "03", "name": "Tom", "age": 30, "location": types.M{"longitude": 50.0, "latitude": 50.0}})
+
+	ids := func(results []types.M) []string {
+		list := []string{}
+		for _, r := range results {
+			list = append(list, r["objectId"].(string))
+		}
+		return list
+	}
+
+	tests := []struct {
+		name    string
+		query   types.M
+		options types.M
+		want    []string
+	}{
+		{name: "1", query: types.M{}, options: types.M{"sort": []string{"age"}}, want: []string{"01", "02", "03"}},
+		{name: "2", query: types.M{"name": "joe"}, options: types.M{}, want: []string{"01"}},
+		{name: "3", query: types.M{"age": types.M{"$gte": 20}}, options: types.M{"sort": []string{"-age"}}, want: []string{"03", "02"}},
+		{name: "4", query: types.M{"$or": types.S{types.M{"name": "joe"}, types.M{"age": 30}}}, options: types.M{"sort": []string{"age"}}, want: []string{"01", "03"}},
+		{name: "5", query: types.M{"name": types.M{"$in": types.S{"joe", "jack"}}}, options: types.M{"sort": []string{"age"}}, want: []string{"01", "02"}},
+		{name: "6", query: types.M{"name": types.M{"$nin": types.S{"joe", "jack"}}}, options: types.M{}, want: []string{"03"}},
+		{name: "7", query: types.M{"tags": types.M{"$all": types.S{"b", "c"}}}, options: types.M{}, want: []string{"02"}},
+		{name: "8", query: types.M{"tags": "a"}, options: types.M{}, want: []string{"01"}},
+		{name: "9", query: types.M{"tags": types.M{"$exists": false}}, options: types.M{}, want: []string{"03"}},
+		{name: "10", query: types.M{"name": types.M{"$regex": "^j", "$options": "i"}}, options: types.M{"sort": []string{"age"}}, want: []string{"01", "02"}},
+		{name: "11", query: types.M{"name": types.M{"$regex": "^t", "$options": "i"}}, options: types.M{}, want: []string{"03"}},
+		{name: "12", query: types.M{"info.city": "sh"}, options: types.M{}, want: []string{"02"}},
+		{name: "13", query: types.M{"_rperm": types.M{"$in": types.S{nil, "*"}}}, options: types.M{"sort": []string{"age"}}, want: []string{"01", "03"}},
+		{name: "14", query: types.M{}, options: types.M{"sort": []string{"age"}, "skip": 1, "limit": 1}, want: []string{"02"}},
+		{name: "15", query: types.M{}, options: types.M{"sort": []string{"age"}, "skip": 1}, want: []string{"02", "03"}},
+		{name: "16", query: types.M{"location": types.M{"$nearSphere": types.M{"longitude": 1.1, "latitude": 1.1}, "$maxDistance": 0.1}}, options: types.M{}, want: []string{"02", "01"}},
+		{name: "17", query: types.M{"location": types.M{"$within": types.M{"$box": types.S{types.M{"longitude": -1.0, "latitude": -1.0}, types.M{"longitude": 0.5, "latitude": 0.5}}}}}, options: types.M{}, want: []string{"01"}},
+		{name: "18", query: types.M{"location": types.M{"$geoWithin": types.M{"$polygon": types.S{
+			types.M{"__type": "GeoPoint", "longitude": 40.0, "latitude": 40.0},
+			types.M{"__type": "GeoPoint", "longitude": 60.0, "latitude": 40.0},
+			types.M{"__type": "GeoPoint", "longitude": 60.0, "latitude": 60.0},
+			types.M{"__type": "GeoPoint", "longitude": 40.0, "latitude": 60.0},
+		}}}}, options: types.M{}, want: []string{"03"}},
+		{name: "19", query: types.M{"name": types.M{"$ne": "joe"}, "age": types.M{"$lt": 30}}, options: types.M{}, want: []string{"02"}},
+		{name: "20", query: types.M{"location": types.M{"$geoWithin": types.M{"$polygon": types.M{
+			"__type": "Polygon", "coordinates": types.S{types.S{0.5, 0.5}, types.S{0.5, 2.0}, types.S{2.0, 2.0}, types.S{2.0, 0.5}},
+		}}}}, options: types.M{}, want: []string{"02"}},
+		{name: "21", query: types.M{"area": types.M{"$geoIntersects": types.M{"$point": types.M{"__type": "GeoPoint", "longitude": 5.0, "latitude": 5.0}}}}, options: types.M{}, want: []string{"01"}},
+		{name: "22", query: types.M{"area": types.M{"$geoIntersects": types.M{"$point": types.M{"__type": "GeoPoint", "longitude": 15.0, "latitude": 5.0}}}}, options: types.M{}, want: []string{}},
+		{name: "23", query: types.M{"data": types.M{"__type": "Bytes", "base64": "aGVsbG8="}}, options: types.M{}, want: []string{"01"}},
+		{name: "24", query: types.M{"data": types.M{"$in": types.S{types.M{"__type": "Bytes", "base64": "aGVsbG8="}}}}, options: types.M{}, want: []string{"01"}},
+		{name: "25", query: types.M{"data": types.M{"__type": "Bytes", "base64": "d29ybGQ="}}, options: types.M{}, want: []string{}},
+	}
+	for _, tt := range tests {
+		results, err := s.Find("user", schema, tt.query, tt.options)
+		if err != nil {
+			t.Errorf("%q. SQLiteAdapter.Find() error = %v", tt.name, err)
+			continue
+		}
+		if got := ids(results); reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%q. SQLiteAdapter.Find() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	results, err := s.Find("user", schema, types.M{"objectId": "01"}, types.M{"keys": []string{"objectId", "name"}})
+	if err != nil || reflect.DeepEqual(results, []types.M{{"objectId": "01", "name": "joe"}}) == false {
+		t.Errorf("SQLiteAdapter.Find() keys = %v, %v", results, err)
+	}
+
+	results, err = s.Find("user", schema, types.M{"objectId": "01"}, types.M{"keys": []string{"area"}})
+	expect := types.M{"__type": "Polygon", "coordinates": types.S{types.S{0.0, 0.0}, types.S{0.0, 10.0}, types.S{10.0, 10.0}, types.S{10.0, 0.0}, types.S{0.0, 0.0}}}
+	if err != nil || len(results) != 1 || reflect.DeepEqual(results[0]["area"], expect) == false {
+		t.Errorf("SQLiteAdapter.Find() polygon = %v, %v", results, err)
+	}
+
+	results, err = s.Find("user", schema, types.M{"objectId": "01"}, types.M{"keys": []string{"data"}})
+	if err != nil || len(results) != 1 || reflect.DeepEqual(results[0]["data"], types.M{"__type": "Bytes", "base64": "aGVsbG8="}) == false {
+		t.Errorf("SQLiteAdapter.Find() bytes = %v, %v", results, err)
+	}
+
+	results, err = s.Find("other", schema, types.M{}, types.M{})
+	if err != nil || len(results) != 0 {
+		t.Errorf("SQLiteAdapter.Find() not exists = %v, %v", results, err)
+	}
+}
+
+func TestSQLiteAdapter_Explain(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{
+		"fields": types.M{
+			"objectId": types.M{"type": "String"},
+			"name":     types.M{"type": "String"},
+		},
+	}
+	s.CreateClass("user", schema)
+	s.CreateObject("user", schema, types.M{"objectId": "01", "name": "joe"})
+
+	result, err := s.Explain("user", schema, types.M{"name": "joe"}, types.M{"limit": 10})
+	if err != nil {
+		t.Fatalf("SQLiteAdapter.Explain() error = %v", err)
+	}
+	if result["sql"] != `SELECT * FROM "user" WHERE "name" = ?1  LIMIT ?2 ` || reflect.DeepEqual(result["values"], types.S{"joe", 10}) == false {
+		t.Errorf("SQLiteAdapter.Explain() = %v", result)
+	}
+	if plan := utils.A(result["plan"]); len(plan) == 0 || utils.S(utils.M(plan[0])["detail"]) == "" {
+		t.Errorf("SQLiteAdapter.Explain() plan = %v", result["plan"])
+	}
+}
+
+func TestSQLiteAdapter_FindText(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{
+		"fields": types.M{
+			"objectId": types.M{"type": "String"},
+			"subject":  types.M{"type": "String"},
+		},
+	}
+	s.CreateClass("post", schema)
+	s.CreateObject("post", schema, types.M{"objectId": "01", "subject": "coffee and cake"})
+	s.CreateObject("post", schema, types.M{"objectId": "02", "subject": "Café Coffee"})
+	s.CreateObject("post", schema, types.M{"objectId": "03", "subject": "green tea"})
+
+	text := func(search types.M) types.M {
+		return types.M{"subject": types.M{"$text": types.M{"$search": search}}}
+	}
+	results, err := s.Find("post", schema, text(types.M{"$term": "coffee"}), types.M{"sort": []string{storage.TextScoreKey}, "keys": []string{"objectId", storage.TextScoreKey}})
+	expect := []types.M{{"objectId": "02", "score": 0.5}, {"objectId": "01", "score": 1.0 / 3}}
+	if err != nil || reflect.DeepEqual(results, expect) == false {
+		t.Errorf("SQLiteAdapter.Find() = %v, %v", results, err)
+	}
+	results, err = s.Find("post", schema, text(types.M{"$term": "cafe", "$diacriticSensitive": true}), types.M{})
+	if err != nil || len(results) != 0 {
+		t.Errorf("SQLiteAdapter.Find() = %v, %v", results, err)
+	}
+	results, err = s.Find("post", schema, text(types.M{"$term": "cafe"}), types.M{})
+	if err != nil || len(results) != 1 || results[0]["objectId"] != "02" {
+		t.Errorf("SQLiteAdapter.Find() = %v, %v", results, err)
+	}
+	results, err = s.Find("post", schema, text(types.M{"$term": "Coffee", "$caseSensitive": true}), types.M{})
+	if err != nil || len(results) != 1 || results[0]["objectId"] != "02" {
+		t.Errorf("SQLiteAdapter.Find() = %v, %v", results, err)
+	}
+	_, err = s.Find("post", schema, types.M{}, types.M{"sort": []string{storage.TextScoreKey}})
+	if reflect.DeepEqual(err, errs.E(errs.InvalidQuery, "$score can only be used with $text queries.")) == false {
+		t.Errorf("SQLiteAdapter.Find() error = %v", err)
+	}
+}
+
+func TestSQLiteAdapter_Count(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{"fields": types.M{"objectId": types.M{"type": "String"}, "age": types.M{"type": "Number"}}}
+	s.CreateClass("user", schema)
+	s.CreateObject("user", schema, types.M{"objectId": "01", "age": 10})
+	s.CreateObject("user", schema, types.M{"objectId": "02", "age": 20})
+
+	if got, err := s.Count("user", schema, types.M{"age": types.M{"$gt": 10}}); err != nil || got != 1 {
+		t.Errorf("SQLiteAdapter.Count() = %v, %v", got, err)
+	}
+	if got, err := s.Count("user", schema, types.M{}); err != nil || got != 2 {
+		t.Errorf("SQLiteAdapter.Count() = %v, %v", got, err)
+	}
+	if got, err := s.Count("other", schema, types.M{}); err != nil || got != 0 {
+		t.Errorf("SQLiteAdapter.Count() = %v, %v", got, err)
+	}
+}
+
+func TestSQLiteAdapter_DeleteObjectsByQuery(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{"fields": types.M{"objectId": types.M{"type": "String"}, "age": types.M{"type": "Number"}}}
+	notFound := errs.E(errs.ObjectNotFound, "Object not found.")
+
+	if err := s.DeleteObjectsByQuery("user", schema, types.M{}); reflect.DeepEqual(err, notFound) == false {
+		t.Errorf("SQLiteAdapter.DeleteObjectsByQuery() error = %v", err)
+	}
+	s.CreateClass("user", schema)
+	s.CreateObject("user", schema, types.M{"objectId": "01", "age": 10})
+	s.CreateObject("user", schema, types.M{"objectId": "02", "age": 20})
+	if err := s.DeleteObjectsByQuery("user", schema, types.M{"age": 30}); reflect.DeepEqual(err, notFound) == false {
+		t.Errorf("SQLiteAdapter.DeleteObjectsByQuery() error = %v", err)
+	}
+	if err := s.DeleteObjectsByQuery("user", schema, types.M{"age": 10}); err != nil {
+		t.Errorf("SQLiteAdapter.DeleteObjectsByQuery() error = %v", err)
+	}
+	if got, _ := s.Count("user", schema, types.M{}); got != 1 {
+		t.Errorf("SQLiteAdapter.DeleteObjectsByQuery() count = %v", got)
+	}
+	if err := s.DeleteObjectsByQuery("user", schema, types.M{}); err != nil {
+		t.Errorf("SQLiteAdapter.DeleteObjectsByQuery() error = %v", err)
+	}
+}
+
+func TestSQLiteAdapter_FindOneAndUpdate(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{
+		"fields": types.M{
+			"objectId":  types.M{"type": "String"},
+			"updatedAt": types.M{"type": "Date"},
+			"name":      types.M{"type": "String"},
+			"age":       types.M{"type": "Number"},
+			"tags":      types.M{"type": "Array"},
+			"info":      types.M{"type": "Object"},
+			"post":      types.M{"type": "Pointer", "targetClass": "Post"},
+			"authData":  types.M{"type": "Object"},
+		},
+	}
+	s.CreateClass("user", schema)
+	s.CreateObject("user", schema, types.M{
+		"objectId": "01",
+		"name":     "joe",
+		"age":      10,
+		"tags":     types.S{"a"},
+		"info":     types.M{"city": "bj", "count": 1, "other": "x"},
+	})
+
+	update := types.M{
+		"updatedAt":           "2006-01-02T15:04:05.000Z",
+		"name":                "jack",
+		"age":                 types.M{"__op": "Increment", "amount": 5},
+		"tags":                types.M{"__op": "AddUnique", "objects": types.S{"a", "b"}},
+		"info.count":          types.M{"__op": "Increment", "amount": 2},
+		"info.other":          types.M{"__op": "Delete"},
+		"info.street":         "st",
+		"post":                types.M{"__type": "Pointer", "className": "Post", "objectId": "1024"},
+		"_auth_data_facebook": types.M{"id": "fb"},
+	}
+	got, err := s.FindOneAndUpdate("user", schema, types.M{"name": "joe"}, update)
+	want := types.M{
+		"objectId":  "01",
+		"updatedAt": "2006-01-02T15:04:05.000Z",
+		"name":      "jack",
+		"age":       15.0,
+		"tags":      types.S{"a", "b"},
+		"info":      types.M{"city": "bj", "count": 3.0, "street": "st"},
+		"post":      types.M{"__type": "Pointer", "className": "Post", "objectId": "1024"},
+		"authData":  types.M{"facebook": map[string]interface{}{"id": "fb"}},
+	}
+	if err != nil || reflect.DeepEqual(got, want) == false {
+		t.Errorf("SQLiteAdapter.FindOneAndUpdate() = %v, want %v, error %v", got, want, err)
+	}
+
+	got, err = s.FindOneAndUpdate("user", schema, types.M{"name": "joe"}, types.M{"name": "tom"})
+	if err != nil || len(got) != 0 {
+		t.Errorf("SQLiteAdapter.FindOneAndUpdate() = %v, error %v", got, err)
+	}
+
+	got, err = s.FindOneAndUpdate("user", schema, types.M{"name": "jack"}, types.M{"tags": types.M{"__op": "Remove", "objects": types.S{"a"}}, "age": types.M{"__op": "Delete"}})
+	want = types.M{
+		"objectId":  "01",
+		"updatedAt": "2006-01-02T15:04:05.000Z",
+		"name":      "jack",
+		"tags":      types.S{"b"},
+		"info":      types.M{"city": "bj", "count": 3.0, "street": "st"},
+		"post":      types.M{"__type": "Pointer", "className": "Post", "objectId": "1024"},
+		"authData":  types.M{"facebook": map[string]interface{}{"id": "fb"}},
+	}
+	if err != nil || reflect.DeepEqual(got, want) == false {
+		t.Errorf("SQLiteAdapter.FindOneAndUpdate() = %v, want %v, error %v", got, want, err)
+	}
+
+	_, err = s.FindOneAndUpdate("other", schema, types.M{}, types.M{"name": "tom"})
+	if reflect.DeepEqual(err, errs.E(errs.ObjectNotFound, "Object not found.")) == false {
+		t.Errorf("SQLiteAdapter.FindOneAndUpdate() error = %v", err)
+	}
+}
+
+func TestSQLiteAdapter_UpsertOneObject(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{"fields": types.M{"objectId": types.M{"type": "String"}, "params": types.M{"type": "Object"}}}
+	s.CreateClass("_GlobalConfig", schema)
+
+	err := s.UpsertOneObject("_GlobalConfig", schema, types.M{"objectId": "1"}, types.M{"params": types.M{"k": "v1"}})
+	if err != nil {
+		t.Errorf("SQLiteAdapter.UpsertOneObject() error = %v", err)
+	}
+	err = s.UpsertOneObject("_GlobalConfig", schema, types.M{"objectId": "1"}, types.M{"params": types.M{"k": "v2"}})
+	if err != nil {
+		t.Errorf("SQLiteAdapter.UpsertOneObject() error = %v", err)
+	}
+	results, _ := s.Find("_GlobalConfig", schema, types.M{}, types.M{})
+	want := []types.M{{"objectId": "1", "params": types.M{"k": "v2"}}}
+	if reflect.DeepEqual(results, want) == false {
+		t.Errorf("SQLiteAdapter.UpsertOneObject() = %v, want %v", results, want)
+	}
+}
+
+func TestSQLiteAdapter_EnsureUniqueness(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{"fields": types.M{"objectId": types.M{"type": "String"}, "name": types.M{"type": "String"}}}
+	duplicate := errs.E(errs.DuplicateValue, "A duplicate value for a field with unique values was provided")
+	s.CreateClass("user", schema)
+	s.CreateObject("user", schema, types.M{"objectId": "01", "name": "joe"})
+	s.CreateObject("user", schema, types.M{"objectId": "02", "name": "joe"})
+
+	if err := s.EnsureUniqueness("user", schema, []string{"name"}); reflect.DeepEqual(err, duplicate) == false {
+		t.Errorf("SQLiteAdapter.EnsureUniqueness() error = %v", err)
+	}
+	s.DeleteObjectsByQuery("user", schema, types.M{"objectId": "02"})
+	if err := s.EnsureUniqueness("user", schema, []string{"name"}); err != nil {
+		t.Errorf("SQLiteAdapter.EnsureUniqueness() error = %v", err)
+	}
+	if err := s.EnsureUniqueness("user", schema, []string{"name"}); err != nil {
+		t.Errorf("SQLiteAdapter.EnsureUniqueness() error = %v", err)
+	}
+	if err := s.CreateObject("user", schema, types.M{"objectId": "03", "name": "joe"}); reflect.DeepEqual(err, duplicate) == false {
+		t.Errorf("SQLiteAdapter.EnsureUniqueness() error = %v", err)
+	}
+}
+
+func TestSQLiteAdapter_PerformInitialization(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	options := types.M{
+		"VolatileClassesSchemas": []types.M{
+			{"className": "_PushStatus", "fields": types.M{"objectId": types.M{"type": "String"}}},
+			{"className": "_Hooks", "fields": types.M{"functionName": types.M{"type": "String"}}},
+		},
+	}
+	if err := s.PerformInitialization(options); err != nil {
+		t.Errorf("SQLiteAdapter.PerformInitialization() error = %v", err)
+	}
+	if s.ClassExists("_PushStatus") == false || s.ClassExists("_Hooks") == false {
+		t.Errorf("SQLiteAdapter.PerformInitialization() tables not created")
+	}
+}
+
+func TestSQLiteAdapter_Transaction(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{"fields": types.M{"objectId": types.M{"type": "String"}, "age": types.M{"type": "Number"}}}
+	s.CreateClass("user", schema)
+	s.CreateObject("user", schema, types.M{"objectId": "01", "age": 10})
+
+	tx, err := s.Begin()
+	if err != nil {
+		t.Fatalf("SQLiteAdapter.Begin() error = %v", err)
+	}
+	if _, err := tx.Begin(); err == nil {
+		t.Errorf("SQLiteAdapter.Begin() in transaction error = %v", err)
+	}
+	tx.CreateObject("user", schema, types.M{"objectId": "02", "age": 20})
+	tx.UpdateObjectsByQuery("user", schema, types.M{"objectId": "01"}, types.M{"age": 11})
+	tx.CreateClass("post", types.M{"fields": types.M{"objectId": types.M{"type": "String"}}})
+	if got, _ := tx.Count("user", schema, types.M{}); got != 2 {
+		t.Errorf("SQLiteAdapter.Transaction count = %v", got)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Errorf("SQLiteAdapter.Rollback() error = %v", err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Errorf("SQLiteAdapter.Commit() after Rollback error = %v", err)
+	}
+	if got, _ := s.Count("user", schema, types.M{"age": 10}); got != 1 {
+		t.Errorf("SQLiteAdapter.Rollback() count = %v", got)
+	}
+	if got, _ := s.Count("user", schema, types.M{}); got != 1 {
+		t.Errorf("SQLiteAdapter.Rollback() count = %v", got)
+	}
+	if s.ClassExists("post") {
+		t.Errorf("SQLiteAdapter.Rollback() post is Exists")
+	}
+
+	tx, _ = s.Begin()
+	tx.CreateObject("user", schema, types.M{"objectId": "02", "age": 20})
+	tx.DeleteObjectsByQuery("user", schema, types.M{"objectId": "01"})
+	if err := tx.Commit(); err != nil {
+		t.Errorf("SQLiteAdapter.Commit() error = %v", err)
+	}
+	results, _ := s.Find("user", schema, types.M{}, types.M{})
+	want := []types.M{types.M{"objectId": "02", "age": 20.0}}
+	if reflect.DeepEqual(results, want) == false {
+		t.Errorf("SQLiteAdapter.Commit() = %v, want %v", results, want)
+	}
+}
+
+func Test_buildCreateIndexQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		index types.M
+		want  string
+	}{
+		{name: "1", index: types.M{"keys": types.S{"name"}}, want: `CREATE INDEX "user_idx" ON "user" ("name" ASC)`},
+		{name: "2", index: types.M{"keys": types.S{"name", "-age"}}, want: `CREATE INDEX "user_idx" ON "user" ("name" ASC, "age" DESC)`},
+		{name: "3", index: types.M{"keys": types.S{"name", "age"}, "sparse": true}, want: `CREATE INDEX "user_idx" ON "user" ("name" ASC, "age" ASC) WHERE "name" IS NOT NULL OR "age" IS NOT NULL`},
+		{name: "4", index: types.M{"keys": types.S{"expireAt"}, "expireAfterSeconds": 60}, want: `CREATE INDEX "user_idx" ON "user" ("expireAt" ASC)`},
+		{name: "5", index: types.M{"keys": types.S{"title"}, "text": true}, want: ``},
+	}
+	for _, tt := range tests {
+		if got := buildCreateIndexQuery("user", "idx", tt.index); got != tt.want {
+			t.Errorf("%q. buildCreateIndexQuery() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSQLiteAdapter_CreateIndex(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{
+		"fields": types.M{
+			"objectId": types.M{"type": "String"},
+			"name":     types.M{"type": "String"},
+			"age":      types.M{"type": "Number"},
+		},
+		"indexes": types.M{
+			"name_age": types.M{"keys": types.S{"name", "-age"}},
+		},
+	}
+	if _, err := s.CreateClass("user", schema); err != nil {
+		t.Fatalf("SQLiteAdapter.CreateClass() error = %v", err)
+	}
+
+	index := types.M{"keys": types.S{"age"}, "sparse": true}
+	if err := s.CreateIndex("user", schema, "age", index); err != nil {
+		t.Errorf("SQLiteAdapter.CreateIndex() error = %v", err)
+	}
+	if err := s.CreateIndex("user", schema, "age", index); errs.GetErrorCode(err) != errs.DuplicateValue {
+		t.Errorf("SQLiteAdapter.CreateIndex() error = %v", err)
+	}
+	if err := s.CreateIndex("other", schema, "age", types.M{"keys": types.S{"age"}, "text": true}); errs.GetErrorCode(err) != errs.InvalidClassName {
+		t.Errorf("SQLiteAdapter.CreateIndex() error = %v", err)
+	}
+
+	var count int
+	db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name IN ('user_name_age', 'user_age')`).Scan(&count)
+	if count != 2 {
+		t.Errorf("SQLiteAdapter.CreateIndex() index count = %v", count)
+	}
+	got, err := s.GetIndexes("user")
+	want := types.M{
+		"name_age": map[string]interface{}{"keys": []interface{}{"name", "-age"}},
+		"age":      map[string]interface{}{"keys": []interface{}{"age"}, "sparse": true},
+	}
+	if err != nil || reflect.DeepEqual(got, want) == false {
+		t.Errorf("SQLiteAdapter.GetIndexes() = %v, want %v, error %v", got, want, err)
+	}
+	class, _ := s.GetClass("user")
+	if reflect.DeepEqual(class["indexes"], want) == false {
+		t.Errorf("SQLiteAdapter.GetClass() indexes = %v, want %v", class["indexes"], want)
+	}
+
+	if err := s.DropIndex("user", "name_age"); err != nil {
+		t.Errorf("SQLiteAdapter.DropIndex() error = %v", err)
+	}
+	db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name = 'user_name_age'`).Scan(&count)
+	if count != 0 {
+		t.Errorf("SQLiteAdapter.DropIndex() index count = %v", count)
+	}
+	got, _ = s.GetIndexes("user")
+	delete(want, "name_age")
+	if reflect.DeepEqual(got, want) == false {
+		t.Errorf("SQLiteAdapter.DropIndex() = %v, want %v", got, want)
+	}
+}
+
+func TestSQLiteAdapter_WithContext(t *testing.T) {
+	db := openDB()
+	defer closeDB(db)
+	s := NewSQLiteAdapter("", db)
+	schema := types.M{"fields": types.M{"objectId": types.M{"type": "String"}, "age": types.M{"type": "Number"}}}
+	s.CreateClass("user", schema)
+	s.CreateObject("user", schema, types.M{"objectId": "01", "age": 10})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := s.WithContext(ctx)
+	if got, err := c.Count("user", schema, types.M{}); err != nil || got != 1 {
+		t.Errorf("SQLiteAdapter.WithContext() Count = %v, error = %v", got, err)
+	}
+	cancel()
+	if _, err := c.Find("user", schema, types.M{}, types.M{}); err != context.Canceled {
+		t.Errorf("SQLiteAdapter.WithContext() Find error = %v", err)
+	}
+	if err := c.CreateObject("user", schema, types.M{"objectId": "02", "age": 20}); err != context.Canceled {
+		t.Errorf("SQLiteAdapter.WithContext() CreateObject error = %v", err)
+	}
+	if _, err := c.Begin(); err != context.Canceled {
+		t.Errorf("SQLiteAdapter.WithContext() Begin error = %v", err)
+	}
+	if got, err := s.Count("user", schema, types.M{}); err != nil || got != 1 {
+		t.Errorf("SQLiteAdapter.Count() = %v, error = %v", got, err)
+	}
+}
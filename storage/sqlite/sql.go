@@ -0,0 +1,324 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lfq7413/tomato/storage"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+	"github.com/mattn/go-sqlite3"
+)
+
+// DriverName 注册了自定义函数的 SQLite 驱动名称
+const DriverName = "sqlite3_tomato"
+
+func init() {
+	sql.Register(DriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: registerFunctions,
+	})
+}
+
+// registerFunctions 在每个新建立的连接上注册适配器需要用到的自定义函数
+// SQLite 不包含 Postgres 中的 jsonb 与 point 等类型，相关操作均由以下函数完成
+func registerFunctions(conn *sqlite3.SQLiteConn) error {
+	functions := map[string]interface{}{
+		"regexp":                regexpMatch,
+		"text_score":            textScore,
+		"json_path_value":       jsonPathValue,
+		"json_object_set_key":   jsonObjectSetKey,
+		"json_object_del_key":   jsonObjectDeleteKey,
+		"json_object_increment": jsonObjectIncrement,
+		"json_object_merge":     jsonObjectMerge,
+		"json_build_object":     jsonBuildObject,
+		"array_add":             arrayAdd,
+		"array_add_unique":      arrayAddUnique,
+		"array_remove":          arrayRemove,
+		"array_contains":        arrayContains,
+		"array_contains_all":    arrayContainsAll,
+		"distance_sphere":       distanceSphere,
+		"point_in_box":          pointInBox,
+		"point_in_polygon":      pointInPolygon,
+	}
+	for name, impl := range functions {
+		if err := conn.RegisterFunc(name, impl, true); err != nil {
+			return err
+		}
+	}
+	_, err := conn.Exec("PRAGMA busy_timeout = 5000", nil)
+	return err
+}
+
+// toText 把 SQLite 传入的参数转换为字符串，NULL 转换为空字符串
+func toText(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+func parseJSONArray(v interface{}) types.S {
+	var array types.S
+	if s := toText(v); s != "" {
+		json.Unmarshal([]byte(s), &array)
+	}
+	if array == nil {
+		array = types.S{}
+	}
+	return array
+}
+
+func parseJSONObject(v interface{}) map[string]interface{} {
+	var object map[string]interface{}
+	if s := toText(v); s != "" {
+		json.Unmarshal([]byte(s), &object)
+	}
+	if object == nil {
+		object = map[string]interface{}{}
+	}
+	return object
+}
+
+// toFloat 把 SQLite 传入的数字参数转换为 float64
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+func marshal(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// regexpMatch 实现 REGEXP 操作符，参数顺序为 (pattern, value)
+func regexpMatch(pattern string, value interface{}) (bool, error) {
+	if b, ok := value.([]byte); ok && b == nil {
+		return false, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(toText(value)), nil
+}
+
+// textScore 计算全文检索的相关度，不匹配时返回 0 ，计算方法参见 storage.TextScore
+func textScore(value interface{}, term string, caseSensitive, diacriticSensitive bool) float64 {
+	search := &storage.TextSearch{
+		Term:               term,
+		CaseSensitive:      caseSensitive,
+		DiacriticSensitive: diacriticSensitive,
+	}
+	return storage.TextScore(toText(value), search)
+}
+
+// jsonPathValue 获取 json 对象中 path 对应的值，path 以 . 分隔，返回值为 json 格式
+func jsonPathValue(doc interface{}, path string) string {
+	var current interface{} = parseJSONObject(doc)
+	for _, key := range strings.Split(path, ".") {
+		object := utils.M(current)
+		if object == nil {
+			return ""
+		}
+		current = object[key]
+	}
+	return marshal(current)
+}
+
+// jsonObjectSetKey 设置 json 对象中 key 对应的值，value 为 json 格式
+func jsonObjectSetKey(doc interface{}, key string, value interface{}) string {
+	object := parseJSONObject(doc)
+	var v interface{}
+	if s := toText(value); s != "" {
+		json.Unmarshal([]byte(s), &v)
+	}
+	object[key] = v
+	return marshal(object)
+}
+
+// jsonObjectDeleteKey 删除 json 对象中的 key
+func jsonObjectDeleteKey(doc interface{}, key string) string {
+	object := parseJSONObject(doc)
+	delete(object, key)
+	return marshal(object)
+}
+
+// jsonObjectIncrement 对 json 对象中 key 对应的数字进行累加
+func jsonObjectIncrement(doc interface{}, key string, amount interface{}) string {
+	object := parseJSONObject(doc)
+	if v, ok := object[key].(float64); ok {
+		object[key] = v + toFloat(amount)
+	} else {
+		object[key] = toFloat(amount)
+	}
+	return marshal(object)
+}
+
+// jsonObjectMerge 合并 json 对象的第一层字段，与 Postgres 中的 || 操作一致
+func jsonObjectMerge(doc, patch interface{}) string {
+	object := parseJSONObject(doc)
+	for k, v := range parseJSONObject(patch) {
+		object[k] = v
+	}
+	return marshal(object)
+}
+
+// jsonBuildObject 由键值对组成对象，参数依次为 key1, value1, key2, value2 ...
+func jsonBuildObject(args ...interface{}) string {
+	object := map[string]interface{}{}
+	for i := 0; i+1 < len(args); i += 2 {
+		value := args[i+1]
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		object[toText(args[i])] = value
+	}
+	return marshal(object)
+}
+
+// arrayAdd 向数组中添加元素
+func arrayAdd(array, values interface{}) string {
+	return marshal(append(parseJSONArray(array), parseJSONArray(values)...))
+}
+
+// arrayAddUnique 向数组中添加不存在的元素
+func arrayAddUnique(array, values interface{}) string {
+	result := parseJSONArray(array)
+	for _, v := range parseJSONArray(values) {
+		if indexOf(result, v) == -1 {
+			result = append(result, v)
+		}
+	}
+	return marshal(result)
+}
+
+// arrayRemove 从数组中删除指定的元素
+func arrayRemove(array, values interface{}) string {
+	remove := parseJSONArray(values)
+	result := types.S{}
+	for _, v := range parseJSONArray(array) {
+		if indexOf(remove, v) == -1 {
+			result = append(result, v)
+		}
+	}
+	return marshal(result)
+}
+
+// arrayContains 数组中是否包含 values 中的任意一个元素
+func arrayContains(array, values interface{}) bool {
+	source := parseJSONArray(array)
+	for _, v := range parseJSONArray(values) {
+		if indexOf(source, v) != -1 {
+			return true
+		}
+	}
+	return false
+}
+
+// arrayContainsAll 数组中是否包含 values 中的全部元素
+func arrayContainsAll(array, values interface{}) bool {
+	source := parseJSONArray(array)
+	for _, v := range parseJSONArray(values) {
+		if indexOf(source, v) == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+func indexOf(array types.S, value interface{}) int {
+	target := marshal(value)
+	for i, v := range array {
+		if marshal(v) == target {
+			return i
+		}
+	}
+	return -1
+}
+
+// parsePoint 解析 (longitude,latitude) 格式的坐标
+func parsePoint(v interface{}) (float64, float64, bool) {
+	s := toText(v)
+	if len(s) < 5 {
+		return 0, 0, false
+	}
+	parts := strings.Split(s[1:len(s)-1], ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return longitude, latitude, true
+}
+
+// distanceSphere 计算两点间的球面距离，单位为米
+func distanceSphere(point, lngValue, latValue interface{}) float64 {
+	lng, lat, ok := parsePoint(point)
+	if ok == false {
+		return math.MaxFloat64
+	}
+	longitude, latitude := toFloat(lngValue), toFloat(latValue)
+	toRad := math.Pi / 180
+	dLat := (latitude - lat) * toRad
+	dLng := (longitude - lng) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat*toRad)*math.Cos(latitude*toRad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * 6371 * 1000 * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// pointInBox 点是否在矩形范围内
+func pointInBox(point, left, bottom, right, top interface{}) bool {
+	lng, lat, ok := parsePoint(point)
+	if ok == false {
+		return false
+	}
+	return lng >= toFloat(left) && lng <= toFloat(right) && lat >= toFloat(bottom) && lat <= toFloat(top)
+}
+
+// pointInPolygon 点是否在多边形内，在边上时也认为包含， polygon 格式为 [[longitude,latitude],...]
+func pointInPolygon(point, polygon interface{}) bool {
+	lng, lat, ok := parsePoint(point)
+	if ok == false {
+		return false
+	}
+	return storage.PolygonContains(parsePolygon(polygon), lng, lat)
+}
+
+// parsePolygon 解析 [[longitude,latitude],...] 格式的多边形顶点
+func parsePolygon(v interface{}) []storage.Vertex {
+	vertices := []storage.Vertex{}
+	for _, v := range parseJSONArray(v) {
+		if p := utils.A(v); len(p) == 2 {
+			x, _ := p[0].(float64)
+			y, _ := p[1].(float64)
+			vertices = append(vertices, storage.Vertex{x, y})
+		}
+	}
+	return vertices
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/lfq7413/tomato/errs"
+	"github.com/lfq7413/tomato/types"
+	"github.com/lfq7413/tomato/utils"
+)
+
+// 全文检索的查询条件格式如下：
+// {
+// 	"subject": {
+// 		"$text": {
+// 			"$search": {
+// 				"$term": "coffee shop",
+// 				"$language": "english",
+// 				"$caseSensitive": false,
+// 				"$diacriticSensitive": false
+// 			}
+// 		}
+// 	}
+// }
+// $term 为必填项，其他参数均为可选
+// 在 sort 中使用 $score 表示按相关度由高到低排序，在 keys 中使用 $score 表示在结果的 score 字段中返回相关度
+
+// TextScoreKey 在 sort 与 keys 中表示全文检索的相关度
+const TextScoreKey = "$score"
+
+// TextScoreField 查询结果中保存相关度的字段
+const TextScoreField = "score"
+
+var textLanguageRegex = regexp.MustCompile(`^[a-z]+$`)
+
+// TextSearch 全文检索条件
+type TextSearch struct {
+	Term               string
+	Language           string
+	CaseSensitive      bool
+	DiacriticSensitive bool
+}
+
+// ParseTextSearch 解析并校验 $text 的参数
+func ParseTextSearch(text interface{}) (*TextSearch, error) {
+	object := utils.M(text)
+	if object == nil {
+		return nil, errs.E(errs.InvalidJSON, "bad $text: use an object value")
+	}
+	for key := range object {
+		if key != "$search" {
+			return nil, errs.E(errs.InvalidJSON, "bad $text: unknown key "+key)
+		}
+	}
+	search := utils.M(object["$search"])
+	if search == nil {
+		return nil, errs.E(errs.InvalidJSON, "bad $text: $search, should be object")
+	}
+
+	result := &TextSearch{}
+	for key, v := range search {
+		switch key {
+		case "$term":
+			term, ok := v.(string)
+			if ok == false || strings.TrimSpace(term) == "" {
+				return nil, errs.E(errs.InvalidJSON, "bad $text: $term, should be string")
+			}
+			result.Term = term
+		case "$language":
+			language, ok := v.(string)
+			if ok == false || language == "" {
+				return nil, errs.E(errs.InvalidJSON, "bad $text: $language, should be string")
+			}
+			if textLanguageRegex.MatchString(language) == false {
+				return nil, errs.E(errs.InvalidJSON, "bad $text: $language, invalid language "+language)
+			}
+			result.Language = language
+		case "$caseSensitive":
+			b, ok := v.(bool)
+			if ok == false {
+				return nil, errs.E(errs.InvalidJSON, "bad $text: $caseSensitive, should be boolean")
+			}
+			result.CaseSensitive = b
+		case "$diacriticSensitive":
+			b, ok := v.(bool)
+			if ok == false {
+				return nil, errs.E(errs.InvalidJSON, "bad $text: $diacriticSensitive, should be boolean")
+			}
+			result.DiacriticSensitive = b
+		default:
+			return nil, errs.E(errs.InvalidJSON, "bad $text: unknown key "+key)
+		}
+	}
+	if result.Term == "" {
+		return nil, errs.E(errs.InvalidJSON, "bad $text: $term, should be string")
+	}
+	return result, nil
+}
+
+// HasTextSearch 查询条件中是否包含 $text ，包括 $and $or 中的子查询
+func HasTextSearch(query types.M) bool {
+	for key, v := range query {
+		if key == "$and" || key == "$or" {
+			for _, subQuery := range utils.A(v) {
+				if HasTextSearch(utils.M(subQuery)) {
+					return true
+				}
+			}
+			continue
+		}
+		if constraint := utils.M(v); constraint != nil && constraint["$text"] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// TextScore 计算 text 与检索词的相关度，不匹配时返回 0 ，用于不支持全文索引的数据库
+// 检索词按单词拆分，文本中包含任意一个单词即为匹配，相关度为匹配的单词数与文本单词总数之比
+// 不进行词干提取，忽略 Language
+func TextScore(text string, search *TextSearch) float64 {
+	terms := map[string]bool{}
+	for _, term := range textWords(search.Term, search) {
+		terms[term] = true
+	}
+	words := textWords(text, search)
+	if len(terms) == 0 || len(words) == 0 {
+		return 0
+	}
+	matches := 0
+	for _, word := range words {
+		if terms[word] {
+			matches++
+		}
+	}
+	return float64(matches) / float64(len(words))
+}
+
+// textWords 把文本拆分为单词，并按照检索条件处理大小写与变音符号
+func textWords(s string, search *TextSearch) []string {
+	if search.CaseSensitive == false {
+		s = strings.ToLower(s)
+	}
+	if search.DiacriticSensitive == false {
+		s = strings.Map(func(r rune) rune {
+			if base, ok := diacritics[r]; ok {
+				return base
+			}
+			return r
+		}, s)
+	}
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return unicode.IsLetter(r) == false && unicode.IsNumber(r) == false
+	})
+}
+
+// diacritics 带变音符号的拉丁字母与基本字母的对应关系
+var diacritics = func() map[rune]rune {
+	groups := map[rune]string{
+		'a': "àáâãäåāăą", 'A': "ÀÁÂÃÄÅĀĂĄ",
+		'c': "çćĉċč", 'C': "ÇĆĈĊČ",
+		'd': "ďđ", 'D': "ĎĐ",
+		'e': "èéêëēĕėęě", 'E': "ÈÉÊËĒĔĖĘĚ",
+		'g': "ĝğġģ", 'G': "ĜĞĠĢ",
+		'i': "ìíîïĩīĭįı", 'I': "ÌÍÎÏĨĪĬĮİ",
+		'l': "ĺļľŀł", 'L': "ĹĻĽĿŁ",
+		'n': "ñńņň", 'N': "ÑŃŅŇ",
+		'o': "òóôõöøōŏő", 'O': "ÒÓÔÕÖØŌŎŐ",
+		'r': "ŕŗř", 'R': "ŔŖŘ",
+		's': "śŝşš", 'S': "ŚŜŞŠ",
+		't': "ţťŧ", 'T': "ŢŤŦ",
+		'u': "ùúûüũūŭůűų", 'U': "ÙÚÛÜŨŪŬŮŰŲ",
+		'y': "ýÿŷ", 'Y': "ÝŸŶ",
+		'z': "źżž", 'Z': "ŹŻŽ",
+	}
+	result := map[rune]rune{}
+	for base, letters := range groups {
+		for _, r := range letters {
+			result[r] = base
+		}
+	}
+	return result
+}()
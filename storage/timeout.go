@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/lfq7413/tomato/errs"
+)
+
+// 单次查询的最大执行时间保存在 ctx 中，由适配器在 Find 与 Count 中读取，
+// Mongo 中对应 maxTimeMS ，Postgres 中对应 statement_timeout ，其他适配器忽略该设置
+
+type queryTimeoutKey struct{}
+
+// ErrQueryTimeout 查询执行时间超过限制时返回的错误
+var ErrQueryTimeout = errs.E(errs.Timeout, "Query timed out.")
+
+// WithQueryTimeout 返回设置了单次查询最大执行时间的 ctx ，单位为毫秒， ms 不大于 0 时表示不限制
+func WithQueryTimeout(ctx context.Context, ms int) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ms < 0 {
+		ms = 0
+	}
+	return context.WithValue(ctx, queryTimeoutKey{}, ms)
+}
+
+// QueryTimeout 返回 ctx 中设置的单次查询最大执行时间，单位为毫秒，未设置时返回 0
+func QueryTimeout(ctx context.Context) int {
+	if ctx == nil {
+		return 0
+	}
+	ms, _ := ctx.Value(queryTimeoutKey{}).(int)
+	return ms
+}
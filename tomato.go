@@ -1,7 +1,10 @@
 package tomato
 
 import (
+	stdcontext "context"
+	"log"
 	"strings"
+	"time"
 
 	"github.com/lfq7413/tomato/config"
 	_ "github.com/lfq7413/tomato/routers"
@@ -11,7 +14,10 @@ import (
 	"github.com/astaxie/beego/plugins/cors"
 	"github.com/lfq7413/tomato/controllers"
 	"github.com/lfq7413/tomato/livequery"
+	"github.com/lfq7413/tomato/migration"
 	"github.com/lfq7413/tomato/orm"
+	"github.com/lfq7413/tomato/ratelimit"
+	"github.com/lfq7413/tomato/rest"
 )
 
 // Run ...
@@ -22,6 +28,28 @@ func Run() {
 	// 创建必要的索引
 	orm.TomatoDBController.PerformInitialization()
 
+	// 执行数据库迁移
+	if config.TConfig.MigrationsDir != "" {
+		if err := migration.LoadDir(config.TConfig.MigrationsDir); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if config.TConfig.AutoMigrate {
+		if err := migration.Run(); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// 定期删除已过期的对象
+	if config.TConfig.ExpireSweepInterval > 0 {
+		go rest.RunExpirySweeper(stdcontext.Background(), time.Duration(config.TConfig.ExpireSweepInterval)*time.Second)
+	}
+
+	// 通过数据库变更发送 LiveQuery 通知
+	if config.TConfig.LiveQueryChangeFeed {
+		go livequery.TLiveQuery.RunChangeFeed(stdcontext.Background(), orm.TomatoDBController)
+	}
+
 	if beego.BConfig.RunMode == "dev" {
 		beego.BConfig.WebConfig.DirectoryIndex = true
 		beego.BConfig.WebConfig.StaticDir["/swagger"] = "swagger"
@@ -31,6 +59,7 @@ func Run() {
 
 	allowMethodOverride()
 	allowCrossDomain()
+	enableRateLimit()
 
 	beego.Run()
 }
@@ -77,6 +106,14 @@ func allowCrossDomain() {
 	})
 }
 
+// enableRateLimit 在路由之前按 RateLimits 中的规则限流
+func enableRateLimit() {
+	if config.TConfig.RateLimits == "" {
+		return
+	}
+	beego.InsertFilter("/v1/*", beego.BeforeRouter, ratelimit.Filter)
+}
+
 func allowMethodOverride() {
 	beego.InsertFilter("*", beego.BeforeRouter, func(ctx *context.Context) {
 		if ctx.Input.Method() != "POST" {